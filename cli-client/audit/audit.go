@@ -0,0 +1,113 @@
+// Package audit records security-relevant events on the client: peer key
+// changes, decryption failures, unsigned messages, server certificate
+// changes and pin mismatches.
+//
+// Events are always kept in memory for /audit. Writing them to disk is
+// opt-in ("audit_log": true in config.json); the file is JSON lines,
+// created with mode 0600 next to config.json.
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Kind names a type of security event.
+type Kind string
+
+const (
+	KeyChange      Kind = "key_change"         // a peer's identity key differs from the one seen before
+	DecryptFailure Kind = "decrypt_failure"    // an encrypted message could not be opened
+	Unsigned       Kind = "unsigned_message"   // a message arrived without a valid signature
+	ServerCert     Kind = "server_cert_change" // the relay presented a different TLS certificate
+	PinMismatch    Kind = "pin_mismatch"       // the relay's certificate matches none of the pins; not connected
+)
+
+// Event is one audit record.
+type Event struct {
+	Time   time.Time `json:"time"`
+	Kind   Kind      `json:"kind"`
+	Peer   string    `json:"peer,omitempty"` // username or server host
+	Detail string    `json:"detail"`
+}
+
+// maxRecent caps how many events are kept in memory.
+const maxRecent = 50
+
+// Log collects events and, if opened with a path, appends them to a file.
+// It is safe for concurrent use.
+type Log struct {
+	mu     sync.Mutex
+	f      *os.File // nil = memory only
+	recent []Event  // most recent last, capped at maxRecent
+}
+
+// NewLog returns a memory-only log.
+func NewLog() *Log {
+	return &Log{}
+}
+
+// OpenFile starts appending events to path as well.
+func (l *Log) OpenFile(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f != nil {
+		l.f.Close()
+	}
+	l.f = f
+	return nil
+}
+
+// Path returns the file events are written to, or "" for memory only.
+func (l *Log) Path() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return ""
+	}
+	return l.f.Name()
+}
+
+// Record stores e, stamping it with the current time if it has none.
+// Write errors are ignored — auditing must never break the chat.
+func (l *Log) Record(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recent = append(l.recent, e)
+	if len(l.recent) > maxRecent {
+		l.recent = l.recent[len(l.recent)-maxRecent:]
+	}
+	if l.f != nil {
+		if line, err := json.Marshal(e); err == nil {
+			l.f.Write(append(line, '\n'))
+		}
+	}
+}
+
+// Recent returns a copy of the events kept in memory, oldest first.
+func (l *Log) Recent() []Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Event(nil), l.recent...)
+}
+
+// Close stops writing to the file, if any.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
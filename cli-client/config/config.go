@@ -0,0 +1,311 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"cli-client/models"
+)
+
+// HighlightRule is one user-defined highlight as written in config.json.
+//
+//	{"pattern": "(?i)\\bERR-\\d+\\b", "color": "red", "notify": true}
+//
+// Color accepts anything models.ParseColorToTag understands ("red", "#f80",
+// "[black:yellow]"). Notify rings the terminal bell when the rule fires.
+type HighlightRule struct {
+	Pattern string `json:"pattern"`
+	Color   string `json:"color"`
+	Notify  bool   `json:"notify"`
+}
+
+// Config is the on-disk client configuration. A missing file is not an
+// error — every field has a usable zero value.
+type Config struct {
+	// ServerURL overrides the built-in relay address. A .onion host turns
+	// on hidden-service mode.
+	ServerURL string `json:"server_url"`
+	// TorProxy is the SOCKS proxy for .onion relays, default
+	// socks5://127.0.0.1:9050.
+	TorProxy string `json:"tor_proxy"`
+	// Proxy carries relay traffic through a SOCKS5 or HTTP(S) proxy, e.g.
+	// socks5://127.0.0.1:9050. The --proxy flag overrides it; empty falls
+	// back to HTTPS_PROXY / HTTP_PROXY.
+	Proxy string `json:"proxy"`
+	// Prefix is the line layout template, e.g. "HH:MM │ %-12user │ msg".
+	// Empty keeps the built-in "[HH:MM] [user] msg" look.
+	Prefix     string          `json:"prefix"`
+	Highlights []HighlightRule `json:"highlights"`
+	// Privacy starts the client in privacy mode: messages are encrypted and
+	// padded to fixed sizes and decoy messages are sent (see /privacy).
+	Privacy bool `json:"privacy"`
+	// E2ERooms lists rooms that require end-to-end encryption: messages
+	// sent there are always encrypted, never plain text (see /encrypt).
+	E2ERooms []string `json:"e2e_rooms"`
+	// Passphrase is the room passphrase the message key is derived from.
+	// Set, it is not asked for at login; everyone in the room must use the
+	// same one. Empty = ask, and an empty answer keeps the built-in key.
+	Passphrase string `json:"passphrase"`
+	// PassphraseSalt is the salt the key is derived with. Everyone in the
+	// room must use the same one; empty takes the relay's (see /api/motd),
+	// and without that a built-in one.
+	PassphraseSalt string `json:"passphrase_salt"`
+	// AuditLog writes security events to audit.log next to config.json.
+	AuditLog bool `json:"audit_log"`
+	// PinSHA256 pins the relay's TLS certificate: SHA-256 hashes (hex or
+	// Base64) of the leaf certificate or of a public key in its chain.
+	PinSHA256 []string `json:"pin_sha256"`
+	// UpdateCheck asks ReleasesURL once a day whether a newer client is
+	// out. Off by default: the request tells that host you run TTC.
+	UpdateCheck bool `json:"update_check"`
+	// ReleasesURL answers with the newest release as JSON with "tag_name"
+	// and "html_url" (GitHub's format); empty = the project's GitHub.
+	ReleasesURL string `json:"releases_url"`
+	// AdminToken is the relay's admin token (its -admin-token). Set, the
+	// moderation commands /kick, /ban, /unban, /purge and /announce work.
+	AdminToken string `json:"admin_token"`
+	// ObserverToken and ObserverSigningKey are the "token" and
+	// "signing_key" of an observer token from the relay's admin API. Set,
+	// --monitor watches that token's room with them; empty, it asks for one
+	// with AdminToken.
+	ObserverToken      string `json:"observer_token"`
+	ObserverSigningKey string `json:"observer_signing_key"`
+	// SyncDrafts saves the unsent text in the input field on the relay so
+	// another device can pick it up (see /drafts). Off by default.
+	SyncDrafts bool `json:"sync_drafts"`
+	// DisableMouse leaves the mouse to the terminal, for its own text
+	// selection, instead of scrolling and clicking in the chat.
+	DisableMouse bool `json:"disable_mouse"`
+	// Theme is the chat screen's color scheme: dark (the default), light,
+	// solarized or monochrome. /theme switches it for the session.
+	Theme string `json:"theme"`
+	// Snippets are named message templates, expanded into the input field
+	// with /snip <name> or ";name" and Tab, e.g.
+	// {"standup": "Standup {date}: done {} · next {} · blocked on {}"}.
+	Snippets map[string]string `json:"snippets"`
+	// StatusSocket serves the client's status as JSON on this unix socket
+	// for status bar widgets; a relative path is inside DataDir. Empty =
+	// off.
+	StatusSocket string `json:"status_socket"`
+	// Ignore lists users whose messages are not shown. /ignore and
+	// /unignore edit it.
+	Ignore []string `json:"ignore"`
+	// TerminalNotify is how mentions and direct messages raise a desktop
+	// notification through the terminal: "osc9" (the default), "osc777"
+	// or "off" for just the bell.
+	TerminalNotify string `json:"terminal_notify"`
+	// ImagePreview is how an image fetched with /get is previewed: "auto"
+	// (the default: the terminal's graphics protocol if it has one),
+	// "kitty", "sixel", "blocks" for colored half blocks, or "off".
+	ImagePreview string `json:"image_preview"`
+	// DisableEmoji leaves :shortcodes: as typed, without the completion
+	// popup.
+	DisableEmoji bool `json:"disable_emoji"`
+	// DisableFormatting shows *bold*, _italic_ and `code` in messages as
+	// typed instead of formatting them.
+	DisableFormatting bool `json:"disable_formatting"`
+}
+
+// AuditPath returns the location of the security audit log.
+func AuditPath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "audit.log"), nil
+}
+
+// UpdateStatePath returns where the last update check is remembered.
+func UpdateStatePath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "update.json"), nil
+}
+
+// SessionStatePath returns where the poll cursor, client ID and room are
+// kept between runs.
+func SessionStatePath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "session.json"), nil
+}
+
+// TourStatePath returns where the client remembers that the onboarding
+// tour was taken.
+func TourStatePath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tour.json"), nil
+}
+
+// IdentityPath returns the location of the client's identity key.
+func IdentityPath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "identity.key"), nil
+}
+
+// TrustPath returns the location of the trust-on-first-use key database.
+func TrustPath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "trust.json"), nil
+}
+
+// BotsPath returns where the bots "./client bots" runs are defined.
+func BotsPath() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "bots.json"), nil
+}
+
+// ScriptsDir returns where the client's Lua scripts are, normally
+// ~/.config/ttc/scripts.
+func ScriptsDir() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "scripts"), nil
+}
+
+// Dir returns the client's config directory, normally ~/.config/ttc.
+func Dir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ttc"), nil
+}
+
+// DataDir returns where the client keeps data it builds up rather than
+// settings, normally ~/.local/share/ttc ($XDG_DATA_HOME/ttc).
+func DataDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "ttc"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".local", "share", "ttc"), nil
+}
+
+// HistoryPath returns where the lines sent from the input field are kept
+// between runs, normally ~/.local/share/ttc/history.
+func HistoryPath() (string, error) {
+	dir, err := DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history"), nil
+}
+
+// Path returns the location of config.json, normally ~/.config/ttc/config.json.
+func Path() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.json"), nil
+}
+
+// Load reads config.json. If the file does not exist an empty Config is
+// returned so a fresh install starts without complaint.
+func Load() (*Config, error) {
+	path, err := Path()
+	if err != nil {
+		return &Config{}, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return &Config{}, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return &Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// Set writes one key of config.json, leaving the others as they are (keys
+// end up sorted). A missing file is created, readable by the user alone.
+func Set(key string, value interface{}) error {
+	path, err := Path()
+	if err != nil {
+		return err
+	}
+	fields := map[string]json.RawMessage{}
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if fields[key], err = json.Marshal(value); err != nil {
+		return err
+	}
+	if data, err = json.MarshalIndent(fields, "", "    "); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// CompileHighlights turns the configured rules into matchers. Rules with an
+// empty or invalid pattern are skipped and reported so one typo doesn't
+// disable every other rule.
+func (c *Config) CompileHighlights() ([]models.Highlight, []error) {
+	var rules []models.Highlight
+	var errs []error
+	for i, r := range c.Highlights {
+		if r.Pattern == "" {
+			errs = append(errs, fmt.Errorf("highlight #%d: empty pattern", i+1))
+			continue
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("highlight #%d: %w", i+1, err))
+			continue
+		}
+		color := "[black:yellow]"
+		if r.Color != "" {
+			color = models.ParseColorToTag(r.Color)
+		}
+		rules = append(rules, models.Highlight{
+			Pattern: re,
+			Color:   color,
+			Notify:  r.Notify,
+		})
+	}
+	return rules, errs
+}
@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"strings"
+
+	"cli-client/models"
+	"cli-client/views"
+)
+
+// accentColors are the colors a relay may give a room; anything else is
+// ignored rather than handed to tview.
+var accentColors = map[string]bool{
+	"red": true, "green": true, "yellow": true, "blue": true,
+	"magenta": true, "cyan": true, "white": true,
+}
+
+// syncRoomAccent labels the chat header with the current room, in the
+// accent color the relay's operator gave it (see /api/motd). Must be called
+// from the tview event loop.
+func (ac *AppController) syncRoomAccent() {
+	room := strings.ToLower(ac.App.Room)
+	accent := ac.roomAccents[room]
+	if !accentColors[accent] {
+		accent = ""
+	}
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.SetRoom(room, accent)
+	}
+}
@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"cli-client/models"
+	"cli-client/views"
+)
+
+// ── Actions (/me) ─────────────────────────────────────────────────────────────
+//
+// "/me waves" sends an IRC-style action, shown as "* alice waves" in italics
+// without the time and name prefix. It travels like any message to the room
+// — encrypted and signed the same way — with "type": "action" next to it.
+// The type is only in protocol version 2: relays and clients that speak
+// only version 1 show the text as an ordinary message.
+
+// actionType is the message type of an action on the wire.
+const actionType = "action"
+
+// SendAction relays a /me action; see SendMessage.
+func (nc *NetworkClient) SendAction(localID, username, content, colorTag string) {
+	if atomic.LoadInt32(&nc.stopped) == 1 {
+		return
+	}
+	log.Printf("TRACE NetworkClient.SendAction: user=%q content=%.60q", username, content)
+	nc.send(outboundMessage{localID: localID, username: username, content: content, colorTag: colorTag, action: true})
+}
+
+// OnSendAction implements /me <action>. Must be called from the tview event
+// loop.
+func (ac *AppController) OnSendAction(text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		ac.sendSystem("Usage: /me <action>  —  e.g. /me waves")
+		return
+	}
+	msg := models.NewMessage(ac.App.CurrentUser.Username, text)
+	msg.Color = ac.App.GetUserColorTag(ac.App.CurrentUser.Username)
+	msg.Action = true
+	if ac.netClient != nil {
+		msg.Delivery = models.DeliverySending
+	}
+	ac.App.AddMessage(msg)
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.AddMessage(msg)
+		ac.rememberSent(chat, "/me "+text)
+	}
+	if ac.netClient != nil {
+		ac.netClient.SendAction(msg.ID, msg.Username, text, msg.Color)
+	}
+	ac.lastTypingSent = time.Time{} // the server cleared our typing state
+}
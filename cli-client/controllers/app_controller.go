@@ -1,367 +1,1342 @@
-package controllers
-
-import (
-	"fmt"
-	"strings"
-	"time"
-
-	"cli-client/models"
-	"cli-client/views"
-
-	"github.com/rivo/tview"
-)
-
-type AppController struct {
-	App   *models.AppState
-	Views map[models.Screen]interface{}
-	SM    *StateMachine
-
-	app         *tview.Application
-	netClient   *NetworkClient
-	latencyCtrl *LatencyController
-}
-
-func NewAppController(app *tview.Application) *AppController {
-	return &AppController{
-		App:   models.NewAppState(),
-		Views: make(map[models.Screen]interface{}),
-		SM:    NewStateMachine(models.ScreenNone),
-		app:   app,
-	}
-}
-
-func (ac *AppController) RegisterView(screen models.Screen, view interface{}) {
-	ac.Views[screen] = view
-}
-
-// OnLoginSubmit — called from the tview event loop.
-// username is the entered username; colorTag is the tview color tag chosen
-// during login (e.g. "[cyan]"). If empty, falls back to hash-based default.
-func (ac *AppController) OnLoginSubmit(username, colorTag string) {
-	ac.App.SetCurrentUser(username)
-
-	// Apply the color chosen during login immediately, before any messages render.
-	if colorTag != "" && strings.HasPrefix(colorTag, "[") {
-		ac.App.SetUserColor(username, colorTag)
-	}
-
-	ac.SM.Transition(models.ScreenChat)
-
-	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
-		chat.SetCurrentUser(username)
-	}
-
-	ac.startNetworkClient()
-	ac.startLatencyController()
-}
-
-// OnSendMessage — called from the tview event loop.
-// The message is displayed optimistically in the UI immediately.
-// The encrypted wire copy is sent to the server asynchronously.
-func (ac *AppController) OnSendMessage(content string) {
-	msg := models.NewMessage(ac.App.CurrentUser.Username, content)
-	msg.Color = ac.App.GetUserColorTag(ac.App.CurrentUser.Username)
-	ac.App.AddMessage(msg)
-
-	// Display immediately — no waiting for server round-trip.
-	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
-		chat.AddMessage(msg)
-		chat.AddToHistory(content)
-	}
-
-	// Fire-and-forget: encrypt and relay to server.
-	// The server echoes this back to us; NetworkClient deduplicates via sentIDs.
-	if ac.netClient != nil {
-		ac.netClient.SendMessage(msg.Username, content, msg.Color)
-	}
-}
-
-// OnCommand — called from the tview event loop.
-func (ac *AppController) OnCommand(command string) {
-	if len(command) <= 1 {
-		ac.sendSystem("Usage: /<command>  —  type /help for available commands.")
-		return
-	}
-
-	raw := command[1:]
-	parts := strings.SplitN(raw, " ", 2)
-	cmd := strings.ToLower(strings.TrimSpace(parts[0]))
-	arg := ""
-	if len(parts) > 1 {
-		arg = strings.TrimSpace(parts[1])
-	}
-
-	chat, hasChat := ac.Views[models.ScreenChat].(*views.ChatView)
-
-	switch cmd {
-
-	case "clear":
-		ac.App.Messages = []*models.Message{}
-		if hasChat {
-			chat.ClearMessages()
-		}
-
-	case "help":
-		ac.sendSystem("Commands:  /clear  /whois  /nick  /mode [animation|static]  /user_color <color>  /server <url>  /latency  /info  /exit  /help")
-
-	case "info":
-		lines := []string{
-			"[dim]┌─ SecTherminal ──────────────────────────────────────────────┐[-]",
-			"  A lightweight, encrypted terminal messenger built in Go.",
-			"  Designed for speed, privacy, and minimal footprint.",
-			"",
-			"  [cyan]Author   [-]Mortza Mansory",
-			"  [cyan]License  [-]MIT — free and open-source",
-			"  [cyan]GitHub   [-]https://github.com/mortza-mansory/TTC-cli-messanger",
-			"  [cyan]Version  [-]v1.0.0-dev",
-			"",
-			"  [green]✓[-] End-to-end AES-256-GCM encrypted relay",
-			"  [green]✓[-] Zero server-side message storage — your device, your data",
-			"  [green]✓[-] Client-side history only (server stores nothing)",
-			"  [green]✓[-] Open source — audit the code yourself",
-			"  [green]✓[-] Low latency global relay nodes",
-			"[dim]└─────────────────────────────────────────────────────────────┘[-]",
-		}
-		for _, line := range lines {
-			ac.sendSystem(line)
-		}
-
-	case "whois":
-		if ac.App.CurrentUser == nil {
-			ac.sendSystem("No user logged in.")
-			return
-		}
-		u := ac.App.CurrentUser
-		colorTag := ac.App.GetUserColorTag(u.Username)
-		colorDisplay := strings.Trim(colorTag, "[]")
-		ac.sendSystem(fmt.Sprintf(
-			"Whois  ▸  user: %s%s[-]  |  color: %s  |  status: online  |  msgs sent: %d",
-			colorTag, u.Username, colorDisplay, ac.countUserMessages(u.Username),
-		))
-
-	case "nick":
-		if !hasChat {
-			return
-		}
-		active := chat.ToggleNickMode()
-		if active {
-			ac.sendSystem("Nick mode ON — ← / → navigates your sent-message history. /nick to turn off.")
-		} else {
-			ac.sendSystem("Nick mode OFF — arrow keys restored to normal.")
-		}
-
-	case "mode":
-		if !hasChat {
-			return
-		}
-		var label string
-		switch strings.ToLower(arg) {
-		case "animation", "anim":
-			chat.SetAnimationMode(true)
-			label = "animation"
-		case "static":
-			chat.SetAnimationMode(false)
-			label = "static"
-		default:
-			label = chat.ToggleAnimationMode()
-		}
-		ac.sendSystem(fmt.Sprintf("Display mode → %s", label))
-
-	case "user_color":
-		if ac.App.CurrentUser == nil {
-			ac.sendSystem("No user logged in.")
-			return
-		}
-		if arg == "" {
-			validList := strings.Join(models.ValidNamedColors, ", ")
-			ac.sendSystem("Usage: /user_color <color>  —  named: " + validList + "  |  or hex: #rrggbb")
-			return
-		}
-		username := ac.App.CurrentUser.Username
-		if strings.ToLower(arg) == "reset" {
-			delete(ac.App.UserColors, username)
-			defaultTag := models.GetUsernameColor(username)
-			if hasChat {
-				chat.SetCurrentUser(username)
-			}
-			colorDisplay := strings.Trim(defaultTag, "[]")
-			ac.sendSystem(fmt.Sprintf("Color reset → %s%s[-] (default)", defaultTag, colorDisplay))
-			return
-		}
-		colorTag := models.ParseColorToTag(arg)
-		if !strings.HasPrefix(arg, "#") && !models.IsValidNamedColor(arg) {
-			validList := strings.Join(models.ValidNamedColors, ", ")
-			ac.sendSystem(fmt.Sprintf("Unknown color: '%s'  —  valid names: %s  |  or hex: #rrggbb", arg, validList))
-			return
-		}
-		ac.App.SetUserColor(username, colorTag)
-		colorDisplay := arg
-		if !strings.HasPrefix(arg, "#") {
-			colorDisplay = strings.Trim(colorTag, "[]")
-		}
-		ac.sendSystem(fmt.Sprintf("Your color → %s%s[-]  (applies to all your new messages)", colorTag, colorDisplay))
-
-	// ── /server ──────────────────────────────────────────────────────────────
-	// Changes the relay server URL at runtime and reconnects.
-	// Usage: /server http://myserver.example.com:8080
-	case "server":
-		if arg == "" {
-			current := DefaultServerURL
-			if ac.netClient != nil {
-				current = ac.netClient.serverURL
-			}
-			ac.sendSystem(fmt.Sprintf("Current server: [cyan]%s[-]  —  usage: /server <url>", current))
-			return
-		}
-		// Validate basic URL shape
-		if !strings.HasPrefix(arg, "http://") && !strings.HasPrefix(arg, "https://") {
-			ac.sendSystem("Invalid URL — must start with http:// or https://")
-			return
-		}
-		DefaultServerURL = arg
-		ac.sendSystem(fmt.Sprintf("Server URL → [cyan]%s[-]  — reconnecting…", arg))
-		// Restart the network client with the new URL
-		ac.stopNetworkClient()
-		ac.startNetworkClient()
-
-	case "latency":
-		ms := -1
-		if ac.latencyCtrl != nil {
-			ms = ac.latencyCtrl.Current()
-		}
-		if ms < 0 {
-			ac.sendSystem("Latency: unreachable — TCP probe to 1.1.1.1:53 failed.")
-		} else {
-			ac.sendSystem(fmt.Sprintf("Latency: [cyan]%dms[-]  (TCP probe → 1.1.1.1:53, live measurement)", ms))
-		}
-
-	case "exit":
-		ac.app.Stop()
-
-	default:
-		ac.sendSystem(fmt.Sprintf("Unknown command: /%s — type /help for available commands.", cmd))
-	}
-}
-
-// ── Helpers ───────────────────────────────────────────────────────────────────
-
-func (ac *AppController) sendSystem(text string) {
-	msg := models.NewSystemMessage(text)
-	ac.App.AddMessage(msg)
-	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
-		chat.AddMessage(msg)
-	}
-}
-
-func (ac *AppController) countUserMessages(username string) int {
-	n := 0
-	for _, m := range ac.App.Messages {
-		if m.Username == username {
-			n++
-		}
-	}
-	return n
-}
-
-// startNetworkClient creates and starts a NetworkClient using DefaultServerURL.
-func (ac *AppController) startNetworkClient() {
-	ac.stopNetworkClient()
-
-	ac.netClient = NewNetworkClient(
-		ac.app,
-		DefaultServerURL,
-
-		// onMessage: called from the poll goroutine for each decrypted incoming message.
-		func(username, content, colorTag string) {
-			if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
-				// AddIncomingMessage already wraps in QueueUpdateDraw — safe here.
-				chat.AddIncomingMessage(username, content, colorTag)
-			}
-		},
-
-		// onStatusChange: called from the poll goroutine on connect/error/reconnect.
-		func(connected bool, msg string) {
-			ac.app.QueueUpdateDraw(func() {
-				ac.App.IsConnected = connected
-				ac.sendSystem(msg)
-				if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
-					chat.SetOnlineStatus(connected)
-				}
-			})
-		},
-	)
-
-	ac.netClient.Start()
-	go ac.statsPollerLoop()
-}
-
-func (ac *AppController) statsPollerLoop() {
-	// Poll /api/stats every 8 seconds and push results to the chat header.
-	// Runs as a goroutine alongside the poll loop; stops when netClient stops.
-	ticker := time.NewTicker(8 * time.Second)
-	defer ticker.Stop()
-
-	// Fetch once immediately so header shows data before the first tick.
-	ac.fetchAndPushStats()
-
-	for {
-		select {
-		case <-ticker.C:
-			if ac.netClient == nil {
-				return
-			}
-			ac.fetchAndPushStats()
-		}
-	}
-}
-
-func (ac *AppController) fetchAndPushStats() {
-	if ac.netClient == nil {
-		return
-	}
-	stats, err := ac.netClient.FetchStats()
-	if err != nil {
-		return // non-critical — silently skip bad fetches
-	}
-	chat, ok := ac.Views[models.ScreenChat].(*views.ChatView)
-	if !ok {
-		return
-	}
-	chat.UpdateStats(
-		stats.ChatStats.TotalMessages,
-		stats.ActiveClients,
-		stats.ChatStats.WaitingClients,
-		stats.ChatStats.MaxWaiters, // reuse maxWaiters as maxMsgs (server exposes 1000 for both)
-		stats.ChatStats.MaxWaiters,
-		ac.netClient.ServerURL(),
-	)
-}
-
-func (ac *AppController) stopNetworkClient() {
-	if ac.netClient != nil {
-		ac.netClient.Stop()
-		ac.netClient = nil
-	}
-}
-
-func (ac *AppController) startLatencyController() {
-	if ac.latencyCtrl != nil {
-		ac.latencyCtrl.Stop()
-	}
-	ac.latencyCtrl = NewLatencyController()
-	ac.latencyCtrl.Start(func(ms int) {
-		ac.App.Latency = ms
-		if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
-			chat.UpdateLatency(ms)
-		}
-	})
-}
-
-// StopBot stops all background services: network client and latency controller.
-func (ac *AppController) StopBot() {
-	ac.stopNetworkClient()
-	if ac.latencyCtrl != nil {
-		ac.latencyCtrl.Stop()
-		ac.latencyCtrl = nil
-	}
-}
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"cli-client/audit"
+	"cli-client/config"
+	"cli-client/crypto"
+	"cli-client/models"
+	"cli-client/trust"
+	"cli-client/version"
+	"cli-client/views"
+
+	"github.com/rivo/tview"
+)
+
+type AppController struct {
+	App   *models.AppState
+	Views map[models.Screen]interface{}
+	SM    *StateMachine
+
+	app         *tview.Application
+	clientID    string
+	session     *Session // issued at login; authenticates send/poll
+	netClient   *NetworkClient
+	latencyCtrl *LatencyController
+	seen        *seenIDs // recently delivered message IDs, for de-duplication
+	audit       *audit.Log
+	identity    *crypto.Identity     // nil if identity.key couldn't be loaded
+	gc          *crypto.GlobalCrypto // message keys; outlives reconnects
+	passphrase  string               // room passphrase from config.json, "" = ask at login
+	kdfSalt     string               // passphrase salt from config.json, "" = the relay's
+	trustStore  *trust.Store
+	peers       peerKeys
+
+	strictProtocol bool            // report poll protocol violations (/debug strict)
+	privacy        bool            // pad messages and send decoys (/privacy)
+	e2eRooms       map[string]bool // rooms that require encryption (/encrypt)
+	configNotes    []string        // problems found in config.json, shown once chat opens
+	deviceLogin    bool            // the relay signs in through an identity provider — see device_login.go
+
+	// Updates — see update.go.
+	updateCheck      bool              // look for new releases once a day
+	releasesURL      string            // where to look
+	minClientVersion string            // oldest client the relay serves, from its MOTD
+	roomAccents      map[string]string // room → accent color, from the MOTD; see accent.go
+	notices          []Notice          // kept announcements from the MOTD, shown once the history loaded; see motd.go
+
+	scripts scriptEngine // Lua hooks and commands — see scripts.go
+
+	adminToken string // relay admin token for the moderation commands — see moderation.go
+	transport  string // TransportPoll, TransportSSE or TransportWS — see stream.go
+	encoding   string // EncodingJSON or EncodingMsgpack — see encoding.go
+	safeMode   bool   // --safe-mode: ignore config.json's look, see SetSafeMode
+
+	// History paging — only touched inside the tview event loop.
+	historyBefore  string // id of the oldest message loaded; next page ends there
+	historyMore    bool   // server has older messages than historyBefore
+	historyLoading bool
+
+	lastTypingSent time.Time // throttles typing events; event loop only
+
+	// Synced drafts — see drafts.go. Event loop only.
+	syncDrafts bool
+	draftText  string      // the input field's text, "" for commands
+	draftSaved string      // the text last saved on the relay
+	draftTimer *time.Timer // saves draftText once typing pauses
+
+	resume   *resumeState  // restored from session.json, until the first connection uses it
+	handover *nickHandover // where the client replaced by /nick stopped — see nick.go
+
+	historyFileLines int // lines in the input history file — see input_history.go
+
+	ignored   ignoreList    // see ignore.go
+	files     fileIndex     // see files.go
+	replies   replyIndex    // see replies.go
+	reactions reactionIndex // see reactions.go
+	pins      []models.Pin  // the room's pinned messages, see pins.go
+
+	// Status API — see status_api.go.
+	status       statusTracker
+	statusServer *http.Server // nil unless "status_socket" is set
+
+	logFile *os.File // erased by /wipe
+	wiped   bool
+}
+
+// typingResendInterval is how often a typing event is repeated while the
+// user keeps typing — comfortably inside the server's 5s TTL.
+const typingResendInterval = 3 * time.Second
+
+// typingPollInterval is how often the list of other typers is refreshed.
+const typingPollInterval = 2 * time.Second
+
+// historyPageSize is how many messages are loaded when the chat opens and
+// per /history page.
+const historyPageSize = 100
+
+func NewAppController(app *tview.Application) *AppController {
+	ac := &AppController{
+		App:   models.NewAppState(),
+		Views: make(map[models.Screen]interface{}),
+		SM:    NewStateMachine(models.ScreenNone),
+		app:   app,
+
+		clientID:  GenerateClientID(),
+		transport: TransportPoll,
+		encoding:  EncodingJSON,
+		seen:      newSeenIDs(seenIDCapacity),
+		audit:     audit.NewLog(),
+		gc:        crypto.NewGlobalCrypto(),
+		e2eRooms:  make(map[string]bool),
+		peers: peerKeys{
+			checked: make(map[string]time.Time),
+			warned:  make(map[string]string),
+		},
+	}
+	ac.trustStore, _ = trust.Open("")
+	return ac
+}
+
+func (ac *AppController) RegisterView(screen models.Screen, view interface{}) {
+	ac.Views[screen] = view
+}
+
+// LoadConfig reads config.json and installs the compiled highlight rules and
+// line prefix template on the chat view. Problems are logged and kept for display once the chat
+// screen opens — a broken config never stops the client from starting.
+// Call after the views are registered.
+func (ac *AppController) LoadConfig() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Printf("config: %v", err)
+		ac.configNotes = append(ac.configNotes, fmt.Sprintf("Config not loaded: %s", tview.Escape(err.Error())))
+	}
+	// look holds the settings of the chat screen's look: in safe mode,
+	// none of config.json's.
+	look := cfg
+	if ac.safeMode {
+		look = &config.Config{}
+	}
+	rules, errs := look.CompileHighlights()
+	for _, e := range errs {
+		log.Printf("config: %v", e)
+		ac.configNotes = append(ac.configNotes, fmt.Sprintf("Config: %s — rule skipped.", tview.Escape(e.Error())))
+	}
+	prefix, err := views.ParsePrefixTemplate(look.Prefix)
+	if err != nil {
+		log.Printf("config: prefix: %v", err)
+		ac.configNotes = append(ac.configNotes, fmt.Sprintf("Config: prefix: %s — using the default layout.", tview.Escape(err.Error())))
+	}
+	theme, ok := views.LookupTheme(look.Theme)
+	if !ok {
+		if look.Theme != "" {
+			ac.configNotes = append(ac.configNotes, fmt.Sprintf("Config: unknown theme %q — using %s. Themes: %s.",
+				tview.Escape(look.Theme), views.DefaultTheme, strings.Join(views.ThemeNames(), ", ")))
+		}
+		theme, _ = views.LookupTheme(views.DefaultTheme)
+	}
+	if ac.safeMode {
+		ac.configNotes = append(ac.configNotes, "Safe mode: default theme, no animations, and none of config.json's highlights, prefix, snippets, emoji, formatting, notification or image settings. Restart without --safe-mode to use them again.")
+	}
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.SetHighlighter(models.NewHighlighter(rules))
+		chat.SetPrefixTemplate(prefix)
+		chat.SetTheme(theme)
+		chat.SetSessionStats(ac.App.Session)
+		chat.SetSnippets(models.NewSnippets(look.Snippets))
+		chat.SetEmoji(!look.DisableEmoji)
+		chat.SetFormatting(!look.DisableFormatting)
+		style, ok := views.ParseNotifyStyle(look.TerminalNotify)
+		if !ok {
+			ac.configNotes = append(ac.configNotes, fmt.Sprintf("Config: unknown terminal_notify %q — using %s. Choices: osc9, osc777, off.",
+				tview.Escape(look.TerminalNotify), views.DefaultNotifyStyle))
+		}
+		chat.SetNotifyStyle(style)
+		images, ok := views.ParseImageStyle(look.ImagePreview)
+		if !ok {
+			ac.configNotes = append(ac.configNotes, fmt.Sprintf("Config: unknown image_preview %q — using %s. Choices: auto, kitty, sixel, blocks, off.",
+				tview.Escape(look.ImagePreview), views.DefaultImageStyle))
+		}
+		chat.SetImageStyle(images)
+		if ac.safeMode {
+			chat.SetAnimationMode(false)
+		}
+	}
+	ac.configNotes = append(ac.configNotes, applyConnectionConfig(cfg)...)
+	ac.app.EnableMouse(!cfg.DisableMouse)
+	ac.privacy = cfg.Privacy
+	ac.passphrase = cfg.Passphrase
+	ac.kdfSalt = cfg.PassphraseSalt
+	ac.updateCheck = cfg.UpdateCheck
+	ac.releasesURL = cfg.ReleasesURL
+	ac.adminToken = cfg.AdminToken
+	ac.syncDrafts = cfg.SyncDrafts
+	ac.ignored.set(cfg.Ignore)
+	if !ac.safeMode {
+		ac.configNotes = append(ac.configNotes, ac.loadScripts()...)
+	}
+	if ac.releasesURL == "" {
+		ac.releasesURL = defaultReleasesURL
+	}
+	if login, ok := ac.Views[models.ScreenLogin].(*views.LoginView); ok {
+		login.SetAskPassphrase(cfg.Passphrase == "")
+	}
+	for _, room := range cfg.E2ERooms {
+		ac.e2eRooms[strings.ToLower(strings.TrimSpace(room))] = true
+	}
+	if cfg.AuditLog {
+		ac.openAuditFile()
+	}
+	if cfg.StatusSocket != "" {
+		if err := ac.startStatusAPI(cfg.StatusSocket); err != nil {
+			log.Printf("config: status API: %v", err)
+			ac.configNotes = append(ac.configNotes, fmt.Sprintf("Config: status API not started: %s", tview.Escape(err.Error())))
+		}
+	}
+	ac.openTrust()
+	log.Printf("config: %d highlight rule(s) active, prefix=%q privacy=%v theme=%s", len(rules), look.Prefix, cfg.Privacy, theme.Name)
+}
+
+// applyConnectionConfig installs cfg's relay address, proxies and
+// certificate pins — what the chat and --monitor both need — and returns
+// notes on the settings it had to ignore.
+func applyConnectionConfig(cfg *config.Config) []string {
+	var notes []string
+	if cfg.ServerURL != "" {
+		if strings.HasPrefix(cfg.ServerURL, "http://") || strings.HasPrefix(cfg.ServerURL, "https://") {
+			DefaultServerURL = strings.TrimRight(cfg.ServerURL, "/")
+		} else {
+			notes = append(notes, fmt.Sprintf("Config: server_url %q must start with http:// or https:// — ignored.", tview.Escape(cfg.ServerURL)))
+		}
+	}
+	if err := SetTorProxy(cfg.TorProxy); err != nil {
+		log.Printf("config: %v", err)
+		notes = append(notes, fmt.Sprintf("Config: %s — using %s.", tview.Escape(err.Error()), DefaultTorProxy))
+	}
+	if err := SetProxy(cfg.Proxy); err != nil {
+		log.Printf("config: %v", err)
+		notes = append(notes, fmt.Sprintf("Config: %s — refusing to connect until it is fixed.", tview.Escape(err.Error())))
+	}
+	if err := SetPins(cfg.PinSHA256); err != nil {
+		log.Printf("config: %v", err)
+		notes = append(notes, fmt.Sprintf("Config: %s — refusing to connect until it is fixed.", tview.Escape(err.Error())))
+	}
+	return notes
+}
+
+// SetSafeMode starts the client in safe mode (--safe-mode), to recover from
+// a config that makes the chat screen unusable: the default theme, static
+// display and none of config.json's look — highlights, prefix, snippets,
+// emoji, formatting, notifications and image previews are the built-in
+// ones — and no scripts. Settings that only affect the connection still
+// apply, and /theme and /mode work as usual. Call before LoadConfig.
+func (ac *AppController) SetSafeMode() {
+	ac.safeMode = true
+}
+
+// SetTransport picks how messages are received (--transport): TransportPoll,
+// TransportSSE or TransportWS. Call before the login.
+func (ac *AppController) SetTransport(name string) error {
+	if err := ValidTransport(name); err != nil {
+		return err
+	}
+	ac.transport = name
+	return nil
+}
+
+// SetEncoding picks the wire encoding of sends, polls and history
+// (--encoding): EncodingJSON or EncodingMsgpack. Call before the login.
+func (ac *AppController) SetEncoding(name string) error {
+	if err := ValidEncoding(name); err != nil {
+		return err
+	}
+	ac.encoding = name
+	return nil
+}
+
+// Resume re-syncs everything that went stale while the process was stopped:
+// the whole terminal is repainted, the header clock and stats refreshed, and
+// the poll loop drops its (probably dead) connection and polls again.
+// Safe to call from any goroutine.
+func (ac *AppController) Resume() {
+	ac.app.Sync()
+	ac.app.QueueUpdateDraw(func() {
+		if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+			chat.Refresh()
+		}
+		if ac.netClient != nil {
+			ac.netClient.Wake()
+			go ac.fetchAndPushStats()
+		}
+	})
+}
+
+// OnLoginSubmit — called from the tview event loop.
+// username is the entered username; colorTag is the tview color tag chosen
+// during login (e.g. "[cyan]"). If empty, falls back to hash-based default.
+//
+// The credentials are checked against /api/login off the event loop, or,
+// with register, a new account is created through /api/register (first
+// come, first served). A refused login offers to register instead.
+// On a relay with device code sign-in, username and password are empty and
+// the identity provider names the user instead (see device_login.go).
+// On rejection the login view shows the error inline and re-prompts.
+//
+// passphrase is the room passphrase typed at login; empty falls back to
+// "passphrase" in config.json, then to the key built into every client.
+// The key is derived once logged in, with the salt from config.json or the
+// relay's message of the day.
+func (ac *AppController) OnLoginSubmit(username, colorTag, password, passphrase string, register bool) {
+	if passphrase == "" {
+		passphrase = ac.passphrase
+	}
+	go func() {
+		var session *Session
+		var err error
+		if ac.deviceLogin {
+			session, err = ac.deviceSignIn()
+			if err == nil {
+				username = session.Username
+			}
+		} else if register {
+			session, err = Register(DefaultServerURL, ac.clientID, username, password, ac.publishedKeys())
+		} else {
+			session, err = Login(DefaultServerURL, ac.clientID, username, password, ac.publishedKeys())
+		}
+		motd := &Motd{}
+		if err == nil {
+			if m, merr := FetchMotd(DefaultServerURL); merr == nil {
+				motd = m
+			} else {
+				log.Printf("motd: %v", merr)
+			}
+			if passphrase != "" {
+				ac.usePassphrase(passphrase, motd.KDFSalt)
+			}
+		}
+		ac.app.QueueUpdateDraw(func() {
+			var tooOld *ClientTooOldError
+			if errors.As(err, &tooOld) {
+				ac.blockOutdated(tooOld.Message)
+				return
+			}
+			if err != nil {
+				if login, ok := ac.Views[models.ScreenLogin].(*views.LoginView); ok {
+					if errors.Is(err, ErrInvalidCredentials) {
+						login.OfferRegister(err.Error(), password)
+					} else {
+						login.ShowLoginError(err.Error())
+					}
+				}
+				return
+			}
+			ac.minClientVersion = motd.MinClientVersion
+			ac.roomAccents = motd.RoomAccents
+			ac.notices = motd.Announcements
+			if version.Older(motd.MinClientVersion) {
+				ac.blockOutdated(fmt.Sprintf("This relay requires client %s or newer.", motd.MinClientVersion))
+				return
+			}
+			ac.session = session
+			ac.completeLogin(username, colorTag)
+			if register {
+				ac.sendSystem(fmt.Sprintf("Account [cyan]%s[-] registered — this username is now yours.", username))
+			}
+			if motd.Motd != "" {
+				ac.sendSystem(motdText(motd.Motd))
+			}
+		})
+	}()
+}
+
+// completeLogin switches to the chat screen once the server accepted the login.
+// Must be called from the tview event loop.
+func (ac *AppController) completeLogin(username, colorTag string) {
+	ac.App.SetCurrentUser(username)
+
+	// Apply the color chosen during login immediately, before any messages render.
+	if colorTag != "" && strings.HasPrefix(colorTag, "[") {
+		ac.App.SetUserColor(username, colorTag)
+	}
+
+	ac.SM.Transition(models.ScreenChat)
+
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.SetCurrentUser(username)
+	}
+
+	for _, note := range ac.configNotes {
+		ac.sendSystem(note)
+	}
+	ac.configNotes = nil
+	ac.noteNetworkMode()
+	ac.noteKeyFingerprint()
+
+	ac.startNetworkClient()
+	ac.startLatencyController()
+	if ac.updateCheck {
+		go ac.checkForUpdate(false)
+	}
+	ac.offerTour()
+}
+
+// OnSendMessage — called from the tview event loop.
+// Scripts' on_send hooks see the text first; see sendMessage.
+func (ac *AppController) OnSendMessage(content string) {
+	content, actions := ac.scripts.onSend(content)
+	if content != "" {
+		ac.sendMessage(content)
+	}
+	ac.runScriptActions(actions)
+}
+
+// sendMessage sends content to the room as typed. Must be called from the
+// tview event loop.
+// The message is displayed optimistically in the UI immediately.
+// The encrypted wire copy is sent to the server asynchronously.
+func (ac *AppController) sendMessage(content string) {
+	ac.status.seen()
+	msg := models.NewMessage(ac.App.CurrentUser.Username, content)
+	msg.Color = ac.App.GetUserColorTag(ac.App.CurrentUser.Username)
+	ac.files.note(msg)
+	ac.replies.note(msg)
+	if ac.netClient != nil {
+		msg.Delivery = models.DeliverySending
+	}
+	ac.App.AddMessage(msg)
+
+	// Display immediately — no waiting for server round-trip.
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.AddMessage(msg)
+		ac.rememberSent(chat, content)
+	}
+
+	// Fire-and-forget: encrypt and relay to server.
+	// The server echoes this back to us; NetworkClient deduplicates via sentIDs.
+	if ac.netClient != nil {
+		ac.netClient.SendMessage(msg.ID, msg.Username, content, msg.Color)
+	}
+	ac.lastTypingSent = time.Time{} // the server cleared our typing state
+}
+
+// OnTyping — called from the tview event loop whenever the input text
+// changes. Plain text (not /commands) sends a throttled typing event.
+func (ac *AppController) OnTyping(text string) {
+	ac.status.seen()
+	ac.noteDraft(text)
+	if ac.netClient == nil || text == "" || strings.HasPrefix(text, "/") {
+		return
+	}
+	if time.Since(ac.lastTypingSent) < typingResendInterval {
+		return
+	}
+	ac.lastTypingSent = time.Now()
+	ac.netClient.SendTyping()
+}
+
+// OnCommand — called from the tview event loop.
+func (ac *AppController) OnCommand(command string) {
+	ac.status.seen()
+	if len(command) <= 1 {
+		ac.sendSystem("Usage: /<command>  —  type /help for available commands.")
+		return
+	}
+
+	raw := command[1:]
+	parts := strings.SplitN(raw, " ", 2)
+	cmd := strings.ToLower(strings.TrimSpace(parts[0]))
+	arg := ""
+	if len(parts) > 1 {
+		arg = strings.TrimSpace(parts[1])
+	}
+
+	chat, hasChat := ac.Views[models.ScreenChat].(*views.ChatView)
+
+	switch cmd {
+
+	case "clear":
+		ac.App.Messages = []*models.Message{}
+		if hasChat {
+			chat.ClearMessages()
+		}
+
+	case "help":
+		ac.showHelp()
+
+	case "info":
+		lines := []string{
+			"[dim]┌─ SecTherminal ──────────────────────────────────────────────┐[-]",
+			"  A lightweight, encrypted terminal messenger built in Go.",
+			"  Designed for speed, privacy, and minimal footprint.",
+			"",
+			"  [cyan]Author   [-]Mortza Mansory",
+			"  [cyan]License  [-]MIT — free and open-source",
+			"  [cyan]GitHub   [-]https://github.com/mortza-mansory/TTC-cli-messanger",
+			"  [cyan]Version  [-]" + version.Version,
+			"  [cyan]Commit   [-]" + orUnknown(version.Commit),
+			"  [cyan]Built    [-]" + orUnknown(version.Date),
+			"",
+			"  [green]✓[-] End-to-end AES-256-GCM encrypted relay",
+			"  [green]✓[-] Zero server-side message storage — your device, your data",
+			"  [green]✓[-] Client-side history only (server stores nothing)",
+			"  [green]✓[-] Open source — audit the code yourself",
+			"  [green]✓[-] Low latency global relay nodes",
+			"[dim]└─────────────────────────────────────────────────────────────┘[-]",
+		}
+		for _, line := range lines {
+			ac.sendSystem(line)
+		}
+
+	case "whois":
+		ac.handleWhois(arg)
+
+	case "nick":
+		ac.handleNick(arg)
+
+	case "ignore":
+		ac.handleIgnore(arg, true)
+
+	case "unignore":
+		ac.handleIgnore(arg, false)
+
+	case "ignored":
+		ac.handleIgnored()
+
+	case "mode":
+		if !hasChat {
+			return
+		}
+		var label string
+		switch strings.ToLower(arg) {
+		case "animation", "anim":
+			chat.SetAnimationMode(true)
+			label = "animation"
+		case "static":
+			chat.SetAnimationMode(false)
+			label = "static"
+		default:
+			label = chat.ToggleAnimationMode()
+		}
+		ac.sendSystem(fmt.Sprintf("Display mode → %s", label))
+
+	case "user_color":
+		if ac.App.CurrentUser == nil {
+			ac.sendSystem("No user logged in.")
+			return
+		}
+		if arg == "" {
+			validList := strings.Join(models.ValidNamedColors, ", ")
+			ac.sendSystem("Usage: /user_color <color>  —  named: " + validList + "  |  or hex: #rrggbb")
+			return
+		}
+		username := ac.App.CurrentUser.Username
+		if strings.ToLower(arg) == "reset" {
+			delete(ac.App.UserColors, username)
+			ac.syncPrivacy()
+			defaultTag := models.GetUsernameColor(username)
+			if hasChat {
+				chat.SetCurrentUser(username)
+			}
+			colorDisplay := strings.Trim(defaultTag, "[]")
+			ac.sendSystem(fmt.Sprintf("Color reset → %s%s[-] (default)", defaultTag, colorDisplay))
+			return
+		}
+		colorTag := models.ParseColorToTag(arg)
+		if !strings.HasPrefix(arg, "#") && !models.IsValidNamedColor(arg) {
+			validList := strings.Join(models.ValidNamedColors, ", ")
+			ac.sendSystem(fmt.Sprintf("Unknown color: '%s'  —  valid names: %s  |  or hex: #rrggbb", arg, validList))
+			return
+		}
+		ac.App.SetUserColor(username, colorTag)
+		ac.syncPrivacy()
+		colorDisplay := arg
+		if !strings.HasPrefix(arg, "#") {
+			colorDisplay = strings.Trim(colorTag, "[]")
+		}
+		ac.sendSystem(fmt.Sprintf("Your color → %s%s[-]  (applies to all your new messages)", colorTag, colorDisplay))
+
+	// ── /server ──────────────────────────────────────────────────────────────
+	// Changes the relay server URL at runtime and reconnects.
+	// Usage: /server http://myserver.example.com:8080
+	case "server":
+		if arg == "" {
+			current := DefaultServerURL
+			if ac.netClient != nil {
+				current = ac.netClient.serverURL
+			}
+			ac.sendSystem(fmt.Sprintf("Current server: [cyan]%s[-]  —  usage: /server <url>", current))
+			return
+		}
+		// Validate basic URL shape
+		if !strings.HasPrefix(arg, "http://") && !strings.HasPrefix(arg, "https://") {
+			ac.sendSystem("Invalid URL — must start with http:// or https://")
+			return
+		}
+		DefaultServerURL = arg
+		ac.sendSystem(fmt.Sprintf("Server URL → [cyan]%s[-]  — reconnecting…", arg))
+		ac.noteNetworkMode()
+		// Restart the network client with the new URL
+		ac.stopNetworkClient()
+		ac.startNetworkClient()
+		ac.startLatencyController()
+
+	case "latency":
+		if onionMode() {
+			ac.sendSystem("Latency: not measured — hidden-service mode only talks to the relay.")
+			return
+		}
+		if proxy := ActiveProxy(); proxy != "" {
+			ac.sendSystem(fmt.Sprintf("Latency: not measured — the probe would bypass the proxy %s.", tview.Escape(proxy)))
+			return
+		}
+		ms := -1
+		if ac.latencyCtrl != nil {
+			ms = ac.latencyCtrl.Current()
+		}
+		if ms < 0 {
+			ac.sendSystem("Latency: unreachable — TCP probe to 1.1.1.1:53 failed.")
+		} else {
+			ac.sendSystem(fmt.Sprintf("Latency: [cyan]%dms[-]  (TCP probe → 1.1.1.1:53, live measurement)", ms))
+		}
+
+	case "debug":
+		ac.handleDebug(arg)
+
+	case "history":
+		ac.loadOlderHistory()
+
+	case "retry":
+		ac.retryFailed()
+
+	case "privacy":
+		ac.handlePrivacy(arg)
+
+	case "encrypt":
+		ac.handleEncrypt(arg)
+
+	case "msg":
+		ac.handleMsg(arg)
+
+	case "forward":
+		ac.handleForward(arg)
+
+	case "reply":
+		ac.handleReply(arg)
+
+	case "react":
+		ac.handleReact(arg)
+
+	case "pins":
+		ac.handlePins()
+
+	case "motd":
+		ac.handleMotd()
+
+	case "pin":
+		ac.handlePin(arg, false)
+
+	case "unpin":
+		ac.handlePin(arg, true)
+
+	case "xpost":
+		ac.handleXPost(arg)
+
+	case "drafts":
+		ac.handleDrafts(arg)
+
+	case "trust":
+		ac.handleTrust(arg)
+
+	case "untrust":
+		ac.handleUntrust(arg)
+
+	case "audit":
+		ac.showAudit()
+
+	case "update":
+		ac.handleUpdate(arg)
+
+	case "kick":
+		ac.handleKick(arg)
+
+	case "ban":
+		ac.handleBan(arg)
+
+	case "unban":
+		ac.handleUnban(arg)
+
+	case "purge":
+		ac.handlePurge(arg)
+
+	case "announce":
+		ac.handleAnnounce(arg)
+
+	case "me":
+		ac.OnSendAction(arg)
+
+	case "room":
+		ac.handleRoom(arg)
+
+	case "paste":
+		ac.handlePaste(arg)
+
+	case "send":
+		ac.handleSend(arg)
+
+	case "get":
+		ac.handleGet(arg)
+
+	case "tour":
+		ac.startTour(false)
+
+	case "snip":
+		ac.handleSnip(arg)
+
+	case "theme":
+		ac.handleTheme(arg)
+
+	case "users":
+		users := ac.App.OnlineUsers()
+		ac.sendSystem(fmt.Sprintf("Online now (%d):", len(users)))
+		for _, u := range users {
+			seen := "active"
+			if !u.LastSeen.IsZero() {
+				if ago := time.Since(u.LastSeen).Round(time.Second); ago >= 5*time.Second {
+					seen = fmt.Sprintf("seen %s ago", ago)
+				}
+			}
+			ac.sendSystem(fmt.Sprintf("  %s%s[-]  [dim]%s[-]", u.Color, tview.Escape(u.Username), seen))
+		}
+		go ac.fetchAndPushPresence()
+
+	case "wipe":
+		ac.wipe(strings.EqualFold(arg, "all"))
+
+	case "exit":
+		ac.app.Stop()
+
+	case "scripts":
+		ac.handleScripts(arg)
+
+	default:
+		if ok, actions := ac.scripts.command(cmd, arg); ok {
+			ac.runScriptActions(actions)
+			return
+		}
+		ac.sendSystem(fmt.Sprintf("Unknown command: /%s — type /help for available commands.", cmd))
+	}
+}
+
+// ── Helpers ───────────────────────────────────────────────────────────────────
+
+func (ac *AppController) sendSystem(text string) {
+	msg := models.NewSystemMessage(text)
+	ac.App.AddMessage(msg)
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.AddMessage(msg)
+	}
+}
+
+// handleDebug implements /debug: session counters, protocol diagnostics and
+// the strict protocol conformance toggle.
+func (ac *AppController) handleDebug(arg string) {
+	fields := strings.Fields(strings.ToLower(arg))
+	if len(fields) > 0 && fields[0] == "strict" {
+		switch {
+		case len(fields) == 1:
+			ac.strictProtocol = !ac.strictProtocol
+		case fields[1] == "on":
+			ac.strictProtocol = true
+		case fields[1] == "off":
+			ac.strictProtocol = false
+		default:
+			ac.sendSystem("Usage: /debug strict [on|off]")
+			return
+		}
+		if ac.netClient != nil {
+			ac.netClient.SetStrictMode(ac.strictProtocol)
+		}
+		ac.sendSystem(fmt.Sprintf("Strict protocol mode → %s", onOff(ac.strictProtocol)))
+		return
+	}
+	if len(fields) > 0 {
+		ac.sendSystem("Usage: /debug  —  or /debug strict [on|off]")
+		return
+	}
+
+	snap := ac.App.Session.Snapshot()
+	protocol := 1
+	if ac.session != nil && ac.session.Protocol > 1 {
+		protocol = ac.session.Protocol
+	}
+	total := 0
+	for _, n := range snap.Violations {
+		total += n
+	}
+	lines := []string{
+		"[dim]┌─ Debug ─────────────────────────────────────────────────────┐[-]",
+		fmt.Sprintf("  [cyan]Session   [-]%s (up %s)", snap.StartedAt.Format("15:04:05"), time.Since(snap.StartedAt).Round(time.Second)),
+		fmt.Sprintf("  [cyan]Received  [-]%d messages", snap.Received),
+		fmt.Sprintf("  [cyan]Sent      [-]%d messages", snap.Sent),
+		fmt.Sprintf("  [cyan]Strict    [-]%s", onOff(ac.strictProtocol)),
+		fmt.Sprintf("  [cyan]Protocol  [-]v%d, %d violations", protocol, total),
+	}
+	for _, kind := range models.SortedKeys(snap.Violations) {
+		lines = append(lines, fmt.Sprintf("      %-16s %d", kind, snap.Violations[kind]))
+	}
+	dropped := 0
+	for _, n := range snap.Dropped {
+		dropped += n
+	}
+	lines = append(lines, fmt.Sprintf("  [cyan]Dropped   [-]%d messages", dropped))
+	for _, reason := range models.SortedKeys(snap.Dropped) {
+		lines = append(lines, fmt.Sprintf("      %-16s %d", reason, snap.Dropped[reason]))
+	}
+	if len(snap.Diagnostics) > 0 {
+		lines = append(lines, "  [cyan]Recent diagnostics[-]")
+		for _, d := range snap.Diagnostics {
+			// Diagnostics quote raw server data — escape it like user content.
+			lines = append(lines, "    "+strings.ReplaceAll(d, "[", "[[]"))
+		}
+	}
+	lines = append(lines, "[dim]└─────────────────────────────────────────────────────────────┘[-]")
+	for _, line := range lines {
+		ac.sendSystem(line)
+	}
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+func (ac *AppController) countUserMessages(username string) int {
+	n := 0
+	for _, m := range ac.App.Messages {
+		if m.Username == username {
+			n++
+		}
+	}
+	return n
+}
+
+// startNetworkClient creates and starts a NetworkClient using DefaultServerURL.
+func (ac *AppController) startNetworkClient() {
+	ac.stopNetworkClient()
+
+	ac.peers.mu.Lock()
+	ac.peers.self = ac.session.Username
+	ac.peers.mu.Unlock()
+	self := ac.session.Username
+	ac.status.setSession(self, ac.App.Room)
+
+	var nc *NetworkClient
+	nc = NewNetworkClient(
+		ac.app,
+		DefaultServerURL,
+		ac.session.Token,
+		ac.App.Room,
+		ac.App.Session,
+		ac.gc,
+
+		// onMessage: called from the poll goroutine for each decrypted incoming message.
+		// Retries or server bugs can deliver an ID twice — drop repeats here
+		// so ChatView never renders the same message twice.
+		func(msg *models.Message) {
+			if ac.seen.Observe(msg.ID) {
+				log.Printf("TRACE onMessage: duplicate id=%q from %q dropped", msg.ID, msg.Username)
+				ac.App.Session.RecordDropped(models.DropDuplicate, 1)
+				return
+			}
+			ac.checkPeerKey(nc, msg.Username)
+			ac.files.note(msg)
+			ac.replies.quote(msg)
+			ac.replies.note(msg)
+			// Keep AppState complete so a history page re-render via
+			// SetMessages doesn't drop messages that arrived live.
+			ac.app.QueueUpdate(func() { ac.App.AddMessage(msg) })
+			if ac.ignored.has(msg.Username) {
+				log.Printf("TRACE onMessage: id=%q from ignored %q not shown", msg.ID, msg.Username)
+				return
+			}
+			if msg.Username != self {
+				show, actions := ac.scripts.onMessage(msg)
+				if len(actions) > 0 {
+					// After the message itself, so an answer shows below it.
+					defer ac.app.QueueUpdateDraw(func() { ac.runScriptActions(actions) })
+				}
+				if !show {
+					log.Printf("TRACE onMessage: id=%q hidden by a script", msg.ID)
+					return
+				}
+			}
+			if msg.Username != self {
+				ac.status.noteMessage(msg.Timestamp, msg.MentionsUser(self))
+			}
+			if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+				switch {
+				case msg.To == self && msg.Username != self:
+					log.Printf("TRACE onMessage: id=%q direct message from %q", msg.ID, msg.Username)
+					chat.Notify("Direct message from "+msg.Username, msg.Content)
+				case msg.MentionsUser(self):
+					log.Printf("TRACE onMessage: id=%q from %q mentions us", msg.ID, msg.Username)
+					chat.Notify(msg.Username+" mentioned you in #"+ac.App.Room, msg.Content)
+				}
+				if msg.Action {
+					ac.app.QueueUpdateDraw(func() { chat.AddMessage(msg) })
+					return
+				}
+				// AddIncomingMessage already wraps in QueueUpdateDraw — safe here.
+				chat.AddIncomingMessage(msg.ID, msg.Username, msg.DisplayText(), msg.Color, msg.Sender, msg.Quote)
+			}
+		},
+
+		// onStatusChange: called from the poll goroutine on connect/error/reconnect.
+		func(connected bool, msg string) {
+			ac.app.QueueUpdateDraw(func() {
+				ac.App.IsConnected = connected
+				ac.status.setConnected(connected)
+				ac.sendSystem(msg)
+				if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+					chat.SetOnlineStatus(connected)
+				}
+			})
+		},
+
+		// onDelivery: an own message changed delivery state — re-render just
+		// its line with the new marker.
+		func(localID string, state models.DeliveryState) {
+			ac.app.QueueUpdateDraw(func() {
+				ac.setDelivery(localID, state)
+			})
+		},
+	)
+	ac.netClient = nc
+
+	ac.netClient.SetStrictMode(ac.strictProtocol)
+	ac.netClient.SetTransport(ac.transport)
+	ac.netClient.SetSigningKey(ac.session.SigningKey)
+	ac.netClient.SetProtocol(ac.session.Protocol)
+	ac.netClient.SetEncoding(ac.encoding)
+	ac.netClient.SetSecurityHandler(ac.recordSecurity)
+	ac.netClient.SetIdentity(ac.session.Username, ac.identity)
+	ac.netClient.SetAnnouncementHandler(func(id, text string) {
+		if ac.seen.Observe(id) {
+			return
+		}
+		ac.app.QueueUpdateDraw(func() { ac.sendSystem(announcementText(text)) })
+	})
+	ac.netClient.SetNickHandler(func(id string, change nickChange) {
+		if ac.seen.Observe(id) {
+			return
+		}
+		ac.app.QueueUpdateDraw(func() { ac.noteNick(change) })
+	})
+	ac.netClient.SetReactionHandler(func(seq uint64, event reactionEvent) {
+		if ac.reactions.set(seq, event) {
+			ac.app.QueueUpdateDraw(func() { ac.showReactions(event.MessageID) })
+		}
+	})
+	ac.netClient.SetSentHandler(func(localID, id string) {
+		ac.replies.alias(localID, id)
+		ac.app.QueueUpdate(func() {
+			if msg := ac.findMessage(localID); msg != nil {
+				msg.RelayID = id
+			}
+		})
+	})
+	ac.netClient.SetSenderKeys(func(username string) (string, bool) { return ac.senderKey(nc, username) })
+	ac.netClient.SetGapHandler(func() {
+		ac.app.QueueUpdateDraw(func() {
+			ac.sendSystem("[yellow]── messages may have been missed — the relay dropped them before they reached you ──[-]")
+		})
+	})
+	ac.syncPrivacy()
+	ac.syncRoomAccent()
+	if h := ac.handover; h != nil {
+		// Same relay, new session (/nick): the history is on screen.
+		ac.handover = nil
+		nc.ResumeAfter(h.lastID, h.lastSeq)
+		nc.Start()
+	} else {
+		go ac.loadInitialHistory(ac.netClient, ac.takeResume(nc))
+	}
+	go ac.statsPollerLoop()
+	go ac.typingPollerLoop(ac.netClient)
+	go ac.showRetention(ac.netClient)
+	if ac.syncDrafts {
+		go ac.restoreDraft(ac.netClient)
+	}
+	ac.runScriptActions(ac.scripts.onConnect(self, ac.App.Room))
+}
+
+// typingPollerLoop refreshes the "is typing…" line until nc is stopped.
+func (ac *AppController) typingPollerLoop(nc *NetworkClient) {
+	ticker := time.NewTicker(typingPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-nc.stopCh:
+			return
+		case <-ticker.C:
+		}
+		names, err := nc.FetchTyping()
+		if err != nil {
+			continue // non-critical — the indicator expires on its own
+		}
+		if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+			chat.SetTypingUsers(names)
+		}
+	}
+}
+
+// handlePrivacy implements /privacy: show or toggle padded messages and
+// decoy traffic for this session.
+func (ac *AppController) handlePrivacy(arg string) {
+	switch strings.ToLower(arg) {
+	case "":
+	case "on":
+		ac.privacy = true
+	case "off":
+		ac.privacy = false
+	default:
+		ac.sendSystem("Usage: /privacy [on|off]")
+		return
+	}
+	ac.syncPrivacy()
+	if ac.privacy {
+		ac.sendSystem("Privacy mode [green]on[-] — messages are encrypted and padded, decoys are sent now and then.")
+	} else {
+		ac.sendSystem("Privacy mode [red]off[-] — messages are sent as typed.")
+	}
+}
+
+// syncPrivacy passes the privacy setting and the identity decoys are sent
+// under to the network client. Call again after the user's color changes so
+// decoys keep matching real messages.
+func (ac *AppController) syncPrivacy() {
+	if ac.netClient == nil || ac.App.CurrentUser == nil {
+		return
+	}
+	username := ac.App.CurrentUser.Username
+	ac.netClient.SetPrivacy(ac.privacy, username, ac.App.GetUserColorTag(username))
+	ac.syncEncryption()
+}
+
+// setDelivery records a delivery state change of an own message and redraws
+// its line. A failed message gets a hint about /retry.
+// Must be called from the tview event loop.
+func (ac *AppController) setDelivery(localID string, state models.DeliveryState) {
+	msg := ac.findMessage(localID)
+	if msg == nil {
+		return // cleared in the meantime
+	}
+	msg.Delivery = state
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.UpdateMessage(msg)
+	}
+	if state == models.DeliveryFailed {
+		ac.sendSystem("A message was not delivered — type /retry to send it again.")
+	}
+}
+
+// findMessage looks up a message held in AppState by ID, newest first.
+func (ac *AppController) findMessage(id string) *models.Message {
+	for i := len(ac.App.Messages) - 1; i >= 0; i-- {
+		if ac.App.Messages[i].ID == id {
+			return ac.App.Messages[i]
+		}
+	}
+	return nil
+}
+
+// retryFailed resends every own message whose delivery failed, oldest first.
+// Must be called from the tview event loop.
+func (ac *AppController) retryFailed() {
+	if ac.netClient == nil {
+		ac.sendSystem("Not connected.")
+		return
+	}
+	n := 0
+	for _, msg := range ac.App.Messages {
+		if msg.Delivery != models.DeliveryFailed {
+			continue
+		}
+		msg.Delivery = models.DeliverySending
+		if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+			chat.UpdateMessage(msg)
+		}
+		switch {
+		case msg.Forwarded != nil:
+			ac.sendForward(ac.netClient, msg)
+		case msg.To != "":
+			ac.sendDirect(ac.netClient, msg)
+		case msg.Action:
+			ac.netClient.SendAction(msg.ID, msg.Username, msg.Content, msg.Color)
+		case msg.ReplyTo != "":
+			ac.netClient.SendReply(msg.ID, msg.Username, msg.Content, msg.Color, msg.ReplyTo)
+		default:
+			ac.netClient.SendMessage(msg.ID, msg.Username, msg.Content, msg.Color)
+		}
+		n++
+	}
+	if n == 0 {
+		ac.sendSystem("Nothing to retry — all messages were delivered.")
+		return
+	}
+	ac.sendSystem(fmt.Sprintf("Retrying %d message(s)…", n))
+}
+
+// loadInitialHistory shows the room's recent backlog, then starts the poll
+// loop right after it so the backlog isn't replayed as live messages.
+// With resume (see resume.go) the backlog reaches back to the last run's
+// cursor and the new messages are marked. Runs off the event loop.
+func (ac *AppController) loadInitialHistory(nc *NetworkClient, resume *resumeState) {
+	msgs, more, err := nc.FetchHistory("", historyPageSize)
+	if err == nil && resume != nil {
+		msgs, more = fillGap(nc, msgs, more, resume.LastID)
+	}
+	ac.app.QueueUpdateDraw(func() {
+		if ac.netClient != nc {
+			return // stopped or replaced (/server) while the fetch was running
+		}
+		if err != nil {
+			log.Printf("history: %v", err)
+			ac.sendSystem(fmt.Sprintf("History unavailable: %s", tview.Escape(err.Error())))
+		} else {
+			if resume != nil {
+				msgs = ac.markUnread(msgs, resume.LastID)
+			}
+			ac.showHistoryPage(msgs, more)
+			if len(msgs) > 0 {
+				nc.ResumeAfter(msgs[len(msgs)-1].ID, msgs[len(msgs)-1].Seq)
+			}
+		}
+		ac.showNotices()
+		// Start polling only after SetMessages is queued, so no live message
+		// can be rendered first and then wiped by the bulk load.
+		nc.Start()
+	})
+}
+
+// loadOlderHistory fetches the page before the oldest loaded message (/history).
+// Must be called from the tview event loop.
+func (ac *AppController) loadOlderHistory() {
+	nc := ac.netClient
+	switch {
+	case nc == nil:
+		ac.sendSystem("Not connected.")
+		return
+	case ac.historyLoading:
+		return
+	case !ac.historyMore:
+		ac.sendSystem("No older messages on the server.")
+		return
+	}
+	ac.historyLoading = true
+	before := ac.historyBefore
+	go func() {
+		msgs, more, err := nc.FetchHistory(before, historyPageSize)
+		ac.app.QueueUpdateDraw(func() {
+			ac.historyLoading = false
+			if ac.netClient != nc {
+				return
+			}
+			if err != nil {
+				ac.sendSystem(fmt.Sprintf("History unavailable: %s", tview.Escape(err.Error())))
+				return
+			}
+			ac.showHistoryPage(msgs, more)
+		})
+	}()
+}
+
+// showHistoryPage puts a page of older messages in front of everything shown
+// so far and re-renders the chat. Must be called from the tview event loop.
+func (ac *AppController) showHistoryPage(msgs []*models.Message, more bool) {
+	for _, m := range msgs {
+		ac.seen.Observe(m.ID)
+		ac.files.note(m)
+		ac.replies.quote(m)
+		ac.replies.note(m)
+		m.Reactions = ac.reactions.get(m.ID)
+	}
+	if len(msgs) > 0 {
+		ac.historyBefore = msgs[0].ID
+	}
+	ac.historyMore = more && len(msgs) > 0
+	ac.App.PrependMessages(msgs)
+	ac.showVisibleMessages()
+	if ac.historyMore {
+		ac.sendSystem("Older messages available — type /history to load more.")
+	}
+}
+
+func (ac *AppController) statsPollerLoop() {
+	// Poll /api/stats every 8 seconds and push results to the chat header.
+	// Runs as a goroutine alongside the poll loop; stops when netClient stops.
+	ticker := time.NewTicker(8 * time.Second)
+	defer ticker.Stop()
+
+	// Fetch once immediately so header shows data before the first tick.
+	ac.fetchAndPushStats()
+	ac.fetchAndPushPresence()
+	ac.fetchAndPushPins()
+
+	for {
+		select {
+		case <-ticker.C:
+			if ac.netClient == nil {
+				return
+			}
+			ac.fetchAndPushStats()
+			ac.fetchAndPushPresence()
+			ac.fetchAndPushPins()
+		}
+	}
+}
+
+func (ac *AppController) fetchAndPushStats() {
+	if ac.netClient == nil {
+		return
+	}
+	stats, err := ac.netClient.FetchStats()
+	if err != nil {
+		return // non-critical — silently skip bad fetches
+	}
+	chat, ok := ac.Views[models.ScreenChat].(*views.ChatView)
+	if !ok {
+		return
+	}
+	maxMsgs := stats.ChatStats.BufferCapacity
+	if maxMsgs == 0 {
+		maxMsgs = stats.ChatStats.MaxWaiters // older relays don't send it; both were 1000
+	}
+	chat.UpdateStats(
+		stats.ChatStats.TotalMessages,
+		stats.ActiveClients,
+		stats.ChatStats.WaitingClients,
+		maxMsgs,
+		stats.ChatStats.MaxWaiters,
+		ac.netClient.ServerURL(),
+	)
+}
+
+// fetchAndPushPresence refreshes the online list in AppState and the chat
+// sidebar. Runs on the stats poller goroutine.
+func (ac *AppController) fetchAndPushPresence() {
+	nc := ac.netClient
+	if nc == nil {
+		return
+	}
+	presence, err := nc.FetchPresence()
+	if err != nil {
+		log.Printf("presence: %v", err)
+		return // non-critical — keep showing the last known list
+	}
+	online := make([]*models.User, len(presence))
+	for i, p := range presence {
+		ac.checkPeerKey(nc, p.Username)
+		online[i] = &models.User{
+			Username: p.Username,
+			Color:    models.GetUsernameColor(p.Username),
+			IsOnline: true,
+			LastSeen: p.LastSeen,
+		}
+		if p.LastActive != nil {
+			online[i].LastActive = *p.LastActive
+		}
+	}
+	ac.app.QueueUpdateDraw(func() {
+		ac.App.SetPresence(online)
+		if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+			chat.SetOnlineUsers(ac.App.OnlineUsers())
+		}
+	})
+}
+
+// noteNetworkMode tells the user when relay traffic doesn't go straight to
+// the relay. Must be called from the tview event loop.
+func (ac *AppController) noteNetworkMode() {
+	if onionMode() {
+		ac.sendSystem("Hidden-service mode — relay traffic goes through Tor, the latency probe is off.")
+	} else if proxy := ActiveProxy(); proxy != "" {
+		ac.sendSystem(fmt.Sprintf("Proxy — relay traffic goes through [cyan]%s[-], the latency probe is off.", tview.Escape(proxy)))
+	}
+}
+
+func (ac *AppController) stopNetworkClient() {
+	if ac.netClient != nil {
+		ac.netClient.Stop()
+		ac.netClient = nil
+	}
+}
+
+func (ac *AppController) startLatencyController() {
+	if ac.latencyCtrl != nil {
+		ac.latencyCtrl.Stop()
+		ac.latencyCtrl = nil
+	}
+	if onionMode() || ActiveProxy() != "" {
+		// The probe dials 1.1.1.1 directly — off limits for a hidden
+		// service, and it would bypass the proxy.
+		if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+			chat.UpdateLatency(-1)
+		}
+		return
+	}
+	ac.latencyCtrl = NewLatencyController()
+	ac.latencyCtrl.Start(func(ms int) {
+		ac.App.Latency = ms
+		if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+			chat.UpdateLatency(ms)
+		}
+	})
+}
+
+// StopBot stops all background services: network client and latency controller.
+func (ac *AppController) StopBot() {
+	ac.stopNetworkClient()
+	if ac.latencyCtrl != nil {
+		ac.latencyCtrl.Stop()
+		ac.latencyCtrl = nil
+	}
+}
@@ -0,0 +1,294 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"cli-client/config"
+	"cli-client/models"
+)
+
+// ── Bots ──────────────────────────────────────────────────────────────────────
+//
+// "./client bots" runs bots without a screen, each logged in as its own
+// user like pipe mode (see pipe.go). A bot answers the room's messages that
+// match a pattern and posts on a schedule. Go code builds one with NewBot,
+// On and Every; bots.json next to config.json (or --file) defines them
+// without code:
+//
+//	{"bots": [{"user": "ci-bot", "room": "dev", "password_env": "CI_BOT_PASSWORD",
+//	           "on":    [{"match": "^!deploy (\\w+)$", "reply": "Deploying $1 for {user}"}],
+//	           "every": [{"interval": "24h", "post": "Stand-up in 10 minutes"}]}]}
+//
+// Answers are replies to the message they answer. A bot never answers
+// itself or another bot of the same run, so two can't keep each other
+// talking; direct messages and files are left alone.
+
+// minBotInterval is the shortest schedule a bot may post on.
+const minBotInterval = time.Minute
+
+// BotFunc answers a message that matched a bot's pattern. groups are the
+// pattern's submatches, groups[0] the whole match. "" sends nothing. It runs
+// on the bot's poll goroutine, so it should be quick.
+type BotFunc func(msg *models.Message, groups []string) string
+
+type botHandler struct {
+	re *regexp.Regexp
+	fn BotFunc
+}
+
+type botTimer struct {
+	every time.Duration
+	fn    func() string
+}
+
+// Bot is one bot: the user it logs in as, its room and what it does there.
+type Bot struct {
+	User        string
+	Room        string // "" = models.DefaultRoom
+	PasswordEnv string // the variable its password is in, "" = $TTC_PASSWORD
+
+	handlers []botHandler
+	timers   []botTimer
+}
+
+// NewBot creates a bot that logs in as user and stays in room.
+func NewBot(user, room string) *Bot {
+	if room == "" {
+		room = models.DefaultRoom
+	}
+	return &Bot{User: user, Room: room}
+}
+
+// On makes the bot answer messages matching pattern with what fn returns.
+// The first handler whose pattern matches answers; the others don't.
+func (b *Bot) On(pattern string, fn BotFunc) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("pattern %q: %w", pattern, err)
+	}
+	b.handlers = append(b.handlers, botHandler{re: re, fn: fn})
+	return nil
+}
+
+// Every makes the bot post what fn returns every interval, the first time
+// one interval after it started. "" posts nothing that time.
+func (b *Bot) Every(interval time.Duration, fn func() string) error {
+	if interval < minBotInterval {
+		return fmt.Errorf("interval %s is shorter than %s", interval, minBotInterval)
+	}
+	b.timers = append(b.timers, botTimer{every: interval, fn: fn})
+	return nil
+}
+
+// answer returns what the bot says to msg, "" if no pattern matched.
+func (b *Bot) answer(msg *models.Message) (reply string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PANIC in bot %s handler: %v", b.User, r)
+			reply = ""
+		}
+	}()
+	for _, h := range b.handlers {
+		if groups := h.re.FindStringSubmatch(msg.Content); groups != nil {
+			return h.fn(msg, groups)
+		}
+	}
+	return ""
+}
+
+// ── bots.json ─────────────────────────────────────────────────────────────────
+
+// botFile is bots.json.
+type botFile struct {
+	Bots []struct {
+		User        string `json:"user"`
+		Room        string `json:"room"`
+		PasswordEnv string `json:"password_env"`
+		On          []struct {
+			Match string `json:"match"`
+			Reply string `json:"reply"`
+		} `json:"on"`
+		Every []struct {
+			Interval string `json:"interval"`
+			Post     string `json:"post"`
+		} `json:"every"`
+	} `json:"bots"`
+}
+
+// LoadBots reads the bots defined in path; "" is bots.json next to
+// config.json. In a reply, $1 or ${name} is what the pattern's group
+// matched ($$ a dollar sign), {user} the sender and {room} the room.
+func LoadBots(path string) ([]*Bot, error) {
+	if path == "" {
+		var err error
+		if path, err = config.BotsPath(); err != nil {
+			return nil, err
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file botFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(file.Bots) == 0 {
+		return nil, fmt.Errorf("%s: no bots defined", path)
+	}
+
+	bots := make([]*Bot, 0, len(file.Bots))
+	for i, def := range file.Bots {
+		if def.User == "" {
+			return nil, fmt.Errorf("%s: bot %d has no user", path, i+1)
+		}
+		b := NewBot(def.User, def.Room)
+		b.PasswordEnv = def.PasswordEnv
+		for _, on := range def.On {
+			if err := b.On(on.Match, replyTemplate(b.Room, on.Match, on.Reply)); err != nil {
+				return nil, fmt.Errorf("%s: bot %s: %w", path, def.User, err)
+			}
+		}
+		for _, every := range def.Every {
+			interval, err := time.ParseDuration(every.Interval)
+			if err == nil {
+				post := every.Post
+				err = b.Every(interval, func() string { return post })
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s: bot %s: every: %w", path, def.User, err)
+			}
+		}
+		if len(b.handlers) == 0 && len(b.timers) == 0 {
+			return nil, fmt.Errorf("%s: bot %s has nothing to do — add \"on\" or \"every\"", path, def.User)
+		}
+		bots = append(bots, b)
+	}
+	return bots, nil
+}
+
+// replyTemplate returns the BotFunc for a reply from bots.json.
+func replyTemplate(room, pattern, reply string) BotFunc {
+	re := regexp.MustCompile(pattern) // On has compiled it already
+	return func(msg *models.Message, _ []string) string {
+		tmpl := strings.NewReplacer("{user}", msg.Username, "{room}", room).Replace(reply)
+		match := re.FindStringSubmatchIndex(msg.Content)
+		if match == nil {
+			return ""
+		}
+		return string(re.ExpandString(nil, tmpl, msg.Content, match))
+	}
+}
+
+// ── Runner ────────────────────────────────────────────────────────────────────
+
+// BotRunner runs bots against the relay until stopped.
+type BotRunner struct {
+	headless
+	bots []*Bot
+}
+
+// NewBotRunner creates a runner that reports problems to errw.
+func NewBotRunner(errw io.Writer) *BotRunner {
+	return &BotRunner{headless: headless{errw: errw}}
+}
+
+// Add adds bots to run. Call before Run.
+func (r *BotRunner) Add(bots ...*Bot) {
+	r.bots = append(r.bots, bots...)
+}
+
+// Run logs every bot in and runs them until ctx is done. A bot that can't
+// log in, or whose room requires encryption when there is no passphrase,
+// stops the run.
+func (r *BotRunner) Run(ctx context.Context) error {
+	if len(r.bots) == 0 {
+		return errors.New("no bots to run")
+	}
+	isBot := make(map[string]bool, len(r.bots))
+	for _, b := range r.bots {
+		isBot[strings.ToLower(b.User)] = true
+	}
+
+	var clients []*NetworkClient
+	defer func() {
+		for _, nc := range clients {
+			nc.Stop()
+		}
+	}()
+	var wg sync.WaitGroup
+	for _, b := range r.bots {
+		env := b.PasswordEnv
+		if env == "" {
+			env = PipePasswordEnv
+		}
+		password := os.Getenv(env)
+		if password == "" {
+			return fmt.Errorf("bot %s: $%s is not set", b.User, env)
+		}
+		// Without a passphrase the room key is the one every client has.
+		if r.e2eRooms[strings.ToLower(b.Room)] && r.passphrase == "" {
+			return fmt.Errorf("bot %s: room %s requires encryption and config.json has no passphrase", b.User, b.Room)
+		}
+
+		b := b
+		var nc *NetworkClient
+		var color string
+		onMessage := func(msg *models.Message) {
+			if isBot[strings.ToLower(msg.Username)] || msg.To != "" || msg.File != nil {
+				return
+			}
+			if reply := b.answer(msg); reply != "" {
+				nc.SendReply(models.NewMessage(b.User, reply).ID, b.User, reply, color, msg.ID)
+			}
+		}
+		onDelivery := func(localID string, state models.DeliveryState) {
+			if state == models.DeliveryFailed {
+				r.note("%s: a message was refused by the relay", b.User)
+			}
+		}
+		var session *Session
+		var err error
+		nc, session, err = r.connect(b.User, password, b.Room, onMessage, onDelivery)
+		if err != nil {
+			return fmt.Errorf("bot %s: %w", b.User, err)
+		}
+		color = models.NewMessage(session.Username, "").Color
+		nc.Start()
+		clients = append(clients, nc)
+		log.Printf("bots: %s in %s, %d handler(s), %d timer(s)", b.User, b.Room, len(b.handlers), len(b.timers))
+
+		for _, t := range b.timers {
+			wg.Add(1)
+			go func(t botTimer) {
+				defer wg.Done()
+				ticker := time.NewTicker(t.every)
+				defer ticker.Stop()
+				for {
+					select {
+					case <-ctx.Done():
+						return
+					case <-ticker.C:
+						if post := t.fn(); post != "" {
+							nc.SendMessage(models.NewMessage(b.User, post).ID, b.User, post, color)
+						}
+					}
+				}
+			}(t)
+		}
+	}
+	r.note("%d bot(s) running", len(r.bots))
+	<-ctx.Done()
+	wg.Wait()
+	return nil
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"cli-client/models"
+)
+
+// ── Send coalescing ───────────────────────────────────────────────────────────
+//
+// One send is in flight at a time. Messages sent meanwhile — a pasted block
+// of text arrives as one Enter per line — wait in the outbox and go out
+// together through /api/send/batch once it returns: one round trip, and one
+// request against the relay's rate limit, instead of one per line. A lone
+// message is not held back, and the order never changes.
+//
+// Relays from before batches answer 404; the client then sends one message
+// per request, as it always did.
+
+// maxBatch is the most messages sent in one request, the relay's
+// MaxBatchSize.
+const maxBatch = 20
+
+// sendBatchRequest is the /api/send/batch body.
+type sendBatchRequest struct {
+	Room     string        `json:"room"`
+	Messages []sendRequest `json:"messages"`
+}
+
+// sendBatchResponse is the /api/send/batch answer: the IDs of the messages
+// sent, in order, and why the one after them was refused, if any was.
+type sendBatchResponse struct {
+	Status string   `json:"status"`
+	IDs    []string `json:"ids"`
+	Failed *struct {
+		Status int    `json:"status"`
+		Error  string `json:"error"`
+	} `json:"failed"`
+}
+
+// coalesce adds m to the outbox and starts a sendLoop if none is running.
+func (nc *NetworkClient) coalesce(m outboundMessage) {
+	nc.outMu.Lock()
+	nc.outbox = append(nc.outbox, m)
+	start := !nc.sending
+	nc.sending = true
+	nc.outMu.Unlock()
+	if start {
+		go nc.sendLoop()
+	}
+}
+
+// sendLoop sends the outbox, up to maxBatch messages per request, until it
+// is empty. Once a send has to wait for the relay, the rest of the outbox
+// joins the offline queue behind it.
+func (nc *NetworkClient) sendLoop() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PANIC NetworkClient.sendLoop: %v", r)
+			nc.outMu.Lock()
+			nc.sending = false
+			nc.outMu.Unlock()
+		}
+	}()
+
+	for {
+		nc.outMu.Lock()
+		if len(nc.outbox) == 0 || atomic.LoadInt32(&nc.stopped) == 1 {
+			nc.sending = false
+			nc.outMu.Unlock()
+			return
+		}
+		n := len(nc.outbox)
+		if n > maxBatch {
+			n = maxBatch
+		}
+		batch := append([]outboundMessage(nil), nc.outbox[:n]...)
+		nc.outbox = nc.outbox[n:]
+		nc.outMu.Unlock()
+
+		if nc.PendingCount() > 0 {
+			for _, m := range batch {
+				nc.enqueue(m)
+			}
+			go nc.flushPending()
+			continue
+		}
+		nc.sendBatch(batch)
+	}
+}
+
+// sendBatch sends batch, in one request when there is more than one
+// message, and reports what became of each.
+func (nc *NetworkClient) sendBatch(batch []outboundMessage) {
+	if len(batch) == 1 || atomic.LoadInt32(&nc.noBatch) == 1 {
+		for _, m := range batch {
+			if nc.PendingCount() > 0 {
+				nc.enqueue(m)
+				continue
+			}
+			nc.settle(m, nc.deliver(m))
+		}
+		return
+	}
+
+	// Messages that must not leave at all fail now and stay out of the batch.
+	req := sendBatchRequest{Room: nc.room}
+	var sendable []outboundMessage
+	for _, m := range batch {
+		body, ok := nc.wireRequest(m)
+		if !ok {
+			nc.setDelivery(m.localID, models.DeliveryFailed)
+			continue
+		}
+		req.Messages = append(req.Messages, body)
+		sendable = append(sendable, m)
+	}
+	if len(sendable) == 0 {
+		return
+	}
+
+	sent, outcome, ok := nc.deliverBatch(req, sendable)
+	if !ok {
+		log.Printf("TRACE sendBatch: relay has no /api/send/batch, sending one by one")
+		atomic.StoreInt32(&nc.noBatch, 1)
+		nc.sendBatch(sendable)
+		return
+	}
+	for _, m := range sendable[:sent] {
+		nc.setDelivery(m.localID, models.DeliverySent)
+	}
+	if sent == len(sendable) {
+		return
+	}
+	nc.settle(sendable[sent], outcome)
+
+	// The relay stopped at the refused message. After a refusal for good
+	// the rest is tried again; otherwise it waits in the queue behind it.
+	rest := sendable[sent+1:]
+	if outcome == sendRetry {
+		for _, m := range rest {
+			nc.enqueue(m)
+		}
+		return
+	}
+	nc.outMu.Lock()
+	nc.outbox = append(append([]outboundMessage(nil), rest...), nc.outbox...)
+	nc.outMu.Unlock()
+}
+
+// deliverBatch POSTs req to /api/send/batch. It returns how many messages
+// were sent and what should happen to the next one; ok is false if the
+// relay has no batch endpoint.
+func (nc *NetworkClient) deliverBatch(req sendBatchRequest, batch []outboundMessage) (sent int, outcome sendOutcome, ok bool) {
+	contentType, body, err := nc.encodeBody(req)
+	if err != nil {
+		log.Printf("TRACE deliverBatch: marshal error: %v", err)
+		return 0, sendRejected, true
+	}
+
+	path := nc.messagePath("send/batch")
+	log.Printf("TRACE deliverBatch: POST %s%s (%d messages)", nc.serverURL, path, len(batch))
+	resp, err := nc.relayPost(nc.httpClient, path, nil, contentType, body)
+	if err != nil {
+		log.Printf("TRACE deliverBatch: POST error: %v", err)
+		return 0, sendRetry, true
+	}
+	defer resp.Body.Close()
+	log.Printf("TRACE deliverBatch: POST status=%d", resp.StatusCode)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed:
+		return 0, 0, false
+	default:
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return 0, nc.refused(resp.StatusCode, strings.TrimSpace(string(raw))), true
+	}
+
+	var br sendBatchResponse
+	if err := nc.decodeBody(resp, &br); err != nil {
+		// Sent, but the IDs are lost: the echoes show up twice at worst.
+		log.Printf("TRACE deliverBatch: decode error: %v", err)
+		return len(batch), sendDelivered, true
+	}
+	for i, id := range br.IDs {
+		if i < len(batch) {
+			nc.recordSent(batch[i], id)
+		}
+	}
+	sent = len(br.IDs)
+	if sent > len(batch) {
+		sent = len(batch)
+	}
+	if br.Failed == nil || sent == len(batch) {
+		return len(batch), sendDelivered, true
+	}
+	return sent, nc.refused(br.Failed.Status, br.Failed.Error), true
+}
+
+// settle reports the outcome of sending m: sent, queued to try again, or
+// failed.
+func (nc *NetworkClient) settle(m outboundMessage, outcome sendOutcome) {
+	switch outcome {
+	case sendDelivered:
+		nc.setDelivery(m.localID, models.DeliverySent)
+	case sendRetry:
+		nc.enqueue(m)
+	case sendRejected:
+		nc.setDelivery(m.localID, models.DeliveryFailed)
+	}
+}
@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"container/list"
+	"sync"
+)
+
+// seenIDCapacity bounds how many recent message IDs are remembered for
+// de-duplication. Duplicates arrive close together (retries, a replayed poll
+// window), so a few hundred entries cover them without growing forever.
+const seenIDCapacity = 512
+
+// seenIDs is a bounded LRU set of recently delivered message IDs.
+// It is fed from the poll goroutine, so every access goes through mu.
+type seenIDs struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List               // front = most recently seen
+	index    map[string]*list.Element // id → element in order
+}
+
+func newSeenIDs(capacity int) *seenIDs {
+	return &seenIDs{
+		capacity: capacity,
+		order:    list.New(),
+		index:    make(map[string]*list.Element, capacity),
+	}
+}
+
+// Observe records id and reports whether it had already been seen.
+// A repeat sighting refreshes the entry so it stays in the window.
+func (s *seenIDs) Observe(id string) (duplicate bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.index[id]; ok {
+		s.order.MoveToFront(el)
+		return true
+	}
+
+	s.index[id] = s.order.PushFront(id)
+	if s.order.Len() > s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.index, oldest.Value.(string))
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"cli-client/models"
+	"cli-client/views"
+)
+
+// ── Device code sign-in ───────────────────────────────────────────────────────
+//
+// A relay started with -auth oidc leaves passwords to the organization's
+// identity provider. Its /api/motd says "auth": "oidc", and the login
+// screen then asks for no username or password: the client starts the
+// device code flow, shows the address and code to enter in a browser on
+// any device, and polls the relay until the user approved the sign-in.
+// The username is the one the provider knows the user by.
+
+// AuthDevice is the /api/motd "auth" of a relay that signs in through an
+// identity provider.
+const AuthDevice = "oidc"
+
+// DeviceLogin is a started sign-in, as POST /api/auth/device answers it.
+type DeviceLogin struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+	Issuer                  string `json:"issuer"`
+}
+
+// errSignInPending is PollDeviceLogin's answer until the user approved the
+// sign-in; errSignInSlowDown asks to poll less often.
+var (
+	errSignInPending  = errors.New("sign-in not approved yet")
+	errSignInSlowDown = errors.New("polling too fast")
+)
+
+// StartDeviceLogin asks the relay to start a device code sign-in.
+func StartDeviceLogin(serverURL, clientID string) (*DeviceLogin, error) {
+	resp, err := postAccess(serverURL+"/api/auth/device", map[string]string{"client_id": clientID})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, credentialsError(resp)
+	}
+
+	var dl DeviceLogin
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 16<<10)).Decode(&dl); err != nil || dl.DeviceCode == "" {
+		return nil, fmt.Errorf("relay sent no sign-in code")
+	}
+	return &dl, nil
+}
+
+// PollDeviceLogin asks whether the user approved the sign-in, publishing
+// keys with the session once they did.
+func PollDeviceLogin(serverURL, clientID, deviceCode string, keys PublishedKeys) (*Session, error) {
+	resp, err := postAccess(serverURL+"/api/auth/device/token", map[string]interface{}{
+		"client_id":    clientID,
+		"device_code":  deviceCode,
+		"identity_key": keys.IdentityKey,
+		"dh_key":       keys.DHKey,
+		"dh_key_sig":   keys.DHKeySig,
+		"protocol":     clientProtocol,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return decodeSession(resp)
+	case http.StatusPreconditionRequired:
+		return nil, errSignInPending
+	case http.StatusTooManyRequests:
+		return nil, errSignInSlowDown
+	default:
+		return nil, credentialsError(resp)
+	}
+}
+
+// postAccess posts body as JSON, signed with the access key like a login.
+func postAccess(endpoint string, body interface{}) (*http.Response, error) {
+	log.Printf("TRACE postAccess: POST %s", endpoint)
+	bodyJSON, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("could not build request: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(bodyJSON))
+	if err != nil {
+		return nil, fmt.Errorf("could not build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signRequest(req, accessSigningKey(serverAccessKey), bodyJSON)
+
+	resp, err := relayClient(20 * time.Second).Do(req)
+	if err != nil {
+		log.Printf("TRACE postAccess: error: %v", err)
+		if errors.Is(err, ErrPinMismatch) {
+			return nil, fmt.Errorf("relay certificate does not match the pin — not connecting")
+		}
+		return nil, fmt.Errorf("relay server not reachable")
+	}
+	return resp, nil
+}
+
+// DetectLogin asks the relay how it logs users in and sets the login
+// screen up for it. Call it before the login screen opens; a relay that
+// doesn't answer gets the password login.
+func (ac *AppController) DetectLogin() {
+	motd, err := FetchMotd(DefaultServerURL)
+	if err != nil {
+		log.Printf("TRACE DetectLogin: %v", err)
+		return
+	}
+	ac.deviceLogin = motd.Auth == AuthDevice
+	if login, ok := ac.Views[models.ScreenLogin].(*views.LoginView); ok {
+		login.SetDeviceLogin(ac.deviceLogin)
+	}
+}
+
+// deviceSignIn runs a device code sign-in to the end: it shows the code on
+// the login screen and polls until the user approved or the code expired.
+// Called off the event loop.
+func (ac *AppController) deviceSignIn() (*Session, error) {
+	dl, err := StartDeviceLogin(DefaultServerURL, ac.clientID)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("TRACE deviceSignIn: code issued by %s, expires in %ds", dl.Issuer, dl.ExpiresIn)
+	ac.app.QueueUpdateDraw(func() {
+		if login, ok := ac.Views[models.ScreenLogin].(*views.LoginView); ok {
+			login.ShowDeviceCode(dl.Issuer, dl.VerificationURI, dl.VerificationURIComplete, dl.UserCode)
+		}
+	})
+
+	interval := time.Duration(max(dl.Interval, 1)) * time.Second
+	deadline := time.Now().Add(time.Duration(dl.ExpiresIn) * time.Second)
+	if dl.ExpiresIn <= 0 {
+		deadline = time.Now().Add(15 * time.Minute)
+	}
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		session, err := PollDeviceLogin(DefaultServerURL, ac.clientID, dl.DeviceCode, ac.publishedKeys())
+		switch {
+		case errors.Is(err, errSignInPending):
+			continue
+		case errors.Is(err, errSignInSlowDown):
+			// RFC 8628: back off by five seconds for good.
+			interval += 5 * time.Second
+			continue
+		}
+		return session, err
+	}
+	return nil, errors.New("the sign-in code expired — try again")
+}
@@ -0,0 +1,187 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"cli-client/audit"
+	"cli-client/crypto"
+	"cli-client/models"
+	"cli-client/trust"
+	"cli-client/views"
+
+	"github.com/rivo/tview"
+)
+
+// ── Direct messages ───────────────────────────────────────────────────────────
+//
+// /msg <user> <text> seals text to the user's X25519 key (crypto/direct.go)
+// and relays it with "to" set, so the relay hands it only to that user. The
+// key comes from /api/keys and is used only if it is signed by the identity
+// key pinned for the user — a key the relay swapped is refused.
+
+// directSealedText stands in for an own direct message seen again (history,
+// another session): it was sealed for the recipient only.
+const directSealedText = "(sealed for the recipient — not readable here)"
+
+// SetIdentity tells nc who the user is and the identity key direct messages
+// to them are opened with; id may be nil. Call before Start.
+func (nc *NetworkClient) SetIdentity(username string, id *crypto.Identity) {
+	nc.self = username
+	nc.identity = id
+}
+
+// SendDirect relays a direct message to to, already sealed with
+// crypto.SealDirect. Delivery is reported like for SendMessage.
+func (nc *NetworkClient) SendDirect(localID, username, to, sealed, colorTag string) {
+	if atomic.LoadInt32(&nc.stopped) == 1 {
+		return
+	}
+	log.Printf("TRACE NetworkClient.SendDirect: user=%q to=%q", username, to)
+	nc.send(outboundMessage{localID: localID, username: username, to: to, content: sealed, colorTag: colorTag, sealed: true})
+}
+
+// openEntry returns the text of a polled or history entry: direct messages
+// are opened with the identity key, everything else by openContent.
+func (nc *NetworkClient) openEntry(msg *pollMessage) (text string, keep bool) {
+	defer func() { nc.countOpened(text, keep) }()
+	if msg.To == "" || !crypto.IsDirect(msg.Content) {
+		return nc.openContent(msg.Username, msg.Content)
+	}
+	if msg.Username == nc.self {
+		return directSealedText, true
+	}
+	if nc.identity == nil {
+		return undecryptableText, true
+	}
+	text, err := nc.identity.OpenDirect(msg.Content)
+	if err != nil {
+		nc.reportSecurity(audit.Event{Kind: audit.DecryptFailure, Peer: msg.Username, Detail: "direct message: " + err.Error()})
+		return undecryptableText, true
+	}
+	return text, true
+}
+
+// handleMsg implements /msg:
+//
+//	/msg                 list who can receive direct messages
+//	/msg <user> <text>   send text to user only, end-to-end encrypted
+//
+// Must be called from the tview event loop; key lookups run in the background.
+func (ac *AppController) handleMsg(arg string) {
+	nc := ac.netClient
+	if nc == nil || ac.session == nil {
+		ac.sendSystem("Not connected.")
+		return
+	}
+	if ac.identity == nil {
+		ac.sendSystem("No identity key loaded — direct messages need one. See the note at login.")
+		return
+	}
+
+	to, text, _ := strings.Cut(arg, " ")
+	text = strings.TrimSpace(text)
+	switch {
+	case to == "":
+		ac.listDirectRecipients(nc)
+		return
+	case text == "":
+		ac.sendSystem("Usage: /msg <user> <text>")
+		return
+	case to == ac.session.Username:
+		ac.sendSystem("That's you — pick someone else.")
+		return
+	}
+
+	msg := models.NewMessage(ac.session.Username, text)
+	msg.To = to
+	msg.Color = ac.App.GetUserColorTag(ac.session.Username)
+	msg.Delivery = models.DeliverySending
+	ac.App.AddMessage(msg)
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.AddMessage(msg)
+		ac.rememberSent(chat, "/msg "+arg)
+	}
+	ac.sendDirect(nc, msg)
+}
+
+// sendDirect seals msg for msg.To and relays it. A recipient without a
+// usable key marks msg failed. Must be called from the tview event loop.
+func (ac *AppController) sendDirect(nc *NetworkClient, msg *models.Message) {
+	localID, username, to, text, color := msg.ID, msg.Username, msg.To, msg.Content, msg.Color
+	go func() {
+		sealed, err := ac.sealDirect(nc, to, text)
+		if err != nil {
+			log.Printf("TRACE sendDirect: to=%q: %v", to, err)
+			ac.app.QueueUpdateDraw(func() {
+				ac.sendSystem(fmt.Sprintf("Direct message to %s not sent: %s", tview.Escape(to), tview.Escape(err.Error())))
+				ac.setDelivery(localID, models.DeliveryFailed)
+			})
+			return
+		}
+		nc.SendDirect(localID, username, to, sealed, color)
+	}()
+}
+
+// sealDirect fetches to's keys, checks them against the pinned identity key
+// and seals text. Runs off the event loop.
+func (ac *AppController) sealDirect(nc *NetworkClient, to, text string) (string, error) {
+	keys, err := nc.FetchKeys(to)
+	if errors.Is(err, ErrNoIdentityKey) {
+		return "", errors.New("they have no published keys")
+	}
+	if err != nil {
+		return "", err
+	}
+	if keys.DHKey == "" {
+		return "", errors.New("their client does not support encrypted direct messages yet")
+	}
+	if err := crypto.VerifyDHKey(keys.IdentityKey, keys.DHKey, keys.DHKeySig); err != nil {
+		return "", fmt.Errorf("the relay served a key for them that their identity key did not sign — not sending")
+	}
+
+	result, pinned, err := ac.trustStore.Check(to, keys.IdentityKey)
+	if err != nil {
+		log.Printf("trust: %s: %v", to, err)
+	}
+	switch result {
+	case trust.New:
+		log.Printf("trust: pinned %s's key %s on first use", to, pinned.Fingerprint)
+	case trust.Changed:
+		ac.warnKeyChanged(to, pinned, keys.IdentityKey)
+		return "", fmt.Errorf("their identity key changed — compare fingerprints, then /trust %s", to)
+	}
+	return crypto.SealDirect(keys.DHKey, text)
+}
+
+// listDirectRecipients shows who published a key for direct messages.
+// Must be called from the tview event loop.
+func (ac *AppController) listDirectRecipients(nc *NetworkClient) {
+	self := ac.session.Username
+	go func() {
+		dir, err := nc.FetchKeyDirectory()
+		ac.app.QueueUpdateDraw(func() {
+			if err != nil {
+				ac.sendSystem(fmt.Sprintf("Could not load the key directory: %s", tview.Escape(err.Error())))
+				return
+			}
+			var names []string
+			for _, k := range dir {
+				if k.DHKey != "" && k.Username != self {
+					names = append(names, tview.Escape(k.Username))
+				}
+			}
+			sort.Strings(names)
+			if len(names) == 0 {
+				ac.sendSystem("Nobody else can receive encrypted direct messages yet.")
+				return
+			}
+			ac.sendSystem("Direct messages can go to: " + strings.Join(names, ", "))
+			ac.sendSystem("[dim]/msg <user> <text> — sealed to their key; the relay and the room can't read it.[-]")
+		})
+	}()
+}
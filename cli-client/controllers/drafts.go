@@ -0,0 +1,200 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cli-client/models"
+	"cli-client/views"
+)
+
+// ── Synced drafts ─────────────────────────────────────────────────────────────
+//
+// With "sync_drafts" in config.json, or after /drafts on, the text in the
+// input field is saved on the relay under the account and room, a moment
+// after typing stops. Logging in on another device puts it back into an
+// empty input field. Sending the message, clearing the field or /wipe
+// deletes it. Commands are never saved.
+//
+// Off by default: the relay can read a draft like any message that isn't
+// encrypted. In privacy mode and in rooms that require encryption the
+// draft is encrypted with the room key before it leaves.
+
+// draftSaveDelay is how long typing must pause before the draft is saved.
+const draftSaveDelay = 2 * time.Second
+
+// draftResponse is the /api/drafts answer.
+type draftResponse struct {
+	Text      string     `json:"text"`
+	UpdatedAt *time.Time `json:"updated_at"`
+}
+
+// FetchDraft returns the draft saved on the relay for the room, "" if none,
+// and when it was saved.
+func (nc *NetworkClient) FetchDraft() (string, time.Time, error) {
+	params := url.Values{}
+	params.Set("room", nc.room)
+
+	resp, err := nc.relayGet(relayClient(10*time.Second), "/api/drafts", params)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("drafts HTTP %d", resp.StatusCode)
+	}
+	var body draftResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", time.Time{}, fmt.Errorf("decode draft: %w", err)
+	}
+	if body.UpdatedAt == nil {
+		return body.Text, time.Time{}, nil
+	}
+	return body.Text, *body.UpdatedAt, nil
+}
+
+// SaveDraft stores text as the room's draft on the relay; "" deletes it.
+// text must already be sealed where the room requires it.
+func (nc *NetworkClient) SaveDraft(text string) error {
+	body, err := json.Marshal(map[string]string{"room": nc.room, "text": text})
+	if err != nil {
+		return err
+	}
+	resp, err := nc.relayPost(relayClient(10*time.Second), "/api/drafts", nil, "application/json", body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("drafts HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// handleDrafts implements /drafts [on|off]. Turning it off deletes the
+// draft on the relay. Must be called from the tview event loop.
+func (ac *AppController) handleDrafts(arg string) {
+	switch strings.ToLower(arg) {
+	case "":
+		state := "off — drafts stay on this device"
+		if ac.syncDrafts {
+			state = "on — the unsent text is saved on the relay for your other devices"
+		}
+		ac.sendSystem("Draft sync is " + state + ". /drafts on|off")
+	case "on":
+		ac.syncDrafts = true
+		ac.sendSystem("Draft sync on — what you type is saved on the relay a moment after you stop.")
+		ac.noteDraft(ac.draftText)
+	case "off":
+		if ac.syncDrafts && ac.draftSaved != "" {
+			text := ac.draftText
+			ac.draftText = ""
+			ac.flushDraft()
+			ac.draftText = text
+			ac.sendSystem("Draft sync off — the draft on the relay was deleted.")
+		} else {
+			ac.sendSystem("Draft sync off.")
+		}
+		ac.syncDrafts = false
+	default:
+		ac.sendSystem("Usage: /drafts [on|off]")
+	}
+}
+
+// noteDraft records the input field's text and saves it once typing pauses
+// for draftSaveDelay. Must be called from the tview event loop.
+func (ac *AppController) noteDraft(text string) {
+	if strings.HasPrefix(text, "/") {
+		text = ""
+	}
+	ac.draftText = text
+	if !ac.syncDrafts || ac.netClient == nil {
+		return
+	}
+	if ac.draftTimer == nil {
+		ac.draftTimer = time.AfterFunc(draftSaveDelay, func() { ac.app.QueueUpdate(ac.flushDraft) })
+		return
+	}
+	ac.draftTimer.Reset(draftSaveDelay)
+}
+
+// flushDraft saves the draft if it changed since the last save. Must be
+// called from the tview event loop.
+func (ac *AppController) flushDraft() {
+	nc, text := ac.netClient, ac.draftText
+	if !ac.syncDrafts || nc == nil || text == ac.draftSaved {
+		return
+	}
+	sealed, err := ac.sealDraft(nc, text)
+	if err != nil {
+		log.Printf("drafts: seal: %v", err)
+		return
+	}
+	ac.draftSaved = text
+	go func() {
+		if err := nc.SaveDraft(sealed); err != nil {
+			log.Printf("drafts: save: %v", err)
+		}
+	}()
+}
+
+// FlushDraft saves a draft still waiting for draftSaveDelay before the
+// client exits. Call once the event loop has stopped.
+func (ac *AppController) FlushDraft() {
+	nc, text := ac.netClient, ac.draftText
+	if ac.draftTimer != nil {
+		ac.draftTimer.Stop()
+	}
+	if !ac.syncDrafts || nc == nil || text == ac.draftSaved {
+		return
+	}
+	sealed, err := ac.sealDraft(nc, text)
+	if err == nil {
+		err = nc.SaveDraft(sealed)
+	}
+	if err != nil {
+		log.Printf("drafts: save on exit: %v", err)
+	}
+}
+
+// sealDraft encrypts text like a message to the room would be.
+func (ac *AppController) sealDraft(nc *NetworkClient, text string) (string, error) {
+	if text == "" || !nc.Encrypting() {
+		return text, nil
+	}
+	return ac.gc.EncryptText(text)
+}
+
+// restoreDraft loads the room's draft from the relay into the input field,
+// unless something has been typed already. Runs off the event loop.
+func (ac *AppController) restoreDraft(nc *NetworkClient) {
+	sealed, savedAt, err := nc.FetchDraft()
+	if err != nil {
+		log.Printf("drafts: load: %v", err)
+		return
+	}
+	if sealed == "" {
+		return
+	}
+	text, _ := nc.openContent(nc.self, sealed)
+	if text == undecryptableText {
+		log.Printf("drafts: the saved draft can't be opened with this room key")
+		return
+	}
+	ac.app.QueueUpdateDraw(func() {
+		if ac.netClient != nc || ac.draftText != "" {
+			return
+		}
+		chat, ok := ac.Views[models.ScreenChat].(*views.ChatView)
+		if !ok || !chat.SetDraft(text) {
+			return
+		}
+		ac.draftText, ac.draftSaved = text, text
+		ac.sendSystem(fmt.Sprintf("[dim]Restored your unsent draft from %s.[-]", savedAt.Local().Format("Jan 2 15:04")))
+	})
+}
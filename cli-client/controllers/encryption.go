@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"cli-client/models"
+	"cli-client/views"
+)
+
+// ── Per-room encryption ───────────────────────────────────────────────────────
+//
+// A room can be marked E2E-required ("e2e_rooms" in config.json, or
+// /encrypt on). Messages sent there are always encrypted, and one that would
+// leave as plain text is refused rather than sent. Privacy mode encrypts in
+// every room. The header shows a lock while outgoing messages are encrypted
+// and the fingerprint of the key they are encrypted with.
+
+// handleEncrypt implements /encrypt: mark or unmark the current room as
+// E2E-required for this session, or explain what is protected.
+func (ac *AppController) handleEncrypt(arg string) {
+	room := strings.ToLower(ac.App.Room)
+	switch strings.ToLower(arg) {
+	case "", "status":
+		ac.encryptStatus()
+		return
+	case "on":
+		ac.e2eRooms[room] = true
+	case "off":
+		delete(ac.e2eRooms, room)
+	default:
+		ac.sendSystem("Usage: /encrypt [on|off|status]")
+		return
+	}
+	ac.syncEncryption()
+	if ac.e2eRooms[room] {
+		ac.sendSystem(fmt.Sprintf("Room #%s now [green]requires E2E[-] — plain-text messages will not be sent.", room))
+	} else {
+		ac.sendSystem(fmt.Sprintf("Room #%s no longer requires E2E.", room))
+	}
+}
+
+// encryptStatus spells out what the current encryption protects and what it
+// leaves visible, so the lock in the header is not mistaken for more.
+func (ac *AppController) encryptStatus() {
+	room := strings.ToLower(ac.App.Room)
+	required := ac.e2eRooms[room]
+
+	switch {
+	case required:
+		ac.sendSystem(fmt.Sprintf("Room #%s: [green]🔒 E2E required[-] — every message is encrypted, plain text is never sent.", room))
+	case ac.privacy:
+		ac.sendSystem(fmt.Sprintf("Room #%s: [green]🔒 encrypted[-] by privacy mode (not required — /encrypt on to require it).", room))
+	default:
+		ac.sendSystem(fmt.Sprintf("Room #%s: [yellow]🔓 plain text[-] — the relay can read your messages. /encrypt on to require encryption.", room))
+		return
+	}
+
+	suite := ac.gc.Suite().String()
+	if ac.gc.UsesBuiltinKey() {
+		ac.sendSystem(fmt.Sprintf("  [green]Protected:[-] message text, sealed with %s under the key built into every TTC client.", suite))
+	} else {
+		ac.sendSystem(fmt.Sprintf("  [green]Protected:[-] message text, sealed with %s under the room passphrase (key %s).", suite, ac.gc.Fingerprint()))
+	}
+	if ac.privacy {
+		ac.sendSystem("  [green]Protected:[-] message length (padded) and activity (decoys), see /privacy.")
+	} else {
+		ac.sendSystem("  [yellow]Visible:[-] message length — /privacy on pads messages to fixed sizes.")
+	}
+	ac.sendSystem("  [yellow]Visible:[-] your username, color, the room and when you send, to the relay and the network.")
+	if ac.gc.UsesBuiltinKey() {
+		ac.sendSystem("  [yellow]Not private from:[-] anyone running a TTC client, which holds the same key. Log in with a room passphrase to change that.")
+	} else {
+		ac.sendSystem("  [yellow]Not private from:[-] anyone who knows the room passphrase.")
+	}
+	ac.sendSystem("  [dim]Incoming encrypted messages are decrypted either way; plain-text ones from other clients are still shown.[-]")
+}
+
+// syncEncryption passes the room's E2E requirement to the network client
+// and updates the lock in the header.
+func (ac *AppController) syncEncryption() {
+	if ac.netClient == nil {
+		return
+	}
+	ac.netClient.SetE2ERequired(ac.e2eRooms[strings.ToLower(ac.App.Room)])
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.SetEncryption(ac.netClient.Encrypting(), ac.netClient.E2ERequired())
+	}
+}
+
+// noteKeyFingerprint shows which key encrypted messages use, in the header
+// and once in the chat, so users can check they typed the same passphrase.
+// Must be called from the tview event loop.
+func (ac *AppController) noteKeyFingerprint() {
+	fp, builtin := ac.gc.Fingerprint(), ac.gc.UsesBuiltinKey()
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.SetKeyFingerprint(fp, builtin)
+	}
+	if builtin {
+		ac.sendSystem("No room passphrase — encrypted messages use the key built into every TTC client.")
+		return
+	}
+	ac.sendSystem(fmt.Sprintf("Room key [cyan]🔑 %s[-] — compare it with everyone in the room; a different fingerprint means a different passphrase.", fp))
+}
+
+// usePassphrase derives the room key from passphrase. The salt in
+// config.json wins over relaySalt, the one the relay's MOTD suggests.
+// Argon2id takes a moment — call it off the event loop.
+func (ac *AppController) usePassphrase(passphrase, relaySalt string) {
+	salt := ac.kdfSalt
+	if salt == "" {
+		salt = relaySalt
+	}
+	var saltBytes []byte
+	if salt != "" {
+		saltBytes = []byte(salt)
+	}
+	if err := ac.gc.UsePassphrase(passphrase, saltBytes); err != nil {
+		log.Printf("passphrase: %v", err)
+	}
+}
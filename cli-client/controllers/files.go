@@ -0,0 +1,310 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"cli-client/models"
+	"cli-client/views"
+
+	"github.com/rivo/tview"
+)
+
+// ── File transfer ─────────────────────────────────────────────────────────────
+//
+//	/send <path>   upload a file and announce it in the room
+//	/get <n|id>    download a file announced in the chat to ~/Downloads
+//
+// The file is sealed with the room key before it leaves, whatever the
+// privacy setting, and uploaded under a neutral name, so the relay holds
+// neither its contents nor its name. The announcement is an ordinary
+// message (see models.Attachment), sealed like any other. Each client
+// numbers the files it sees from 1, and /get takes that number. Files
+// expire on the relay's -upload-ttl schedule, not with the messages. A PNG,
+// JPEG or GIF is previewed in the chat once saved (see views/image.go).
+
+// maxFileRead is the largest file /send reads; the relay has its own,
+// usually smaller, limit (-max-upload).
+const maxFileRead = 64 << 20
+
+// sealedOverhead covers what sealing adds to a file: envelope header,
+// nonce and tag.
+const sealedOverhead = 1 << 10
+
+// sealedUploadName is the name every file is uploaded under. Sealed, a file
+// is random bytes to the relay: its upload scanner can't flag it.
+const sealedUploadName = "sealed.bin"
+
+// maxPreviewPixels is the largest image, in pixels, /get decodes for a
+// preview.
+const maxPreviewPixels = 40 << 20
+
+// fileIndex numbers the files announced in the chat. The poll goroutine
+// and the event loop both use it.
+type fileIndex struct {
+	mu    sync.Mutex
+	files []*models.Attachment // files[n-1] is file n
+	byID  map[string]*models.Attachment
+}
+
+// note sets msg.File if msg announces a file, numbering the file the first
+// time it is seen.
+func (x *fileIndex) note(msg *models.Message) {
+	if msg.IsSystem {
+		return
+	}
+	file := models.ParseAttachment(msg.Content)
+	if file == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if known, ok := x.byID[file.ID]; ok {
+		msg.File = known
+		return
+	}
+	if x.byID == nil {
+		x.byID = make(map[string]*models.Attachment)
+	}
+	x.files = append(x.files, file)
+	file.Number = len(x.files)
+	x.byID[file.ID] = file
+	msg.File = file
+}
+
+// lookup finds a file by its number or its relay ID. An ID not seen in the
+// chat is taken as it is, named after itself.
+func (x *fileIndex) lookup(ref string) (*models.Attachment, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 1 || n > len(x.files) {
+			return nil, false
+		}
+		return x.files[n-1], true
+	}
+	if file, ok := x.byID[ref]; ok {
+		return file, true
+	}
+	if strings.HasPrefix(ref, "file_") {
+		return &models.Attachment{ID: ref, Name: ref}, true
+	}
+	return nil, false
+}
+
+// handleSend implements /send <path>. Must be called from the tview event
+// loop; reading, sealing and uploading run in the background.
+func (ac *AppController) handleSend(arg string) {
+	nc := ac.netClient
+	if arg == "" {
+		ac.sendSystem("Usage: /send <path>  —  share a file; the others fetch it with /get")
+		return
+	}
+	if nc == nil {
+		ac.sendSystem("Not connected to a relay.")
+		return
+	}
+
+	ac.sendSystem(fmt.Sprintf("[dim]Sending %s…[-]", tview.Escape(filepath.Base(arg))))
+	go func() {
+		name, data, err := readSendFile(arg)
+		if err == nil {
+			var sealed []byte
+			if sealed, err = ac.sealFile(data); err == nil {
+				var uploaded *UploadedFile
+				if uploaded, err = nc.UploadFile(sealedUploadName, sealed); err == nil {
+					ac.app.QueueUpdateDraw(func() {
+						if ac.netClient != nc {
+							return
+						}
+						ac.sendMessage(models.AttachmentText(uploaded.ID, name, formatBytes(int64(len(data)))))
+						ac.sendSystem(fmt.Sprintf("[dim]%s can be fetched until %s.[-]",
+							tview.Escape(name), uploaded.ExpiresAt.Local().Format("Jan 2 15:04")))
+					})
+					return
+				}
+			}
+		}
+		ac.app.QueueUpdateDraw(func() {
+			ac.sendSystem(fmt.Sprintf("[red]Send failed: %s[-]", tview.Escape(err.Error())))
+		})
+	}()
+}
+
+// handleGet implements /get <n|id>. Must be called from the tview event
+// loop; downloading and saving run in the background.
+func (ac *AppController) handleGet(arg string) {
+	nc := ac.netClient
+	if arg == "" {
+		ac.sendSystem("Usage: /get <n|id>  —  save a file from the chat to ~/Downloads")
+		return
+	}
+	if nc == nil {
+		ac.sendSystem("Not connected to a relay.")
+		return
+	}
+	file, ok := ac.files.lookup(strings.TrimSpace(arg))
+	if !ok {
+		ac.sendSystem(fmt.Sprintf("No file %s — use the number after /get in the chat.", tview.Escape(arg)))
+		return
+	}
+
+	go func() {
+		path, data, err := ac.fetchFile(nc, file)
+		var preview image.Image
+		if err == nil {
+			preview = previewImage(data)
+		}
+		ac.app.QueueUpdateDraw(func() {
+			if err != nil {
+				ac.sendSystem(fmt.Sprintf("[red]Can't get %s: %s[-]", tview.Escape(file.Name), tview.Escape(err.Error())))
+				return
+			}
+			msg := models.NewSystemMessage(fmt.Sprintf("Saved %s (%s) to %s.", tview.Escape(file.Name), formatBytes(int64(len(data))), tview.Escape(path)))
+			msg.Image = preview
+			ac.App.AddMessage(msg)
+			if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+				chat.AddMessage(msg)
+			}
+		})
+	}()
+}
+
+// fetchFile downloads file, opens it with the room key and saves it in the
+// download folder, returning where and what.
+func (ac *AppController) fetchFile(nc *NetworkClient, file *models.Attachment) (string, []byte, error) {
+	sealed, err := nc.DownloadFile(file.ID, maxFileRead+sealedOverhead)
+	if err != nil {
+		return "", nil, err
+	}
+	data, err := ac.openFile(sealed)
+	if err != nil {
+		return "", nil, fmt.Errorf("the file can't be opened with this room key")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", nil, err
+	}
+	path, err := saveDownload(filepath.Join(home, "Downloads"), file.Name, data)
+	return path, data, err
+}
+
+// previewImage decodes data for a preview if it is a PNG, JPEG or GIF of a
+// sane size, else returns nil.
+func previewImage(data []byte) image.Image {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width*cfg.Height > maxPreviewPixels {
+		return nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	return views.PreviewImage(img)
+}
+
+// sealFile encrypts data with the room key. The envelope is uploaded as
+// raw bytes, not the Base64 a message carries, to keep within the relay's
+// size limit.
+func (ac *AppController) sealFile(data []byte) ([]byte, error) {
+	sealed, err := ac.gc.Encrypt(data)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(sealed)
+}
+
+// openFile decrypts a file sealed by sealFile.
+func (ac *AppController) openFile(sealed []byte) ([]byte, error) {
+	return ac.gc.Decrypt(base64.StdEncoding.EncodeToString(sealed))
+}
+
+// readSendFile reads the file to send from path (~ is expanded), with the
+// name to announce it under.
+func readSendFile(path string) (name string, data []byte, err error) {
+	f, err := os.Open(expandHome(path))
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		return "", nil, fmt.Errorf("%s is a folder", filepath.Base(path))
+	}
+	if data, err = io.ReadAll(io.LimitReader(f, maxFileRead+1)); err != nil {
+		return "", nil, err
+	}
+	if len(data) > maxFileRead {
+		return "", nil, fmt.Errorf("more than %s — too big to send", formatBytes(maxFileRead))
+	}
+	return filepath.Base(path), data, nil
+}
+
+// saveDownload writes data to dir under name, made safe to use as a file
+// name, or "name (2).ext" and so on if that is taken. dir is created if
+// needed. Returns the path written.
+func saveDownload(dir, name string, data []byte) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	name = safeFileName(name)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for n := 1; n <= 100; n++ {
+		path := filepath.Join(dir, name)
+		if n > 1 {
+			path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, n, ext))
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			os.Remove(path)
+			return "", err
+		}
+		return path, f.Close()
+	}
+	return "", fmt.Errorf("%s already exists in %s", name, dir)
+}
+
+// safeFileName keeps the last part of a name another user chose, without
+// control characters, so it can't point outside the download folder.
+func safeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	if name == "" || name == "." || name == ".." || name == "/" {
+		return "download"
+	}
+	return name
+}
+
+// expandHome expands a leading ~/ in path to the home folder.
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return home + path[1:]
+		}
+	}
+	return path
+}
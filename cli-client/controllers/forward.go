@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"cli-client/models"
+	"cli-client/views"
+
+	"github.com/rivo/tview"
+)
+
+// ── Forwarding ────────────────────────────────────────────────────────────────
+//
+//	/forward                        list the newest messages with their IDs
+//	/forward <id|last> <room|@user> send a message on, to a room or as a
+//	                                direct message
+//
+// The copy carries who wrote the original, where and when as "fwd" metadata
+// next to the content, and receiving clients show that provenance in front
+// of the text. The content is sealed for the destination like any other
+// message and signed by the forwarder; the "fwd" block itself is not signed
+// — it is the forwarder's claim, not the original author's signature.
+
+// forwardListSize is how many messages /forward without arguments lists.
+const forwardListSize = 5
+
+// SendForward relays a forwarded message into room, or to to as a direct
+// message. content is already sealed for the destination. Delivery is
+// reported like for SendMessage.
+func (nc *NetworkClient) SendForward(localID, username, room, to, content, colorTag string, fwd *models.Forward) {
+	if atomic.LoadInt32(&nc.stopped) == 1 {
+		return
+	}
+	log.Printf("TRACE NetworkClient.SendForward: user=%q room=%q to=%q from=%q", username, room, to, fwd.From)
+	nc.send(outboundMessage{localID: localID, username: username, to: to, room: room, content: content, colorTag: colorTag, sealed: true, fwd: fwd})
+}
+
+// handleForward implements /forward. Must be called from the tview event
+// loop; sealing runs in the background.
+func (ac *AppController) handleForward(arg string) {
+	nc := ac.netClient
+	if nc == nil || ac.session == nil {
+		ac.sendSystem("Not connected.")
+		return
+	}
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		ac.listForwardable()
+		return
+	}
+	if len(fields) != 2 {
+		ac.sendSystem("Usage: /forward <id|last> <room|@user>")
+		return
+	}
+
+	orig, err := ac.forwardSource(fields[0])
+	if err != nil {
+		ac.sendSystem(err.Error())
+		return
+	}
+	if orig.Content == undecryptableText || orig.Content == directSealedText {
+		ac.sendSystem("That message can't be read here, so it can't be forwarded.")
+		return
+	}
+
+	// A forward of a forward keeps the original author.
+	fwd := orig.Forwarded
+	if fwd == nil {
+		room := ac.App.Room
+		if orig.To != "" {
+			room = ""
+		}
+		fwd = &models.Forward{From: orig.Username, Room: room, At: orig.Timestamp.UTC()}
+	}
+
+	msg := models.NewMessage(ac.session.Username, orig.Content)
+	msg.Color = ac.App.GetUserColorTag(ac.session.Username)
+	msg.Forwarded = fwd
+	msg.Delivery = models.DeliverySending
+	dest := fields[1]
+	if strings.HasPrefix(dest, "@") {
+		msg.To = strings.TrimPrefix(dest, "@")
+		switch {
+		case msg.To == "":
+			ac.sendSystem("Usage: /forward <id|last> <room|@user>")
+			return
+		case msg.To == ac.session.Username:
+			ac.sendSystem("That's you — pick someone else.")
+			return
+		case ac.identity == nil:
+			ac.sendSystem("No identity key loaded — direct messages need one. See the note at login.")
+			return
+		}
+	} else {
+		msg.Room = strings.ToLower(strings.TrimPrefix(dest, "#"))
+		if msg.Room == "" {
+			ac.sendSystem("Usage: /forward <id|last> <room|@user>")
+			return
+		}
+	}
+
+	ac.App.AddMessage(msg)
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.AddMessage(msg)
+		ac.rememberSent(chat, "/forward "+arg)
+	}
+	ac.sendForward(nc, msg)
+}
+
+// forwardSource finds the message /forward refers to: "last" is the newest
+// message that isn't a system note, anything else an ID or the end of one.
+// Must be called from the tview event loop.
+func (ac *AppController) forwardSource(ref string) (*models.Message, error) {
+	if ref == "last" {
+		for i := len(ac.App.Messages) - 1; i >= 0; i-- {
+			if m := ac.App.Messages[i]; !m.IsSystem {
+				return m, nil
+			}
+		}
+		return nil, errors.New("No message to forward yet.")
+	}
+	if m := ac.findMessage(ref); m != nil && !m.IsSystem {
+		return m, nil
+	}
+	var found *models.Message
+	for _, m := range ac.App.Messages {
+		if m.IsSystem || !strings.HasSuffix(m.ID, ref) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("%q matches more than one message — give more of the ID.", ref)
+		}
+		found = m
+	}
+	if found == nil {
+		return nil, fmt.Errorf("No message %q — /forward lists the newest IDs.", ref)
+	}
+	return found, nil
+}
+
+// listForwardable shows the newest messages with the IDs /forward takes.
+// Must be called from the tview event loop.
+func (ac *AppController) listForwardable() {
+	var recent []*models.Message
+	for i := len(ac.App.Messages) - 1; i >= 0 && len(recent) < forwardListSize; i-- {
+		if m := ac.App.Messages[i]; !m.IsSystem {
+			recent = append(recent, m)
+		}
+	}
+	if len(recent) == 0 {
+		ac.sendSystem("No message to forward yet.")
+		return
+	}
+	ac.sendSystem("Usage: /forward <id|last> <room|@user> — newest messages:")
+	for i := len(recent) - 1; i >= 0; i-- {
+		m := recent[i]
+		ac.sendSystem(fmt.Sprintf("  [dim]%s[-]  %s%s[-]: %.50s", tview.Escape(m.ID), m.Color, tview.Escape(m.Username), tview.Escape(m.Content)))
+	}
+}
+
+// sendForward seals msg for its destination and relays it. A failure marks
+// msg failed. Must be called from the tview event loop.
+func (ac *AppController) sendForward(nc *NetworkClient, msg *models.Message) {
+	localID, username, to, room, text, color, fwd := msg.ID, msg.Username, msg.To, msg.Room, msg.Content, msg.Color, msg.Forwarded
+	if room == "" && to == "" {
+		room = ac.App.Room
+	}
+	e2e := ac.e2eRooms[room]
+	go func() {
+		var sealed string
+		var err error
+		switch {
+		case to != "":
+			sealed, err = ac.sealDirect(nc, to, text)
+		case nc.PrivacyEnabled():
+			sealed, err = ac.gc.EncryptPadded(text)
+		case e2e:
+			sealed, err = ac.gc.EncryptText(text)
+		default:
+			sealed = text
+		}
+		if err != nil {
+			log.Printf("TRACE sendForward: room=%q to=%q: %v", room, to, err)
+			ac.app.QueueUpdateDraw(func() {
+				ac.sendSystem(fmt.Sprintf("Forward not sent: %s", tview.Escape(err.Error())))
+				ac.setDelivery(localID, models.DeliveryFailed)
+			})
+			return
+		}
+		if to != "" {
+			room = ""
+		}
+		nc.SendForward(localID, username, room, to, sealed, color, fwd)
+	}()
+}
@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"cli-client/models"
+	"cli-client/views"
+)
+
+// ── Help ──────────────────────────────────────────────────────────────────────
+//
+// /help opens the reference below over the chat (views.ChatView.ShowHelp).
+// A new command gets a row in helpCommands, a new key in helpKeys.
+
+var helpCommands = []views.HelpRow{
+	{Name: "/msg <user> <text>", Text: "Direct message, sealed for that user alone"},
+	{Name: "/me <action>", Text: "Action line: /me waves shows \"* you waves\""},
+	{Name: "/forward <id|last> <room|@user>", Text: "Send a message on to a room or user; alone, lists recent IDs"},
+	{Name: "/reply <n|id> <text>", Text: "Answer a message, quoted above your reply; alone, lists the newest"},
+	{Name: "/react <n|id> <emoji>", Text: "React to a message, or take your reaction back; alone, lists the newest"},
+	{Name: "/pins", Text: "List the messages pinned in this room"},
+	{Name: "/pin <n|id>", Text: "Pin your message to the room, shown under the header"},
+	{Name: "/unpin <n|id>", Text: "Unpin your message; n as /pins lists them"},
+	{Name: "/xpost <room1,room2> <text>", Text: "Post to this room and up to five more at once"},
+	{Name: "/paste <path|clipboard>", Text: "Share text as a paste link"},
+	{Name: "/paste view <id>", Text: "Open a paste in the viewer"},
+	{Name: "/send <path>", Text: "Share a file, sealed with the room key"},
+	{Name: "/get <n|id>", Text: "Save a shared file to ~/Downloads"},
+	{Name: "/snip [name]", Text: "Insert a snippet from config.json; alone, lists them"},
+	{Name: "/retry", Text: "Resend your messages that failed"},
+	{Name: "/history", Text: "Load older messages"},
+	{Name: "/clear", Text: "Clear the message area"},
+	{Name: "/users", Text: "Who is online, and since when idle"},
+	{Name: "/whois [user]", Text: "When the relay first and last saw a user, messages sent, online or not"},
+	{Name: "/room info", Text: "Room statistics from the relay"},
+	{Name: "/motd", Text: "The relay's message of the day and kept announcements"},
+	{Name: "/scripts [reload]", Text: "The Lua scripts loaded, their hooks and commands; reload loads them again"},
+	{Name: "/nick <newname>", Text: "Change your name; the account moves with it"},
+	{Name: "/ignore <user>", Text: "Hide a user's messages; kept in config.json"},
+	{Name: "/unignore <user>", Text: "Show them again, the hidden ones too"},
+	{Name: "/ignored", Text: "Who you ignore"},
+	{Name: "/nick", Text: "Toggle nick mode: ← / → also browse sent history"},
+	{Name: "/mode [animation|static]", Text: "Word-by-word or instant display; alone, toggles"},
+	{Name: "/user_color <color|reset>", Text: "Your name color: a name or #rrggbb"},
+	{Name: "/theme [name]", Text: "Color theme for this session; alone, lists themes"},
+	{Name: "/privacy [on|off]", Text: "Encrypt and pad everything, and send decoys"},
+	{Name: "/encrypt [on|off|status]", Text: "End-to-end encryption for this room"},
+	{Name: "/drafts [on|off]", Text: "Keep the unsent draft on the relay"},
+	{Name: "/trust [user [fingerprint]]", Text: "Show or pin identity key fingerprints"},
+	{Name: "/trust export <path>", Text: "Write the pinned fingerprints to a file"},
+	{Name: "/untrust <user>", Text: "Forget a pinned fingerprint"},
+	{Name: "/audit", Text: "Security events of this session"},
+	{Name: "/server [url]", Text: "Show or switch the relay, and reconnect"},
+	{Name: "/latency", Text: "Network latency probe"},
+	{Name: "/update [check|dismiss]", Text: "Look for a new release, or stop announcing it"},
+	{Name: "/debug [strict on|off]", Text: "Session counters and protocol diagnostics"},
+	{Name: "/info", Text: "About this client"},
+	{Name: "/tour", Text: "Replay the tour of the chat screen"},
+	{Name: "/wipe [all]", Text: "Panic button: erase scrollback and local traces; all also deletes config"},
+	{Name: "/help", Text: "This page"},
+	{Name: "/exit", Text: "Quit"},
+}
+
+var helpModeration = []views.HelpRow{
+	{Name: "/kick <user>", Text: "End the user's sessions"},
+	{Name: "/ban [user [reason]]", Text: "Ban a user; alone, lists bans"},
+	{Name: "/unban <user>", Text: "Lift a ban"},
+	{Name: "/purge [user]", Text: "Delete the room's messages, or only one user's"},
+	{Name: "/announce <text>", Text: "Show a relay announcement in every room"},
+}
+
+var helpKeys = []views.HelpRow{
+	{Name: "Enter", Text: "Send the message or run the command"},
+	{Name: "↑ / ↓", Text: "Browse the lines you sent; past the newest, back to what you typed"},
+	{Name: "Tab", Text: "After ;name, expand the snippet; then jump to its next {} field"},
+	{Name: "Alt+Enter", Text: "Add a line break; a pasted block keeps its own (shown as ↵)"},
+	{Name: ":name", Text: "Offer emoji shortcodes; ↑ / ↓ pick, Enter or Tab insert, Esc closes"},
+	{Name: "PgUp / PgDn", Text: "Scroll the messages"},
+	{Name: "End", Text: "While scrolled back: jump to the newest message"},
+	{Name: "Mouse wheel", Text: "Scroll the messages"},
+	{Name: "Click a name", Text: "Fill in /whois for that user"},
+	{Name: "Ctrl+Z", Text: "Suspend to the shell; fg resumes and reconnects"},
+	{Name: "Esc / q", Text: "Close this page, the paste viewer or the tour"},
+}
+
+var helpModes = []views.HelpRow{
+	{Name: "animation / static", Text: "Incoming messages appear word by word, or at once (/mode)"},
+	{Name: "nick mode", Text: "← / → browse sent history while the input is empty (/nick)"},
+	{Name: "privacy mode", Text: "Every message encrypted and padded, decoys sent (/privacy)"},
+	{Name: "scrolled back", Text: "The view stays put; the command bar counts new messages"},
+	{Name: "safe mode", Text: "--safe-mode: the built-in look and no scripts, to recover from a broken config"},
+}
+
+// showHelp opens the help page. Must be called from the tview event loop.
+func (ac *AppController) showHelp() {
+	chat, ok := ac.Views[models.ScreenChat].(*views.ChatView)
+	if !ok {
+		return
+	}
+	chat.ShowHelp([]views.HelpSection{
+		{Title: "Commands", Rows: helpCommands},
+		{Title: "Moderation (with \"admin_token\" in config.json)", Rows: helpModeration},
+		{Title: "Keys", Rows: helpKeys},
+		{Title: "Modes", Rows: helpModes},
+	})
+}
@@ -0,0 +1,169 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"cli-client/config"
+	"cli-client/models"
+	"cli-client/views"
+
+	"github.com/rivo/tview"
+)
+
+// ── Ignore list ───────────────────────────────────────────────────────────────
+//
+//	/ignore <user>     stop showing the user's messages
+//	/unignore <user>   show them again
+//	/ignored           list who is ignored
+//
+// Messages from an ignored user still arrive and are kept, but are not
+// drawn, don't count as unread and don't ring the bell; /unignore brings the
+// ones already received back. The list is "ignore" in config.json and
+// follows a user through a nick change. It is the client's alone — the
+// relay and the ignored user don't learn of it.
+
+// ignoreList is the set of ignored usernames. The poll goroutine reads it,
+// the event loop changes it.
+type ignoreList struct {
+	mu    sync.RWMutex
+	users map[string]bool
+}
+
+func (l *ignoreList) set(users []string) {
+	l.mu.Lock()
+	l.users = make(map[string]bool, len(users))
+	for _, u := range users {
+		if u = strings.TrimSpace(u); u != "" {
+			l.users[u] = true
+		}
+	}
+	l.mu.Unlock()
+}
+
+// has reports whether username is ignored.
+func (l *ignoreList) has(username string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.users[username]
+}
+
+// toggle adds username (on) or removes it, and reports whether that changed
+// anything.
+func (l *ignoreList) toggle(username string, on bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.users[username] == on {
+		return false
+	}
+	if l.users == nil {
+		l.users = make(map[string]bool)
+	}
+	if on {
+		l.users[username] = true
+	} else {
+		delete(l.users, username)
+	}
+	return true
+}
+
+// list returns the ignored usernames, sorted.
+func (l *ignoreList) list() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	users := make([]string, 0, len(l.users))
+	for u := range l.users {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	return users
+}
+
+// handleIgnore implements /ignore <user> and, with on false, /unignore
+// <user>. Must be called from the tview event loop.
+func (ac *AppController) handleIgnore(arg string, on bool) {
+	user := strings.TrimPrefix(strings.TrimSpace(arg), "@")
+	if user == "" || strings.ContainsAny(user, " \t") {
+		if on {
+			ac.sendSystem("Usage: /ignore <user>")
+		} else {
+			ac.sendSystem("Usage: /unignore <user>")
+		}
+		return
+	}
+	if on && ac.session != nil && user == ac.session.Username {
+		ac.sendSystem("You can't ignore yourself.")
+		return
+	}
+	if !ac.ignored.toggle(user, on) {
+		if on {
+			ac.sendSystem(fmt.Sprintf("%s is already ignored.", tview.Escape(user)))
+		} else {
+			ac.sendSystem(fmt.Sprintf("%s is not ignored.", tview.Escape(user)))
+		}
+		return
+	}
+	log.Printf("TRACE ignore: %q on=%v", user, on)
+	ac.showVisibleMessages()
+	if on {
+		ac.sendSystem(fmt.Sprintf("Ignoring %s — their messages are hidden. /unignore %s shows them again.", tview.Escape(user), tview.Escape(user)))
+	} else {
+		ac.sendSystem(fmt.Sprintf("No longer ignoring %s.", tview.Escape(user)))
+	}
+	ac.saveIgnored()
+}
+
+// handleIgnored implements /ignored. Must be called from the tview event loop.
+func (ac *AppController) handleIgnored() {
+	users := ac.ignored.list()
+	if len(users) == 0 {
+		ac.sendSystem("Nobody is ignored. /ignore <user> hides someone's messages.")
+		return
+	}
+	ac.sendSystem(fmt.Sprintf("Ignored (%d):", len(users)))
+	for _, u := range users {
+		ac.sendSystem(fmt.Sprintf("  %s%s[-]", models.GetUsernameColor(u), tview.Escape(u)))
+	}
+}
+
+// followIgnoredNick keeps ignoring a user who changed their name. Must be
+// called from the tview event loop.
+func (ac *AppController) followIgnoredNick(change nickChange) {
+	if !ac.ignored.toggle(change.From, false) {
+		return
+	}
+	ac.ignored.toggle(change.To, true)
+	ac.saveIgnored()
+}
+
+// saveIgnored writes the list to config.json. Must be called from the tview
+// event loop.
+func (ac *AppController) saveIgnored() {
+	if err := config.Set("ignore", ac.ignored.list()); err != nil {
+		log.Printf("ignore: save: %v", err)
+		ac.sendSystem(fmt.Sprintf("[red]✗ Ignore list not saved — it lasts for this session only: %s[-]", tview.Escape(err.Error())))
+	}
+}
+
+// visibleMessages returns the messages to draw: all but those from ignored
+// users.
+func (ac *AppController) visibleMessages() []*models.Message {
+	visible := make([]*models.Message, 0, len(ac.App.Messages))
+	for _, m := range ac.App.Messages {
+		if !ac.ignored.has(m.Username) {
+			visible = append(visible, m)
+		}
+	}
+	return visible
+}
+
+// showVisibleMessages re-renders the chat without ignored users' messages.
+// Must be called from the tview event loop.
+func (ac *AppController) showVisibleMessages() {
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.SetMessages(ac.visibleMessages())
+	}
+}
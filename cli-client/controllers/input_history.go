@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cli-client/config"
+	"cli-client/models"
+	"cli-client/views"
+)
+
+// ── Input history ─────────────────────────────────────────────────────────────
+//
+// Every line sent from the input field — messages and the /msg, /me and
+// /forward commands that send one — is appended to ~/.local/share/ttc/history
+// (mode 0600) as it is sent, so a crash loses nothing. LoadInputHistory
+// reads the newest views.MaxSentHistory lines back at startup for ↑ / ↓.
+// The file is rewritten down to that many lines when it has grown to twice
+// as long. /wipe deletes it.
+
+// LoadInputHistory gives the chat screen the lines sent in earlier runs.
+// Call once, before the chat screen is shown.
+func (ac *AppController) LoadInputHistory() {
+	chat, ok := ac.Views[models.ScreenChat].(*views.ChatView)
+	if !ok {
+		return
+	}
+	path, err := config.HistoryPath()
+	if err != nil {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("input history: %v", err)
+		}
+		return
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("input history: %s: %v", path, err)
+	}
+	ac.historyFileLines = len(lines)
+	chat.SetSentHistory(lines)
+	log.Printf("input history: %d line(s) loaded", min(len(lines), views.MaxSentHistory))
+}
+
+// rememberSent adds a sent line to the input history and to the history
+// file. Must be called from the tview event loop.
+func (ac *AppController) rememberSent(chat *views.ChatView, line string) {
+	if ac.wiped || strings.ContainsAny(line, "\r\n") || !chat.AddToHistory(line) {
+		return
+	}
+	path, err := config.HistoryPath()
+	if err == nil {
+		if ac.historyFileLines >= 2*views.MaxSentHistory {
+			err = writeInputHistory(path, chat.SentHistory())
+			ac.historyFileLines = len(chat.SentHistory())
+		} else {
+			err = appendInputHistory(path, line)
+			ac.historyFileLines++
+		}
+	}
+	if err != nil {
+		log.Printf("input history: not saved: %v", err)
+	}
+}
+
+func appendInputHistory(path, line string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeInputHistory(path string, lines []string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	return os.WriteFile(path, []byte(b.String()), 0600)
+}
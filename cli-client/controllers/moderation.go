@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+)
+
+// ── Moderation ────────────────────────────────────────────────────────────────
+//
+// With the relay's admin token in config.json ("admin_token") the client can
+// moderate through the relay's admin API:
+//
+//	/kick <user>            end the user's sessions
+//	/ban                    list the bans
+//	/ban <user> [reason]    ban a user
+//	/unban <user>           lift a ban
+//	/purge [user]           delete the room's messages, or only one user's
+//	/announce <text>        show a relay announcement in every room
+//
+// The relay decides: a wrong token or a disabled admin API comes back as a
+// system message.
+
+// ErrNotAdmin is returned when the relay rejects the admin token.
+var ErrNotAdmin = errors.New("the relay rejected the admin token")
+
+// ErrAdminDisabled is returned when the relay runs without an admin API.
+var ErrAdminDisabled = errors.New("the relay's admin API is disabled")
+
+// relayBan is one entry of GET /api/admin/bans.
+type relayBan struct {
+	Username  string    `json:"username"`
+	ClientID  string    `json:"client_id"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// adminResult is the relay's answer to a moderation action.
+type adminResult struct {
+	Purged int `json:"purged"`
+}
+
+// adminCall sends one admin API request with token and decodes the JSON
+// answer into out (unless nil). The error is short and user-facing.
+func adminCall(serverURL, token, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, serverURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := relayClient(10 * time.Second).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return ErrNotAdmin
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		text := strings.TrimSpace(string(msg))
+		switch {
+		case resp.StatusCode == http.StatusNotFound && strings.HasPrefix(text, "Admin API is disabled"):
+			return ErrAdminDisabled
+		case text != "":
+			return errors.New(text)
+		}
+		return fmt.Errorf("admin HTTP %d", resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out); err != nil {
+		return fmt.Errorf("decode admin answer: %w", err)
+	}
+	return nil
+}
+
+// moderate runs a moderation command off the event loop and reports the
+// outcome with done, or the error, as a system message. Must be called from
+// the tview event loop.
+func (ac *AppController) moderate(call func(serverURL, token string) (string, error)) {
+	if ac.adminToken == "" {
+		ac.sendSystem("Moderation needs the relay's admin token — set \"admin_token\" in config.json.")
+		return
+	}
+	if ac.netClient == nil {
+		ac.sendSystem("Not connected.")
+		return
+	}
+	serverURL, token := ac.netClient.ServerURL(), ac.adminToken
+	go func() {
+		done, err := call(serverURL, token)
+		ac.app.QueueUpdateDraw(func() {
+			if err != nil {
+				ac.sendSystem(fmt.Sprintf("[red]✗ %s[-]", tview.Escape(err.Error())))
+				return
+			}
+			ac.sendSystem(done)
+		})
+	}()
+}
+
+// handleKick implements /kick <user>. Must be called from the tview event loop.
+func (ac *AppController) handleKick(arg string) {
+	user := strings.TrimPrefix(strings.TrimSpace(arg), "@")
+	if user == "" || strings.ContainsAny(user, " \t") {
+		ac.sendSystem("Usage: /kick <user>")
+		return
+	}
+	ac.moderate(func(serverURL, token string) (string, error) {
+		err := adminCall(serverURL, token, http.MethodPost, "/api/admin/kick", map[string]string{"username": user}, nil)
+		return fmt.Sprintf("Kicked [cyan]%s[-] — their sessions have ended.", tview.Escape(user)), err
+	})
+}
+
+// handleBan implements /ban and /ban <user> [reason]. Must be called from the
+// tview event loop.
+func (ac *AppController) handleBan(arg string) {
+	if arg == "" {
+		ac.moderate(listBans)
+		return
+	}
+	parts := strings.SplitN(arg, " ", 2)
+	user := strings.TrimPrefix(parts[0], "@")
+	reason := ""
+	if len(parts) > 1 {
+		reason = strings.TrimSpace(parts[1])
+	}
+	ac.moderate(func(serverURL, token string) (string, error) {
+		err := adminCall(serverURL, token, http.MethodPost, "/api/admin/bans", map[string]string{"username": user, "reason": reason}, nil)
+		return fmt.Sprintf("Banned [cyan]%s[-] from the relay.", tview.Escape(user)), err
+	})
+}
+
+// handleUnban implements /unban <user>. Must be called from the tview event loop.
+func (ac *AppController) handleUnban(arg string) {
+	user := strings.TrimPrefix(strings.TrimSpace(arg), "@")
+	if user == "" || strings.ContainsAny(user, " \t") {
+		ac.sendSystem("Usage: /unban <user>")
+		return
+	}
+	ac.moderate(func(serverURL, token string) (string, error) {
+		err := adminCall(serverURL, token, http.MethodDelete, "/api/admin/bans?"+url.Values{"username": {user}}.Encode(), nil, nil)
+		return fmt.Sprintf("Lifted the ban on [cyan]%s[-].", tview.Escape(user)), err
+	})
+}
+
+func listBans(serverURL, token string) (string, error) {
+	var bans []relayBan
+	if err := adminCall(serverURL, token, http.MethodGet, "/api/admin/bans", nil, &bans); err != nil {
+		return "", err
+	}
+	if len(bans) == 0 {
+		return "No bans.", nil
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Bans (%d):", len(bans))
+	for _, b := range bans {
+		who := "[cyan]" + tview.Escape(b.Username) + "[-]"
+		if b.Username == "" {
+			who = "client " + tview.Escape(b.ClientID)
+		}
+		fmt.Fprintf(&sb, "\n  %s  [dim]%s[-]", who, b.CreatedAt.Local().Format("2006-01-02 15:04"))
+		if b.Reason != "" {
+			fmt.Fprintf(&sb, " — %s", tview.Escape(b.Reason))
+		}
+	}
+	return sb.String(), nil
+}
+
+// handlePurge implements /purge [user] for the current room. Must be called
+// from the tview event loop.
+func (ac *AppController) handlePurge(arg string) {
+	user := strings.TrimPrefix(strings.TrimSpace(arg), "@")
+	if strings.ContainsAny(user, " \t") {
+		ac.sendSystem("Usage: /purge [user]")
+		return
+	}
+	room := ac.App.Room
+	ac.moderate(func(serverURL, token string) (string, error) {
+		var res adminResult
+		err := adminCall(serverURL, token, http.MethodPost, "/api/admin/purge", map[string]string{"room": room, "username": user}, &res)
+		by := ""
+		if user != "" {
+			by = " by " + tview.Escape(user)
+		}
+		return fmt.Sprintf("Purged %d message(s)%s in [cyan]%s[-] from the relay.", res.Purged, by, tview.Escape(room)), err
+	})
+}
+
+// handleAnnounce implements /announce <text>. Must be called from the tview
+// event loop.
+func (ac *AppController) handleAnnounce(arg string) {
+	if arg == "" {
+		ac.sendSystem("Usage: /announce <text>")
+		return
+	}
+	ac.moderate(func(serverURL, token string) (string, error) {
+		err := adminCall(serverURL, token, http.MethodPost, "/api/admin/announce", map[string]string{"text": arg}, nil)
+		return "Announcement sent to every room.", err
+	})
+}
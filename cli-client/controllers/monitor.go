@@ -0,0 +1,363 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"cli-client/config"
+	"cli-client/crypto"
+	"cli-client/models"
+	"cli-client/views"
+
+	"github.com/rivo/tview"
+)
+
+// ── Monitor ───────────────────────────────────────────────────────────────────
+//
+// --monitor runs the client without the chat, as a small ops dashboard for
+// one relay (views.MonitorView): /api/stats every two seconds, the message
+// rate of one room as a sparkline, and a log of what happened there —
+// announcements, nick changes, clients coming and going, the relay dropping
+// out and coming back.
+//
+// It reads the room as a read-only observer (see "Observer Tokens" in the
+// README), so it never shows up as online and cannot send. The token is
+// "observer_token" from config.json, or else one the monitor asks the admin
+// API for with "admin_token". Without either, the stats still work but the
+// rate and the room's events stay empty.
+
+// monitorStatsInterval is how often /api/stats is read.
+const monitorStatsInterval = 2 * time.Second
+
+// monitorBucket is how much time one column of the sparkline covers, and
+// monitorBuckets how many are kept (20 minutes).
+const (
+	monitorBucket  = 5 * time.Second
+	monitorBuckets = 240
+)
+
+// monitorObserverName and monitorObserverTTL are what the monitor asks the
+// admin API for: the token shows up as observer:monitor in the relay's log.
+const (
+	monitorObserverName = "monitor"
+	monitorObserverTTL  = "24h"
+)
+
+// observerCredentials is an observer token as the admin API issues it.
+type observerCredentials struct {
+	Token      string `json:"token"`
+	Username   string `json:"username"`
+	Room       string `json:"room"`
+	SigningKey string `json:"signing_key"`
+}
+
+// Monitor drives the --monitor dashboard.
+type Monitor struct {
+	app  *tview.Application
+	view *views.MonitorView
+	room string
+
+	adminToken string
+	observer   *observerCredentials // from config.json, nil = ask the admin API
+	notes      []string             // config problems, shown once the dashboard runs
+
+	stopCh chan struct{}
+
+	mu   sync.Mutex
+	nc   *NetworkClient // reads the room; nil without an observer token
+	rate []int          // messages per monitorBucket, oldest first; the last is filling
+	seen int
+}
+
+// NewMonitor creates the monitor shown in view, for room; "" is the
+// observer token's room, or models.DefaultRoom.
+func NewMonitor(app *tview.Application, view *views.MonitorView, room string) *Monitor {
+	return &Monitor{
+		app:    app,
+		view:   view,
+		room:   room,
+		stopCh: make(chan struct{}),
+		rate:   []int{0},
+	}
+}
+
+// LoadConfig reads what the monitor needs from config.json: the relay and
+// how to reach it, the theme, and the tokens. Problems become events. Call
+// before Start.
+func (m *Monitor) LoadConfig() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Printf("config: %v", err)
+		m.notes = append(m.notes, fmt.Sprintf("Config not loaded: %s", tview.Escape(err.Error())))
+	}
+	m.notes = append(m.notes, applyConnectionConfig(cfg)...)
+	if theme, ok := views.LookupTheme(cfg.Theme); ok {
+		m.view.SetTheme(theme)
+	}
+	m.app.EnableMouse(!cfg.DisableMouse)
+	m.adminToken = cfg.AdminToken
+	if cfg.ObserverToken != "" {
+		m.observer = readObserverToken(cfg.ObserverToken, cfg.ObserverSigningKey)
+		switch {
+		case m.observer.Room == "":
+			m.notes = append(m.notes, "Config: observer_token is not an observer token — the relay will refuse it.")
+		case m.room != "" && m.room != m.observer.Room:
+			m.notes = append(m.notes, fmt.Sprintf("Config: observer_token reads %s only — watching %s, not %s.",
+				tview.Escape(m.observer.Room), tview.Escape(m.observer.Room), tview.Escape(m.room)))
+			m.room = m.observer.Room
+		default:
+			m.room = m.observer.Room
+		}
+	}
+	if m.room == "" {
+		m.room = models.DefaultRoom
+	}
+}
+
+// readObserverToken fills in an observer token's username and room from its
+// payload. The signature is the relay's to check; this only tells the
+// monitor which room to read.
+func readObserverToken(token, signingKey string) *observerCredentials {
+	creds := &observerCredentials{Token: token, SigningKey: signingKey}
+	payload, _, _ := strings.Cut(token, ".")
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return creds
+	}
+	var claims struct {
+		Username string `json:"u"`
+		Room     string `json:"room"`
+	}
+	if json.Unmarshal(data, &claims) == nil {
+		creds.Username, creds.Room = claims.Username, claims.Room
+	}
+	return creds
+}
+
+// Start checks the relay and starts watching it in the background.
+func (m *Monitor) Start() {
+	go m.run()
+}
+
+// Stop ends the watching. Call once the dashboard has closed.
+func (m *Monitor) Stop() {
+	close(m.stopCh)
+	m.mu.Lock()
+	nc := m.nc
+	m.mu.Unlock()
+	if nc != nil {
+		nc.Stop()
+	}
+}
+
+func (m *Monitor) run() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PANIC Monitor.run: %v", r)
+		}
+	}()
+
+	m.view.SetTitle(fmt.Sprintf("%s · room %s", DefaultServerURL, m.room))
+	for _, note := range m.notes {
+		m.event(views.MonitorAlert, note)
+	}
+	if err := CheckServerConnectivity(DefaultServerURL); err != nil {
+		m.event(views.MonitorAlert, fmt.Sprintf("Relay not reachable: %s", tview.Escape(err.Error())))
+	} else {
+		m.event(views.MonitorOK, fmt.Sprintf("Relay reachable at %s", tview.Escape(DefaultServerURL)))
+	}
+
+	creds, err := m.observerToken()
+	var statsClient *NetworkClient
+	switch {
+	case err != nil:
+		m.event(views.MonitorAlert, fmt.Sprintf("No observer token: %s — showing the relay's stats only.", tview.Escape(err.Error())))
+	case creds == nil:
+		m.event(views.MonitorInfo, "No \"observer_token\" or \"admin_token\" in config.json — showing the relay's stats only.")
+	default:
+		statsClient = m.watchRoom(creds)
+	}
+	if statsClient == nil {
+		statsClient = NewNetworkClient(m.app, DefaultServerURL, "", m.room, nil, crypto.NewGlobalCrypto(), nil, nil, nil)
+		m.view.SetRate(nil, monitorBucket, 0, "The rate needs an observer token — see --monitor in the README.")
+	}
+
+	stats := time.NewTicker(monitorStatsInterval)
+	defer stats.Stop()
+	bucket := time.NewTicker(monitorBucket)
+	defer bucket.Stop()
+
+	var shown views.MonitorStats
+	m.readStats(statsClient, &shown)
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case <-stats.C:
+			m.readStats(statsClient, &shown)
+		case <-bucket.C:
+			m.nextBucket()
+		}
+	}
+}
+
+// observerToken returns the credentials to read the room with: the
+// configured ones, or new ones from the admin API. nil, nil = neither is
+// configured.
+func (m *Monitor) observerToken() (*observerCredentials, error) {
+	if m.observer != nil {
+		return m.observer, nil
+	}
+	if m.adminToken == "" {
+		return nil, nil
+	}
+	var creds observerCredentials
+	err := adminCall(DefaultServerURL, m.adminToken, http.MethodPost, "/api/admin/observers",
+		map[string]string{"name": monitorObserverName, "room": m.room, "ttl": monitorObserverTTL}, &creds)
+	if err != nil {
+		return nil, err
+	}
+	m.event(views.MonitorInfo, fmt.Sprintf("Got an observer token for %s from the admin API.", tview.Escape(creds.Room)))
+	return &creds, nil
+}
+
+// watchRoom starts reading the room with creds and returns the client.
+func (m *Monitor) watchRoom(creds *observerCredentials) *NetworkClient {
+	who := creds.Username
+	if who == "" {
+		who = "observer"
+	}
+	nc := NewNetworkClient(
+		m.app,
+		DefaultServerURL,
+		creds.Token,
+		m.room,
+		nil,
+		crypto.NewGlobalCrypto(),
+		// onMessage: only counted — the monitor shows no messages.
+		func(*models.Message) { m.countMessage() },
+		func(connected bool, msg string) {
+			m.view.SetConnection(connected, who)
+			level := views.MonitorAlert
+			if connected {
+				level = views.MonitorOK
+			}
+			m.event(level, tview.Escape(msg))
+		},
+		nil,
+	)
+	if creds.SigningKey != "" {
+		key, err := base64.RawURLEncoding.DecodeString(creds.SigningKey)
+		if err != nil {
+			m.event(views.MonitorAlert, "The observer signing key is malformed — requests go unsigned.")
+		} else {
+			nc.SetSigningKey(key)
+		}
+	}
+	nc.SetAnnouncementHandler(func(id, text string) {
+		m.countMessage()
+		m.event(views.MonitorInfo, announcementText(text))
+	})
+	nc.SetNickHandler(func(id string, change nickChange) {
+		m.countMessage()
+		m.event(views.MonitorInfo, nickText(change))
+	})
+	// The backlog happened before the monitor started: not events, and not
+	// part of the rate.
+	if msgs, _, err := nc.FetchHistory("", 1); err == nil && len(msgs) > 0 {
+		nc.ResumeAfter(msgs[len(msgs)-1].ID, msgs[len(msgs)-1].Seq)
+	}
+	nc.Start()
+	m.mu.Lock()
+	m.nc = nc
+	m.mu.Unlock()
+	m.view.SetRate(m.rateSnapshot())
+	return nc
+}
+
+// readStats reads /api/stats once into shown, the reading on screen, and
+// turns what changed into events. A failed read keeps the last numbers.
+func (m *Monitor) readStats(nc *NetworkClient, shown *views.MonitorStats) {
+	start := time.Now()
+	stats, err := nc.FetchStats()
+	if err != nil {
+		if shown.Err == "" {
+			m.event(views.MonitorAlert, fmt.Sprintf("The relay stopped answering /api/stats: %s", tview.Escape(err.Error())))
+		}
+		shown.Err = err.Error()
+		m.view.SetStats(*shown)
+		return
+	}
+	if shown.Err != "" {
+		m.event(views.MonitorOK, "The relay answers /api/stats again.")
+	}
+
+	next := views.MonitorStats{
+		Status:     stats.Status,
+		Active:     stats.ActiveClients,
+		Waiting:    stats.ChatStats.WaitingClients,
+		MaxWaiters: stats.ChatStats.MaxWaiters,
+		Buffered:   stats.ChatStats.TotalMessages,
+		Fetched:    start,
+		Latency:    time.Since(start),
+	}
+	if !shown.Fetched.IsZero() {
+		if next.Status != shown.Status {
+			m.event(views.MonitorAlert, fmt.Sprintf("Relay status %s → %s", tview.Escape(shown.Status), tview.Escape(next.Status)))
+		}
+		if next.Active != shown.Active {
+			m.event(views.MonitorInfo, fmt.Sprintf("Clients %d → %d", shown.Active, next.Active))
+		}
+		full := func(s views.MonitorStats) bool {
+			return s.MaxWaiters > 0 && s.Waiting*10 >= s.MaxWaiters*9
+		}
+		if full(next) && !full(*shown) {
+			m.event(views.MonitorAlert, fmt.Sprintf("Long polls at %d of %d — new ones will be refused soon.", next.Waiting, next.MaxWaiters))
+		}
+	}
+	*shown = next
+	m.view.SetStats(next)
+}
+
+// countMessage counts one message in the room. Runs on the poll goroutine.
+func (m *Monitor) countMessage() {
+	m.mu.Lock()
+	m.rate[len(m.rate)-1]++
+	m.seen++
+	m.mu.Unlock()
+	m.view.SetRate(m.rateSnapshot())
+}
+
+// nextBucket starts a new column of the sparkline, once the room is read.
+func (m *Monitor) nextBucket() {
+	m.mu.Lock()
+	if m.nc == nil {
+		m.mu.Unlock()
+		return
+	}
+	m.rate = append(m.rate, 0)
+	if len(m.rate) > monitorBuckets {
+		m.rate = m.rate[len(m.rate)-monitorBuckets:]
+	}
+	m.mu.Unlock()
+	m.view.SetRate(m.rateSnapshot())
+}
+
+// rateSnapshot returns SetRate's arguments.
+func (m *Monitor) rateSnapshot() ([]int, time.Duration, int, string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]int(nil), m.rate...), monitorBucket, m.seen, ""
+}
+
+// event logs text, which may hold color tags, in the events panel.
+func (m *Monitor) event(level views.MonitorLevel, text string) {
+	log.Printf("monitor: %s", text)
+	m.view.AddEvent(time.Now(), level, strings.TrimSpace(text))
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/rivo/tview"
+)
+
+// ── Message of the day ────────────────────────────────────────────────────────
+//
+// The relay's /api/motd carries the operator's message of the day and the
+// announcements kept for clients that connect later (POST
+// /api/admin/announce with "keep"). The MOTD shows when the chat opens;
+// kept announcements once the history loaded, so one that is still in the
+// history isn't shown twice. /motd shows both again.
+
+// motdText is how the message of the day reads as a system line.
+func motdText(motd string) string {
+	return fmt.Sprintf("[yellow]📢 %s[-]", tview.Escape(motd))
+}
+
+// showNotices shows the kept announcements from the MOTD that weren't in
+// the history. Must be called from the tview event loop.
+func (ac *AppController) showNotices() {
+	for _, n := range ac.notices {
+		if !ac.seen.Observe(n.ID) {
+			ac.sendSystem(announcementText(n.Text))
+		}
+	}
+	ac.notices = nil
+}
+
+// handleMotd implements /motd. Must be called from the tview event loop.
+func (ac *AppController) handleMotd() {
+	nc := ac.netClient
+	go func() {
+		motd, err := FetchMotd(DefaultServerURL)
+		ac.app.QueueUpdateDraw(func() {
+			if ac.netClient != nc {
+				return
+			}
+			if err != nil {
+				ac.sendSystem(fmt.Sprintf("[red]MOTD unavailable: %s[-]", tview.Escape(err.Error())))
+				return
+			}
+			if motd.Motd == "" && len(motd.Announcements) == 0 {
+				ac.sendSystem("The relay has no message of the day.")
+				return
+			}
+			if motd.Motd != "" {
+				ac.sendSystem(motdText(motd.Motd))
+			}
+			for _, n := range motd.Announcements {
+				ac.sendSystem(fmt.Sprintf("%s  [dim]until %s[-]", announcementText(n.Text), n.Until.Local().Format("Jan 2 15:04")))
+			}
+		})
+	}()
+}
@@ -1,449 +1,1634 @@
-package controllers
-
-import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"io"
-	"log"
-	"math/rand"
-	"net/http"
-	"net/url"
-	"sync"
-	"sync/atomic"
-	"time"
-
-	"github.com/rivo/tview"
-)
-
-var DefaultServerURL = "http://tccbackend-production-831d.up.railway.app"
-
-const serverAccessKey = "secure_chat_key_2024"
-
-// ── Wire types ────────────────────────────────────────────────────────────────
-
-type sendRequest struct {
-	AccessKey string `json:"access_key"`
-	ClientID  string `json:"client_id"`
-	Username  string `json:"username"`
-	Content   string `json:"content"`
-	Color     string `json:"color"`
-}
-
-type sendResponse struct {
-	Status string `json:"status"`
-	ID     string `json:"id"`
-	Time   string `json:"time"`
-}
-
-type pollMessage struct {
-	Username  string
-	Content   string
-	Color     string
-	ID        string
-	Timestamp time.Time
-}
-
-var knownPollKeys = map[string]bool{
-	"color":     true,
-	"id":        true,
-	"timestamp": true,
-}
-
-// parsePollMessages parses the raw JSON array from /api/poll.
-// Logs every step so the last line before a crash identifies the bad message.
-func parsePollMessages(data []byte) ([]*pollMessage, error) {
-	log.Printf("TRACE parsePollMessages: raw body (%d bytes): %.500s", len(data), data)
-
-	var rawList []map[string]json.RawMessage
-	if err := json.Unmarshal(data, &rawList); err != nil {
-		log.Printf("TRACE parsePollMessages: unmarshal error: %v", err)
-		return nil, fmt.Errorf("parse poll array: %w", err)
-	}
-	log.Printf("TRACE parsePollMessages: parsed %d entries", len(rawList))
-
-	msgs := make([]*pollMessage, 0, len(rawList))
-	for i, raw := range rawList {
-		log.Printf("TRACE parsePollMessages: entry[%d] keys=%v", i, mapKeys(raw))
-		msg := &pollMessage{}
-
-		if v, ok := raw["color"]; ok {
-			json.Unmarshal(v, &msg.Color)
-		}
-		if v, ok := raw["id"]; ok {
-			json.Unmarshal(v, &msg.ID)
-		}
-		if v, ok := raw["timestamp"]; ok {
-			json.Unmarshal(v, &msg.Timestamp)
-		}
-
-		for key, val := range raw {
-			if knownPollKeys[key] {
-				continue
-			}
-			msg.Username = key
-			json.Unmarshal(val, &msg.Content)
-			break
-		}
-
-		log.Printf("TRACE parsePollMessages: entry[%d] id=%q user=%q color=%q content=%.80q",
-			i, msg.ID, msg.Username, msg.Color, msg.Content)
-
-		if msg.Username == "" || msg.Content == "" || msg.ID == "" {
-			log.Printf("TRACE parsePollMessages: entry[%d] SKIPPED (malformed)", i)
-			continue
-		}
-		msgs = append(msgs, msg)
-	}
-	log.Printf("TRACE parsePollMessages: returning %d valid messages", len(msgs))
-	return msgs, nil
-}
-
-func mapKeys(m map[string]json.RawMessage) []string {
-	keys := make([]string, 0, len(m))
-	for k := range m {
-		keys = append(keys, k)
-	}
-	return keys
-}
-
-// ── NetworkClient ─────────────────────────────────────────────────────────────
-
-type NetworkClient struct {
-	serverURL string
-	clientID  string
-	app       *tview.Application
-
-	httpClient *http.Client
-	stopped    int32
-	stopCh     chan struct{}
-
-	lastIDMu sync.Mutex
-	lastID   string
-
-	sentIDsMu sync.Mutex
-	sentIDs   map[string]struct{}
-
-	onMessage      func(username, content, colorTag string)
-	onStatusChange func(connected bool, msg string)
-}
-
-func NewNetworkClient(
-	app *tview.Application,
-	serverURL string,
-	onMessage func(username, content, colorTag string),
-	onStatusChange func(connected bool, msg string),
-) *NetworkClient {
-	cid := generateClientID()
-	log.Printf("TRACE NewNetworkClient: url=%s clientID=%s", serverURL, cid)
-	return &NetworkClient{
-		serverURL:      serverURL,
-		clientID:       cid,
-		app:            app,
-		httpClient:     &http.Client{Timeout: 40 * time.Second},
-		stopCh:         make(chan struct{}),
-		sentIDs:        make(map[string]struct{}),
-		onMessage:      onMessage,
-		onStatusChange: onStatusChange,
-	}
-}
-
-func generateClientID() string {
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	return fmt.Sprintf("client_%d", r.Int63n(1_000_000_000))
-}
-
-func (nc *NetworkClient) Start() {
-	log.Printf("TRACE NetworkClient.Start: launching pollLoop goroutine")
-	go nc.pollLoop()
-}
-
-func (nc *NetworkClient) SendMessage(username, content, colorTag string) {
-	if atomic.LoadInt32(&nc.stopped) == 1 {
-		return
-	}
-	log.Printf("TRACE NetworkClient.SendMessage: user=%q content=%.60q color=%q", username, content, colorTag)
-	go nc.sendAsync(username, content, colorTag)
-}
-
-func (nc *NetworkClient) Stop() {
-	if atomic.CompareAndSwapInt32(&nc.stopped, 0, 1) {
-		log.Printf("TRACE NetworkClient.Stop: closing stopCh")
-		close(nc.stopCh)
-	}
-}
-
-// ServerURL returns the relay server base URL this client is connected to.
-func (nc *NetworkClient) ServerURL() string {
-	return nc.serverURL
-}
-
-// ── Send ──────────────────────────────────────────────────────────────────────
-
-func (nc *NetworkClient) sendAsync(username, content, colorTag string) {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("PANIC NetworkClient.sendAsync: %v", r)
-		}
-	}()
-
-	log.Printf("TRACE sendAsync: building request user=%q content=%.60q", username, content)
-	body := sendRequest{
-		AccessKey: serverAccessKey,
-		ClientID:  nc.clientID,
-		Username:  username,
-		Content:   content,
-		Color:     colorTag,
-	}
-	bodyJSON, err := json.Marshal(body)
-	if err != nil {
-		log.Printf("TRACE sendAsync: marshal error: %v", err)
-		return
-	}
-
-	log.Printf("TRACE sendAsync: POST %s/api/send", nc.serverURL)
-	resp, err := nc.httpClient.Post(
-		nc.serverURL+"/api/send",
-		"application/json",
-		bytes.NewReader(bodyJSON),
-	)
-	if err != nil {
-		log.Printf("TRACE sendAsync: POST error: %v", err)
-		nc.notifyStatus(false, "Message send failed — server unreachable.")
-		return
-	}
-	defer resp.Body.Close()
-	log.Printf("TRACE sendAsync: POST status=%d", resp.StatusCode)
-
-	switch resp.StatusCode {
-	case http.StatusUnauthorized:
-		nc.notifyStatus(false, "Server rejected access key.")
-	case http.StatusOK, http.StatusCreated:
-		var sr sendResponse
-		if err := json.NewDecoder(resp.Body).Decode(&sr); err == nil && sr.ID != "" {
-			log.Printf("TRACE sendAsync: server assigned id=%q", sr.ID)
-			nc.sentIDsMu.Lock()
-			nc.sentIDs[sr.ID] = struct{}{}
-			nc.sentIDsMu.Unlock()
-		}
-	default:
-		raw, _ := io.ReadAll(resp.Body)
-		log.Printf("TRACE sendAsync: unexpected status %d body=%.120s", resp.StatusCode, raw)
-	}
-}
-
-// ── Poll loop ─────────────────────────────────────────────────────────────────
-
-func (nc *NetworkClient) pollLoop() {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("PANIC NetworkClient.pollLoop: %v", r)
-		}
-	}()
-
-	backoff := 1 * time.Second
-	const maxBackoff = 30 * time.Second
-	firstConnect := true
-	wasConnected := false
-	iteration := 0
-
-	for {
-		iteration++
-		if atomic.LoadInt32(&nc.stopped) == 1 {
-			log.Printf("TRACE pollLoop[%d]: stopped, exiting", iteration)
-			return
-		}
-
-		log.Printf("TRACE pollLoop[%d]: calling poll(), lastID=%q", iteration, nc.lastID)
-		msgs, err := nc.poll()
-		if err != nil {
-			log.Printf("TRACE pollLoop[%d]: poll error: %v", iteration, err)
-			if firstConnect {
-				nc.notifyStatus(false, fmt.Sprintf("Cannot reach server at %s", nc.serverURL))
-			} else if wasConnected {
-				nc.notifyStatus(false, fmt.Sprintf("Connection lost — reconnecting in %v…", backoff))
-			}
-			wasConnected = false
-			select {
-			case <-nc.stopCh:
-				return
-			case <-time.After(backoff):
-			}
-			backoff = minDur(backoff*2, maxBackoff)
-			continue
-		}
-
-		if firstConnect || !wasConnected {
-			nc.notifyStatus(true, fmt.Sprintf("Connected to relay at %s", nc.serverURL))
-		}
-		backoff = 1 * time.Second
-		firstConnect = false
-		wasConnected = true
-
-		log.Printf("TRACE pollLoop[%d]: poll returned %d messages (nil=%v)", iteration, len(msgs), msgs == nil)
-
-		for idx, msg := range msgs {
-			log.Printf("TRACE pollLoop[%d]: dispatching msg[%d] id=%q user=%q color=%q content=%.80q",
-				iteration, idx, msg.ID, msg.Username, msg.Color, msg.Content)
-			nc.handleIncoming(msg)
-			log.Printf("TRACE pollLoop[%d]: msg[%d] dispatch complete", iteration, idx)
-		}
-
-		if msgs == nil {
-			select {
-			case <-nc.stopCh:
-				return
-			case <-time.After(500 * time.Millisecond):
-			}
-		}
-	}
-}
-
-func (nc *NetworkClient) poll() ([]*pollMessage, error) {
-	nc.lastIDMu.Lock()
-	lastID := nc.lastID
-	nc.lastIDMu.Unlock()
-
-	params := url.Values{}
-	params.Set("access_key", serverAccessKey)
-	params.Set("client_id", nc.clientID)
-	if lastID != "" {
-		params.Set("last_id", lastID)
-	}
-
-	log.Printf("TRACE poll: GET %s/api/poll lastID=%q", nc.serverURL, lastID)
-	req, err := http.NewRequest(http.MethodGet, nc.serverURL+"/api/poll?"+params.Encode(), nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := nc.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	log.Printf("TRACE poll: response status=%d", resp.StatusCode)
-
-	switch resp.StatusCode {
-	case http.StatusNoContent:
-		log.Printf("TRACE poll: 204 no content")
-		return nil, nil
-
-	case http.StatusUnauthorized:
-		return nil, fmt.Errorf("server rejected access key")
-
-	case http.StatusOK:
-		rawBody, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("read poll body: %w", err)
-		}
-		log.Printf("TRACE poll: 200 body=%d bytes", len(rawBody))
-		msgs, err := parsePollMessages(rawBody)
-		if err != nil {
-			return nil, err
-		}
-		if len(msgs) > 0 {
-			nc.lastIDMu.Lock()
-			nc.lastID = msgs[len(msgs)-1].ID
-			nc.lastIDMu.Unlock()
-			log.Printf("TRACE poll: advanced lastID to %q", nc.lastID)
-		}
-		return msgs, nil
-
-	default:
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected HTTP %d: %.120s", resp.StatusCode, body)
-	}
-}
-
-func (nc *NetworkClient) handleIncoming(msg *pollMessage) {
-	log.Printf("TRACE handleIncoming: checking sentIDs for id=%q", msg.ID)
-	nc.sentIDsMu.Lock()
-	_, isMine := nc.sentIDs[msg.ID]
-	if isMine {
-		delete(nc.sentIDs, msg.ID)
-	}
-	nc.sentIDsMu.Unlock()
-
-	if isMine {
-		log.Printf("TRACE handleIncoming: id=%q is mine, skipping echo", msg.ID)
-		return
-	}
-
-	log.Printf("TRACE handleIncoming: calling onMessage user=%q color=%q content=%.80q",
-		msg.Username, msg.Color, msg.Content)
-	if nc.onMessage != nil {
-		nc.onMessage(msg.Username, msg.Content, msg.Color)
-	}
-	log.Printf("TRACE handleIncoming: onMessage returned for id=%q", msg.ID)
-}
-
-func (nc *NetworkClient) notifyStatus(connected bool, msg string) {
-	log.Printf("TRACE notifyStatus: connected=%v msg=%q", connected, msg)
-	if nc.onStatusChange != nil {
-		nc.onStatusChange(connected, msg)
-	}
-}
-
-// ── Startup connectivity check ────────────────────────────────────────────────
-
-func CheckServerConnectivity(serverURL string) error {
-	log.Printf("TRACE CheckServerConnectivity: GET %s/health", serverURL)
-	client := &http.Client{Timeout: 3 * time.Second}
-	resp, err := client.Get(serverURL + "/health")
-	if err != nil {
-		log.Printf("TRACE CheckServerConnectivity: error: %v", err)
-		return fmt.Errorf("relay server not available at %s: %w", serverURL, err)
-	}
-	resp.Body.Close()
-	log.Printf("TRACE CheckServerConnectivity: status=%d", resp.StatusCode)
-	if resp.StatusCode >= 500 {
-		return fmt.Errorf("relay server returned HTTP %d", resp.StatusCode)
-	}
-	return nil
-}
-
-func minDur(a, b time.Duration) time.Duration {
-	if a < b {
-		return a
-	}
-	return b
-}
-
-// ── Server stats ──────────────────────────────────────────────────────────────
-
-// ServerStats mirrors the /api/stats response.
-type ServerStats struct {
-	ChatStats struct {
-		TotalMessages  int `json:"total_messages"`
-		WaitingClients int `json:"waiting_clients"`
-		MaxWaiters     int `json:"max_waiters"`
-	} `json:"chat_stats"`
-	ActiveClients int    `json:"active_clients"`
-	Status        string `json:"status"`
-}
-
-// FetchStats calls GET /api/stats and returns the parsed result.
-// Uses a short 5-second timeout — stats are non-critical, failure is silent.
-func (nc *NetworkClient) FetchStats() (*ServerStats, error) {
-	params := url.Values{}
-	params.Set("access_key", serverAccessKey)
-	params.Set("client_id", nc.clientID)
-
-	client := &http.Client{Timeout: 5 * time.Second}
-	resp, err := client.Get(nc.serverURL + "/api/stats?" + params.Encode())
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("stats HTTP %d", resp.StatusCode)
-	}
-
-	var stats ServerStats
-	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
-		return nil, fmt.Errorf("decode stats: %w", err)
-	}
-	return &stats, nil
-}
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cli-client/audit"
+	"cli-client/crypto"
+	"cli-client/models"
+
+	"github.com/rivo/tview"
+)
+
+var DefaultServerURL = "http://tccbackend-production-831d.up.railway.app"
+
+const serverAccessKey = "secure_chat_key_2024"
+
+// ── Wire types ────────────────────────────────────────────────────────────────
+
+// sendRequest is the /api/send body; the token goes in the Authorization
+// header (see request_signing.go).
+type sendRequest struct {
+	Username string          `json:"username"`
+	Content  string          `json:"content"`
+	Color    string          `json:"color"`
+	Room     string          `json:"room"`
+	To       string          `json:"to,omitempty"`       // direct message recipient
+	Sig      string          `json:"sig,omitempty"`      // identity key signature, see signatures.go
+	Fwd      *models.Forward `json:"fwd,omitempty"`      // provenance of a forwarded message, see forward.go
+	Type     string          `json:"type,omitempty"`     // "action" for /me, see actions.go
+	ReplyTo  string          `json:"reply_to,omitempty"` // message answered, see replies.go
+	XPost    []sendCopy      `json:"xpost,omitempty"`    // copies for other rooms, see xpost.go
+}
+
+// sendCopy is a cross-posted message's copy for one more room.
+type sendCopy struct {
+	Room    string `json:"room"`
+	Content string `json:"content"`
+	Sig     string `json:"sig,omitempty"`
+}
+
+type sendResponse struct {
+	Status string `json:"status"`
+	ID     string `json:"id"`
+	Time   string `json:"time"`
+}
+
+type loginRequest struct {
+	AccessKey string `json:"access_key"`
+	ClientID  string `json:"client_id"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	// IdentityKey publishes the client's public identity key, if any, and
+	// DHKey/DHKeySig its X25519 key for direct messages.
+	IdentityKey string `json:"identity_key,omitempty"`
+	DHKey       string `json:"dh_key,omitempty"`
+	DHKeySig    string `json:"dh_key_sig,omitempty"`
+	Protocol    int    `json:"protocol"` // newest message format spoken, see protocol.go
+}
+
+// PublishedKeys are a client's public keys: its own, published at login,
+// or a peer's, from GET /api/keys. DHKey is empty for clients from before
+// direct messages.
+type PublishedKeys struct {
+	Username    string `json:"username,omitempty"`
+	IdentityKey string `json:"identity_key"`
+	DHKey       string `json:"dh_key,omitempty"`
+	DHKeySig    string `json:"dh_key_sig,omitempty"`
+}
+
+type loginResponse struct {
+	Status     string `json:"status"`
+	Username   string `json:"username"`
+	Token      string `json:"token"`
+	ExpiresAt  string `json:"expires_at"`
+	SigningKey string `json:"signing_key"` // base64url; absent from relays before request signing
+	Protocol   int    `json:"protocol"`    // message format version; absent from relays before versions
+}
+
+// Session is what a successful login or registration hands back: the signed
+// token that authenticates send/poll in place of the shared access key.
+type Session struct {
+	Username   string
+	Token      string
+	ExpiresAt  time.Time
+	SigningKey []byte // signs the requests made with Token, nil = unsigned
+	Protocol   int    // message format version the relay agreed to, 0 = 1
+}
+
+type historyResponse struct {
+	Room     string          `json:"room"`
+	Messages json.RawMessage `json:"messages"`
+	HasMore  bool            `json:"has_more"`
+}
+
+type pollMessage struct {
+	Username  string
+	To        string          // direct message recipient, "" for room messages
+	Sig       string          // sender's signature, "" from older clients
+	System    bool            // announcement from the relay's operator
+	Fwd       *models.Forward // provenance of a forwarded message
+	Action    bool            // /me action; protocol v2 only
+	ReplyTo   string          // ID of the message answered; protocol v2 only
+	AlsoIn    []string        // other rooms of a cross-post; protocol v2 only
+	Mentions  []string        // users the relay found @mentioned; protocol v2 only
+	Nick      *nickChange     // set on a nick change announcement; protocol v2 only
+	Reaction  *reactionEvent  // set on a reaction event; protocol v2 only
+	Content   string
+	Color     string
+	ID        string
+	Seq       uint64 // relay sequence number; protocol v2 only
+	Timestamp time.Time
+}
+
+var knownPollKeys = map[string]bool{
+	"color":     true,
+	"fwd":       true,
+	"id":        true,
+	"sig":       true,
+	"system":    true,
+	"timestamp": true,
+	"to":        true,
+}
+
+// ProtocolViolation describes one way a poll entry deviated from the wire format.
+type ProtocolViolation struct {
+	Index  int    // position of the entry in the poll array
+	Kind   string // short machine-readable kind, e.g. "unknown_field"
+	Detail string
+}
+
+// parsePollMessages parses the raw JSON array from /api/poll.
+// Logs every step so the last line before a crash identifies the bad message.
+//
+// In strict mode every entry is also checked for protocol conformance and the
+// problems are returned as violations — malformed entries are still skipped,
+// but drift between client and server no longer goes unnoticed. The count
+// of skipped entries is returned with them.
+func parsePollMessages(data []byte, strict bool) ([]*pollMessage, []ProtocolViolation, int, error) {
+	log.Printf("TRACE parsePollMessages: raw body (%d bytes): %.500s", len(data), data)
+
+	var rawList []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &rawList); err != nil {
+		log.Printf("TRACE parsePollMessages: unmarshal error: %v", err)
+		return nil, nil, 0, fmt.Errorf("parse poll array: %w", err)
+	}
+	log.Printf("TRACE parsePollMessages: parsed %d entries", len(rawList))
+
+	var violations []ProtocolViolation
+	violate := func(i int, kind, format string, args ...interface{}) {
+		if strict {
+			violations = append(violations, ProtocolViolation{i, kind, fmt.Sprintf(format, args...)})
+		}
+	}
+
+	msgs := make([]*pollMessage, 0, len(rawList))
+	for i, raw := range rawList {
+		log.Printf("TRACE parsePollMessages: entry[%d] keys=%v", i, mapKeys(raw))
+		msg := &pollMessage{}
+
+		if v, ok := raw["color"]; ok {
+			if err := json.Unmarshal(v, &msg.Color); err != nil {
+				violate(i, "bad_type", "color is not a string: %.40s", v)
+			}
+		}
+		if v, ok := raw["fwd"]; ok {
+			if err := json.Unmarshal(v, &msg.Fwd); err != nil || (msg.Fwd != nil && msg.Fwd.From == "") {
+				violate(i, "bad_type", "fwd is not a forward: %.60s", v)
+				msg.Fwd = nil
+			}
+		}
+		if v, ok := raw["id"]; ok {
+			if err := json.Unmarshal(v, &msg.ID); err != nil {
+				violate(i, "bad_type", "id is not a string: %.40s", v)
+			}
+		}
+		if v, ok := raw["sig"]; ok {
+			if err := json.Unmarshal(v, &msg.Sig); err != nil {
+				violate(i, "bad_type", "sig is not a string: %.40s", v)
+			}
+		}
+		if v, ok := raw["system"]; ok {
+			if err := json.Unmarshal(v, &msg.System); err != nil {
+				violate(i, "bad_type", "system is not a boolean: %.40s", v)
+			}
+		}
+		if v, ok := raw["timestamp"]; ok {
+			if err := json.Unmarshal(v, &msg.Timestamp); err != nil {
+				violate(i, "bad_timestamp", "unparsable timestamp %.40s", v)
+			}
+		}
+		if v, ok := raw["to"]; ok {
+			if err := json.Unmarshal(v, &msg.To); err != nil {
+				violate(i, "bad_type", "to is not a string: %.40s", v)
+			}
+		}
+
+		// Every key that is not a known field is a candidate username.
+		// Exactly one is expected; more means the server added a field we
+		// don't know about (or a user is named like one).
+		var userKeys []string
+		for key := range raw {
+			if !knownPollKeys[key] {
+				userKeys = append(userKeys, key)
+			}
+		}
+		sort.Strings(userKeys)
+		if len(userKeys) > 1 {
+			violate(i, "unknown_field", "ambiguous username keys %v", userKeys)
+		}
+		if len(userKeys) > 0 {
+			msg.Username = userKeys[0]
+			if err := json.Unmarshal(raw[msg.Username], &msg.Content); err != nil {
+				violate(i, "bad_type", "content for %q is not a string", msg.Username)
+			}
+		}
+
+		log.Printf("TRACE parsePollMessages: entry[%d] id=%q user=%q color=%q content=%.80q",
+			i, msg.ID, msg.Username, msg.Color, msg.Content)
+
+		if strings.TrimSpace(msg.Username) == "" {
+			violate(i, "empty_username", "entry id=%q has no username", msg.ID)
+		}
+		if msg.Content == "" {
+			violate(i, "empty_content", "entry id=%q from %q has no content", msg.ID, msg.Username)
+		}
+		if msg.ID == "" {
+			violate(i, "missing_id", "entry from %q has no id", msg.Username)
+		}
+
+		if msg.Username == "" || msg.Content == "" || msg.ID == "" {
+			log.Printf("TRACE parsePollMessages: entry[%d] SKIPPED (malformed)", i)
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	log.Printf("TRACE parsePollMessages: returning %d valid messages, %d violations", len(msgs), len(violations))
+	return msgs, violations, len(rawList) - len(msgs), nil
+}
+
+func mapKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// ── NetworkClient ─────────────────────────────────────────────────────────────
+
+type NetworkClient struct {
+	serverURL  string
+	token      string
+	signingKey []byte // signs every request, nil = unsigned; see request_signing.go
+	room       string
+	protocol   int // message format version, see protocol.go
+	app        *tview.Application
+
+	httpClient *http.Client
+	sse        int32 // atomic: 1 = receive over /api/stream, see stream.go
+	ws         int32 // atomic: 1 = receive over /api/ws, see websocket.go
+	msgpack    int32 // atomic: 1 = ask for MessagePack, see encoding.go
+	stopped    int32
+	stopCh     chan struct{}
+	strict     int32 // atomic: 1 = report protocol violations
+	stats      *models.SessionStats
+
+	// relayMsgpack is 1 (atomic) once the relay answered in MessagePack.
+	relayMsgpack int32
+
+	lastIDMu sync.Mutex
+	lastID   string
+	lastSeq  uint64 // see sequence.go
+
+	// wakeCh cuts a backoff or idle wait short; pollCancel aborts the
+	// in-flight long poll. Both are used by Wake after a suspend.
+	wakeCh       chan struct{}
+	pollCancelMu sync.Mutex
+	pollCancel   context.CancelFunc
+
+	sentIDsMu sync.Mutex
+	sentIDs   map[string]struct{}
+
+	pendingMu sync.Mutex
+	pending   []outboundMessage // offline queue, oldest first
+	flushing  int32             // atomic: 1 = flushPending running
+
+	// Send coalescing — see coalesce.go.
+	outMu   sync.Mutex
+	outbox  []outboundMessage // waiting for the send in flight, oldest first
+	sending bool              // a sendLoop is running
+	noBatch int32             // atomic: 1 = the relay has no /api/send/batch
+
+	// self and identity open direct messages, see direct.go.
+	self     string
+	identity *crypto.Identity
+
+	// senderKeys looks up the key incoming messages are verified with.
+	senderKeys func(username string) (string, bool)
+
+	// Privacy mode — see privacy.go.
+	gc          *crypto.GlobalCrypto
+	privacy     int32 // atomic: 1 = pad outgoing messages and send decoys
+	e2eRequired int32 // atomic: 1 = never send plaintext into this room
+	decoyMu     sync.Mutex
+	decoyUser   string
+	decoyColor  string
+
+	// Security events (see audit package); certFP is the TLS certificate
+	// fingerprint the relay presented first.
+	onSecurity func(audit.Event)
+	certMu     sync.Mutex
+	certFP     string
+
+	onMessage      func(msg *models.Message)
+	onAnnouncement func(id, text string)
+	onNick         func(id string, change nickChange)
+	onReaction     func(seq uint64, event reactionEvent)
+	onSent         func(localID, id string)
+	onGap          func()
+	onStatusChange func(connected bool, msg string)
+	onDelivery     func(localID string, state models.DeliveryState)
+}
+
+func NewNetworkClient(
+	app *tview.Application,
+	serverURL string,
+	token string,
+	room string,
+	stats *models.SessionStats,
+	gc *crypto.GlobalCrypto,
+	onMessage func(msg *models.Message),
+	onStatusChange func(connected bool, msg string),
+	onDelivery func(localID string, state models.DeliveryState),
+) *NetworkClient {
+	log.Printf("TRACE NewNetworkClient: url=%s room=%s", serverURL, room)
+	return &NetworkClient{
+		serverURL:      serverURL,
+		token:          token,
+		room:           room,
+		app:            app,
+		httpClient:     relayClient(40 * time.Second),
+		stopCh:         make(chan struct{}),
+		wakeCh:         make(chan struct{}, 1),
+		stats:          stats,
+		sentIDs:        make(map[string]struct{}),
+		gc:             gc,
+		onMessage:      onMessage,
+		onStatusChange: onStatusChange,
+		onDelivery:     onDelivery,
+	}
+}
+
+// GenerateClientID returns a random client id. The AppController creates one
+// per run so the id the server bound at login is the one used to send.
+func GenerateClientID() string {
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return fmt.Sprintf("client_%d", r.Int63n(1_000_000_000))
+}
+
+func (nc *NetworkClient) Start() {
+	log.Printf("TRACE NetworkClient.Start: launching pollLoop goroutine")
+	go nc.pollLoop()
+	go nc.decoyLoop()
+}
+
+// SendMessage relays a message. localID is the ID of the optimistic copy
+// already shown in the chat; every change of its delivery state is reported
+// back through onDelivery under that ID.
+func (nc *NetworkClient) SendMessage(localID, username, content, colorTag string) {
+	if atomic.LoadInt32(&nc.stopped) == 1 {
+		return
+	}
+	log.Printf("TRACE NetworkClient.SendMessage: user=%q content=%.60q color=%q", username, content, colorTag)
+	nc.send(outboundMessage{localID: localID, username: username, content: content, colorTag: colorTag})
+}
+
+// send relays m now, or queues it behind messages already waiting.
+func (nc *NetworkClient) send(m outboundMessage) {
+	if nc.PendingCount() > 0 {
+		// Keep order: queue behind what is already waiting and try a flush.
+		nc.enqueue(m)
+		go nc.flushPending()
+		return
+	}
+	nc.coalesce(m)
+}
+
+func (nc *NetworkClient) Stop() {
+	if atomic.CompareAndSwapInt32(&nc.stopped, 0, 1) {
+		log.Printf("TRACE NetworkClient.Stop: closing stopCh")
+		close(nc.stopCh)
+		// An open stream would otherwise linger until the next keepalive.
+		nc.pollCancelMu.Lock()
+		if nc.pollCancel != nil {
+			nc.pollCancel()
+		}
+		nc.pollCancelMu.Unlock()
+	}
+}
+
+// SetSecurityHandler installs the callback that receives security events
+// such as decryption failures and server certificate changes. Call before
+// Start. The callback runs on network goroutines.
+func (nc *NetworkClient) SetSecurityHandler(fn func(audit.Event)) {
+	nc.onSecurity = fn
+}
+
+func (nc *NetworkClient) reportSecurity(e audit.Event) {
+	log.Printf("TRACE security event: kind=%s peer=%q detail=%q", e.Kind, e.Peer, e.Detail)
+	if nc.onSecurity != nil {
+		nc.onSecurity(e)
+	}
+}
+
+// checkServerCert compares the relay's TLS certificate with the first one
+// seen this session and reports a change. Plain HTTP responses are ignored.
+func (nc *NetworkClient) checkServerCert(resp *http.Response) {
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return
+	}
+	sum := sha256.Sum256(resp.TLS.PeerCertificates[0].Raw)
+	fp := hex.EncodeToString(sum[:])
+
+	nc.certMu.Lock()
+	old := nc.certFP
+	nc.certFP = fp
+	nc.certMu.Unlock()
+
+	if old != "" && old != fp {
+		nc.reportSecurity(audit.Event{
+			Kind:   audit.ServerCert,
+			Peer:   resp.Request.URL.Host,
+			Detail: fmt.Sprintf("certificate sha256 changed from %s to %s", old, fp),
+		})
+	}
+}
+
+// SetStrictMode turns protocol conformance checking of poll responses on or off.
+func (nc *NetworkClient) SetStrictMode(strict bool) {
+	if strict {
+		atomic.StoreInt32(&nc.strict, 1)
+	} else {
+		atomic.StoreInt32(&nc.strict, 0)
+	}
+}
+
+// Wake aborts the current long poll and skips any reconnect backoff so the
+// poll loop talks to the server again right away. Used after the process was
+// suspended, when the open connection has most likely gone stale.
+// Safe to call from any goroutine.
+func (nc *NetworkClient) Wake() {
+	log.Printf("TRACE NetworkClient.Wake")
+	nc.pollCancelMu.Lock()
+	if nc.pollCancel != nil {
+		nc.pollCancel()
+	}
+	nc.pollCancelMu.Unlock()
+	select {
+	case nc.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// ResumeAfter makes the poll loop continue after the message id, numbered
+// seq (0 if unknown), instead of replaying the server's recent backlog.
+// Call before Start, once history has been shown.
+func (nc *NetworkClient) ResumeAfter(id string, seq uint64) {
+	nc.lastIDMu.Lock()
+	nc.lastID = id
+	nc.lastSeq = seq
+	nc.lastIDMu.Unlock()
+}
+
+// LastID returns the poll cursor: the newest message delivered so far, or ""
+// before the first one.
+func (nc *NetworkClient) LastID() string {
+	nc.lastIDMu.Lock()
+	defer nc.lastIDMu.Unlock()
+	return nc.lastID
+}
+
+// ServerURL returns the relay server base URL this client is connected to.
+func (nc *NetworkClient) ServerURL() string {
+	return nc.serverURL
+}
+
+// ── Send ──────────────────────────────────────────────────────────────────────
+
+// outboundMessage is a send waiting in the offline queue.
+type outboundMessage struct {
+	localID  string // models.Message.ID of the optimistic copy in the chat
+	username string
+	to       string // direct message recipient, "" = the room
+	room     string // another room than nc.room (forwards), "" = nc.room
+	content  string
+	colorTag string
+	sealed   bool            // content is already an envelope (decoys, direct messages, forwards to other rooms)
+	fwd      *models.Forward // provenance of a forwarded message
+	action   bool            // a /me action
+	replyTo  string          // relay ID of the message answered, "" if none
+	xpost    []xpostCopy     // copies for other rooms, already sealed
+}
+
+type sendOutcome int
+
+const (
+	sendDelivered sendOutcome = iota
+	sendRetry                 // relay unreachable or failing — keep queued
+	sendRejected              // refused for good (401/403/4xx) — marked failed
+)
+
+func (nc *NetworkClient) setDelivery(localID string, state models.DeliveryState) {
+	if nc.onDelivery != nil {
+		nc.onDelivery(localID, state)
+	}
+}
+
+// deliver POSTs one message to /api/send and reports what should happen to it.
+func (nc *NetworkClient) deliver(m outboundMessage) sendOutcome {
+	log.Printf("TRACE deliver: building request user=%q content=%.60q", m.username, m.content)
+	body, ok := nc.wireRequest(m)
+	if !ok {
+		return sendRejected
+	}
+	contentType, encoded, err := nc.encodeBody(body)
+	if err != nil {
+		log.Printf("TRACE deliver: marshal error: %v", err)
+		return sendRejected
+	}
+
+	log.Printf("TRACE deliver: POST %s%s", nc.serverURL, nc.messagePath("send"))
+	resp, err := nc.relayPost(nc.httpClient, nc.messagePath("send"), nil, contentType, encoded)
+	if err != nil {
+		log.Printf("TRACE deliver: POST error: %v", err)
+		return sendRetry
+	}
+	defer resp.Body.Close()
+	log.Printf("TRACE deliver: POST status=%d", resp.StatusCode)
+
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
+		var sr sendResponse
+		if err := nc.decodeBody(resp, &sr); err == nil && sr.ID != "" {
+			nc.recordSent(m, sr.ID)
+		}
+		return sendDelivered
+	}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+	return nc.refused(resp.StatusCode, strings.TrimSpace(string(raw)))
+}
+
+// wireRequest seals and signs m for the relay; false means it must not be
+// sent at all.
+func (nc *NetworkClient) wireRequest(m outboundMessage) (sendRequest, bool) {
+	content := m.content
+	if !m.sealed {
+		var err error
+		if content, err = nc.sealContent(m.content); err != nil {
+			log.Printf("TRACE deliver: encrypt error: %v", err)
+			return sendRequest{}, false
+		}
+	}
+	room := nc.room
+	if m.room != "" {
+		// The sender already sealed it as that room requires.
+		room = m.room
+	} else if nc.E2ERequired() && !crypto.HasEnvelope(content) {
+		log.Printf("TRACE deliver: refusing plaintext in E2E-required room %q", nc.room)
+		return sendRequest{}, false
+	}
+	req := sendRequest{
+		Username: m.username,
+		Content:  content,
+		Color:    m.colorTag,
+		Room:     room,
+		To:       m.to,
+		Sig:      nc.sign(m.username, room, m.to, content),
+		Fwd:      m.fwd,
+		ReplyTo:  m.replyTo,
+	}
+	if m.action {
+		req.Type = actionType
+	}
+	for _, x := range m.xpost {
+		req.XPost = append(req.XPost, sendCopy{Room: x.room, Content: x.content, Sig: nc.sign(m.username, x.room, "", x.content)})
+	}
+	return req, true
+}
+
+// recordSent notes the ID the relay gave m, so its echo isn't shown twice.
+func (nc *NetworkClient) recordSent(m outboundMessage, id string) {
+	log.Printf("TRACE deliver: server assigned id=%q", id)
+	if nc.stats != nil && (!m.sealed || m.to != "" || m.room != "") {
+		nc.stats.RecordSent()
+	}
+	nc.sentIDsMu.Lock()
+	nc.sentIDs[id] = struct{}{}
+	nc.sentIDsMu.Unlock()
+	if nc.onSent != nil {
+		nc.onSent(m.localID, id)
+	}
+}
+
+// refused reports what should happen to a message the relay answered with
+// status instead of sending it; detail is the relay's explanation.
+func (nc *NetworkClient) refused(status int, detail string) sendOutcome {
+	switch {
+	case status == http.StatusUnauthorized:
+		nc.notifyStatus(false, "Server rejected session token — restart and log in again.")
+		return sendRejected
+	case status == http.StatusForbidden:
+		nc.notifyStatus(true, fmt.Sprintf("Message refused: %s", detail))
+		return sendRejected
+	case status == http.StatusTooManyRequests, status >= 500:
+		log.Printf("TRACE deliver: relay busy/failing status=%d — will retry", status)
+		return sendRetry
+	default:
+		log.Printf("TRACE deliver: unexpected status %d body=%.120s", status, detail)
+		return sendRejected
+	}
+}
+
+// ── Offline queue ─────────────────────────────────────────────────────────────
+//
+// A send that fails because the relay is unreachable is queued instead of
+// dropped and shown as pending in the chat. The queue is flushed, in order,
+// whenever a poll succeeds again. While anything is queued, new messages
+// line up behind it so the conversation never arrives out of order.
+
+func (nc *NetworkClient) enqueue(m outboundMessage) {
+	nc.pendingMu.Lock()
+	first := len(nc.pending) == 0
+	nc.pending = append(nc.pending, m)
+	nc.pendingMu.Unlock()
+
+	log.Printf("TRACE enqueue: id=%q queued (first=%v)", m.localID, first)
+	nc.setDelivery(m.localID, models.DeliveryQueued)
+	if first {
+		nc.notifyStatus(false, "Relay unreachable — message queued, it will be resent on reconnect.")
+	}
+}
+
+// PendingCount returns how many messages wait in the offline queue.
+func (nc *NetworkClient) PendingCount() int {
+	nc.pendingMu.Lock()
+	defer nc.pendingMu.Unlock()
+	return len(nc.pending)
+}
+
+// DropPending discards every queued message without sending it and returns
+// how many there were.
+func (nc *NetworkClient) DropPending() int {
+	nc.pendingMu.Lock()
+	defer nc.pendingMu.Unlock()
+	n := len(nc.pending)
+	nc.pending = nil
+	return n
+}
+
+// flushPending resends queued messages oldest first and stops at the first
+// one the relay still can't take. Only one flush runs at a time.
+func (nc *NetworkClient) flushPending() {
+	if !atomic.CompareAndSwapInt32(&nc.flushing, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&nc.flushing, 0)
+
+	for atomic.LoadInt32(&nc.stopped) == 0 {
+		nc.pendingMu.Lock()
+		if len(nc.pending) == 0 {
+			nc.pendingMu.Unlock()
+			return
+		}
+		m := nc.pending[0]
+		nc.pendingMu.Unlock()
+
+		outcome := nc.deliver(m)
+		if outcome == sendRetry {
+			log.Printf("TRACE flushPending: relay still unavailable, %d queued", nc.PendingCount())
+			return
+		}
+
+		nc.pendingMu.Lock()
+		nc.pending = nc.pending[1:]
+		nc.pendingMu.Unlock()
+		log.Printf("TRACE flushPending: id=%q outcome=%d", m.localID, outcome)
+		if outcome == sendDelivered {
+			nc.setDelivery(m.localID, models.DeliverySent)
+		} else {
+			nc.setDelivery(m.localID, models.DeliveryFailed)
+		}
+	}
+}
+
+// ── Poll loop ─────────────────────────────────────────────────────────────────
+
+func (nc *NetworkClient) pollLoop() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PANIC NetworkClient.pollLoop: %v", r)
+		}
+	}()
+
+	backoff := 1 * time.Second
+	const maxBackoff = 30 * time.Second
+	firstConnect := true
+	wasConnected := false
+	iteration := 0
+
+	// connected runs once the relay answered: a poll, or a stream opening.
+	connected := func() {
+		if firstConnect || !wasConnected {
+			nc.notifyStatus(true, fmt.Sprintf("Connected to relay at %s", nc.serverURL))
+		}
+		if nc.PendingCount() > 0 {
+			go nc.flushPending()
+		}
+		backoff = 1 * time.Second
+		firstConnect = false
+		wasConnected = true
+	}
+
+	for {
+		iteration++
+		if atomic.LoadInt32(&nc.stopped) == 1 {
+			log.Printf("TRACE pollLoop[%d]: stopped, exiting", iteration)
+			return
+		}
+
+		var msgs []*pollMessage
+		var err error
+		if atomic.LoadInt32(&nc.ws) == 1 {
+			log.Printf("TRACE pollLoop[%d]: opening websocket, lastID=%q", iteration, nc.LastID())
+			err = nc.websocket(connected)
+			if errors.Is(err, errNoWebSocket) {
+				atomic.StoreInt32(&nc.ws, 0)
+				atomic.StoreInt32(&nc.sse, 1)
+				nc.notifyStatus(true, "Relay has no WebSocket endpoint — falling back to Server-Sent Events.")
+				continue
+			}
+		} else if atomic.LoadInt32(&nc.sse) == 1 {
+			log.Printf("TRACE pollLoop[%d]: opening stream, lastID=%q", iteration, nc.LastID())
+			err = nc.stream(connected)
+			if errors.Is(err, errNoStream) {
+				atomic.StoreInt32(&nc.sse, 0)
+				nc.notifyStatus(true, "Relay has no event stream — falling back to long polling.")
+				continue
+			}
+		} else {
+			log.Printf("TRACE pollLoop[%d]: calling poll(), lastID=%q", iteration, nc.LastID())
+			msgs, err = nc.poll()
+		}
+		if errors.Is(err, context.Canceled) {
+			// Woken on purpose — poll again at once, the connection itself is fine.
+			log.Printf("TRACE pollLoop[%d]: poll cancelled by Wake", iteration)
+			backoff = 1 * time.Second
+			continue
+		}
+		if err != nil {
+			log.Printf("TRACE pollLoop[%d]: poll error: %v", iteration, err)
+			if atomic.LoadInt32(&nc.stopped) == 1 {
+				return // replaced (/server, /nick): the error is the old session's
+			}
+			if errors.Is(err, ErrPinMismatch) && (firstConnect || wasConnected) {
+				nc.reportSecurity(audit.Event{Kind: audit.PinMismatch, Peer: nc.serverURL, Detail: err.Error()})
+			}
+			if firstConnect {
+				nc.notifyStatus(false, fmt.Sprintf("Cannot reach server at %s", nc.serverURL))
+			} else if wasConnected {
+				nc.notifyStatus(false, fmt.Sprintf("Connection lost — reconnecting in %v…", backoff))
+			}
+			wasConnected = false
+			select {
+			case <-nc.stopCh:
+				return
+			case <-nc.wakeCh:
+				backoff = 1 * time.Second
+				continue
+			case <-time.After(backoff):
+			}
+			backoff = minDur(backoff*2, maxBackoff)
+			continue
+		}
+
+		connected()
+
+		log.Printf("TRACE pollLoop[%d]: poll returned %d messages (nil=%v)", iteration, len(msgs), msgs == nil)
+
+		for idx, msg := range msgs {
+			log.Printf("TRACE pollLoop[%d]: dispatching msg[%d] id=%q user=%q color=%q content=%.80q",
+				iteration, idx, msg.ID, msg.Username, msg.Color, msg.Content)
+			nc.handleIncoming(msg)
+			log.Printf("TRACE pollLoop[%d]: msg[%d] dispatch complete", iteration, idx)
+		}
+
+		if msgs == nil {
+			select {
+			case <-nc.stopCh:
+				return
+			case <-nc.wakeCh:
+			case <-time.After(500 * time.Millisecond):
+			}
+		}
+	}
+}
+
+func (nc *NetworkClient) poll() ([]*pollMessage, error) {
+	nc.lastIDMu.Lock()
+	lastID, lastSeq := nc.lastID, nc.lastSeq
+	nc.lastIDMu.Unlock()
+
+	params := url.Values{}
+	params.Set("room", nc.room)
+	if lastID != "" {
+		params.Set("last_id", lastID)
+	}
+	if lastSeq > 0 {
+		params.Set("last_seq", strconv.FormatUint(lastSeq, 10))
+	}
+
+	ctx, done := nc.pollContext()
+	defer done()
+
+	log.Printf("TRACE poll: GET %s%s lastID=%q", nc.serverURL, nc.messagePath("poll"), lastID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, nc.serverURL+nc.messagePath("poll")+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	nc.authorize(req, nil)
+	nc.negotiate(req)
+
+	resp, err := nc.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	log.Printf("TRACE poll: response status=%d", resp.StatusCode)
+	nc.checkServerCert(resp)
+
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent {
+		nc.noteCursor(resp.Header)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		log.Printf("TRACE poll: 204 no content")
+		return nil, nil
+
+	case http.StatusUnauthorized:
+		return nil, fmt.Errorf("server rejected session token — log in again")
+
+	case http.StatusOK:
+		rawBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("read poll body: %w", err)
+		}
+		log.Printf("TRACE poll: 200 body=%d bytes", len(rawBody))
+		msgs, violations, err := nc.parseMessages(rawBody, nc.isMsgpack(resp), atomic.LoadInt32(&nc.strict) == 1)
+		if err != nil {
+			return nil, err
+		}
+		if nc.stats != nil {
+			for _, v := range violations {
+				nc.stats.RecordViolation(v.Kind, fmt.Sprintf("entry[%d] %s", v.Index, v.Detail))
+			}
+		}
+		if len(msgs) > 0 {
+			nc.lastIDMu.Lock()
+			nc.lastID = msgs[len(msgs)-1].ID
+			nc.lastIDMu.Unlock()
+			log.Printf("TRACE poll: advanced lastID to %q", nc.lastID)
+		}
+		return msgs, nil
+
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("unexpected HTTP %d: %.120s", resp.StatusCode, body)
+	}
+}
+
+// pollContext returns the context for one poll or stream, which Wake and
+// Stop cancel, and the func that releases it.
+func (nc *NetworkClient) pollContext() (context.Context, func()) {
+	// Drain a stale wake-up so an old Wake doesn't cut this fresh poll short.
+	select {
+	case <-nc.wakeCh:
+	default:
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	nc.pollCancelMu.Lock()
+	nc.pollCancel = cancel
+	nc.pollCancelMu.Unlock()
+	return ctx, func() {
+		nc.pollCancelMu.Lock()
+		nc.pollCancel = nil
+		nc.pollCancelMu.Unlock()
+		cancel()
+	}
+}
+
+// ── History ───────────────────────────────────────────────────────────────────
+
+// FetchHistory loads one page of the room's backlog from /api/history:
+// up to limit messages older than before (newest page when before is ""),
+// oldest first. more reports whether an older page exists. Reaction events
+// in the page go to the reaction handler instead.
+func (nc *NetworkClient) FetchHistory(before string, limit int) (msgs []*models.Message, more bool, err error) {
+	params := url.Values{}
+	params.Set("room", nc.room)
+	params.Set("limit", fmt.Sprint(limit))
+	if before != "" {
+		params.Set("before", before)
+	}
+
+	log.Printf("TRACE FetchHistory: GET %s%s before=%q limit=%d", nc.serverURL, nc.messagePath("history"), before, limit)
+	client := relayClient(10 * time.Second)
+	resp, err := nc.relayGet(client, nc.messagePath("history"), params)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return nil, false, fmt.Errorf("server rejected session token — log in again")
+	default:
+		return nil, false, fmt.Errorf("history HTTP %d", resp.StatusCode)
+	}
+
+	page, packed, err := nc.readHistory(resp)
+	if err != nil {
+		return nil, false, fmt.Errorf("decode history: %w", err)
+	}
+	if len(page.Messages) == 0 {
+		return nil, page.HasMore, nil
+	}
+	entries, violations, err := nc.parseMessages(page.Messages, packed, atomic.LoadInt32(&nc.strict) == 1)
+	if err != nil {
+		return nil, false, err
+	}
+	if nc.stats != nil {
+		for _, v := range violations {
+			nc.stats.RecordViolation(v.Kind, fmt.Sprintf("history[%d] %s", v.Index, v.Detail))
+		}
+	}
+
+	msgs = make([]*models.Message, 0, len(entries))
+	for _, e := range entries {
+		if e.Reaction != nil {
+			if nc.onReaction != nil {
+				nc.onReaction(e.Seq, *e.Reaction)
+			}
+			continue
+		}
+		if e.System {
+			text := announcementText(e.Content)
+			if e.Nick != nil {
+				text = nickText(*e.Nick)
+			}
+			msgs = append(msgs, &models.Message{
+				ID:        e.ID,
+				Username:  e.Username,
+				Content:   text,
+				Timestamp: e.Timestamp.Local(),
+				IsSystem:  true,
+			})
+			continue
+		}
+		content, keep := nc.openEntry(e)
+		if !keep {
+			continue
+		}
+		msgs = append(msgs, entryMessage(e, content, nc.checkSender(e)))
+	}
+	log.Printf("TRACE FetchHistory: %d messages, more=%v", len(msgs), page.HasMore)
+	return msgs, page.HasMore, nil
+}
+
+// entryMessage turns a polled or history entry, opened to content, into a
+// chat message.
+func entryMessage(e *pollMessage, content string, check models.SenderCheck) *models.Message {
+	ts := e.Timestamp
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	color := e.Color
+	if color == "" {
+		color = models.GetUsernameColor(e.Username)
+	} else if !strings.HasPrefix(color, "[") {
+		color = models.ParseColorToTag(color)
+	}
+	return &models.Message{
+		ID:        e.ID,
+		Seq:       e.Seq,
+		Username:  e.Username,
+		To:        e.To,
+		Content:   content,
+		Timestamp: ts.Local(),
+		Color:     color,
+		Sender:    check,
+		Forwarded: e.Fwd,
+		Action:    e.Action,
+		ReplyTo:   e.ReplyTo,
+		AlsoIn:    e.AlsoIn,
+		Mentions:  e.Mentions,
+	}
+}
+
+func (nc *NetworkClient) handleIncoming(msg *pollMessage) {
+	log.Printf("TRACE handleIncoming: checking sentIDs for id=%q", msg.ID)
+	nc.sentIDsMu.Lock()
+	_, isMine := nc.sentIDs[msg.ID]
+	if isMine {
+		delete(nc.sentIDs, msg.ID)
+	}
+	nc.sentIDsMu.Unlock()
+
+	if isMine {
+		log.Printf("TRACE handleIncoming: id=%q is mine, skipping echo", msg.ID)
+		return
+	}
+
+	if msg.Reaction != nil {
+		log.Printf("TRACE handleIncoming: reaction id=%q to %q", msg.ID, msg.Reaction.MessageID)
+		if nc.onReaction != nil {
+			nc.onReaction(msg.Seq, *msg.Reaction)
+		}
+		return
+	}
+	if msg.System && msg.Nick != nil {
+		log.Printf("TRACE handleIncoming: nick change id=%q %q → %q", msg.ID, msg.Nick.From, msg.Nick.To)
+		if nc.onNick != nil {
+			nc.onNick(msg.ID, *msg.Nick)
+		}
+		return
+	}
+	if msg.System {
+		log.Printf("TRACE handleIncoming: announcement id=%q", msg.ID)
+		if nc.onAnnouncement != nil {
+			nc.onAnnouncement(msg.ID, msg.Content)
+		}
+		return
+	}
+
+	content, keep := nc.openEntry(msg)
+	if !keep {
+		return
+	}
+	check := nc.checkSender(msg)
+
+	if nc.stats != nil {
+		nc.stats.RecordReceived()
+	}
+
+	log.Printf("TRACE handleIncoming: calling onMessage user=%q color=%q sender=%d content=%.80q",
+		msg.Username, msg.Color, check, content)
+	if nc.onMessage != nil {
+		nc.onMessage(entryMessage(msg, content, check))
+	}
+	log.Printf("TRACE handleIncoming: onMessage returned for id=%q", msg.ID)
+}
+
+// countOpened counts an entry openEntry dropped (a decoy) or could only
+// show as undecryptableText.
+func (nc *NetworkClient) countOpened(text string, keep bool) {
+	switch {
+	case nc.stats == nil:
+	case !keep:
+		nc.stats.RecordDropped(models.DropDecoy, 1)
+	case text == undecryptableText:
+		nc.stats.RecordDropped(models.DropUndecryptable, 1)
+	}
+}
+
+func (nc *NetworkClient) notifyStatus(connected bool, msg string) {
+	log.Printf("TRACE notifyStatus: connected=%v msg=%q", connected, msg)
+	if nc.onStatusChange != nil {
+		nc.onStatusChange(connected, msg)
+	}
+}
+
+// ── Startup connectivity check ────────────────────────────────────────────────
+
+func CheckServerConnectivity(serverURL string) error {
+	log.Printf("TRACE CheckServerConnectivity: GET %s/health", serverURL)
+	client := relayClient(3 * time.Second)
+	resp, err := client.Get(serverURL + "/health")
+	if err != nil {
+		log.Printf("TRACE CheckServerConnectivity: error: %v", err)
+		return fmt.Errorf("relay server not available at %s: %w", serverURL, err)
+	}
+	resp.Body.Close()
+	log.Printf("TRACE CheckServerConnectivity: status=%d", resp.StatusCode)
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("relay server returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ── Announcements ─────────────────────────────────────────────────────────────
+
+// SetAnnouncementHandler installs the callback for announcements the relay's
+// operator broadcasts (POST /api/admin/announce). It is called from the poll
+// goroutine with the plain announcement text. Call before Start.
+func (nc *NetworkClient) SetAnnouncementHandler(fn func(id, text string)) {
+	nc.onAnnouncement = fn
+}
+
+// announcementText is how an announcement reads as a system line.
+func announcementText(text string) string {
+	return fmt.Sprintf("[yellow]📢 relay:[-] %s", tview.Escape(text))
+}
+
+// ── Message of the day ────────────────────────────────────────────────────────
+
+// Motd mirrors the /api/motd response.
+type Motd struct {
+	Motd string `json:"motd"`
+	// Announcements are the relay's kept announcements, for clients to
+	// show when they connect (see motd.go).
+	Announcements []Notice `json:"announcements"`
+	KDFSalt       string   `json:"kdf_salt"`
+	// MinClientVersion is the oldest client the relay still serves.
+	MinClientVersion string `json:"min_client_version"`
+	// RoomAccents maps rooms to the color their header is drawn in.
+	RoomAccents map[string]string `json:"room_accents"`
+	// Auth is how the relay logs users in; AuthDevice means device code
+	// sign-in (see device_login.go), anything else a password.
+	Auth string `json:"auth"`
+}
+
+// Notice is a kept announcement: one to every room that clients connecting
+// until Until are shown too.
+type Notice struct {
+	ID    string    `json:"id"`
+	Text  string    `json:"text"`
+	Time  time.Time `json:"time"`
+	Until time.Time `json:"until"`
+}
+
+// FetchMotd calls GET /api/motd. Relays from before the MOTD answer 404,
+// which comes back as an empty Motd — there is simply nothing to show.
+func FetchMotd(serverURL string) (*Motd, error) {
+	client := relayClient(5 * time.Second)
+	resp, err := client.Get(serverURL + "/api/motd")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return &Motd{}, nil
+	default:
+		return nil, fmt.Errorf("motd HTTP %d", resp.StatusCode)
+	}
+
+	var motd Motd
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 8<<10)).Decode(&motd); err != nil {
+		return nil, fmt.Errorf("decode motd: %w", err)
+	}
+	return &motd, nil
+}
+
+// ── Login ─────────────────────────────────────────────────────────────────────
+
+// ErrInvalidCredentials is returned by Login when the relay refused the
+// username or password. The relay doesn't say which, so an unknown user
+// isn't registered unless asked for (Register).
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// ClientTooOldError is the relay's 426 Upgrade Required answer to a login:
+// it no longer serves this client version. Message is the relay's reason.
+type ClientTooOldError struct {
+	Message string
+}
+
+func (e *ClientTooOldError) Error() string { return e.Message }
+
+// Login submits the credentials entered on the login screen to /api/login,
+// publishing keys unless they are empty.
+// The returned error is short and user-facing — the login view shows it inline.
+func Login(serverURL, clientID, username, password string, keys PublishedKeys) (*Session, error) {
+	resp, err := postCredentials(serverURL+"/api/login", clientID, username, password, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return decodeSession(resp)
+	case http.StatusUnauthorized, http.StatusNotFound: // older relays answer 404 for an unknown user
+		return nil, ErrInvalidCredentials
+	default:
+		return nil, credentialsError(resp)
+	}
+}
+
+// Register creates a new account on the relay so the username is owned by
+// whoever knows the password. The new account is logged in straight away.
+func Register(serverURL, clientID, username, password string, keys PublishedKeys) (*Session, error) {
+	resp, err := postCredentials(serverURL+"/api/register", clientID, username, password, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusCreated || resp.StatusCode == http.StatusOK {
+		return decodeSession(resp)
+	}
+	return nil, credentialsError(resp)
+}
+
+func decodeSession(resp *http.Response) (*Session, error) {
+	var lr loginResponse
+	if err := json.NewDecoder(resp.Body).Decode(&lr); err != nil || lr.Token == "" {
+		return nil, fmt.Errorf("server sent no session token")
+	}
+	expiresAt, _ := time.Parse(time.RFC3339, lr.ExpiresAt)
+	var signingKey []byte
+	if lr.SigningKey != "" {
+		key, err := base64.RawURLEncoding.DecodeString(lr.SigningKey)
+		if err != nil {
+			return nil, fmt.Errorf("server sent a malformed signing key")
+		}
+		signingKey = key
+	}
+	return &Session{
+		Username:   lr.Username,
+		Token:      lr.Token,
+		ExpiresAt:  expiresAt,
+		SigningKey: signingKey,
+		Protocol:   lr.Protocol,
+	}, nil
+}
+
+func postCredentials(endpoint, clientID, username, password string, keys PublishedKeys) (*http.Response, error) {
+	log.Printf("TRACE postCredentials: POST %s user=%q", endpoint, username)
+	bodyJSON, err := json.Marshal(loginRequest{
+		AccessKey:   serverAccessKey,
+		ClientID:    clientID,
+		Username:    username,
+		Password:    password,
+		IdentityKey: keys.IdentityKey,
+		DHKey:       keys.DHKey,
+		DHKeySig:    keys.DHKeySig,
+		Protocol:    clientProtocol,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not build request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(bodyJSON))
+	if err != nil {
+		return nil, fmt.Errorf("could not build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	signRequest(req, accessSigningKey(serverAccessKey), bodyJSON)
+
+	client := relayClient(10 * time.Second)
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("TRACE postCredentials: error: %v", err)
+		if errors.Is(err, ErrPinMismatch) {
+			return nil, fmt.Errorf("relay certificate does not match the pin — not connecting")
+		}
+		if errors.Is(err, ErrBadProxy) {
+			return nil, fmt.Errorf("proxy setting is invalid — not connecting")
+		}
+		return nil, fmt.Errorf("relay server not reachable")
+	}
+	log.Printf("TRACE postCredentials: status=%d", resp.StatusCode)
+	return resp, nil
+}
+
+// credentialsError turns a non-success auth response into a user-facing error.
+// The server's plain-text error body is already phrased for humans.
+func credentialsError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return fmt.Errorf("too many attempts — wait a moment")
+	}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+	msg := strings.TrimSpace(string(raw))
+	if msg == "" {
+		msg = fmt.Sprintf("HTTP %d", resp.StatusCode)
+	}
+	if resp.StatusCode == http.StatusUpgradeRequired {
+		return &ClientTooOldError{Message: msg}
+	}
+	return errors.New(msg)
+}
+
+func minDur(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// ── Server stats ──────────────────────────────────────────────────────────────
+
+// ServerStats mirrors the /api/stats response.
+type ServerStats struct {
+	ChatStats struct {
+		TotalMessages     int `json:"total_messages"`
+		BufferCapacity    int `json:"buffer_capacity"`
+		MessagesPerMinute int `json:"messages_per_minute"`
+		WaitingClients    int `json:"waiting_clients"`
+		MaxWaiters        int `json:"max_waiters"`
+	} `json:"chat_stats"`
+	MessagesByUser map[string]int `json:"messages_by_user"`
+	ActiveClients  int            `json:"active_clients"`
+	UptimeSeconds  int64          `json:"uptime_seconds"`
+	Status         string         `json:"status"`
+}
+
+// FetchStats calls GET /api/stats and returns the parsed result.
+// Uses a short 5-second timeout — stats are non-critical, failure is silent.
+// The relay wants the access key, not a session.
+func (nc *NetworkClient) FetchStats() (*ServerStats, error) {
+	req, err := http.NewRequest(http.MethodGet, nc.serverURL+"/api/stats", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-TTC-Access-Key", serverAccessKey)
+
+	client := relayClient(5 * time.Second)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("stats HTTP %d", resp.StatusCode)
+	}
+
+	var stats ServerStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("decode stats: %w", err)
+	}
+	return &stats, nil
+}
+
+// ── Presence ──────────────────────────────────────────────────────────────────
+
+// PresenceUser is one entry of the /api/presence response.
+type PresenceUser struct {
+	Username   string     `json:"username"`
+	Clients    int        `json:"clients"`
+	LastSeen   time.Time  `json:"last_seen"`
+	LastActive *time.Time `json:"last_active"` // last message or typing event; absent from older relays
+}
+
+// FetchPresence calls GET /api/presence and returns who is online, sorted
+// by username. Same short timeout as FetchStats — presence is non-critical.
+func (nc *NetworkClient) FetchPresence() ([]PresenceUser, error) {
+	client := relayClient(5 * time.Second)
+	resp, err := nc.relayGet(client, "/api/presence", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("presence HTTP %d", resp.StatusCode)
+	}
+
+	var presence struct {
+		Users []PresenceUser `json:"users"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&presence); err != nil {
+		return nil, fmt.Errorf("decode presence: %w", err)
+	}
+	return presence.Users, nil
+}
+
+// ── Identity keys ─────────────────────────────────────────────────────────────
+
+// ErrNoIdentityKey is returned by FetchIdentityKey for users whose client
+// never published a key (older clients) and for unknown users.
+var ErrNoIdentityKey = errors.New("no identity key published")
+
+// FetchIdentityKey calls GET /api/keys and returns username's Base64 public
+// identity key.
+func (nc *NetworkClient) FetchIdentityKey(username string) (string, error) {
+	keys, err := nc.FetchKeys(username)
+	if err != nil {
+		return "", err
+	}
+	return keys.IdentityKey, nil
+}
+
+// FetchKeys calls GET /api/keys and returns every key username published.
+func (nc *NetworkClient) FetchKeys(username string) (PublishedKeys, error) {
+	params := url.Values{}
+	params.Set("user", username)
+
+	client := relayClient(5 * time.Second)
+	resp, err := nc.relayGet(client, "/api/keys", params)
+	if err != nil {
+		return PublishedKeys{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return PublishedKeys{}, ErrNoIdentityKey
+	default:
+		return PublishedKeys{}, fmt.Errorf("keys HTTP %d", resp.StatusCode)
+	}
+
+	var keys PublishedKeys
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return PublishedKeys{}, fmt.Errorf("decode keys: %w", err)
+	}
+	if keys.IdentityKey == "" {
+		return PublishedKeys{}, ErrNoIdentityKey
+	}
+	return keys, nil
+}
+
+// FetchKeyDirectory calls GET /api/keys without a user and returns the keys
+// of everyone who published some.
+func (nc *NetworkClient) FetchKeyDirectory() ([]PublishedKeys, error) {
+	client := relayClient(5 * time.Second)
+	resp, err := nc.relayGet(client, "/api/keys", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("keys HTTP %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Users []PublishedKeys `json:"users"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode keys: %w", err)
+	}
+	return body.Users, nil
+}
+
+// ── Typing ────────────────────────────────────────────────────────────────────
+
+// SendTyping tells the relay the user is typing. Fire-and-forget: a lost
+// typing event only means the indicator shows up a moment later.
+func (nc *NetworkClient) SendTyping() {
+	if atomic.LoadInt32(&nc.stopped) == 1 {
+		return
+	}
+	go func() {
+		body, _ := json.Marshal(map[string]string{"room": nc.room})
+		client := relayClient(5 * time.Second)
+		resp, err := nc.relayPost(client, "/api/typing", nil, "application/json", body)
+		if err != nil {
+			log.Printf("TRACE SendTyping: %v", err)
+			return
+		}
+		resp.Body.Close()
+	}()
+}
+
+// FetchTyping returns who else is typing in the room right now.
+func (nc *NetworkClient) FetchTyping() ([]string, error) {
+	params := url.Values{}
+	params.Set("room", nc.room)
+
+	client := relayClient(5 * time.Second)
+	resp, err := nc.relayGet(client, "/api/typing", params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("typing HTTP %d", resp.StatusCode)
+	}
+
+	var typing struct {
+		Typing []string `json:"typing"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&typing); err != nil {
+		return nil, fmt.Errorf("decode typing: %w", err)
+	}
+	return typing.Typing, nil
+}
+
+// ── Files ─────────────────────────────────────────────────────────────────────
+
+// UploadedFile mirrors the /api/upload response.
+type UploadedFile struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Size       int       `json:"size"`
+	SHA256     string    `json:"sha256"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	QuotaUsed  int64     `json:"quota_used"`
+	QuotaLimit int64     `json:"quota_limit"`
+}
+
+// FileError is a refused /api/upload or /api/files request. Code is the
+// relay's reason (quota_exceeded, file_too_large, expired…); Error() turns
+// it into a sentence fit for the chat log.
+type FileError struct {
+	Status  int
+	Code    string `json:"error"`
+	Message string `json:"message"`
+	Limit   int64  `json:"limit"`
+	Used    int64  `json:"used"`
+}
+
+func (e *FileError) Error() string {
+	switch e.Code {
+	case "file_too_large":
+		return fmt.Sprintf("file is too big — the relay accepts up to %s", formatBytes(e.Limit))
+	case "quota_exceeded":
+		return fmt.Sprintf("upload quota full (%s of %s used) — space frees up as your older files expire",
+			formatBytes(e.Used), formatBytes(e.Limit))
+	case "rate_limited":
+		return "uploading too fast — wait a few seconds"
+	case "infected":
+		return "the relay's virus scanner rejected the file"
+	case "scan_unavailable":
+		return "the relay can't scan files right now — try again later"
+	case "bad_name":
+		return "the relay refused the file name"
+	case "not_found":
+		return "no such file on the relay"
+	case "expired":
+		return "that file has expired on the relay"
+	case "unauthorized":
+		return "session expired — log in again"
+	}
+	if e.Message != "" {
+		return e.Message
+	}
+	return fmt.Sprintf("files HTTP %d", e.Status)
+}
+
+// fileError reads a FileError from a failed response. Relays from before
+// JSON errors answer in plain text, which becomes the message as is.
+func fileError(resp *http.Response) error {
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	fe := &FileError{}
+	if json.Unmarshal(raw, fe) != nil || fe.Code == "" {
+		fe = &FileError{Message: strings.TrimSpace(string(raw))}
+	}
+	fe.Status = resp.StatusCode
+	return fe
+}
+
+// UploadFile sends data to POST /api/upload as name. Errors from the relay
+// are *FileError. The timeout is generous: the relay scans before answering.
+func (nc *NetworkClient) UploadFile(name string, data []byte) (*UploadedFile, error) {
+	params := url.Values{}
+	params.Set("name", name)
+
+	client := relayClient(2 * time.Minute)
+	resp, err := nc.relayPost(client, "/api/upload", params, "application/octet-stream", data)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, fileError(resp)
+	}
+	var uploaded UploadedFile
+	if err := json.NewDecoder(resp.Body).Decode(&uploaded); err != nil {
+		return nil, fmt.Errorf("decode upload: %w", err)
+	}
+	log.Printf("TRACE UploadFile: id=%s size=%d quota=%d/%d", uploaded.ID, uploaded.Size, uploaded.QuotaUsed, uploaded.QuotaLimit)
+	return &uploaded, nil
+}
+
+// DownloadFile fetches an upload from GET /api/files. Errors from the relay
+// are *FileError. maxSize guards against a relay sending more than expected.
+func (nc *NetworkClient) DownloadFile(id string, maxSize int64) ([]byte, error) {
+	params := url.Values{}
+	params.Set("id", id)
+
+	client := relayClient(2 * time.Minute)
+	resp, err := nc.relayGet(client, "/api/files", params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fileError(resp)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("file is larger than %s", formatBytes(maxSize))
+	}
+	return data, nil
+}
+
+// formatBytes renders n as "512 B", "3.4 KB" or "10.0 MB".
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
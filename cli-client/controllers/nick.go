@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+
+	"cli-client/models"
+	"cli-client/views"
+)
+
+// ── Nick changes ──────────────────────────────────────────────────────────────
+//
+// /nick <newname> renames the account on the relay (POST /api/nick): the
+// password and published keys move with it, and the relay hands back a
+// session for the new name. The client switches to that session where the
+// old one stopped reading, so nothing is missed or shown twice, and every
+// room is told "alice is now known as alicia" — on protocol version 2 as a
+// nick change ("type": "nick", "nick": {"from", "to"}), for older clients
+// as a relay announcement. /nick alone still toggles nick mode.
+
+// nickType is the message type of the relay's nick change announcement.
+const nickType = "nick"
+
+// nickChange is the "nick" field of a nick change announcement.
+type nickChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// nickRequest is the /api/nick body; the token goes in the Authorization
+// header.
+type nickRequest struct {
+	Nick string `json:"nick"`
+}
+
+// nickHandover is where a replaced network client stopped reading, for
+// the one started with the new session.
+type nickHandover struct {
+	lastID  string
+	lastSeq uint64
+}
+
+// SetNickHandler installs the callback for nick change announcements. It is
+// called from the poll goroutine. Call before Start.
+func (nc *NetworkClient) SetNickHandler(fn func(id string, change nickChange)) {
+	nc.onNick = fn
+}
+
+// ChangeNick renames the logged-in user to nick and returns the session
+// for the new name. The receiver keeps using the old token, which the relay
+// no longer accepts: replace it.
+func (nc *NetworkClient) ChangeNick(nick string) (*Session, error) {
+	body, _ := json.Marshal(nickRequest{Nick: nick})
+	resp, err := nc.relayPost(relayClient(10*time.Second), "/api/nick", nil, "application/json", body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// The mux's 404: a relay from before nick changes. Otherwise the
+		// account itself is unknown, as for an observer.
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		if strings.HasPrefix(string(raw), "404 page not found") {
+			return nil, fmt.Errorf("this relay can't rename accounts")
+		}
+		return nil, fmt.Errorf("%s", strings.TrimSpace(string(raw)))
+	case http.StatusTooManyRequests:
+		// The cooldown between two changes; the rate limit says so too.
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return nil, fmt.Errorf("%s", strings.TrimSpace(string(raw)))
+	default:
+		return nil, credentialsError(resp)
+	}
+
+	session, err := decodeSession(resp)
+	if err != nil {
+		return nil, err
+	}
+	session.Protocol = nc.protocol
+	return session, nil
+}
+
+// nickText is how a nick change reads as a system line.
+func nickText(change nickChange) string {
+	return fmt.Sprintf("%s%s[-] is now known as %s%s[-]",
+		models.GetUsernameColor(change.From), tview.Escape(change.From),
+		models.GetUsernameColor(change.To), tview.Escape(change.To))
+}
+
+// handleNick implements /nick: alone it toggles nick mode, with a name it
+// renames the user. Must be called from the tview event loop.
+func (ac *AppController) handleNick(arg string) {
+	if arg == "" {
+		chat, ok := ac.Views[models.ScreenChat].(*views.ChatView)
+		if !ok {
+			return
+		}
+		if chat.ToggleNickMode() {
+			ac.sendSystem("Nick mode ON — ← / → navigates your sent-message history. /nick to turn off.")
+		} else {
+			ac.sendSystem("Nick mode OFF — arrow keys restored to normal.")
+		}
+		return
+	}
+
+	nc := ac.netClient
+	if nc == nil || ac.session == nil {
+		ac.sendSystem("Not connected.")
+		return
+	}
+	nick := strings.TrimPrefix(arg, "@")
+	if strings.ContainsAny(nick, " \t") {
+		ac.sendSystem("Usage: /nick <newname>  —  letters, digits, '_', '-' and '.', one word")
+		return
+	}
+	if nick == ac.session.Username {
+		ac.sendSystem(fmt.Sprintf("You are already %s.", tview.Escape(nick)))
+		return
+	}
+	go func() {
+		session, err := nc.ChangeNick(nick)
+		ac.app.QueueUpdateDraw(func() {
+			if ac.netClient != nc {
+				return // replaced (/server) while the request was running
+			}
+			if err != nil {
+				ac.sendSystem(fmt.Sprintf("[red]Nick not changed: %s[-]", tview.Escape(err.Error())))
+				return
+			}
+			ac.applyNick(session)
+		})
+	}()
+}
+
+// applyNick switches to session, issued for the user's new name, and
+// carries on reading where the old network client stopped. Must be called
+// from the tview event loop.
+func (ac *AppController) applyNick(session *Session) {
+	old := ac.session.Username
+	ac.handover = &nickHandover{lastID: ac.netClient.LastID(), lastSeq: ac.netClient.LastSeq()}
+	ac.session = session
+	ac.App.RenameCurrentUser(session.Username)
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.SetCurrentUser(session.Username)
+	}
+	ac.startNetworkClient()
+	ac.sendSystem(fmt.Sprintf("You are now known as [cyan]%s[-] — the account %s is yours under the new name.",
+		tview.Escape(session.Username), tview.Escape(old)))
+	go ac.fetchAndPushPresence()
+}
+
+// noteNick shows someone's nick change. Must be called from the tview event
+// loop.
+func (ac *AppController) noteNick(change nickChange) {
+	if ac.session != nil && (change.From == ac.session.Username || change.To == ac.session.Username) {
+		return // our own, maybe still on the old session: applyNick says so
+	}
+	ac.App.RenameUser(change.From, change.To)
+	ac.followIgnoredNick(change)
+	ac.sendSystem(nickText(change))
+	go ac.fetchAndPushPresence()
+}
@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ── Hidden-service mode ───────────────────────────────────────────────────────
+//
+// A relay URL on a .onion host switches the client into hidden-service mode:
+//
+//   - all relay traffic goes through the Tor SOCKS proxy ("tor_proxy" in
+//     config.json, 127.0.0.1:9050 by default) — the hostname is resolved by
+//     Tor, never by local DNS;
+//   - HTTP timeouts are stretched by onionTimeoutFactor;
+//   - the latency probe to 1.1.1.1 is skipped and requests to any host but
+//     the relay are refused,
+//
+// so the client's whole network footprint is one Tor circuit to the relay.
+
+// DefaultTorProxy is where Tor's SOCKS port usually listens.
+const DefaultTorProxy = "socks5://127.0.0.1:9050"
+
+// onionTimeoutFactor stretches HTTP timeouts for .onion relays.
+const onionTimeoutFactor = 3
+
+var torProxy = struct {
+	mu  sync.RWMutex
+	url *url.URL
+}{url: mustParseURL(DefaultTorProxy)}
+
+func mustParseURL(raw string) *url.URL {
+	u, err := url.Parse(raw)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// SetTorProxy sets the SOCKS proxy used for .onion relays, e.g.
+// "socks5://127.0.0.1:9150" for Tor Browser. Empty keeps the default.
+func SetTorProxy(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "socks5" && u.Scheme != "socks5h") {
+		return fmt.Errorf("tor_proxy %q: want socks5://host:port", raw)
+	}
+	torProxy.mu.Lock()
+	defer torProxy.mu.Unlock()
+	torProxy.url = u
+	return nil
+}
+
+// IsOnion reports whether serverURL points at a Tor hidden service.
+func IsOnion(serverURL string) bool {
+	u, err := url.Parse(serverURL)
+	return err == nil && strings.HasSuffix(strings.ToLower(u.Hostname()), ".onion")
+}
+
+// onionMode reports whether the current relay is a hidden service.
+func onionMode() bool {
+	return IsOnion(DefaultServerURL)
+}
+
+// checkOnionPolicy refuses, in hidden-service mode, requests to any host
+// other than the relay.
+func checkOnionPolicy(req *http.Request) error {
+	if !onionMode() {
+		return nil
+	}
+	relay, err := url.Parse(DefaultServerURL)
+	if err != nil || !strings.EqualFold(req.URL.Host, relay.Host) {
+		return fmt.Errorf("hidden-service mode: refusing request to non-relay host %s", req.URL.Host)
+	}
+	return nil
+}
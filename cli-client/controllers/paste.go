@@ -0,0 +1,283 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cli-client/models"
+	"cli-client/views"
+
+	"github.com/rivo/tview"
+)
+
+// ── Pastes ────────────────────────────────────────────────────────────────────
+//
+// "/paste <path>" or "/paste clipboard" uploads the text to the relay's
+// paste store and sends a one-line message with its short ID instead of the
+// text, so a long snippet doesn't flood the room. "/paste view <id>" opens
+// it in a scrollable viewer over the chat. Pastes expire on their own
+// schedule (the relay's -paste-ttl), not with the messages.
+//
+// In privacy mode and in rooms that require encryption the text is
+// encrypted with the room key before it leaves, like a message.
+
+// maxPasteRead is the most this client reads from a file or the clipboard;
+// the relay has its own, usually smaller, limit.
+const maxPasteRead = 1 << 20
+
+// pasteSyntax maps file extensions to the syntax hint stored with a paste.
+// Other extensions are sent as they are, without the dot.
+var pasteSyntax = map[string]string{
+	".py": "python", ".js": "javascript", ".ts": "typescript", ".rb": "ruby",
+	".rs": "rust", ".sh": "bash", ".yml": "yaml", ".md": "markdown",
+	".h": "c", ".hpp": "cpp", ".cc": "cpp", ".txt": "",
+}
+
+// Paste mirrors the GET /api/paste response.
+type Paste struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Syntax    string    `json:"syntax"`
+	Text      string    `json:"text"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpireAt  time.Time `json:"expire_at"`
+}
+
+// pasteCreated is the POST /api/paste answer.
+type pasteCreated struct {
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// pasteError turns a refused /api/paste request into an error; the relay
+// explains itself in plain text.
+func pasteError(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound && resp.Request.Method == http.MethodPost {
+		return fmt.Errorf("this relay has no paste store")
+	}
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if msg := strings.TrimSpace(string(raw)); msg != "" {
+		return fmt.Errorf("%s", msg)
+	}
+	return fmt.Errorf("paste HTTP %d", resp.StatusCode)
+}
+
+// CreatePaste stores text on the relay and returns the new paste's ID.
+// text must already be sealed where the room requires it.
+func (nc *NetworkClient) CreatePaste(name, syntax, text string) (string, error) {
+	body, err := json.Marshal(map[string]string{"name": name, "syntax": syntax, "text": text})
+	if err != nil {
+		return "", err
+	}
+	resp, err := nc.relayPost(relayClient(30*time.Second), "/api/paste", nil, "application/json", body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", pasteError(resp)
+	}
+	var created pasteCreated
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", fmt.Errorf("decode paste: %w", err)
+	}
+	log.Printf("TRACE CreatePaste: id=%s size=%d expires=%s", created.ID, len(text), created.ExpiresAt.Format(time.RFC3339))
+	return created.ID, nil
+}
+
+// FetchPaste loads a paste from GET /api/paste. The text is as stored;
+// see openContent.
+func (nc *NetworkClient) FetchPaste(id string) (*Paste, error) {
+	params := url.Values{}
+	params.Set("id", id)
+
+	resp, err := nc.relayGet(relayClient(30*time.Second), "/api/paste", params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, pasteError(resp)
+	}
+	var paste Paste
+	if err := json.NewDecoder(resp.Body).Decode(&paste); err != nil {
+		return nil, fmt.Errorf("decode paste: %w", err)
+	}
+	return &paste, nil
+}
+
+// handlePaste implements /paste <path|clipboard> and /paste view <id>. Must
+// be called from the tview event loop; reading and uploading run in the
+// background.
+func (ac *AppController) handlePaste(arg string) {
+	nc := ac.netClient
+	if arg == "" {
+		ac.sendSystem("Usage: /paste <path|clipboard>  —  share text by link;  /paste view <id>  —  open one")
+		return
+	}
+	if nc == nil {
+		ac.sendSystem("Not connected to a relay.")
+		return
+	}
+	if verb, id, _ := strings.Cut(arg, " "); strings.EqualFold(verb, "view") {
+		ac.viewPaste(nc, strings.TrimSpace(id))
+		return
+	}
+
+	go func() {
+		name, syntax, text, err := readPasteSource(arg)
+		if err == nil {
+			var sealed string
+			if sealed, err = ac.sealDraft(nc, text); err == nil {
+				var id string
+				if id, err = nc.CreatePaste(name, syntax, sealed); err == nil {
+					ac.app.QueueUpdateDraw(func() {
+						if ac.netClient == nc {
+							ac.sendMessage(pasteAnnouncement(id, name, syntax, text))
+						}
+					})
+					return
+				}
+			}
+		}
+		ac.app.QueueUpdateDraw(func() {
+			ac.sendSystem(fmt.Sprintf("[red]Paste failed: %s[-]", tview.Escape(err.Error())))
+		})
+	}()
+}
+
+// pasteAnnouncement is the message sent in place of the text, e.g.
+// "📋 paste k3vq9xwa — main.go (go, 42 lines) · /paste view k3vq9xwa".
+func pasteAnnouncement(id, name, syntax, text string) string {
+	lines := strings.Count(strings.TrimRight(text, "\n"), "\n") + 1
+	detail := fmt.Sprintf("%d lines", lines)
+	if lines == 1 {
+		detail = "1 line"
+	}
+	if syntax != "" {
+		detail = syntax + ", " + detail
+	}
+	if name == "" {
+		name = "snippet"
+	}
+	return fmt.Sprintf("📋 paste %s — %s (%s) · /paste view %s", id, name, detail, id)
+}
+
+func (ac *AppController) viewPaste(nc *NetworkClient, id string) {
+	if id == "" {
+		ac.sendSystem("Usage: /paste view <id>")
+		return
+	}
+	go func() {
+		paste, err := nc.FetchPaste(id)
+		var text string
+		if err == nil {
+			text, _ = nc.openContent(paste.Author, paste.Text)
+			if text == undecryptableText {
+				err = fmt.Errorf("the paste can't be opened with this room key")
+			}
+		}
+		ac.app.QueueUpdateDraw(func() {
+			if err != nil {
+				ac.sendSystem(fmt.Sprintf("[red]Paste %s unavailable: %s[-]", tview.Escape(id), tview.Escape(err.Error())))
+				return
+			}
+			chat, ok := ac.Views[models.ScreenChat].(*views.ChatView)
+			if !ok {
+				return
+			}
+			title := "paste " + paste.ID
+			for _, part := range []string{paste.Name, paste.Syntax, paste.Author} {
+				if part != "" {
+					title += " · " + part
+				}
+			}
+			title += " · expires " + paste.ExpireAt.Local().Format("15:04")
+			chat.ShowPaste(title, paste.Syntax, text)
+		})
+	}()
+}
+
+// readPasteSource reads the text to paste from "clipboard" or a file path
+// (~ is expanded), with the name and syntax hint to store alongside.
+func readPasteSource(source string) (name, syntax, text string, err error) {
+	var data []byte
+	if strings.EqualFold(source, "clipboard") {
+		if data, err = readClipboard(); err != nil {
+			return "", "", "", err
+		}
+	} else {
+		path := expandHome(source)
+		f, err := os.Open(path)
+		if err != nil {
+			return "", "", "", err
+		}
+		defer f.Close()
+		if data, err = io.ReadAll(io.LimitReader(f, maxPasteRead+1)); err != nil {
+			return "", "", "", err
+		}
+		name = filepath.Base(path)
+		ext := strings.ToLower(filepath.Ext(name))
+		var known bool
+		if syntax, known = pasteSyntax[ext]; !known {
+			syntax = strings.TrimPrefix(ext, ".")
+		}
+	}
+
+	switch {
+	case len(data) > maxPasteRead:
+		return "", "", "", fmt.Errorf("more than %s — too long to paste", formatBytes(maxPasteRead))
+	case !utf8.Valid(data) || bytes.IndexByte(data, 0) >= 0:
+		return "", "", "", fmt.Errorf("not text — send files with an upload instead")
+	case len(bytes.TrimSpace(data)) == 0:
+		return "", "", "", fmt.Errorf("nothing to paste")
+	}
+	return name, syntax, string(data), nil
+}
+
+// readClipboard returns the system clipboard's text using the platform's
+// clipboard tool: pbpaste, PowerShell, or wl-paste, xclip or xsel.
+func readClipboard() ([]byte, error) {
+	var tools [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		tools = [][]string{{"pbpaste"}}
+	case "windows":
+		tools = [][]string{{"powershell", "-NoProfile", "-Command", "Get-Clipboard -Raw"}}
+	default:
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			tools = append(tools, []string{"wl-paste", "--no-newline"})
+		}
+		tools = append(tools,
+			[]string{"xclip", "-selection", "clipboard", "-o"},
+			[]string{"xsel", "--clipboard", "--output"})
+	}
+	for _, tool := range tools {
+		if _, err := exec.LookPath(tool[0]); err != nil {
+			continue
+		}
+		out, err := exec.Command(tool[0], tool[1:]...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", tool[0], err)
+		}
+		return out, nil
+	}
+	names := make([]string, len(tools))
+	for i, tool := range tools {
+		names[i] = tool[0]
+	}
+	return nil, fmt.Errorf("can't read the clipboard — install %s", strings.Join(names, " or "))
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ── Certificate pinning ───────────────────────────────────────────────────────
+//
+// With "pin_sha256" set in config.json the client only talks to a relay whose
+// TLS connection matches one of the pins. A pin is a SHA-256 hash, hex
+// (colons allowed) or Base64, of either
+//
+//   - the leaf certificate (openssl x509 -noout -fingerprint -sha256), or
+//   - the SubjectPublicKeyInfo of any certificate in the chain, which
+//     survives certificate renewals with the same key.
+//
+// A pinned leaf is trusted by itself, so a self-signed relay certificate
+// works once pinned (the server logs its pin at startup with -tls-cert). A
+// pinned CA key is checked on top of normal certificate verification. While
+// pins are configured, plain http:// relays are refused outright.
+
+// ErrPinMismatch is returned (wrapped) for every request to a relay whose
+// certificate matches none of the configured pins.
+var ErrPinMismatch = errors.New("relay certificate does not match the pinned sha256")
+
+// pinState is shared by every HTTP client the controllers create.
+var pinState struct {
+	mu   sync.RWMutex
+	pins map[[32]byte]bool // nil = pinning off
+	err  error             // config error — refuse every request
+}
+
+// SetPins installs the pins from config.json. An invalid pin is an error and
+// leaves the client refusing all connections, rather than silently trusting
+// whatever certificate the relay presents.
+func SetPins(values []string) error {
+	pins := make(map[[32]byte]bool, len(values))
+	var err error
+	for _, v := range values {
+		sum, perr := parsePin(v)
+		if perr != nil {
+			err = fmt.Errorf("pin_sha256 %q: %w", v, perr)
+			break
+		}
+		pins[sum] = true
+	}
+	if len(values) == 0 {
+		pins = nil
+	}
+
+	pinState.mu.Lock()
+	pinState.pins, pinState.err = pins, err
+	pinState.mu.Unlock()
+	closeRelayConnections()
+	return err
+}
+
+func parsePin(v string) ([32]byte, error) {
+	var sum [32]byte
+	s := strings.TrimSpace(v)
+	s = strings.TrimPrefix(s, "sha256/")
+
+	raw, err := hex.DecodeString(strings.ReplaceAll(s, ":", ""))
+	if err != nil || len(raw) != len(sum) {
+		raw, _ = base64.StdEncoding.DecodeString(s)
+	}
+	if len(raw) != len(sum) {
+		return sum, errors.New("not a hex or Base64 SHA-256 hash")
+	}
+	copy(sum[:], raw)
+	return sum, nil
+}
+
+// verifyPins is the tls.Config.VerifyConnection hook, and the only
+// certificate check: the transport turns off crypto/tls's own so that a
+// pinned leaf — certificate or key — is trusted by itself, which lets a
+// relay use a self-signed certificate. Without pins, or for pins on a CA
+// key, the chain and hostname are verified as crypto/tls would.
+func verifyPins(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("relay sent no certificate")
+	}
+	chains, chainErr := verifyChain(cs)
+
+	pinState.mu.RLock()
+	pins := pinState.pins
+	pinState.mu.RUnlock()
+	if pins == nil {
+		return chainErr
+	}
+
+	leaf := cs.PeerCertificates[0]
+	if pins[sha256.Sum256(leaf.Raw)] || pins[sha256.Sum256(leaf.RawSubjectPublicKeyInfo)] {
+		return nil
+	}
+	// A CA key only counts on a chain that verifies — anyone can send a
+	// copy of a CA certificate along with their own leaf.
+	for _, chain := range chains {
+		for _, cert := range chain {
+			if pins[sha256.Sum256(cert.RawSubjectPublicKeyInfo)] {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%w: relay presented %s", ErrPinMismatch, describeCert(leaf))
+}
+
+// verifyChain checks the relay's chain against the system roots and its
+// hostname, like crypto/tls does by default.
+func verifyChain(cs tls.ConnectionState) ([][]*x509.Certificate, error) {
+	opts := x509.VerifyOptions{
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	return cs.PeerCertificates[0].Verify(opts)
+}
+
+// describeCert names both hashes of cert so the user can compare them with
+// the expected ones (or update the pin after a planned key change).
+func describeCert(cert *x509.Certificate) string {
+	certSum := sha256.Sum256(cert.Raw)
+	spkiSum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return fmt.Sprintf("cert sha256 %s, key sha256 %s",
+		hex.EncodeToString(certSum[:]), base64.StdEncoding.EncodeToString(spkiSum[:]))
+}
+
+// checkPinPolicy refuses requests while the pin config is broken, and
+// plain-HTTP requests while pins are set.
+func checkPinPolicy(req *http.Request) error {
+	pinState.mu.RLock()
+	pins, err := pinState.pins, pinState.err
+	pinState.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrPinMismatch, err)
+	}
+	if pins != nil && req.URL.Scheme != "https" {
+		return fmt.Errorf("%w: %s is not https", ErrPinMismatch, req.URL.Host)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"cli-client/models"
+	"cli-client/views"
+
+	"github.com/rivo/tview"
+)
+
+// ── Pinned messages ───────────────────────────────────────────────────────────
+//
+//	/pins            list the messages pinned in this room, numbered
+//	/pin <n|id>      pin an own message, numbered as for /reply
+//	/unpin <n|id>    unpin one, numbered as /pins lists them
+//
+// The relay keeps a copy of every pinned message (POST and DELETE
+// /api/messages/<id>/pin), so pins outlive the room's TTL. Users pin their
+// own messages; the operator can pin any through the admin API. The newest
+// pin shows in a strip under the header. GET /api/pins lists a room's pins
+// and is read with the stats, so pins by others show within a few seconds.
+
+// pinEntry is one pin in the /api/pins answer; the message is always in
+// protocol version 2's format.
+type pinEntry struct {
+	Message  wireMessage `json:"message"`
+	PinnedBy string      `json:"pinned_by"`
+	PinnedAt time.Time   `json:"pinned_at"`
+}
+
+// FetchPins returns the messages pinned in the room, the first pinned
+// first, opened like polled ones. A relay from before pins has none.
+func (nc *NetworkClient) FetchPins() ([]models.Pin, error) {
+	params := url.Values{}
+	params.Set("room", nc.room)
+	resp, err := nc.relayGet(relayClient(5*time.Second), "/api/pins", params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("pins HTTP %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Pins []pinEntry `json:"pins"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode pins: %w", err)
+	}
+	pins := make([]models.Pin, 0, len(body.Pins))
+	for _, p := range body.Pins {
+		w := p.Message
+		if w.ID == "" || w.Username == "" {
+			continue
+		}
+		text, keep := nc.openContent(w.Username, w.Content)
+		if !keep {
+			continue
+		}
+		e := &pollMessage{ID: w.ID, Username: w.Username, Color: w.Color, Timestamp: w.Timestamp, Fwd: w.Fwd, Action: w.Type == actionType}
+		pins = append(pins, models.Pin{
+			Message:  entryMessage(e, text, models.SenderUnchecked),
+			PinnedBy: p.PinnedBy,
+			PinnedAt: p.PinnedAt.Local(),
+		})
+	}
+	return pins, nil
+}
+
+// PinMessage pins the message with relay ID id to the room, or with unpin
+// unpins it.
+func (nc *NetworkClient) PinMessage(id string, unpin bool) error {
+	method := http.MethodPost
+	if unpin {
+		method = http.MethodDelete
+	}
+	resp, err := nc.relayDo(relayClient(10*time.Second), method, "/api/messages/"+url.PathEscape(id)+"/pin", nil, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		// The mux's 404: a relay from before pins. Otherwise the message
+		// has expired, or isn't pinned.
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		if strings.HasPrefix(string(raw), "404 page not found") {
+			return fmt.Errorf("this relay can't pin messages")
+		}
+		return fmt.Errorf("%s", strings.TrimSpace(string(raw)))
+	}
+	return credentialsError(resp)
+}
+
+// fetchAndPushPins refreshes the pins and the strip under the header.
+func (ac *AppController) fetchAndPushPins() {
+	nc := ac.netClient
+	if nc == nil {
+		return
+	}
+	pins, err := nc.FetchPins()
+	if err != nil {
+		log.Printf("pins: %v", err)
+		return // non-critical — keep showing the last known pins
+	}
+	ac.app.QueueUpdateDraw(func() {
+		if ac.netClient == nc {
+			ac.showPins(pins)
+		}
+	})
+}
+
+// showPins makes pins the room's pins. Must be called from the tview event
+// loop.
+func (ac *AppController) showPins(pins []models.Pin) {
+	ac.pins = pins
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.SetPins(pins)
+	}
+}
+
+// handlePins implements /pins. Must be called from the tview event loop.
+func (ac *AppController) handlePins() {
+	nc := ac.netClient
+	if nc == nil {
+		ac.sendSystem("Not connected.")
+		return
+	}
+	go func() {
+		pins, err := nc.FetchPins()
+		ac.app.QueueUpdateDraw(func() {
+			if ac.netClient != nc {
+				return
+			}
+			if err != nil {
+				ac.sendSystem(fmt.Sprintf("[red]Pins unavailable: %s[-]", tview.Escape(err.Error())))
+				return
+			}
+			ac.showPins(pins)
+			if len(pins) == 0 {
+				ac.sendSystem(fmt.Sprintf("No pinned messages in #%s.", tview.Escape(ac.App.Room)))
+				return
+			}
+			ac.sendSystem(fmt.Sprintf("Pinned in #%s (%d) — /unpin <n> to unpin your own:", tview.Escape(ac.App.Room), len(pins)))
+			for i, p := range pins {
+				q := models.QuoteOf(p.Message)
+				ac.sendSystem(fmt.Sprintf("  [dim]%d[-]  %s%s[-]: %s  [dim]pinned by %s %s[-]", i+1,
+					p.Message.Color, tview.Escape(q.Username), tview.Escape(q.Text),
+					tview.Escape(p.PinnedBy), p.PinnedAt.Format("Jan 2 15:04")))
+			}
+		})
+	}()
+}
+
+// handlePin implements /pin and, with unpin, /unpin. Must be called from
+// the tview event loop.
+func (ac *AppController) handlePin(ref string, unpin bool) {
+	nc := ac.netClient
+	switch {
+	case ref == "" && unpin:
+		ac.sendSystem("Usage: /unpin <n|id>  —  n as /pins lists them")
+		return
+	case ref == "":
+		ac.listRecent("Usage: /pin <n|id> — newest messages:")
+		return
+	case nc == nil:
+		ac.sendSystem("Not connected.")
+		return
+	}
+
+	var id string
+	if unpin {
+		id = ref
+		if n, err := strconv.Atoi(ref); err == nil {
+			if n < 1 || n > len(ac.pins) {
+				ac.sendSystem(fmt.Sprintf("No pin %d — /pins lists them.", n))
+				return
+			}
+			id = ac.pins[n-1].Message.ID
+		}
+	} else {
+		target := ac.replyTarget(ref)
+		switch {
+		case target == nil:
+			ac.sendSystem(fmt.Sprintf("No message %s — /pin lists the newest ones.", tview.Escape(ref)))
+			return
+		case target.To != "" || target.Room != "":
+			ac.sendSystem("Only messages to this room can be pinned.")
+			return
+		}
+		if id = relayID(target); id == "" {
+			ac.sendSystem("That message hasn't reached the relay yet — try again in a moment.")
+			return
+		}
+	}
+
+	go func() {
+		err := nc.PinMessage(id, unpin)
+		ac.app.QueueUpdateDraw(func() {
+			if ac.netClient != nc {
+				return
+			}
+			switch {
+			case err != nil && unpin:
+				ac.sendSystem(fmt.Sprintf("[red]Not unpinned: %s[-]", tview.Escape(err.Error())))
+			case err != nil:
+				ac.sendSystem(fmt.Sprintf("[red]Not pinned: %s[-]", tview.Escape(err.Error())))
+			case unpin:
+				ac.sendSystem("Unpinned.")
+			default:
+				ac.sendSystem("Pinned — everyone in the room sees it under the header.")
+			}
+		})
+		if err == nil {
+			ac.fetchAndPushPins()
+		}
+	}()
+}
@@ -0,0 +1,377 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cli-client/config"
+	"cli-client/crypto"
+	"cli-client/models"
+	"cli-client/trust"
+)
+
+// ── Pipe mode ─────────────────────────────────────────────────────────────────
+//
+// "tail" and "pipe" run the client without a screen, for scripts and bots:
+//
+//	./client tail --user alice                        the room's messages as JSON lines
+//	tail -f build.log | ./client pipe --user ci-bot   and every line of stdin sent
+//
+// Every message that arrives, except the user's own, is one PipeEvent on
+// stdout; connection changes and refused sends go to stderr. The password
+// is $TTC_PASSWORD; with --register a user whose login is refused is
+// registered, as the login screen offers to. config.json is read for the relay, the proxy settings, the
+// passphrase, the E2E-required rooms and the identity key, so the messages
+// are encrypted and signed like the chat's. pipe exits once stdin ends and what it read has
+// been sent.
+
+// PipePasswordEnv is the environment variable pipe mode reads the
+// password from.
+const PipePasswordEnv = "TTC_PASSWORD"
+
+// pipeDrainTimeout is how long pipe waits, once stdin ended, for the
+// messages still on their way to the relay.
+const pipeDrainTimeout = 30 * time.Second
+
+// PipeEvent is one line of pipe mode's output.
+type PipeEvent struct {
+	Type    string    `json:"type"` // "message", "action", "announcement" or "nick"
+	ID      string    `json:"id"`
+	Room    string    `json:"room"`
+	User    string    `json:"user,omitempty"`
+	To      string    `json:"to,omitempty"` // direct messages: the recipient
+	Text    string    `json:"text"`
+	Time    time.Time `json:"time"`
+	ReplyTo string    `json:"reply_to,omitempty"`
+	Sender  string    `json:"sender,omitempty"` // "verified", "unsigned" or "forged"
+}
+
+// senderNames are PipeEvent's Sender values.
+var senderNames = map[models.SenderCheck]string{
+	models.SenderVerified: "verified",
+	models.SenderUnsigned: "unsigned",
+	models.SenderForged:   "forged",
+}
+
+// headless is what the modes without a screen share: the settings read
+// from config.json, the login and the connection.
+type headless struct {
+	errw io.Writer // problems, one line each
+
+	passphrase string
+	kdfSalt    string
+	e2eRooms   map[string]bool  // rooms that require encryption (e2e_rooms)
+	identity   *crypto.Identity // nil = messages go unsigned
+	trustStore *trust.Store     // nil = signatures go unchecked
+	encoding   string           // --encoding, "" = EncodingJSON
+	register   bool             // --register: register a user whose login is refused
+}
+
+// Pipe drives "tail" and "pipe".
+type Pipe struct {
+	headless
+
+	user string
+	room string
+	send bool   // read stdin and send it: "pipe"
+	self string // the logged-in username; its own messages aren't printed
+
+	outMu sync.Mutex
+	out   *json.Encoder
+
+	pendingMu sync.Mutex
+	pending   map[string]bool // local IDs of sends not yet accepted or refused
+	drained   chan struct{}   // signalled whenever pending shrinks
+	refused   int
+}
+
+// NewPipe creates pipe mode for user in room ("" = models.DefaultRoom),
+// writing events to out and problems to errw. With send it also sends
+// what Run reads.
+func NewPipe(user, room string, send bool, out, errw io.Writer) *Pipe {
+	if room == "" {
+		room = models.DefaultRoom
+	}
+	return &Pipe{
+		headless: headless{errw: errw},
+		user:     user,
+		room:     room,
+		send:     send,
+		out:      json.NewEncoder(out),
+		pending:  make(map[string]bool),
+		drained:  make(chan struct{}, 1),
+	}
+}
+
+// LoadConfig reads what the modes without a screen need from config.json.
+// Problems are reported on errw; a proxy or pin that can't be used makes
+// the login fail.
+func (h *headless) LoadConfig() {
+	cfg, err := config.Load()
+	if err != nil {
+		h.note("config not loaded: %v", err)
+	}
+	for _, n := range applyConnectionConfig(cfg) {
+		h.note("%s", n)
+	}
+	h.passphrase = cfg.Passphrase
+	h.kdfSalt = cfg.PassphraseSalt
+	h.e2eRooms = make(map[string]bool, len(cfg.E2ERooms))
+	for _, room := range cfg.E2ERooms {
+		h.e2eRooms[strings.ToLower(strings.TrimSpace(room))] = true
+	}
+
+	path, err := config.IdentityPath()
+	if err == nil {
+		h.identity, err = crypto.LoadOrCreateIdentity(path)
+	}
+	if err != nil {
+		h.note("identity key not loaded: %v — messages go unsigned", err)
+	}
+	if path, err := config.TrustPath(); err == nil {
+		h.trustStore, err = trust.Open(path)
+		if err != nil {
+			h.note("trust store not loaded: %v — signatures go unchecked", err)
+		}
+	}
+}
+
+// SetEncoding picks the wire encoding (--encoding): EncodingJSON or
+// EncodingMsgpack. Call before Run.
+func (h *headless) SetEncoding(name string) error {
+	if err := ValidEncoding(name); err != nil {
+		return err
+	}
+	h.encoding = name
+	return nil
+}
+
+// SetRegister makes a refused login register the user instead
+// (--register). Call before Run.
+func (h *headless) SetRegister(on bool) {
+	h.register = on
+}
+
+// connect logs user in, registering them if asked to, and returns a client
+// for room that delivers only what arrives from now on. Set its handlers,
+// then Start it.
+func (h *headless) connect(user, password, room string, onMessage func(*models.Message), onDelivery func(string, models.DeliveryState)) (*NetworkClient, *Session, error) {
+	clientID := GenerateClientID()
+	keys := PublishedKeys{}
+	if h.identity != nil {
+		dh, sig := h.identity.DHPublicKey()
+		keys = PublishedKeys{IdentityKey: h.identity.PublicKey(), DHKey: dh, DHKeySig: sig}
+	}
+	session, err := Login(DefaultServerURL, clientID, user, password, keys)
+	if h.register && errors.Is(err, ErrInvalidCredentials) {
+		session, err = Register(DefaultServerURL, clientID, user, password, keys)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("login: %w", err)
+	}
+
+	gc := crypto.NewGlobalCrypto()
+	if h.passphrase != "" {
+		salt := h.kdfSalt
+		if salt == "" {
+			if motd, err := FetchMotd(DefaultServerURL); err == nil {
+				salt = motd.KDFSalt
+			}
+		}
+		var saltBytes []byte
+		if salt != "" {
+			saltBytes = []byte(salt)
+		}
+		if err := gc.UsePassphrase(h.passphrase, saltBytes); err != nil {
+			return nil, nil, fmt.Errorf("passphrase: %w", err)
+		}
+	}
+
+	nc := NewNetworkClient(nil, DefaultServerURL, session.Token, room, nil, gc,
+		onMessage,
+		func(connected bool, msg string) { h.note("%s: %s", session.Username, msg) },
+		onDelivery,
+	)
+	nc.SetSigningKey(session.SigningKey)
+	nc.SetProtocol(session.Protocol)
+	nc.SetEncoding(h.encoding)
+	nc.SetE2ERequired(h.e2eRooms[strings.ToLower(room)])
+	nc.SetIdentity(session.Username, h.identity)
+	nc.SetSenderKeys(h.senderKey(session.Username))
+	// Only what arrives from now on: the backlog is /api/history's.
+	if msgs, _, err := nc.FetchHistory("", 1); err == nil && len(msgs) > 0 {
+		nc.ResumeAfter(msgs[len(msgs)-1].ID, msgs[len(msgs)-1].Seq)
+	}
+	return nc, session, nil
+}
+
+// Run logs in and prints the room's messages until ctx is done; with send
+// it sends every line read from in, and returns once in has ended and the
+// lines have been sent.
+func (p *Pipe) Run(ctx context.Context, in io.Reader) error {
+	password := os.Getenv(PipePasswordEnv)
+	if p.user == "" || password == "" {
+		return fmt.Errorf("--user and $%s are required", PipePasswordEnv)
+	}
+
+	nc, session, err := p.connect(p.user, password, p.room, p.printMessage, p.delivered)
+	if err != nil {
+		return err
+	}
+	p.self = session.Username
+	nc.SetAnnouncementHandler(func(id, text string) {
+		p.print(PipeEvent{Type: "announcement", ID: id, Room: p.room, Text: text, Time: time.Now()})
+	})
+	nc.SetNickHandler(func(id string, change nickChange) {
+		p.print(PipeEvent{Type: "nick", ID: id, Room: p.room, User: change.From,
+			Text: fmt.Sprintf("%s is now known as %s", change.From, change.To), Time: time.Now()})
+	})
+	nc.Start()
+	defer nc.Stop()
+	log.Printf("pipe: %s in %s, send=%v", session.Username, p.room, p.send)
+
+	if !p.send {
+		<-ctx.Done()
+		return nil
+	}
+
+	color := models.NewMessage(session.Username, "").Color
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64<<10), 1<<20)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		if err := scanner.Err(); err != nil {
+			p.note("stdin: %v", err)
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case line, ok := <-lines:
+			if !ok {
+				return p.drain(ctx)
+			}
+			line = strings.TrimRight(line, "\r")
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			localID := models.NewMessage(session.Username, line).ID
+			p.pendingMu.Lock()
+			p.pending[localID] = true
+			p.pendingMu.Unlock()
+			nc.SendMessage(localID, session.Username, line, color)
+		}
+	}
+}
+
+// drain waits for the sends still pending, up to pipeDrainTimeout.
+func (p *Pipe) drain(ctx context.Context) error {
+	deadline := time.NewTimer(pipeDrainTimeout)
+	defer deadline.Stop()
+	for {
+		p.pendingMu.Lock()
+		left, refused := len(p.pending), p.refused
+		p.pendingMu.Unlock()
+		if left == 0 {
+			if refused > 0 {
+				return fmt.Errorf("%d message(s) refused by the relay", refused)
+			}
+			return nil
+		}
+		select {
+		case <-p.drained:
+		case <-ctx.Done():
+			return fmt.Errorf("%d message(s) not sent", left)
+		case <-deadline.C:
+			return fmt.Errorf("%d message(s) not sent — the relay didn't take them within %s", left, pipeDrainTimeout)
+		}
+	}
+}
+
+// delivered is the NetworkClient's onDelivery: it settles the sends the
+// relay took or refused.
+func (p *Pipe) delivered(localID string, state models.DeliveryState) {
+	if state != models.DeliverySent && state != models.DeliveryFailed {
+		return
+	}
+	p.pendingMu.Lock()
+	delete(p.pending, localID)
+	if state == models.DeliveryFailed {
+		p.refused++
+	}
+	p.pendingMu.Unlock()
+	select {
+	case p.drained <- struct{}{}:
+	default:
+	}
+}
+
+// senderKey returns the lookup incoming signatures are checked with: the
+// own identity key for self, else the key pinned in the trust store. The
+// modes without a screen pin nothing themselves — that is the chat's /trust.
+func (h *headless) senderKey(self string) func(username string) (string, bool) {
+	return func(username string) (string, bool) {
+		if username == self && h.identity != nil {
+			return h.identity.PublicKey(), true
+		}
+		if h.trustStore == nil {
+			return "", false
+		}
+		e, ok := h.trustStore.Get(username)
+		return e.Key, ok
+	}
+}
+
+// printMessage is the NetworkClient's onMessage. The own messages are
+// left out, so a bot never reads back what it sent.
+func (p *Pipe) printMessage(msg *models.Message) {
+	if msg.Username == p.self {
+		return
+	}
+	ev := PipeEvent{
+		Type:    "message",
+		ID:      msg.ID,
+		Room:    p.room,
+		User:    msg.Username,
+		To:      msg.To,
+		Text:    msg.Content,
+		Time:    msg.Timestamp,
+		ReplyTo: msg.ReplyTo,
+		Sender:  senderNames[msg.Sender],
+	}
+	if msg.Action {
+		ev.Type = "action"
+	}
+	if msg.File != nil {
+		ev.Text = msg.File.Label()
+	}
+	p.print(ev)
+}
+
+// print writes ev as one line of stdout.
+func (p *Pipe) print(ev PipeEvent) {
+	p.outMu.Lock()
+	defer p.outMu.Unlock()
+	if err := p.out.Encode(ev); err != nil {
+		log.Printf("pipe: %v", err)
+	}
+}
+
+// note writes one line to stderr.
+func (h *headless) note(format string, args ...interface{}) {
+	fmt.Fprintf(h.errw, "ttc: "+format+"\n", args...)
+}
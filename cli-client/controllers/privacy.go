@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"log"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"cli-client/audit"
+	"cli-client/crypto"
+)
+
+// ── Privacy mode ──────────────────────────────────────────────────────────────
+//
+// With privacy mode on, every outgoing message is encrypted and padded to a
+// fixed size bucket (see crypto/padding.go), and a decoy message is sent at
+// random intervals. Decoys decrypt to a FrameDecoy and are dropped by every
+// client, so to the relay and the network they look like normal traffic.
+//
+// Username, color and timing of real messages are still visible to the
+// relay — the mode hides message length and blurs activity, nothing more.
+//
+// Incoming envelopes are always decrypted, whether or not privacy mode is on
+// locally, so padded and plain clients can share a room.
+
+// Decoys are sent at uniformly random intervals in this range.
+const (
+	decoyMinInterval = 20 * time.Second
+	decoyMaxInterval = 2 * time.Minute
+)
+
+// undecryptableText replaces the body of an envelope that can't be opened.
+const undecryptableText = "[encrypted message — cannot decrypt]"
+
+// SetPrivacy turns privacy mode on or off. username and colorTag are used
+// for decoy messages. Safe to call from any goroutine.
+func (nc *NetworkClient) SetPrivacy(on bool, username, colorTag string) {
+	nc.decoyMu.Lock()
+	nc.decoyUser, nc.decoyColor = username, colorTag
+	nc.decoyMu.Unlock()
+	if on {
+		atomic.StoreInt32(&nc.privacy, 1)
+	} else {
+		atomic.StoreInt32(&nc.privacy, 0)
+	}
+	log.Printf("TRACE SetPrivacy: on=%v", on)
+}
+
+// SetE2ERequired makes every outgoing message encrypted, with or without
+// privacy mode, and refuses to send one that would leave as plain text.
+// Safe to call from any goroutine.
+func (nc *NetworkClient) SetE2ERequired(on bool) {
+	if on {
+		atomic.StoreInt32(&nc.e2eRequired, 1)
+	} else {
+		atomic.StoreInt32(&nc.e2eRequired, 0)
+	}
+	log.Printf("TRACE SetE2ERequired: on=%v", on)
+}
+
+// E2ERequired reports whether the room is marked E2E-required.
+func (nc *NetworkClient) E2ERequired() bool {
+	return atomic.LoadInt32(&nc.e2eRequired) == 1
+}
+
+// Encrypting reports whether outgoing messages leave encrypted.
+func (nc *NetworkClient) Encrypting() bool {
+	return nc.PrivacyEnabled() || nc.E2ERequired()
+}
+
+// PrivacyEnabled reports whether outgoing messages are padded.
+func (nc *NetworkClient) PrivacyEnabled() bool {
+	return atomic.LoadInt32(&nc.privacy) == 1
+}
+
+// sealContent encrypts and pads content when privacy mode is on, and only
+// encrypts it in an E2E-required room.
+func (nc *NetworkClient) sealContent(content string) (string, error) {
+	switch {
+	case nc.PrivacyEnabled():
+		return nc.gc.EncryptPadded(content)
+	case nc.E2ERequired():
+		return nc.gc.EncryptText(content)
+	}
+	return content, nil
+}
+
+// openContent decrypts content sent by username if it is an envelope. keep
+// is false for decoys, which must not be shown.
+func (nc *NetworkClient) openContent(username, content string) (text string, keep bool) {
+	if !crypto.HasEnvelope(content) {
+		return content, true
+	}
+	text, decoy, err := nc.gc.OpenMessage(content)
+	if err != nil {
+		nc.reportSecurity(audit.Event{Kind: audit.DecryptFailure, Peer: username, Detail: err.Error()})
+		return undecryptableText, true
+	}
+	if decoy {
+		log.Printf("TRACE openContent: dropped decoy")
+		return "", false
+	}
+	return text, true
+}
+
+// decoyLoop sends a decoy at random intervals while privacy mode is on.
+func (nc *NetworkClient) decoyLoop() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("PANIC NetworkClient.decoyLoop: %v", r)
+		}
+	}()
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for {
+		wait := decoyMinInterval + time.Duration(r.Int63n(int64(decoyMaxInterval-decoyMinInterval)))
+		select {
+		case <-nc.stopCh:
+			return
+		case <-time.After(wait):
+		}
+		if !nc.PrivacyEnabled() || nc.PendingCount() > 0 {
+			continue // off, or relay unreachable — don't pile up decoys
+		}
+		content, err := nc.gc.EncryptDecoy()
+		if err != nil {
+			log.Printf("TRACE decoyLoop: %v", err)
+			continue
+		}
+		nc.decoyMu.Lock()
+		m := outboundMessage{username: nc.decoyUser, content: content, colorTag: nc.decoyColor, sealed: true}
+		nc.decoyMu.Unlock()
+		log.Printf("TRACE decoyLoop: sending decoy (%d bytes)", len(content))
+		nc.deliver(m)
+	}
+}
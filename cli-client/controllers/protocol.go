@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"cli-client/models"
+)
+
+// ── Protocol versions ─────────────────────────────────────────────────────────
+//
+// Version 1 of the message format keys every message by its sender:
+//
+//	{"alice": "hi", "color": "[green]", "id": "msg_…"}
+//
+// so a user named "color" or "id" can't be told apart from a field (see
+// parsePollMessages). Version 2, under /api/v2, has a field per value:
+//
+//	{"id": "msg_…", "username": "alice", "content": "hi", "color": "[green]", "timestamp": "…"}
+//
+// The client asks for clientProtocol at login and the relay answers with
+// the version both speak; relays from before versions answer nothing and
+// get version 1. Only send, poll, stream and history differ between the
+// versions.
+
+// clientProtocol is the newest message format version this client speaks.
+const clientProtocol = 2
+
+// wireMessage is a message in protocol version 2.
+type wireMessage struct {
+	ID        string          `json:"id"`
+	Seq       uint64          `json:"seq"`
+	Username  string          `json:"username"`
+	Content   string          `json:"content"`
+	Color     string          `json:"color"`
+	Timestamp time.Time       `json:"timestamp"`
+	To        string          `json:"to"`
+	Sig       string          `json:"sig"`
+	System    bool            `json:"system"`
+	Fwd       *models.Forward `json:"fwd"`
+	Type      string          `json:"type"`
+	ReplyTo   string          `json:"reply_to"`
+	Origin    string          `json:"origin"`
+	AlsoIn    []string        `json:"also_in"`
+	Mentions  []string        `json:"mentions"`
+	Nick      *nickChange     `json:"nick"`
+	Reaction  *reactionEvent  `json:"reaction"`
+}
+
+// knownWireKeys are the fields of wireMessage, for strict mode.
+var knownWireKeys = map[string]bool{
+	"also_in":   true,
+	"color":     true,
+	"content":   true,
+	"fwd":       true,
+	"id":        true,
+	"mentions":  true,
+	"nick":      true,
+	"origin":    true,
+	"reaction":  true,
+	"reply_to":  true,
+	"seq":       true,
+	"sig":       true,
+	"system":    true,
+	"timestamp": true,
+	"to":        true,
+	"type":      true,
+	"username":  true,
+}
+
+// SetProtocol picks the message format version, Session.Protocol; 0 means
+// version 1. Call before Start.
+func (nc *NetworkClient) SetProtocol(version int) {
+	nc.protocol = version
+}
+
+// messagePath returns the path of a message endpoint ("send", "poll",
+// "stream", "history") in the negotiated version.
+func (nc *NetworkClient) messagePath(name string) string {
+	if nc.protocol >= 2 {
+		return "/api/v2/" + name
+	}
+	return "/api/" + name
+}
+
+// parseMessages parses an array of messages in the negotiated version, in
+// JSON or, if packed, MessagePack (version 2 only, see encoding.go).
+// Entries too malformed to show are counted as dropped.
+func (nc *NetworkClient) parseMessages(data []byte, packed, strict bool) ([]*pollMessage, []ProtocolViolation, error) {
+	if packed && nc.protocol < 2 {
+		return nil, nil, fmt.Errorf("parse message array: MessagePack in protocol version 1")
+	}
+	parse := parsePollMessages
+	if nc.protocol >= 2 {
+		parse = func(data []byte, strict bool) ([]*pollMessage, []ProtocolViolation, int, error) {
+			return parseWireMessages(data, packed, strict)
+		}
+	}
+	msgs, violations, skipped, err := parse(data, strict)
+	if nc.stats != nil {
+		nc.stats.RecordDropped(models.DropMalformed, skipped)
+	}
+	return msgs, violations, err
+}
+
+// parseWireMessages is parsePollMessages for protocol version 2: the same
+// entries are skipped and, in strict mode, the same problems reported.
+// packed means data is MessagePack rather than JSON.
+func parseWireMessages(data []byte, packed, strict bool) ([]*pollMessage, []ProtocolViolation, int, error) {
+	unmarshal := json.Unmarshal
+	if packed {
+		unmarshal = unmarshalMsgpack
+		log.Printf("TRACE parseWireMessages: MessagePack body (%d bytes)", len(data))
+	} else {
+		log.Printf("TRACE parseWireMessages: raw body (%d bytes): %.500s", len(data), data)
+	}
+
+	rawList, err := splitList(data, packed)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("parse message array: %w", err)
+	}
+
+	var violations []ProtocolViolation
+	violate := func(i int, kind, format string, args ...interface{}) {
+		if strict {
+			violations = append(violations, ProtocolViolation{i, kind, fmt.Sprintf(format, args...)})
+		}
+	}
+
+	msgs := make([]*pollMessage, 0, len(rawList))
+	for i, raw := range rawList {
+		var w wireMessage
+		if err := unmarshal(raw, &w); err != nil {
+			violate(i, "bad_type", "entry is not a message: %v", err)
+			continue
+		}
+		if strict {
+			var fields map[string]interface{}
+			unmarshal(raw, &fields)
+			var unknown []string
+			for key := range fields {
+				if !knownWireKeys[key] {
+					unknown = append(unknown, key)
+				}
+			}
+			sort.Strings(unknown)
+			if len(unknown) > 0 {
+				violate(i, "unknown_field", "unknown fields %v", unknown)
+			}
+		}
+		if w.Fwd != nil && w.Fwd.From == "" {
+			violate(i, "bad_type", "fwd has no author")
+			w.Fwd = nil
+		}
+		if w.Nick != nil && (w.Type != nickType || w.Nick.From == "" || w.Nick.To == "") {
+			violate(i, "bad_type", "nick is not a nick change")
+			w.Nick = nil
+		}
+		if w.Reaction != nil && (w.Type != reactionType || w.Reaction.MessageID == "") {
+			violate(i, "bad_type", "reaction is not a reaction event")
+			w.Reaction = nil
+		}
+		if w.Type == reactionType && w.Reaction == nil {
+			// Its content is the bare emoji: nothing to show as a message.
+			violate(i, "bad_type", "reaction event id=%q has no reaction", w.ID)
+			continue
+		}
+		if w.Type != "" && w.Type != actionType && w.Type != nickType && w.Type != reactionType {
+			violate(i, "bad_type", "unknown message type %q", w.Type)
+		}
+
+		if strings.TrimSpace(w.Username) == "" {
+			violate(i, "empty_username", "entry id=%q has no username", w.ID)
+		}
+		if w.Content == "" {
+			violate(i, "empty_content", "entry id=%q from %q has no content", w.ID, w.Username)
+		}
+		if w.ID == "" {
+			violate(i, "missing_id", "entry from %q has no id", w.Username)
+		}
+		if w.Username == "" || w.Content == "" || w.ID == "" {
+			log.Printf("TRACE parseWireMessages: entry[%d] SKIPPED (malformed)", i)
+			continue
+		}
+
+		msgs = append(msgs, &pollMessage{
+			Username:  w.Username,
+			To:        w.To,
+			Sig:       w.Sig,
+			System:    w.System,
+			Fwd:       w.Fwd,
+			Action:    w.Type == actionType,
+			ReplyTo:   w.ReplyTo,
+			AlsoIn:    w.AlsoIn,
+			Mentions:  w.Mentions,
+			Nick:      w.Nick,
+			Reaction:  w.Reaction,
+			Content:   w.Content,
+			Color:     w.Color,
+			ID:        w.ID,
+			Seq:       w.Seq,
+			Timestamp: w.Timestamp,
+		})
+	}
+	log.Printf("TRACE parseWireMessages: returning %d valid messages, %d violations", len(msgs), len(violations))
+	return msgs, violations, len(rawList) - len(msgs), nil
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ── Outbound proxy ────────────────────────────────────────────────────────────
+//
+// Relay traffic can go through a SOCKS5 or HTTP(S) proxy — a corporate
+// proxy, or Tor for a relay that isn't a hidden service. The proxy is, in
+// order of precedence:
+//
+//   - the --proxy flag;
+//   - "proxy" in config.json;
+//   - HTTPS_PROXY / HTTP_PROXY (minus NO_PROXY) from the environment.
+//
+// .onion relays ignore all of these and always use the Tor proxy (onion.go).
+// While a proxy is in use the latency probe, which dials 1.1.1.1 directly,
+// stays off so nothing bypasses it.
+
+// ErrBadProxy is returned (wrapped) for every request while the configured
+// proxy is invalid — the client refuses to fall back to a direct connection.
+var ErrBadProxy = errors.New("proxy setting is invalid")
+
+var proxyState struct {
+	mu  sync.RWMutex
+	url *url.URL // nil = from the environment
+	err error
+}
+
+// SetProxy sets the proxy for relay traffic, e.g. "socks5://127.0.0.1:9050"
+// or "http://proxy.corp:3128". Empty falls back to the environment.
+func SetProxy(raw string) error {
+	var u *url.URL
+	var err error
+	if raw = strings.TrimSpace(raw); raw != "" {
+		u, err = url.Parse(raw)
+		if err != nil || u.Host == "" || !validProxyScheme(u.Scheme) {
+			u, err = nil, fmt.Errorf("proxy %q: want socks5://host:port or http://host:port", raw)
+		}
+	}
+
+	proxyState.mu.Lock()
+	proxyState.url, proxyState.err = u, err
+	proxyState.mu.Unlock()
+	closeRelayConnections()
+	return err
+}
+
+func validProxyScheme(scheme string) bool {
+	switch scheme {
+	case "socks5", "socks5h", "http", "https":
+		return true
+	}
+	return false
+}
+
+// relayProxy is the http.Transport Proxy hook: .onion hosts always go
+// through the Tor proxy, everything else through the configured proxy or
+// the environment's.
+func relayProxy(req *http.Request) (*url.URL, error) {
+	if strings.HasSuffix(strings.ToLower(req.URL.Hostname()), ".onion") {
+		torProxy.mu.RLock()
+		defer torProxy.mu.RUnlock()
+		return torProxy.url, nil
+	}
+	proxyState.mu.RLock()
+	u := proxyState.url
+	proxyState.mu.RUnlock()
+	if u != nil {
+		return u, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// checkProxyPolicy refuses every request while the proxy setting is invalid.
+func checkProxyPolicy(req *http.Request) error {
+	proxyState.mu.RLock()
+	defer proxyState.mu.RUnlock()
+	if proxyState.err != nil {
+		return fmt.Errorf("%w: %v", ErrBadProxy, proxyState.err)
+	}
+	return nil
+}
+
+// ActiveProxy returns the proxy requests to the current relay go through,
+// with any password masked, or "" for a direct connection.
+func ActiveProxy() string {
+	req, err := http.NewRequest(http.MethodGet, DefaultServerURL, nil)
+	if err != nil {
+		return ""
+	}
+	u, err := relayProxy(req)
+	if err != nil || u == nil {
+		return ""
+	}
+	return u.Redacted()
+}
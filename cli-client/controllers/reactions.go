@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"cli-client/models"
+	"cli-client/views"
+
+	"github.com/rivo/tview"
+)
+
+// ── Reactions ─────────────────────────────────────────────────────────────────
+//
+//	/react                  list the newest messages, numbered
+//	/react <n|id> <emoji>   react to a message; again to take it back
+//
+// A reaction is POST /api/messages/<id>/react {"emoji": "👍"}, and DELETE
+// takes it back. The relay keeps who gave which emoji and tells everyone who
+// sees the message with a reaction event in the poll stream ("type":
+// "reaction", "reaction": {"message_id", "emoji", "removed", "totals"}).
+// Totals are all of the message's reactions after the change, so the newest
+// event wins and a replayed one changes nothing. They show as a dim
+// "👍 3  🎉 1" under the message. Reaction events are only in protocol
+// version 2: older clients never see them.
+
+// reactionType is the message type of the relay's reaction events.
+const reactionType = "reaction"
+
+// reactionEvent is the "reaction" field of a reaction event.
+type reactionEvent struct {
+	MessageID string            `json:"message_id"`
+	Emoji     string            `json:"emoji"`
+	Removed   bool              `json:"removed"`
+	Totals    []models.Reaction `json:"totals"`
+}
+
+// reactionRequest is the /api/messages/<id>/react body; the token goes in
+// the Authorization header.
+type reactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+// reactionIndex keeps the reactions of every message that has any, by
+// relay ID, up to as many messages as the chat keeps. History pages bring
+// events out of order, so each entry remembers the sequence number of its
+// event and an older one never replaces it. The poll goroutine, history
+// fetches and the event loop all use it.
+type reactionIndex struct {
+	mu      sync.Mutex
+	entries map[string]reactionEntry
+	order   []string // keys of entries, oldest first
+}
+
+type reactionEntry struct {
+	seq       uint64
+	reactions []models.Reaction
+}
+
+// set records the reactions event reports, from the event with seq, and
+// reports whether they replaced older ones.
+func (x *reactionIndex) set(seq uint64, event reactionEvent) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.entries == nil {
+		x.entries = make(map[string]reactionEntry)
+	}
+	old, ok := x.entries[event.MessageID]
+	if ok && seq < old.seq {
+		return false
+	}
+	if !ok {
+		x.order = append(x.order, event.MessageID)
+		if len(x.order) > views.ScrollbackLines {
+			delete(x.entries, x.order[0])
+			x.order = x.order[1:]
+		}
+	}
+	x.entries[event.MessageID] = reactionEntry{seq: seq, reactions: event.Totals}
+	return true
+}
+
+// get returns the reactions of the message with relay ID id.
+func (x *reactionIndex) get(id string) []models.Reaction {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.entries[id].reactions
+}
+
+// SetReactionHandler installs the callback for reaction events, with their
+// sequence number. It is called from the poll goroutine and from
+// FetchHistory. Call before Start.
+func (nc *NetworkClient) SetReactionHandler(fn func(seq uint64, event reactionEvent)) {
+	nc.onReaction = fn
+}
+
+// React gives emoji to the message with relay ID id, or with remove takes it
+// back. Everyone who sees the message, this client too, hears of it as a
+// reaction event.
+func (nc *NetworkClient) React(id, emoji string, remove bool) error {
+	method := http.MethodPost
+	if remove {
+		method = http.MethodDelete
+	}
+	body, _ := json.Marshal(reactionRequest{Emoji: emoji})
+	resp, err := nc.relayDo(relayClient(10*time.Second), method, "/api/messages/"+url.PathEscape(id)+"/react", nil, "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		// The mux's 404: a relay from before reactions. Otherwise the
+		// message has expired.
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		if strings.HasPrefix(string(raw), "404 page not found") {
+			return fmt.Errorf("this relay doesn't support reactions")
+		}
+		return fmt.Errorf("%s", strings.TrimSpace(string(raw)))
+	}
+	return credentialsError(resp)
+}
+
+// handleReact implements /react. Must be called from the tview event loop.
+func (ac *AppController) handleReact(arg string) {
+	ref, emoji, _ := strings.Cut(arg, " ")
+	emoji = strings.TrimSpace(emoji)
+	if ref == "" {
+		ac.listRecent("Usage: /react <n|id> <emoji> — newest messages:")
+		return
+	}
+	if emoji == "" || strings.ContainsAny(emoji, " \t") {
+		ac.sendSystem("Usage: /react <n|id> <emoji>  —  /react alone lists the newest messages")
+		return
+	}
+	nc := ac.netClient
+	if nc == nil {
+		ac.sendSystem("Not connected.")
+		return
+	}
+	if nc.protocol < 2 {
+		ac.sendSystem("This relay speaks protocol version 1 — reactions need version 2.")
+		return
+	}
+	target := ac.replyTarget(ref)
+	if target == nil {
+		ac.sendSystem(fmt.Sprintf("No message %s — /react lists the newest ones.", tview.Escape(ref)))
+		return
+	}
+	id := relayID(target)
+	if id == "" {
+		ac.sendSystem("That message hasn't reached the relay yet — try again in a moment.")
+		return
+	}
+	remove := gaveReaction(target.Reactions, emoji, ac.session.Username)
+
+	go func() {
+		err := nc.React(id, emoji, remove)
+		if err == nil {
+			return // the reaction event shows it
+		}
+		ac.app.QueueUpdateDraw(func() {
+			if ac.netClient == nc {
+				ac.sendSystem(fmt.Sprintf("[red]Reaction not sent: %s[-]", tview.Escape(err.Error())))
+			}
+		})
+	}()
+}
+
+// gaveReaction reports whether username is among those who gave emoji.
+func gaveReaction(reactions []models.Reaction, emoji, username string) bool {
+	for _, r := range reactions {
+		if r.Emoji != emoji {
+			continue
+		}
+		for _, u := range r.Users {
+			if u == username {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// showReactions puts the recorded reactions of the message with relay ID id
+// under its line, if it is shown. Must be called from the tview event loop.
+func (ac *AppController) showReactions(id string) {
+	for i := len(ac.App.Messages) - 1; i >= 0; i-- {
+		m := ac.App.Messages[i]
+		if m.IsSystem || relayID(m) != id {
+			continue
+		}
+		m.Reactions = ac.reactions.get(id)
+		if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+			chat.SetReactions(m.ID, m.Reactions)
+		}
+		return
+	}
+}
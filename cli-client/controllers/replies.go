@@ -0,0 +1,213 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cli-client/models"
+	"cli-client/views"
+
+	"github.com/rivo/tview"
+)
+
+// ── Replies ───────────────────────────────────────────────────────────────────
+//
+//	/reply                 list the newest messages, numbered
+//	/reply <n|id> <text>   answer a message: 1 is the newest, or give its ID
+//
+// A reply travels like any message to the room, with "reply_to": the relay
+// ID of the message it answers. Like "fwd" it is not signed. Each client
+// looks the ID up among the messages it has seen and shows a dim excerpt
+// of that message above the reply; one it never saw — expired, or from
+// before it joined — shows as "reply to an earlier message". The field is
+// only in protocol version 2: older relays and clients show the reply as an
+// ordinary message.
+
+// replyListSize is how many messages /reply or /react without arguments
+// lists.
+const replyListSize = 5
+
+// replyIndex keeps the excerpt of every message seen, by ID, for the
+// replies to it, up to as many as the chat keeps. The poll goroutine, the
+// sender and the event loop all use it.
+type replyIndex struct {
+	mu     sync.Mutex
+	quotes map[string]*models.Quote
+	order  []string // keys of quotes, oldest first
+}
+
+// note remembers the excerpt of msg under its ID.
+func (x *replyIndex) note(msg *models.Message) {
+	if msg.IsSystem {
+		return
+	}
+	x.add(msg.ID, models.QuoteOf(msg))
+}
+
+// alias makes the excerpt of the own message sent as localID findable by
+// the ID the relay gave it.
+func (x *replyIndex) alias(localID, id string) {
+	x.mu.Lock()
+	q, ok := x.quotes[localID]
+	x.mu.Unlock()
+	if ok {
+		x.add(id, q)
+	}
+}
+
+func (x *replyIndex) add(id string, q *models.Quote) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.quotes == nil {
+		x.quotes = make(map[string]*models.Quote)
+	}
+	if _, ok := x.quotes[id]; ok {
+		return
+	}
+	x.quotes[id] = q
+	x.order = append(x.order, id)
+	if len(x.order) > views.ScrollbackLines {
+		delete(x.quotes, x.order[0])
+		x.order = x.order[1:]
+	}
+}
+
+// quote sets msg.Quote if msg is a reply: the excerpt of the message it
+// answers, or an empty one if that was never seen here.
+func (x *replyIndex) quote(msg *models.Message) {
+	if msg.ReplyTo == "" {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if q, ok := x.quotes[msg.ReplyTo]; ok {
+		msg.Quote = q
+		return
+	}
+	msg.Quote = &models.Quote{}
+}
+
+// SetSentHandler registers fn to be told the ID the relay gave an own
+// message, by its local ID. It is called from the sending goroutine. Call
+// before Start.
+func (nc *NetworkClient) SetSentHandler(fn func(localID, id string)) {
+	nc.onSent = fn
+}
+
+// SendReply relays a reply to the message with relay ID replyTo; see
+// SendMessage.
+func (nc *NetworkClient) SendReply(localID, username, content, colorTag, replyTo string) {
+	if atomic.LoadInt32(&nc.stopped) == 1 {
+		return
+	}
+	log.Printf("TRACE NetworkClient.SendReply: user=%q reply_to=%q content=%.60q", username, replyTo, content)
+	nc.send(outboundMessage{localID: localID, username: username, content: content, colorTag: colorTag, replyTo: replyTo})
+}
+
+// handleReply implements /reply. Must be called from the tview event loop.
+func (ac *AppController) handleReply(arg string) {
+	ref, text, _ := strings.Cut(arg, " ")
+	text = strings.TrimSpace(text)
+	if ref == "" {
+		ac.listRecent("Usage: /reply <n|id> <text> — newest messages:")
+		return
+	}
+	if text == "" {
+		ac.sendSystem("Usage: /reply <n|id> <text>  —  /reply alone lists the newest messages")
+		return
+	}
+	if ac.netClient == nil {
+		ac.sendSystem("Not connected.")
+		return
+	}
+	target := ac.replyTarget(ref)
+	switch {
+	case target == nil:
+		ac.sendSystem(fmt.Sprintf("No message %s — /reply lists the newest ones.", tview.Escape(ref)))
+		return
+	case target.To != "" || target.Room != "":
+		ac.sendSystem("Only messages to this room can be answered — use /msg for a direct message.")
+		return
+	}
+	id := relayID(target)
+	if id == "" {
+		ac.sendSystem("That message hasn't reached the relay yet — try again in a moment.")
+		return
+	}
+
+	msg := models.NewMessage(ac.App.CurrentUser.Username, text)
+	msg.Color = ac.App.GetUserColorTag(ac.App.CurrentUser.Username)
+	msg.ReplyTo = id
+	msg.Quote = models.QuoteOf(target)
+	msg.Delivery = models.DeliverySending
+	ac.files.note(msg)
+	ac.replies.note(msg)
+	ac.App.AddMessage(msg)
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.AddMessage(msg)
+		ac.rememberSent(chat, "/reply "+arg)
+	}
+	ac.netClient.SendReply(msg.ID, msg.Username, text, msg.Color, id)
+	ac.lastTypingSent = time.Time{} // the server cleared our typing state
+}
+
+// relayID returns the ID the relay knows msg by: for an own message the one
+// it gave it, "" until then.
+func relayID(msg *models.Message) string {
+	if msg.Delivery != models.DeliveryNone {
+		return msg.RelayID
+	}
+	return msg.ID
+}
+
+// replyTarget finds the message /reply and /react refer to: a number
+// counts back from the newest message shown, anything else is an ID. Must
+// be called from the tview event loop.
+func (ac *AppController) replyTarget(ref string) *models.Message {
+	if n, err := strconv.Atoi(ref); err == nil {
+		recent := ac.recentMessages(n)
+		if n < 1 || len(recent) < n {
+			return nil
+		}
+		return recent[n-1]
+	}
+	for i := len(ac.App.Messages) - 1; i >= 0; i-- {
+		if m := ac.App.Messages[i]; !m.IsSystem && (m.ID == ref || m.RelayID == ref) {
+			return m
+		}
+	}
+	return nil
+}
+
+// recentMessages returns up to n of the newest messages shown, newest
+// first, leaving out system notes and ignored users. Must be called from
+// the tview event loop.
+func (ac *AppController) recentMessages(n int) []*models.Message {
+	var recent []*models.Message
+	for i := len(ac.App.Messages) - 1; i >= 0 && len(recent) < n; i-- {
+		if m := ac.App.Messages[i]; !m.IsSystem && !ac.ignored.has(m.Username) {
+			recent = append(recent, m)
+		}
+	}
+	return recent
+}
+
+// listRecent shows usage and the newest messages with the numbers
+// replyTarget takes. Must be called from the tview event loop.
+func (ac *AppController) listRecent(usage string) {
+	recent := ac.recentMessages(replyListSize)
+	if len(recent) == 0 {
+		ac.sendSystem("No messages yet.")
+		return
+	}
+	ac.sendSystem(usage)
+	for i := len(recent) - 1; i >= 0; i-- {
+		q := models.QuoteOf(recent[i])
+		ac.sendSystem(fmt.Sprintf("  [dim]%d[-]  %s%s[-]: %s", i+1, recent[i].Color, tview.Escape(q.Username), tview.Escape(q.Text)))
+	}
+}
@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cli-client/config"
+	"cli-client/models"
+)
+
+// ── Session resume ────────────────────────────────────────────────────────────
+//
+// On exit the client writes session.json next to config.json: the relay, the
+// user, the client ID, the room and the poll cursor — the newest message it
+// delivered. Started again within resumeWindow, it logs in with the same
+// client ID and room, loads history back to that message (up to
+// resumeGapPages pages) and marks where the new ones begin, so nothing is
+// lost and nothing arrives twice.
+//
+// The session token is never written; the login is always a fresh one. The
+// file is deleted as it is read, so two clients started together never share
+// a client ID.
+
+// resumeWindow is how old session.json may be and still be picked up.
+const resumeWindow = time.Hour
+
+// resumeGapPages caps how many history pages are fetched to reach the cursor.
+const resumeGapPages = 5
+
+// resumeState is session.json.
+type resumeState struct {
+	ServerURL string    `json:"server_url"`
+	Username  string    `json:"username"`
+	ClientID  string    `json:"client_id"`
+	Room      string    `json:"room"`
+	LastID    string    `json:"last_id"` // poll cursor: newest message delivered
+	SavedAt   time.Time `json:"saved_at"`
+}
+
+// RestoreSession picks up session.json from the last run if it is recent
+// enough: the client ID and room are reused right away, the cursor once the
+// same user is logged in to the same relay. Call once, before the login.
+func (ac *AppController) RestoreSession() {
+	path, err := config.SessionStatePath()
+	if err != nil {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("resume: %v", err)
+		}
+		return
+	}
+	os.Remove(path)
+
+	var st resumeState
+	if err := json.Unmarshal(data, &st); err != nil {
+		log.Printf("resume: %s: %v", path, err)
+		return
+	}
+	if st.ClientID == "" || time.Since(st.SavedAt) > resumeWindow {
+		log.Printf("resume: session from %s is too old — starting fresh", st.SavedAt.Format(time.RFC3339))
+		return
+	}
+	log.Printf("resume: client id %q, room %q, last id %q", st.ClientID, st.Room, st.LastID)
+	ac.clientID = st.ClientID
+	if st.Room != "" {
+		ac.App.Room = st.Room
+	}
+	ac.resume = &st
+}
+
+// SaveSession writes session.json for the next start. Nothing is written
+// without a logged-in session, or after /wipe. Call once the event loop
+// has stopped.
+func (ac *AppController) SaveSession() {
+	nc := ac.netClient
+	if ac.wiped || ac.session == nil || nc == nil {
+		return
+	}
+	st := resumeState{
+		ServerURL: nc.ServerURL(),
+		Username:  ac.session.Username,
+		ClientID:  ac.clientID,
+		Room:      ac.App.Room,
+		LastID:    nc.LastID(),
+		SavedAt:   time.Now(),
+	}
+	path, err := config.SessionStatePath()
+	if err == nil {
+		err = writeResumeState(path, st)
+	}
+	if err != nil {
+		log.Printf("resume: not saved: %v", err)
+	}
+}
+
+func writeResumeState(path string, st resumeState) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// takeResume returns the restored cursor if it belongs to nc's relay, the
+// logged-in user and the current room, and forgets it either way — only the
+// first connection resumes. Must be called from the tview event loop.
+func (ac *AppController) takeResume(nc *NetworkClient) *resumeState {
+	st := ac.resume
+	ac.resume = nil
+	if st == nil || st.LastID == "" {
+		return nil
+	}
+	if st.ServerURL != nc.ServerURL() || st.Username != ac.session.Username || st.Room != ac.App.Room {
+		return nil
+	}
+	return st
+}
+
+// fillGap pages further back until msgs reach lastID, so everything sent
+// while the client was closed is shown. It gives up after resumeGapPages
+// pages or on an error, keeping what it has.
+func fillGap(nc *NetworkClient, msgs []*models.Message, more bool, lastID string) ([]*models.Message, bool) {
+	for page := 1; page < resumeGapPages && more && len(msgs) > 0 && messageIndex(msgs, lastID) < 0; page++ {
+		older, olderMore, err := nc.FetchHistory(msgs[0].ID, historyPageSize)
+		if err != nil {
+			log.Printf("resume: gap fill: %v", err)
+			break
+		}
+		msgs = append(older, msgs...)
+		more = olderMore
+	}
+	return msgs, more
+}
+
+// markUnread puts a divider in front of the messages after lastID and
+// returns the result. Must be called from the tview event loop.
+func (ac *AppController) markUnread(msgs []*models.Message, lastID string) []*models.Message {
+	i := messageIndex(msgs, lastID)
+	switch {
+	case i < 0:
+		ac.sendSystem("Resumed — the relay no longer has your last message; showing the newest.")
+		return msgs
+	case i == len(msgs)-1:
+		ac.sendSystem("Resumed — nothing new since you left.")
+		return msgs
+	}
+	divider := models.NewSystemMessage(fmt.Sprintf("[dim]── %d new since you left ──[-]", len(msgs)-i-1))
+	out := make([]*models.Message, 0, len(msgs)+1)
+	out = append(out, msgs[:i+1]...)
+	out = append(out, divider)
+	return append(out, msgs[i+1:]...)
+}
+
+func messageIndex(msgs []*models.Message, id string) int {
+	for i, m := range msgs {
+		if m.ID == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+)
+
+// ── Room info ─────────────────────────────────────────────────────────────────
+//
+// /room info shows what the relay knows about the current room from the
+// messages it still holds: how busy it is, who posts most and how much of
+// the relay's buffer it takes. Direct messages and announcements are not
+// counted. It also says how long the relay keeps the room's messages, which
+// the operator may set per room; that line is shown on connecting too.
+
+// RoomStats mirrors the /api/rooms/<room>/stats response.
+type RoomStats struct {
+	Room              string  `json:"room"`
+	Messages          int     `json:"messages"`
+	MessagesPerMinute float64 `json:"messages_per_minute"`
+	ActiveMembers     int     `json:"active_members"`
+	WindowSeconds     int     `json:"window_seconds"`
+	TopPosters        []struct {
+		Username string `json:"username"`
+		Messages int    `json:"messages"`
+	} `json:"top_posters"`
+	BufferUsed int `json:"buffer_used"`
+	BufferSize int `json:"buffer_size"`
+	// TTLSeconds and MaxMessages are the room's retention; relays from
+	// before per-room retention leave them 0.
+	TTLSeconds  int `json:"ttl_seconds"`
+	MaxMessages int `json:"max_messages"`
+}
+
+// FetchRoomStats calls GET /api/rooms/<room>/stats for the current room.
+func (nc *NetworkClient) FetchRoomStats() (*RoomStats, error) {
+	resp, err := nc.relayGet(relayClient(5*time.Second), "/api/rooms/"+url.PathEscape(nc.room)+"/stats", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("this relay has no room statistics")
+	default:
+		return nil, fmt.Errorf("room stats HTTP %d", resp.StatusCode)
+	}
+
+	var stats RoomStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("decode room stats: %w", err)
+	}
+	return &stats, nil
+}
+
+// handleRoom implements /room info. Must be called from the tview event
+// loop; the fetch runs in the background.
+func (ac *AppController) handleRoom(arg string) {
+	if !strings.EqualFold(arg, "info") {
+		ac.sendSystem("Usage: /room info")
+		return
+	}
+	nc := ac.netClient
+	if nc == nil {
+		ac.sendSystem("Not connected to a relay.")
+		return
+	}
+	go func() {
+		stats, err := nc.FetchRoomStats()
+		ac.app.QueueUpdateDraw(func() {
+			if err != nil {
+				ac.sendSystem(fmt.Sprintf("[red]Room info unavailable: %v[-]", err))
+				return
+			}
+			ac.showRoomStats(stats)
+		})
+	}()
+}
+
+func (ac *AppController) showRoomStats(s *RoomStats) {
+	window := time.Duration(s.WindowSeconds) * time.Second
+	ac.sendSystem(fmt.Sprintf("Room [cyan]#%s[-]", tview.Escape(s.Room)))
+	ac.sendSystem(fmt.Sprintf("  %.1f msgs/min and %d active member(s) over the last %s",
+		s.MessagesPerMinute, s.ActiveMembers, window))
+	share := 0.0
+	if s.BufferSize > 0 {
+		share = 100 * float64(s.Messages) / float64(s.BufferSize)
+	}
+	ac.sendSystem(fmt.Sprintf("  %d message(s) held — %.0f%% of the relay's buffer (%d/%d used)",
+		s.Messages, share, s.BufferUsed, s.BufferSize))
+	if text := retentionText(s); text != "" {
+		ac.sendSystem("  " + text)
+	}
+	if len(s.TopPosters) == 0 {
+		return
+	}
+	names := make([]string, len(s.TopPosters))
+	for i, p := range s.TopPosters {
+		names[i] = fmt.Sprintf("%s%s[-] %d", ac.App.GetUserColorTag(p.Username), tview.Escape(p.Username), p.Messages)
+	}
+	ac.sendSystem("  Top posters: " + strings.Join(names, ", "))
+}
+
+// retentionText says how long the room's messages are kept, or "" when the
+// relay doesn't report it.
+func retentionText(s *RoomStats) string {
+	if s.TTLSeconds <= 0 {
+		return ""
+	}
+	text := fmt.Sprintf("Messages in #%s expire after %s", tview.Escape(s.Room), shortDuration(time.Duration(s.TTLSeconds)*time.Second))
+	if s.MaxMessages > 0 && s.MaxMessages < s.BufferSize {
+		text += fmt.Sprintf(", and only the last %d are kept", s.MaxMessages)
+	}
+	return text + "."
+}
+
+// shortDuration formats d without trailing zero units: "24h", "5m", "1h30m".
+func shortDuration(d time.Duration) string {
+	text := d.Round(time.Second).String()
+	if strings.HasSuffix(text, "m0s") {
+		text = strings.TrimSuffix(text, "0s")
+	}
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
+
+// showRetention tells, once connected, how long the relay keeps the room's
+// messages. Runs off the event loop; a relay without room statistics is
+// silently skipped.
+func (ac *AppController) showRetention(nc *NetworkClient) {
+	stats, err := nc.FetchRoomStats()
+	if err != nil {
+		log.Printf("TRACE showRetention: %v", err)
+		return
+	}
+	text := retentionText(stats)
+	if text == "" {
+		return
+	}
+	ac.app.QueueUpdateDraw(func() {
+		if ac.netClient == nc {
+			ac.sendSystem("[dim]" + text + "[-]")
+		}
+	})
+}
@@ -0,0 +1,333 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cli-client/config"
+	"cli-client/models"
+	"cli-client/views"
+
+	"github.com/rivo/tview"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// ── Scripts ───────────────────────────────────────────────────────────────────
+//
+// Lua scripts in ~/.config/ttc/scripts (*.lua, loaded in name order) extend
+// the client without forking it: filters, auto-responders, commands. A
+// script may define these hooks:
+//
+//	on_connect(info)   connected to a room: info.user, info.room
+//	on_message(msg)    a message from someone else arrived: msg.id, msg.user,
+//	                   msg.text, msg.to, msg.time, msg.action, msg.reply_to;
+//	                   returning false hides it
+//	on_send(text)      the user sends text: returning a string sends that
+//	                   instead, false sends nothing
+//
+// and call
+//
+//	send(text)          send text to the room, as the user
+//	notify(text)        show text as a system line and raise a notification
+//	command(name, fn)   add /name; fn(arg) runs when it is typed
+//
+// What send and notify ask for happens once the hook returned, and what a
+// script sends doesn't go through on_send again. Hooks run one at a time,
+// each for at most scriptTimeout. /scripts lists what is loaded and
+// /scripts reload loads the directory again.
+
+// scriptTimeout is how long one hook may run before it is stopped.
+const scriptTimeout = time.Second
+
+// scriptActionKind is what a script asked the client to do.
+type scriptActionKind int
+
+const (
+	scriptSend scriptActionKind = iota
+	scriptNotify
+	scriptError
+)
+
+// scriptAction is one thing a script asked for during a hook, carried out
+// on the tview event loop once the hook returned.
+type scriptAction struct {
+	kind   scriptActionKind
+	script string
+	text   string
+}
+
+// script is one loaded file.
+type script struct {
+	name     string
+	state    *lua.LState
+	commands map[string]*lua.LFunction
+	actions  []scriptAction // asked for by the hook running now
+}
+
+// scriptEngine holds the loaded scripts. Safe for use from any goroutine;
+// the hooks run one at a time.
+type scriptEngine struct {
+	mu      sync.Mutex
+	scripts []*script
+}
+
+// load replaces the loaded scripts with the ones in dir. It returns a
+// problem per script that didn't load; a missing dir is no problem.
+func (e *scriptEngine) load(dir string) []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, s := range e.scripts {
+		s.state.Close()
+	}
+	e.scripts = nil
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.lua"))
+	if err != nil {
+		return []string{err.Error()}
+	}
+	sort.Strings(paths)
+	var problems []string
+	for _, path := range paths {
+		s := newScript(filepath.Base(path))
+		ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+		s.state.SetContext(ctx)
+		err := s.state.DoFile(path)
+		s.state.RemoveContext()
+		cancel()
+		if err != nil {
+			s.state.Close()
+			problems = append(problems, fmt.Sprintf("%s: %v", s.name, err))
+			continue
+		}
+		s.actions = nil // a script's top level may not send yet
+		e.scripts = append(e.scripts, s)
+	}
+	return problems
+}
+
+// newScript creates the Lua state for the script called name, with the
+// client's functions installed.
+func newScript(name string) *script {
+	s := &script{name: name, state: lua.NewState(), commands: make(map[string]*lua.LFunction)}
+	s.state.SetGlobal("send", s.state.NewFunction(func(L *lua.LState) int {
+		s.actions = append(s.actions, scriptAction{kind: scriptSend, script: s.name, text: L.CheckString(1)})
+		return 0
+	}))
+	s.state.SetGlobal("notify", s.state.NewFunction(func(L *lua.LState) int {
+		s.actions = append(s.actions, scriptAction{kind: scriptNotify, script: s.name, text: L.CheckString(1)})
+		return 0
+	}))
+	s.state.SetGlobal("command", s.state.NewFunction(func(L *lua.LState) int {
+		s.commands[strings.ToLower(L.CheckString(1))] = L.CheckFunction(2)
+		return 0
+	}))
+	return s
+}
+
+// call runs fn with args in s and returns its result, nil if it returned
+// nothing. A failure is added to s.actions as a scriptError.
+func (s *script) call(what string, fn *lua.LFunction, args ...lua.LValue) lua.LValue {
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+	s.state.SetContext(ctx)
+	defer s.state.RemoveContext()
+
+	if err := s.state.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, args...); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("stopped after %s", scriptTimeout)
+		}
+		log.Printf("script %s: %s: %v", s.name, what, err)
+		s.actions = append(s.actions, scriptAction{kind: scriptError, script: s.name, text: fmt.Sprintf("%s: %v", what, err)})
+		return nil
+	}
+	ret := s.state.Get(-1)
+	s.state.Pop(1)
+	return ret
+}
+
+// hook runs the global function name of every script that defines one,
+// stopping early once keep returns false for a result. It returns whether
+// it ran to the end and what the scripts asked for. Call with e.mu held.
+func (e *scriptEngine) hook(name string, args func(L *lua.LState) []lua.LValue, keep func(ret lua.LValue) bool) (bool, []scriptAction) {
+	var actions []scriptAction
+	for _, s := range e.scripts {
+		fn, ok := s.state.GetGlobal(name).(*lua.LFunction)
+		if !ok {
+			continue
+		}
+		ret := s.call(name, fn, args(s.state)...)
+		actions = append(actions, s.actions...)
+		s.actions = nil
+		if !keep(ret) {
+			return false, actions
+		}
+	}
+	return true, actions
+}
+
+// onConnect runs on_connect.
+func (e *scriptEngine) onConnect(user, room string) []scriptAction {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, actions := e.hook("on_connect", func(L *lua.LState) []lua.LValue {
+		info := L.NewTable()
+		info.RawSetString("user", lua.LString(user))
+		info.RawSetString("room", lua.LString(room))
+		return []lua.LValue{info}
+	}, func(lua.LValue) bool { return true })
+	return actions
+}
+
+// onMessage runs on_message and reports whether msg is to be shown.
+func (e *scriptEngine) onMessage(msg *models.Message) (bool, []scriptAction) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.hook("on_message", func(L *lua.LState) []lua.LValue {
+		t := L.NewTable()
+		t.RawSetString("id", lua.LString(msg.ID))
+		t.RawSetString("user", lua.LString(msg.Username))
+		t.RawSetString("text", lua.LString(msg.Content))
+		t.RawSetString("to", lua.LString(msg.To))
+		t.RawSetString("time", lua.LNumber(msg.Timestamp.Unix()))
+		t.RawSetString("action", lua.LBool(msg.Action))
+		t.RawSetString("reply_to", lua.LString(msg.ReplyTo))
+		return []lua.LValue{t}
+	}, func(ret lua.LValue) bool { return ret != lua.LFalse })
+}
+
+// onSend runs on_send over text and returns what to send, "" for nothing.
+func (e *scriptEngine) onSend(text string) (string, []scriptAction) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, actions := e.hook("on_send", func(L *lua.LState) []lua.LValue {
+		return []lua.LValue{lua.LString(text)}
+	}, func(ret lua.LValue) bool {
+		switch ret := ret.(type) {
+		case lua.LString:
+			text = string(ret)
+		case lua.LBool:
+			if !bool(ret) {
+				text = ""
+			}
+		}
+		return text != ""
+	})
+	return text, actions
+}
+
+// command runs the script command name with arg; false if no script
+// added one.
+func (e *scriptEngine) command(name, arg string) (bool, []scriptAction) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, s := range e.scripts {
+		fn, ok := s.commands[name]
+		if !ok {
+			continue
+		}
+		s.call("/"+name, fn, lua.LString(arg))
+		actions := s.actions
+		s.actions = nil
+		return true, actions
+	}
+	return false, nil
+}
+
+// describe returns a line per loaded script: its hooks and commands.
+func (e *scriptEngine) describe() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	lines := make([]string, 0, len(e.scripts))
+	for _, s := range e.scripts {
+		var parts []string
+		for _, hook := range []string{"on_connect", "on_message", "on_send"} {
+			if _, ok := s.state.GetGlobal(hook).(*lua.LFunction); ok {
+				parts = append(parts, hook)
+			}
+		}
+		names := make([]string, 0, len(s.commands))
+		for name := range s.commands {
+			names = append(names, "/"+name)
+		}
+		sort.Strings(names)
+		parts = append(parts, names...)
+		if len(parts) == 0 {
+			parts = []string{"no hooks"}
+		}
+		lines = append(lines, fmt.Sprintf("%s — %s", s.name, strings.Join(parts, ", ")))
+	}
+	return lines
+}
+
+// ── AppController ─────────────────────────────────────────────────────────────
+
+// loadScripts loads the scripts directory and returns the problems, ready
+// to show.
+func (ac *AppController) loadScripts() []string {
+	dir, err := config.ScriptsDir()
+	if err != nil {
+		return nil
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil
+	}
+	var notes []string
+	for _, p := range ac.scripts.load(dir) {
+		log.Printf("scripts: %s", p)
+		notes = append(notes, fmt.Sprintf("[red]Script not loaded: %s[-]", tview.Escape(p)))
+	}
+	return notes
+}
+
+// runScriptActions carries out what scripts asked for. Must be called from
+// the tview event loop.
+func (ac *AppController) runScriptActions(actions []scriptAction) {
+	for _, a := range actions {
+		switch a.kind {
+		case scriptSend:
+			if a.text != "" {
+				ac.sendMessage(a.text)
+			}
+		case scriptNotify:
+			ac.sendSystem(fmt.Sprintf("[dim]%s:[-] %s", tview.Escape(a.script), tview.Escape(a.text)))
+			if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+				chat.Notify(a.script, a.text)
+			}
+		case scriptError:
+			ac.sendSystem(fmt.Sprintf("[red]Script %s: %s[-]", tview.Escape(a.script), tview.Escape(a.text)))
+		}
+	}
+}
+
+// handleScripts implements /scripts and /scripts reload. Must be called
+// from the tview event loop.
+func (ac *AppController) handleScripts(arg string) {
+	switch strings.ToLower(arg) {
+	case "":
+	case "reload":
+		for _, note := range ac.loadScripts() {
+			ac.sendSystem(note)
+		}
+	default:
+		ac.sendSystem("Usage: /scripts [reload]")
+		return
+	}
+	lines := ac.scripts.describe()
+	if len(lines) == 0 {
+		dir, _ := config.ScriptsDir()
+		ac.sendSystem(fmt.Sprintf("No scripts loaded — put *.lua files in %s.", tview.Escape(dir)))
+		return
+	}
+	ac.sendSystem(fmt.Sprintf("Scripts (%d):", len(lines)))
+	for _, line := range lines {
+		ac.sendSystem("  " + tview.Escape(line))
+	}
+}
@@ -0,0 +1,86 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"cli-client/audit"
+	"cli-client/config"
+	"cli-client/models"
+	"cli-client/views"
+
+	"github.com/rivo/tview"
+)
+
+// ── Security audit ────────────────────────────────────────────────────────────
+//
+// Security events from the network client (and, for peer keys, from the key
+// checks) go through recordSecurity: they land in the audit log and the ones
+// a user must not miss raise the warning banner until /audit is run.
+
+// openAuditFile starts writing the audit log to disk. Called from LoadConfig
+// when "audit_log" is set.
+func (ac *AppController) openAuditFile() {
+	path, err := config.AuditPath()
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(path), 0700); err == nil {
+			err = ac.audit.OpenFile(path)
+		}
+	}
+	if err != nil {
+		log.Printf("config: audit log: %v", err)
+		ac.configNotes = append(ac.configNotes, fmt.Sprintf("Audit log not opened: %s", tview.Escape(err.Error())))
+	}
+}
+
+// recordSecurity logs a security event and warns the user about the
+// serious ones. Safe to call from any goroutine.
+func (ac *AppController) recordSecurity(e audit.Event) {
+	ac.audit.Record(e)
+
+	var warning string
+	switch e.Kind {
+	case audit.KeyChange:
+		warning = fmt.Sprintf("The identity key of %s has changed — verify it before trusting their messages. /audit for details.", e.Peer)
+	case audit.ServerCert:
+		warning = fmt.Sprintf("The relay %s presented a different TLS certificate. /audit for details.", e.Peer)
+	case audit.PinMismatch:
+		warning = fmt.Sprintf("NOT CONNECTED: the TLS certificate of %s does not match your pin_sha256 — possible interception. /audit for details.", e.Peer)
+	default:
+		return
+	}
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.ShowBanner(warning)
+	}
+}
+
+// peerKeyChanged reports that username's identity key fingerprint differs
+// from the one seen before.
+func (ac *AppController) peerKeyChanged(username, oldFP, newFP string) {
+	ac.recordSecurity(audit.Event{
+		Kind:   audit.KeyChange,
+		Peer:   username,
+		Detail: fmt.Sprintf("fingerprint changed from %s to %s", oldFP, newFP),
+	})
+}
+
+// showAudit implements /audit: lists this session's security events and
+// dismisses the warning banner. Must be called from the tview event loop.
+func (ac *AppController) showAudit() {
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.HideBanner()
+	}
+
+	where := "memory only — set \"audit_log\": true in config.json to keep a file"
+	if path := ac.audit.Path(); path != "" {
+		where = "logging to " + tview.Escape(path)
+	}
+	events := ac.audit.Recent()
+	ac.sendSystem(fmt.Sprintf("Security audit — %d event(s) this session, %s", len(events), where))
+	for _, e := range events {
+		ac.sendSystem(fmt.Sprintf("  [dim]%s[-]  [yellow]%s[-]  %s  %s",
+			e.Time.Format("15:04:05"), e.Kind, tview.Escape(e.Peer), tview.Escape(e.Detail)))
+	}
+}
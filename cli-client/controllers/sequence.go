@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// ── Sequence numbers ──────────────────────────────────────────────────────────
+//
+// The relay numbers every message it keeps, across all rooms. Besides
+// last_id, polls and streams send last_seq, the number the relay said it
+// had read up to (X-TTC-Seq on a poll, "seq" on a v2 message). It still
+// works once the message last_id names has expired from the relay's buffer
+// — after a long disconnect or a suspend — where last_id alone would get
+// nothing. When messages after the cursor expired before they were read
+// the relay says so (X-TTC-Gap, or a "gap" event on the stream) and the
+// chat gets a "messages may have been missed" divider.
+
+// SetGapHandler registers fn to be told when messages were missed. It is
+// called from the poll goroutine. Call before Start.
+func (nc *NetworkClient) SetGapHandler(fn func()) {
+	nc.onGap = fn
+}
+
+// LastSeq returns the sequence number the relay has read up to for this
+// client, 0 before the first poll.
+func (nc *NetworkClient) LastSeq() uint64 {
+	nc.lastIDMu.Lock()
+	defer nc.lastIDMu.Unlock()
+	return nc.lastSeq
+}
+
+// setLastSeq moves the sequence cursor to seq.
+func (nc *NetworkClient) setLastSeq(seq uint64) {
+	nc.lastIDMu.Lock()
+	nc.lastSeq = seq
+	nc.lastIDMu.Unlock()
+}
+
+// noteCursor takes the sequence cursor and a gap from a poll response.
+func (nc *NetworkClient) noteCursor(h http.Header) {
+	if seq, err := strconv.ParseUint(h.Get("X-TTC-Seq"), 10, 64); err == nil {
+		nc.setLastSeq(seq)
+	}
+	if h.Get("X-TTC-Gap") != "" {
+		nc.reportGap()
+	}
+}
+
+func (nc *NetworkClient) reportGap() {
+	log.Printf("TRACE reportGap: messages after the cursor expired on the relay")
+	if nc.onGap != nil {
+		nc.onGap()
+	}
+}
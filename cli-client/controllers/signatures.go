@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"cli-client/audit"
+	"cli-client/crypto"
+	"cli-client/models"
+)
+
+// ── Message signatures ────────────────────────────────────────────────────────
+//
+// Every message leaves signed with the identity key (crypto.SignMessage), so
+// a username alone no longer proves who wrote it. Incoming messages are
+// checked against the sender's pinned key — looked up and pinned on first
+// contact — and the chat marks the ones that fail: "? unverified" for
+// unsigned messages (older clients) and a red warning for a signature that
+// does not match, which is also written to the audit log.
+
+// SetSenderKeys installs the lookup incoming messages are verified with: it
+// returns the identity key pinned for a username, or false if there is
+// none. It is called from the poll goroutine and may block. Call before
+// Start.
+func (nc *NetworkClient) SetSenderKeys(fn func(username string) (string, bool)) {
+	nc.senderKeys = fn
+}
+
+// signatureRoom is the room a message's signature is bound to; direct
+// messages are bound to their recipient instead.
+func (nc *NetworkClient) signatureRoom(to string) string {
+	if to != "" {
+		return ""
+	}
+	return nc.room
+}
+
+// sign returns the signature for content sent as username into room, ""
+// without an identity key.
+func (nc *NetworkClient) sign(username, room, to, content string) string {
+	if nc.identity == nil {
+		return ""
+	}
+	if to != "" {
+		room = ""
+	}
+	return nc.identity.SignMessage(username, room, to, content)
+}
+
+// checkSender verifies msg's signature against the sender's pinned key.
+// msg.Content must still be as received.
+func (nc *NetworkClient) checkSender(msg *pollMessage) models.SenderCheck {
+	if msg.Sig == "" || nc.senderKeys == nil {
+		return models.SenderUnsigned
+	}
+	key, ok := nc.senderKeys(msg.Username)
+	if !ok {
+		return models.SenderUnsigned
+	}
+	err := crypto.VerifyMessage(key, msg.Username, nc.signatureRoom(msg.To), msg.To, msg.Content, msg.Sig)
+	if err != nil {
+		nc.reportSecurity(audit.Event{Kind: audit.Unsigned, Peer: msg.Username, Detail: "message " + msg.ID + ": " + err.Error()})
+		return models.SenderForged
+	}
+	return models.SenderVerified
+}
+
+// senderKey is the NetworkClient's SetSenderKeys lookup: the own key for
+// own messages, else the pinned key, fetched and pinned on first contact.
+// Runs on the poll goroutine.
+func (ac *AppController) senderKey(nc *NetworkClient, username string) (string, bool) {
+	ac.peers.mu.Lock()
+	self := ac.peers.self
+	ac.peers.mu.Unlock()
+	if username == self && ac.identity != nil {
+		return ac.identity.PublicKey(), true
+	}
+	if e, ok := ac.trustStore.Get(username); ok {
+		return e.Key, true
+	}
+	if ac.keyCheckDue(username) {
+		ac.lookupPeerKey(nc, username)
+	}
+	e, ok := ac.trustStore.Get(username)
+	return e.Key, ok
+}
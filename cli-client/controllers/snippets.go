@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"cli-client/models"
+	"cli-client/views"
+
+	"github.com/rivo/tview"
+)
+
+// handleSnip implements /snip <name>, which puts a snippet from config.json
+// into the input field to be completed and sent (see views/snippet.go), and
+// /snip, which lists them. Must be called from the tview event loop.
+func (ac *AppController) handleSnip(arg string) {
+	chat, ok := ac.Views[models.ScreenChat].(*views.ChatView)
+	if !ok {
+		return
+	}
+	names := chat.SnippetNames()
+	switch {
+	case len(names) == 0:
+		ac.sendSystem(`No snippets yet — add them to config.json, e.g. "snippets": {"standup": "Standup {date}: done {} · next {}"}`)
+	case arg == "":
+		ac.sendSystem(fmt.Sprintf("Snippets: %s  —  /snip <name>, or type %sname and press Tab", strings.Join(names, ", "), views.SnippetTrigger))
+	case !chat.ExpandSnippet(arg):
+		ac.sendSystem(fmt.Sprintf("No snippet %q. Snippets: %s", tview.Escape(arg), strings.Join(names, ", ")))
+	}
+}
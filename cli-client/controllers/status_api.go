@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"cli-client/config"
+)
+
+// ── Status API ────────────────────────────────────────────────────────────────
+//
+// With "status_socket" in config.json the running client answers
+// GET /status on that unix socket with a small JSON report — unread
+// messages, mentions and whether it is connected — for status bar widgets:
+//
+//	curl -s --unix-socket ~/.local/share/ttc/status.sock http://ttc/status
+//	{"user":"bob","room":"lobby","connected":true,"unread":3,"mentions":1,...}
+//
+// Unread and mentions count messages from others since the user last typed,
+// sent or ran a command. The socket is mode 0600 and only reachable on this
+// machine; it is removed when the client exits. Off by default.
+
+// statusReport is the answer to GET /status.
+type statusReport struct {
+	User        string     `json:"user"`
+	Room        string     `json:"room"`
+	Connected   bool       `json:"connected"`
+	Unread      int        `json:"unread"`
+	Mentions    int        `json:"mentions"`
+	LastMessage *time.Time `json:"last_message,omitempty"` // newest message from others
+	Time        time.Time  `json:"time"`
+}
+
+// statusTracker keeps what the status API reports. The poll goroutine and
+// the event loop both update it.
+type statusTracker struct {
+	mu     sync.Mutex
+	report statusReport
+}
+
+func (t *statusTracker) setSession(user, room string) {
+	t.mu.Lock()
+	t.report.User, t.report.Room = user, room
+	t.mu.Unlock()
+}
+
+func (t *statusTracker) setConnected(connected bool) {
+	t.mu.Lock()
+	t.report.Connected = connected
+	t.mu.Unlock()
+}
+
+// noteMessage counts a message from someone else.
+func (t *statusTracker) noteMessage(at time.Time, mentioned bool) {
+	t.mu.Lock()
+	t.report.Unread++
+	if mentioned {
+		t.report.Mentions++
+	}
+	t.report.LastMessage = &at
+	t.mu.Unlock()
+}
+
+// seen resets the counts: the user is at the keyboard.
+func (t *statusTracker) seen() {
+	t.mu.Lock()
+	t.report.Unread, t.report.Mentions = 0, 0
+	t.mu.Unlock()
+}
+
+func (t *statusTracker) snapshot() statusReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r := t.report
+	r.Time = time.Now()
+	return r
+}
+
+// startStatusAPI serves the status API on the unix socket at path; a
+// relative path is taken inside config.DataDir. A socket another running
+// client answers on is left alone.
+func (ac *AppController) startStatusAPI(path string) error {
+	if !filepath.IsAbs(path) {
+		dir, err := config.DataDir()
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(dir, path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return fmt.Errorf("%s is in use by another client", path)
+		}
+		os.Remove(path) // left behind by a client that crashed
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ac.status.snapshot())
+	})
+	ac.statusServer = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		if err := ac.statusServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("status API: %v", err)
+		}
+	}()
+	log.Printf("status API: listening on %s", path)
+	return nil
+}
+
+// CloseStatusAPI stops the status API and removes its socket. Call once the
+// client exits.
+func (ac *AppController) CloseStatusAPI() {
+	if ac.statusServer != nil {
+		ac.statusServer.Close() // removes the socket, too
+	}
+}
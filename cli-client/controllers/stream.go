@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ── Transports ────────────────────────────────────────────────────────────────
+//
+// --transport picks how new messages arrive:
+//
+//	poll   long polling on /api/poll (default) — one request per batch
+//	sse    Server-Sent Events on /api/stream — one response stays open and
+//	       carries every message, with no pause between polls
+//	ws     the same events over a WebSocket on /api/ws, see websocket.go
+//
+// SSE is plain HTTP, so it passes the proxies long polling does; a relay
+// without /api/stream makes the client fall back to polling, and one
+// without /api/ws falls back to SSE.
+
+const (
+	TransportPoll = "poll"
+	TransportSSE  = "sse"
+	TransportWS   = "ws"
+)
+
+// ValidTransport checks a --transport value.
+func ValidTransport(name string) error {
+	switch name {
+	case TransportPoll, TransportSSE, TransportWS:
+		return nil
+	}
+	return fmt.Errorf("transport %q: want sse, ws or poll", name)
+}
+
+// errNoStream means the relay predates /api/stream.
+var errNoStream = errors.New("relay has no /api/stream")
+
+// streamIdleTimeout drops a stream that has been silent this long. The relay
+// sends a keepalive every poll timeout (30s by default).
+const streamIdleTimeout = 75 * time.Second
+
+// SetTransport picks the transport (TransportPoll, TransportSSE or
+// TransportWS). Call before Start.
+func (nc *NetworkClient) SetTransport(name string) {
+	var sse, ws int32
+	switch name {
+	case TransportSSE:
+		sse = 1
+	case TransportWS:
+		ws = 1
+	}
+	atomic.StoreInt32(&nc.sse, sse)
+	atomic.StoreInt32(&nc.ws, ws)
+}
+
+// streamQuery is the query string that opens a stream where the last one
+// left off.
+func (nc *NetworkClient) streamQuery() string {
+	params := url.Values{}
+	params.Set("room", nc.room)
+	if lastID := nc.LastID(); lastID != "" {
+		params.Set("last_id", lastID)
+	}
+	if lastSeq := nc.LastSeq(); lastSeq > 0 {
+		params.Set("last_seq", strconv.FormatUint(lastSeq, 10))
+	}
+	return params.Encode()
+}
+
+// stream opens /api/stream and hands every message to handleIncoming until
+// the stream ends, Wake or Stop cancels it, or it goes quiet for
+// streamIdleTimeout. connected is called once the relay accepted it.
+func (nc *NetworkClient) stream(connected func()) error {
+	pollCtx, done := nc.pollContext()
+	defer done()
+	ctx, cancel := context.WithCancel(pollCtx)
+	defer cancel()
+	idle := time.AfterFunc(streamIdleTimeout, cancel)
+	defer idle.Stop()
+
+	log.Printf("TRACE stream: GET %s%s", nc.serverURL, nc.messagePath("stream"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, nc.serverURL+nc.messagePath("stream")+"?"+nc.streamQuery(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	nc.authorize(req, nil)
+
+	// No client timeout: the response lasts as long as the connection.
+	resp, err := relayClient(0).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	log.Printf("TRACE stream: response status=%d", resp.StatusCode)
+	nc.checkServerCert(resp)
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return errNoStream
+	case http.StatusUnauthorized:
+		return fmt.Errorf("server rejected session token — log in again")
+	default:
+		return fmt.Errorf("unexpected HTTP %d", resp.StatusCode)
+	}
+	connected()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	var event string
+	var data bytes.Buffer
+	for scanner.Scan() {
+		idle.Reset(streamIdleTimeout)
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if err := nc.dispatchEvent(event, data.Bytes()); err != nil {
+				return err
+			}
+			event = ""
+			data.Reset()
+		case strings.HasPrefix(line, ":"):
+			// keepalive
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
+// dispatchEvent handles one complete stream event.
+func (nc *NetworkClient) dispatchEvent(event string, data []byte) error {
+	switch event {
+	case "error":
+		if string(data) == "Unauthorized" {
+			return fmt.Errorf("server rejected session token — log in again")
+		}
+		return fmt.Errorf("stream: %s", data)
+	case "gap":
+		if seq, err := strconv.ParseUint(string(data), 10, 64); err == nil {
+			nc.setLastSeq(seq)
+		}
+		nc.reportGap()
+		return nil
+	case "message", "":
+		if len(data) == 0 {
+			return nil
+		}
+	default:
+		return nil // unknown events are for newer clients
+	}
+
+	// Each event carries one message in poll format.
+	msgs, violations, err := nc.parseMessages(append(append([]byte{'['}, data...), ']'), false, atomic.LoadInt32(&nc.strict) == 1)
+	if err != nil {
+		return err
+	}
+	if nc.stats != nil {
+		for _, v := range violations {
+			nc.stats.RecordViolation(v.Kind, fmt.Sprintf("stream %s", v.Detail))
+		}
+	}
+	for _, msg := range msgs {
+		if atomic.LoadInt32(&nc.stopped) == 1 {
+			return nil
+		}
+		nc.lastIDMu.Lock()
+		nc.lastID = msg.ID
+		if msg.Seq > 0 {
+			nc.lastSeq = msg.Seq
+		}
+		nc.lastIDMu.Unlock()
+		log.Printf("TRACE stream: dispatching id=%q user=%q", msg.ID, msg.Username)
+		nc.handleIncoming(msg)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+
+	"cli-client/models"
+	"cli-client/views"
+
+	"github.com/rivo/tview"
+)
+
+// ── Themes ────────────────────────────────────────────────────────────────────
+//
+// /theme <name> restyles the chat screen for the rest of the session; the
+// "theme" setting in config.json picks the one it starts with. See
+// views.Theme for what a theme covers.
+
+// handleTheme implements /theme [name]. Must be called from the tview event
+// loop.
+func (ac *AppController) handleTheme(arg string) {
+	chat, ok := ac.Views[models.ScreenChat].(*views.ChatView)
+	if !ok {
+		return
+	}
+	if arg == "" {
+		ac.sendSystem(fmt.Sprintf("Theme: [::b]%s[::-]  —  /theme <%s>", chat.Theme().Name, strings.Join(views.ThemeNames(), "|")))
+		return
+	}
+	theme, ok := views.LookupTheme(arg)
+	if !ok {
+		ac.sendSystem(fmt.Sprintf("Unknown theme '%s'  —  themes: %s", tview.Escape(arg), strings.Join(views.ThemeNames(), ", ")))
+		return
+	}
+	chat.SetTheme(theme)
+	ac.sendSystem(fmt.Sprintf("Theme → [::b]%s[::-]  (set \"theme\" in config.json to keep it)", theme.Name))
+	chat.SetMessages(ac.visibleMessages()) // re-render the scrollback in the new colors
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cli-client/config"
+	"cli-client/models"
+	"cli-client/views"
+)
+
+// ── Onboarding tour ───────────────────────────────────────────────────────────
+//
+// The first time the chat screen opens, views.ChatView.StartTour walks
+// through it. Finishing or skipping the tour writes tour.json next to
+// config.json and it is not offered again; /tour replays it any time.
+
+// tourState is tour.json.
+type tourState struct {
+	EndedAt   time.Time `json:"ended_at"`
+	Completed bool      `json:"completed"` // false: skipped with Esc
+}
+
+// offerTour starts the tour unless it was taken before. Must be called from
+// the tview event loop.
+func (ac *AppController) offerTour() {
+	path, err := config.TourStatePath()
+	if err != nil {
+		return
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	ac.startTour(true)
+}
+
+// startTour shows the tour, and with remember records in tour.json how it
+// ended; /tour replays it without. Must be called from the tview event loop.
+func (ac *AppController) startTour(remember bool) {
+	chat, ok := ac.Views[models.ScreenChat].(*views.ChatView)
+	if !ok {
+		return
+	}
+	chat.StartTour(func(completed bool) {
+		log.Printf("tour: ended, completed=%v", completed)
+		if !remember || ac.wiped {
+			return
+		}
+		if err := saveTourState(tourState{EndedAt: time.Now(), Completed: completed}); err != nil {
+			log.Printf("tour: not saved: %v", err)
+		}
+	})
+}
+
+func saveTourState(st tourState) error {
+	path, err := config.TourStatePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"cli-client/version"
+)
+
+// ── Relay transport ───────────────────────────────────────────────────────────
+//
+// Every request the client makes goes through relayTransport, which applies
+// certificate pinning (pinning.go), hidden-service mode (onion.go) and the
+// outbound proxy (proxy.go) in one place, and stamps each request with the
+// client's User-Agent.
+//
+// The http.Transport underneath asks for gzip and decompresses the answer
+// before anyone reads it, so poll and history responses cross the network
+// compressed (the relay compresses JSON of 1 KiB and more). Nothing may set
+// Accept-Encoding by hand: that turns the decompression off.
+
+type relayRoundTripper struct {
+	base http.RoundTripper
+}
+
+func (t relayRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := checkPinPolicy(req); err != nil {
+		return nil, err
+	}
+	if err := checkOnionPolicy(req); err != nil {
+		return nil, err
+	}
+	if err := checkProxyPolicy(req); err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", version.UserAgent())
+	return t.base.RoundTrip(req)
+}
+
+// relayTransport carries every request to the relay.
+var relayTransport = func() http.RoundTripper {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.TLSClientConfig = &tls.Config{
+		// verifyPins does the whole certificate check, see pinning.go.
+		InsecureSkipVerify: true,
+		VerifyConnection:   verifyPins,
+	}
+	base.Proxy = relayProxy
+	return relayRoundTripper{base: base}
+}()
+
+// closeRelayConnections drops kept-alive connections, so the next request
+// goes through a fresh TLS handshake and the current pins.
+func closeRelayConnections() {
+	relayTransport.(relayRoundTripper).base.(*http.Transport).CloseIdleConnections()
+}
+
+// relayClient returns an HTTP client for talking to the relay. Timeouts are
+// stretched for .onion relays, where every round trip crosses a Tor circuit.
+func relayClient(timeout time.Duration) *http.Client {
+	if onionMode() {
+		timeout *= onionTimeoutFactor
+	}
+	return &http.Client{Timeout: timeout, Transport: relayTransport}
+}
@@ -0,0 +1,275 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cli-client/config"
+	"cli-client/crypto"
+	"cli-client/trust"
+
+	"github.com/rivo/tview"
+)
+
+// ── Trust on first use ────────────────────────────────────────────────────────
+//
+// The client publishes its identity key at login and looks up the key of
+// everyone it hears from (messages, presence). The first key seen for a
+// user is pinned in the trust store; a different one later raises the
+// warning banner, an audit event and a red line in the chat, once per new
+// key, until the user accepts it with /trust.
+
+// keyRecheckInterval is how often a peer's published key is looked up again.
+const keyRecheckInterval = 10 * time.Minute
+
+// peerKeys throttles key lookups and warnings. Used from the poll and
+// presence goroutines.
+type peerKeys struct {
+	mu      sync.Mutex
+	self    string               // own username, never checked
+	checked map[string]time.Time // username → last lookup
+	warned  map[string]string    // username → changed key already warned about
+}
+
+// openTrust loads the identity key and the trust store. Called from
+// LoadConfig; problems are shown once the chat opens, and a broken trust
+// store is replaced by an in-memory one rather than overwritten.
+func (ac *AppController) openTrust() {
+	path, err := config.IdentityPath()
+	if err == nil {
+		ac.identity, err = crypto.LoadOrCreateIdentity(path)
+	}
+	if err != nil {
+		log.Printf("config: identity: %v", err)
+		ac.configNotes = append(ac.configNotes, fmt.Sprintf("Identity key not loaded: %s — logging in without one.", tview.Escape(err.Error())))
+	}
+
+	path, err = config.TrustPath()
+	if err == nil {
+		var store *trust.Store
+		if store, err = trust.Open(path); err == nil {
+			ac.trustStore = store
+		}
+	}
+	if err != nil {
+		log.Printf("config: trust store: %v", err)
+		ac.configNotes = append(ac.configNotes, fmt.Sprintf("Trust store not loaded: %s — pinned keys are kept in memory only.", tview.Escape(err.Error())))
+	}
+}
+
+// publicIdentityKey is the key published at login, "" without an identity.
+func (ac *AppController) publicIdentityKey() string {
+	if ac.identity == nil {
+		return ""
+	}
+	return ac.identity.PublicKey()
+}
+
+// publishedKeys are the keys published at login: the identity key and the
+// X25519 key direct messages are sealed to. Empty without an identity.
+func (ac *AppController) publishedKeys() PublishedKeys {
+	if ac.identity == nil {
+		return PublishedKeys{}
+	}
+	dh, sig := ac.identity.DHPublicKey()
+	return PublishedKeys{IdentityKey: ac.identity.PublicKey(), DHKey: dh, DHKeySig: sig}
+}
+
+// checkPeerKey looks up username's published key through nc, at most every
+// keyRecheckInterval, and compares it with the pinned one. Safe to call
+// from any goroutine; the lookup runs in the background.
+func (ac *AppController) checkPeerKey(nc *NetworkClient, username string) {
+	if ac.keyCheckDue(username) {
+		go ac.lookupPeerKey(nc, username)
+	}
+}
+
+// keyCheckDue reports whether username's key should be looked up now and,
+// if so, records the lookup.
+func (ac *AppController) keyCheckDue(username string) bool {
+	ac.peers.mu.Lock()
+	defer ac.peers.mu.Unlock()
+	if username == ac.peers.self || time.Since(ac.peers.checked[username]) < keyRecheckInterval {
+		return false
+	}
+	ac.peers.checked[username] = time.Now()
+	return true
+}
+
+// lookupPeerKey fetches username's published key, pins it on first use and
+// warns if it differs from the pinned one. Blocks on the relay.
+func (ac *AppController) lookupPeerKey(nc *NetworkClient, username string) {
+	key, err := nc.FetchIdentityKey(username)
+	if err != nil {
+		if !errors.Is(err, ErrNoIdentityKey) {
+			// Try again with the next message instead of in 10 minutes.
+			ac.peers.mu.Lock()
+			delete(ac.peers.checked, username)
+			ac.peers.mu.Unlock()
+		}
+		log.Printf("TRACE lookupPeerKey: %s: %v", username, err)
+		return
+	}
+	result, pinned, err := ac.trustStore.Check(username, key)
+	if err != nil {
+		log.Printf("trust: %s: %v", username, err)
+	}
+	switch result {
+	case trust.New:
+		log.Printf("trust: pinned %s's key %s on first use", username, pinned.Fingerprint)
+	case trust.Match:
+		ac.peers.mu.Lock()
+		delete(ac.peers.warned, username)
+		ac.peers.mu.Unlock()
+	case trust.Changed:
+		ac.warnKeyChanged(username, pinned, key)
+	}
+}
+
+// warnKeyChanged raises the alarm about a changed key, once per new key.
+func (ac *AppController) warnKeyChanged(username string, pinned trust.Entry, key string) {
+	ac.peers.mu.Lock()
+	already := ac.peers.warned[username] == key
+	ac.peers.warned[username] = key
+	ac.peers.mu.Unlock()
+	if already {
+		return
+	}
+
+	fp, _ := trust.Fingerprint(key)
+	ac.peerKeyChanged(username, pinned.Fingerprint, fp)
+	ac.app.QueueUpdateDraw(func() {
+		ac.sendSystem(fmt.Sprintf("[red::b]⚠ IDENTITY KEY CHANGED for %s[-::-] — pinned %s, relay now serves %s.",
+			tview.Escape(username), pinned.Fingerprint, fp))
+		ac.sendSystem(fmt.Sprintf("[red]  Someone may be impersonating %s. Compare fingerprints with them in person, then /trust %s to accept the new key.[-]",
+			tview.Escape(username), tview.Escape(username)))
+	})
+}
+
+// handleTrust implements /trust:
+//
+//	/trust                      list pinned keys and your own fingerprint
+//	/trust <user>               accept the key the relay serves for user now
+//	/trust <user> <fingerprint> accept it only if it has that fingerprint
+//	/trust export <path>        write the trust store to a file
+//
+// Must be called from the tview event loop; lookups run in the background.
+func (ac *AppController) handleTrust(arg string) {
+	fields := strings.Fields(arg)
+	switch {
+	case len(fields) == 0:
+		ac.listTrust()
+		return
+	case fields[0] == "export" && len(fields) == 2:
+		ac.exportTrust(fields[1])
+		return
+	}
+
+	username := fields[0]
+	want := ""
+	if len(fields) > 1 {
+		want = trust.NormalizeFingerprint(strings.Join(fields[1:], ""))
+	}
+	nc := ac.netClient
+	if nc == nil {
+		ac.sendSystem("Not connected — can't look up keys.")
+		return
+	}
+
+	go func() {
+		key, err := nc.FetchIdentityKey(username)
+		ac.app.QueueUpdateDraw(func() {
+			if err != nil {
+				ac.sendSystem(fmt.Sprintf("No key for %s: %s", tview.Escape(username), tview.Escape(err.Error())))
+				return
+			}
+			fp, _ := trust.Fingerprint(key)
+			if want != "" && trust.NormalizeFingerprint(fp) != want {
+				ac.sendSystem(fmt.Sprintf("[red]Not trusted:[-] the relay serves %s for %s, not the fingerprint you gave.",
+					fp, tview.Escape(username)))
+				return
+			}
+			if _, err := ac.trustStore.Trust(username, key); err != nil {
+				ac.sendSystem(fmt.Sprintf("Trust store not saved: %s", tview.Escape(err.Error())))
+			}
+			ac.peers.mu.Lock()
+			delete(ac.peers.warned, username)
+			ac.peers.mu.Unlock()
+			ac.sendSystem(fmt.Sprintf("[green]Trusted[-] %s with key %s.", tview.Escape(username), fp))
+		})
+	}()
+}
+
+// handleUntrust implements /untrust <user>: forget the pinned key, so the
+// next one seen is pinned on first use again.
+func (ac *AppController) handleUntrust(arg string) {
+	username := strings.TrimSpace(arg)
+	if username == "" {
+		ac.sendSystem("Usage: /untrust <user>")
+		return
+	}
+	removed, err := ac.trustStore.Untrust(username)
+	switch {
+	case err != nil:
+		ac.sendSystem(fmt.Sprintf("Trust store not saved: %s", tview.Escape(err.Error())))
+	case !removed:
+		ac.sendSystem(fmt.Sprintf("No key pinned for %s.", tview.Escape(username)))
+	default:
+		ac.peers.mu.Lock()
+		delete(ac.peers.checked, username)
+		delete(ac.peers.warned, username)
+		ac.peers.mu.Unlock()
+		ac.sendSystem(fmt.Sprintf("Forgot %s's key — the next one seen is pinned again.", tview.Escape(username)))
+	}
+}
+
+// listTrust shows the own fingerprint and every pinned key.
+func (ac *AppController) listTrust() {
+	if key := ac.publicIdentityKey(); key != "" {
+		fp, _ := trust.Fingerprint(key)
+		ac.sendSystem(fmt.Sprintf("Your fingerprint: [cyan]%s[-]", fp))
+	} else {
+		ac.sendSystem("You have no identity key this session.")
+	}
+
+	where := "memory only"
+	if path := ac.trustStore.Path(); path != "" {
+		where = tview.Escape(path)
+	}
+	names := ac.trustStore.Usernames()
+	ac.sendSystem(fmt.Sprintf("Pinned keys (%d) — %s:", len(names), where))
+	for _, name := range names {
+		e, _ := ac.trustStore.Get(name)
+		how := "[dim]first use[-]"
+		if e.Verified {
+			how = "[green]verified[-]"
+		}
+		ac.peers.mu.Lock()
+		changed := ac.peers.warned[name] != ""
+		ac.peers.mu.Unlock()
+		if changed {
+			how = "[red]CHANGED — /trust to accept[-]"
+		}
+		ac.sendSystem(fmt.Sprintf("  %s  %s  %s  [dim]since %s[-]",
+			tview.Escape(name), e.Fingerprint, how, e.FirstSeen.Format("2006-01-02")))
+	}
+}
+
+// exportTrust writes the trust store to path (~ is expanded).
+func (ac *AppController) exportTrust(path string) {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = home + path[1:]
+		}
+	}
+	if err := ac.trustStore.Export(path); err != nil {
+		ac.sendSystem(fmt.Sprintf("Export failed: %s", tview.Escape(err.Error())))
+		return
+	}
+	ac.sendSystem(fmt.Sprintf("Exported %d pinned key(s) to %s.", len(ac.trustStore.Usernames()), tview.Escape(path)))
+}
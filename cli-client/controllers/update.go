@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cli-client/config"
+	"cli-client/models"
+	"cli-client/version"
+	"cli-client/views"
+
+	"github.com/rivo/tview"
+)
+
+// ── Updates ───────────────────────────────────────────────────────────────────
+//
+// With "update_check": true in config.json the client asks the releases URL,
+// at most once a day, for the newest release. If it is newer than this build
+// the chat says so — once per session, until /update dismiss hides that
+// version. The answer is kept in update.json next to config.json.
+//
+// Independently, the relay may declare a minimum client version: in its
+// message of the day, or by refusing the login with 426 Upgrade Required.
+// An older client is stopped at the login screen — it cannot talk to that
+// relay any more.
+
+// defaultReleasesURL answers like GitHub's "latest release" API.
+const defaultReleasesURL = "https://api.github.com/repos/mortza-mansory/TTC-cli-messanger/releases/latest"
+
+// updateCheckInterval is the least time between two release lookups.
+const updateCheckInterval = 24 * time.Hour
+
+// updateState is update.json.
+type updateState struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Latest    string    `json:"latest,omitempty"`    // newest release seen, e.g. "v1.2.0"
+	URL       string    `json:"url,omitempty"`       // its release page
+	Dismissed string    `json:"dismissed,omitempty"` // release /update dismiss hid
+}
+
+func loadUpdateState() updateState {
+	var st updateState
+	path, err := config.UpdateStatePath()
+	if err != nil {
+		return st
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("update: %v", err)
+		}
+		return st
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		log.Printf("update: %s: %v", path, err)
+	}
+	return st
+}
+
+func saveUpdateState(st updateState) error {
+	path, err := config.UpdateStatePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// releaseRoundTripper fetches the releases URL through the relay's proxy
+// and with its User-Agent, but with ordinary certificate checks: the pins
+// are for the relay. Hidden-service mode refuses it like any other host.
+type releaseRoundTripper struct {
+	base http.RoundTripper
+}
+
+func (t releaseRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := checkOnionPolicy(req); err != nil {
+		return nil, err
+	}
+	if err := checkProxyPolicy(req); err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", version.UserAgent())
+	return t.base.RoundTrip(req)
+}
+
+var releaseTransport = func() http.RoundTripper {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.Proxy = relayProxy
+	return releaseRoundTripper{base: base}
+}()
+
+// FetchLatestRelease asks releasesURL for the newest release and returns its
+// version and release page.
+func FetchLatestRelease(releasesURL string) (tag, page string, err error) {
+	client := &http.Client{Timeout: 10 * time.Second, Transport: releaseTransport}
+	resp, err := client.Get(releasesURL)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("releases HTTP %d", resp.StatusCode)
+	}
+
+	var release struct {
+		TagName string `json:"tag_name"`
+		HTMLURL string `json:"html_url"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&release); err != nil {
+		return "", "", fmt.Errorf("decode release: %w", err)
+	}
+	if _, ok := version.Compare(release.TagName, version.Version); !ok {
+		return "", "", fmt.Errorf("release %q is not a version", release.TagName)
+	}
+	return release.TagName, release.HTMLURL, nil
+}
+
+// checkForUpdate looks up the newest release if the last lookup is a day
+// old (or force is set) and announces it if it is newer than this build and
+// not dismissed. Runs off the event loop.
+func (ac *AppController) checkForUpdate(force bool) {
+	st := loadUpdateState()
+	if force || time.Since(st.CheckedAt) >= updateCheckInterval {
+		tag, page, err := FetchLatestRelease(ac.releasesURL)
+		// A failed lookup counts too — the next try is tomorrow, not at
+		// every start.
+		st.CheckedAt = time.Now()
+		if err != nil {
+			log.Printf("update: %v", err)
+		} else {
+			st.Latest, st.URL = tag, page
+		}
+		if err := saveUpdateState(st); err != nil {
+			log.Printf("update: %v", err)
+		}
+		if err != nil && force {
+			ac.app.QueueUpdateDraw(func() {
+				ac.sendSystem(fmt.Sprintf("Update check failed: %s", tview.Escape(err.Error())))
+			})
+			return
+		}
+	}
+
+	ac.app.QueueUpdateDraw(func() {
+		switch {
+		case st.Latest == "" || !version.Older(st.Latest):
+			if force {
+				ac.sendSystem(fmt.Sprintf("You have the newest client (%s).", tview.Escape(version.Version)))
+			}
+		case st.Latest == st.Dismissed && !force:
+		default:
+			ac.sendSystem(fmt.Sprintf("[green]⬆ New version %s available[-] — you have %s. %s",
+				tview.Escape(st.Latest), tview.Escape(version.Version), tview.Escape(st.URL)))
+			ac.sendSystem("[dim]/update dismiss hides this until the next release.[-]")
+		}
+	})
+}
+
+// handleUpdate implements /update:
+//
+//	/update           show this build, the newest release seen and the relay's minimum
+//	/update check     look for a new release now
+//	/update dismiss   stop announcing the newest release seen
+//
+// Must be called from the tview event loop; lookups run in the background.
+func (ac *AppController) handleUpdate(arg string) {
+	switch strings.ToLower(arg) {
+	case "":
+		st := loadUpdateState()
+		ac.sendSystem(fmt.Sprintf("Client %s", tview.Escape(version.Summary())))
+		if st.Latest != "" {
+			ac.sendSystem(fmt.Sprintf("  Newest release: %s [dim](checked %s)[-]", tview.Escape(st.Latest), st.CheckedAt.Format("2006-01-02 15:04")))
+		}
+		if ac.minClientVersion != "" {
+			ac.sendSystem(fmt.Sprintf("  Relay requires: %s or newer", tview.Escape(ac.minClientVersion)))
+		}
+		if !ac.updateCheck {
+			ac.sendSystem("  [dim]Daily check off — set \"update_check\": true in config.json, or /update check once.[-]")
+		}
+	case "check":
+		go ac.checkForUpdate(true)
+	case "dismiss":
+		st := loadUpdateState()
+		if st.Latest == "" {
+			ac.sendSystem("No release to dismiss.")
+			return
+		}
+		st.Dismissed = st.Latest
+		if err := saveUpdateState(st); err != nil {
+			ac.sendSystem(fmt.Sprintf("Not saved: %s", tview.Escape(err.Error())))
+			return
+		}
+		ac.sendSystem(fmt.Sprintf("Won't mention %s again.", tview.Escape(st.Latest)))
+	default:
+		ac.sendSystem("Usage: /update [check|dismiss]")
+	}
+}
+
+// blockOutdated stops the client at the login screen because the relay
+// requires a newer version. Must be called from the tview event loop.
+func (ac *AppController) blockOutdated(reason string) {
+	log.Printf("update: blocked: %s", reason)
+	if login, ok := ac.Views[models.ScreenLogin].(*views.LoginView); ok {
+		login.ShowBlocked(fmt.Sprintf("%s\nYou have %s. Download a newer client from %s — Ctrl+C to quit.",
+			tview.Escape(reason), tview.Escape(version.Version), "https://github.com/mortza-mansory/TTC-cli-messanger/releases"))
+	}
+}
@@ -0,0 +1,226 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ── WebSocket transport ───────────────────────────────────────────────────────
+//
+// --transport ws receives the stream (stream.go) over a WebSocket on /api/ws:
+// the same events, one JSON text frame each ({"event": ..., "data": ...}),
+// for proxies that pass WebSocket but buffer event streams. The relay pings
+// in place of the keepalive comment. The handshake goes through
+// relayTransport like every other request, so pins, onion mode and the
+// proxy apply; net/http hands back the connection once the relay switches
+// protocols.
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Frame opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// maxWSMessage caps one message from the relay, as the SSE scanner caps a
+// line.
+const maxWSMessage = 1 << 20
+
+// errNoWebSocket means the relay, or a proxy in front of it, doesn't speak
+// WebSocket.
+var errNoWebSocket = errors.New("relay has no /api/ws")
+
+// websocket opens /api/ws and hands every message to handleIncoming until
+// the relay closes it, Wake or Stop cancels it, or it goes quiet for
+// streamIdleTimeout. connected is called once the relay accepted it.
+func (nc *NetworkClient) websocket(connected func()) error {
+	pollCtx, done := nc.pollContext()
+	defer done()
+	ctx, cancel := context.WithCancel(pollCtx)
+	defer cancel()
+	idle := time.AfterFunc(streamIdleTimeout, cancel)
+	defer idle.Stop()
+
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	log.Printf("TRACE websocket: GET %s%s", nc.serverURL, nc.messagePath("ws"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, nc.serverURL+nc.messagePath("ws")+"?"+nc.streamQuery(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	nc.authorize(req, nil)
+
+	resp, err := relayClient(0).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	log.Printf("TRACE websocket: response status=%d", resp.StatusCode)
+	nc.checkServerCert(resp)
+
+	switch resp.StatusCode {
+	case http.StatusSwitchingProtocols:
+	case http.StatusNotFound, http.StatusUpgradeRequired:
+		return errNoWebSocket
+	case http.StatusUnauthorized:
+		return fmt.Errorf("server rejected session token — log in again")
+	default:
+		return fmt.Errorf("unexpected HTTP %d", resp.StatusCode)
+	}
+	sum := sha1.Sum([]byte(key + wsGUID))
+	if resp.Header.Get("Sec-WebSocket-Accept") != base64.StdEncoding.EncodeToString(sum[:]) {
+		return errors.New("websocket: relay answered the handshake wrongly")
+	}
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		return errors.New("websocket: connection not writable")
+	}
+	// Reads don't watch ctx once the protocol switched; closing does.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	connected()
+
+	r := bufio.NewReader(conn)
+	for {
+		op, payload, err := readWSMessage(r)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		idle.Reset(streamIdleTimeout)
+		switch op {
+		case wsPing:
+			if err := writeWSFrame(conn, wsPong, payload); err != nil {
+				return err
+			}
+		case wsClose:
+			if len(payload) > 2 {
+				payload = payload[:2] // echo the status code only
+			}
+			writeWSFrame(conn, wsClose, payload)
+			return nil
+		case wsText:
+			var ev struct {
+				Event string          `json:"event"`
+				Data  json.RawMessage `json:"data"`
+			}
+			if err := json.Unmarshal(payload, &ev); err != nil {
+				return fmt.Errorf("websocket: %w", err)
+			}
+			data := []byte(ev.Data)
+			var reason string
+			if ev.Event == "error" && json.Unmarshal(data, &reason) == nil {
+				data = []byte(reason)
+			}
+			if err := nc.dispatchEvent(ev.Event, data); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readWSMessage reads the relay's next message, joining fragments, and
+// returns its opcode and payload. Control frames come back as they arrive,
+// even between fragments.
+func readWSMessage(r *bufio.Reader) (byte, []byte, error) {
+	var op byte
+	var message []byte
+	for {
+		fin, frameOp, payload, err := readWSFrame(r)
+		if err != nil {
+			return 0, nil, err
+		}
+		if frameOp >= wsClose {
+			return frameOp, payload, nil
+		}
+		if frameOp != wsContinuation {
+			op = frameOp
+		}
+		if len(message)+len(payload) > maxWSMessage {
+			return 0, nil, errors.New("websocket: message too large")
+		}
+		message = append(message, payload...)
+		if fin {
+			return op, message, nil
+		}
+	}
+}
+
+// readWSFrame reads one unmasked frame from the relay.
+func readWSFrame(r *bufio.Reader) (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = head[0]&0x80 != 0
+	op = head[0] & 0x0F
+	if head[1]&0x80 != 0 {
+		return false, 0, nil, errors.New("websocket: masked frame from the relay")
+	}
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxWSMessage {
+		return false, 0, nil, errors.New("websocket: frame too large")
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	return fin, op, payload, nil
+}
+
+// writeWSFrame sends a control frame, masked as a client must.
+func writeWSFrame(w io.Writer, op byte, payload []byte) error {
+	if len(payload) > 125 {
+		return errors.New("websocket: control frame too large")
+	}
+	frame := make([]byte, 6, 6+len(payload))
+	frame[0] = 0x80 | op
+	frame[1] = 0x80 | byte(len(payload))
+	if _, err := rand.Read(frame[2:6]); err != nil {
+		return err
+	}
+	for i, b := range payload {
+		frame = append(frame, b^frame[2+i%4])
+	}
+	_, err := w.Write(frame)
+	return err
+}
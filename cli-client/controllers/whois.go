@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+)
+
+// ── Whois ─────────────────────────────────────────────────────────────────────
+//
+// /whois [user] asks the relay (GET /api/whois) when it first and last saw
+// the user, how many messages they sent and whether they are online, and
+// shows it as a block of system lines. The relay keeps this in memory only,
+// so "first seen" means since it started. Against a relay without the
+// endpoint, or while offline, /whois falls back to what the client itself
+// knows: the color, the sidebar's presence and the messages on screen.
+
+// WhoisInfo mirrors the /api/whois response.
+type WhoisInfo struct {
+	Username   string     `json:"username"`
+	Online     bool       `json:"online"`
+	Clients    int        `json:"clients"`
+	FirstSeen  *time.Time `json:"first_seen"`
+	LastSeen   *time.Time `json:"last_seen"`
+	LastActive *time.Time `json:"last_active"`
+	Messages   int64      `json:"messages"`
+}
+
+// errNoWhois means the relay predates /api/whois.
+var errNoWhois = errors.New("this relay has no /api/whois")
+
+// errUnknownUser is /api/whois's answer for a username with no account.
+var errUnknownUser = errors.New("no account with that username")
+
+// FetchWhois calls GET /api/whois for username.
+func (nc *NetworkClient) FetchWhois(username string) (*WhoisInfo, error) {
+	params := url.Values{}
+	params.Set("user", username)
+	resp, err := nc.relayGet(relayClient(5*time.Second), "/api/whois", params)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// The relay's own 404 names the account; the mux's means no endpoint.
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		if strings.TrimSpace(string(body)) == errUnknownUser.Error() {
+			return nil, errUnknownUser
+		}
+		return nil, errNoWhois
+	default:
+		return nil, fmt.Errorf("whois HTTP %d", resp.StatusCode)
+	}
+
+	var info WhoisInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("decode whois: %w", err)
+	}
+	return &info, nil
+}
+
+// handleWhois implements /whois. Must be called from the tview event loop;
+// the fetch runs in the background.
+func (ac *AppController) handleWhois(arg string) {
+	if ac.App.CurrentUser == nil {
+		ac.sendSystem("No user logged in.")
+		return
+	}
+	name := strings.TrimPrefix(arg, "@")
+	if name == "" {
+		name = ac.App.CurrentUser.Username
+	}
+	nc := ac.netClient
+	if nc == nil {
+		ac.showLocalWhois(name)
+		return
+	}
+	go func() {
+		info, err := nc.FetchWhois(name)
+		ac.app.QueueUpdateDraw(func() {
+			switch {
+			case err == nil:
+				ac.showWhois(info)
+			case errors.Is(err, errUnknownUser):
+				ac.sendSystem(fmt.Sprintf("[red]Whois: no user %q on this relay.[-]", name))
+			default:
+				log.Printf("TRACE whois: %v — showing local info", err)
+				ac.showLocalWhois(name)
+			}
+		})
+	}()
+}
+
+func (ac *AppController) showWhois(w *WhoisInfo) {
+	colorTag := ac.App.GetUserColorTag(w.Username)
+	ac.sendSystem(fmt.Sprintf("Whois %s%s[-]  [dim]color %s[-]", colorTag, tview.Escape(w.Username), strings.Trim(colorTag, "[]")))
+
+	status := "[dim]offline[-]"
+	if w.Online {
+		status = "[green]online[-]"
+		if w.Clients > 1 {
+			status += fmt.Sprintf(" on %d clients", w.Clients)
+		}
+		if w.LastActive != nil {
+			status += ", last active " + whoisAgo(*w.LastActive)
+		}
+	}
+	ac.sendSystem("  status:     " + status)
+
+	if w.FirstSeen == nil {
+		ac.sendSystem("  [dim]not seen since the relay started[-]")
+		return
+	}
+	ac.sendSystem(fmt.Sprintf("  first seen: %s (%s)", w.FirstSeen.Local().Format("Jan 2 15:04"), whoisAgo(*w.FirstSeen)))
+	if w.LastSeen != nil {
+		ac.sendSystem(fmt.Sprintf("  last seen:  %s (%s)", w.LastSeen.Local().Format("Jan 2 15:04"), whoisAgo(*w.LastSeen)))
+	}
+	ac.sendSystem(fmt.Sprintf("  messages:   %d sent since first seen", w.Messages))
+}
+
+// showLocalWhois is /whois from what this client knows alone.
+func (ac *AppController) showLocalWhois(name string) {
+	status := "offline"
+	if name == ac.App.CurrentUser.Username {
+		status = "online"
+	}
+	for _, u := range ac.App.OnlineUsers() {
+		if u.Username == name {
+			status = "online"
+		}
+	}
+	colorTag := ac.App.GetUserColorTag(name)
+	colorDisplay := strings.Trim(colorTag, "[]")
+	ac.sendSystem(fmt.Sprintf(
+		"Whois  ▸  user: %s%s[-]  |  color: %s  |  status: %s  |  msgs sent: %d",
+		colorTag, tview.Escape(name), colorDisplay, status, ac.countUserMessages(name),
+	))
+}
+
+// whoisAgo formats how long ago t was: "just now", "42s ago", "3h12m0s ago".
+func whoisAgo(t time.Time) string {
+	ago := time.Since(t).Round(time.Second)
+	if ago < 5*time.Second {
+		return "just now"
+	}
+	return ago.String() + " ago"
+}
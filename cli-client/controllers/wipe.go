@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"io"
+	"log"
+	"os"
+
+	"cli-client/config"
+	"cli-client/models"
+	"cli-client/views"
+)
+
+// ── Panic button ──────────────────────────────────────────────────────────────
+//
+// /wipe       clears the scrollback, sent-message history (and its file),
+//             the draft in the input field, the offline send queue, the
+//             local log file (error.txt holds message traces) and
+//             session.json, then exits without resuming next time.
+// /wipe all   additionally deletes the config directory (including the
+//             audit log, identity key and trust store) and forgets the
+//             session token.
+//
+// Nothing is asked first — the point is to be fast. Failures are ignored
+// because there is no one left to report them to.
+
+// SetLogFile tells the controller which file the log is written to so
+// /wipe can erase it.
+func (ac *AppController) SetLogFile(f *os.File) {
+	ac.logFile = f
+}
+
+// Wiped reports whether the client exited through /wipe; main then also
+// clears the terminal's own scrollback.
+func (ac *AppController) Wiped() bool {
+	return ac.wiped
+}
+
+// wipe implements /wipe. Must be called from the tview event loop.
+func (ac *AppController) wipe(all bool) {
+	ac.wiped = true
+
+	// Stop logging first so nothing new reaches the disk.
+	log.SetOutput(io.Discard)
+	views.DebugLogFile = nil
+
+	if ac.netClient != nil {
+		ac.netClient.DropPending()
+	}
+	ac.StopBot()
+
+	ac.App.Messages = nil
+	ac.historyBefore, ac.historyMore = "", false
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.Wipe()
+	}
+
+	if ac.logFile != nil {
+		ac.logFile.Truncate(0)
+		ac.logFile.Close()
+		os.Remove(ac.logFile.Name())
+		ac.logFile = nil
+	}
+
+	if path, err := config.SessionStatePath(); err == nil {
+		os.Remove(path)
+	}
+	if path, err := config.HistoryPath(); err == nil {
+		os.Remove(path)
+	}
+
+	if all {
+		ac.session = nil
+		ac.audit.Close()
+		ac.trustStore.Wipe() // late key checks must not recreate trust.json
+		if dir, err := config.Dir(); err == nil {
+			os.RemoveAll(dir)
+		}
+	}
+
+	ac.app.Stop()
+}
@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+
+	"cli-client/models"
+	"cli-client/views"
+
+	"github.com/rivo/tview"
+)
+
+// ── Cross-posting ─────────────────────────────────────────────────────────────
+//
+// "/xpost ops,dev deploy at 5" posts the text to the current room and to
+// #ops and #dev in one request. The relay stores a linked copy per room —
+// each with its own ID, all sharing the ID of the first as "origin" — and
+// every copy lists the other rooms in "also_in", which the chat shows as
+// "· also posted in #ops, #dev". Each copy is sealed and signed for its own
+// room, like a forward. Cross-posts need protocol version 2.
+
+// maxXPostRooms is how many rooms, the current one included, the relay
+// takes one cross-post to.
+const maxXPostRooms = 6
+
+// xpostCopy is a cross-post's copy for one more room, sealed for it.
+type xpostCopy struct {
+	room    string
+	content string
+}
+
+// SendCrossPost relays content to the current room, sealed there like
+// SendMessage, with copies for more rooms. Delivery is reported like for
+// SendMessage.
+func (nc *NetworkClient) SendCrossPost(localID, username, content, colorTag string, copies []xpostCopy) {
+	if atomic.LoadInt32(&nc.stopped) == 1 {
+		return
+	}
+	log.Printf("TRACE NetworkClient.SendCrossPost: user=%q rooms=%d content=%.60q", username, len(copies)+1, content)
+	nc.send(outboundMessage{localID: localID, username: username, content: content, colorTag: colorTag, xpost: copies})
+}
+
+// handleXPost implements /xpost <room,room…> <text>. Must be called from
+// the tview event loop; sealing runs in the background.
+func (ac *AppController) handleXPost(arg string) {
+	nc := ac.netClient
+	if nc == nil || ac.session == nil {
+		ac.sendSystem("Not connected.")
+		return
+	}
+	list, text, _ := strings.Cut(arg, " ")
+	text = strings.TrimSpace(text)
+	if list == "" || text == "" {
+		ac.sendSystem("Usage: /xpost <room1,room2> <text>  —  post to this room and those at once")
+		return
+	}
+	if ac.session.Protocol < 2 {
+		ac.sendSystem("This relay can't cross-post — it speaks only protocol version 1.")
+		return
+	}
+
+	var rooms []string
+	for _, room := range strings.Split(list, ",") {
+		room = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(room), "#"))
+		if room == "" || room == ac.App.Room || containsString(rooms, room) {
+			continue
+		}
+		rooms = append(rooms, room)
+	}
+	switch {
+	case len(rooms) == 0:
+		ac.sendSystem("Name at least one other room, e.g. /xpost ops,dev <text>")
+		return
+	case len(rooms) > maxXPostRooms-1:
+		ac.sendSystem(fmt.Sprintf("A cross-post reaches at most %d rooms besides this one.", maxXPostRooms-1))
+		return
+	}
+
+	msg := models.NewMessage(ac.session.Username, text)
+	msg.Color = ac.App.GetUserColorTag(ac.session.Username)
+	msg.AlsoIn = rooms
+	msg.Delivery = models.DeliverySending
+	ac.App.AddMessage(msg)
+	if chat, ok := ac.Views[models.ScreenChat].(*views.ChatView); ok {
+		chat.AddMessage(msg)
+		ac.rememberSent(chat, "/xpost "+arg)
+	}
+
+	localID, username, color := msg.ID, msg.Username, msg.Color
+	e2e := make([]bool, len(rooms))
+	for i, room := range rooms {
+		e2e[i] = ac.e2eRooms[room]
+	}
+	go func() {
+		copies := make([]xpostCopy, len(rooms))
+		for i, room := range rooms {
+			var sealed string
+			var err error
+			switch {
+			case nc.PrivacyEnabled():
+				sealed, err = ac.gc.EncryptPadded(text)
+			case e2e[i]:
+				sealed, err = ac.gc.EncryptText(text)
+			default:
+				sealed = text
+			}
+			if err != nil {
+				log.Printf("TRACE handleXPost: room=%q: %v", room, err)
+				ac.app.QueueUpdateDraw(func() {
+					ac.sendSystem(fmt.Sprintf("Cross-post not sent: %s", tview.Escape(err.Error())))
+					ac.setDelivery(localID, models.DeliveryFailed)
+				})
+				return
+			}
+			copies[i] = xpostCopy{room: room, content: sealed}
+		}
+		nc.SendCrossPost(localID, username, text, color, copies)
+	}()
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
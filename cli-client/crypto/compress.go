@@ -0,0 +1,83 @@
+package crypto
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ── Compressed frames ─────────────────────────────────────────────────────────
+//
+// A long text — a pasted log, a stack trace — is compressed with DEFLATE
+// before it is sealed, when that makes it smaller. The plaintext is then a
+// frame (see padding.go) of kind FrameDeflate whose body is the compressed
+// text; outside privacy mode the frame is not padded. The relay stores and
+// serves the smaller ciphertext and never sees the difference.
+//
+// Clients from before compressed frames can't open them and show such a
+// message as undecryptable. Compression lets the ciphertext length depend
+// on what the text repeats; in privacy mode the padding hides most of that.
+
+// FrameDeflate is a frame whose body is the text compressed with DEFLATE.
+const FrameDeflate FrameKind = 3
+
+// CompressThreshold is the shortest text, in bytes, that is compressed.
+const CompressThreshold = 1024
+
+// maxInflated caps a decompressed text, so a small message can't inflate
+// into gigabytes.
+const maxInflated = 4 << 20
+
+// compress returns text compressed, or ok=false when it is short, doesn't
+// shrink or doesn't fit a frame.
+func compress(text string) (body []byte, ok bool) {
+	if len(text) < CompressThreshold {
+		return nil, false
+	}
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, false
+	}
+	if _, err := io.WriteString(w, text); err != nil || w.Close() != nil {
+		return nil, false
+	}
+	if buf.Len() >= len(text) || buf.Len() > 0xFFFF {
+		return nil, false
+	}
+	return buf.Bytes(), true
+}
+
+// inflate decompresses a FrameDeflate body.
+func inflate(body []byte) (string, error) {
+	r := flate.NewReader(bytes.NewReader(body))
+	defer r.Close()
+	text, err := io.ReadAll(io.LimitReader(r, maxInflated+1))
+	if err != nil {
+		return "", err
+	}
+	if len(text) > maxInflated {
+		return "", errors.New("compressed message too large")
+	}
+	return string(text), nil
+}
+
+// unpaddedFrame is a frame of exactly the header and body.
+func unpaddedFrame(kind FrameKind, body []byte) []byte {
+	out := make([]byte, frameHeaderSize+len(body))
+	out[1] = byte(kind)
+	binary.BigEndian.PutUint16(out[2:4], uint16(len(body)))
+	copy(out[frameHeaderSize:], body)
+	return out
+}
+
+// EncryptText seals text like Encrypt, compressed first when it is long
+// enough to gain from it. It is what EncryptPadded is in privacy mode.
+func (gc *GlobalCrypto) EncryptText(text string) (string, error) {
+	if body, ok := compress(text); ok {
+		return gc.Encrypt(unpaddedFrame(FrameDeflate, body))
+	}
+	return gc.Encrypt([]byte(text))
+}
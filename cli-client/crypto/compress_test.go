@@ -0,0 +1,126 @@
+package crypto
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+// logText is the kind of long, repetitive text compression is for.
+func logText(n int) string {
+	var b strings.Builder
+	for b.Len() < n {
+		b.WriteString("2024-01-01T12:00:00Z INFO request handled path=/api/poll status=204\n")
+	}
+	return b.String()[:n]
+}
+
+func deflate(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	for _, n := range []int{CompressThreshold, 4096, 60000} {
+		text := logText(n)
+		body, ok := compress(text)
+		if !ok {
+			t.Fatalf("%d bytes: not compressed", n)
+		}
+		if len(body) >= len(text) {
+			t.Errorf("%d bytes: compressed to %d", n, len(body))
+		}
+		got, err := inflate(body)
+		if err != nil {
+			t.Fatalf("%d bytes: inflate: %v", n, err)
+		}
+		if got != text {
+			t.Errorf("%d bytes: round trip changed the text", n)
+		}
+	}
+}
+
+func TestCompressSkips(t *testing.T) {
+	random := make([]byte, 4096)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	for name, text := range map[string]string{
+		"short":          logText(CompressThreshold - 1),
+		"incompressible": string(random),
+	} {
+		if _, ok := compress(text); ok {
+			t.Errorf("%s: compressed", name)
+		}
+	}
+}
+
+func TestInflateMalformed(t *testing.T) {
+	valid := deflate(t, []byte(logText(4096)))
+	for name, body := range map[string][]byte{
+		"reserved block type": {0xff, 0xff, 0xff, 0xff},
+		"truncated":           valid[:len(valid)/2],
+		"empty":               {},
+	} {
+		if _, err := inflate(body); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestInflateBound(t *testing.T) {
+	if _, err := inflate(deflate(t, make([]byte, maxInflated))); err != nil {
+		t.Errorf("%d bytes: %v", maxInflated, err)
+	}
+	// A few KiB of zeros that would inflate past the cap.
+	bomb := deflate(t, make([]byte, maxInflated+1))
+	if _, err := inflate(bomb); err == nil {
+		t.Errorf("%d bytes from %d: no error", maxInflated+1, len(bomb))
+	}
+}
+
+func TestEncryptTextRoundTrip(t *testing.T) {
+	gc := NewGlobalCrypto()
+	for _, text := range []string{"hello", logText(8192)} {
+		sealed, err := gc.EncryptText(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, decoy, err := gc.OpenMessage(sealed)
+		if err != nil {
+			t.Fatalf("%d bytes: open: %v", len(text), err)
+		}
+		if decoy || got != text {
+			t.Errorf("%d bytes: got %d bytes, decoy=%v", len(text), len(got), decoy)
+		}
+	}
+}
+
+func TestEncryptTextShrinksLongText(t *testing.T) {
+	gc := NewGlobalCrypto()
+	text := logText(8192)
+	compressed, err := gc.EncryptText(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := gc.Encrypt([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) >= len(plain) {
+		t.Errorf("compressed %d, plain %d", len(compressed), len(plain))
+	}
+}
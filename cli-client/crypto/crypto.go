@@ -1,100 +1,224 @@
-// Package crypto provides end-to-end encryption for SecTherminal messages.
-//
-// All clients share the same AES-256-GCM key derived from a common passphrase.
-// The relay server only sees ciphertext and cannot read messages or usernames.
-//
-// Encryption scheme:
-//   - Key:       SHA-256 of shared passphrase  (32 bytes → AES-256)
-//   - Cipher:    AES-GCM  (authenticated encryption, IND-CCA2)
-//   - Nonce:     12 random bytes prepended to ciphertext
-//   - Encoding:  Base64 (standard) for safe JSON transport
-package crypto
-
-import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/base64"
-	"errors"
-	"io"
-)
-
-// sharedPassphrase is the secret baked into every client binary.
-// Change this before shipping — any client with a different passphrase
-// cannot read messages from clients with the original one.
-const sharedPassphrase = "SecTherminal-global-relay-key-v1 @#$%^&*()"
-
-// globalKey is derived once at startup from the shared passphrase.
-var globalKey = sha256.Sum256([]byte(sharedPassphrase))
-
-// GlobalCrypto wraps AES-256-GCM encrypt/decrypt operations.
-// It is stateless and safe to use from multiple goroutines.
-type GlobalCrypto struct {
-	key [32]byte
-}
-
-// NewGlobalCrypto returns a GlobalCrypto ready to use.
-func NewGlobalCrypto() *GlobalCrypto {
-	return &GlobalCrypto{key: globalKey}
-}
-
-// Encrypt encrypts plaintext with AES-256-GCM and returns a Base64 string.
-// A fresh random 12-byte nonce is prepended to each ciphertext, so the same
-// plaintext produces different output on every call.
-func (gc *GlobalCrypto) Encrypt(plaintext []byte) (string, error) {
-	block, err := aes.NewCipher(gc.key[:])
-	if err != nil {
-		return "", err
-	}
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", err
-	}
-
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return "", err
-	}
-
-	// Seal appends the ciphertext (+tag) to nonce, producing: nonce || ciphertext || tag
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
-}
-
-// Decrypt decrypts a Base64-encoded AES-256-GCM ciphertext produced by Encrypt.
-// Returns an error if the message was tampered with or the key is wrong.
-func (gc *GlobalCrypto) Decrypt(encrypted string) ([]byte, error) {
-	data, err := base64.StdEncoding.DecodeString(encrypted)
-	if err != nil {
-		return nil, err
-	}
-
-	block, err := aes.NewCipher(gc.key[:])
-	if err != nil {
-		return nil, err
-	}
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-
-	if len(data) < gcm.NonceSize() {
-		return nil, errors.New("ciphertext too short")
-	}
-
-	nonce := data[:gcm.NonceSize()]
-	ciphertext := data[gcm.NonceSize():]
-
-	return gcm.Open(nil, nonce, ciphertext, nil)
-}
-
-// GenerateAccessKey derives a deterministic access key from the shared secret.
-// Both the client and server must agree on this value.
-// To match the server's hardcoded key, change the server to call this and
-// use the result instead of a literal string.
-func (gc *GlobalCrypto) GenerateAccessKey() string {
-	combined := append(gc.key[:], []byte("ACCESS_GRANTED")...)
-	hash := sha256.Sum256(combined)
-	return base64.StdEncoding.EncodeToString(hash[:16])
-}
+// Package crypto provides end-to-end encryption for SecTherminal messages.
+//
+// Clients share a key derived from a common passphrase: the room passphrase
+// entered at login (see passphrase.go) or, without one, the passphrase built
+// into every client. The relay server only sees ciphertext and cannot read
+// encrypted messages.
+//
+// Encryption scheme (see envelope.go for the byte layout):
+//   - Header:    version, cipher id, kdf id, key id — authenticated as AAD
+//   - Key:       derived from the keyring passphrase by the header's KDF
+//   - Cipher:    AES-256-GCM by default, XChaCha20-Poly1305 available
+//   - Nonce:     random per message, follows the header
+//   - Encoding:  Base64 (standard) for safe JSON transport
+//
+// Every message names its own suite, so a client can decrypt old and new
+// messages side by side while a migration (new cipher, KDF or rotated key)
+// rolls out.
+package crypto
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// sharedPassphrase is the secret baked into every client binary.
+// Change this before shipping — any client with a different passphrase
+// cannot read messages from clients with the original one.
+const sharedPassphrase = "SecTherminal-global-relay-key-v1 @#$%^&*()"
+
+// globalKey is derived once at startup from the shared passphrase. It stays
+// a plain SHA-256 so every client version can read messages under it —
+// there is nothing to brute-force in a passphrase that ships with the
+// binary. Passphrases people type go through Argon2id, see DeriveKey.
+var globalKey = sha256.Sum256([]byte(sharedPassphrase))
+
+// GlobalCrypto seals and opens message envelopes.
+// It is safe to use from multiple goroutines.
+type GlobalCrypto struct {
+	key [32]byte // legacy key (SHA-256 of key id 0), also used for access keys
+
+	mu      sync.RWMutex
+	keyring map[byte]string // key id → passphrase
+	suite   Suite           // used for new messages
+	salt    []byte          // Argon2id salt, nil = built-in
+
+	// derived caches derived keys — Argon2id is far too slow to run per
+	// message.
+	derivedMu sync.Mutex
+	derived   map[derivedID][32]byte
+}
+
+type derivedID struct {
+	kdf        KDFID
+	id         byte
+	passphrase string
+	salt       string
+}
+
+// NewGlobalCrypto returns a GlobalCrypto ready to use. Key id 0 is the
+// built-in shared passphrase; new messages use LegacySuite's cipher and KDF
+// wrapped in a versioned envelope.
+func NewGlobalCrypto() *GlobalCrypto {
+	return &GlobalCrypto{
+		key:     globalKey,
+		keyring: map[byte]string{0: sharedPassphrase},
+		suite:   LegacySuite,
+		derived: make(map[derivedID][32]byte),
+	}
+}
+
+// AddKey registers a passphrase under id, e.g. a rotated shared key.
+// Messages sealed under any registered id can be decrypted.
+func (gc *GlobalCrypto) AddKey(id byte, passphrase string) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.keyring[id] = passphrase
+}
+
+// SetSuite selects the suite for new messages. The key id must be in the
+// keyring and the cipher and KDF must be known.
+func (gc *GlobalCrypto) SetSuite(s Suite) error {
+	if _, err := gc.aead(s); err != nil {
+		return err
+	}
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.suite = s
+	return nil
+}
+
+// Suite returns the suite used for new messages.
+func (gc *GlobalCrypto) Suite() Suite {
+	gc.mu.RLock()
+	defer gc.mu.RUnlock()
+	return gc.suite
+}
+
+// SuiteOf reports which suite an encrypted message claims to use;
+// messages without an envelope report LegacySuite.
+func SuiteOf(encrypted string) (Suite, error) {
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return Suite{}, err
+	}
+	if s, ok := parseHeader(data); ok {
+		return s, nil
+	}
+	return LegacySuite, nil
+}
+
+func (gc *GlobalCrypto) aead(s Suite) (cipher.AEAD, error) {
+	key, err := gc.derivedKey(s)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(s.Cipher, key)
+}
+
+// derivedKey returns the key for s's KDF and key id, deriving it on first use.
+func (gc *GlobalCrypto) derivedKey(s Suite) ([32]byte, error) {
+	gc.mu.RLock()
+	passphrase, ok := gc.keyring[s.KeyID]
+	salt := gc.salt
+	gc.mu.RUnlock()
+	if !ok {
+		return [32]byte{}, fmt.Errorf("unknown key id %d", s.KeyID)
+	}
+
+	did := derivedID{kdf: s.KDF, id: s.KeyID, passphrase: passphrase, salt: string(salt)}
+	gc.derivedMu.Lock()
+	key, ok := gc.derived[did]
+	gc.derivedMu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	key, err := deriveKey(s.KDF, s.KeyID, passphrase, salt)
+	if err != nil {
+		return key, err
+	}
+	gc.derivedMu.Lock()
+	gc.derived[did] = key
+	gc.derivedMu.Unlock()
+	return key, nil
+}
+
+// Encrypt seals plaintext in a versioned envelope with the current suite and
+// returns it Base64-encoded. A fresh random nonce is used for each call, so
+// the same plaintext produces different output every time.
+func (gc *GlobalCrypto) Encrypt(plaintext []byte) (string, error) {
+	suite := gc.Suite()
+	aead, err := gc.aead(suite)
+	if err != nil {
+		return "", err
+	}
+
+	header := suite.header()
+	out := make([]byte, headerSize+aead.NonceSize(), headerSize+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	copy(out, header)
+	nonce := out[headerSize:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	// header || nonce || ciphertext || tag, with the header authenticated.
+	out = aead.Seal(out, nonce, plaintext, header)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+// Decrypt opens a Base64-encoded message produced by Encrypt, picking the
+// suite from its envelope header. Ciphertexts from before the envelope are
+// decrypted with LegacySuite. Returns an error if the message was tampered
+// with or its key is not in the keyring.
+func (gc *GlobalCrypto) Decrypt(encrypted string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	var envelopeErr error
+	if suite, ok := parseHeader(data); ok {
+		plaintext, err := gc.open(suite, data[:headerSize], data[headerSize:])
+		if err == nil {
+			return plaintext, nil
+		}
+		// A legacy nonce can start with the magic bytes by chance — fall
+		// through and try it as a legacy message before giving up.
+		envelopeErr = err
+	}
+
+	plaintext, err := gc.open(LegacySuite, nil, data)
+	if err != nil && envelopeErr != nil {
+		return nil, envelopeErr
+	}
+	return plaintext, err
+}
+
+func (gc *GlobalCrypto) open(suite Suite, header, data []byte) ([]byte, error) {
+	aead, err := gc.aead(suite)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce := data[:aead.NonceSize()]
+	return aead.Open(nil, nonce, data[aead.NonceSize():], header)
+}
+
+// GenerateAccessKey derives a deterministic access key from the shared secret.
+// Both the client and server must agree on this value.
+// To match the server's hardcoded key, change the server to call this and
+// use the result instead of a literal string.
+func (gc *GlobalCrypto) GenerateAccessKey() string {
+	combined := append(gc.key[:], []byte("ACCESS_GRANTED")...)
+	hash := sha256.Sum256(combined)
+	return base64.StdEncoding.EncodeToString(hash[:16])
+}
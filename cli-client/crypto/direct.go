@@ -0,0 +1,193 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// ── Direct messages ───────────────────────────────────────────────────────────
+//
+// Besides the identity key every client has an X25519 key for direct
+// messages. It is derived from the identity seed, so identity.key stays the
+// only secret on disk, and published at login together with the identity
+// key's signature over it — a relay that swaps it must also swap the pinned
+// identity key, which trust on first use catches.
+//
+// A direct message is sealed to the recipient's X25519 key with a fresh
+// ephemeral key per message:
+//
+//	header (xchacha20-poly1305, KDFX25519, key id 0)
+//	|| ephemeral public key (32) || nonce || ciphertext || tag
+//
+// The AEAD key is HKDF-SHA256 over the ECDH secret, bound to both public
+// keys; header and ephemeral key are authenticated as additional data. The
+// plaintext is a padded frame, so lengths leak only the bucket; a long text
+// is compressed first (see compress.go). Only the recipient can open the
+// message — not the relay, not the room and not the sender once it has
+// left.
+
+// dhKeyContext prefixes the X25519 key when the identity key signs it. The
+// relay verifies the same bytes.
+const dhKeyContext = "ttc-dh-key-v1"
+
+var directSuite = Suite{Cipher: CipherXChaCha20Poly1305, KDF: KDFX25519, KeyID: 0}
+
+var (
+	ErrBadDHKey  = errors.New("X25519 key is not signed by the identity key")
+	ErrNotDirect = errors.New("not a direct message")
+)
+
+// dhPrivate derives the X25519 key from the identity seed.
+func (id *Identity) dhPrivate() *ecdh.PrivateKey {
+	var seed [32]byte
+	r := hkdf.New(sha256.New, id.private.Seed(), nil, []byte(dhKeyContext))
+	if _, err := io.ReadFull(r, seed[:]); err != nil {
+		panic("crypto: hkdf: " + err.Error())
+	}
+	key, err := ecdh.X25519().NewPrivateKey(seed[:])
+	if err != nil {
+		panic("crypto: x25519: " + err.Error())
+	}
+	return key
+}
+
+// DHPublicKey returns the X25519 public key and the identity key's
+// signature over it, both Base64 — the form published at login.
+func (id *Identity) DHPublicKey() (key, sig string) {
+	pub := id.dhPrivate().PublicKey().Bytes()
+	signature := ed25519.Sign(id.private, append([]byte(dhKeyContext), pub...))
+	return base64.StdEncoding.EncodeToString(pub), base64.StdEncoding.EncodeToString(signature)
+}
+
+// VerifyDHKey checks that dhKey is an X25519 key signed by identityKey.
+func VerifyDHKey(identityKey, dhKey, sig string) error {
+	identity, err := base64.StdEncoding.DecodeString(identityKey)
+	if err != nil || len(identity) != ed25519.PublicKeySize {
+		return ErrBadDHKey
+	}
+	pub, err := base64.StdEncoding.DecodeString(dhKey)
+	if err != nil || len(pub) != 32 {
+		return ErrBadDHKey
+	}
+	signature, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil || !ed25519.Verify(identity, append([]byte(dhKeyContext), pub...), signature) {
+		return ErrBadDHKey
+	}
+	return nil
+}
+
+// SealDirect encrypts text to the Base64 X25519 key dhKey. Check the key
+// with VerifyDHKey first.
+func SealDirect(dhKey, text string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(dhKey)
+	if err != nil {
+		return "", ErrBadDHKey
+	}
+	recipient, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return "", ErrBadDHKey
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	secret, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return "", err
+	}
+	aead, err := directAEAD(secret, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return "", err
+	}
+	kind, body := FrameText, []byte(text)
+	if compressed, ok := compress(text); ok {
+		kind, body = FrameDeflate, compressed
+	}
+	plaintext, err := frame(kind, body)
+	if err != nil {
+		return "", err
+	}
+
+	// header || ephemeral key || nonce || ciphertext || tag
+	ad := append(directSuite.header(), ephemeral.PublicKey().Bytes()...)
+	out := make([]byte, len(ad)+aead.NonceSize(), len(ad)+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	copy(out, ad)
+	nonce := out[len(ad):]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	out = aead.Seal(out, nonce, plaintext, ad)
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+// OpenDirect decrypts a direct message sealed to id.
+func (id *Identity) OpenDirect(sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if s, ok := parseHeader(data); !ok || s != directSuite {
+		return "", ErrNotDirect
+	}
+	adSize := headerSize + 32
+	if len(data) < adSize+chacha20poly1305.NonceSizeX {
+		return "", errors.New("direct message too short")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(data[headerSize:adSize])
+	if err != nil {
+		return "", err
+	}
+	private := id.dhPrivate()
+	secret, err := private.ECDH(ephemeral)
+	if err != nil {
+		return "", err
+	}
+	aead, err := directAEAD(secret, ephemeral, private.PublicKey())
+	if err != nil {
+		return "", err
+	}
+	nonce := data[adSize : adSize+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, data[adSize+aead.NonceSize():], data[:adSize])
+	if err != nil {
+		return "", err
+	}
+	if kind, body, ok := unframe(plaintext); ok {
+		switch kind {
+		case FrameText:
+			return string(body), nil
+		case FrameDeflate:
+			return inflate(body)
+		}
+	}
+	return "", errors.New("direct message: bad frame")
+}
+
+// directAEAD keys the cipher from the ECDH secret, salted with the
+// ephemeral and the recipient's public key.
+func directAEAD(secret []byte, ephemeral, recipient *ecdh.PublicKey) (cipher.AEAD, error) {
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+	var key [32]byte
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte("ttc-dm-v1")), key[:]); err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(key[:])
+}
+
+// IsDirect reports whether content is a sealed direct message.
+func IsDirect(content string) bool {
+	data, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return false
+	}
+	s, ok := parseHeader(data)
+	return ok && s == directSuite
+}
@@ -0,0 +1,137 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// ── Envelope format ───────────────────────────────────────────────────────────
+//
+//	byte 0   magic    0xE7
+//	byte 1   version  1
+//	byte 2   cipher   CipherID
+//	byte 3   kdf      KDFID
+//	byte 4   key id   which passphrase of the keyring
+//	…        nonce || ciphertext || tag
+//
+// The 5 header bytes are passed to the AEAD as additional data, so a header
+// cannot be swapped without failing authentication. Ciphertexts from before
+// the envelope (bare AES-GCM nonce || ciphertext) are still accepted.
+
+const (
+	envelopeMagic   = 0xE7
+	envelopeVersion = 1
+	headerSize      = 5
+)
+
+// CipherID names the AEAD used for a message.
+type CipherID byte
+
+const (
+	CipherAES256GCM         CipherID = 1
+	CipherXChaCha20Poly1305 CipherID = 2
+)
+
+// KDFID names how the 32-byte key is derived from a keyring passphrase.
+type KDFID byte
+
+const (
+	KDFSHA256     KDFID = 1 // SHA-256(passphrase) — the original scheme
+	KDFHKDFSHA256 KDFID = 2 // HKDF-SHA256 with a per-key-id info string
+	KDFArgon2id   KDFID = 3 // Argon2id, for passphrases typed by people
+	KDFX25519     KDFID = 4 // ephemeral X25519 + HKDF, direct messages (direct.go)
+)
+
+// Suite is the combination of cipher, KDF and key a message is sealed with.
+type Suite struct {
+	Cipher CipherID
+	KDF    KDFID
+	KeyID  byte
+}
+
+// LegacySuite describes ciphertexts that predate the envelope header.
+var LegacySuite = Suite{Cipher: CipherAES256GCM, KDF: KDFSHA256, KeyID: 0}
+
+func (s Suite) String() string {
+	return fmt.Sprintf("%s/%s/key%d", s.Cipher, s.KDF, s.KeyID)
+}
+
+func (c CipherID) String() string {
+	switch c {
+	case CipherAES256GCM:
+		return "aes-256-gcm"
+	case CipherXChaCha20Poly1305:
+		return "xchacha20-poly1305"
+	}
+	return fmt.Sprintf("cipher(%d)", byte(c))
+}
+
+func (k KDFID) String() string {
+	switch k {
+	case KDFSHA256:
+		return "sha256"
+	case KDFHKDFSHA256:
+		return "hkdf-sha256"
+	case KDFArgon2id:
+		return "argon2id"
+	case KDFX25519:
+		return "x25519-hkdf"
+	}
+	return fmt.Sprintf("kdf(%d)", byte(k))
+}
+
+func (s Suite) header() []byte {
+	return []byte{envelopeMagic, envelopeVersion, byte(s.Cipher), byte(s.KDF), s.KeyID}
+}
+
+// parseHeader reads the envelope header, if data has one.
+func parseHeader(data []byte) (Suite, bool) {
+	if len(data) < headerSize || data[0] != envelopeMagic || data[1] != envelopeVersion {
+		return Suite{}, false
+	}
+	return Suite{Cipher: CipherID(data[2]), KDF: KDFID(data[3]), KeyID: data[4]}, true
+}
+
+// deriveKey turns a passphrase into a 32-byte key with the given KDF. salt
+// is only used by Argon2id; nil means the built-in salt for keyID.
+func deriveKey(kdf KDFID, keyID byte, passphrase string, salt []byte) ([32]byte, error) {
+	var key [32]byte
+	switch kdf {
+	case KDFSHA256:
+		key = sha256.Sum256([]byte(passphrase))
+	case KDFHKDFSHA256:
+		r := hkdf.New(sha256.New, []byte(passphrase), nil, []byte(fmt.Sprintf("ttc-envelope-v1 key %d", keyID)))
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return key, err
+		}
+	case KDFArgon2id:
+		if salt == nil {
+			salt = defaultSalt(keyID)
+		}
+		key = DeriveKey(passphrase, salt)
+	default:
+		return key, fmt.Errorf("unknown kdf %d", byte(kdf))
+	}
+	return key, nil
+}
+
+// newAEAD builds the cipher named by id around key.
+func newAEAD(id CipherID, key [32]byte) (cipher.AEAD, error) {
+	switch id {
+	case CipherAES256GCM:
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key[:])
+	}
+	return nil, fmt.Errorf("unknown cipher %d", byte(id))
+}
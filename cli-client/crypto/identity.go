@@ -0,0 +1,108 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ── Identity key ──────────────────────────────────────────────────────────────
+//
+// Every client has an Ed25519 identity key, created on first start and kept
+// in identity.key next to config.json (mode 0600, Base64 seed). The public
+// half is published to the relay at login; other clients pin it on first
+// use and warn when it changes (see the trust package).
+
+// Identity is the client's long-term key pair.
+type Identity struct {
+	private ed25519.PrivateKey
+}
+
+// LoadOrCreateIdentity reads the identity key at path, creating a new one
+// if the file does not exist yet.
+func LoadOrCreateIdentity(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return createIdentity(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s: not an identity key", path)
+	}
+	return &Identity{private: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+func createIdentity(path string) (*Identity, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	seed := base64.StdEncoding.EncodeToString(private.Seed()) + "\n"
+	// O_EXCL: never overwrite a key another client instance just created.
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.WriteString(seed); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	return &Identity{private: private}, nil
+}
+
+// PublicKey returns the public key, Base64 — the form the relay stores.
+func (id *Identity) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(id.private.Public().(ed25519.PublicKey))
+}
+
+// ── Message signatures ────────────────────────────────────────────────────────
+//
+// Every message is signed with the sender's identity key, so the relay — or
+// anyone who can POST to it — cannot put words in another user's mouth. The
+// signature covers the username, the room, the direct-message recipient and
+// the content exactly as sent (ciphertext for encrypted messages):
+//
+//	"ttc-msg-v1" 0 username 0 room 0 to 0 content
+//
+// room is "" for direct messages, which are not tied to a room.
+
+const messageContext = "ttc-msg-v1"
+
+var ErrBadSignature = errors.New("message signature does not match the sender's identity key")
+
+func signedMessage(username, room, to, content string) []byte {
+	return []byte(messageContext + "\x00" + username + "\x00" + room + "\x00" + to + "\x00" + content)
+}
+
+// SignMessage returns the identity key's signature over a message, Base64.
+func (id *Identity) SignMessage(username, room, to, content string) string {
+	sig := ed25519.Sign(id.private, signedMessage(username, room, to, content))
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// VerifyMessage checks sig, made by SignMessage, against identityKey.
+func VerifyMessage(identityKey, username, room, to, content, sig string) error {
+	key, err := base64.StdEncoding.DecodeString(identityKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return ErrBadSignature
+	}
+	signature, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil || !ed25519.Verify(key, signedMessage(username, room, to, content), signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
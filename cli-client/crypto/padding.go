@@ -0,0 +1,140 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+)
+
+// ── Padded frames (privacy mode) ──────────────────────────────────────────────
+//
+// In privacy mode the plaintext handed to the AEAD is a frame rather than the
+// bare message text:
+//
+//	byte 0     0x00 — never the first byte of a chat message
+//	byte 1     FrameKind
+//	byte 2-3   body length, big endian
+//	…          body, then zero bytes up to the bucket size
+//
+// Every frame is padded to one of PadBuckets (or a multiple of the largest),
+// so the ciphertext length only tells an observer which bucket a message fell
+// into. Decoy frames carry random bytes and are dropped after decryption.
+
+// FrameKind tells a real message from a decoy.
+type FrameKind byte
+
+const (
+	FrameText  FrameKind = 1
+	FrameDecoy FrameKind = 2
+)
+
+const frameHeaderSize = 4
+
+// PadBuckets are the frame sizes in bytes messages are padded up to.
+var PadBuckets = []int{256, 1024, 4096}
+
+// bucketSize returns the padded frame size for a body of n bytes.
+func bucketSize(n int) int {
+	need := frameHeaderSize + n
+	for _, b := range PadBuckets {
+		if need <= b {
+			return b
+		}
+	}
+	largest := PadBuckets[len(PadBuckets)-1]
+	return (need + largest - 1) / largest * largest
+}
+
+func frame(kind FrameKind, body []byte) ([]byte, error) {
+	if len(body) > 0xFFFF {
+		return nil, errors.New("message too long to pad")
+	}
+	out := make([]byte, bucketSize(len(body)))
+	out[1] = byte(kind)
+	binary.BigEndian.PutUint16(out[2:4], uint16(len(body)))
+	copy(out[frameHeaderSize:], body)
+	return out, nil
+}
+
+// unframe returns the kind and body of a padded frame, or ok=false if
+// plaintext is an ordinary unpadded message.
+func unframe(plaintext []byte) (kind FrameKind, body []byte, ok bool) {
+	if len(plaintext) < frameHeaderSize || plaintext[0] != 0 {
+		return 0, nil, false
+	}
+	n := int(binary.BigEndian.Uint16(plaintext[2:4]))
+	if frameHeaderSize+n > len(plaintext) {
+		return 0, nil, false
+	}
+	return FrameKind(plaintext[1]), plaintext[frameHeaderSize : frameHeaderSize+n], true
+}
+
+// EncryptPadded seals text as a padded FrameText, or as a padded
+// FrameDeflate when it is long enough to compress (see compress.go).
+func (gc *GlobalCrypto) EncryptPadded(text string) (string, error) {
+	kind, body := FrameText, []byte(text)
+	if compressed, ok := compress(text); ok {
+		kind, body = FrameDeflate, compressed
+	}
+	f, err := frame(kind, body)
+	if err != nil {
+		return "", err
+	}
+	return gc.Encrypt(f)
+}
+
+// EncryptDecoy seals a decoy frame whose random body length falls into one
+// of the smaller buckets, like typical chat lines do.
+func (gc *GlobalCrypto) EncryptDecoy() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(int64(PadBuckets[1]-frameHeaderSize)))
+	if err != nil {
+		return "", err
+	}
+	body := make([]byte, n.Int64())
+	if _, err := io.ReadFull(rand.Reader, body); err != nil {
+		return "", err
+	}
+	f, err := frame(FrameDecoy, body)
+	if err != nil {
+		return "", err
+	}
+	return gc.Encrypt(f)
+}
+
+// OpenMessage decrypts a message, strips its padding and decompresses it.
+// decoy is true for decoy frames, which the caller should drop. Ciphertexts
+// without a frame are returned as they are.
+func (gc *GlobalCrypto) OpenMessage(encrypted string) (text string, decoy bool, err error) {
+	plaintext, err := gc.Decrypt(encrypted)
+	if err != nil {
+		return "", false, err
+	}
+	kind, body, ok := unframe(plaintext)
+	if !ok {
+		return string(plaintext), false, nil
+	}
+	switch kind {
+	case FrameText:
+		return string(body), false, nil
+	case FrameDeflate:
+		text, err := inflate(body)
+		return text, false, err
+	case FrameDecoy:
+		return "", true, nil
+	}
+	return "", false, errors.New("unknown frame kind")
+}
+
+// HasEnvelope reports whether content looks like a message sealed by
+// Encrypt — Base64 carrying the envelope header — as opposed to plain text.
+func HasEnvelope(content string) bool {
+	data, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return false
+	}
+	_, ok := parseHeader(data)
+	return ok
+}
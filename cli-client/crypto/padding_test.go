@@ -0,0 +1,116 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBucketSize(t *testing.T) {
+	for _, tc := range []struct{ body, want int }{
+		{0, 256},
+		{256 - frameHeaderSize, 256},
+		{256 - frameHeaderSize + 1, 1024},
+		{4096 - frameHeaderSize, 4096},
+		{4096 - frameHeaderSize + 1, 8192},
+		{0xFFFF, 69632},
+	} {
+		if got := bucketSize(tc.body); got != tc.want {
+			t.Errorf("bucketSize(%d) = %d, want %d", tc.body, got, tc.want)
+		}
+	}
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 252, 253, 5000, 0xFFFF} {
+		body := bytes.Repeat([]byte{'x'}, n)
+		f, err := frame(FrameText, body)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if len(f) != bucketSize(n) {
+			t.Errorf("%d bytes: frame is %d, want %d", n, len(f), bucketSize(n))
+		}
+		kind, got, ok := unframe(f)
+		if !ok || kind != FrameText || !bytes.Equal(got, body) {
+			t.Errorf("%d bytes: unframe = %v, %d bytes, %v", n, kind, len(got), ok)
+		}
+	}
+}
+
+func TestFrameTooLong(t *testing.T) {
+	if _, err := frame(FrameText, make([]byte, 0x10000)); err == nil {
+		t.Error("no error for a body over 64 KiB")
+	}
+}
+
+func TestUnframeMalformed(t *testing.T) {
+	for name, plaintext := range map[string][]byte{
+		"empty":           {},
+		"short header":    {0, byte(FrameText), 0},
+		"plain text":      []byte("hello, world"),
+		"length too long": {0, byte(FrameText), 0, 5, 'a', 'b'},
+	} {
+		if _, _, ok := unframe(plaintext); ok {
+			t.Errorf("%s: taken for a frame", name)
+		}
+	}
+}
+
+func TestEncryptPaddedRoundTrip(t *testing.T) {
+	gc := NewGlobalCrypto()
+	for _, text := range []string{"", "hi", string(bytes.Repeat([]byte{'y'}, 3000)), logText(20000)} {
+		sealed, err := gc.EncryptPadded(text)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", len(text), err)
+		}
+		got, decoy, err := gc.OpenMessage(sealed)
+		if err != nil {
+			t.Fatalf("%d bytes: open: %v", len(text), err)
+		}
+		if decoy || got != text {
+			t.Errorf("%d bytes: got %d bytes, decoy=%v", len(text), len(got), decoy)
+		}
+	}
+}
+
+func TestEncryptPaddedHidesLength(t *testing.T) {
+	gc := NewGlobalCrypto()
+	short, err := gc.EncryptPadded("ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	longer, err := gc.EncryptPadded("see you at the stand-up tomorrow, same room as last week")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(short) != len(longer) {
+		t.Errorf("same bucket, different lengths: %d and %d", len(short), len(longer))
+	}
+}
+
+func TestEncryptDecoy(t *testing.T) {
+	gc := NewGlobalCrypto()
+	sealed, err := gc.EncryptDecoy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, decoy, err := gc.OpenMessage(sealed)
+	if err != nil || !decoy || text != "" {
+		t.Errorf("OpenMessage = %q, %v, %v", text, decoy, err)
+	}
+}
+
+func TestOpenMessageBadFrames(t *testing.T) {
+	gc := NewGlobalCrypto()
+	unknown, _ := frame(FrameKind(9), []byte("?"))
+	corrupt, _ := frame(FrameDeflate, []byte{0xff, 0xff, 0xff})
+	for name, plaintext := range map[string][]byte{"unknown kind": unknown, "corrupt deflate": corrupt} {
+		sealed, err := gc.Encrypt(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := gc.OpenMessage(sealed); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// ── Room passphrase ───────────────────────────────────────────────────────────
+//
+// Users who agree on a passphrase get their own key instead of the one built
+// into every client. The key is derived with Argon2id so a captured
+// ciphertext can't be brute-forced cheaply against a passphrase list.
+//
+// Everyone in the room must derive the same key from the same passphrase,
+// so the salt can't be random per client: it is shared like the passphrase
+// ("passphrase_salt" in config.json, or the relay's MOTD), falling back to a
+// fixed per-key-id string. A shared salt still stops precomputed tables —
+// an attacker has to redo the work for every salt. The parameters below are
+// part of the format; changing them needs a new KDFID.
+
+// PassphraseKeyID is the keyring slot for the passphrase entered at login.
+const PassphraseKeyID byte = 1
+
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
+)
+
+// DeriveKey stretches passphrase into a 32-byte key with Argon2id. It is
+// slow on purpose (64 MB, a fraction of a second). A nil salt uses the
+// built-in one for the passphrase key id.
+func DeriveKey(passphrase string, salt []byte) [32]byte {
+	if salt == nil {
+		salt = defaultSalt(PassphraseKeyID)
+	}
+	var key [32]byte
+	copy(key[:], argon2.IDKey([]byte(passphrase), salt, argonTime, argonMemory, argonThreads, 32))
+	return key
+}
+
+func defaultSalt(keyID byte) []byte {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("ttc-argon2id-v1 key %d", keyID)))
+	return sum[:16]
+}
+
+// UsePassphrase makes passphrase the key for new messages, derived with
+// DeriveKey under salt (nil = built-in). Call it off the event loop.
+// Messages under the built-in key can still be decrypted.
+func (gc *GlobalCrypto) UsePassphrase(passphrase string, salt []byte) error {
+	if passphrase == "" {
+		return fmt.Errorf("empty passphrase")
+	}
+	suite := Suite{Cipher: CipherAES256GCM, KDF: KDFArgon2id, KeyID: PassphraseKeyID}
+	gc.mu.Lock()
+	gc.salt = salt
+	gc.mu.Unlock()
+	gc.AddKey(PassphraseKeyID, passphrase)
+	// Derive now, so the first message doesn't pay for it.
+	if _, err := gc.derivedKey(suite); err != nil {
+		return err
+	}
+	return gc.SetSuite(suite)
+}
+
+// UsesBuiltinKey reports whether new messages are sealed under the key built
+// into every client, i.e. no passphrase was set.
+func (gc *GlobalCrypto) UsesBuiltinKey() bool {
+	return gc.Suite().KeyID == 0
+}
+
+// Fingerprint returns a short hash of the key new messages are sealed with,
+// e.g. "3f9a-0c2e-71d8". Two users who see the same fingerprint share the
+// same secret; the key itself can't be recovered from it.
+func (gc *GlobalCrypto) Fingerprint() string {
+	key, err := gc.derivedKey(gc.Suite())
+	if err != nil {
+		return "?"
+	}
+	sum := sha256.Sum256(append([]byte("ttc-key-fingerprint-v1"), key[:]...))
+	h := hex.EncodeToString(sum[:6])
+	return h[0:4] + "-" + h[4:8] + "-" + h[8:12]
+}
@@ -1,237 +1,406 @@
-package main
-
-import (
-	"fmt"
-	"log"
-	"os"
-	"runtime/debug"
-	"time"
-
-	"cli-client/controllers"
-	"cli-client/models"
-	"cli-client/views"
-
-	"github.com/rivo/tview"
-)
-
-var logFile *os.File
-
-func init() {
-	var err error
-	// Open with append+create so multiple runs accumulate — easier to correlate
-	// a crash with the session that produced it.
-	logFile, err = os.OpenFile("error.txt",
-		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		fmt.Println("Failed to open error log file:", err)
-		return
-	}
-
-	// Also wire up the standard logger to the same file.
-	log.SetOutput(&syncWriter{f: logFile})
-
-	// Give chat_view.go access to the file handle so it can flush before
-	// every tview SetText call — ensures traces are on disk even on hard crashes.
-	views.DebugLogFile = logFile
-	// syncWriter flushes to disk on every write — no log line lost on hard crash
-	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
-
-	// Write a session header so different runs are clearly separated in the file.
-	separator := fmt.Sprintf(
-		"\n════════════════════════════════════════════════════════\n"+
-			"  TTC session started  %s\n"+
-			"════════════════════════════════════════════════════════\n",
-		time.Now().Format("2006-01-02 15:04:05"),
-	)
-	logFile.WriteString(separator)
-	logFile.Sync()
-}
-
-// logError writes a timestamped error line to error.txt and stderr.
-// syncWriter wraps an *os.File and calls Sync() after every Write so that
-// log lines are guaranteed to be on disk even if the process is hard-killed.
-type syncWriter struct{ f *os.File }
-
-func (w *syncWriter) Write(p []byte) (n int, err error) {
-	n, err = w.f.Write(p)
-	w.f.Sync() // flush OS page cache → disk on every log line
-	return
-}
-
-func logError(format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	line := fmt.Sprintf("[%s] ERROR: %s\n",
-		time.Now().Format("2006-01-02 15:04:05.000"), msg)
-	if logFile != nil {
-		logFile.WriteString(line)
-		logFile.Sync()
-	}
-}
-
-// recoverFromPanic is called via defer in goroutines. It catches software
-// panics (interface conversion, nil dereference that reached user code, etc.)
-// and writes the full stack trace to error.txt before re-returning.
-// Fatal runtime errors (concurrent map writes, etc.) are NOT caught here —
-// they are captured by the stderr redirect set up in init().
-func recoverFromPanic() {
-	if r := recover(); r != nil {
-		entry := fmt.Sprintf(
-			"[%s] PANIC RECOVERED: %v\n--- stack trace ---\n%s-------------------\n",
-			time.Now().Format("2006-01-02 15:04:05.000"),
-			r,
-			string(debug.Stack()),
-		)
-		if logFile != nil {
-			logFile.WriteString(entry)
-			logFile.Sync()
-		}
-	}
-}
-
-func main() {
-	defer func() {
-		if r := recover(); r != nil {
-			entry := fmt.Sprintf(
-				"[%s] FATAL PANIC in main: %v\n--- stack trace ---\n%s-------------------\n",
-				time.Now().Format("2006-01-02 15:04:05.000"),
-				r,
-				string(debug.Stack()),
-			)
-			if logFile != nil {
-				logFile.WriteString(entry)
-				logFile.Sync()
-				logFile.Close()
-			}
-			os.Exit(1)
-		}
-	}()
-
-	app := tview.NewApplication()
-	pages := tview.NewPages()
-
-	ctrl := controllers.NewAppController(app)
-
-	loadingView := views.NewLoadingView(app)
-	loginView := views.NewLoginView(app, ctrl.OnLoginSubmit)
-	chatView := views.NewChatView(
-		app,
-		ctrl.OnSendMessage,
-		ctrl.OnCommand,
-	)
-
-	ctrl.RegisterView(models.ScreenLoading, loadingView)
-	ctrl.RegisterView(models.ScreenLogin, loginView)
-	ctrl.RegisterView(models.ScreenChat, chatView)
-
-	pages.AddPage("loading", loadingView.GetPrimitive(), true, true)
-	pages.AddPage("login", loginView.Primitive(), true, false)
-	pages.AddPage("chat", chatView.Primitive(), true, false)
-
-	// ── LOADING ───────────────────────────────────────────────────────────────
-	ctrl.SM.OnEnter(models.ScreenLoading, func() {
-		defer recoverFromPanic()
-		pages.SwitchToPage("loading")
-
-		go func() {
-			defer recoverFromPanic()
-
-			steps := []struct {
-				progress int
-				label    string
-			}{
-				{10, "Initializing…"},
-				{20, "Loading modules…"},
-				{40, "Preparing encryption…"},
-				{60, "Checking configuration…"},
-				{80, "Contacting relay server…"},
-				{90, "Verifying connection…"},
-				{100, ""},
-			}
-			for _, s := range steps {
-				time.Sleep(140 * time.Millisecond)
-				loadingView.UpdateProgress(s.progress)
-				if s.label != "" {
-					loadingView.SetStatus(s.label)
-				}
-			}
-
-			loadingView.SetStatus("Contacting relay server…")
-			connErr := controllers.CheckServerConnectivity(controllers.DefaultServerURL)
-
-			if connErr != nil {
-				logError("Server connectivity check failed: %v", connErr)
-				app.QueueUpdateDraw(func() {
-					defer recoverFromPanic()
-					loadingView.ShowFatalError(
-						fmt.Sprintf("Server not reachable — %s", controllers.DefaultServerURL),
-					)
-					loadingView.SetCountdown(4)
-				})
-
-				for i := 3; i >= 0; i-- {
-					time.Sleep(1 * time.Second)
-					remaining := i
-					app.QueueUpdateDraw(func() {
-						defer recoverFromPanic()
-						loadingView.SetCountdown(remaining)
-					})
-				}
-
-				time.Sleep(200 * time.Millisecond)
-				app.Stop()
-				return
-			}
-
-			log.Printf("Server reachable at %s", controllers.DefaultServerURL)
-			loadingView.SetStatus("Connected  ✓")
-			time.Sleep(300 * time.Millisecond)
-
-			app.QueueUpdateDraw(func() {
-				defer recoverFromPanic()
-				ctrl.SM.Transition(models.ScreenLogin)
-			})
-		}()
-	})
-
-	// ── LOGIN ─────────────────────────────────────────────────────────────────
-	ctrl.SM.OnEnter(models.ScreenLogin, func() {
-		defer recoverFromPanic()
-		pages.SwitchToPage("login")
-		loginView.StartUsernamePrompt()
-		app.SetFocus(loginView.Primitive())
-	})
-
-	// ── CHAT ──────────────────────────────────────────────────────────────────
-	ctrl.SM.OnEnter(models.ScreenChat, func() {
-		defer recoverFromPanic()
-		pages.SwitchToPage("chat")
-		app.SetFocus(chatView.InputPrimitive())
-	})
-
-	// ── CHAT EXIT ─────────────────────────────────────────────────────────────
-	ctrl.SM.OnExit(models.ScreenChat, func() {
-		defer recoverFromPanic()
-		ctrl.StopBot()
-		if chat, ok := ctrl.Views[models.ScreenChat].(*views.ChatView); ok {
-			chat.Stop()
-		}
-	})
-
-	go func() {
-		defer recoverFromPanic()
-		time.Sleep(100 * time.Millisecond)
-		app.QueueUpdateDraw(func() {
-			defer recoverFromPanic()
-			ctrl.SM.Transition(models.ScreenLoading)
-		})
-	}()
-
-	if err := app.SetRoot(pages, true).Run(); err != nil {
-		logError("Application error: %v", err)
-	}
-
-	log.Printf("Application exited cleanly")
-	if logFile != nil {
-		logFile.Close()
-	}
-}
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+	"time"
+
+	"cli-client/controllers"
+	"cli-client/models"
+	"cli-client/views"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+var logFile *os.File
+
+func init() {
+	var err error
+	// Open with append+create so multiple runs accumulate — easier to correlate
+	// a crash with the session that produced it.
+	logFile, err = os.OpenFile("error.txt",
+		os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Println("Failed to open error log file:", err)
+		return
+	}
+
+	// Also wire up the standard logger to the same file.
+	log.SetOutput(&syncWriter{f: logFile})
+
+	// Give chat_view.go access to the file handle so it can flush before
+	// every tview SetText call — ensures traces are on disk even on hard crashes.
+	views.DebugLogFile = logFile
+	// syncWriter flushes to disk on every write — no log line lost on hard crash
+	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds | log.Lshortfile)
+
+	// Write a session header so different runs are clearly separated in the file.
+	separator := fmt.Sprintf(
+		"\n════════════════════════════════════════════════════════\n"+
+			"  TTC session started  %s\n"+
+			"════════════════════════════════════════════════════════\n",
+		time.Now().Format("2006-01-02 15:04:05"),
+	)
+	logFile.WriteString(separator)
+	logFile.Sync()
+}
+
+// runMonitor runs --monitor instead of the chat until q or Esc.
+func runMonitor(proxy, room string) {
+	app := tview.NewApplication()
+	view := views.NewMonitorView(app, app.Stop)
+	mon := controllers.NewMonitor(app, view, room)
+	mon.LoadConfig()
+	if proxy != "" {
+		if err := controllers.SetProxy(proxy); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	mon.Start()
+	if err := app.SetRoot(view.Primitive(), true).Run(); err != nil {
+		logError("Application error: %v", err)
+	}
+	mon.Stop()
+	log.Printf("Monitor exited cleanly")
+}
+
+// runPipe runs "tail" or "pipe" (see controllers/pipe.go) with the
+// arguments after it and returns the exit code.
+func runPipe(mode string, args []string) int {
+	fs := flag.NewFlagSet(mode, flag.ExitOnError)
+	user := fs.String("user", "", "Username to log in as; the password is $"+controllers.PipePasswordEnv)
+	room := fs.String("room", "", "Room to read and send to (default: lobby)")
+	proxy := fs.String("proxy", "", "Proxy for relay traffic, as for the chat (default: \"proxy\" in config.json, then HTTPS_PROXY)")
+	encoding := fs.String("encoding", controllers.EncodingJSON, "Wire format, as for the chat: json or msgpack")
+	register := fs.Bool("register", false, "Register --user if the relay refuses the login")
+	fs.Parse(args)
+
+	p := controllers.NewPipe(*user, *room, mode == "pipe", os.Stdout, os.Stderr)
+	p.LoadConfig()
+	p.SetRegister(*register)
+	if err := p.SetEncoding(*encoding); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *proxy != "" {
+		if err := controllers.SetProxy(*proxy); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := p.Run(ctx, os.Stdin); err != nil {
+		fmt.Fprintln(os.Stderr, "ttc:", err)
+		return 1
+	}
+	return 0
+}
+
+// runBots runs "bots" (see controllers/bots.go) with the arguments after
+// it and returns the exit code.
+func runBots(args []string) int {
+	fs := flag.NewFlagSet("bots", flag.ExitOnError)
+	file := fs.String("file", "", "Bot definitions (default: bots.json next to config.json)")
+	proxy := fs.String("proxy", "", "Proxy for relay traffic, as for the chat (default: \"proxy\" in config.json, then HTTPS_PROXY)")
+	encoding := fs.String("encoding", controllers.EncodingJSON, "Wire format, as for the chat: json or msgpack")
+	register := fs.Bool("register", false, "Register a bot's user if the relay refuses its login")
+	fs.Parse(args)
+
+	bots, err := controllers.LoadBots(*file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ttc:", err)
+		return 2
+	}
+	r := controllers.NewBotRunner(os.Stderr)
+	r.LoadConfig()
+	r.SetRegister(*register)
+	if err := r.SetEncoding(*encoding); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *proxy != "" {
+		if err := controllers.SetProxy(*proxy); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+	r.Add(bots...)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := r.Run(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "ttc:", err)
+		return 1
+	}
+	return 0
+}
+
+// logError writes a timestamped error line to error.txt and stderr.
+// syncWriter wraps an *os.File and calls Sync() after every Write so that
+// log lines are guaranteed to be on disk even if the process is hard-killed.
+type syncWriter struct{ f *os.File }
+
+func (w *syncWriter) Write(p []byte) (n int, err error) {
+	n, err = w.f.Write(p)
+	w.f.Sync() // flush OS page cache → disk on every log line
+	return
+}
+
+func logError(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	line := fmt.Sprintf("[%s] ERROR: %s\n",
+		time.Now().Format("2006-01-02 15:04:05.000"), msg)
+	if logFile != nil {
+		logFile.WriteString(line)
+		logFile.Sync()
+	}
+}
+
+// recoverFromPanic is called via defer in goroutines. It catches software
+// panics (interface conversion, nil dereference that reached user code, etc.)
+// and writes the full stack trace to error.txt before re-returning.
+// Fatal runtime errors (concurrent map writes, etc.) are NOT caught here —
+// they are captured by the stderr redirect set up in init().
+func recoverFromPanic() {
+	if r := recover(); r != nil {
+		entry := fmt.Sprintf(
+			"[%s] PANIC RECOVERED: %v\n--- stack trace ---\n%s-------------------\n",
+			time.Now().Format("2006-01-02 15:04:05.000"),
+			r,
+			string(debug.Stack()),
+		)
+		if logFile != nil {
+			logFile.WriteString(entry)
+			logFile.Sync()
+		}
+	}
+}
+
+func main() {
+	defer func() {
+		if r := recover(); r != nil {
+			entry := fmt.Sprintf(
+				"[%s] FATAL PANIC in main: %v\n--- stack trace ---\n%s-------------------\n",
+				time.Now().Format("2006-01-02 15:04:05.000"),
+				r,
+				string(debug.Stack()),
+			)
+			if logFile != nil {
+				logFile.WriteString(entry)
+				logFile.Sync()
+				logFile.Close()
+			}
+			os.Exit(1)
+		}
+	}()
+
+	// tail, pipe and bots are for scripts: no screen.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "tail", "pipe":
+			os.Exit(runPipe(os.Args[1], os.Args[2:]))
+		case "bots":
+			os.Exit(runBots(os.Args[2:]))
+		}
+	}
+
+	proxy := flag.String("proxy", "", "Proxy for relay traffic, e.g. socks5://127.0.0.1:9050 or http://proxy:3128 (default: \"proxy\" in config.json, then HTTPS_PROXY)")
+	transport := flag.String("transport", controllers.TransportPoll, "How new messages arrive: poll (long polling), sse (Server-Sent Events) or ws (WebSocket)")
+	encoding := flag.String("encoding", controllers.EncodingJSON, "Wire format of sends, polls and history: json or msgpack (MessagePack, smaller and faster to parse; falls back to json on older relays)")
+	safeMode := flag.Bool("safe-mode", false, "Start with the built-in look and no scripts, ignoring config.json's theme, highlights, prefix, snippets and display settings, to recover from a broken config")
+	monitor := flag.Bool("monitor", false, "No chat: a live dashboard of the relay's stats, one room's message rate and its events, read as an observer")
+	monitorRoom := flag.String("monitor-room", "", "Room --monitor watches (default: the observer token's room, else lobby)")
+	flag.Parse()
+
+	if *monitor {
+		runMonitor(*proxy, *monitorRoom)
+		return
+	}
+
+	app := tview.NewApplication()
+	pages := tview.NewPages()
+
+	ctrl := controllers.NewAppController(app)
+
+	loadingView := views.NewLoadingView(app)
+	loginView := views.NewLoginView(app, ctrl.OnLoginSubmit)
+	chatView := views.NewChatView(
+		app,
+		ctrl.OnSendMessage,
+		ctrl.OnCommand,
+		ctrl.OnTyping,
+	)
+
+	ctrl.RegisterView(models.ScreenLoading, loadingView)
+	ctrl.RegisterView(models.ScreenLogin, loginView)
+	ctrl.RegisterView(models.ScreenChat, chatView)
+	if *safeMode {
+		ctrl.SetSafeMode()
+	}
+	ctrl.LoadConfig()
+	ctrl.RestoreSession()
+	ctrl.LoadInputHistory()
+	if *proxy != "" {
+		if err := controllers.SetProxy(*proxy); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	if err := ctrl.SetTransport(*transport); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if err := ctrl.SetEncoding(*encoding); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	ctrl.SetLogFile(logFile)
+
+	pages.AddPage("loading", loadingView.GetPrimitive(), true, true)
+	pages.AddPage("login", loginView.Primitive(), true, false)
+	pages.AddPage("chat", chatView.Primitive(), true, false)
+
+	// ── LOADING ───────────────────────────────────────────────────────────────
+	ctrl.SM.OnEnter(models.ScreenLoading, func() {
+		defer recoverFromPanic()
+		pages.SwitchToPage("loading")
+
+		go func() {
+			defer recoverFromPanic()
+
+			steps := []struct {
+				progress int
+				label    string
+			}{
+				{10, "Initializing…"},
+				{20, "Loading modules…"},
+				{40, "Preparing encryption…"},
+				{60, "Checking configuration…"},
+				{80, "Contacting relay server…"},
+				{90, "Verifying connection…"},
+				{100, ""},
+			}
+			for _, s := range steps {
+				time.Sleep(140 * time.Millisecond)
+				loadingView.UpdateProgress(s.progress)
+				if s.label != "" {
+					loadingView.SetStatus(s.label)
+				}
+			}
+
+			loadingView.SetStatus("Contacting relay server…")
+			connErr := controllers.CheckServerConnectivity(controllers.DefaultServerURL)
+
+			if connErr != nil {
+				logError("Server connectivity check failed: %v", connErr)
+				reason := fmt.Sprintf("Server not reachable — %s", controllers.DefaultServerURL)
+				if errors.Is(connErr, controllers.ErrPinMismatch) {
+					reason = fmt.Sprintf("⚠ %s: certificate does not match pin_sha256 — refusing to connect", controllers.DefaultServerURL)
+				} else if errors.Is(connErr, controllers.ErrBadProxy) {
+					reason = "⚠ proxy setting is invalid — refusing to connect (see --proxy and \"proxy\" in config.json)"
+				} else if proxy := controllers.ActiveProxy(); proxy != "" {
+					reason = fmt.Sprintf("Server not reachable — %s via proxy %s", controllers.DefaultServerURL, proxy)
+				}
+				app.QueueUpdateDraw(func() {
+					defer recoverFromPanic()
+					loadingView.ShowFatalError(reason)
+					loadingView.SetCountdown(4)
+				})
+
+				for i := 3; i >= 0; i-- {
+					time.Sleep(1 * time.Second)
+					remaining := i
+					app.QueueUpdateDraw(func() {
+						defer recoverFromPanic()
+						loadingView.SetCountdown(remaining)
+					})
+				}
+
+				time.Sleep(200 * time.Millisecond)
+				app.Stop()
+				return
+			}
+
+			log.Printf("Server reachable at %s", controllers.DefaultServerURL)
+			ctrl.DetectLogin()
+			loadingView.SetStatus("Connected  ✓")
+			time.Sleep(300 * time.Millisecond)
+
+			app.QueueUpdateDraw(func() {
+				defer recoverFromPanic()
+				ctrl.SM.Transition(models.ScreenLogin)
+			})
+		}()
+	})
+
+	// ── LOGIN ─────────────────────────────────────────────────────────────────
+	ctrl.SM.OnEnter(models.ScreenLogin, func() {
+		defer recoverFromPanic()
+		pages.SwitchToPage("login")
+		loginView.StartUsernamePrompt()
+		app.SetFocus(loginView.Primitive())
+	})
+
+	// ── CHAT ──────────────────────────────────────────────────────────────────
+	ctrl.SM.OnEnter(models.ScreenChat, func() {
+		defer recoverFromPanic()
+		pages.SwitchToPage("chat")
+		app.SetFocus(chatView.InputPrimitive())
+	})
+
+	// ── CHAT EXIT ─────────────────────────────────────────────────────────────
+	ctrl.SM.OnExit(models.ScreenChat, func() {
+		defer recoverFromPanic()
+		ctrl.StopBot()
+		if chat, ok := ctrl.Views[models.ScreenChat].(*views.ChatView); ok {
+			chat.Stop()
+		}
+	})
+
+	// Ctrl+Z suspends like any other terminal program; resuming (or any
+	// SIGCONT) forces a full redraw and reconnects — see signals_unix.go.
+	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyCtrlZ {
+			suspend(app)
+			return nil
+		}
+		return event
+	})
+	watchResume(app, ctrl)
+
+	go func() {
+		defer recoverFromPanic()
+		time.Sleep(100 * time.Millisecond)
+		app.QueueUpdateDraw(func() {
+			defer recoverFromPanic()
+			ctrl.SM.Transition(models.ScreenLoading)
+		})
+	}()
+
+	if err := app.SetRoot(pages, true).Run(); err != nil {
+		logError("Application error: %v", err)
+	}
+
+	ctrl.CloseStatusAPI()
+	ctrl.FlushDraft()
+	if ctrl.Wiped() {
+		// Clear the screen and the terminal's scrollback buffer too.
+		fmt.Print("\x1b[3J\x1b[H\x1b[2J")
+		return
+	}
+	ctrl.SaveSession()
+
+	log.Printf("Application exited cleanly")
+	if logFile != nil {
+		logFile.Close()
+	}
+}
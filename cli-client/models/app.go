@@ -1,78 +1,131 @@
-package models
-
-// AppState represents the overall application state
-type AppState struct {
-	CurrentUser *User
-	Messages    []*Message
-	Users       map[string]*User
-	UserColors  map[string]string // username → tview color tag override e.g. "[#ff00ff]"
-	Latency     int
-	IsConnected bool
-}
-
-// NewAppState creates a new application state
-func NewAppState() *AppState {
-	return &AppState{
-		CurrentUser: nil,
-		Messages:    make([]*Message, 0),
-		Users:       make(map[string]*User),
-		UserColors:  make(map[string]string),
-		Latency:     18,
-		IsConnected: true,
-	}
-}
-
-// AddMessage adds a message to the chat
-func (a *AppState) AddMessage(msg *Message) {
-	a.Messages = append(a.Messages, msg)
-}
-
-// GetMessages returns all messages
-func (a *AppState) GetMessages() []*Message {
-	return a.Messages
-}
-
-// SetCurrentUser sets the current user
-func (a *AppState) SetCurrentUser(username string) {
-	a.CurrentUser = NewUser(username)
-	a.Users[username] = a.CurrentUser
-}
-
-// GetUserColorTag returns the tview color tag for a user.
-// Checks the manual override map first; falls back to the hash-based default.
-func (a *AppState) GetUserColorTag(username string) string {
-	if tag, ok := a.UserColors[username]; ok {
-		return tag
-	}
-	return GetUsernameColor(username)
-}
-
-// SetUserColor stores a manual color override for a user.
-// colorTag must be a valid tview tag e.g. "[green]" or "[#ff00ff]".
-func (a *AppState) SetUserColor(username, colorTag string) {
-	a.UserColors[username] = colorTag
-	// Keep the User struct in sync if it exists
-	if u, ok := a.Users[username]; ok {
-		u.Color = colorTag
-	}
-	if a.CurrentUser != nil && a.CurrentUser.Username == username {
-		a.CurrentUser.Color = colorTag
-	}
-}
-
-// GetOnlineUsersCount returns the count of online users
-func (a *AppState) GetOnlineUsersCount() int {
-	count := 0
-	for _, u := range a.Users {
-		if u.IsOnline {
-			count++
-		}
-	}
-	if a.CurrentUser != nil {
-		count++
-	}
-	if count == 0 {
-		count = 1
-	}
-	return count
-}
+package models
+
+import "sort"
+
+// AppState represents the overall application state
+type AppState struct {
+	CurrentUser *User
+	Room        string // room sent with every send, poll and history call
+	Messages    []*Message
+	Users       map[string]*User
+	UserColors  map[string]string // username → tview color tag override e.g. "[#ff00ff]"
+	Latency     int
+	IsConnected bool
+	Session     *SessionStats // counters and diagnostics shown by /debug
+}
+
+// DefaultRoom is the room every client starts in.
+const DefaultRoom = "lobby"
+
+// NewAppState creates a new application state
+func NewAppState() *AppState {
+	return &AppState{
+		CurrentUser: nil,
+		Room:        DefaultRoom,
+		Messages:    make([]*Message, 0),
+		Users:       make(map[string]*User),
+		UserColors:  make(map[string]string),
+		Latency:     18,
+		IsConnected: true,
+		Session:     NewSessionStats(),
+	}
+}
+
+// AddMessage adds a message to the chat
+func (a *AppState) AddMessage(msg *Message) {
+	a.Messages = append(a.Messages, msg)
+}
+
+// PrependMessages puts older messages (history pages) in front of the ones
+// already held.
+func (a *AppState) PrependMessages(older []*Message) {
+	a.Messages = append(append(make([]*Message, 0, len(older)+len(a.Messages)), older...), a.Messages...)
+}
+
+// GetMessages returns all messages
+func (a *AppState) GetMessages() []*Message {
+	return a.Messages
+}
+
+// SetCurrentUser sets the current user
+func (a *AppState) SetCurrentUser(username string) {
+	a.CurrentUser = NewUser(username)
+	a.Users[username] = a.CurrentUser
+}
+
+// RenameCurrentUser gives the current user a new name, keeping their color.
+func (a *AppState) RenameCurrentUser(username string) {
+	old := a.CurrentUser.Username
+	a.RenameUser(old, username)
+	a.CurrentUser.Username = username
+	a.Users[username] = a.CurrentUser
+}
+
+// RenameUser moves what is known about from — the color override and the
+// presence entry — to to, after a nick change.
+func (a *AppState) RenameUser(from, to string) {
+	if tag, ok := a.UserColors[from]; ok {
+		a.UserColors[to] = tag
+		delete(a.UserColors, from)
+	}
+	if u, ok := a.Users[from]; ok {
+		delete(a.Users, from)
+		u.Username = to
+		a.Users[to] = u
+	}
+}
+
+// GetUserColorTag returns the tview color tag for a user.
+// Checks the manual override map first; falls back to the hash-based default.
+func (a *AppState) GetUserColorTag(username string) string {
+	if tag, ok := a.UserColors[username]; ok {
+		return tag
+	}
+	return GetUsernameColor(username)
+}
+
+// SetUserColor stores a manual color override for a user.
+// colorTag must be a valid tview tag e.g. "[green]" or "[#ff00ff]".
+func (a *AppState) SetUserColor(username, colorTag string) {
+	a.UserColors[username] = colorTag
+	// Keep the User struct in sync if it exists
+	if u, ok := a.Users[username]; ok {
+		u.Color = colorTag
+	}
+	if a.CurrentUser != nil && a.CurrentUser.Username == username {
+		a.CurrentUser.Color = colorTag
+	}
+}
+
+// SetPresence replaces the known users with the online list reported by the
+// server. Color overrides are re-applied and the current user is always kept.
+func (a *AppState) SetPresence(online []*User) {
+	users := make(map[string]*User, len(online)+1)
+	for _, u := range online {
+		if tag, ok := a.UserColors[u.Username]; ok {
+			u.Color = tag
+		}
+		users[u.Username] = u
+	}
+	if a.CurrentUser != nil {
+		users[a.CurrentUser.Username] = a.CurrentUser
+	}
+	a.Users = users
+}
+
+// OnlineUsers returns the online users sorted by username.
+func (a *AppState) OnlineUsers() []*User {
+	users := make([]*User, 0, len(a.Users))
+	for _, u := range a.Users {
+		if u.IsOnline {
+			users = append(users, u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users
+}
+
+// GetOnlineUsersCount returns the count of online users
+func (a *AppState) GetOnlineUsersCount() int {
+	return len(a.OnlineUsers())
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Attachment is a file shared with /send. The file itself waits on the
+// relay, sealed with the room key; the message announcing it carries its
+// ID, name and size as text, so clients without /get still show something
+// useful:
+//
+//	📎 report.pdf (1.2 MB) · /get file_3f9a0c2e71d84b5a9e06c1d7
+type Attachment struct {
+	ID     string // the relay's upload ID
+	Name   string
+	Size   string // as the sender wrote it, e.g. "1.2 MB"
+	Number int    // what /get calls it in this session, 0 if not numbered
+}
+
+var attachmentRe = regexp.MustCompile(`^📎 (.+) \(([^()]+)\) · /get (file_\w+)$`)
+
+// AttachmentText is the message content announcing a file.
+func AttachmentText(id, name, size string) string {
+	return fmt.Sprintf("📎 %s (%s) · /get %s", name, size, id)
+}
+
+// ParseAttachment returns the file content announces, or nil if it is an
+// ordinary message.
+func ParseAttachment(content string) *Attachment {
+	m := attachmentRe.FindStringSubmatch(content)
+	if m == nil {
+		return nil
+	}
+	return &Attachment{Name: m[1], Size: m[2], ID: m[3]}
+}
+
+// Label is the attachment as the chat shows it, "📎 report.pdf (1.2 MB) —
+// /get 3", or as sent while it has no number.
+func (a *Attachment) Label() string {
+	if a.Number == 0 {
+		return AttachmentText(a.ID, a.Name, a.Size)
+	}
+	return fmt.Sprintf("📎 %s (%s) — /get %d", a.Name, a.Size, a.Number)
+}
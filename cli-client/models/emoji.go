@@ -0,0 +1,145 @@
+package models
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// emojiShortcodes maps a shortcode, without its colons, to its emoji. Every
+// emoji here is a single code point drawn two columns wide by default, so
+// the terminal, tcell and tview all agree on its width. Emoji that need
+// U+FE0F to be drawn as emoji, like ❤️ and ⚠️, are left out: terminals
+// disagree on how wide they are.
+var emojiShortcodes = map[string]string{
+	// Faces
+	"smile": "😄", "grin": "😁", "joy": "😂", "rofl": "🤣", "smiley": "😃",
+	"slightly_smiling_face": "🙂", "upside_down_face": "🙃", "wink": "😉",
+	"blush": "😊", "innocent": "😇", "heart_eyes": "😍", "star_struck": "🤩",
+	"kissing_heart": "😘", "yum": "😋", "stuck_out_tongue": "😛",
+	"stuck_out_tongue_winking_eye": "😜", "zany_face": "🤪", "hugs": "🤗",
+	"thinking": "🤔", "shushing_face": "🤫", "zipper_mouth_face": "🤐",
+	"raised_eyebrow": "🤨", "neutral_face": "😐", "expressionless": "😑",
+	"no_mouth": "😶", "smirk": "😏", "unamused": "😒", "roll_eyes": "🙄",
+	"grimacing": "😬", "relieved": "😌", "pensive": "😔", "sleepy": "😪",
+	"sleeping": "😴", "mask": "😷", "nerd_face": "🤓", "sunglasses": "😎",
+	"confused": "😕", "worried": "😟", "open_mouth": "😮", "astonished": "😲",
+	"flushed": "😳", "pleading_face": "🥺", "cry": "😢", "sob": "😭",
+	"scream": "😱", "sweat": "😓", "weary": "😩", "tired_face": "😫",
+	"yawning_face": "🥱", "triumph": "😤", "rage": "😡", "angry": "😠",
+	"skull": "💀", "clown_face": "🤡", "ghost": "👻", "alien": "👽",
+	"robot": "🤖", "poop": "💩", "see_no_evil": "🙈", "exploding_head": "🤯",
+	"partying_face": "🥳", "face_palm": "🤦", "shrug": "🤷",
+
+	// Hands and people
+	"+1": "👍", "thumbsup": "👍", "-1": "👎", "thumbsdown": "👎", "ok_hand": "👌",
+	"wave": "👋", "clap": "👏", "raised_hands": "🙌", "pray": "🙏",
+	"handshake": "🤝", "muscle": "💪", "point_up_2": "👆", "point_down": "👇",
+	"point_left": "👈", "point_right": "👉", "fist": "✊", "punch": "👊",
+	"crossed_fingers": "🤞", "metal": "🤘", "call_me_hand": "🤙",
+	"raised_hand": "✋", "eyes": "👀", "brain": "🧠", "ninja": "🥷",
+
+	// Hearts and symbols
+	"sparkling_heart": "💖", "broken_heart": "💔", "two_hearts": "💕",
+	"orange_heart": "🧡", "yellow_heart": "💛", "green_heart": "💚",
+	"blue_heart": "💙", "purple_heart": "💜", "black_heart": "🖤",
+	"100": "💯", "boom": "💥", "collision": "💥", "sparkles": "✨",
+	"star": "⭐", "star2": "🌟", "dizzy": "💫", "zap": "⚡", "fire": "🔥",
+	"white_check_mark": "✅", "check": "✅", "x": "❌",
+	"negative_squared_cross_mark": "❎", "question": "❓", "exclamation": "❗",
+	"no_entry": "⛔", "no_entry_sign": "🚫", "sos": "🆘", "new": "🆕",
+	"ok": "🆗", "cool": "🆒", "free": "🆓", "up": "🆙", "red_circle": "🔴",
+	"large_blue_circle": "🔵", "green_circle": "🟢", "yellow_circle": "🟡",
+	"hourglass": "⌛", "hourglass_flowing_sand": "⏳", "alarm_clock": "⏰",
+	"zzz": "💤", "speech_balloon": "💬", "thought_balloon": "💭",
+	"bulb": "💡", "bell": "🔔", "no_bell": "🔕", "mega": "📣", "loudspeaker": "📢",
+
+	// Things
+	"rocket": "🚀", "tada": "🎉", "confetti_ball": "🎊", "balloon": "🎈",
+	"gift": "🎁", "trophy": "🏆", "medal": "🏅", "dart": "🎯", "game_die": "🎲",
+	"lock": "🔒", "unlock": "🔓", "key": "🔑", "closed_lock_with_key": "🔐",
+	"hammer": "🔨", "wrench": "🔧", "nut_and_bolt": "🔩", "link": "🔗",
+	"paperclip": "📎", "pushpin": "📌", "memo": "📝", "pencil": "📝",
+	"book": "📖", "books": "📚", "clipboard": "📋", "calendar": "📅",
+	"chart_with_upwards_trend": "📈", "chart_with_downwards_trend": "📉",
+	"bar_chart": "📊", "package": "📦",
+	"email": "📧", "inbox_tray": "📥", "outbox_tray": "📤", "mailbox": "📫",
+	"computer": "💻", "iphone": "📱", "satellite": "📡", "battery": "🔋",
+	"electric_plug": "🔌", "mag": "🔍", "bug": "🐛", "construction": "🚧",
+	"rotating_light": "🚨", "moneybag": "💰", "gem": "💎", "coffee": "☕", "tea": "🍵", "beer": "🍺", "beers": "🍻", "pizza": "🍕",
+	"hamburger": "🍔", "cake": "🍰", "birthday": "🎂", "cookie": "🍪",
+	"popcorn": "🍿", "taco": "🌮", "apple": "🍎",
+
+	// Nature
+	"sun_with_face": "🌞", "rainbow": "🌈", "ocean": "🌊", "earth_africa": "🌍",
+	"earth_americas": "🌎", "earth_asia": "🌏", "moon": "🌙", "seedling": "🌱", "evergreen_tree": "🌲",
+	"palm_tree": "🌴", "cactus": "🌵", "rose": "🌹", "sunflower": "🌻",
+	"four_leaf_clover": "🍀", "cat": "🐱", "dog": "🐶", "fox_face": "🦊",
+	"penguin": "🐧", "unicorn": "🦄", "turtle": "🐢", "snake": "🐍",
+	"octopus": "🐙", "crab": "🦀", "whale": "🐳", "bee": "🐝", "snail": "🐌",
+	"owl": "🦉", "duck": "🦆", "chicken": "🐔", "pig": "🐷", "monkey": "🐒",
+}
+
+// shortcodeRe matches a shortcode with its colons.
+var shortcodeRe = regexp.MustCompile(`:([a-z0-9_+-]+):`)
+
+// Emoji returns the emoji for shortcode, given with or without its colons.
+func Emoji(shortcode string) (string, bool) {
+	e, ok := emojiShortcodes[strings.ToLower(strings.Trim(shortcode, ":"))]
+	return e, ok
+}
+
+// ExpandShortcodes replaces every known :shortcode: in s with its emoji and
+// leaves unknown ones, like the ":30:" in "12:30:45", as they are.
+func ExpandShortcodes(s string) string {
+	if !strings.Contains(s, ":") {
+		return s
+	}
+	return shortcodeRe.ReplaceAllStringFunc(s, func(code string) string {
+		if e, ok := Emoji(code); ok {
+			return e
+		}
+		return code
+	})
+}
+
+// EmojiCompletion is a shortcode offered for what was typed so far.
+type EmojiCompletion struct {
+	Shortcode string // without colons
+	Emoji     string
+}
+
+// CompleteShortcode returns up to limit shortcodes starting with prefix
+// (without the colon), the shortest first. Names with a later word starting
+// with prefix follow, so ":heart" also offers "broken_heart".
+func CompleteShortcode(prefix string, limit int) []EmojiCompletion {
+	prefix = strings.ToLower(prefix)
+	var starts, contains []string
+	for code := range emojiShortcodes {
+		switch {
+		case strings.HasPrefix(code, prefix):
+			starts = append(starts, code)
+		case strings.Contains(code, "_"+prefix):
+			contains = append(contains, code)
+		}
+	}
+	byLength := func(codes []string) {
+		sort.Slice(codes, func(i, j int) bool {
+			if len(codes[i]) != len(codes[j]) {
+				return len(codes[i]) < len(codes[j])
+			}
+			return codes[i] < codes[j]
+		})
+	}
+	byLength(starts)
+	byLength(contains)
+
+	var out []EmojiCompletion
+	for _, code := range append(starts, contains...) {
+		if len(out) == limit {
+			break
+		}
+		out = append(out, EmojiCompletion{Shortcode: code, Emoji: emojiShortcodes[code]})
+	}
+	return out
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"regexp"
+	"sort"
+)
+
+// Highlight is a compiled highlight rule. Color is a tview color tag.
+type Highlight struct {
+	Pattern *regexp.Regexp
+	Color   string
+	Notify  bool
+}
+
+// HighlightSpan marks a byte range [Start, End) of a message body that
+// should be drawn in Color.
+type HighlightSpan struct {
+	Start int
+	End   int
+	Color string
+}
+
+// Highlighter applies a fixed list of rules to message bodies. It is built
+// once at startup and only read afterwards, so it needs no locking.
+type Highlighter struct {
+	rules []Highlight
+}
+
+// NewHighlighter returns a Highlighter for rules. Earlier rules win when two
+// matches overlap.
+func NewHighlighter(rules []Highlight) *Highlighter {
+	return &Highlighter{rules: rules}
+}
+
+// Len returns the number of active rules.
+func (h *Highlighter) Len() int {
+	if h == nil {
+		return 0
+	}
+	return len(h.rules)
+}
+
+// Match returns the non-overlapping spans to highlight in content, ordered
+// by position, and whether any matching rule asked for a notification.
+func (h *Highlighter) Match(content string) ([]HighlightSpan, bool) {
+	if h == nil || len(h.rules) == 0 {
+		return nil, false
+	}
+	var spans []HighlightSpan
+	notify := false
+	for _, rule := range h.rules {
+		for _, loc := range rule.Pattern.FindAllStringIndex(content, -1) {
+			if loc[0] == loc[1] || overlaps(spans, loc[0], loc[1]) {
+				continue
+			}
+			spans = append(spans, HighlightSpan{Start: loc[0], End: loc[1], Color: rule.Color})
+			notify = notify || rule.Notify
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+	return spans, notify
+}
+
+func overlaps(spans []HighlightSpan, start, end int) bool {
+	for _, s := range spans {
+		if start < s.End && s.Start < end {
+			return true
+		}
+	}
+	return false
+}
@@ -1,49 +1,192 @@
-package models
-
-import "time"
-
-// Message represents a chat message.
-// Color is a tview color tag string e.g. "[green]" or "[#ff00ff]".
-type Message struct {
-	ID        string
-	Username  string
-	Content   string
-	Timestamp time.Time
-	IsSystem  bool
-	Color     string // tview color tag — used for both username label and content text
-}
-
-// NewMessage creates a new outgoing message with the default hash-based color.
-// The controller should override Color via AppState.GetUserColorTag if the user
-// has set a custom color.
-func NewMessage(username, content string) *Message {
-	return &Message{
-		ID:        generateMessageID(),
-		Username:  username,
-		Content:   content,
-		Timestamp: time.Now(),
-		IsSystem:  false,
-		Color:     GetUsernameColor(username), // tview tag e.g. "[magenta]"
-	}
-}
-
-// NewSystemMessage creates a system notification message.
-func NewSystemMessage(content string) *Message {
-	return &Message{
-		ID:        generateMessageID(),
-		Username:  "SYSTEM",
-		Content:   content,
-		Timestamp: time.Now(),
-		IsSystem:  true,
-		Color:     "[yellow]",
-	}
-}
-
-// FormatTime returns the formatted timestamp for display.
-func (m *Message) FormatTime() string {
-	return m.Timestamp.Format("15:04")
-}
-
-func generateMessageID() string {
-	return time.Now().Format("20060102150405")
-}
+package models
+
+import (
+	"fmt"
+	"image"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Message represents a chat message.
+// Color is a tview color tag string e.g. "[green]" or "[#ff00ff]".
+type Message struct {
+	ID        string
+	Seq       uint64 // the relay's sequence number, 0 if unknown (protocol v1)
+	Username  string
+	To        string // direct message recipient, "" for room messages
+	Content   string
+	Timestamp time.Time
+	IsSystem  bool
+	Color     string        // tview color tag — used for both username label and content text
+	Delivery  DeliveryState // own messages only; zero for incoming/system
+	Sender    SenderCheck   // incoming messages only: was the signature checked
+	Forwarded *Forward      // provenance of a forwarded message, nil otherwise
+	Room      string        // own messages sent to another room than the current one
+	Action    bool          // "/me waves", shown as "* username waves"
+	AlsoIn    []string      // the other rooms a cross-posted message went to
+	Mentions  []string      // users @mentioned, as the relay found them
+	File      *Attachment   // the file the message announces, nil otherwise
+	RelayID   string        // own messages: the ID the relay gave it, "" until then
+	ReplyTo   string        // relay ID of the message this one answers, "" if none
+	Quote     *Quote        // what ReplyTo points at, nil if not a reply
+	Reactions []Reaction    // emoji given to the message, in the order first given
+	Image     image.Image   // system messages only: an image previewed under the line
+}
+
+// Forward is the provenance a forwarded message carries: who wrote it,
+// where and when.
+type Forward struct {
+	From string    `json:"from"`
+	Room string    `json:"room,omitempty"` // "" = a direct message
+	At   time.Time `json:"at"`
+}
+
+// Reaction is one emoji given to a message and who gave it.
+type Reaction struct {
+	Emoji string   `json:"emoji"`
+	Users []string `json:"users"`
+}
+
+// Pin is a message pinned to the room: who pinned it and when.
+type Pin struct {
+	Message  *Message
+	PinnedBy string
+	PinnedAt time.Time
+}
+
+// Quote is what a reply shows of the message it answers: who wrote it and
+// how it began. Both are empty for a message this client never saw.
+type Quote struct {
+	Username string
+	Text     string // the start of the message, on one line
+}
+
+// quoteLen is how many characters of a message a Quote keeps.
+const quoteLen = 60
+
+// QuoteOf returns the excerpt a reply to m shows.
+func QuoteOf(m *Message) *Quote {
+	text := m.Content
+	if m.File != nil {
+		text = m.File.Label()
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	if r := []rune(text); len(r) > quoteLen {
+		text = string(r[:quoteLen-1]) + "…"
+	}
+	return &Quote{Username: m.Username, Text: text}
+}
+
+// DeliveryState tracks an own message on its way to the relay.
+type DeliveryState int
+
+const (
+	DeliveryNone    DeliveryState = iota // not an outgoing message
+	DeliverySending                      // POST /api/send in flight
+	DeliveryQueued                       // relay unreachable, waiting in the offline queue
+	DeliverySent                         // relay accepted it and returned an ID
+	DeliveryFailed                       // refused or dropped — /retry resends
+)
+
+// SenderCheck is the result of checking an incoming message's signature
+// against the sender's pinned identity key.
+type SenderCheck int
+
+const (
+	SenderUnchecked SenderCheck = iota // own, system or local messages
+	SenderVerified                     // signed by the pinned identity key
+	SenderUnsigned                     // no signature, or no key to check it against
+	SenderForged                       // signature does not match — possibly spoofed
+)
+
+// NewMessage creates a new outgoing message with the default hash-based color.
+// The controller should override Color via AppState.GetUserColorTag if the user
+// has set a custom color.
+func NewMessage(username, content string) *Message {
+	return &Message{
+		ID:        generateMessageID(),
+		Username:  username,
+		Content:   content,
+		Timestamp: time.Now(),
+		IsSystem:  false,
+		Color:     GetUsernameColor(username), // tview tag e.g. "[magenta]"
+	}
+}
+
+// DirectPrefix marks the text of a direct message to username in the chat.
+func DirectPrefix(username string) string {
+	return "✉ → " + username + ": "
+}
+
+// ForwardPrefix marks the text of a forwarded message in the chat.
+func ForwardPrefix(f *Forward) string {
+	where := ""
+	if f.Room != "" {
+		where = " in #" + f.Room
+	}
+	return "↪ " + f.From + where + ", " + f.At.Local().Format("Jan 2 15:04") + ": "
+}
+
+// AlsoInSuffix notes the other rooms of a cross-posted message.
+func AlsoInSuffix(rooms []string) string {
+	return "  · also posted in #" + strings.Join(rooms, ", #")
+}
+
+// MentionsUser reports whether the relay found username @mentioned in m.
+func (m *Message) MentionsUser(username string) bool {
+	for _, name := range m.Mentions {
+		if name == username {
+			return true
+		}
+	}
+	return false
+}
+
+// DisplayText returns the content as the chat shows it, behind the markers
+// for a direct message, another room and a forward, and before the rooms
+// a cross-post also went to. A file announcement shows as its Label.
+func (m *Message) DisplayText() string {
+	text := m.Content
+	if m.File != nil {
+		text = m.File.Label()
+	}
+	if m.Forwarded != nil {
+		text = ForwardPrefix(m.Forwarded) + text
+	}
+	switch {
+	case m.To != "":
+		text = DirectPrefix(m.To) + text
+	case m.Room != "":
+		text = "→ #" + m.Room + ": " + text
+	}
+	if len(m.AlsoIn) > 0 {
+		text += AlsoInSuffix(m.AlsoIn)
+	}
+	return text
+}
+
+// NewSystemMessage creates a system notification message.
+func NewSystemMessage(content string) *Message {
+	return &Message{
+		ID:        generateMessageID(),
+		Username:  "SYSTEM",
+		Content:   content,
+		Timestamp: time.Now(),
+		IsSystem:  true,
+		Color:     "[yellow]",
+	}
+}
+
+// FormatTime returns the formatted timestamp for display.
+func (m *Message) FormatTime() string {
+	return m.Timestamp.Format("15:04")
+}
+
+var messageCounter uint64
+
+// generateMessageID returns a process-unique local ID. The offline queue
+// finds messages by it, so two messages in the same second must differ.
+func generateMessageID() string {
+	return fmt.Sprintf("local_%s_%d", time.Now().Format("20060102150405"), atomic.AddUint64(&messageCounter, 1))
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxDiagnostics caps how many recent protocol diagnostics /debug keeps.
+const maxDiagnostics = 20
+
+// Reasons a message from the relay was not shown, as counted by
+// RecordDropped. They answer "I didn't get your message".
+const (
+	DropMalformed     = "malformed"     // no id, sender or content; skipped while parsing
+	DropDuplicate     = "duplicate"     // an ID already shown, delivered again
+	DropDecoy         = "decoy"         // privacy-mode cover traffic, dropped by design
+	DropUndecryptable = "undecryptable" // shown only as a placeholder: wrong room key or broken envelope
+	DropViewStopped   = "view_stopped"  // arrived while the chat screen was shutting down
+	DropCleared       = "cleared"       // still animating when /clear or /wipe ran
+)
+
+// SessionStats collects per-session counters surfaced by the /debug command.
+// It is written from network goroutines and read from the tview event loop,
+// so every access goes through mu.
+type SessionStats struct {
+	mu          sync.Mutex
+	startedAt   time.Time
+	received    int
+	sent        int
+	violations  map[string]int // violation kind → count
+	dropped     map[string]int // Drop* reason → count
+	diagnostics []string       // most recent last, capped at maxDiagnostics
+}
+
+// SessionSnapshot is a consistent copy of SessionStats for rendering.
+type SessionSnapshot struct {
+	StartedAt   time.Time
+	Received    int
+	Sent        int
+	Violations  map[string]int
+	Dropped     map[string]int
+	Diagnostics []string
+}
+
+// NewSessionStats creates an empty stats collector starting now.
+func NewSessionStats() *SessionStats {
+	return &SessionStats{
+		startedAt:  time.Now(),
+		violations: make(map[string]int),
+		dropped:    make(map[string]int),
+	}
+}
+
+// RecordReceived counts one message delivered by the relay.
+func (s *SessionStats) RecordReceived() {
+	s.mu.Lock()
+	s.received++
+	s.mu.Unlock()
+}
+
+// RecordSent counts one message accepted by the relay.
+func (s *SessionStats) RecordSent() {
+	s.mu.Lock()
+	s.sent++
+	s.mu.Unlock()
+}
+
+// RecordViolation counts a protocol violation of the given kind and keeps
+// a timestamped diagnostic line for /debug.
+func (s *SessionStats) RecordViolation(kind, detail string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.violations[kind]++
+	line := fmt.Sprintf("%s  %s: %s", time.Now().Format("15:04:05"), kind, detail)
+	s.diagnostics = append(s.diagnostics, line)
+	if len(s.diagnostics) > maxDiagnostics {
+		s.diagnostics = s.diagnostics[len(s.diagnostics)-maxDiagnostics:]
+	}
+}
+
+// RecordDropped counts n messages not shown for reason, one of the Drop*
+// constants. Safe to call from any goroutine; n <= 0 is ignored.
+func (s *SessionStats) RecordDropped(reason string, n int) {
+	if n <= 0 {
+		return
+	}
+	s.mu.Lock()
+	s.dropped[reason] += n
+	s.mu.Unlock()
+}
+
+// TotalViolations returns the number of protocol violations seen so far.
+func (s *SessionStats) TotalViolations() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, c := range s.violations {
+		n += c
+	}
+	return n
+}
+
+// Snapshot returns a copy of the current counters.
+func (s *SessionStats) Snapshot() SessionSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	violations := make(map[string]int, len(s.violations))
+	for k, v := range s.violations {
+		violations[k] = v
+	}
+	dropped := make(map[string]int, len(s.dropped))
+	for k, v := range s.dropped {
+		dropped[k] = v
+	}
+	diagnostics := make([]string, len(s.diagnostics))
+	copy(diagnostics, s.diagnostics)
+	return SessionSnapshot{
+		StartedAt:   s.startedAt,
+		Received:    s.received,
+		Sent:        s.sent,
+		Violations:  violations,
+		Dropped:     dropped,
+		Diagnostics: diagnostics,
+	}
+}
+
+// SortedKeys returns the keys of a counter map in alphabetical order.
+func SortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
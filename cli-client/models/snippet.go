@@ -0,0 +1,94 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// SnippetVars are the values a snippet's placeholders are filled in with.
+type SnippetVars struct {
+	Now  time.Time
+	User string
+	Room string // "" = the global room
+}
+
+// Snippets are named message templates. A template may contain
+//
+//	{date} {time} {weekday} {user} {room}   filled in on expansion
+//	{}                                      a field for the user to fill in
+//
+// Anything else in braces is left as it is. Names are matched ignoring
+// case. Snippets are loaded once at startup and only read afterwards.
+type Snippets map[string]string
+
+// NewSnippets returns the templates keyed by lower-case name; empty names
+// and templates are dropped.
+func NewSnippets(templates map[string]string) Snippets {
+	s := make(Snippets, len(templates))
+	for name, tmpl := range templates {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && tmpl != "" {
+			s[name] = tmpl
+		}
+	}
+	return s
+}
+
+// Names returns the snippet names in alphabetical order.
+func (s Snippets) Names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Expand fills in the template called name. fields are the positions of
+// its {} fields in text, in runes, first to last.
+func (s Snippets) Expand(name string, vars SnippetVars) (text string, fields []int, ok bool) {
+	tmpl, ok := s[strings.ToLower(name)]
+	if !ok {
+		return "", nil, false
+	}
+	room := vars.Room
+	if room == "" {
+		room = "global"
+	}
+	values := map[string]string{
+		"{date}":    vars.Now.Format("2006-01-02"),
+		"{time}":    vars.Now.Format("15:04"),
+		"{weekday}": vars.Now.Weekday().String(),
+		"{user}":    vars.User,
+		"{room}":    room,
+	}
+
+	var b strings.Builder
+	for tmpl != "" {
+		open := strings.IndexByte(tmpl, '{')
+		if open < 0 {
+			b.WriteString(tmpl)
+			break
+		}
+		b.WriteString(tmpl[:open])
+		tmpl = tmpl[open:]
+		end := strings.IndexByte(tmpl, '}')
+		if end < 0 {
+			b.WriteString(tmpl)
+			break
+		}
+		placeholder := tmpl[:end+1]
+		tmpl = tmpl[end+1:]
+		switch value, known := values[strings.ToLower(placeholder)]; {
+		case placeholder == "{}":
+			fields = append(fields, utf8.RuneCountInString(b.String()))
+		case known:
+			b.WriteString(value)
+		default:
+			b.WriteString(placeholder)
+		}
+	}
+	return b.String(), fields, true
+}
@@ -11,6 +11,9 @@ type User struct {
 	Color    string // tview color tag e.g. "[magenta]"
 	IsOnline bool
 	LastSeen time.Time
+	// LastActive is when the user last sent a message or typed, as the
+	// relay saw it; zero = not since they came online.
+	LastActive time.Time
 }
 
 // NewUser creates a new user with default values
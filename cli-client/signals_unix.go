@@ -0,0 +1,45 @@
+//go:build !windows
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"cli-client/controllers"
+
+	"github.com/rivo/tview"
+)
+
+// watchResume repairs the client after the process was stopped and
+// continued (Ctrl+Z / fg, SIGSTOP, a sleeping laptop): SIGCONT triggers a full
+// repaint plus a fresh poll, SIGWINCH a repaint in case the terminal changed
+// size while we were not looking.
+func watchResume(app *tview.Application, ctrl *controllers.AppController) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGCONT, syscall.SIGWINCH)
+	go func() {
+		defer recoverFromPanic()
+		for sig := range sigCh {
+			log.Printf("Signal %v — re-syncing screen", sig)
+			if sig == syscall.SIGCONT {
+				ctrl.Resume()
+			} else {
+				app.Sync()
+			}
+		}
+	}()
+}
+
+// suspend puts the client in the background like Ctrl+Z does in a shell.
+// tcell keeps the terminal in raw mode, so the key arrives as a key event and
+// never becomes SIGTSTP on its own — we restore the terminal and send it.
+func suspend(app *tview.Application) {
+	app.Suspend(func() {
+		if err := syscall.Kill(syscall.Getpid(), syscall.SIGTSTP); err != nil {
+			log.Printf("suspend: %v", err)
+		}
+	})
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"cli-client/controllers"
+
+	"github.com/rivo/tview"
+)
+
+// watchResume is a no-op on Windows: there is no SIGCONT, and console
+// resizes are reported by tcell itself.
+func watchResume(app *tview.Application, ctrl *controllers.AppController) {}
+
+// suspend is a no-op on Windows, which has no job control.
+func suspend(app *tview.Application) {}
@@ -0,0 +1,218 @@
+// Package trust is the client's trust-on-first-use database: the identity
+// key fingerprint first seen for every username.
+//
+// A key seen for the first time is pinned. A different key later is a
+// change the user has to accept with /trust; until then the old pin stays.
+// The database is trust.json next to config.json (mode 0600) and can be
+// exported with /trust export.
+package trust
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is the pinned key of one user.
+type Entry struct {
+	Key         string    `json:"key"` // Base64 Ed25519 public key
+	Fingerprint string    `json:"fingerprint"`
+	FirstSeen   time.Time `json:"first_seen"`
+	Verified    bool      `json:"verified"` // accepted with /trust rather than on first use
+}
+
+// Result is the outcome of Check.
+type Result int
+
+const (
+	New     Result = iota // first key seen for the user — now pinned
+	Match                 // same key as pinned
+	Changed               // differs from the pinned key — not stored
+)
+
+// Fingerprint returns the short form of a Base64 public key users compare
+// out of band: the first 16 bytes of its SHA-256, as 8 groups of 4 hex
+// digits.
+func Fingerprint(key string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) == 0 {
+		return "", errors.New("not a Base64 key")
+	}
+	sum := sha256.Sum256(raw)
+	digits := hex.EncodeToString(sum[:16])
+	groups := make([]string, 0, len(digits)/4)
+	for i := 0; i < len(digits); i += 4 {
+		groups = append(groups, digits[i:i+4])
+	}
+	return strings.Join(groups, " "), nil
+}
+
+// NormalizeFingerprint strips the separators users may type or paste
+// (spaces, colons, dashes) and lowercases s, for comparison.
+func NormalizeFingerprint(s string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", ":", "", "-", "").Replace(s))
+}
+
+// Store is the TOFU database. It is safe for concurrent use.
+type Store struct {
+	mu      sync.Mutex
+	path    string // "" = memory only
+	entries map[string]*Entry
+}
+
+// Open loads the database at path. A missing file is an empty database;
+// an empty path keeps it in memory only.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, entries: make(map[string]*Entry)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s.entries); err != nil {
+		return s, fmt.Errorf("%s: %w", path, err)
+	}
+	if s.entries == nil {
+		s.entries = make(map[string]*Entry)
+	}
+	return s, nil
+}
+
+// Check compares key with the one pinned for username. A first key is
+// pinned and saved; a changed key is reported along with the pinned entry
+// and left for the user to accept.
+func (s *Store) Check(username, key string) (Result, Entry, error) {
+	fp, err := Fingerprint(key)
+	if err != nil {
+		return New, Entry{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[username]; ok {
+		if e.Key == key {
+			return Match, *e, nil
+		}
+		return Changed, *e, nil
+	}
+	e := &Entry{Key: key, Fingerprint: fp, FirstSeen: time.Now()}
+	s.entries[username] = e
+	return New, *e, s.saveLocked()
+}
+
+// Trust pins key for username as verified, replacing any earlier key.
+func (s *Store) Trust(username, key string) (Entry, error) {
+	fp, err := Fingerprint(key)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := &Entry{Key: key, Fingerprint: fp, FirstSeen: time.Now(), Verified: true}
+	if old, ok := s.entries[username]; ok && old.Key == key {
+		e.FirstSeen = old.FirstSeen
+	}
+	s.entries[username] = e
+	return *e, s.saveLocked()
+}
+
+// Untrust forgets username's key; the next one seen is pinned as new.
+// It reports whether there was an entry.
+func (s *Store) Untrust(username string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[username]; !ok {
+		return false, nil
+	}
+	delete(s.entries, username)
+	return true, s.saveLocked()
+}
+
+// Get returns the entry pinned for username.
+func (s *Store) Get(username string) (Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[username]
+	if !ok {
+		return Entry{}, false
+	}
+	return *e, true
+}
+
+// Usernames returns every username with a pinned key, sorted.
+func (s *Store) Usernames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.entries))
+	for name := range s.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Path returns the database file, or "" for memory only.
+func (s *Store) Path() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.path
+}
+
+// Export writes the database to path in the same JSON format it is
+// stored in, mode 0600.
+func (s *Store) Export(path string) error {
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s.entries, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// Wipe empties the store and stops it writing to disk. Used by /wipe all,
+// after which the file is deleted.
+func (s *Store) Wipe() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = ""
+	s.entries = make(map[string]*Entry)
+}
+
+// saveLocked rewrites the database file through a temporary file, so a
+// crash never leaves it half-written.
+func (s *Store) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
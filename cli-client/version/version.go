@@ -0,0 +1,142 @@
+// Package version holds the client's build metadata. Release builds stamp
+// it with -ldflags:
+//
+//	go build -ldflags "-X cli-client/version.Version=v1.1.0 \
+//	  -X cli-client/version.Commit=$(git rev-parse --short HEAD) \
+//	  -X cli-client/version.Date=$(date -u +%Y-%m-%d)" -o client .
+//
+// A plain go build inside a git checkout still gets the commit and date
+// from the Go toolchain's VCS stamp.
+package version
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+var (
+	Version = "v1.0.0-dev"
+	Commit  = ""
+	Date    = ""
+)
+
+func init() {
+	if Commit != "" && Date != "" {
+		return
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	var revision, modified, built string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		case "vcs.time":
+			built = s.Value
+		}
+	}
+	if Commit == "" && revision != "" {
+		if len(revision) > 7 {
+			revision = revision[:7]
+		}
+		if modified == "true" {
+			revision += "-dirty"
+		}
+		Commit = revision
+	}
+	if Date == "" && len(built) >= len("2006-01-02") {
+		Date = built[:len("2006-01-02")]
+	}
+}
+
+// Summary is the one-line form for people, e.g. "v1.1.0 · 3f2c9ab · 2026-10-01".
+// Unknown parts are left out.
+func Summary() string {
+	parts := []string{Version}
+	if Commit != "" {
+		parts = append(parts, Commit)
+	}
+	if Date != "" {
+		parts = append(parts, Date)
+	}
+	return strings.Join(parts, " · ")
+}
+
+// UserAgent is sent with every request so the relay's logs can tell client
+// builds apart, e.g. "ttc-client/v1.1.0 (3f2c9ab; 2026-10-01)". It carries
+// no OS or machine details.
+func UserAgent() string {
+	commit, date := Commit, Date
+	if commit == "" {
+		commit = "unknown"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	return fmt.Sprintf("ttc-client/%s (%s; %s)", Version, commit, date)
+}
+
+// Compare orders two versions like "v1.2.3" or "v1.2.0-rc1": negative if a
+// is older than b, 0 if they are the same release, positive if a is newer.
+// Missing parts count as 0 and a pre-release sorts before its release. ok
+// is false if either does not parse.
+func Compare(a, b string) (cmp int, ok bool) {
+	va, okA := parse(a)
+	vb, okB := parse(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	for i := range va.parts {
+		if va.parts[i] != vb.parts[i] {
+			if va.parts[i] < vb.parts[i] {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	switch {
+	case va.pre == vb.pre:
+		return 0, true
+	case va.pre == "":
+		return 1, true
+	case vb.pre == "":
+		return -1, true
+	}
+	return strings.Compare(va.pre, vb.pre), true
+}
+
+// Older reports whether this build is older than v. Versions that don't
+// parse are never older.
+func Older(v string) bool {
+	cmp, ok := Compare(Version, v)
+	return ok && cmp < 0
+}
+
+type parsed struct {
+	parts [3]int
+	pre   string
+}
+
+func parse(v string) (parsed, bool) {
+	var p parsed
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	v, p.pre, _ = strings.Cut(v, "-")
+	fields := strings.Split(v, ".")
+	if len(fields) > len(p.parts) {
+		return p, false
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return p, false
+		}
+		p.parts[i] = n
+	}
+	return p, true
+}
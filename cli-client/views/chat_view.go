@@ -1,818 +1,1447 @@
-package views
-
-import (
-	"fmt"
-	"log"
-	"os"
-	"strings"
-	"sync/atomic"
-	"time"
-
-	"cli-client/models"
-
-	"github.com/gdamore/tcell/v2"
-	"github.com/rivo/tview"
-)
-
-// DebugLogFile is set by main so renderMessages can flush before SetText.
-// A hard crash inside tview.SetText would otherwise lose the last log lines
-// since they're buffered. Flush guarantees the trace is on disk.
-var DebugLogFile *os.File
-
-type ChatView struct {
-	app           *tview.Application
-	container     *tview.Flex
-	header        *tview.TextView
-	messageView   *tview.TextView
-	inputField    *tview.InputField
-	footer        *tview.TextView
-	commandBar    *tview.TextView
-	onSendMessage func(string)
-	onCommand     func(string)
-
-	stopped  int32 // atomic: 1 = stopped
-	animMode int32 // atomic: 1 = word-by-word, 0 = static
-
-	// Header state — only touched inside tview event loop
-	headerUsername string
-	headerLatency  int
-	headerOnline   bool
-
-	// Server stats — updated by UpdateStats(), only in tview event loop
-	statsTotalMsgs  int
-	statsActive     int
-	statsWaiting    int
-	statsMaxMsgs    int
-	statsMaxWaiters int
-	statsServerURL  string
-
-	// Nick mode / message history — only touched inside tview event loop
-	nickActive  bool
-	sentHistory []string
-	historyIdx  int // -1 = not browsing
-
-	// ── Message render model ──────────────────────────────────────────────
-	// All fields below are ONLY ever read/written from inside QueueUpdateDraw
-	// (i.e. the tview event loop), so no mutex is needed.
-	//
-	// Design: the visible text is always:
-	//   committedText  +  inFlight[0] + inFlight[1] + ...   (by insertion order)
-	//
-	// AddMessage      → appends a fully-formatted line to committedText, re-renders.
-	// Animation start → allocates an inFlight slot (animID), re-renders.
-	// Animation tick  → updates the slot text, re-renders.
-	// Animation end   → moves final line from slot into committedText, re-renders.
-	//
-	// Because AddMessage only touches committedText (never overwrites inFlight),
-	// and animations only touch their own slot, messages never clobber each other.
-	committedText string
-	inFlight      map[int]string // animID → current partial line (with trailing cursor)
-	nextAnimID    int            // monotonically increasing; never resets
-	inFlightGen   int            // incremented by ClearMessages; stale callbacks bail out
-}
-
-func NewChatView(
-	app *tview.Application,
-	onSendMessage func(string),
-	onCommand func(string),
-) *ChatView {
-	c := &ChatView{
-		app:             app,
-		onSendMessage:   onSendMessage,
-		onCommand:       onCommand,
-		historyIdx:      -1,
-		headerLatency:   18,
-		headerOnline:    true,
-		inFlight:        make(map[int]string),
-		statsMaxMsgs:    1000,
-		statsMaxWaiters: 1000,
-		statsServerURL:  "localhost:8034",
-	}
-	// Default to STATIC mode. Animation mode (word-by-word) involves a
-	// goroutine that reads from a channel while holding a QueueUpdateDraw
-	// slot — if that path is the crash source, static mode will stay stable.
-	// Users can switch with /mode animation once confirmed working.
-	atomic.StoreInt32(&c.animMode, 0)
-	c.buildUI()
-	c.startClockTicker()
-	return c
-}
-
-func (c *ChatView) Primitive() tview.Primitive      { return c.container }
-func (c *ChatView) InputPrimitive() tview.Primitive { return c.inputField }
-func (c *ChatView) GetPrimitive() tview.Primitive   { return c.container }
-
-// ── UI construction ────────────────────────────────────────────────────────
-
-func (c *ChatView) buildUI() {
-	// Header — bordered box, cyan border to match the project theme.
-	// Height 3 in the flex (1 top border + 1 content line + 1 bottom border).
-	c.header = tview.NewTextView()
-	c.header.SetDynamicColors(true)
-	c.header.SetTextAlign(tview.AlignLeft)
-	c.header.SetBackgroundColor(tcell.ColorBlack)
-	c.header.SetBorder(true)
-	c.header.SetBorderColor(tcell.ColorDarkCyan)
-	c.header.SetBorderPadding(0, 0, 1, 1)
-
-	c.messageView = tview.NewTextView()
-	c.messageView.SetDynamicColors(true)
-	c.messageView.SetScrollable(true)
-	c.messageView.SetWordWrap(true)
-	c.messageView.SetText("")
-	c.messageView.SetBackgroundColor(tcell.ColorBlack)
-
-	c.commandBar = tview.NewTextView()
-	c.commandBar.SetDynamicColors(true)
-	c.commandBar.SetTextAlign(tview.AlignLeft)
-	c.commandBar.SetBackgroundColor(tcell.ColorBlack)
-	c.redrawCommandBar()
-
-	c.inputField = tview.NewInputField()
-	c.inputField.SetLabel("  > ")
-	c.inputField.SetPlaceholder("Type a message or /command...")
-	c.inputField.SetFieldBackgroundColor(tcell.ColorBlack)
-	c.inputField.SetFieldTextColor(tcell.ColorWhite)
-	c.inputField.SetDoneFunc(func(key tcell.Key) {
-		if key == tcell.KeyEnter {
-			text := c.inputField.GetText()
-			if text != "" {
-				if strings.HasPrefix(text, "/") {
-					c.onCommand(text)
-				} else {
-					c.onSendMessage(text)
-				}
-				c.inputField.SetText("")
-				c.historyIdx = -1
-			}
-		}
-	})
-
-	// ── Arrow-key capture for nick-mode history navigation ─────────────────
-	// When nick mode is OFF  → keys behave normally.
-	// When nick mode is ON:
-	//   ← (Left)  → go to previous (older) sent message.
-	//               Only activates when the field is empty OR already in history,
-	//               so normal left-cursor movement still works while typing fresh text.
-	//   → (Right) → go to next (newer) sent message / clears at the newest end.
-	c.inputField.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-		if !c.nickActive {
-			return event
-		}
-		fieldEmpty := c.inputField.GetText() == ""
-		inHistory := c.historyIdx >= 0
-
-		switch event.Key() {
-		case tcell.KeyLeft:
-			if !fieldEmpty && !inHistory {
-				return event // editing a fresh message — let cursor move
-			}
-			if len(c.sentHistory) == 0 {
-				return nil
-			}
-			if c.historyIdx < 0 {
-				c.historyIdx = len(c.sentHistory) - 1
-			} else if c.historyIdx > 0 {
-				c.historyIdx--
-			}
-			c.inputField.SetText(c.sentHistory[c.historyIdx])
-			return nil // consumed
-
-		case tcell.KeyRight:
-			if !fieldEmpty && !inHistory {
-				return event // editing a fresh message — let cursor move
-			}
-			if c.historyIdx < 0 {
-				return nil
-			}
-			c.historyIdx++
-			if c.historyIdx >= len(c.sentHistory) {
-				c.historyIdx = -1
-				c.inputField.SetText("")
-			} else {
-				c.inputField.SetText(c.sentHistory[c.historyIdx])
-			}
-			return nil // consumed
-		}
-		return event
-	})
-
-	c.footer = tview.NewTextView()
-	c.footer.SetDynamicColors(true)
-	c.footer.SetTextAlign(tview.AlignLeft)
-	c.footer.SetBackgroundColor(tcell.ColorBlack)
-	// initial content drawn after stats fields are set
-	c.redrawFooter()
-
-	c.container = tview.NewFlex()
-	c.container.SetDirection(tview.FlexRow)
-	c.container.SetBackgroundColor(tcell.ColorBlack)
-	c.container.AddItem(c.header, 5, 0, false) // 5 = border top + 2 content lines + border bottom
-	c.container.AddItem(c.messageView, 0, 1, false)
-	c.container.AddItem(c.commandBar, 1, 0, false)
-	c.container.AddItem(c.inputField, 3, 0, true)
-	c.container.AddItem(c.footer, 1, 0, false)
-
-	c.redrawHeader()
-}
-
-// ── Message render engine ──────────────────────────────────────────────────
-
-// sanitizeContent escapes raw user-supplied text for safe rendering inside
-// a tview TextView with SetDynamicColors(true).
-//
-// tview treats anything matching `[word]` as a color/style tag. User messages
-// can contain arbitrary `[` characters (URLs, code snippets, IRC nicks like
-// `[nick]`). An unmatched or unrecognised `[` sequence causes tview to panic
-// with an index-out-of-bounds — a fatal error that recover() cannot catch.
-//
-// The fix: replace every `[` in user content with `[[]` (tview's own escape
-// for a literal `[`). We do NOT escape color tags we intentionally construct
-// in format strings — only raw content that came from outside the app.
-func sanitizeContent(s string) string {
-	return strings.ReplaceAll(s, "[", "[[]")
-}
-
-// safeColorTag validates that a color tag from external sources is well-formed
-// before inserting it raw into a tview format string.
-//
-// A valid tview color tag must:
-//   - Start with "["
-//   - End with "]"
-//   - Contain no nested "[" that would start a second tag
-//
-// Anything that doesn't satisfy these rules is replaced with "[white]" so we
-// never hand tview a malformed tag that would cause a fatal index panic.
-func safeColorTag(tag string) string {
-	if len(tag) < 3 {
-		return "[white]"
-	}
-	if tag[0] != '[' || tag[len(tag)-1] != ']' {
-		return "[white]"
-	}
-	// Must not contain a second "[" inside (would nest tags)
-	inner := tag[1 : len(tag)-1]
-	if strings.ContainsAny(inner, "[]") {
-		return "[white]"
-	}
-	return tag
-}
-
-// renderMessages rebuilds the messageView from the committed buffer plus all
-// active in-flight animation lines. Must always be called from the tview event loop.
-func (c *ChatView) renderMessages() {
-	log.Printf("TRACE renderMessages: committedLen=%d inFlightCount=%d nextAnimID=%d",
-		len(c.committedText), len(c.inFlight), c.nextAnimID)
-	text := c.committedText
-	for i := 0; i < c.nextAnimID; i++ {
-		if line, ok := c.inFlight[i]; ok {
-			text += line
-		}
-	}
-	log.Printf("TRACE renderMessages: total text len=%d calling SetText", len(text))
-	// Flush to disk BEFORE SetText — if tview crashes inside SetText (e.g. from
-	// a bad color tag sequence we missed), the log is already on disk.
-	if DebugLogFile != nil {
-		DebugLogFile.Sync()
-	}
-	c.messageView.SetText(text)
-	log.Printf("TRACE renderMessages: SetText done, calling ScrollToEnd")
-	c.messageView.ScrollToEnd()
-	log.Printf("TRACE renderMessages: DONE")
-}
-
-// ── Message formatting ────────────────────────────────────────────────────
-
-// formatLine renders a Message into a tview-tagged string.
-//
-// Output format:   [HH:MM] [username] message body
-//
-// Both the username label (in brackets) and the message content share the
-// same color so the entire line visually "belongs" to that user.
-// [[] is tview's escape sequence for a literal "[" character.
-func formatLine(msg *models.Message) string {
-	if msg.IsSystem {
-		// System messages are trusted internal strings — they may contain tview
-		// color markup like [cyan]name[-] intentionally. Do NOT sanitize them.
-		return fmt.Sprintf("[yellow]▸ %s[-]\n", msg.Content)
-	}
-	color := safeColorTag(msg.Color)
-	if color == "" {
-		color = "[white]"
-	}
-	ts := msg.FormatTime()
-	safeUser := sanitizeContent(msg.Username) // escapes [ inside username
-	safeContent := sanitizeContent(msg.Content)
-	// [ts] and [username] are NOT valid tview color names so tview passes them
-	// through as literal bracket-wrapped text — no [[] escaping needed.
-	// [%s] for timestamp → passes through (digits+colon = never a color name)
-	// [[]%s] for username → [[] is tview escape for literal "[", so output is [username]
-	return fmt.Sprintf("[gray][%s][-] %s[[]%s][-] %s%s[-]\n",
-		ts, color, safeUser, color, safeContent)
-}
-
-// incomingPrefix builds the formatted prefix for an incoming message line.
-//
-// We do NOT escape [ with [[] here. tview passes unrecognised tags (those
-// whose content is not a valid color name) through as literal text.
-// [10:48] and [username] are never valid tview colors, so they display as-is.
-// Real color directives like [red] and [-] work as normal.
-func incomingPrefix(colorTag, username string) string {
-	ts := time.Now().Format("15:04")
-	safeUser := sanitizeContent(username) // escapes any [ inside the username itself
-	return fmt.Sprintf("[gray][%s][-] %s[[]%s][-] %s",
-		ts, colorTag, safeUser, colorTag)
-}
-
-// ── Public message API ────────────────────────────────────────────────────
-
-// AddMessage displays a message instantly (own messages, system messages).
-// Must be called from the tview event loop.
-//
-// By appending to committedText (never to the raw messageView text), we
-// guarantee the message survives any concurrent animation redraws.
-func (c *ChatView) AddMessage(msg *models.Message) {
-	c.committedText += formatLine(msg)
-	c.renderMessages()
-}
-
-// AddIncomingMessage displays a message from another user.
-//
-//	colorTag — tview color tag from the wire format, e.g. "[green]" or "[#ff00ff]".
-//	           Pass through models.ParseColorToTag if converting from raw JSON.
-//
-// Static mode  → appends to committedText immediately, one draw call.
-// Anim mode    → allocates an in-flight slot, drips words via a goroutine.
-//
-// In both modes, any messages sent by the local user while this call is in
-// progress are appended to committedText and will NOT be lost.
-//
-// Safe to call from any goroutine.
-func (c *ChatView) AddIncomingMessage(username, content, colorTag string) {
-	log.Printf("TRACE AddIncomingMessage: ENTER user=%q color=%q content=%.80q", username, colorTag, content)
-
-	if atomic.LoadInt32(&c.stopped) == 1 {
-		log.Printf("TRACE AddIncomingMessage: view stopped, dropping msg from %q", username)
-		return
-	}
-
-	// Normalise and validate color tag.
-	// safeColorTag MUST run last — it rejects any tag that would crash tview.
-	if colorTag == "" {
-		colorTag = models.GetUsernameColor(username)
-	}
-	if !strings.HasPrefix(colorTag, "[") {
-		colorTag = models.ParseColorToTag(colorTag)
-	}
-	colorTag = safeColorTag(colorTag) // reject malformed tags from the server
-	log.Printf("TRACE AddIncomingMessage: normalised+validated colorTag=%q", colorTag)
-
-	words := strings.Fields(content)
-	log.Printf("TRACE AddIncomingMessage: word count=%d", len(words))
-	if len(words) == 0 {
-		return
-	}
-
-	prefix := incomingPrefix(colorTag, username)
-	log.Printf("TRACE AddIncomingMessage: prefix built, animMode=%d", atomic.LoadInt32(&c.animMode))
-
-	// ── STATIC mode ────────────────────────────────────────────────────────
-	if atomic.LoadInt32(&c.animMode) == 0 {
-		log.Printf("TRACE AddIncomingMessage: static mode, queuing draw for user=%q", username)
-		c.app.QueueUpdateDraw(func() {
-			log.Printf("TRACE static draw: ENTER event loop for user=%q", username)
-			if atomic.LoadInt32(&c.stopped) == 1 {
-				log.Printf("TRACE static draw: stopped, bailing")
-				return
-			}
-			defer func() {
-				if r := recover(); r != nil {
-					log.Printf("PANIC static draw (from %s): %v", username, r)
-				}
-			}()
-			sanitized := sanitizeContent(content)
-			log.Printf("TRACE static draw: sanitized content=%.80q", sanitized)
-			log.Printf("TRACE static draw: committedText len before=%d", len(c.committedText))
-			c.committedText += prefix + sanitized + "[-]\n" // prefix already ends with colorTag
-			log.Printf("TRACE static draw: committedText len after=%d inFlight count=%d", len(c.committedText), len(c.inFlight))
-			log.Printf("TRACE static draw: calling renderMessages")
-			c.renderMessages()
-			log.Printf("TRACE static draw: renderMessages returned")
-		})
-		log.Printf("TRACE AddIncomingMessage: static QueueUpdateDraw enqueued")
-		return
-	}
-
-	// ── ANIMATION mode ─────────────────────────────────────────────────────
-	// Step 1 (event loop): allocate an in-flight slot and paint the cursor
-	// immediately so the user sees activity straight away.
-	// idCh carries both the animID and the inFlightGen at allocation time.
-	// The animation goroutine uses gen to detect if ClearMessages() ran while
-	// it was mid-flight, so it can discard stale word-tick callbacks.
-	log.Printf("TRACE AddIncomingMessage: anim mode, allocating slot for user=%q", username)
-	type animSlot struct{ id, gen int }
-	slotCh := make(chan animSlot, 1)
-	c.app.QueueUpdateDraw(func() {
-		log.Printf("TRACE anim-init: ENTER event loop for user=%q", username)
-		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("PANIC anim-init (from %s): %v", username, r)
-				slotCh <- animSlot{-1, -1}
-			}
-		}()
-		if atomic.LoadInt32(&c.stopped) == 1 {
-			log.Printf("TRACE anim-init: stopped, sending -1 slot")
-			slotCh <- animSlot{-1, -1}
-			return
-		}
-		animID := c.nextAnimID
-		c.nextAnimID++
-		gen := c.inFlightGen
-		log.Printf("TRACE anim-init: allocated animID=%d gen=%d inFlight count=%d", animID, gen, len(c.inFlight))
-		c.inFlight[animID] = prefix + "[dim]▋[-]"
-		slotCh <- animSlot{animID, gen}
-		log.Printf("TRACE anim-init: calling renderMessages")
-		c.renderMessages()
-		log.Printf("TRACE anim-init: renderMessages returned, sent slot")
-	})
-	log.Printf("TRACE AddIncomingMessage: anim init QueueUpdateDraw enqueued")
-
-	// Step 2 (goroutine): drip words one at a time, updating only our slot.
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				log.Printf("PANIC word-anim goroutine (from %s): %v", username, r)
-			}
-		}()
-
-		log.Printf("TRACE anim-goroutine: waiting for slot user=%q", username)
-		slot := <-slotCh
-		log.Printf("TRACE anim-goroutine: got slot id=%d gen=%d user=%q", slot.id, slot.gen, username)
-		if slot.id < 0 || atomic.LoadInt32(&c.stopped) == 1 {
-			log.Printf("TRACE anim-goroutine: aborting (id=%d stopped=%d)", slot.id, atomic.LoadInt32(&c.stopped))
-			return
-		}
-		animID := slot.id
-		myGen := slot.gen
-
-		built := ""
-		for i, word := range words {
-			if atomic.LoadInt32(&c.stopped) == 1 {
-				return
-			}
-
-			// Variable delay: natural rhythm — short words fast, long ones slightly slower.
-			delay := time.Duration(55+len(word)*9) * time.Millisecond
-			if delay > 150*time.Millisecond {
-				delay = 150 * time.Millisecond
-			}
-			time.Sleep(delay)
-
-			if i == 0 {
-				built = word
-			} else {
-				built += " " + word
-			}
-			isLast := i == len(words)-1
-			snapshot := built
-
-			wordIdx := i
-			c.app.QueueUpdateDraw(func() {
-				log.Printf("TRACE word-tick: ENTER event loop animID=%d word[%d]=%q isLast=%v user=%q", animID, wordIdx, snapshot, isLast, username)
-				defer func() {
-					if r := recover(); r != nil {
-						log.Printf("PANIC word-anim draw (from %s): %v", username, r)
-					}
-				}()
-				if atomic.LoadInt32(&c.stopped) == 1 {
-					log.Printf("TRACE word-tick: stopped, bailing animID=%d", animID)
-					return
-				}
-				if c.inFlightGen != myGen {
-					log.Printf("TRACE word-tick: stale gen (mine=%d current=%d), bailing animID=%d", myGen, c.inFlightGen, animID)
-					return
-				}
-				sanitized := sanitizeContent(snapshot)
-				log.Printf("TRACE word-tick: sanitized=%.60q committedLen=%d inFlightCount=%d", sanitized, len(c.committedText), len(c.inFlight))
-				if isLast {
-					log.Printf("TRACE word-tick: LAST WORD — committing animID=%d", animID)
-					delete(c.inFlight, animID)
-					c.committedText += prefix + sanitized + "[-]\n"
-					log.Printf("TRACE word-tick: committed, new committedLen=%d", len(c.committedText))
-				} else {
-					c.inFlight[animID] = prefix + sanitized + " [dim]▋[-]"
-				}
-				log.Printf("TRACE word-tick: calling renderMessages animID=%d", animID)
-				c.renderMessages()
-				log.Printf("TRACE word-tick: renderMessages returned animID=%d", animID)
-			})
-		}
-	}()
-}
-
-// SetMessages bulk-loads a slice of messages without animation.
-// Replaces committedText entirely and clears any in-flight animations.
-func (c *ChatView) SetMessages(messages []*models.Message) {
-	if atomic.LoadInt32(&c.stopped) == 1 {
-		return
-	}
-	c.app.QueueUpdateDraw(func() {
-		if atomic.LoadInt32(&c.stopped) == 1 {
-			return
-		}
-		var b strings.Builder
-		for _, msg := range messages {
-			b.WriteString(formatLine(msg))
-		}
-		c.committedText = b.String()
-		c.inFlight = make(map[int]string) // discard any in-flight animations
-		c.renderMessages()
-	})
-}
-
-// ClearMessages wipes the message area and all in-flight animation state.
-// Must be called from the tview event loop.
-//
-// Bumping inFlightGen invalidates any word-tick callbacks that were already
-// queued when this runs — they check the generation and bail out rather than
-// writing to a map that has been replaced.
-func (c *ChatView) ClearMessages() {
-	c.committedText = ""
-	c.inFlight = make(map[int]string)
-	c.inFlightGen++ // invalidate all queued animation callbacks
-	c.renderMessages()
-}
-
-// ── Header ─────────────────────────────────────────────────────────────────
-
-func (c *ChatView) startClockTicker() {
-	go func() {
-		ticker := time.NewTicker(1 * time.Second)
-		defer ticker.Stop()
-		for range ticker.C {
-			if atomic.LoadInt32(&c.stopped) == 1 {
-				return
-			}
-			c.app.QueueUpdateDraw(func() {
-				if atomic.LoadInt32(&c.stopped) == 1 {
-					return
-				}
-				c.redrawHeader()
-			})
-		}
-	}()
-}
-
-// redrawHeader repaints the header content.
-//
-// Row 1:  [GLOBAL]  HH:MM:SS  @username    ●ONLINE/OFFLINE  LATENCY:Xms
-// Row 2:  msgs ▓▓▓▓▓░░░░░ 47/1000  │  ●●●○○ 3 active  │  0 waiting
-//
-// Must be called from within the tview event loop.
-func (c *ChatView) redrawHeader() {
-	clock := time.Now().Format("15:04:05")
-
-	// ── Row 1 ────────────────────────────────────────────────────────────────
-	onlineStr := "[red]● OFFLINE[-]"
-	if c.headerOnline {
-		onlineStr = "[green]● ONLINE[-]"
-	}
-
-	userStr := ""
-	if c.headerUsername != "" {
-		userStr = fmt.Sprintf("  [yellow]@%s[-]", c.headerUsername)
-	}
-
-	latencyColor := "green"
-	if c.headerLatency > 100 {
-		latencyColor = "yellow"
-	}
-	if c.headerLatency > 300 {
-		latencyColor = "red"
-	}
-	latencyStr := "[dim]ping: --ms[-]"
-	if c.headerLatency >= 0 {
-		latencyStr = fmt.Sprintf("[dim]ping: [%s]%dms[-][-]", latencyColor, c.headerLatency)
-	}
-
-	row1 := fmt.Sprintf("[cyan]◈ GLOBAL[-]  [dim]%s[-]%s    %s   %s",
-		clock, userStr, onlineStr, latencyStr)
-
-	// ── Row 2: live server stats ─────────────────────────────────────────────
-	// Active users: up to 5 colored dots, then "+N"
-	activeDots := ""
-	dotColors := []string{"green", "cyan", "yellow", "magenta", "blue"}
-	n := c.statsActive
-	if n > 5 {
-		for i := 0; i < 5; i++ {
-			activeDots += fmt.Sprintf("[%s]●[-]", dotColors[i])
-		}
-		activeDots += fmt.Sprintf("[dim]+%d[-]", n-5)
-	} else {
-		for i := 0; i < 5; i++ {
-			if i < n {
-				activeDots += fmt.Sprintf("[%s]●[-]", dotColors[i])
-			} else {
-				activeDots += "[dim]○[-]"
-			}
-		}
-	}
-
-	waitColor := "dim"
-	if c.statsWaiting > 0 {
-		waitColor = "cyan"
-	}
-
-	row2 := fmt.Sprintf(
-		"[dim]total msgs: [-][cyan]%d[-]   [dim]│[-]   %s [dim]%d active[-]   [dim]│   [%s]%d waiting[-][-]",
-		c.statsTotalMsgs,
-		activeDots, c.statsActive,
-		waitColor, c.statsWaiting,
-	)
-
-	c.header.SetText(row1 + "\n" + row2)
-}
-
-// UpdateStats refreshes the server stats displayed in the header and footer.
-// Safe to call from any goroutine.
-func (c *ChatView) UpdateStats(totalMsgs, active, waiting, maxMsgs, maxWaiters int, serverURL string) {
-	if atomic.LoadInt32(&c.stopped) == 1 {
-		return
-	}
-	c.app.QueueUpdateDraw(func() {
-		if atomic.LoadInt32(&c.stopped) == 1 {
-			return
-		}
-		c.statsTotalMsgs = totalMsgs
-		c.statsActive = active
-		c.statsWaiting = waiting
-		c.statsMaxMsgs = maxMsgs
-		c.statsMaxWaiters = maxWaiters
-		if serverURL != "" {
-			c.statsServerURL = serverURL
-		}
-		c.redrawHeader()
-		c.redrawFooter()
-	})
-}
-
-// SetCurrentUser pushes the logged-in username to the header.
-// Must be called from the tview event loop.
-func (c *ChatView) SetCurrentUser(username string) {
-	c.headerUsername = username
-	c.redrawHeader()
-}
-
-// SetOnlineStatus updates the ●ONLINE/●OFFLINE indicator in the header.
-//
-// MUST be called from within the tview event loop (i.e. from inside a
-// QueueUpdateDraw callback). It does NOT call QueueUpdateDraw itself —
-// doing so from inside an existing callback would nest queue calls and
-// deadlock tview's updates channel on Windows.
-func (c *ChatView) SetOnlineStatus(online bool) {
-	if atomic.LoadInt32(&c.stopped) == 1 {
-		return
-	}
-	c.headerOnline = online
-	c.redrawHeader()
-}
-
-// SetOnlineStatusAsync updates the online indicator from any goroutine.
-// Use this ONLY when NOT already inside a QueueUpdateDraw callback.
-func (c *ChatView) SetOnlineStatusAsync(online bool) {
-	if atomic.LoadInt32(&c.stopped) == 1 {
-		return
-	}
-	c.app.QueueUpdateDraw(func() {
-		if atomic.LoadInt32(&c.stopped) == 1 {
-			return
-		}
-		c.headerOnline = online
-		c.redrawHeader()
-	})
-}
-
-// UpdateLatency updates the latency shown in the header.
-// Safe to call from any goroutine.
-func (c *ChatView) UpdateLatency(latency int) {
-	if atomic.LoadInt32(&c.stopped) == 1 {
-		return
-	}
-	c.app.QueueUpdateDraw(func() {
-		if atomic.LoadInt32(&c.stopped) == 1 {
-			return
-		}
-		c.headerLatency = latency
-		c.redrawHeader()
-	})
-}
-
-// ── Command bar ───────────────────────────────────────────────────────────
-
-func (c *ChatView) redrawCommandBar() {
-	modeLabel := "[dim]mode:[green]ANIM[-]"
-	if atomic.LoadInt32(&c.animMode) == 0 {
-		modeLabel = "[dim]mode:[cyan]STATIC[-]"
-	}
-	nickLabel := ""
-	if c.nickActive {
-		nickLabel = "  [cyan]nick:ON ←→[-]"
-	}
-	c.commandBar.SetText(fmt.Sprintf(
-		"[dim]/ commands: clear  whois  nick  mode  user_color  latency  info  exit  help[-]   %s%s",
-		modeLabel, nickLabel,
-	))
-	c.redrawFooter() // keep mode label in footer in sync
-}
-
-// redrawFooter repaints the bottom status bar with secondary server info.
-// Must be called from within the tview event loop.
-func (c *ChatView) redrawFooter() {
-	if c.footer == nil {
-		return // called before buildUI() finished initializing c.footer
-	}
-
-	modeLabel := "[cyan]ANIM[-]"
-	if atomic.LoadInt32(&c.animMode) == 0 {
-		modeLabel = "[green]STATIC[-]"
-	}
-
-	url := c.statsServerURL
-	if url == "" {
-		url = "localhost:8034"
-	}
-
-	c.footer.SetText(fmt.Sprintf(
-		"[dim]server:[cyan]%s[-]  [dim]│  mode:%s[-]  [dim]│[-]  [magenta]SecTherminal v1.0[-]",
-		url, modeLabel,
-	))
-}
-
-// ── Animation mode ────────────────────────────────────────────────────────
-
-func (c *ChatView) SetAnimationMode(anim bool) {
-	if anim {
-		atomic.StoreInt32(&c.animMode, 1)
-	} else {
-		atomic.StoreInt32(&c.animMode, 0)
-	}
-	c.redrawCommandBar()
-}
-
-func (c *ChatView) ToggleAnimationMode() string {
-	if atomic.LoadInt32(&c.animMode) == 1 {
-		atomic.StoreInt32(&c.animMode, 0)
-		c.redrawCommandBar()
-		return "static"
-	}
-	atomic.StoreInt32(&c.animMode, 1)
-	c.redrawCommandBar()
-	return "animation"
-}
-
-func (c *ChatView) IsAnimationMode() bool {
-	return atomic.LoadInt32(&c.animMode) == 1
-}
-
-// ── Nick mode ─────────────────────────────────────────────────────────────
-
-func (c *ChatView) ToggleNickMode() bool {
-	c.nickActive = !c.nickActive
-	c.historyIdx = -1
-	c.redrawCommandBar()
-	return c.nickActive
-}
-
-func (c *ChatView) AddToHistory(msg string) {
-	if msg == "" {
-		return
-	}
-	if len(c.sentHistory) > 0 && c.sentHistory[len(c.sentHistory)-1] == msg {
-		return
-	}
-	c.sentHistory = append(c.sentHistory, msg)
-	if len(c.sentHistory) > 100 {
-		c.sentHistory = c.sentHistory[1:]
-	}
-}
-
-// ── Footer ────────────────────────────────────────────────────────────────
-
-func (c *ChatView) UpdateCursorPosition(line, col int) {
-	if atomic.LoadInt32(&c.stopped) == 1 {
-		return
-	}
-	c.app.QueueUpdateDraw(func() {
-		if atomic.LoadInt32(&c.stopped) == 1 {
-			return
-		}
-		c.footer.SetText(fmt.Sprintf(
-			"[magenta]NORMAL[-]    SecTherminal              UTF-8    L:%d, C:%d", line, col,
-		))
-	})
-}
-
-// Stop signals this view is permanently done. No further UI updates will run.
-func (c *ChatView) Stop() {
-	atomic.StoreInt32(&c.stopped, 1)
-}
+package views
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"cli-client/models"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// DebugLogFile is set by main so renderMessages can flush before SetText.
+// A hard crash inside tview.SetText would otherwise lose the last log lines
+// since they're buffered. Flush guarantees the trace is on disk.
+var DebugLogFile *os.File
+
+type ChatView struct {
+	app           *tview.Application
+	root          *tview.Pages // container, with the paste viewer on top while open
+	container     *tview.Flex
+	header        *tview.TextView
+	messageView   *tview.TextView
+	userList      *tview.TextView
+	inputField    *composerField
+	footer        *tview.TextView
+	commandBar    *tview.TextView
+	typingBar     *tview.TextView
+	bannerBar     *tview.TextView // security warning under the header; hidden when empty
+	pinBar        *tview.TextView // newest pinned message under the header; hidden without pins
+	onSendMessage func(string)
+	onCommand     func(string)
+	onTyping      func(string)
+
+	stopped  int32 // atomic: 1 = stopped
+	animMode int32 // atomic: 1 = word-by-word, 0 = static
+	bell     int32 // atomic: 1 = ring the terminal bell after the next draw
+	notifier *terminalNotifier
+
+	// highlighter is set once before the chat screen opens and only read
+	// afterwards, so the animation goroutines may use it without locking.
+	highlighter *models.Highlighter
+	prefix      *PrefixTemplate      // nil = built-in "[HH:MM] [user]" layout
+	theme       *Theme               // see SetTheme; never nil
+	stats       *models.SessionStats // counts messages dropped here; may be nil
+
+	// Header state — only touched inside tview event loop
+	headerUsername string
+	headerLatency  int
+	headerOnline   bool
+	headerLock     string // encryption indicator, see SetEncryption
+	headerKey      string // key fingerprint, "" = built-in; see SetKeyFingerprint
+	headerKeySet   bool
+	headerRoom     string // room label, "" = GLOBAL; see SetRoom
+	headerAccent   string // room accent color name, "" = cyan
+
+	// Server stats — updated by UpdateStats(), only in tview event loop
+	statsTotalMsgs  int
+	statsActive     int
+	statsWaiting    int
+	statsMaxMsgs    int
+	statsMaxWaiters int
+	statsServerURL  string
+
+	// Typing indicator — only touched inside tview event loop
+	typingNames []string
+	typingUntil time.Time
+
+	// Online-users sidebar — only touched inside tview event loop
+	onlineUsers []*models.User // nil until the first presence list
+	spinFrame   int            // advanced by the clock ticker
+
+	// Scroll-lock — see scrollback.go. Only touched inside tview event loop.
+	scrolledBack bool // the messages are scrolled up; followEnd leaves them
+	unread       int  // messages that arrived since
+
+	// Nick mode / message history — only touched inside tview event loop
+	nickActive   bool
+	sentHistory  []string // oldest first, at most MaxSentHistory
+	historyIdx   int      // -1 = not browsing
+	historyDraft string   // what was typed before browsing started
+
+	// Snippets — see snippet.go. snippets is set before the chat screen
+	// opens; snipFields only touched inside tview event loop.
+	snippets   models.Snippets
+	snipFields []int // {} fields still to fill, in runes from the end of the text
+
+	// Emoji — see emoji.go. emojiOff is set before the chat screen opens;
+	// emojiMatches only touched inside tview event loop.
+	emojiOff     bool
+	emojiMatches []models.EmojiCompletion // what the popup offers; nil = closed
+
+	formattingOff bool // see inline.go; set before the chat screen opens
+
+	// Image previews — see image.go. imageStyle is set before the chat
+	// screen opens; the rest only touched inside tview event loop.
+	imageStyle  ImageStyle
+	previews    map[string]*preview // by message ID
+	previewAt   map[int]*preview    // by preview index
+	nextPreview int
+	nextKittyID uint32
+	imageOut    []string    // sequences for the next draw, e.g. deletions
+	imageScreen image.Point // the screen size the pictures were drawn for
+	imagesStale bool        // the screen was redrawn; draw the pictures again
+
+	// ── Message render model ──────────────────────────────────────────────
+	// All fields below are ONLY ever read/written from inside QueueUpdateDraw
+	// (i.e. the tview event loop), so no mutex is needed.
+	//
+	// Design: the visible text is always:
+	//   lines (oldest first)  +  inFlight[0] + inFlight[1] + ...   (by insertion order)
+	//
+	// AddMessage      → appends a fully-formatted line to lines.
+	// UpdateMessage   → re-formats one stored line in place (delivery state).
+	// Animation start → allocates an inFlight slot (animID), re-renders.
+	// Animation tick  → updates the slot text, re-renders.
+	// Animation end   → moves final line from slot into lines.
+	//
+	// Because AddMessage only touches lines (never overwrites inFlight),
+	// and animations only touch their own slot, messages never clobber each other.
+	//
+	// Rendering is incremental: while no animation text is on screen, a new
+	// line is written to the end of messageView instead of rebuilding it.
+	// lines is capped at ScrollbackLines; the evicted lines still shown at the
+	// top of messageView are dropped by an occasional full rebuild, so both
+	// the per-message cost and memory stay flat in busy rooms.
+	lines       *lineStore
+	shownAnim   bool           // messageView ends with in-flight animation text
+	staleLines  int            // evicted lines still present in messageView
+	inFlight    map[int]string // animID → current partial line (with trailing cursor)
+	nextAnimID  int            // monotonically increasing; never resets
+	inFlightGen int            // incremented by ClearMessages; stale callbacks bail out
+}
+
+func NewChatView(
+	app *tview.Application,
+	onSendMessage func(string),
+	onCommand func(string),
+	onTyping func(string),
+) *ChatView {
+	c := &ChatView{
+		app:             app,
+		onSendMessage:   onSendMessage,
+		onCommand:       onCommand,
+		onTyping:        onTyping,
+		historyIdx:      -1,
+		headerLatency:   18,
+		headerOnline:    true,
+		inFlight:        make(map[int]string),
+		lines:           newLineStore(ScrollbackLines),
+		statsMaxMsgs:    1000,
+		statsMaxWaiters: 1000,
+		statsServerURL:  "localhost:8034",
+		theme:           themes[DefaultTheme],
+		notifier:        newTerminalNotifier(),
+		imageStyle:      ImageBlocks,
+		previews:        make(map[string]*preview),
+		previewAt:       make(map[int]*preview),
+	}
+	// Default to STATIC mode. Animation mode (word-by-word) involves a
+	// goroutine that reads from a channel while holding a QueueUpdateDraw
+	// slot — if that path is the crash source, static mode will stay stable.
+	// Users can switch with /mode animation once confirmed working.
+	atomic.StoreInt32(&c.animMode, 0)
+	c.buildUI()
+	c.startClockTicker()
+	// Pastes come in whole, line breaks and all; see codeblock.go.
+	app.EnablePaste(true)
+	// tview only hands out the screen during a draw, so a highlight rule with
+	// notify set raises c.bell and the beep happens after the next frame.
+	// Terminal notifications wait for it the same way.
+	app.SetAfterDrawFunc(func(screen tcell.Screen) {
+		if atomic.CompareAndSwapInt32(&c.bell, 1, 0) {
+			screen.Beep()
+		}
+		c.notifier.flush(screen)
+		c.drawImages(screen)
+	})
+	return c
+}
+
+// Beep rings the terminal bell after the next draw, e.g. for a mention.
+// Safe to call from any goroutine.
+func (c *ChatView) Beep() {
+	atomic.StoreInt32(&c.bell, 1)
+}
+
+// Notify rings the bell and raises a terminal notification (see notify.go)
+// after the next draw. title and body are plain text, not tview markup.
+// Safe to call from any goroutine.
+func (c *ChatView) Notify(title, body string) {
+	c.notifier.queue(title, body)
+	atomic.StoreInt32(&c.bell, 1)
+}
+
+// SetNotifyStyle picks the escape sequence Notify uses; NotifyOff leaves
+// just the bell.
+func (c *ChatView) SetNotifyStyle(style NotifyStyle) {
+	c.notifier.setStyle(style)
+}
+
+// SetPrefixTemplate switches the line layout to a user template; nil restores
+// the built-in one. Call it before the chat screen is shown.
+func (c *ChatView) SetPrefixTemplate(t *PrefixTemplate) {
+	c.prefix = t
+}
+
+// SetHighlighter installs the compiled highlight rules applied to incoming
+// messages. Call it before the chat screen is shown.
+func (c *ChatView) SetHighlighter(h *models.Highlighter) {
+	c.highlighter = h
+}
+
+// SetSessionStats is where the view counts messages it drops because it was
+// stopped or cleared before they were drawn. Call it before the chat screen
+// is shown.
+func (c *ChatView) SetSessionStats(s *models.SessionStats) {
+	c.stats = s
+}
+
+// noteDropped counts one incoming message that never reached the screen.
+// Safe to call from any goroutine.
+func (c *ChatView) noteDropped(reason string) {
+	if c.stats != nil {
+		c.stats.RecordDropped(reason, 1)
+	}
+}
+
+func (c *ChatView) Primitive() tview.Primitive      { return c.root }
+func (c *ChatView) InputPrimitive() tview.Primitive { return c.inputField }
+func (c *ChatView) GetPrimitive() tview.Primitive   { return c.root }
+
+// ── UI construction ────────────────────────────────────────────────────────
+
+func (c *ChatView) buildUI() {
+	// Header — bordered box in the theme's border color, see SetTheme.
+	// Height 3 in the flex (1 top border + 1 content line + 1 bottom border).
+	c.header = tview.NewTextView()
+	c.header.SetDynamicColors(true)
+	c.header.SetTextAlign(tview.AlignLeft)
+	c.header.SetBorder(true)
+	c.header.SetBorderPadding(0, 0, 1, 1)
+
+	c.messageView = tview.NewTextView()
+	c.messageView.SetDynamicColors(true)
+	c.messageView.SetScrollable(true)
+	c.messageView.SetWordWrap(true)
+	c.messageView.SetText("")
+
+	// Online-users sidebar — filled by SetOnlineUsers from /api/presence.
+	c.userList = tview.NewTextView()
+	c.userList.SetDynamicColors(true)
+	c.userList.SetScrollable(true)
+	c.userList.SetWrap(false)
+	c.userList.SetBorder(true)
+	c.userList.SetTitle(" online ")
+	c.userList.SetText("[dim]…[-]")
+
+	c.commandBar = tview.NewTextView()
+	c.commandBar.SetDynamicColors(true)
+	c.commandBar.SetTextAlign(tview.AlignLeft)
+	c.redrawCommandBar()
+
+	c.inputField = &composerField{tview.NewInputField()}
+	c.inputField.SetLabel("  > ")
+	c.inputField.SetPlaceholder("Type a message or /command...")
+	c.inputField.SetChangedFunc(func(text string) {
+		if c.expandTypedShortcode(text) {
+			return // SetText called this again with the emoji
+		}
+		// Recalling sent history is not typing.
+		if c.onTyping != nil && c.historyIdx < 0 {
+			c.onTyping(text)
+		}
+	})
+	c.inputField.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEnter {
+			text := c.inputField.GetText()
+			if text != "" {
+				if strings.HasPrefix(text, "/") {
+					c.onCommand(text)
+				} else {
+					c.onSendMessage(c.expandEmoji(composedText(text)))
+				}
+				// A command may have filled the field in (/snip); keep that.
+				if c.inputField.GetText() == text {
+					c.inputField.SetText("")
+					c.snipFields = nil
+				}
+				c.historyIdx = -1
+				c.historyDraft = ""
+				c.jumpToEnd()
+			}
+		}
+	})
+
+	// ── Arrow-key capture for sent-message history ─────────────────────────
+	// ↑ / ↓ always step through the sent history, like a shell; the text
+	// being typed is kept and comes back past the newest entry.
+	// Nick mode additionally binds ← / →, but only when the field is empty
+	// OR already in history, so normal cursor movement still works while
+	// typing fresh text.
+	c.inputField.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if c.emojiPopupOpen() {
+			switch event.Key() {
+			case tcell.KeyUp, tcell.KeyDown, tcell.KeyPgUp, tcell.KeyPgDn:
+				return event // moves through the popup
+			case tcell.KeyEscape:
+				c.emojiMatches = nil
+				return event // closes it
+			}
+		}
+		switch event.Key() {
+		case tcell.KeyUp:
+			c.historyPrev()
+			return nil
+		case tcell.KeyDown:
+			c.historyNext()
+			return nil
+		case tcell.KeyTab:
+			if c.snippetTab() {
+				return nil
+			}
+		}
+		if c.handleScrollKey(event) {
+			return nil
+		}
+		if !c.nickActive {
+			return event
+		}
+		if c.inputField.GetText() != "" && c.historyIdx < 0 {
+			return event // editing a fresh message — let cursor move
+		}
+		switch event.Key() {
+		case tcell.KeyLeft:
+			c.historyPrev()
+			return nil // consumed
+		case tcell.KeyRight:
+			c.historyNext()
+			return nil // consumed
+		}
+		return event
+	})
+
+	// Security warning line under the header — collapsed until ShowBanner.
+	c.bannerBar = tview.NewTextView()
+	c.bannerBar.SetDynamicColors(true)
+	c.bannerBar.SetBackgroundColor(tcell.ColorDarkRed)
+
+	// Pinned message strip under the header — collapsed until SetPins.
+	c.pinBar = tview.NewTextView()
+	c.pinBar.SetDynamicColors(true)
+
+	// "alice is typing…" line right above the input — collapsed to zero
+	// height while nobody types, see redrawTyping.
+	c.typingBar = tview.NewTextView()
+	c.typingBar.SetDynamicColors(true)
+
+	c.footer = tview.NewTextView()
+	c.footer.SetDynamicColors(true)
+	c.footer.SetTextAlign(tview.AlignLeft)
+	// initial content drawn after stats fields are set
+	c.redrawFooter()
+
+	c.container = tview.NewFlex()
+	c.container.SetDirection(tview.FlexRow)
+	c.container.AddItem(c.header, 5, 0, false) // 5 = border top + 2 content lines + border bottom
+	c.container.AddItem(c.bannerBar, 0, 0, false)
+	c.container.AddItem(c.pinBar, 0, 0, false)
+	body := tview.NewFlex()
+	body.SetDirection(tview.FlexColumn)
+	body.AddItem(c.messageView, 0, 1, false)
+	body.AddItem(c.userList, 20, 0, false)
+	c.container.AddItem(body, 0, 1, false)
+	c.container.AddItem(c.commandBar, 1, 0, false)
+	c.container.AddItem(c.typingBar, 0, 0, false)
+	c.container.AddItem(c.inputField, 3, 0, true)
+	c.container.AddItem(c.footer, 1, 0, false)
+
+	c.root = tview.NewPages()
+	c.root.AddPage("chat", c.container, true, true)
+
+	c.setupMouse()
+	c.setupEmoji()
+	c.setupScrollLock()
+	c.SetTheme(c.theme)
+}
+
+// ── Message render engine ──────────────────────────────────────────────────
+
+// sanitizeContent escapes raw user-supplied text for safe rendering inside
+// a tview TextView with SetDynamicColors(true).
+//
+// tview treats anything matching `[word]` as a color/style tag. User messages
+// can contain arbitrary `[` characters (URLs, code snippets, IRC nicks like
+// `[nick]`). An unmatched or unrecognised `[` sequence causes tview to panic
+// with an index-out-of-bounds — a fatal error that recover() cannot catch.
+//
+// The fix: replace every `[` in user content with `[[]` (tview's own escape
+// for a literal `[`). We do NOT escape color tags we intentionally construct
+// in format strings — only raw content that came from outside the app.
+// Emoji tview and tcell measure differently are redrawn too (see fitCells).
+func sanitizeContent(s string) string {
+	return strings.ReplaceAll(fitCells(s), "[", "[[]")
+}
+
+// safeColorTag validates that a color tag from external sources is well-formed
+// before inserting it raw into a tview format string.
+//
+// A valid tview color tag must:
+//   - Start with "["
+//   - End with "]"
+//   - Contain no nested "[" that would start a second tag
+//
+// Anything that doesn't satisfy these rules is replaced with "[white]" so we
+// never hand tview a malformed tag that would cause a fatal index panic.
+func safeColorTag(tag string) string {
+	if len(tag) < 3 {
+		return "[white]"
+	}
+	if tag[0] != '[' || tag[len(tag)-1] != ']' {
+		return "[white]"
+	}
+	// Must not contain a second "[" inside (would nest tags)
+	inner := tag[1 : len(tag)-1]
+	if strings.ContainsAny(inner, "[]") {
+		return "[white]"
+	}
+	return tag
+}
+
+// renderMessages rebuilds the messageView from the stored lines plus all
+// active in-flight animation lines. Must always be called from the tview event loop.
+func (c *ChatView) renderMessages() {
+	log.Printf("TRACE renderMessages: lines=%d inFlightCount=%d nextAnimID=%d",
+		c.lines.Len(), len(c.inFlight), c.nextAnimID)
+	var b strings.Builder
+	c.lines.AppendTo(&b)
+	for i := 0; i < c.nextAnimID; i++ {
+		if line, ok := c.inFlight[i]; ok {
+			b.WriteString(line)
+		}
+	}
+	text := b.String()
+	c.shownAnim = len(c.inFlight) > 0
+	c.staleLines = 0
+	log.Printf("TRACE renderMessages: total text len=%d calling SetText", len(text))
+	// Flush to disk BEFORE SetText — if tview crashes inside SetText (e.g. from
+	// a bad color tag sequence we missed), the log is already on disk.
+	if DebugLogFile != nil {
+		DebugLogFile.Sync()
+	}
+	c.messageView.SetText(text)
+	c.imagesStale = true
+	log.Printf("TRACE renderMessages: SetText done, calling followEnd")
+	c.followEnd()
+	log.Printf("TRACE renderMessages: DONE")
+}
+
+// appendLine stores a formatted line and shows it. When the view ends with
+// committed text only, the line is simply written to the end of messageView;
+// otherwise (animation text on screen, or too many evicted lines still
+// shown) the view is rebuilt. Must be called from the tview event loop.
+func (c *ChatView) appendLine(id, text string) {
+	if c.lines.Append(id, text) {
+		c.staleLines++
+	}
+	if c.shownAnim || c.staleLines > ScrollbackLines/4 {
+		c.renderMessages()
+		return
+	}
+	if DebugLogFile != nil {
+		DebugLogFile.Sync()
+	}
+	c.messageView.Write([]byte(text))
+	c.followEnd()
+}
+
+// ── Message formatting ────────────────────────────────────────────────────
+
+// formatLine renders a Message into a tview-tagged string.
+//
+// Output format:   [HH:MM] [username] message body
+// (or the user's prefix template, see SetPrefixTemplate)
+//
+// Both the username label (in brackets) and the message content share the
+// same color so the entire line visually "belongs" to that user.
+// [[] is tview's escape sequence for a literal "[" character.
+func (c *ChatView) formatLine(msg *models.Message) string {
+	if msg.IsSystem {
+		// System messages are trusted internal strings — they may contain tview
+		// color markup like [cyan]name[-] intentionally. Do NOT sanitize them.
+		return fmt.Sprintf("[%s]▸ %s[-]\n", c.theme.System, msg.Content) + c.formatPreview(msg.ID, msg.Image)
+	}
+	color := c.theme.userColor(safeColorTag(msg.Color))
+	safeContent := c.renderBody(msg.DisplayText(), color, false)
+	safeContent += deliveryMarker(msg.Delivery) + senderMarker(msg.Sender)
+	quote := ""
+	if msg.Quote != nil {
+		quote = c.quoteLine(msg.Quote)
+	}
+	if msg.Action {
+		// "* alice waves" — no time or name prefix, dim italics.
+		return quote + fmt.Sprintf("%s[::di]* %s %s[-::-]\n",
+			color, userRegion(msg.Username, sanitizeContent(msg.Username)), safeContent)
+	}
+	if c.prefix != nil {
+		return quote + c.prefix.Render(msg.Timestamp, msg.Username, color, c.theme.Muted) + safeContent + "[-]\n"
+	}
+	ts := msg.FormatTime()
+	safeUser := sanitizeContent(msg.Username) // escapes [ inside username
+	// [ts] and [username] are NOT valid tview color names so tview passes them
+	// through as literal bracket-wrapped text — no [[] escaping needed.
+	// [%s] for timestamp → passes through (digits+colon = never a color name)
+	// [[]%s] for username → [[] is tview escape for literal "[", so output is [username]
+	return quote + fmt.Sprintf("[%s][%s][-] %s[-] %s%s[-]\n",
+		c.theme.Muted, ts, userRegion(msg.Username, color+"[[]"+safeUser+"]"), color, safeContent)
+}
+
+// quoteLine is the dim line above a reply showing the message it answers,
+// or saying it answers one this client never saw.
+func (c *ChatView) quoteLine(q *models.Quote) string {
+	if q.Username == "" {
+		return fmt.Sprintf("[%s::d]  ┌ reply to an earlier message[-::-]\n", c.theme.Muted)
+	}
+	return fmt.Sprintf("[%s::d]  ┌ %s: %s[-::-]\n", c.theme.Muted, sanitizeContent(q.Username), sanitizeContent(q.Text))
+}
+
+// reactionLine is the dim line under a message counting its reactions,
+// "👍 3  🎉 1", or "" without any.
+func (c *ChatView) reactionLine(reactions []models.Reaction) string {
+	if len(reactions) == 0 {
+		return ""
+	}
+	counts := make([]string, len(reactions))
+	for i, r := range reactions {
+		counts[i] = fmt.Sprintf("%s %d", sanitizeContent(r.Emoji), len(r.Users))
+	}
+	return fmt.Sprintf("[%s::d]  %s[-::-]\n", c.theme.Muted, strings.Join(counts, "  "))
+}
+
+// senderMarker flags an incoming message whose sender could not be
+// verified. It starts with [-] to close the content color.
+func senderMarker(check models.SenderCheck) string {
+	switch check {
+	case models.SenderUnsigned:
+		return "[-] [dim]? unverified[-]"
+	case models.SenderForged:
+		return "[-] [red::b]⚠ forged signature — may not be from this user[-::-]"
+	}
+	return ""
+}
+
+// deliveryMarker is the glyph appended to an own message for its delivery
+// state. It starts with [-] to close the content color.
+func deliveryMarker(state models.DeliveryState) string {
+	switch state {
+	case models.DeliverySending:
+		return "[-] [dim]◷[-]"
+	case models.DeliveryQueued:
+		return "[-] [dim]⧗ queued[-]"
+	case models.DeliverySent:
+		return "[-] [green]✓[-]"
+	case models.DeliveryFailed:
+		return "[-] [red]✗ not sent — /retry[-]"
+	}
+	return ""
+}
+
+// highlightContent sanitizes content and wraps every span matched by the
+// highlight rules in the rule's color, falling back to colorTag afterwards
+// so the rest of the line keeps the sender's color. Inline formatting and
+// code blocks are rendered too (see inline.go and codeblock.go).
+func (c *ChatView) highlightContent(content, colorTag string) string {
+	return c.renderBody(content, colorTag, true)
+}
+
+// incomingPrefix builds the formatted prefix for an incoming message line.
+//
+// We do NOT escape [ with [[] here. tview passes unrecognised tags (those
+// whose content is not a valid color name) through as literal text.
+// [10:48] and [username] are never valid tview colors, so they display as-is.
+// Real color directives like [red] and [-] work as normal.
+func (c *ChatView) incomingPrefix(colorTag, username string) string {
+	if c.prefix != nil {
+		return c.prefix.Render(time.Now(), username, colorTag, c.theme.Muted)
+	}
+	ts := time.Now().Format("15:04")
+	safeUser := sanitizeContent(username) // escapes any [ inside the username itself
+	return fmt.Sprintf("[%s][%s][-] %s[-] %s",
+		c.theme.Muted, ts, userRegion(username, colorTag+"[[]"+safeUser+"]"), colorTag)
+}
+
+// ── Public message API ────────────────────────────────────────────────────
+
+// AddMessage displays a message instantly (own messages, system messages).
+// Must be called from the tview event loop.
+//
+// By appending to lines (never to the raw messageView text), we
+// guarantee the message survives any concurrent animation redraws.
+func (c *ChatView) AddMessage(msg *models.Message) {
+	c.appendLine(msg.ID, c.formatLine(msg))
+}
+
+// UpdateMessage re-renders the line of a message shown earlier with
+// AddMessage or SetMessages, e.g. after its delivery state changed.
+// Unknown messages (cleared meanwhile) are ignored.
+// Must be called from the tview event loop.
+func (c *ChatView) UpdateMessage(msg *models.Message) {
+	if c.lines.Update(msg.ID, c.formatLine(msg)) {
+		c.renderMessages()
+	}
+}
+
+// SetReactions shows reactions under the line of the message with id,
+// replacing those shown before. Unknown messages are ignored, as by
+// UpdateMessage. Must be called from the tview event loop.
+func (c *ChatView) SetReactions(id string, reactions []models.Reaction) {
+	if c.lines.SetUnder(id, c.reactionLine(reactions)) {
+		c.renderMessages()
+	}
+}
+
+// AddIncomingMessage displays the message with id from another user,
+// marked if its sender could not be verified (see senderMarker). A reply
+// passes the message it answers as quote (see quoteLine); others pass nil.
+// The id makes the line's reactions settable (SetReactions); "" for none.
+//
+//	colorTag — tview color tag from the wire format, e.g. "[green]" or "[#ff00ff]".
+//	           Pass through models.ParseColorToTag if converting from raw JSON.
+//
+// Static mode  → appends to lines immediately, one draw call.
+// Anim mode    → allocates an in-flight slot, drips words via a goroutine.
+//
+// In both modes, any messages sent by the local user while this call is in
+// progress are appended to lines and will NOT be lost.
+//
+// Safe to call from any goroutine.
+func (c *ChatView) AddIncomingMessage(id, username, content, colorTag string, check models.SenderCheck, quote *models.Quote) {
+	log.Printf("TRACE AddIncomingMessage: ENTER user=%q color=%q content=%.80q", username, colorTag, content)
+
+	if atomic.LoadInt32(&c.stopped) == 1 {
+		log.Printf("TRACE AddIncomingMessage: view stopped, dropping msg from %q", username)
+		c.noteDropped(models.DropViewStopped)
+		return
+	}
+
+	// Normalise and validate color tag.
+	// safeColorTag MUST run last — it rejects any tag that would crash tview.
+	if colorTag == "" {
+		colorTag = models.GetUsernameColor(username)
+	}
+	if !strings.HasPrefix(colorTag, "[") {
+		colorTag = models.ParseColorToTag(colorTag)
+	}
+	colorTag = safeColorTag(colorTag) // reject malformed tags from the server
+	colorTag = c.theme.userColor(colorTag)
+	log.Printf("TRACE AddIncomingMessage: normalised+validated colorTag=%q", colorTag)
+
+	words := strings.Fields(content)
+	log.Printf("TRACE AddIncomingMessage: word count=%d", len(words))
+	if len(words) == 0 {
+		return
+	}
+
+	prefix := c.incomingPrefix(colorTag, username)
+	if quote != nil {
+		prefix = c.quoteLine(quote) + prefix
+	}
+	marker := senderMarker(check)
+	log.Printf("TRACE AddIncomingMessage: prefix built, animMode=%d", atomic.LoadInt32(&c.animMode))
+
+	if _, notify := c.highlighter.Match(content); notify {
+		log.Printf("TRACE AddIncomingMessage: highlight rule with notify matched user=%q", username)
+		atomic.StoreInt32(&c.bell, 1)
+	}
+
+	// ── STATIC mode ────────────────────────────────────────────────────────
+	// Word by word would lose the line breaks, so text with any is static.
+	if atomic.LoadInt32(&c.animMode) == 0 || strings.Contains(content, "\n") {
+		log.Printf("TRACE AddIncomingMessage: static mode, queuing draw for user=%q", username)
+		c.app.QueueUpdateDraw(func() {
+			log.Printf("TRACE static draw: ENTER event loop for user=%q", username)
+			if atomic.LoadInt32(&c.stopped) == 1 {
+				log.Printf("TRACE static draw: stopped, bailing")
+				c.noteDropped(models.DropViewStopped)
+				return
+			}
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("PANIC static draw (from %s): %v", username, r)
+				}
+			}()
+			sanitized := c.highlightContent(content, colorTag)
+			log.Printf("TRACE static draw: sanitized content=%.80q", sanitized)
+			log.Printf("TRACE static draw: lines=%d inFlight count=%d", c.lines.Len(), len(c.inFlight))
+			c.noteUnread()
+			c.appendLine(id, prefix+sanitized+marker+"[-]\n") // prefix already ends with colorTag
+			log.Printf("TRACE static draw: appendLine returned")
+		})
+		log.Printf("TRACE AddIncomingMessage: static QueueUpdateDraw enqueued")
+		return
+	}
+
+	// ── ANIMATION mode ─────────────────────────────────────────────────────
+	// Step 1 (event loop): allocate an in-flight slot and paint the cursor
+	// immediately so the user sees activity straight away.
+	// idCh carries both the animID and the inFlightGen at allocation time.
+	// The animation goroutine uses gen to detect if ClearMessages() ran while
+	// it was mid-flight, so it can discard stale word-tick callbacks.
+	log.Printf("TRACE AddIncomingMessage: anim mode, allocating slot for user=%q", username)
+	type animSlot struct{ id, gen int }
+	slotCh := make(chan animSlot, 1)
+	c.app.QueueUpdateDraw(func() {
+		log.Printf("TRACE anim-init: ENTER event loop for user=%q", username)
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("PANIC anim-init (from %s): %v", username, r)
+				slotCh <- animSlot{-1, -1}
+			}
+		}()
+		if atomic.LoadInt32(&c.stopped) == 1 {
+			log.Printf("TRACE anim-init: stopped, sending -1 slot")
+			c.noteDropped(models.DropViewStopped)
+			slotCh <- animSlot{-1, -1}
+			return
+		}
+		animID := c.nextAnimID
+		c.nextAnimID++
+		gen := c.inFlightGen
+		log.Printf("TRACE anim-init: allocated animID=%d gen=%d inFlight count=%d", animID, gen, len(c.inFlight))
+		c.inFlight[animID] = prefix + "[dim]▋[-]"
+		slotCh <- animSlot{animID, gen}
+		c.noteUnread()
+		log.Printf("TRACE anim-init: calling renderMessages")
+		c.renderMessages()
+		log.Printf("TRACE anim-init: renderMessages returned, sent slot")
+	})
+	log.Printf("TRACE AddIncomingMessage: anim init QueueUpdateDraw enqueued")
+
+	// Step 2 (goroutine): drip words one at a time, updating only our slot.
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("PANIC word-anim goroutine (from %s): %v", username, r)
+			}
+		}()
+
+		log.Printf("TRACE anim-goroutine: waiting for slot user=%q", username)
+		slot := <-slotCh
+		log.Printf("TRACE anim-goroutine: got slot id=%d gen=%d user=%q", slot.id, slot.gen, username)
+		if slot.id < 0 || atomic.LoadInt32(&c.stopped) == 1 {
+			log.Printf("TRACE anim-goroutine: aborting (id=%d stopped=%d)", slot.id, atomic.LoadInt32(&c.stopped))
+			return
+		}
+		animID := slot.id
+		myGen := slot.gen
+
+		built := ""
+		for i, word := range words {
+			if atomic.LoadInt32(&c.stopped) == 1 {
+				c.noteDropped(models.DropViewStopped)
+				return
+			}
+
+			// Variable delay: natural rhythm — short words fast, long ones slightly slower.
+			delay := time.Duration(55+len(word)*9) * time.Millisecond
+			if delay > 150*time.Millisecond {
+				delay = 150 * time.Millisecond
+			}
+			time.Sleep(delay)
+
+			if i == 0 {
+				built = word
+			} else {
+				built += " " + word
+			}
+			isLast := i == len(words)-1
+			snapshot := built
+
+			wordIdx := i
+			c.app.QueueUpdateDraw(func() {
+				log.Printf("TRACE word-tick: ENTER event loop animID=%d word[%d]=%q isLast=%v user=%q", animID, wordIdx, snapshot, isLast, username)
+				defer func() {
+					if r := recover(); r != nil {
+						log.Printf("PANIC word-anim draw (from %s): %v", username, r)
+					}
+				}()
+				if atomic.LoadInt32(&c.stopped) == 1 {
+					log.Printf("TRACE word-tick: stopped, bailing animID=%d", animID)
+					if isLast {
+						c.noteDropped(models.DropViewStopped)
+					}
+					return
+				}
+				if c.inFlightGen != myGen {
+					log.Printf("TRACE word-tick: stale gen (mine=%d current=%d), bailing animID=%d", myGen, c.inFlightGen, animID)
+					if isLast {
+						c.noteDropped(models.DropCleared)
+					}
+					return
+				}
+				sanitized := c.highlightContent(snapshot, colorTag)
+				log.Printf("TRACE word-tick: sanitized=%.60q lines=%d inFlightCount=%d", sanitized, c.lines.Len(), len(c.inFlight))
+				if isLast {
+					log.Printf("TRACE word-tick: LAST WORD — committing animID=%d", animID)
+					delete(c.inFlight, animID)
+					c.appendLine(id, prefix+sanitized+marker+"[-]\n")
+					log.Printf("TRACE word-tick: committed, lines=%d", c.lines.Len())
+					return
+				}
+				c.inFlight[animID] = prefix + sanitized + " [dim]▋[-]"
+				log.Printf("TRACE word-tick: calling renderMessages animID=%d", animID)
+				c.renderMessages()
+				log.Printf("TRACE word-tick: renderMessages returned animID=%d", animID)
+			})
+		}
+	}()
+}
+
+// SetMessages bulk-loads a slice of messages without animation.
+// Replaces the stored lines entirely (keeping the newest ScrollbackLines)
+// and clears any in-flight animations. Must be called from the tview event
+// loop — QueueUpdateDraw from there would never return.
+func (c *ChatView) SetMessages(messages []*models.Message) {
+	if atomic.LoadInt32(&c.stopped) == 1 {
+		return
+	}
+	c.lines.Reset()
+	if len(messages) > ScrollbackLines {
+		messages = messages[len(messages)-ScrollbackLines:]
+	}
+	for _, msg := range messages {
+		c.lines.Append(msg.ID, c.formatLine(msg))
+		if len(msg.Reactions) > 0 {
+			c.lines.SetUnder(msg.ID, c.reactionLine(msg.Reactions))
+		}
+	}
+	c.inFlight = make(map[int]string) // discard any in-flight animations
+	c.renderMessages()
+}
+
+// ClearMessages wipes the message area and all in-flight animation state.
+// Must be called from the tview event loop.
+//
+// Bumping inFlightGen invalidates any word-tick callbacks that were already
+// queued when this runs — they check the generation and bail out rather than
+// writing to a map that has been replaced.
+func (c *ChatView) ClearMessages() {
+	c.lines.Reset()
+	c.inFlight = make(map[int]string)
+	c.inFlightGen++ // invalidate all queued animation callbacks
+	c.resetPreviews()
+	c.renderMessages()
+}
+
+// Wipe clears everything the view remembers: scrollback, sent-message
+// history, the draft in the input field and the typing bar.
+// Must be called from the tview event loop.
+func (c *ChatView) Wipe() {
+	c.sentHistory = nil
+	c.historyIdx = -1
+	c.historyDraft = ""
+	c.snipFields = nil
+	c.inputField.SetText("")
+	c.typingNames = nil
+	c.redrawTyping()
+	c.ClearMessages()
+}
+
+// SetDraft puts text into the input field if it is empty, without it
+// counting as typing, and reports whether it did.
+// Must be called from the tview event loop.
+func (c *ChatView) SetDraft(text string) bool {
+	if c.inputField.GetText() != "" {
+		return false
+	}
+	onTyping := c.onTyping
+	c.onTyping = nil
+	c.inputField.SetText(text)
+	c.onTyping = onTyping
+	return true
+}
+
+// ── Header ─────────────────────────────────────────────────────────────────
+
+func (c *ChatView) startClockTicker() {
+	go func() {
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if atomic.LoadInt32(&c.stopped) == 1 {
+				return
+			}
+			c.app.QueueUpdateDraw(func() {
+				if atomic.LoadInt32(&c.stopped) == 1 {
+					return
+				}
+				c.redrawHeader()
+				if len(c.typingNames) > 0 && time.Now().After(c.typingUntil) {
+					c.typingNames = nil
+					c.redrawTyping()
+				}
+				c.spinFrame++
+				c.redrawUserList()
+			})
+		}
+	}()
+}
+
+// redrawHeader repaints the header content.
+//
+// Row 1:  [#room]  🔒 E2E  HH:MM:SS  @username    ●ONLINE/OFFLINE  LATENCY:Xms
+// Row 2:  msgs ▓▓▓▓▓░░░░░ 47/1000  │  ●●●○○ 3 active  │  0 waiting
+//
+// Must be called from within the tview event loop.
+func (c *ChatView) redrawHeader() {
+	clock := time.Now().Format("15:04:05")
+
+	// ── Row 1 ────────────────────────────────────────────────────────────────
+	onlineStr := "[red]● OFFLINE[-]"
+	if c.headerOnline {
+		onlineStr = "[green]● ONLINE[-]"
+	}
+
+	userStr := ""
+	if c.headerUsername != "" {
+		userStr = fmt.Sprintf("  [%s]@%s[-]", c.theme.Highlight, c.headerUsername)
+	}
+
+	latencyColor := "green"
+	if c.headerLatency > 100 {
+		latencyColor = "yellow"
+	}
+	if c.headerLatency > 300 {
+		latencyColor = "red"
+	}
+	latencyStr := "[dim]ping: --ms[-]"
+	if c.headerLatency >= 0 {
+		latencyStr = fmt.Sprintf("[dim]ping: [%s]%dms[-][-]", latencyColor, c.headerLatency)
+	}
+
+	lockStr := ""
+	if c.headerLock != "" {
+		lockStr = "  " + c.headerLock
+	}
+	switch {
+	case c.headerKey != "":
+		lockStr += fmt.Sprintf("  [%s]🔑 %s[-]", c.theme.Accent, c.headerKey)
+	case c.headerKeySet:
+		lockStr += "  [dim]🔑 built-in[-]"
+	}
+
+	roomStr := "GLOBAL"
+	if c.headerRoom != "" {
+		roomStr = "#" + sanitizeContent(c.headerRoom)
+	}
+	accent := c.theme.Accent
+	if c.headerAccent != "" {
+		accent = c.headerAccent
+	}
+
+	row1 := fmt.Sprintf("[%s::b]◈ %s[-::-]%s  [dim]%s[-]%s    %s   %s",
+		accent, roomStr, lockStr, clock, userStr, onlineStr, latencyStr)
+
+	// ── Row 2: live server stats ─────────────────────────────────────────────
+	// Active users: up to 5 colored dots, then "+N"
+	activeDots := ""
+	dotColors := []string{"green", "cyan", "yellow", "magenta", "blue"}
+	n := c.statsActive
+	if n > 5 {
+		for i := 0; i < 5; i++ {
+			activeDots += fmt.Sprintf("[%s]●[-]", dotColors[i])
+		}
+		activeDots += fmt.Sprintf("[dim]+%d[-]", n-5)
+	} else {
+		for i := 0; i < 5; i++ {
+			if i < n {
+				activeDots += fmt.Sprintf("[%s]●[-]", dotColors[i])
+			} else {
+				activeDots += "[dim]○[-]"
+			}
+		}
+	}
+
+	waitColor := "dim"
+	if c.statsWaiting > 0 {
+		waitColor = c.theme.Accent
+	}
+
+	row2 := fmt.Sprintf(
+		"[dim]total msgs: [-][%s]%d[-]   [dim]│[-]   %s [dim]%d active[-]   [dim]│   [%s]%d waiting[-][-]",
+		c.theme.Accent, c.statsTotalMsgs,
+		activeDots, c.statsActive,
+		waitColor, c.statsWaiting,
+	)
+
+	c.header.SetText(row1 + "\n" + row2)
+}
+
+// typingDisplayTTL is how long a typing list stays up without a refresh.
+const typingDisplayTTL = 6 * time.Second
+
+// SetTypingUsers shows who is typing above the input bar; an empty list
+// hides the line. Without a fresh call the line expires after a few
+// seconds. Safe to call from any goroutine.
+func (c *ChatView) SetTypingUsers(names []string) {
+	if atomic.LoadInt32(&c.stopped) == 1 {
+		return
+	}
+	c.app.QueueUpdateDraw(func() {
+		if atomic.LoadInt32(&c.stopped) == 1 {
+			return
+		}
+		c.typingNames = names
+		c.typingUntil = time.Now().Add(typingDisplayTTL)
+		c.redrawTyping()
+		c.redrawUserList()
+	})
+}
+
+// redrawTyping repaints the typing line and collapses it when empty.
+// Must be called from the tview event loop.
+func (c *ChatView) redrawTyping() {
+	var text string
+	switch n := len(c.typingNames); {
+	case n == 0:
+	case n == 1:
+		text = fmt.Sprintf("  [dim]%s is typing…[-]", sanitizeContent(c.typingNames[0]))
+	case n <= 3:
+		text = fmt.Sprintf("  [dim]%s are typing…[-]", sanitizeContent(strings.Join(c.typingNames, ", ")))
+	default:
+		text = fmt.Sprintf("  [dim]%d people are typing…[-]", n)
+	}
+	c.typingBar.SetText(text)
+	height := 0
+	if text != "" {
+		height = 1
+	}
+	c.container.ResizeItem(c.typingBar, height, 0)
+}
+
+// ShowBanner puts a warning line under the header that stays until
+// HideBanner. text is plain, not tview-tagged. Safe to call from any goroutine.
+func (c *ChatView) ShowBanner(text string) {
+	c.setBanner(text)
+}
+
+// HideBanner removes the warning line. Safe to call from any goroutine.
+func (c *ChatView) HideBanner() {
+	c.setBanner("")
+}
+
+func (c *ChatView) setBanner(text string) {
+	if atomic.LoadInt32(&c.stopped) == 1 {
+		return
+	}
+	c.app.QueueUpdateDraw(func() {
+		if atomic.LoadInt32(&c.stopped) == 1 {
+			return
+		}
+		height := 0
+		if text != "" {
+			text = " [white::b]⚠ " + sanitizeContent(text) + "[-::-]"
+			height = 1
+		}
+		c.bannerBar.SetText(text)
+		c.container.ResizeItem(c.bannerBar, height, 0)
+	})
+}
+
+// SetPins shows the newest of the room's pinned messages in a line under
+// the header, with how many more there are; without pins the line is
+// collapsed. Must be called from the tview event loop.
+func (c *ChatView) SetPins(pins []models.Pin) {
+	text, height := "", 0
+	if n := len(pins); n > 0 {
+		q := models.QuoteOf(pins[n-1].Message)
+		text = fmt.Sprintf(" [%s]📌[-] %s: %s", c.theme.Accent, sanitizeContent(q.Username), sanitizeContent(q.Text))
+		if n > 1 {
+			text += fmt.Sprintf("  [%s::d]+%d more — /pins[-::-]", c.theme.Muted, n-1)
+		}
+		height = 1
+	}
+	c.pinBar.SetText(text)
+	c.container.ResizeItem(c.pinBar, height, 0)
+}
+
+// Refresh repaints the header and footer from current state — the clock
+// in particular is stale after the process was suspended.
+// Must be called from the tview event loop.
+func (c *ChatView) Refresh() {
+	if atomic.LoadInt32(&c.stopped) == 1 {
+		return
+	}
+	c.redrawHeader()
+	c.redrawFooter()
+	c.imagesStale = true
+}
+
+// UpdateStats refreshes the server stats displayed in the header and footer.
+// Safe to call from any goroutine.
+func (c *ChatView) UpdateStats(totalMsgs, active, waiting, maxMsgs, maxWaiters int, serverURL string) {
+	if atomic.LoadInt32(&c.stopped) == 1 {
+		return
+	}
+	c.app.QueueUpdateDraw(func() {
+		if atomic.LoadInt32(&c.stopped) == 1 {
+			return
+		}
+		c.statsTotalMsgs = totalMsgs
+		c.statsActive = active
+		c.statsWaiting = waiting
+		c.statsMaxMsgs = maxMsgs
+		c.statsMaxWaiters = maxWaiters
+		if serverURL != "" {
+			c.statsServerURL = serverURL
+		}
+		c.redrawHeader()
+		c.redrawFooter()
+	})
+}
+
+// SetOnlineUsers repaints the online-users sidebar. The current user is
+// marked with "›". Must be called from the tview event loop.
+func (c *ChatView) SetOnlineUsers(users []*models.User) {
+	if users == nil {
+		users = []*models.User{}
+	}
+	c.onlineUsers = users
+	c.redrawUserList()
+}
+
+// idleAfter is how long after their last message or keystroke a user is
+// shown as idle, with the time since.
+const idleAfter = time.Minute
+
+// spinnerFrames animate the typing mark, one frame per clock tick.
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// redrawUserList repaints the sidebar: a spinner next to whoever is typing
+// in the room, the time since they were last active next to idle users.
+// Must be called from the tview event loop.
+func (c *ChatView) redrawUserList() {
+	if c.onlineUsers == nil {
+		return
+	}
+	typing := make(map[string]bool, len(c.typingNames))
+	for _, name := range c.typingNames {
+		typing[name] = true
+	}
+
+	var b strings.Builder
+	for _, u := range c.onlineUsers {
+		marker := " "
+		if u.Username == c.headerUsername {
+			marker = "›"
+		}
+		status := ""
+		switch {
+		case typing[u.Username]:
+			status = " [yellow]" + spinnerFrames[c.spinFrame%len(spinnerFrames)] + "[-]"
+		case !u.LastActive.IsZero() && time.Since(u.LastActive) >= idleAfter:
+			status = " [dim]" + idleFor(time.Since(u.LastActive)) + "[-]"
+		}
+		fmt.Fprintf(&b, "%s %s●[-] %s%s\n", marker, c.theme.userColor(safeColorTag(u.Color)), sanitizeContent(u.Username), status)
+	}
+	c.userList.SetTitle(fmt.Sprintf(" online %d ", len(c.onlineUsers)))
+	c.userList.SetText(b.String())
+}
+
+// idleFor formats an idle time for the sidebar: "2m", "3h", "1d".
+func idleFor(d time.Duration) string {
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	}
+	return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+}
+
+// SetCurrentUser pushes the logged-in username to the header.
+// Must be called from the tview event loop.
+func (c *ChatView) SetCurrentUser(username string) {
+	c.headerUsername = username
+	c.redrawHeader()
+}
+
+// SetEncryption shows whether outgoing messages in this room are encrypted:
+// 🔒 E2E, with a "req" mark if the room requires it, or 🔓 plain.
+// Must be called from the tview event loop.
+func (c *ChatView) SetEncryption(encrypted, required bool) {
+	switch {
+	case required:
+		c.headerLock = "[green]🔒 E2E[-][dim]·req[-]"
+	case encrypted:
+		c.headerLock = "[green]🔒 E2E[-]"
+	default:
+		c.headerLock = "[yellow]🔓 plain[-]"
+	}
+	c.redrawHeader()
+}
+
+// SetRoom labels the header with the room and draws the label and the
+// header border in the room's accent color, a tview color name; "" keeps
+// the theme's. Must be called from the tview event loop.
+func (c *ChatView) SetRoom(room, accent string) {
+	c.headerRoom = room
+	c.headerAccent = accent
+	border := c.theme.Border
+	if accent != "" {
+		border = tcell.GetColor(accent)
+	}
+	c.header.SetBorderColor(border)
+	c.redrawHeader()
+}
+
+// SetKeyFingerprint shows the fingerprint of the message key in the header,
+// or "built-in" when no room passphrase was set.
+// Must be called from the tview event loop.
+func (c *ChatView) SetKeyFingerprint(fp string, builtin bool) {
+	c.headerKey, c.headerKeySet = fp, true
+	if builtin {
+		c.headerKey = ""
+	}
+	c.redrawHeader()
+}
+
+// SetOnlineStatus updates the ●ONLINE/●OFFLINE indicator in the header.
+//
+// MUST be called from within the tview event loop (i.e. from inside a
+// QueueUpdateDraw callback). It does NOT call QueueUpdateDraw itself —
+// doing so from inside an existing callback would nest queue calls and
+// deadlock tview's updates channel on Windows.
+func (c *ChatView) SetOnlineStatus(online bool) {
+	if atomic.LoadInt32(&c.stopped) == 1 {
+		return
+	}
+	c.headerOnline = online
+	c.redrawHeader()
+}
+
+// SetOnlineStatusAsync updates the online indicator from any goroutine.
+// Use this ONLY when NOT already inside a QueueUpdateDraw callback.
+func (c *ChatView) SetOnlineStatusAsync(online bool) {
+	if atomic.LoadInt32(&c.stopped) == 1 {
+		return
+	}
+	c.app.QueueUpdateDraw(func() {
+		if atomic.LoadInt32(&c.stopped) == 1 {
+			return
+		}
+		c.headerOnline = online
+		c.redrawHeader()
+	})
+}
+
+// UpdateLatency updates the latency shown in the header.
+// Safe to call from any goroutine.
+func (c *ChatView) UpdateLatency(latency int) {
+	if atomic.LoadInt32(&c.stopped) == 1 {
+		return
+	}
+	c.app.QueueUpdateDraw(func() {
+		if atomic.LoadInt32(&c.stopped) == 1 {
+			return
+		}
+		c.headerLatency = latency
+		c.redrawHeader()
+	})
+}
+
+// ── Command bar ───────────────────────────────────────────────────────────
+
+func (c *ChatView) redrawCommandBar() {
+	modeLabel := "[dim]mode:[green]ANIM[-]"
+	if atomic.LoadInt32(&c.animMode) == 0 {
+		modeLabel = "[dim]mode:[cyan]STATIC[-]"
+	}
+	nickLabel := ""
+	if c.nickActive {
+		nickLabel = "  [cyan]nick:ON ←→[-]"
+	}
+	if c.scrolledBack {
+		c.commandBar.SetText(c.scrollIndicator() + "   " + modeLabel + nickLabel)
+		c.redrawFooter()
+		return
+	}
+	c.commandBar.SetText(fmt.Sprintf(
+		"[dim]/ commands: clear  whois  users  nick  mode  user_color  latency  history  retry  privacy  encrypt  trust  audit  debug  info  wipe  exit  help[-]   %s%s",
+		modeLabel, nickLabel,
+	))
+	c.redrawFooter() // keep mode label in footer in sync
+}
+
+// redrawFooter repaints the bottom status bar with secondary server info.
+// Must be called from within the tview event loop.
+func (c *ChatView) redrawFooter() {
+	if c.footer == nil {
+		return // called before buildUI() finished initializing c.footer
+	}
+
+	modeLabel := "[cyan]ANIM[-]"
+	if atomic.LoadInt32(&c.animMode) == 0 {
+		modeLabel = "[green]STATIC[-]"
+	}
+
+	url := c.statsServerURL
+	if url == "" {
+		url = "localhost:8034"
+	}
+
+	c.footer.SetText(fmt.Sprintf(
+		"[dim]server:[cyan]%s[-]  [dim]│  mode:%s[-]  [dim]│[-]  [magenta]SecTherminal v1.0[-]",
+		url, modeLabel,
+	))
+}
+
+// ── Animation mode ────────────────────────────────────────────────────────
+
+func (c *ChatView) SetAnimationMode(anim bool) {
+	if anim {
+		atomic.StoreInt32(&c.animMode, 1)
+	} else {
+		atomic.StoreInt32(&c.animMode, 0)
+	}
+	c.redrawCommandBar()
+}
+
+func (c *ChatView) ToggleAnimationMode() string {
+	if atomic.LoadInt32(&c.animMode) == 1 {
+		atomic.StoreInt32(&c.animMode, 0)
+		c.redrawCommandBar()
+		return "static"
+	}
+	atomic.StoreInt32(&c.animMode, 1)
+	c.redrawCommandBar()
+	return "animation"
+}
+
+func (c *ChatView) IsAnimationMode() bool {
+	return atomic.LoadInt32(&c.animMode) == 1
+}
+
+// ── Nick mode ─────────────────────────────────────────────────────────────
+
+func (c *ChatView) ToggleNickMode() bool {
+	c.nickActive = !c.nickActive
+	c.historyIdx = -1
+	c.redrawCommandBar()
+	return c.nickActive
+}
+
+// MaxSentHistory is how many sent lines ↑ / ↓ can recall.
+const MaxSentHistory = 500
+
+// AddToHistory appends msg to the sent history and reports whether it was
+// added — a repeat of the previous line is not. Must be called from the
+// tview event loop.
+func (c *ChatView) AddToHistory(msg string) bool {
+	if msg == "" {
+		return false
+	}
+	if len(c.sentHistory) > 0 && c.sentHistory[len(c.sentHistory)-1] == msg {
+		return false
+	}
+	c.sentHistory = append(c.sentHistory, msg)
+	if len(c.sentHistory) > MaxSentHistory {
+		c.sentHistory = c.sentHistory[len(c.sentHistory)-MaxSentHistory:]
+	}
+	return true
+}
+
+// SetSentHistory replaces the sent history, oldest line first, e.g. with
+// the one saved by the last run. Call it before the chat screen is shown.
+func (c *ChatView) SetSentHistory(lines []string) {
+	if len(lines) > MaxSentHistory {
+		lines = lines[len(lines)-MaxSentHistory:]
+	}
+	c.sentHistory = append([]string(nil), lines...)
+	c.historyIdx = -1
+}
+
+// SentHistory returns a copy of the sent history, oldest line first. Must
+// be called from the tview event loop.
+func (c *ChatView) SentHistory() []string {
+	return append([]string(nil), c.sentHistory...)
+}
+
+// historyPrev recalls the previous (older) sent line.
+func (c *ChatView) historyPrev() {
+	if len(c.sentHistory) == 0 {
+		return
+	}
+	if c.historyIdx < 0 {
+		c.historyDraft = c.inputField.GetText()
+		c.historyIdx = len(c.sentHistory) - 1
+	} else if c.historyIdx > 0 {
+		c.historyIdx--
+	}
+	c.inputField.SetText(c.sentHistory[c.historyIdx])
+	c.snipFields = nil
+}
+
+// historyNext recalls the next (newer) sent line; past the newest it puts
+// back what was being typed.
+func (c *ChatView) historyNext() {
+	if c.historyIdx < 0 {
+		return
+	}
+	c.historyIdx++
+	if c.historyIdx >= len(c.sentHistory) {
+		c.inputField.SetText(c.historyDraft) // still browsing: not typing
+		c.historyIdx = -1
+		c.historyDraft = ""
+	} else {
+		c.inputField.SetText(c.sentHistory[c.historyIdx])
+	}
+	c.snipFields = nil
+}
+
+// ── Footer ────────────────────────────────────────────────────────────────
+
+func (c *ChatView) UpdateCursorPosition(line, col int) {
+	if atomic.LoadInt32(&c.stopped) == 1 {
+		return
+	}
+	c.app.QueueUpdateDraw(func() {
+		if atomic.LoadInt32(&c.stopped) == 1 {
+			return
+		}
+		c.footer.SetText(fmt.Sprintf(
+			"[magenta]NORMAL[-]    SecTherminal              UTF-8    L:%d, C:%d", line, col,
+		))
+	})
+}
+
+// Stop signals this view is permanently done. No further UI updates will run.
+func (c *ChatView) Stop() {
+	atomic.StoreInt32(&c.stopped, 1)
+}
@@ -0,0 +1,239 @@
+package views
+
+import (
+	"fmt"
+	"strings"
+
+	"cli-client/models"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// ── Code blocks ───────────────────────────────────────────────────────────
+//
+// A message may hold code between ``` fences, optionally naming its
+// language after the opening one:
+//
+//	```go
+//	func main() {}
+//	```
+//
+// The block is drawn on its own lines in a frame, syntax highlighted with
+// chroma in the theme's Syntax style; without a language chroma guesses
+// one. Text around the block is drawn as usual.
+//
+// The input field is one line, so a pasted block would otherwise be sent a
+// line at a time. A paste with line breaks (bracketed paste, which the
+// terminal has to support) shows them as ↵ in the field instead, and they
+// become line breaks again when the message is sent; Alt+Enter adds one by
+// hand.
+
+// codeFence opens and closes a code block.
+const codeFence = "```"
+
+// pastedNewline stands in for a line break in the input field.
+const pastedNewline = "↵"
+
+// contentPart is a piece of a message: text, or a code block's code.
+type contentPart struct {
+	text string
+	code bool
+	lang string // code only; "" = unknown
+}
+
+// splitCodeBlocks cuts content into text and code blocks. A fence without
+// a closing one is left as text.
+func splitCodeBlocks(content string) []contentPart {
+	if !strings.Contains(content, codeFence) {
+		return []contentPart{{text: content}}
+	}
+	var parts []contentPart
+	for {
+		open := strings.Index(content, codeFence)
+		if open < 0 {
+			break
+		}
+		body := content[open+len(codeFence):]
+		end := strings.Index(body, codeFence)
+		if end < 0 {
+			break
+		}
+		code := body[:end]
+		lang := ""
+		// The language is the rest of the opening fence's line, when the
+		// code starts on the next one.
+		if nl := strings.IndexByte(code, '\n'); nl >= 0 && !strings.ContainsAny(strings.TrimSpace(code[:nl]), " \t") {
+			lang, code = strings.TrimSpace(code[:nl]), code[nl+1:]
+		}
+		if before := strings.TrimRight(content[:open], " \n"); before != "" {
+			parts = append(parts, contentPart{text: before})
+		}
+		parts = append(parts, contentPart{text: strings.Trim(code, "\n"), code: true, lang: lang})
+		content = strings.TrimLeft(body[end+len(codeFence):], " \n")
+	}
+	if content != "" {
+		parts = append(parts, contentPart{text: content})
+	}
+	return parts
+}
+
+// renderBody renders a message's content: code blocks framed and
+// highlighted, the text around them through styleContent, with the
+// highlight rules applied when highlight is set. The result starts on the
+// line the prefix is on and never ends with a line break.
+func (c *ChatView) renderBody(content, colorTag string, highlight bool) string {
+	parts := splitCodeBlocks(content)
+	var b strings.Builder
+	for i, part := range parts {
+		if part.code {
+			if i > 0 || b.Len() > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString(c.renderCodeBlock(part.text, part.lang, colorTag))
+			continue
+		}
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		var spans []models.HighlightSpan
+		if highlight {
+			spans, _ = c.highlighter.Match(part.text)
+		}
+		b.WriteString(c.styleContent(part.text, colorTag, spans))
+	}
+	return b.String()
+}
+
+// codeLexer returns the lexer for lang, or the one chroma guesses for code,
+// and the language's name.
+func codeLexer(lang, code string) (chroma.Lexer, string) {
+	lexer := lexers.Get(lang)
+	if lexer == nil {
+		lexer = lexers.Analyse(code)
+	}
+	if lexer == nil {
+		return lexers.Fallback, "text"
+	}
+	return lexer, strings.ToLower(lexer.Config().Name)
+}
+
+// renderCodeBlock draws code in a frame in the sender's color, highlighted
+// in the theme's Syntax style.
+func (c *ChatView) renderCodeBlock(code, lang, colorTag string) string {
+	lexer, name := codeLexer(lang, code)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[-:-:-]%s  ╭─ [%s]%s[-]%s\n", colorTag, c.theme.Muted, tview.Escape(name), colorTag)
+	b.WriteString("[-:-:-]" + colorTag + "  │ [-:-:-]")
+	b.WriteString(highlightCode(lexer, c.theme.Syntax, strings.ReplaceAll(code, "\t", "    "),
+		"\n[-:-:-]"+colorTag+"  │ [-:-:-]"))
+	b.WriteString("\n[-:-:-]" + colorTag + "  ╰─[-:-:-]" + colorTag)
+	return b.String()
+}
+
+// highlightCode tokenises code with lexer and returns it with tview tags
+// for the style named style, lineStart in place of every line break. An
+// unknown style or a lexer error leaves the code uncolored. Code is full of
+// brackets, so it is escaped like the paste viewer's text rather than by
+// sanitizeContent.
+func highlightCode(lexer chroma.Lexer, style, code, lineStart string) string {
+	plain := func() string {
+		return strings.ReplaceAll(tview.Escape(fitCells(code)), "\n", lineStart)
+	}
+	s, ok := styles.Registry[style]
+	if !ok {
+		return plain()
+	}
+	it, err := chroma.Coalesce(lexer).Tokenise(nil, code)
+	if err != nil {
+		return plain()
+	}
+
+	var b strings.Builder
+	for token := it(); token != chroma.EOF; token = it() {
+		tag := tokenTag(s.Get(token.Type))
+		for i, line := range strings.Split(token.Value, "\n") {
+			if i > 0 {
+				b.WriteString(lineStart)
+			}
+			if line == "" {
+				continue
+			}
+			b.WriteString(tag + tview.Escape(fitCells(line)) + "[-:-:-]")
+		}
+	}
+	return strings.TrimSuffix(b.String(), lineStart)
+}
+
+// tokenTag is the tview tag for a chroma style entry. The background is
+// left to the theme.
+func tokenTag(e chroma.StyleEntry) string {
+	fg := "-"
+	if e.Colour.IsSet() {
+		fg = e.Colour.String()
+	}
+	attrs := ""
+	if e.Bold == chroma.Yes {
+		attrs += "b"
+	}
+	if e.Italic == chroma.Yes {
+		attrs += "i"
+	}
+	if e.Underline == chroma.Yes {
+		attrs += "u"
+	}
+	return fmt.Sprintf("[%s::%s]", fg, attrs)
+}
+
+// composerField is the input field, taking pastes with line breaks in.
+type composerField struct {
+	*tview.InputField
+}
+
+// PasteHandler puts a paste into the field with its line breaks as ↵.
+func (f *composerField) PasteHandler() func(string, func(tview.Primitive)) {
+	handle := f.InputField.PasteHandler()
+	return func(text string, setFocus func(tview.Primitive)) {
+		text = strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+		handle(strings.ReplaceAll(strings.TrimRight(text, "\n"), "\n", pastedNewline), setFocus)
+	}
+}
+
+// InputHandler is the field's; Alt+Enter adds a line break by hand.
+func (f *composerField) InputHandler() func(*tcell.EventKey, func(tview.Primitive)) {
+	handle := f.InputField.InputHandler()
+	return func(event *tcell.EventKey, setFocus func(tview.Primitive)) {
+		if event.Key() == tcell.KeyEnter && event.Modifiers()&tcell.ModAlt != 0 {
+			f.InputField.PasteHandler()(pastedNewline, setFocus)
+			return
+		}
+		handle(event, setFocus)
+	}
+}
+
+// MouseHandler is the field's, but a click focuses f rather than the
+// InputField inside it, whose handlers aren't these.
+func (f *composerField) MouseHandler() func(tview.MouseAction, *tcell.EventMouse, func(tview.Primitive)) (bool, tview.Primitive) {
+	handle := f.InputField.MouseHandler()
+	return func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(tview.Primitive)) (bool, tview.Primitive) {
+		consumed, capture := handle(action, event, func(p tview.Primitive) {
+			if p == f.InputField {
+				p = f
+			}
+			setFocus(p)
+		})
+		if capture == f.InputField {
+			capture = f
+		}
+		return consumed, capture
+	}
+}
+
+// composedText turns the ↵ of a pasted block back into line breaks.
+func composedText(text string) string {
+	return strings.ReplaceAll(text, pastedNewline, "\n")
+}
@@ -0,0 +1,177 @@
+package views
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"cli-client/models"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/mattn/go-runewidth"
+	"github.com/rivo/tview"
+	"github.com/rivo/uniseg"
+)
+
+// ── Emoji ─────────────────────────────────────────────────────────────────
+//
+// Shortcodes like :rocket: become their emoji (see models.ExpandShortcodes)
+// as soon as the closing colon is typed, and whatever is left when the
+// message is sent. After a colon and two letters a popup offers the
+// shortcodes that match; ↑ / ↓ pick one, Enter or Tab inserts it and Esc
+// closes the popup.
+//
+// tview measures text by grapheme cluster and tcell by its first code point,
+// and the two disagree on a few emoji: ❤️ (a one-column heart turned emoji
+// by U+FE0F) is two columns to tview and one to tcell, so every cell after
+// it on the line lands one column off. fitCells draws those the way both
+// agree on.
+
+// emojiPopupSize is the most shortcodes the popup offers at once.
+const emojiPopupSize = 8
+
+// SetEmoji turns shortcode expansion and the popup on or off. Call it
+// before the chat screen is shown.
+func (c *ChatView) SetEmoji(on bool) {
+	c.emojiOff = !on
+}
+
+// expandEmoji replaces the known shortcodes left in a message about to be
+// sent, unless expansion is off.
+func (c *ChatView) expandEmoji(text string) string {
+	if c.emojiOff {
+		return text
+	}
+	return models.ExpandShortcodes(text)
+}
+
+// setupEmoji hooks the popup up to the input field.
+func (c *ChatView) setupEmoji() {
+	c.inputField.SetAutocompleteStyles(tcell.ColorDarkSlateGray,
+		tcell.StyleDefault.Foreground(tcell.ColorWhite).Background(tcell.ColorDarkSlateGray),
+		tcell.StyleDefault.Foreground(tcell.ColorBlack).Background(tcell.ColorAqua))
+	c.inputField.SetAutocompleteFunc(func(text string) []string {
+		c.emojiMatches = nil
+		if c.emojiOff || c.historyIdx >= 0 {
+			return nil
+		}
+		_, prefix, ok := shortcodeAtEnd(text)
+		if !ok || utf8.RuneCountInString(prefix) < 2 {
+			return nil
+		}
+		c.emojiMatches = models.CompleteShortcode(prefix, emojiPopupSize)
+		entries := make([]string, len(c.emojiMatches))
+		for i, m := range c.emojiMatches {
+			entries[i] = fmt.Sprintf(" %s :%s: ", m.Emoji, m.Shortcode)
+		}
+		return entries
+	})
+	c.inputField.SetAutocompletedFunc(func(_ string, index, source int) bool {
+		if source == tview.AutocompletedNavigate {
+			return false
+		}
+		if index >= 0 && index < len(c.emojiMatches) {
+			text := c.inputField.GetText()
+			if start, _, ok := shortcodeAtEnd(text); ok {
+				c.inputField.SetText(text[:start] + c.emojiMatches[index].Emoji)
+			}
+		}
+		c.emojiMatches = nil
+		return true
+	})
+}
+
+// emojiPopupOpen reports whether the popup is showing, so the input
+// capture leaves ↑ / ↓ / Esc to it.
+func (c *ChatView) emojiPopupOpen() bool {
+	return len(c.emojiMatches) > 0
+}
+
+// expandTypedShortcode replaces a known shortcode the user just closed at
+// the end of the input field. Called from the field's changed handler.
+func (c *ChatView) expandTypedShortcode(text string) bool {
+	if c.emojiOff || c.historyIdx >= 0 || !strings.HasSuffix(text, ":") {
+		return false
+	}
+	start, code, ok := shortcodeAtEnd(text[:len(text)-1])
+	if !ok {
+		return false
+	}
+	emoji, ok := models.Emoji(code)
+	if !ok {
+		return false
+	}
+	c.emojiMatches = nil
+	c.inputField.SetText(text[:start] + emoji)
+	return true
+}
+
+// shortcodeAtEnd finds an unclosed shortcode being typed at the end of
+// text: a colon at the start of a word followed by name characters. start
+// is the colon's byte offset, name what follows it.
+func shortcodeAtEnd(text string) (start int, name string, ok bool) {
+	i := len(text)
+	for i > 0 && isShortcodeChar(text[i-1]) {
+		i--
+	}
+	if i == 0 || text[i-1] != ':' || i == len(text) {
+		return 0, "", false
+	}
+	start = i - 1
+	// "12:30" and "http://x:8080" are not shortcodes.
+	if start > 0 {
+		if prev, _ := utf8.DecodeLastRuneInString(text[:start]); prev != ' ' && prev != '\t' && prev != '(' {
+			return 0, "", false
+		}
+	}
+	return start, text[i:], true
+}
+
+func isShortcodeChar(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '_' || b == '+' || b == '-'
+}
+
+// fitCells rewrites the grapheme clusters tview and tcell measure
+// differently into ones they agree on: U+FE0F is dropped, leaving the
+// one-column text form (❤️ → ❤), and a flag is spelled out by its two
+// letters (🇩🇪 → DE). Everything else is returned as it is.
+func fitCells(s string) string {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	g := uniseg.NewGraphemes(s)
+	for g.Next() {
+		cluster, runes := g.Str(), g.Runes()
+		if g.Width() == runewidth.RuneWidth(runes[0]) {
+			b.WriteString(cluster)
+			continue
+		}
+		switch {
+		case strings.ContainsRune(cluster, '\uFE0F'):
+			b.WriteString(strings.ReplaceAll(cluster, "\uFE0F", ""))
+		case isRegionalIndicator(runes[0]):
+			for _, r := range runes {
+				if isRegionalIndicator(r) {
+					b.WriteRune('A' + r - 0x1F1E6)
+				}
+			}
+		default:
+			b.WriteString(cluster)
+		}
+	}
+	return b.String()
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
@@ -0,0 +1,81 @@
+package views
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// ── Help ──────────────────────────────────────────────────────────────────
+//
+// ShowHelp opens the reference /help shows — commands, keys and modes — in
+// a box over the chat like the paste viewer: ↑ / ↓, PgUp / PgDn and the
+// wheel scroll it, Esc, q or a click outside the box close it.
+
+const helpPage = "help"
+
+// HelpSection is one titled table of the help page.
+type HelpSection struct {
+	Title string
+	Rows  []HelpRow
+}
+
+// HelpRow is one entry of a HelpSection: a command or key, and what it
+// does. Neither may contain color tags.
+type HelpRow struct {
+	Name string
+	Text string
+}
+
+// ShowHelp shows sections in the help box. Must be called from the tview
+// event loop.
+func (c *ChatView) ShowHelp(sections []HelpSection) {
+	var b strings.Builder
+	for i, section := range sections {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "[%s::b]%s[-::-]\n", c.theme.Highlight, tview.Escape(section.Title))
+		width := 0
+		for _, row := range section.Rows {
+			width = max(width, tview.TaggedStringWidth(tview.Escape(row.Name)))
+		}
+		for _, row := range section.Rows {
+			name := tview.Escape(row.Name)
+			pad := strings.Repeat(" ", width-tview.TaggedStringWidth(name))
+			fmt.Fprintf(&b, "  [%s]%s[-]%s  %s\n", c.theme.System, name, pad, tview.Escape(row.Text))
+		}
+	}
+
+	view := tview.NewTextView()
+	view.SetDynamicColors(true)
+	view.SetScrollable(true)
+	view.SetWordWrap(true)
+	view.SetBackgroundColor(c.theme.Background)
+	view.SetTextColor(c.theme.Text)
+	view.SetBorder(true)
+	view.SetBorderPadding(0, 0, 1, 1)
+	view.SetBorderColor(c.theme.Border)
+	view.SetTitle(" Help · ↑↓ PgUp PgDn scroll · Esc to close ")
+	view.SetTitleColor(c.theme.Border)
+	view.SetText(b.String())
+	view.ScrollToBeginning()
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape || event.Rune() == 'q' {
+			c.closeHelp()
+			return nil
+		}
+		return event
+	})
+
+	c.root.AddPage(helpPage, centeredOverlay(view, c.closeHelp), true, true)
+	c.app.SetFocus(view)
+}
+
+// closeHelp closes the help box. Must be called from the tview event loop.
+func (c *ChatView) closeHelp() {
+	c.root.RemovePage(helpPage)
+	c.app.SetFocus(c.inputField)
+}
@@ -0,0 +1,485 @@
+package views
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// ── Image previews ────────────────────────────────────────────────────────
+//
+// An image saved with /get is previewed in the message view, under the
+// line saying where it went. The preview is drawn with half blocks (▀) in
+// 24-bit color, which any terminal can show. Where the terminal has a
+// graphics protocol, kitty's or sixel, the picture itself is drawn over
+// that thumbnail after each draw, whenever the thumbnail is wholly on
+// screen and nothing covers the chat; the rest of the time, e.g. while it
+// is half scrolled out, the thumbnail shows.
+//
+// To find its thumbnails on screen the view starts each of their rows
+// with two blank cells whose foreground colors encode the preview and the
+// row (see previewMarker). Blank cells show no foreground, so the marks
+// are invisible.
+
+// ImageStyle picks how image previews are drawn.
+type ImageStyle string
+
+const (
+	ImageAuto   ImageStyle = "auto"   // from the environment, see detectImageStyle
+	ImageKitty  ImageStyle = "kitty"  // kitty graphics protocol
+	ImageSixel  ImageStyle = "sixel"  // DEC sixel graphics
+	ImageBlocks ImageStyle = "blocks" // half blocks only
+	ImageOff    ImageStyle = "off"    // no preview
+)
+
+// DefaultImageStyle is used when config.json doesn't name one.
+const DefaultImageStyle = ImageAuto
+
+// ParseImageStyle returns the style named s ("" = DefaultImageStyle).
+func ParseImageStyle(s string) (ImageStyle, bool) {
+	switch style := ImageStyle(strings.ToLower(strings.TrimSpace(s))); style {
+	case "":
+		return DefaultImageStyle, true
+	case ImageAuto, ImageKitty, ImageSixel, ImageBlocks, ImageOff:
+		return style, true
+	}
+	return DefaultImageStyle, false
+}
+
+// detectImageStyle guesses the terminal's graphics protocol from the
+// environment. tmux forwards neither protocol by default, so inside it
+// previews are half blocks.
+func detectImageStyle() ImageStyle {
+	term, program := os.Getenv("TERM"), os.Getenv("TERM_PROGRAM")
+	switch {
+	case os.Getenv("TMUX") != "" || strings.HasPrefix(term, "screen"):
+		return ImageBlocks
+	case os.Getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" || term == "xterm-ghostty" ||
+		program == "WezTerm" || program == "ghostty":
+		return ImageKitty
+	case strings.HasPrefix(term, "foot") || strings.HasPrefix(term, "mlterm") || term == "contour" ||
+		program == "iTerm.app" || os.Getenv("WT_SESSION") != "":
+		return ImageSixel
+	}
+	return ImageBlocks
+}
+
+const (
+	// previewCols and previewRows bound a thumbnail, in cells.
+	previewCols = 40
+	previewRows = 12
+	// previewPixels bounds the picture kept for a preview, per side.
+	previewPixels = 480
+	// defaultCellWidth and defaultCellHeight are assumed for sixel when
+	// the terminal doesn't report its cell size.
+	defaultCellWidth  = 10
+	defaultCellHeight = 20
+)
+
+// preview is an image shown in the message view.
+type preview struct {
+	img        *image.RGBA
+	index      int // 1–255, see previewMarker
+	cols, rows int // the thumbnail's size in cells
+
+	at      image.Point // where the picture was last drawn, if shown
+	shown   bool
+	kittyID uint32      // image ID on the terminal; 0 = not sent yet
+	sixel   string      // the picture as sixel, "" = not encoded yet
+	sixelAt image.Point // the size sixel was encoded at, in pixels
+}
+
+// SetImageStyle picks how image previews are drawn; ImageAuto looks at
+// the terminal. Call it before the chat screen is shown.
+func (c *ChatView) SetImageStyle(style ImageStyle) {
+	if style == ImageAuto {
+		style = detectImageStyle()
+	}
+	c.imageStyle = style
+}
+
+// PreviewImage shrinks img to the most a preview draws, so a message can
+// keep it around.
+func PreviewImage(img image.Image) *image.RGBA {
+	w, h := fitSize(img.Bounds().Dx(), img.Bounds().Dy(), previewPixels, previewPixels)
+	return scaleImage(img, w, h)
+}
+
+// formatPreview renders the thumbnail of a message's image, one line per
+// row, for the lines under the message. id is the message's.
+func (c *ChatView) formatPreview(id string, img image.Image) string {
+	if c.imageStyle == ImageOff || img == nil {
+		return ""
+	}
+	p := c.previews[id]
+	if p == nil {
+		rgba, ok := img.(*image.RGBA)
+		if !ok {
+			rgba = PreviewImage(img)
+		}
+		p = &preview{img: rgba}
+		c.nextPreview = c.nextPreview%255 + 1
+		p.index = c.nextPreview
+		if old := c.previewAt[p.index]; old != nil {
+			c.dropPreview(old)
+		}
+		c.previews[id] = p
+		c.previewAt[p.index] = p
+	}
+
+	maxCols := previewCols
+	if _, _, width, _ := c.messageView.GetInnerRect(); width > 0 && width-3 < maxCols {
+		maxCols = max(width-3, 1)
+	}
+	// Half blocks are about square: one column, two rows of pixels a cell.
+	cols, px := fitSize(p.img.Bounds().Dx(), p.img.Bounds().Dy(), maxCols, 2*previewRows)
+	p.cols, p.rows = cols, (px+1)/2
+	thumb := scaleImage(p.img, cols, 2*p.rows)
+	bg := colorOf(c.theme.Background)
+
+	var b strings.Builder
+	for row := 0; row < p.rows; row++ {
+		b.WriteString(previewMarker(p.index, row))
+		for x := 0; x < cols; x++ {
+			top := over(thumb.RGBAAt(x, 2*row), bg)
+			bottom := over(thumb.RGBAAt(x, 2*row+1), bg)
+			fmt.Fprintf(&b, "[#%02x%02x%02x:#%02x%02x%02x]▀", top.R, top.G, top.B, bottom.R, bottom.G, bottom.B)
+		}
+		b.WriteString("[-:-:-]\n")
+	}
+	return b.String()
+}
+
+// previewMarker starts row of preview index: two blank cells, the first in
+// #01e1<row>, the second in #01e2<index>.
+func previewMarker(index, row int) string {
+	return fmt.Sprintf("[#01e1%02x] [#01e2%02x] ", row, index)
+}
+
+// markerAt reads the marker of a thumbnail row starting at x, y.
+func markerAt(screen tcell.Screen, x, y int) (index, row int, ok bool) {
+	_, _, first, _ := screen.GetContent(x, y)
+	_, _, second, _ := screen.GetContent(x+1, y)
+	fg1, _, _ := first.Decompose()
+	fg2, _, _ := second.Decompose()
+	r1, g1, b1 := fg1.RGB()
+	r2, g2, b2 := fg2.RGB()
+	if r1 != 0x01 || g1 != 0xe1 || r2 != 0x01 || g2 != 0xe2 {
+		return 0, 0, false
+	}
+	return int(b2), int(b1), true
+}
+
+// dropPreview forgets p, and the terminal its picture.
+func (c *ChatView) dropPreview(p *preview) {
+	for id, q := range c.previews {
+		if q == p {
+			delete(c.previews, id)
+		}
+	}
+	delete(c.previewAt, p.index)
+	if p.kittyID != 0 {
+		c.imageOut = append(c.imageOut, fmt.Sprintf("\x1b_Ga=d,d=I,i=%d,q=2\x1b\\", p.kittyID))
+	}
+}
+
+// resetPreviews forgets every preview, e.g. when the messages are cleared.
+func (c *ChatView) resetPreviews() {
+	for _, p := range c.previewAt {
+		c.dropPreview(p)
+	}
+}
+
+// drawImages draws the pictures of the thumbnails wholly on screen, with
+// the terminal's graphics protocol, and takes away the ones that moved
+// or went. Called after every draw, from the event loop.
+func (c *ChatView) drawImages(screen tcell.Screen) {
+	graphics := c.imageStyle == ImageKitty || c.imageStyle == ImageSixel
+	if !graphics || (len(c.previewAt) == 0 && len(c.imageOut) == 0) {
+		return
+	}
+	tty, ok := screen.Tty()
+	if !ok || tty == nil {
+		return
+	}
+
+	visible := make(map[*preview]image.Point)
+	if front, _ := c.root.GetFrontPage(); front == "chat" && !c.emojiPopupOpen() {
+		x, y, _, height := c.messageView.GetInnerRect()
+		for row := y; row < y+height; row++ {
+			index, r, ok := markerAt(screen, x, row)
+			p := c.previewAt[index]
+			if !ok || r != 0 || p == nil || row+p.rows > y+height {
+				continue
+			}
+			whole := true
+			for k := 1; k < p.rows && whole; k++ {
+				i, r, ok := markerAt(screen, x, row+k)
+				whole = ok && i == index && r == k
+			}
+			if whole {
+				visible[p] = image.Pt(x+2, row)
+			}
+		}
+	}
+
+	if size := image.Pt(screen.Size()); size != c.imageScreen {
+		c.imageScreen = size
+		c.imagesStale = true
+	}
+	out := c.imageOut
+	c.imageOut = nil
+	for _, p := range c.previewAt {
+		at, ok := visible[p]
+		if p.shown && (!ok || at != p.at) {
+			p.shown = false
+			if p.kittyID != 0 {
+				out = append(out, fmt.Sprintf("\x1b_Ga=d,d=i,i=%d,p=1,q=2\x1b\\", p.kittyID))
+			}
+			// tcell repaints the thumbnail over a sixel picture.
+			screen.LockRegion(p.at.X, p.at.Y, p.cols, p.rows, false)
+		}
+	}
+	var cw, ch int
+	if ws, err := tty.WindowSize(); err == nil {
+		cw, ch = ws.CellDimensions()
+	}
+	for p, at := range visible {
+		if p.shown && !c.imagesStale && c.imageStyle == ImageSixel {
+			continue // still there
+		}
+		var seq string
+		if c.imageStyle == ImageKitty {
+			seq = c.kittySequence(p, cw, ch)
+		} else {
+			seq = p.sixelSequence(cw, ch, colorOf(c.theme.Background))
+		}
+		out = append(out, fmt.Sprintf("\x1b7\x1b[%d;%dH%s\x1b8", at.Y+1, at.X+1, seq))
+		p.at, p.shown = at, true
+	}
+	c.imagesStale = false
+	if len(out) == 0 {
+		return
+	}
+
+	// The pictures go on top of what tcell draws, so draw that first.
+	screen.Show()
+	for _, seq := range out {
+		if _, err := tty.Write([]byte(seq)); err != nil {
+			log.Printf("TRACE drawImages: %v", err)
+			return
+		}
+	}
+}
+
+// kittySequence places p's picture over its thumbnail, sending the picture
+// first if the terminal doesn't have it yet. A placement with the same ID
+// replaces the last one, so this may be repeated every draw.
+func (c *ChatView) kittySequence(p *preview, cw, ch int) string {
+	var b strings.Builder
+	if p.kittyID == 0 {
+		c.nextKittyID++
+		p.kittyID = c.nextKittyID
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, p.img); err != nil {
+			log.Printf("TRACE kittySequence: %v", err)
+		}
+		data := base64.StdEncoding.EncodeToString(buf.Bytes())
+		for first := true; data != "" || first; first = false {
+			chunk := data[:min(len(data), 4096)]
+			data = data[len(chunk):]
+			more := 0
+			if data != "" {
+				more = 1
+			}
+			if first {
+				fmt.Fprintf(&b, "\x1b_Ga=t,f=100,i=%d,q=2,m=%d;%s\x1b\\", p.kittyID, more, chunk)
+			} else {
+				fmt.Fprintf(&b, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+			}
+		}
+	}
+	// Fit the picture into the thumbnail's cells when their size is known;
+	// otherwise kitty stretches it over them.
+	cols, rows := p.cols, p.rows
+	if cw > 0 && ch > 0 {
+		w, h := fillSize(p.img.Bounds().Dx(), p.img.Bounds().Dy(), p.cols*cw, p.rows*ch)
+		cols, rows = max((w+cw/2)/cw, 1), max((h+ch/2)/ch, 1)
+	}
+	fmt.Fprintf(&b, "\x1b_Ga=p,i=%d,p=1,c=%d,r=%d,C=1,q=2\x1b\\", p.kittyID, cols, rows)
+	return b.String()
+}
+
+// sixelSequence is p's picture as sixel, fitted into its thumbnail's
+// cells of cw×ch pixels (0 = unknown).
+func (p *preview) sixelSequence(cw, ch int, bg color.RGBA) string {
+	if cw <= 0 || ch <= 0 {
+		cw, ch = defaultCellWidth, defaultCellHeight
+	}
+	// Whole sixel bands only, so the picture never spills into the line
+	// under the thumbnail.
+	w, h := fillSize(p.img.Bounds().Dx(), p.img.Bounds().Dy(), p.cols*cw, p.rows*ch/6*6)
+	h = max(h/6*6, 6)
+	if p.sixel == "" || p.sixelAt != image.Pt(w, h) {
+		p.sixel = encodeSixel(scaleImage(p.img, w, h), bg)
+		p.sixelAt = image.Pt(w, h)
+	}
+	return p.sixel
+}
+
+// encodeSixel encodes img as sixel, in the 6×6×6 color cube, with
+// transparent pixels blended into bg.
+func encodeSixel(img *image.RGBA, bg color.RGBA) string {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	level := func(v uint8) int { return (int(v)*5 + 127) / 255 }
+	index := make([]int, w*h)
+	used := make(map[int]bool)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := over(img.RGBAAt(x, y), bg)
+			i := level(c.R)*36 + level(c.G)*6 + level(c.B)
+			index[y*w+x] = i
+			used[i] = true
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\x1bP0;1;0q\"1;1;%d;%d", w, h)
+	for i := range used {
+		fmt.Fprintf(&b, "#%d;2;%d;%d;%d", i, i/36*20, i/6%6*20, i%6*20)
+	}
+	row := make([]byte, w)
+	for top := 0; top < h; top += 6 {
+		inBand := make(map[int]bool)
+		for y := top; y < min(top+6, h); y++ {
+			for x := 0; x < w; x++ {
+				inBand[index[y*w+x]] = true
+			}
+		}
+		colors := make([]int, 0, len(inBand))
+		for i := range inBand {
+			colors = append(colors, i)
+		}
+		sort.Ints(colors)
+		for n, i := range colors {
+			for x := 0; x < w; x++ {
+				bits := 0
+				for k := 0; k < 6 && top+k < h; k++ {
+					if index[(top+k)*w+x] == i {
+						bits |= 1 << k
+					}
+				}
+				row[x] = byte(63 + bits)
+			}
+			fmt.Fprintf(&b, "#%d", i)
+			writeSixelRuns(&b, row)
+			if n < len(colors)-1 {
+				b.WriteByte('$')
+			}
+		}
+		b.WriteByte('-')
+	}
+	b.WriteString("\x1b\\")
+	return b.String()
+}
+
+// writeSixelRuns writes a row of sixel characters, runs of four or more as
+// !<count><char>.
+func writeSixelRuns(b *strings.Builder, row []byte) {
+	for x := 0; x < len(row); {
+		n := 1
+		for x+n < len(row) && row[x+n] == row[x] {
+			n++
+		}
+		if n >= 4 {
+			fmt.Fprintf(b, "!%d%c", n, row[x])
+		} else {
+			for k := 0; k < n; k++ {
+				b.WriteByte(row[x])
+			}
+		}
+		x += n
+	}
+}
+
+// fitSize scales w×h down to fit within maxW×maxH, keeping its aspect
+// ratio; a smaller image keeps its size.
+func fitSize(w, h, maxW, maxH int) (int, int) {
+	if w <= 0 || h <= 0 {
+		return 1, 1
+	}
+	if w > maxW {
+		w, h = maxW, h*maxW/w
+	}
+	if h > maxH {
+		w, h = w*maxH/h, maxH
+	}
+	return max(w, 1), max(h, 1)
+}
+
+// fillSize scales w×h up or down to just fit within maxW×maxH, keeping its
+// aspect ratio.
+func fillSize(w, h, maxW, maxH int) (int, int) {
+	if w <= 0 || h <= 0 {
+		return 1, 1
+	}
+	if w*maxH > h*maxW {
+		return maxW, max(h*maxW/w, 1)
+	}
+	return max(w*maxH/h, 1), maxH
+}
+
+// scaleImage resizes src to w×h, averaging the source pixels under each
+// target pixel (at most 8×8 of them).
+func scaleImage(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(b.Min.Y+(y+1)*b.Dy()/h, y0+1)
+		ystep := max((y1-y0)/8, 1)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(b.Min.X+(x+1)*b.Dx()/w, x0+1)
+			xstep := max((x1-x0)/8, 1)
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy += ystep {
+				for sx := x0; sx < x1; sx += xstep {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+cr>>8, g+cg>>8, bl+cb>>8, a+ca>>8, n+1
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(bl / n), uint8(a / n)})
+		}
+	}
+	return dst
+}
+
+// over blends the premultiplied c over bg.
+func over(c, bg color.RGBA) color.RGBA {
+	k := 255 - uint32(c.A)
+	return color.RGBA{
+		R: uint8(uint32(c.R) + uint32(bg.R)*k/255),
+		G: uint8(uint32(c.G) + uint32(bg.G)*k/255),
+		B: uint8(uint32(c.B) + uint32(bg.B)*k/255),
+		A: 255,
+	}
+}
+
+// colorOf is c as RGB; the terminal's default color counts as black.
+func colorOf(c tcell.Color) color.RGBA {
+	r, g, b := c.RGB()
+	if r < 0 {
+		return color.RGBA{A: 255}
+	}
+	return color.RGBA{uint8(r), uint8(g), uint8(b), 255}
+}
@@ -0,0 +1,205 @@
+package views
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"cli-client/models"
+)
+
+// ── Inline formatting ─────────────────────────────────────────────────────
+//
+// Messages may mark text up the way chat users always have:
+//
+//	*bold*   _italic_   `code`
+//
+// The markers are dropped and the text between them drawn bold, italic or
+// in the theme's accent color. A marker only opens at the start of a word
+// and closes at the end of one, so 2*3*4, snake_case_names and URLs stay as
+// they are; nothing inside `code` is formatted. Formatting combines with
+// highlight rules, and "disable_formatting" in config.json turns it off.
+
+// inlineSpan is a run of formatted text: content[open:start] is its opening
+// marker and content[end:close] the closing one.
+type inlineSpan struct {
+	open, start, end, close int
+	kind                    byte // '*', '_' or '`'
+}
+
+// SetFormatting turns inline formatting on or off. Call it before the chat
+// screen is shown.
+func (c *ChatView) SetFormatting(on bool) {
+	c.formattingOff = !on
+}
+
+// styleContent renders content for the message view: the highlight spans
+// in their colors, inline formatting and everything else in colorTag, with
+// every '[' from the content escaped by sanitizeContent.
+func (c *ChatView) styleContent(content, colorTag string, highlights []models.HighlightSpan) string {
+	var marks []inlineSpan
+	if !c.formattingOff {
+		marks = parseInline(content)
+	}
+	if len(highlights) == 0 && len(marks) == 0 {
+		return sanitizeContent(content)
+	}
+
+	// Cut content wherever the style may change.
+	cuts := []int{0, len(content)}
+	for _, h := range highlights {
+		cuts = append(cuts, h.Start, h.End)
+	}
+	for _, m := range marks {
+		cuts = append(cuts, m.open, m.start, m.end, m.close)
+	}
+	sort.Ints(cuts)
+
+	var b strings.Builder
+	current := colorTag // what the text is drawn in at this point
+	for i := 0; i+1 < len(cuts); i++ {
+		from, to := cuts[i], cuts[i+1]
+		if from == to || inMarker(marks, from) {
+			continue
+		}
+		style := styleAt(from, colorTag, highlights, marks, c.theme.Accent)
+		if style != current {
+			b.WriteString("[-:-:-]" + style)
+			current = style
+		}
+		b.WriteString(sanitizeContent(content[from:to]))
+	}
+	if current != colorTag {
+		b.WriteString("[-:-:-]" + colorTag)
+	}
+	return b.String()
+}
+
+// styleAt returns the tags for the text at byte pos.
+func styleAt(pos int, colorTag string, highlights []models.HighlightSpan, marks []inlineSpan, accent string) string {
+	color := colorTag
+	attrs := ""
+	for _, m := range marks {
+		if pos < m.start || pos >= m.end {
+			continue
+		}
+		switch m.kind {
+		case '*':
+			attrs += "b"
+		case '_':
+			attrs += "i"
+		case '`':
+			color = "[" + accent + "]"
+		}
+	}
+	for _, h := range highlights {
+		if pos >= h.Start && pos < h.End {
+			color = safeColorTag(h.Color)
+		}
+	}
+	if attrs != "" {
+		return color + "[::" + attrs + "]"
+	}
+	return color
+}
+
+// inMarker reports whether pos is inside one of marks' markers.
+func inMarker(marks []inlineSpan, pos int) bool {
+	for _, m := range marks {
+		if pos >= m.open && pos < m.start || pos >= m.end && pos < m.close {
+			return true
+		}
+	}
+	return false
+}
+
+// parseInline finds the formatted runs in s. Code spans come first; bold
+// and italic may hold them and each other.
+func parseInline(s string) []inlineSpan {
+	if !strings.ContainsAny(s, "*_`") {
+		return nil
+	}
+	var code []inlineSpan
+	for i := 0; i < len(s); i++ {
+		if s[i] != '`' {
+			continue
+		}
+		j := strings.IndexByte(s[i+1:], '`')
+		if j < 0 {
+			break
+		}
+		if j > 0 {
+			code = append(code, inlineSpan{open: i, start: i + 1, end: i + 1 + j, close: i + 2 + j, kind: '`'})
+		}
+		i += j + 1
+	}
+
+	spans := code
+	for _, kind := range []byte{'*', '_'} {
+		for i := 0; i < len(s); i++ {
+			if sp, ok := spanAt(code, i); ok {
+				i = sp.close - 1
+				continue
+			}
+			if s[i] != kind || !opensAt(s, i) {
+				continue
+			}
+			for j := i + 2; j < len(s); j++ {
+				if sp, ok := spanAt(code, j); ok {
+					j = sp.close - 1 // a whole code span may be inside
+					continue
+				}
+				if s[j] == kind && closesAt(s, j) {
+					spans = append(spans, inlineSpan{open: i, start: i + 1, end: j, close: j + 1, kind: kind})
+					i = j
+					break
+				}
+			}
+		}
+	}
+	return spans
+}
+
+// spanAt returns the span byte pos lies in, markers included.
+func spanAt(spans []inlineSpan, pos int) (inlineSpan, bool) {
+	for _, sp := range spans {
+		if pos >= sp.open && pos < sp.close {
+			return sp, true
+		}
+	}
+	return inlineSpan{}, false
+}
+
+// opensAt reports whether the marker at i may open a run: at the start of
+// a word and followed by text.
+func opensAt(s string, i int) bool {
+	if i > 0 {
+		prev, _ := utf8.DecodeLastRuneInString(s[:i])
+		if isWordRune(prev) || prev == rune(s[i]) {
+			return false
+		}
+	}
+	next, _ := utf8.DecodeRuneInString(s[i+1:])
+	return i+1 < len(s) && !unicode.IsSpace(next) && next != rune(s[i])
+}
+
+// closesAt reports whether the marker at j may close a run: after text and
+// at the end of a word.
+func closesAt(s string, j int) bool {
+	prev, _ := utf8.DecodeLastRuneInString(s[:j])
+	if unicode.IsSpace(prev) {
+		return false
+	}
+	if j+1 < len(s) {
+		next, _ := utf8.DecodeRuneInString(s[j+1:])
+		if isWordRune(next) || next == rune(s[j]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
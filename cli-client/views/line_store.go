@@ -0,0 +1,102 @@
+package views
+
+import "strings"
+
+// ScrollbackLines is how many committed chat lines the message view keeps.
+// Older lines are dropped, so rendering cost stays bounded however long the
+// session runs.
+const ScrollbackLines = 2000
+
+// storedLine is one fully formatted chat line. id is the message ID for
+// lines that may be updated later, empty otherwise. under is shown below
+// text and kept when text is replaced: a message's reactions.
+type storedLine struct {
+	id    string
+	text  string
+	under string
+}
+
+// lineStore is a fixed-capacity ring of formatted lines. Every line gets a
+// sequence number when appended; the message-ID index stores sequence
+// numbers so it stays valid while the ring wraps.
+// Not safe for concurrent use — ChatView only touches it from the event loop.
+type lineStore struct {
+	ring  []storedLine
+	start int    // ring index of the oldest line
+	n     int    // number of lines held
+	base  uint64 // sequence number of the oldest line
+	byID  map[string]uint64
+}
+
+func newLineStore(capacity int) *lineStore {
+	return &lineStore{
+		ring: make([]storedLine, capacity),
+		byID: make(map[string]uint64),
+	}
+}
+
+// Len returns the number of lines held.
+func (s *lineStore) Len() int {
+	return s.n
+}
+
+// Append adds a line and reports whether the oldest line was evicted to
+// make room for it.
+func (s *lineStore) Append(id, text string) (evicted bool) {
+	if s.n == len(s.ring) {
+		old := s.ring[s.start]
+		if old.id != "" && s.byID[old.id] == s.base {
+			delete(s.byID, old.id)
+		}
+		s.start = (s.start + 1) % len(s.ring)
+		s.base++
+		s.n--
+		evicted = true
+	}
+	s.ring[(s.start+s.n)%len(s.ring)] = storedLine{id: id, text: text}
+	if id != "" {
+		s.byID[id] = s.base + uint64(s.n)
+	}
+	s.n++
+	return evicted
+}
+
+// Update replaces the text of the line appended with id. It reports false
+// if there is no such line, or it has already scrolled out.
+func (s *lineStore) Update(id, text string) bool {
+	seq, ok := s.byID[id]
+	if !ok || seq < s.base {
+		return false
+	}
+	s.ring[(s.start+int(seq-s.base))%len(s.ring)].text = text
+	return true
+}
+
+// SetUnder replaces what is shown below the line appended with id. It
+// reports false like Update.
+func (s *lineStore) SetUnder(id, text string) bool {
+	seq, ok := s.byID[id]
+	if !ok || seq < s.base {
+		return false
+	}
+	s.ring[(s.start+int(seq-s.base))%len(s.ring)].under = text
+	return true
+}
+
+// Reset drops every line.
+func (s *lineStore) Reset() {
+	for i := range s.ring {
+		s.ring[i] = storedLine{}
+	}
+	s.start, s.n = 0, 0
+	s.byID = make(map[string]uint64)
+}
+
+// AppendTo writes all lines, oldest first, to b.
+func (s *lineStore) AppendTo(b *strings.Builder) {
+	for i := 0; i < s.n; i++ {
+		line := s.ring[(s.start+i)%len(s.ring)]
+		b.WriteString(line.text)
+		b.WriteString(line.under)
+	}
+}
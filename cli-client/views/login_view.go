@@ -1,183 +1,335 @@
-package views
-
-import (
-	"fmt"
-	"strings"
-	"time"
-
-	"github.com/gdamore/tcell/v2"
-	"github.com/rivo/tview"
-)
-
-// colorOption represents one selectable color in the login palette.
-type colorOption struct {
-	name    string // plain name sent to the server e.g. "cyan"
-	tag     string // tview tag e.g. "[cyan]"
-	display string // label shown in the picker e.g. "Cyan"
-}
-
-var loginColors = []colorOption{
-	{"red", "[red]", "Red"},
-	{"green", "[green]", "Green"},
-	{"yellow", "[yellow]", "Yellow"},
-	{"cyan", "[cyan]", "Cyan"},
-	{"magenta", "[magenta]", "Magenta"},
-	{"blue", "[blue]", "Blue"},
-	{"white", "[white]", "White"},
-}
-
-// LoginView steps:
-//
-//	0 — enter username
-//	1 — pick color from palette
-//	2 — enter password (optional / ignored by backend)
-type LoginView struct {
-	app         *tview.Application
-	container   *tview.Flex
-	headerBox   *tview.Box
-	textView    *tview.TextView
-	inputField  *tview.InputField
-	onSubmit    func(username, color string)
-	currentStep int
-	username    string
-	chosenColor string // tview tag e.g. "[cyan]"
-}
-
-func NewLoginView(
-	app *tview.Application,
-	onSubmit func(string, string),
-) *LoginView {
-	l := &LoginView{
-		app:         app,
-		onSubmit:    onSubmit,
-		currentStep: 0,
-		chosenColor: "[cyan]", // sensible default
-	}
-	l.buildUI()
-	return l
-}
-
-func (l *LoginView) Primitive() tview.Primitive    { return l.container }
-func (l *LoginView) GetPrimitive() tview.Primitive { return l.container }
-
-func (l *LoginView) buildUI() {
-	l.headerBox = tview.NewBox()
-	l.headerBox.SetBorder(true)
-	l.headerBox.SetTitle(" TERMINAL MESSENGER v1.0.0 ")
-	l.headerBox.SetBackgroundColor(tcell.ColorBlack)
-
-	l.textView = tview.NewTextView()
-	l.textView.SetDynamicColors(true)
-	l.textView.SetTextAlign(tview.AlignLeft)
-	l.textView.SetBackgroundColor(tcell.ColorBlack)
-
-	l.inputField = tview.NewInputField()
-	l.inputField.SetLabel("> ")
-	l.inputField.SetPlaceholder("Type here...")
-	l.inputField.SetFieldBackgroundColor(tcell.ColorBlack)
-	l.inputField.SetFieldTextColor(tcell.ColorWhite)
-	l.inputField.SetDoneFunc(func(key tcell.Key) {
-		if key == tcell.KeyEnter {
-			l.handleEnter()
-		}
-	})
-
-	l.container = tview.NewFlex()
-	l.container.SetDirection(tview.FlexRow)
-	l.container.SetBackgroundColor(tcell.ColorBlack)
-	l.container.AddItem(l.headerBox, 3, 0, false)
-	l.container.AddItem(l.textView, 0, 1, false)
-	l.container.AddItem(l.inputField, 1, 0, true)
-}
-
-func (l *LoginView) handleEnter() {
-	text := strings.TrimSpace(l.inputField.GetText())
-	l.inputField.SetText("")
-
-	switch l.currentStep {
-
-	// ── Step 0: username ─────────────────────────────────────────────────────
-	case 0:
-		if text == "" {
-			return
-		}
-		l.username = text
-		l.currentStep = 1
-		l.showColorPicker()
-
-	// ── Step 1: color pick ───────────────────────────────────────────────────
-	case 1:
-		// Accept a number 1-N or a color name
-		chosen := l.parseColorInput(text)
-		if chosen == nil {
-			l.typewriterText(fmt.Sprintf(
-				"\n[red]Unknown choice '%s'. Enter a number (1-%d) or a color name.[white]\n",
-				text, len(loginColors),
-			))
-			return
-		}
-		l.chosenColor = chosen.tag
-		l.currentStep = 2
-
-		preview := fmt.Sprintf("\n%s● %s[-]  [dim]— your messages will appear in this color[-]\n", chosen.tag, chosen.display)
-		l.typewriterText(preview)
-		time.Sleep(50 * time.Millisecond)
-		l.typewriterText("\n[cyan]Enter a password[dim] (or press Enter to skip):[white] ")
-
-	// ── Step 2: password (optional) ──────────────────────────────────────────
-	case 2:
-		// Password is cosmetic — backend ignores it.
-		// Pass chosenColor as the second argument so the controller can apply it.
-		l.onSubmit(l.username, l.chosenColor)
-	}
-}
-
-// showColorPicker appends the color palette to the textView.
-func (l *LoginView) showColorPicker() {
-	var sb strings.Builder
-	sb.WriteString("\n[cyan]Choose your chat color:[white]\n\n")
-	for i, c := range loginColors {
-		sb.WriteString(fmt.Sprintf(
-			"  [dim]%d.[white]  %s██[-]  %s%s[-]\n",
-			i+1, c.tag, c.tag, c.display,
-		))
-	}
-	sb.WriteString("\n[dim]Type a number (1-7) or a color name:[white] ")
-	l.typewriterText(sb.String())
-}
-
-// parseColorInput accepts "1"–"7" or a plain name like "cyan".
-func (l *LoginView) parseColorInput(input string) *colorOption {
-	input = strings.ToLower(strings.TrimSpace(input))
-	// Numeric shortcut
-	for i, c := range loginColors {
-		if input == fmt.Sprintf("%d", i+1) {
-			return &loginColors[i]
-		}
-		if input == c.name {
-			return &loginColors[i]
-		}
-	}
-	return nil
-}
-
-// typewriterText displays text character by character for the terminal feel.
-func (l *LoginView) typewriterText(text string) {
-	go func() {
-		for _, char := range text {
-			l.app.QueueUpdateDraw(func() {
-				current := l.textView.GetText(false)
-				l.textView.SetText(current + string(char))
-			})
-			time.Sleep(10 * time.Millisecond)
-		}
-	}()
-}
-
-func (l *LoginView) StartUsernamePrompt() {
-	l.currentStep = 0
-	l.typewriterText(`[yellow]! Establishing secure connection...[white]
-[green]✓ Connection established.[white]
-
-[cyan]Tell us your username:[white] `)
-}
+package views
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// colorOption represents one selectable color in the login palette.
+type colorOption struct {
+	name    string // plain name sent to the server e.g. "cyan"
+	tag     string // tview tag e.g. "[cyan]"
+	display string // label shown in the picker e.g. "Cyan"
+}
+
+var loginColors = []colorOption{
+	{"red", "[red]", "Red"},
+	{"green", "[green]", "Green"},
+	{"yellow", "[yellow]", "Yellow"},
+	{"cyan", "[cyan]", "Cyan"},
+	{"magenta", "[magenta]", "Magenta"},
+	{"blue", "[blue]", "Blue"},
+	{"white", "[white]", "White"},
+}
+
+// LoginView steps:
+//
+//	0 — enter username
+//	1 — pick color from palette
+//	2 — enter room passphrase (masked, never sent; skipped when configured)
+//	3 — enter password (masked, verified by the server via /api/login)
+//	4 — after a refused login: create the account with that password? (y/N)
+//
+// With device login (see SetDeviceLogin) there is no username or password:
+// it starts at step 1 and, after step 2, shows the identity provider's
+// sign-in code while the controller waits for it to be approved.
+type LoginView struct {
+	app         *tview.Application
+	container   *tview.Flex
+	headerBox   *tview.Box
+	textView    *tview.TextView
+	inputField  *tview.InputField
+	onSubmit    func(username, color, password, passphrase string, register bool)
+	currentStep int
+	username    string
+	password    string // kept at step 4, to register with
+	chosenColor string // tview tag e.g. "[cyan]"
+	passphrase  string // "" = built-in key, or the configured one
+	askPhrase   bool   // false when config.json already has the passphrase
+	deviceLogin bool   // the relay signs in through an identity provider
+	submitting  bool   // true while /api/login is in flight — ignore Enter
+	blocked     bool   // the relay refused this client version — ignore Enter for good
+}
+
+func NewLoginView(
+	app *tview.Application,
+	onSubmit func(string, string, string, string, bool),
+) *LoginView {
+	l := &LoginView{
+		app:         app,
+		onSubmit:    onSubmit,
+		currentStep: 0,
+		chosenColor: "[cyan]", // sensible default
+		askPhrase:   true,
+	}
+	l.buildUI()
+	return l
+}
+
+func (l *LoginView) Primitive() tview.Primitive    { return l.container }
+func (l *LoginView) GetPrimitive() tview.Primitive { return l.container }
+
+func (l *LoginView) buildUI() {
+	l.headerBox = tview.NewBox()
+	l.headerBox.SetBorder(true)
+	l.headerBox.SetTitle(" TERMINAL MESSENGER v1.0.0 ")
+	l.headerBox.SetBackgroundColor(tcell.ColorBlack)
+
+	l.textView = tview.NewTextView()
+	l.textView.SetDynamicColors(true)
+	l.textView.SetTextAlign(tview.AlignLeft)
+	l.textView.SetBackgroundColor(tcell.ColorBlack)
+
+	l.inputField = tview.NewInputField()
+	l.inputField.SetLabel("> ")
+	l.inputField.SetPlaceholder("Type here...")
+	l.inputField.SetFieldBackgroundColor(tcell.ColorBlack)
+	l.inputField.SetFieldTextColor(tcell.ColorWhite)
+	l.inputField.SetDoneFunc(func(key tcell.Key) {
+		if key == tcell.KeyEnter {
+			l.handleEnter()
+		}
+	})
+
+	l.container = tview.NewFlex()
+	l.container.SetDirection(tview.FlexRow)
+	l.container.SetBackgroundColor(tcell.ColorBlack)
+	l.container.AddItem(l.headerBox, 3, 0, false)
+	l.container.AddItem(l.textView, 0, 1, false)
+	l.container.AddItem(l.inputField, 1, 0, true)
+}
+
+func (l *LoginView) handleEnter() {
+	if l.submitting || l.blocked {
+		return
+	}
+	raw := l.inputField.GetText()
+	text := strings.TrimSpace(raw)
+	l.inputField.SetText("")
+
+	switch l.currentStep {
+
+	// ── Step 0: username ─────────────────────────────────────────────────────
+	case 0:
+		if text == "" {
+			return
+		}
+		l.username = text
+		l.currentStep = 1
+		l.showColorPicker("")
+
+	// ── Step 1: color pick ───────────────────────────────────────────────────
+	case 1:
+		// Accept a number 1-N or a color name
+		chosen := l.parseColorInput(text)
+		if chosen == nil {
+			l.typewriterText(fmt.Sprintf(
+				"\n[red]Unknown choice '%s'. Enter a number (1-%d) or a color name.[white]\n",
+				text, len(loginColors),
+			))
+			return
+		}
+		l.chosenColor = chosen.tag
+
+		preview := fmt.Sprintf("\n%s● %s[-]  [dim]— your messages will appear in this color[-]\n", chosen.tag, chosen.display)
+		if l.askPhrase {
+			l.currentStep = 2
+			l.promptPassphrase(preview)
+		} else {
+			l.askCredentials(preview)
+		}
+
+	// ── Step 2: room passphrase ──────────────────────────────────────────────
+	case 2:
+		// Raw like the password. Empty keeps the key built into every client.
+		l.passphrase = raw
+		lead := "\n[dim]Room key derived from your passphrase — compare its fingerprint in the header.[-]\n"
+		if raw == "" {
+			lead = "\n[yellow]No passphrase — encrypted messages use the key built into every client.[white]\n"
+		}
+		l.askCredentials(lead)
+
+	// ── Step 3: password ─────────────────────────────────────────────────────
+	case 3:
+		// The raw (untrimmed) input is the password — spaces are significant.
+		// The controller verifies it against the server and calls back into
+		// ShowLoginError on rejection.
+		if l.deviceLogin {
+			l.submitDevice("")
+			return
+		}
+		l.submitting = true
+		l.typewriterText("\n[dim]Verifying credentials…[-]")
+		l.onSubmit(l.username, l.chosenColor, raw, l.passphrase, false)
+
+	// ── Step 4: register instead ─────────────────────────────────────────────
+	case 4:
+		password := l.password
+		l.password = ""
+		if !strings.EqualFold(text, "y") && !strings.EqualFold(text, "yes") {
+			l.currentStep = 3
+			l.promptPassword("")
+			return
+		}
+		l.submitting = true
+		l.typewriterText("\n[dim]Creating the account…[-]")
+		l.onSubmit(l.username, l.chosenColor, password, l.passphrase, true)
+	}
+}
+
+// askCredentials moves on to step 3: the password, or with device login
+// straight to signing in. lead is printed first.
+func (l *LoginView) askCredentials(lead string) {
+	l.currentStep = 3
+	if l.deviceLogin {
+		l.submitDevice(lead)
+		return
+	}
+	l.promptPassword(lead)
+}
+
+// submitDevice starts a device login; the controller answers with
+// ShowDeviceCode, then either leaves the screen or calls ShowLoginError.
+func (l *LoginView) submitDevice(lead string) {
+	l.submitting = true
+	l.inputField.SetMaskCharacter(0)
+	l.typewriterText(lead + "\n[dim]Starting sign-in…[-]")
+	l.onSubmit("", l.chosenColor, "", l.passphrase, false)
+}
+
+// SetDeviceLogin turns device login on: the relay signs users in through
+// an identity provider, so no username or password is asked for. Call it
+// before StartUsernamePrompt.
+func (l *LoginView) SetDeviceLogin(on bool) {
+	l.deviceLogin = on
+}
+
+// ShowDeviceCode tells the user where to approve the sign-in. completeURI,
+// when the provider has one, already holds the code.
+// Must be called from the tview event loop.
+func (l *LoginView) ShowDeviceCode(issuer, uri, completeURI, code string) {
+	text := fmt.Sprintf("\n[cyan]Sign in at[-] [::u]%s[::-] [cyan]with the code[-] [yellow::b]%s[-::-]\n",
+		tview.Escape(uri), tview.Escape(code))
+	if completeURI != "" {
+		text += fmt.Sprintf("[dim]or open %s[-]\n", tview.Escape(completeURI))
+	}
+	text += fmt.Sprintf("[dim]Waiting for %s to approve…[-]", tview.Escape(issuer))
+	l.typewriterText(text)
+}
+
+// promptPassphrase asks for the room passphrase, masked. It only ever leaves
+// this machine as the key it derives.
+func (l *LoginView) promptPassphrase(lead string) {
+	l.inputField.SetMaskCharacter('*')
+	l.typewriterText(lead + "\n[cyan]Room passphrase[-] [dim](shared with everyone you chat with, Enter for none):[white] ")
+}
+
+// SetAskPassphrase turns the passphrase step on or off; off when
+// config.json already provides one.
+func (l *LoginView) SetAskPassphrase(ask bool) {
+	l.askPhrase = ask
+}
+
+// promptPassword switches the input to masked mode and asks for the password.
+// lead is printed first in the same typewriter pass so the lines don't interleave.
+func (l *LoginView) promptPassword(lead string) {
+	l.inputField.SetMaskCharacter('*')
+	l.typewriterText(lead + "\n[cyan]Enter your password:[white] ")
+}
+
+// ShowLoginError reports a rejected login inline and re-prompts for the password.
+// Must be called from the tview event loop.
+func (l *LoginView) ShowLoginError(message string) {
+	l.submitting = false
+	l.currentStep = 3
+	if l.deviceLogin {
+		l.typewriterText(fmt.Sprintf("\n[red]✗ Sign-in failed: %s[white]\n[cyan]Press Enter to try again.[white] ", message))
+		return
+	}
+	l.promptPassword(fmt.Sprintf("\n[red]✗ Login failed: %s[white]\n", message))
+}
+
+// OfferRegister reports a refused login and asks whether to create the
+// account with the password just typed, since the relay doesn't say
+// whether the username exists. Must be called from the tview event loop.
+func (l *LoginView) OfferRegister(message, password string) {
+	l.submitting = false
+	l.currentStep = 4
+	l.password = password
+	l.inputField.SetMaskCharacter(0)
+	l.typewriterText(fmt.Sprintf("\n[red]✗ Login failed: %s[white]\n[cyan]New here? Create the account [white]%s[cyan] with this password? (y/N):[white] ",
+		message, tview.Escape(l.username)))
+}
+
+// ShowBlocked reports that this client can't be used with the relay and
+// stops taking input; only quitting is left. message may span lines.
+// Must be called from the tview event loop.
+func (l *LoginView) ShowBlocked(message string) {
+	l.blocked = true
+	l.inputField.SetPlaceholder("Ctrl+C to quit")
+	l.typewriterText(fmt.Sprintf("\n[red::b]✗ Client too old[-::-]\n[red]%s[white]\n", message))
+}
+
+// showColorPicker appends lead and the color palette to the textView.
+func (l *LoginView) showColorPicker(lead string) {
+	var sb strings.Builder
+	sb.WriteString(lead)
+	sb.WriteString("\n[cyan]Choose your chat color:[white]\n\n")
+	for i, c := range loginColors {
+		sb.WriteString(fmt.Sprintf(
+			"  [dim]%d.[white]  %s██[-]  %s%s[-]\n",
+			i+1, c.tag, c.tag, c.display,
+		))
+	}
+	sb.WriteString("\n[dim]Type a number (1-7) or a color name:[white] ")
+	l.typewriterText(sb.String())
+}
+
+// parseColorInput accepts "1"–"7" or a plain name like "cyan".
+func (l *LoginView) parseColorInput(input string) *colorOption {
+	input = strings.ToLower(strings.TrimSpace(input))
+	// Numeric shortcut
+	for i, c := range loginColors {
+		if input == fmt.Sprintf("%d", i+1) {
+			return &loginColors[i]
+		}
+		if input == c.name {
+			return &loginColors[i]
+		}
+	}
+	return nil
+}
+
+// typewriterText displays text character by character for the terminal feel.
+func (l *LoginView) typewriterText(text string) {
+	go func() {
+		for _, char := range text {
+			l.app.QueueUpdateDraw(func() {
+				current := l.textView.GetText(false)
+				l.textView.SetText(current + string(char))
+			})
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+}
+
+func (l *LoginView) StartUsernamePrompt() {
+	l.currentStep = 0
+	l.passphrase = ""
+	l.submitting = false
+	l.inputField.SetMaskCharacter(0)
+	connected := `[yellow]! Establishing secure connection...[white]
+[green]✓ Connection established.[white]
+`
+	if l.deviceLogin {
+		l.currentStep = 1
+		l.showColorPicker(connected + "\n[cyan]This relay signs you in through your organization — no password here.[white]\n")
+		return
+	}
+	l.typewriterText(connected + `
+[cyan]Tell us your username:[white] `)
+}
@@ -0,0 +1,283 @@
+package views
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// ── Monitor ───────────────────────────────────────────────────────────────
+//
+// MonitorView is the --monitor screen: no chat, only a dashboard of one
+// relay. A header names the relay and the watched room, the relay panel
+// shows /api/stats, the rate panel draws the room's messages per interval
+// as a bar sparkline as wide as the panel, and the events panel logs what
+// happened, newest last. q or Esc quits.
+
+// monitorEventLimit is how many events the events panel keeps.
+const monitorEventLimit = 500
+
+// sparkBlocks are the eighths a sparkline cell is drawn with.
+var sparkBlocks = []rune(" ▁▂▃▄▅▆▇█")
+
+// MonitorStats is one reading of the relay for the relay panel.
+type MonitorStats struct {
+	Status     string // "running", or "" before the first reading
+	Active     int    // clients seen recently
+	Waiting    int    // open long polls
+	MaxWaiters int
+	Buffered   int // messages the relay keeps
+	Fetched    time.Time
+	Latency    time.Duration
+	Err        string // why the last read failed, "" if it didn't; the rest is the last good one
+}
+
+// MonitorLevel is how an event is colored.
+type MonitorLevel int
+
+const (
+	MonitorInfo MonitorLevel = iota
+	MonitorOK
+	MonitorAlert
+)
+
+// MonitorView is the dashboard --monitor shows.
+type MonitorView struct {
+	app   *tview.Application
+	theme *Theme
+
+	root       *tview.Flex
+	header     *tview.TextView
+	statsView  *tview.TextView
+	rateBox    *tview.Box
+	eventsView *tview.TextView
+	footer     *tview.TextView
+
+	// Event loop only.
+	title     string
+	connected bool
+	who       string
+	rate      []int         // messages per bucket, oldest first
+	bucket    time.Duration // the time one rate entry covers
+	seen      int           // messages seen since the start
+	rateNote  string        // shown instead of the sparkline while rate is nil
+	events    []string
+}
+
+// NewMonitorView builds the dashboard. onQuit runs on q or Esc, from the
+// tview event loop.
+func NewMonitorView(app *tview.Application, onQuit func()) *MonitorView {
+	theme, _ := LookupTheme(DefaultTheme)
+	m := &MonitorView{app: app, theme: theme}
+
+	m.header = tview.NewTextView().SetDynamicColors(true)
+	m.statsView = tview.NewTextView().SetDynamicColors(true)
+	m.statsView.SetBorder(true).SetBorderPadding(0, 0, 1, 1).SetTitle(" Relay ")
+	m.rateBox = tview.NewBox()
+	m.rateBox.SetBorder(true).SetTitle(" Message rate ")
+	m.rateBox.SetDrawFunc(m.drawRate)
+	m.eventsView = tview.NewTextView().SetDynamicColors(true).SetWordWrap(true)
+	m.eventsView.SetBorder(true).SetBorderPadding(0, 0, 1, 1).SetTitle(" Events ")
+	m.footer = tview.NewTextView().SetDynamicColors(true)
+
+	top := tview.NewFlex().
+		AddItem(m.statsView, 40, 0, false).
+		AddItem(m.rateBox, 0, 1, false)
+	m.root = tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(m.header, 1, 0, false).
+		AddItem(top, 10, 0, false).
+		AddItem(m.eventsView, 0, 1, true).
+		AddItem(m.footer, 1, 0, false)
+	m.root.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape || event.Rune() == 'q' {
+			onQuit()
+			return nil
+		}
+		return event
+	})
+
+	m.applyTheme()
+	m.redrawHeader()
+	m.redrawStats(MonitorStats{})
+	return m
+}
+
+// Primitive is the dashboard's root, to pass to SetRoot.
+func (m *MonitorView) Primitive() tview.Primitive {
+	return m.root
+}
+
+// SetTheme restyles the dashboard. Call before it is shown.
+func (m *MonitorView) SetTheme(t *Theme) {
+	m.theme = t
+	m.applyTheme()
+	m.redrawHeader()
+}
+
+func (m *MonitorView) applyTheme() {
+	t := m.theme
+	for _, view := range []*tview.TextView{m.header, m.statsView, m.eventsView, m.footer} {
+		view.SetBackgroundColor(t.Background)
+		view.SetTextColor(t.Text)
+	}
+	for _, box := range []*tview.Box{m.statsView.Box, m.rateBox, m.eventsView.Box} {
+		box.SetBackgroundColor(t.Background)
+		box.SetBorderColor(t.Border)
+		box.SetTitleColor(t.Border)
+	}
+	m.root.SetBackgroundColor(t.Background)
+	m.footer.SetText(fmt.Sprintf(" [%s]q / Esc quit · ↑ ↓ PgUp PgDn scroll the events[-]", t.Muted))
+}
+
+// SetTitle names what is watched in the header, e.g. the relay's URL and
+// the room. Safe to call from any goroutine.
+func (m *MonitorView) SetTitle(title string) {
+	m.app.QueueUpdateDraw(func() {
+		m.title = title
+		m.redrawHeader()
+	})
+}
+
+// SetConnection shows in the header whether the room is being read, and as
+// whom. Safe to call from any goroutine.
+func (m *MonitorView) SetConnection(connected bool, who string) {
+	m.app.QueueUpdateDraw(func() {
+		m.connected, m.who = connected, who
+		m.redrawHeader()
+	})
+}
+
+func (m *MonitorView) redrawHeader() {
+	dot, state := "[red]●[-]", "not reading the room"
+	if m.connected {
+		dot, state = "[green]●[-]", "observing as "+tview.Escape(m.who)
+	}
+	m.header.SetText(fmt.Sprintf(" [%s::b]TTC monitor[-::-]  %s  %s  [%s]%s[-]",
+		m.theme.Accent, tview.Escape(m.title), dot, m.theme.Muted, state))
+}
+
+// SetStats shows a reading of the relay. Safe to call from any goroutine.
+func (m *MonitorView) SetStats(s MonitorStats) {
+	m.app.QueueUpdateDraw(func() { m.redrawStats(s) })
+}
+
+func (m *MonitorView) redrawStats(s MonitorStats) {
+	muted := m.theme.Muted
+	row := func(name, value string) string {
+		return fmt.Sprintf("[%s]%-9s[-] %s\n", muted, name, value)
+	}
+	var b strings.Builder
+	switch {
+	case s.Err != "":
+		b.WriteString(row("status", "[red::b]unreachable[-::-]"))
+	case s.Status == "running":
+		b.WriteString(row("status", "[green::b]running[-::-]"))
+	case s.Status == "":
+		b.WriteString(row("status", "[gray]waiting…[-]"))
+	default:
+		b.WriteString(row("status", "[yellow::b]"+tview.Escape(s.Status)+"[-::-]"))
+	}
+	b.WriteString(row("clients", fmt.Sprintf("[%s]%d[-]", m.theme.Accent, s.Active)))
+	b.WriteString(row("polling", fmt.Sprintf("%s %d / %d", gauge(s.Waiting, s.MaxWaiters, 12), s.Waiting, s.MaxWaiters)))
+	b.WriteString(row("buffered", fmt.Sprintf("%d messages", s.Buffered)))
+	if !s.Fetched.IsZero() {
+		b.WriteString(row("updated", fmt.Sprintf("%s [%s](%d ms)[-]", s.Fetched.Format("15:04:05"), muted, s.Latency.Milliseconds())))
+	}
+	m.statsView.SetText(b.String())
+}
+
+// gauge is a bar of width cells filled n/limit, green, then yellow from
+// 70% and red from 90%.
+func gauge(n, limit, width int) string {
+	if limit <= 0 {
+		return "[gray]" + strings.Repeat("░", width) + "[-]"
+	}
+	filled := min(width, n*width/limit)
+	color := "green"
+	switch {
+	case n*10 >= limit*9:
+		color = "red"
+	case n*10 >= limit*7:
+		color = "yellow"
+	}
+	return fmt.Sprintf("[%s]%s[gray]%s[-]", color, strings.Repeat("█", filled), strings.Repeat("░", width-filled))
+}
+
+// SetRate shows the room's messages per bucket, oldest first, and how many
+// were seen in all; nil rate shows note instead. Safe to call from any
+// goroutine.
+func (m *MonitorView) SetRate(rate []int, bucket time.Duration, seen int, note string) {
+	m.app.QueueUpdateDraw(func() {
+		m.rate, m.bucket, m.seen, m.rateNote = rate, bucket, seen, note
+	})
+}
+
+// drawRate draws the sparkline: the newest buckets that fit, one per
+// column, scaled to the busiest of them, over a line of totals.
+func (m *MonitorView) drawRate(screen tcell.Screen, x, y, width, height int) (int, int, int, int) {
+	x, y, width, height = x+2, y+1, width-4, height-2
+	if width <= 0 || height <= 1 {
+		return x, y, width, height
+	}
+	if m.rate == nil {
+		tview.Print(screen, "["+m.theme.Muted+"]"+tview.Escape(m.rateNote)+"[-]", x, y, width, tview.AlignLeft, m.theme.Text)
+		return x, y, width, height
+	}
+
+	bars := height - 1
+	shown := m.rate[max(0, len(m.rate)-width):]
+	peak := 0
+	for _, n := range shown {
+		peak = max(peak, n)
+	}
+	style := tcell.StyleDefault.Background(m.theme.Background).Foreground(tview.Styles.PrimaryTextColor)
+	if color := tcell.GetColor(m.theme.Accent); color != tcell.ColorDefault {
+		style = style.Foreground(color)
+	}
+	offset := width - len(shown) // right-aligned: the newest at the right edge
+	for col, n := range shown {
+		eighths := 0
+		if peak > 0 {
+			eighths = (n*bars*8 + peak - 1) / peak
+		}
+		for r := 0; r < bars; r++ {
+			level := min(8, max(0, eighths-r*8))
+			screen.SetContent(x+offset+col, y+bars-1-r, sparkBlocks[level], nil, style)
+		}
+	}
+
+	perMinute := 0
+	if m.bucket > 0 {
+		window := max(1, int(time.Minute/m.bucket))
+		for _, n := range m.rate[max(0, len(m.rate)-window):] {
+			perMinute += n
+		}
+	}
+	totals := fmt.Sprintf("[%s::b]%d[-::-]/min now · peak [%s]%d[-] per %s · %d seen · %s per column",
+		m.theme.Highlight, perMinute, m.theme.Accent, peak, m.bucket, m.seen, m.bucket)
+	tview.Print(screen, totals, x, y+bars, width, tview.AlignLeft, m.theme.Text)
+	return x, y, width, height
+}
+
+// AddEvent logs text, which may hold color tags, in the events panel. Safe
+// to call from any goroutine.
+func (m *MonitorView) AddEvent(at time.Time, level MonitorLevel, text string) {
+	m.app.QueueUpdateDraw(func() {
+		mark := "[" + m.theme.Muted + "]·[-]"
+		switch level {
+		case MonitorOK:
+			mark = "[green]●[-]"
+		case MonitorAlert:
+			mark = "[red]▲[-]"
+		}
+		m.events = append(m.events, fmt.Sprintf("[%s]%s[-] %s %s", m.theme.Muted, at.Format("15:04:05"), mark, text))
+		if len(m.events) > monitorEventLimit {
+			m.events = m.events[len(m.events)-monitorEventLimit:]
+		}
+		m.eventsView.SetText(strings.Join(m.events, "\n"))
+		m.eventsView.ScrollToEnd()
+	})
+}
@@ -0,0 +1,91 @@
+package views
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// ── Mouse ─────────────────────────────────────────────────────────────────
+//
+// With the mouse enabled (see AppController.LoadConfig) the wheel scrolls
+// the messages, a click in the input field focuses it and a click on a
+// username — in a message line or in the sidebar — puts "/whois <user>" in
+// the input field. Clicks elsewhere never take the focus from the input
+// field. Scrolling is handled in scrollback.go.
+//
+// Usernames in message lines are tview regions named userRegionPrefix plus
+// the hex of the name, since region IDs allow only a few characters.
+
+const userRegionPrefix = "u."
+
+// userRegion wraps text, the rendered username, in a clickable region.
+func userRegion(username, text string) string {
+	return `["` + userRegionPrefix + hex.EncodeToString([]byte(username)) + `"]` + text + `[""]`
+}
+
+// regionUser returns the username of a region made by userRegion.
+func regionUser(id string) (string, bool) {
+	h, ok := strings.CutPrefix(id, userRegionPrefix)
+	if !ok {
+		return "", false
+	}
+	name, err := hex.DecodeString(h)
+	if err != nil || len(name) == 0 {
+		return "", false
+	}
+	return string(name), true
+}
+
+func (c *ChatView) setupMouse() {
+	c.messageView.SetRegions(true)
+	c.messageView.SetHighlightedFunc(func(added, removed, remaining []string) {
+		if len(added) == 0 {
+			return
+		}
+		c.messageView.Highlight()
+		if name, ok := regionUser(added[0]); ok {
+			c.prefillWhois(name)
+		}
+	})
+	c.userList.SetMouseCapture(func(action tview.MouseAction, event *tcell.EventMouse) (tview.MouseAction, *tcell.EventMouse) {
+		if action != tview.MouseLeftClick {
+			return action, event
+		}
+		x, y := event.Position()
+		if c.userList.InInnerRect(x, y) {
+			_, top, _, _ := c.userList.GetInnerRect()
+			row, _ := c.userList.GetScrollOffset()
+			if i := y - top + row; i >= 0 && i < len(c.onlineUsers) {
+				c.prefillWhois(c.onlineUsers[i].Username)
+			}
+		}
+		return action, nil
+	})
+
+	// A press anywhere but the input field would focus that primitive and
+	// leave typed keys with nowhere to go.
+	c.container.SetMouseCapture(func(action tview.MouseAction, event *tcell.EventMouse) (tview.MouseAction, *tcell.EventMouse) {
+		if action == tview.MouseLeftDown && !c.inputField.InRect(event.Position()) {
+			return action, nil
+		}
+		return action, event
+	})
+}
+
+// prefillWhois puts "/whois <username>" in the input field and focuses it.
+// Must be called from the tview event loop.
+func (c *ChatView) prefillWhois(username string) {
+	c.inputField.SetText("/whois " + username)
+	c.historyIdx = -1
+	c.app.SetFocus(c.inputField)
+}
+
+// followEnd keeps the newest message in view unless the user scrolled back.
+func (c *ChatView) followEnd() {
+	if !c.scrolledBack {
+		c.messageView.ScrollToEnd()
+	}
+}
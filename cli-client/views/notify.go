@@ -0,0 +1,138 @@
+package views
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// ── Terminal notifications ────────────────────────────────────────────────
+//
+// Besides the bell, a mention or a direct message is announced with an
+// escape sequence the terminal emulator turns into a desktop notification:
+//
+//	OSC 9    ESC ] 9 ; body BEL                 iTerm2, WezTerm, foot, Ghostty, kitty
+//	OSC 777  ESC ] 777 ; notify ; title ; body BEL   urxvt, foot, some VTE builds
+//
+// It travels with the rest of the output, so it works over SSH without a
+// helper on either end. Inside tmux the sequence is wrapped in a DCS
+// passthrough, which tmux forwards when allow-passthrough is on. Terminals
+// that know neither sequence ignore it.
+
+// NotifyStyle picks the escape sequence used for terminal notifications.
+type NotifyStyle string
+
+const (
+	NotifyOSC9   NotifyStyle = "osc9"
+	NotifyOSC777 NotifyStyle = "osc777"
+	NotifyOff    NotifyStyle = "off"
+)
+
+// DefaultNotifyStyle is used when config.json doesn't name one.
+const DefaultNotifyStyle = NotifyOSC9
+
+// notifyBodyLimit caps the text shown in a notification, in runes.
+const notifyBodyLimit = 120
+
+// ParseNotifyStyle returns the style named s ("" = DefaultNotifyStyle).
+func ParseNotifyStyle(s string) (NotifyStyle, bool) {
+	switch style := NotifyStyle(strings.ToLower(strings.TrimSpace(s))); style {
+	case "":
+		return DefaultNotifyStyle, true
+	case NotifyOSC9, NotifyOSC777, NotifyOff:
+		return style, true
+	}
+	return DefaultNotifyStyle, false
+}
+
+// terminalNotifier queues notifications until the next draw, the only time
+// tview hands out the screen.
+type terminalNotifier struct {
+	mu      sync.Mutex
+	style   NotifyStyle
+	pending []string // escape sequences not yet written
+	tmux    bool
+}
+
+func newTerminalNotifier() *terminalNotifier {
+	return &terminalNotifier{style: DefaultNotifyStyle, tmux: os.Getenv("TMUX") != ""}
+}
+
+func (n *terminalNotifier) setStyle(style NotifyStyle) {
+	n.mu.Lock()
+	n.style = style
+	n.mu.Unlock()
+}
+
+// queue adds a notification; a few are kept at most, so a burst of
+// mentions doesn't pile up a screenful of popups.
+func (n *terminalNotifier) queue(title, body string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	seq := notifySequence(n.style, title, body)
+	if seq == "" || len(n.pending) >= 3 {
+		return
+	}
+	if n.tmux {
+		seq = "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+	}
+	n.pending = append(n.pending, seq)
+}
+
+// flush writes the queued sequences to screen's terminal.
+func (n *terminalNotifier) flush(screen tcell.Screen) {
+	n.mu.Lock()
+	pending := n.pending
+	n.pending = nil
+	n.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	tty, ok := screen.Tty()
+	if !ok || tty == nil {
+		return
+	}
+	for _, seq := range pending {
+		if _, err := tty.Write([]byte(seq)); err != nil {
+			log.Printf("TRACE terminalNotifier: %v", err)
+			return
+		}
+	}
+}
+
+// notifySequence renders a notification in style, or "" when it is off.
+func notifySequence(style NotifyStyle, title, body string) string {
+	title, body = notifyText(title), notifyText(body)
+	switch style {
+	case NotifyOSC9:
+		if body == "" {
+			return fmt.Sprintf("\x1b]9;%s\a", title)
+		}
+		return fmt.Sprintf("\x1b]9;%s: %s\a", title, body)
+	case NotifyOSC777:
+		// ';' separates the fields, so the title may not hold one.
+		return fmt.Sprintf("\x1b]777;notify;%s;%s\a", strings.ReplaceAll(title, ";", ","), body)
+	}
+	return ""
+}
+
+// notifyText blanks control characters, which could end the sequence early
+// or start another, and shortens s.
+func notifyText(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > notifyBodyLimit {
+		s = string(r[:notifyBodyLimit-1]) + "…"
+	}
+	return s
+}
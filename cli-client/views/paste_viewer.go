@@ -0,0 +1,88 @@
+package views
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// ── Paste viewer ──────────────────────────────────────────────────────────
+//
+// ShowPaste opens a paste (see /paste view) in a bordered box over the
+// chat, with line numbers, so a long snippet never enters the scrollback.
+// Arrow keys, PgUp/PgDn and the wheel scroll it; Esc, q or a click outside
+// the box closes it and gives the focus back to the input field. Messages
+// keep arriving underneath.
+
+const pastePage = "paste"
+
+// ShowPaste shows text in the paste viewer, replacing a paste already open,
+// highlighted like a code block (see codeblock.go) as syntax or, without
+// one, the language chroma guesses. title is shown in the border. Must be
+// called from the tview event loop.
+func (c *ChatView) ShowPaste(title, syntax, text string) {
+	text = strings.ReplaceAll(strings.TrimRight(text, "\n"), "\t", "    ")
+	lexer, _ := codeLexer(syntax, text)
+	lines := strings.Split(highlightCode(lexer, c.theme.Syntax, text, "\n"), "\n")
+	width := len(fmt.Sprint(len(lines)))
+	var b strings.Builder
+	for i, line := range lines {
+		fmt.Fprintf(&b, "[%s]%*d │[-] %s\n", c.theme.Muted, width, i+1, line)
+	}
+
+	view := tview.NewTextView()
+	view.SetDynamicColors(true)
+	view.SetScrollable(true)
+	view.SetWrap(false)
+	view.SetBackgroundColor(c.theme.Background)
+	view.SetTextColor(c.theme.Text)
+	view.SetBorder(true)
+	view.SetBorderColor(c.theme.Border)
+	view.SetTitle(" " + tview.Escape(title) + " · Esc to close ")
+	view.SetTitleColor(c.theme.Border)
+	view.SetText(b.String())
+	view.ScrollToBeginning()
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Key() == tcell.KeyEscape || event.Rune() == 'q' {
+			c.closePaste()
+			return nil
+		}
+		return event
+	})
+
+	c.root.AddPage(pastePage, centeredOverlay(view, c.closePaste), true, true)
+	c.app.SetFocus(view)
+}
+
+// centeredOverlay centers view over the chat at 80% of its size. The chat
+// underneath must not react to the mouse while the overlay is open; a click
+// beside view calls close instead.
+func centeredOverlay(view *tview.TextView, close func()) tview.Primitive {
+	box := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(nil, 0, 1, false).
+		AddItem(view, 0, 8, true).
+		AddItem(nil, 0, 1, false)
+	modal := tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(box, 0, 8, true).
+		AddItem(nil, 0, 1, false)
+	modal.SetMouseCapture(func(action tview.MouseAction, event *tcell.EventMouse) (tview.MouseAction, *tcell.EventMouse) {
+		if view.InRect(event.Position()) {
+			return action, event
+		}
+		if action == tview.MouseLeftClick {
+			close()
+		}
+		return tview.MouseConsumed, nil
+	})
+	return modal
+}
+
+// closePaste closes the paste viewer. Must be called from the tview event
+// loop.
+func (c *ChatView) closePaste() {
+	c.root.RemovePage(pastePage)
+	c.app.SetFocus(c.inputField)
+}
@@ -0,0 +1,169 @@
+package views
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// PrefixTemplate is a user-configured layout for the part of a chat line
+// that precedes the message body, e.g.
+//
+//	HH:MM │ %-12user │ msg
+//
+// Tokens:
+//
+//	HH, MM, SS   hour, minute, second of the message
+//	%user        the username as-is
+//	%12user      username right-aligned in a 12-column field
+//	%-12user     username left-aligned in a 12-column field
+//	msg          the message body — must come last
+//
+// Everything else is literal text drawn in gray. Field widths count terminal
+// columns, not bytes, so CJK and emoji names stay aligned; names wider than
+// the field are truncated with "…".
+type PrefixTemplate struct {
+	segments []prefixSegment
+}
+
+type prefixKind int
+
+const (
+	segLiteral prefixKind = iota
+	segHour
+	segMinute
+	segSecond
+	segUser
+)
+
+type prefixSegment struct {
+	kind      prefixKind
+	text      string // segLiteral only
+	width     int    // segUser: 0 = natural width
+	leftAlign bool   // segUser
+}
+
+// ParsePrefixTemplate compiles a template string. An empty string returns
+// nil, which means the built-in "[HH:MM] [user] msg" layout.
+func ParsePrefixTemplate(tmpl string) (*PrefixTemplate, error) {
+	if tmpl == "" {
+		return nil, nil
+	}
+	body := strings.LastIndex(tmpl, "msg")
+	if body < 0 {
+		return nil, errors.New("prefix template must contain msg")
+	}
+	if strings.TrimSpace(tmpl[body+len("msg"):]) != "" {
+		return nil, errors.New("msg must be the last token of the prefix template")
+	}
+
+	t := &PrefixTemplate{}
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			t.segments = append(t.segments, prefixSegment{kind: segLiteral, text: lit.String()})
+			lit.Reset()
+		}
+	}
+	s := tmpl[:body]
+	for i := 0; i < len(s); {
+		switch {
+		case strings.HasPrefix(s[i:], "HH"):
+			flush()
+			t.segments = append(t.segments, prefixSegment{kind: segHour})
+			i += 2
+		case strings.HasPrefix(s[i:], "MM"):
+			flush()
+			t.segments = append(t.segments, prefixSegment{kind: segMinute})
+			i += 2
+		case strings.HasPrefix(s[i:], "SS"):
+			flush()
+			t.segments = append(t.segments, prefixSegment{kind: segSecond})
+			i += 2
+		case s[i] == '%':
+			seg, n, err := parseUserField(s[i:])
+			if err != nil {
+				return nil, err
+			}
+			flush()
+			t.segments = append(t.segments, seg)
+			i += n
+		default:
+			lit.WriteByte(s[i])
+			i++
+		}
+	}
+	flush()
+	return t, nil
+}
+
+// parseUserField parses "%[-][width]user" at the start of s and returns the
+// segment and the number of bytes consumed.
+func parseUserField(s string) (prefixSegment, int, error) {
+	seg := prefixSegment{kind: segUser}
+	i := 1
+	if i < len(s) && s[i] == '-' {
+		seg.leftAlign = true
+		i++
+	}
+	start := i
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	if i > start {
+		w, err := strconv.Atoi(s[start:i])
+		if err != nil || w > 64 {
+			return seg, 0, fmt.Errorf("bad username width %q", s[start:i])
+		}
+		seg.width = w
+	}
+	if !strings.HasPrefix(s[i:], "user") {
+		return seg, 0, fmt.Errorf("unknown token %q — expected %%[-][width]user", s[:min(len(s), i+4)])
+	}
+	return seg, i + len("user"), nil
+}
+
+// Render builds the tview-tagged prefix for one line. colorTag is the
+// already-validated sender color; the returned string ends with it so the
+// body that follows is drawn in the sender's color. Times and literal text
+// are drawn in muted, a color name.
+func (t *PrefixTemplate) Render(ts time.Time, username, colorTag, muted string) string {
+	open := "[" + muted + "]"
+	var b strings.Builder
+	for _, seg := range t.segments {
+		switch seg.kind {
+		case segLiteral:
+			b.WriteString(open + sanitizeContent(seg.text) + "[-]")
+		case segHour:
+			b.WriteString(open + ts.Format("15") + "[-]")
+		case segMinute:
+			b.WriteString(open + ts.Format("04") + "[-]")
+		case segSecond:
+			b.WriteString(open + ts.Format("05") + "[-]")
+		case segUser:
+			b.WriteString(userRegion(username, colorTag+sanitizeContent(padColumns(username, seg.width, seg.leftAlign))) + "[-]")
+		}
+	}
+	b.WriteString(colorTag)
+	return b.String()
+}
+
+// padColumns fits s into width terminal columns, truncating with "…" when
+// it is too wide. width 0 returns s unchanged.
+func padColumns(s string, width int, leftAlign bool) string {
+	if width <= 0 {
+		return s
+	}
+	if runewidth.StringWidth(s) > width {
+		s = runewidth.Truncate(s, width, "…")
+	}
+	pad := strings.Repeat(" ", width-runewidth.StringWidth(s))
+	if leftAlign {
+		return s + pad
+	}
+	return pad + s
+}
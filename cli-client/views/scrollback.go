@@ -0,0 +1,119 @@
+package views
+
+import (
+	"fmt"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// ── Scroll-lock ───────────────────────────────────────────────────────────
+//
+// The wheel and PgUp / PgDn scroll the messages while the input field keeps
+// the focus. Once the view is scrolled up it stays where it is: new messages
+// no longer pull it to the end but are counted, and the command bar shows
+// "↓ N new messages" instead of the command hint. End, a click on the
+// command bar, sending anything, or scrolling down to the end again jump
+// back to the newest message.
+
+// scrollBy scrolls the messages by lines (negative is up). Must be called
+// from the tview event loop.
+func (c *ChatView) scrollBy(lines int) {
+	row, _ := c.messageView.GetScrollOffset()
+	_, _, _, height := c.messageView.GetInnerRect()
+	row = max(0, row+lines)
+	if row+height >= c.messageView.GetWrappedLineCount() {
+		c.jumpToEnd()
+		return
+	}
+	c.messageView.ScrollTo(row, 0)
+	if !c.scrolledBack {
+		c.scrolledBack = true
+		c.redrawCommandBar()
+	}
+}
+
+// scrollPage scrolls the messages by a page, up if up.
+func (c *ChatView) scrollPage(up bool) {
+	_, _, _, height := c.messageView.GetInnerRect()
+	page := max(1, height-1)
+	if up {
+		page = -page
+	}
+	c.scrollBy(page)
+}
+
+// jumpToEnd shows the newest message and follows new ones again. Must be
+// called from the tview event loop.
+func (c *ChatView) jumpToEnd() {
+	wasBack := c.scrolledBack
+	c.scrolledBack = false
+	c.unread = 0
+	c.messageView.ScrollToEnd()
+	if wasBack {
+		c.redrawCommandBar()
+	}
+}
+
+// noteUnread counts a message that arrived while the view is scrolled up.
+// Must be called from the tview event loop.
+func (c *ChatView) noteUnread() {
+	if !c.scrolledBack {
+		return
+	}
+	c.unread++
+	c.redrawCommandBar()
+}
+
+// scrollIndicator is the command bar text while the view is scrolled up.
+func (c *ChatView) scrollIndicator() string {
+	what := "scrolled back"
+	switch c.unread {
+	case 0:
+	case 1:
+		what = "1 new message"
+	default:
+		what = fmt.Sprintf("%d new messages", c.unread)
+	}
+	return fmt.Sprintf("[%s::b] ↓ %s [-::-] [dim]End or click here jumps to the newest[-]", c.theme.Highlight, what)
+}
+
+// handleScrollKey scrolls for PgUp, PgDn and — while scrolled up — End,
+// and reports whether it took the key.
+func (c *ChatView) handleScrollKey(event *tcell.EventKey) bool {
+	switch event.Key() {
+	case tcell.KeyPgUp:
+		c.scrollPage(true)
+	case tcell.KeyPgDn:
+		c.scrollPage(false)
+	case tcell.KeyEnd:
+		if !c.scrolledBack {
+			return false // cursor to the end of the line
+		}
+		c.jumpToEnd()
+	default:
+		return false
+	}
+	return true
+}
+
+func (c *ChatView) setupScrollLock() {
+	c.messageView.SetMouseCapture(func(action tview.MouseAction, event *tcell.EventMouse) (tview.MouseAction, *tcell.EventMouse) {
+		switch action {
+		case tview.MouseScrollUp:
+			c.scrollBy(-1)
+			return action, nil
+		case tview.MouseScrollDown:
+			c.scrollBy(1)
+			return action, nil
+		}
+		return action, event
+	})
+	c.commandBar.SetMouseCapture(func(action tview.MouseAction, event *tcell.EventMouse) (tview.MouseAction, *tcell.EventMouse) {
+		if action == tview.MouseLeftClick && c.scrolledBack {
+			c.jumpToEnd()
+			return action, nil
+		}
+		return action, event
+	})
+}
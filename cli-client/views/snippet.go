@@ -0,0 +1,95 @@
+package views
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"cli-client/models"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// ── Snippets ──────────────────────────────────────────────────────────────
+//
+// A snippet (see models.Snippets) is expanded into the input field, never
+// sent straight away: with /snip <name>, or by typing SnippetTrigger and the
+// name — ";standup" — and pressing Tab. The cursor lands on the first {}
+// field; Tab moves on to the next one until all are filled in, then Enter
+// sends as usual.
+
+// SnippetTrigger starts a snippet name typed into the input field.
+const SnippetTrigger = ";"
+
+// SetSnippets installs the snippets from config.json. Call it before the
+// chat screen is shown.
+func (c *ChatView) SetSnippets(s models.Snippets) {
+	c.snippets = s
+}
+
+// SnippetNames lists the snippets in alphabetical order.
+func (c *ChatView) SnippetNames() []string {
+	return c.snippets.Names()
+}
+
+// ExpandSnippet replaces the input field's text with the snippet called
+// name and reports whether there is one. Must be called from the tview
+// event loop.
+func (c *ChatView) ExpandSnippet(name string) bool {
+	text, fields, ok := c.snippets.Expand(name, models.SnippetVars{
+		Now:  time.Now(),
+		User: c.headerUsername,
+		Room: c.headerRoom,
+	})
+	if !ok {
+		return false
+	}
+	c.historyIdx = -1
+	c.inputField.SetText(text)
+	n := utf8.RuneCountInString(text)
+	c.snipFields = c.snipFields[:0]
+	for _, pos := range fields {
+		c.snipFields = append(c.snipFields, n-pos)
+	}
+	c.app.SetFocus(c.inputField)
+	// /snip runs from the input field's Enter handler, which holds a lock
+	// moving the cursor needs; do it once that has returned.
+	go c.app.QueueUpdateDraw(c.nextSnippetField)
+	return true
+}
+
+// snippetTab handles Tab in the input field: it expands ";name" or moves
+// to the next {} field, and reports whether it did either.
+func (c *ChatView) snippetTab() bool {
+	text := c.inputField.GetText()
+	if name, ok := strings.CutPrefix(text, SnippetTrigger); ok && name != "" && !strings.ContainsAny(name, " \t") {
+		if c.ExpandSnippet(name) {
+			return true
+		}
+	}
+	if len(c.snipFields) == 0 {
+		return false
+	}
+	c.nextSnippetField()
+	return true
+}
+
+// nextSnippetField puts the cursor on the next {} field, if any is left.
+// Fields are kept counted from the end of the text, so typing into an
+// earlier one doesn't move the later ones.
+func (c *ChatView) nextSnippetField() {
+	if len(c.snipFields) == 0 {
+		return
+	}
+	pos := max(0, utf8.RuneCountInString(c.inputField.GetText())-c.snipFields[0])
+	c.snipFields = c.snipFields[1:]
+
+	// InputField has no cursor setter; walk there like the user would.
+	handle := c.inputField.InputHandler()
+	noFocus := func(tview.Primitive) {}
+	handle(tcell.NewEventKey(tcell.KeyHome, 0, tcell.ModNone), noFocus)
+	for i := 0; i < pos; i++ {
+		handle(tcell.NewEventKey(tcell.KeyRight, 0, tcell.ModNone), noFocus)
+	}
+}
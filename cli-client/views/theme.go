@@ -0,0 +1,166 @@
+package views
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// ── Themes ────────────────────────────────────────────────────────────────
+//
+// A Theme is the chat screen's color scheme: backgrounds, borders, the
+// header's accent, system lines, timestamps and the username palette. It is
+// picked with "theme" in config.json or /theme <name>, and SetTheme restyles
+// the screen in place.
+//
+// Senders choose their username color and send it with each message, as a
+// tag like "[magenta]". A theme doesn't change that choice: its Palette
+// maps the built-in colors to ones that read well on its background, so
+// everyone stays told apart. Hex colors pass through unchanged.
+
+// Theme is a named color scheme for the chat screen. The string fields are
+// tview color names or "#rrggbb".
+type Theme struct {
+	Name       string
+	Background tcell.Color // every box on the chat screen
+	Text       tcell.Color // message text and the input field
+	Border     tcell.Color // header and sidebar borders and titles
+	Accent     string      // room label (unless the relay sets one), key fingerprint, counters
+	System     string      // "▸ …" system lines
+	Muted      string      // timestamps and prefix literals
+	Highlight  string      // your own @name in the header
+	// Syntax is the chroma style code blocks are highlighted in; "" draws
+	// them uncolored.
+	Syntax string
+	// Palette maps the built-in username tags (see models.GetUsernameColor)
+	// and "[white]", the fallback, to this theme's; nil keeps them.
+	Palette map[string]string
+}
+
+// DefaultTheme is the look the client has always had.
+const DefaultTheme = "dark"
+
+var themes = map[string]*Theme{
+	"dark": {
+		Name:       "dark",
+		Background: tcell.ColorBlack,
+		Text:       tcell.ColorWhite,
+		Border:     tcell.ColorDarkCyan,
+		Accent:     "cyan",
+		System:     "yellow",
+		Muted:      "gray",
+		Highlight:  "yellow",
+		Syntax:     "monokai",
+	},
+	"light": {
+		Name:       "light",
+		Background: tcell.ColorWhite,
+		Text:       tcell.ColorBlack,
+		Border:     tcell.ColorNavy,
+		Accent:     "navy",
+		System:     "darkorange",
+		Muted:      "gray",
+		Highlight:  "purple",
+		Syntax:     "github",
+		Palette: map[string]string{
+			"[magenta]": "[purple]",
+			"[green]":   "[darkgreen]",
+			"[cyan]":    "[teal]",
+			"[yellow]":  "[olive]",
+			"[red]":     "[maroon]",
+			"[blue]":    "[navy]",
+			"[white]":   "[black]",
+		},
+	},
+	"solarized": {
+		Name:       "solarized",
+		Background: tcell.NewHexColor(0x002b36),
+		Text:       tcell.NewHexColor(0x93a1a1),
+		Border:     tcell.NewHexColor(0x268bd2),
+		Accent:     "#2aa198",
+		System:     "#b58900",
+		Muted:      "#586e75",
+		Highlight:  "#cb4b16",
+		Syntax:     "solarized-dark",
+		Palette: map[string]string{
+			"[magenta]": "[#d33682]",
+			"[green]":   "[#859900]",
+			"[cyan]":    "[#2aa198]",
+			"[yellow]":  "[#b58900]",
+			"[red]":     "[#dc322f]",
+			"[blue]":    "[#268bd2]",
+			"[white]":   "[#93a1a1]",
+		},
+	},
+	"monochrome": {
+		Name:       "monochrome",
+		Background: tcell.ColorBlack,
+		Text:       tcell.ColorWhite,
+		Border:     tcell.ColorGray,
+		Accent:     "white",
+		System:     "silver",
+		Muted:      "gray",
+		Highlight:  "white",
+		Palette: map[string]string{
+			"[magenta]": "[white]",
+			"[green]":   "[white]",
+			"[cyan]":    "[white]",
+			"[yellow]":  "[white]",
+			"[red]":     "[white]",
+			"[blue]":    "[white]",
+		},
+	},
+}
+
+// LookupTheme returns the theme called name, ignoring case.
+func LookupTheme(name string) (*Theme, bool) {
+	t, ok := themes[strings.ToLower(strings.TrimSpace(name))]
+	return t, ok
+}
+
+// ThemeNames lists the themes in alphabetical order.
+func ThemeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// userColor maps a validated username tag through the palette.
+func (t *Theme) userColor(tag string) string {
+	if mapped, ok := t.Palette[strings.ToLower(tag)]; ok {
+		return mapped
+	}
+	return tag
+}
+
+// SetTheme restyles the chat screen with t. Lines already shown keep their
+// colors until they are rendered again — AppController follows up with
+// SetMessages. Must be called from the tview event loop, or before the
+// chat screen is shown.
+func (c *ChatView) SetTheme(t *Theme) {
+	c.theme = t
+	for _, view := range []*tview.TextView{c.header, c.messageView, c.userList, c.commandBar, c.typingBar, c.footer} {
+		view.SetBackgroundColor(t.Background)
+		view.SetTextColor(t.Text)
+	}
+	c.container.SetBackgroundColor(t.Background)
+	c.inputField.SetBackgroundColor(t.Background)
+	c.inputField.SetFieldBackgroundColor(t.Background)
+	c.inputField.SetFieldTextColor(t.Text)
+	c.inputField.SetLabelColor(tcell.GetColor(t.Highlight))
+	c.userList.SetBorderColor(t.Border)
+	c.userList.SetTitleColor(t.Border)
+	c.SetRoom(c.headerRoom, c.headerAccent)
+	c.redrawUserList()
+	c.redrawCommandBar()
+}
+
+// Theme returns the theme the chat screen is drawn in.
+func (c *ChatView) Theme() *Theme {
+	return c.theme
+}
@@ -0,0 +1,176 @@
+package views
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// ── Onboarding tour ───────────────────────────────────────────────────────
+//
+// A tour is a list of steps drawn over the chat screen one at a time:
+// everything but the step's target is dimmed and a callout next to the
+// target explains it. Enter, → or a click moves on, ← goes back and Esc
+// ends the tour early. Messages keep arriving underneath.
+//
+// The overlay is a page above the chat that draws nothing of its own but
+// the callout — Pages has already drawn the chat, so dimming is a pass over
+// the screen's cells.
+
+const tourPage = "tour"
+
+// tourCalloutWidth is the widest a callout gets.
+const tourCalloutWidth = 54
+
+// TourStep is one callout of a tour.
+type TourStep struct {
+	Target tview.Primitive // left undimmed, the callout beside it; nil centers the callout
+	Title  string
+	Text   string // may contain color tags
+}
+
+// tourOverlay is the page that shows a tour's current step and takes the
+// keys and the mouse while it is open.
+type tourOverlay struct {
+	*tview.Box
+	chat    *ChatView
+	steps   []TourStep
+	step    int
+	callout *tview.TextView
+	onEnd   func(completed bool)
+}
+
+// StartTour walks through the chat screen: header, messages, the online
+// list, the command bar and the input field. onEnd is called once the tour
+// closes, with completed false if it was skipped. Must be called from the
+// tview event loop.
+func (c *ChatView) StartTour(onEnd func(completed bool)) {
+	c.startTour([]TourStep{
+		{nil, "Welcome to TTC", "A quick look around the chat screen.\n\nEnter or → goes on, ← goes back, Esc skips the tour. /tour shows it again."},
+		{c.header, "Header", "Who you are, the room, whether messages are encrypted 🔒, the room key's fingerprint 🔑 and your link to the relay."},
+		{c.messageView, "Messages", "The room. The wheel or PgUp scrolls back and End returns, a click on a name asks /whois, and /history loads older messages."},
+		{c.userList, "Online", "Who is in the room right now."},
+		{c.commandBar, "Commands", "The commands at hand and the display mode — /mode switches between static and word-by-word."},
+		{c.inputField, "Input", "Type and press Enter to send. ↑ / ↓ bring back what you sent; a line starting with / is a command."},
+		{c.inputField, "/help", "/help lists every command. That's the tour — enjoy the chat."},
+	}, onEnd)
+}
+
+func (c *ChatView) startTour(steps []TourStep, onEnd func(completed bool)) {
+	if len(steps) == 0 {
+		return
+	}
+	callout := tview.NewTextView()
+	callout.SetDynamicColors(true)
+	callout.SetWordWrap(true)
+	callout.SetBorder(true)
+	callout.SetBorderPadding(0, 0, 1, 1)
+
+	o := &tourOverlay{Box: tview.NewBox(), chat: c, steps: steps, callout: callout, onEnd: onEnd}
+	c.root.AddPage(tourPage, o, true, true)
+	c.app.SetFocus(o)
+}
+
+// end closes the tour.
+func (o *tourOverlay) end(completed bool) {
+	o.chat.root.RemovePage(tourPage)
+	o.chat.app.SetFocus(o.chat.inputField)
+	if o.onEnd != nil {
+		o.onEnd(completed)
+	}
+}
+
+func (o *tourOverlay) next() {
+	if o.step == len(o.steps)-1 {
+		o.end(true)
+		return
+	}
+	o.step++
+}
+
+func (o *tourOverlay) Draw(screen tcell.Screen) {
+	step := o.steps[o.step]
+	theme := o.chat.theme
+
+	tx, ty, tw, th := -1, -1, 0, 0
+	if step.Target != nil {
+		tx, ty, tw, th = step.Target.GetRect()
+	}
+	sw, sh := screen.Size()
+	muted := tcell.GetColor(theme.Muted)
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			if x >= tx && x < tx+tw && y >= ty && y < ty+th {
+				continue
+			}
+			mainc, combc, style, _ := screen.GetContent(x, y)
+			screen.SetContent(x, y, mainc, combc, style.Foreground(muted).Dim(true))
+		}
+	}
+
+	// Size the callout to its wrapped text, then put it under the target,
+	// over it, or in the middle of the screen — whichever fits first.
+	w := min(tourCalloutWidth, sw-2)
+	hint := "Enter next · ← back · Esc skip"
+	if o.step == len(o.steps)-1 {
+		hint = "Enter done"
+	}
+	text := step.Text + "\n\n[" + theme.Muted + "]" + hint + "[-]"
+	h := 2
+	for _, para := range strings.Split(text, "\n") {
+		h += max(1, len(tview.WordWrap(para, w-4)))
+	}
+	x, y := (sw-w)/2, (sh-h)/2
+	if step.Target != nil {
+		x = max(0, min(tx+2, sw-w))
+		switch {
+		case ty+th+h <= sh:
+			y = ty + th
+		case ty-h >= 0:
+			y = ty - h
+		}
+	}
+
+	o.callout.SetBackgroundColor(theme.Background)
+	o.callout.SetTextColor(theme.Text)
+	o.callout.SetBorderColor(tcell.GetColor(theme.Highlight))
+	o.callout.SetTitleColor(tcell.GetColor(theme.Highlight))
+	o.callout.SetTitle(fmt.Sprintf(" %s · %d/%d ", tview.Escape(step.Title), o.step+1, len(o.steps)))
+	o.callout.SetText(text)
+	o.callout.SetRect(x, y, w, h)
+	o.callout.Draw(screen)
+}
+
+func (o *tourOverlay) InputHandler() func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
+	return o.WrapInputHandler(func(event *tcell.EventKey, setFocus func(p tview.Primitive)) {
+		switch event.Key() {
+		case tcell.KeyEnter, tcell.KeyRight, tcell.KeyTab:
+			o.next()
+		case tcell.KeyLeft, tcell.KeyBackspace, tcell.KeyBackspace2:
+			if o.step > 0 {
+				o.step--
+			}
+		case tcell.KeyEscape:
+			o.end(false)
+		case tcell.KeyRune:
+			switch event.Rune() {
+			case ' ':
+				o.next()
+			case 'q':
+				o.end(false)
+			}
+		}
+	})
+}
+
+// MouseHandler keeps the mouse from the chat underneath; a click moves on.
+func (o *tourOverlay) MouseHandler() func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+	return o.WrapMouseHandler(func(action tview.MouseAction, event *tcell.EventMouse, setFocus func(p tview.Primitive)) (consumed bool, capture tview.Primitive) {
+		if action == tview.MouseLeftClick {
+			o.next()
+		}
+		return true, nil
+	})
+}
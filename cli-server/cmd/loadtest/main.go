@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type Config struct {
+	Target      string
+	AccessKey   string
+	Password    string
+	Clients     int
+	SendRate    float64
+	Duration    time.Duration
+	Poll        bool
+	PollTimeout time.Duration
+}
+
+type sendRequest struct {
+	Token    string `json:"token"`
+	ClientID string `json:"client_id"`
+	Username string `json:"username"`
+	Content  string `json:"content"`
+	Color    string `json:"color"`
+}
+
+type credentialsRequest struct {
+	AccessKey string `json:"access_key"`
+	ClientID  string `json:"client_id"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+}
+
+type loginResponse struct {
+	Token string `json:"token"`
+}
+
+type sendResponse struct {
+	Status string `json:"status"`
+	ID     string `json:"id"`
+	Time   string `json:"time"`
+}
+
+// Recorder collects latency samples and error counts for one operation type.
+type Recorder struct {
+	mu        sync.Mutex
+	samples   []time.Duration
+	errors    map[string]int
+	successes int
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{errors: make(map[string]int)}
+}
+
+func (r *Recorder) Success(d time.Duration) {
+	r.mu.Lock()
+	r.samples = append(r.samples, d)
+	r.successes++
+	r.mu.Unlock()
+}
+
+func (r *Recorder) Error(reason string) {
+	r.mu.Lock()
+	r.errors[reason]++
+	r.mu.Unlock()
+}
+
+func (r *Recorder) Report(name string, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	total := r.successes
+	errCount := 0
+	for _, n := range r.errors {
+		errCount += n
+	}
+	total += errCount
+
+	fmt.Printf("\n%s\n", name)
+	if total == 0 {
+		fmt.Println("  no requests")
+		return
+	}
+
+	fmt.Printf("  requests:   %d (%.1f/s)\n", total, float64(total)/elapsed.Seconds())
+	fmt.Printf("  errors:     %d (%.2f%%)\n", errCount, 100*float64(errCount)/float64(total))
+
+	reasons := make([]string, 0, len(r.errors))
+	for reason := range r.errors {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Printf("    %-24s %d\n", reason, r.errors[reason])
+	}
+
+	if len(r.samples) == 0 {
+		return
+	}
+	sorted := make([]time.Duration, len(r.samples))
+	copy(sorted, r.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	fmt.Printf("  latency:    p50=%v p90=%v p99=%v max=%v\n",
+		percentile(sorted, 50),
+		percentile(sorted, 90),
+		percentile(sorted, 99),
+		sorted[len(sorted)-1])
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	idx := int(float64(len(sorted)-1) * p / 100)
+	return sorted[idx].Round(time.Microsecond)
+}
+
+// LoadTest drives the simulated clients against the target relay.
+type LoadTest struct {
+	config     *Config
+	httpClient *http.Client
+
+	sendStats     *Recorder
+	pollStats     *Recorder
+	deliveryStats *Recorder
+
+	sentMu sync.Mutex
+	sentAt map[string]time.Time
+}
+
+func NewLoadTest(config *Config) *LoadTest {
+	return &LoadTest{
+		config: config,
+		httpClient: &http.Client{
+			Timeout: config.PollTimeout + 10*time.Second,
+			Transport: &http.Transport{
+				MaxIdleConns:        config.Clients * 2,
+				MaxIdleConnsPerHost: config.Clients * 2,
+			},
+		},
+		sendStats:     NewRecorder(),
+		pollStats:     NewRecorder(),
+		deliveryStats: NewRecorder(),
+		sentAt:        make(map[string]time.Time),
+	}
+}
+
+// Login registers (first run) and logs in every simulated client, returning
+// one session token per client. Login traffic is not part of the measurement.
+func (lt *LoadTest) Login(ctx context.Context) ([]string, error) {
+	tokens := make([]string, lt.config.Clients)
+	for i := range tokens {
+		creds := credentialsRequest{
+			AccessKey: lt.config.AccessKey,
+			ClientID:  fmt.Sprintf("loadtest_%d_%d", time.Now().UnixNano(), i),
+			Username:  fmt.Sprintf("loadtest_%d", i),
+			Password:  lt.config.Password,
+		}
+
+		resp, err := lt.postJSON(ctx, "/api/register", creds)
+		if err != nil {
+			return nil, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusConflict {
+			return nil, fmt.Errorf("register %s: HTTP %d", creds.Username, resp.StatusCode)
+		}
+
+		resp, err = lt.postJSON(ctx, "/api/login", creds)
+		if err != nil {
+			return nil, err
+		}
+		var lr loginResponse
+		err = json.NewDecoder(resp.Body).Decode(&lr)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || err != nil || lr.Token == "" {
+			return nil, fmt.Errorf("login %s: HTTP %d", creds.Username, resp.StatusCode)
+		}
+		tokens[i] = lr.Token
+	}
+	return tokens, nil
+}
+
+func (lt *LoadTest) postJSON(ctx context.Context, path string, v interface{}) (*http.Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, lt.config.Target+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return lt.httpClient.Do(req)
+}
+
+func (lt *LoadTest) Run(ctx context.Context, tokens []string) time.Duration {
+	var wg sync.WaitGroup
+	start := time.Now()
+
+	for _, token := range tokens {
+		token := token
+		if lt.config.SendRate > 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				lt.sendLoop(ctx, token)
+			}()
+		}
+
+		if lt.config.Poll {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				lt.pollLoop(ctx, token)
+			}()
+		}
+	}
+
+	wg.Wait()
+	return time.Since(start)
+}
+
+func (lt *LoadTest) sendLoop(ctx context.Context, token string) {
+	interval := time.Duration(float64(time.Second) / lt.config.SendRate)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	seq := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			seq++
+			lt.send(ctx, token, fmt.Sprintf("load test message %d", seq))
+		}
+	}
+}
+
+func (lt *LoadTest) send(ctx context.Context, token, content string) {
+	body, err := json.Marshal(sendRequest{
+		Token:   token,
+		Content: content,
+		Color:   "[white]",
+	})
+	if err != nil {
+		lt.sendStats.Error("marshal")
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, lt.config.Target+"/api/send", bytes.NewReader(body))
+	if err != nil {
+		lt.sendStats.Error("request")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := lt.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			lt.sendStats.Error("transport")
+		}
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		lt.sendStats.Error(fmt.Sprintf("HTTP %d", resp.StatusCode))
+		return
+	}
+
+	var sr sendResponse
+	if err := json.NewDecoder(resp.Body).Decode(&sr); err != nil {
+		lt.sendStats.Error("decode")
+		return
+	}
+	lt.sendStats.Success(time.Since(start))
+
+	if sr.ID != "" && lt.config.Poll {
+		lt.sentMu.Lock()
+		lt.sentAt[sr.ID] = start
+		lt.sentMu.Unlock()
+	}
+}
+
+func (lt *LoadTest) pollLoop(ctx context.Context, token string) {
+	lastID := ""
+	for ctx.Err() == nil {
+		ids, err := lt.poll(ctx, token, lastID)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(500 * time.Millisecond):
+			}
+			continue
+		}
+		if len(ids) > 0 {
+			lastID = ids[len(ids)-1]
+		}
+	}
+}
+
+func (lt *LoadTest) poll(ctx context.Context, token, lastID string) ([]string, error) {
+	params := url.Values{}
+	if lastID != "" {
+		params.Set("last_id", lastID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, lt.config.Target+"/api/poll?"+params.Encode(), nil)
+	if err != nil {
+		lt.pollStats.Error("request")
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	start := time.Now()
+	resp, err := lt.httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			lt.pollStats.Error("transport")
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	received := time.Now()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		lt.pollStats.Success(received.Sub(start))
+		return nil, nil
+	case http.StatusOK:
+	default:
+		io.Copy(io.Discard, resp.Body)
+		lt.pollStats.Error(fmt.Sprintf("HTTP %d", resp.StatusCode))
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	var entries []map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		lt.pollStats.Error("decode")
+		return nil, err
+	}
+	lt.pollStats.Success(received.Sub(start))
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		var id string
+		if raw, ok := entry["id"]; ok {
+			json.Unmarshal(raw, &id)
+		}
+		if id == "" {
+			continue
+		}
+		ids = append(ids, id)
+
+		lt.sentMu.Lock()
+		sent, ok := lt.sentAt[id]
+		lt.sentMu.Unlock()
+		if ok {
+			lt.deliveryStats.Success(received.Sub(sent))
+		}
+	}
+	return ids, nil
+}
+
+func main() {
+	target := flag.String("target", "http://localhost:8034", "Base URL of the relay under test")
+	accessKey := flag.String("key", "secure_chat_key_2024", "Access key for clients")
+	password := flag.String("password", "loadtest-password", "Password for the loadtest_N accounts (registered on first run)")
+	clients := flag.Int("clients", 50, "Number of simulated clients")
+	sendRate := flag.Float64("send-rate", 0.5, "Messages per second sent by each client (0 disables sending)")
+	duration := flag.Duration("duration", 30*time.Second, "How long to run the test")
+	poll := flag.Bool("poll", true, "Run a long-poll loop for every client")
+	pollTimeout := flag.Duration("poll-timeout", 30*time.Second, "Server-side long-poll timeout, used to size the HTTP client timeout")
+	flag.Parse()
+
+	if *clients <= 0 {
+		log.Fatalf("-clients must be positive")
+	}
+
+	config := &Config{
+		Target:      *target,
+		AccessKey:   *accessKey,
+		Password:    *password,
+		Clients:     *clients,
+		SendRate:    *sendRate,
+		Duration:    *duration,
+		Poll:        *poll,
+		PollTimeout: *pollTimeout,
+	}
+
+	log.Printf("Load testing %s with %d clients (send %.2f msg/s each, poll=%v) for %v",
+		config.Target, config.Clients, config.SendRate, config.Poll, config.Duration)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, config.Duration)
+	defer cancel()
+
+	lt := NewLoadTest(config)
+	tokens, err := lt.Login(ctx)
+	if err != nil {
+		log.Fatalf("Error logging in simulated clients: %v", err)
+	}
+	elapsed := lt.Run(ctx, tokens)
+
+	fmt.Printf("\nResults after %v\n", elapsed.Round(time.Millisecond))
+	lt.sendStats.Report("send (POST /api/send)", elapsed)
+	lt.pollStats.Report("poll (GET /api/poll, includes long-poll wait)", elapsed)
+	lt.deliveryStats.Report("delivery (send start -> received by a poller)", elapsed)
+}
@@ -1,151 +1,952 @@
-package main
-
-import (
-	"flag"
-	"fmt"
-	"log"
-	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
-	"time"
-
-	"secure-chat-backend/internal/controllers"
-	"secure-chat-backend/internal/middleware"
-	"secure-chat-backend/internal/models"
-	"secure-chat-backend/internal/services"
-)
-
-type Server struct {
-	chatController  *controllers.SendController
-	pollController  *controllers.PollController
-	statsController *controllers.StatsController
-
-	loggingMiddleware  *middleware.LoggingMiddleware
-	recoveryMiddleware *middleware.RecoveryMiddleware
-	corsMiddleware     *middleware.CORSMiddleware
-
-	chatService *services.ChatService
-	authService *services.AuthService
-
-	httpServer *http.Server
-	config     *Config
-}
-
-type Config struct {
-	Port            string
-	AccessKey       string
-	MaxMessages     int
-	MessageTTL      time.Duration
-	CleanupInterval time.Duration
-}
-
-func NewServer(config *Config) *Server {
-	buffer := models.NewMessageBuffer(config.MaxMessages, config.MessageTTL)
-
-	chatService := services.NewChatService(buffer)
-	authService := services.NewAuthService(config.AccessKey)
-
-	authService.CleanupOldClients(24 * time.Hour)
-
-	chatController := controllers.NewSendController(chatService, authService)
-	pollController := controllers.NewPollController(chatService, authService)
-	statsController := controllers.NewStatsController(chatService, authService)
-
-	loggingMiddleware := middleware.NewLoggingMiddleware()
-	recoveryMiddleware := middleware.NewRecoveryMiddleware()
-	corsMiddleware := middleware.NewCORSMiddleware()
-
-	return &Server{
-		chatController:     chatController,
-		pollController:     pollController,
-		statsController:    statsController,
-		loggingMiddleware:  loggingMiddleware,
-		recoveryMiddleware: recoveryMiddleware,
-		corsMiddleware:     corsMiddleware,
-		chatService:        chatService,
-		authService:        authService,
-		config:             config,
-	}
-}
-
-func (s *Server) registerRoutes() {
-	wrap := func(handler http.HandlerFunc) http.HandlerFunc {
-		return s.recoveryMiddleware.Wrap(
-			s.loggingMiddleware.Wrap(
-				s.corsMiddleware.Wrap(handler),
-			),
-		)
-	}
-
-	http.HandleFunc("/api/send", wrap(s.chatController.Handle))
-	http.HandleFunc("/api/poll", wrap(s.pollController.Handle))
-	http.HandleFunc("/api/stats", wrap(s.statsController.Handle))
-
-	http.HandleFunc("/health", wrap(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	}))
-}
-
-func (s *Server) Start() error {
-	s.registerRoutes()
-
-	s.httpServer = &http.Server{
-		Addr:         ":" + s.config.Port,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 60 * time.Second,
-		IdleTimeout:  120 * time.Second,
-	}
-
-	log.Printf("Server started on port %s", s.config.Port)
-	log.Printf("Access Key: %s", s.config.AccessKey)
-	log.Printf("Max Messages: %d, Message TTL: %v", s.config.MaxMessages, s.config.MessageTTL)
-
-	return s.httpServer.ListenAndServe()
-}
-
-func (s *Server) Shutdown() error {
-	log.Println("Initializing server shutdown...")
-	if s.httpServer != nil {
-		return s.httpServer.Close()
-	}
-	return nil
-}
-
-func main() {
-	port := flag.String("port", "8034", "Port to run the server on")
-	accessKey := flag.String("key", "secure_chat_key_2024", "Access key for clients")
-	maxMessages := flag.Int("max-msgs", 1000, "Maximum number of messages to store")
-	msgTTL := flag.Duration("ttl", 1*time.Minute, "Time to live for messages")
-	flag.Parse()
-
-	config := &Config{
-		Port:            *port,
-		AccessKey:       *accessKey,
-		MaxMessages:     *maxMessages,
-		MessageTTL:      *msgTTL,
-		CleanupInterval: 10 * time.Second,
-	}
-
-	server := NewServer(config)
-
-	go func() {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-		<-sigChan
-
-		fmt.Println()
-		log.Println("Received shutdown signal, exiting...")
-
-		if err := server.Shutdown(); err != nil {
-			log.Printf("Error shutting down server: %v", err)
-		}
-
-		os.Exit(0)
-	}()
-
-	if err := server.Start(); err != nil && err != http.ErrServerClosed {
-		log.Fatalf("Error starting server: %v", err)
-	}
-}
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+
+	fileconfig "secure-chat-backend/config"
+	"secure-chat-backend/internal/controllers"
+	"secure-chat-backend/internal/irc"
+	"secure-chat-backend/internal/logging"
+	"secure-chat-backend/internal/metrics"
+	"secure-chat-backend/internal/middleware"
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/services"
+	"secure-chat-backend/internal/storage"
+	"secure-chat-backend/internal/tracing"
+	"secure-chat-backend/internal/utils"
+)
+
+type Server struct {
+	chatController     *controllers.SendController
+	pollController     *controllers.PollController
+	streamController   *controllers.StreamController
+	statsController    *controllers.StatsController
+	loginController    *controllers.LoginController
+	registerController *controllers.RegisterController
+	deviceController   *controllers.DeviceController
+	historyController  *controllers.HistoryController
+	presenceController *controllers.PresenceController
+	whoisController    *controllers.WhoisController
+	nickController     *controllers.NickController
+	roomController     *controllers.RoomController
+	typingController   *controllers.TypingController
+	reactionController *controllers.ReactionController
+	pinController      *controllers.PinController
+	draftController    *controllers.DraftController
+	keysController     *controllers.KeysController
+	uploadController   *controllers.UploadController
+	pasteController    *controllers.PasteController
+	webhookController  *controllers.WebhookController
+	metricsController  *controllers.MetricsController
+	motdController     *controllers.MotdController
+	fileController     *controllers.FileController
+	adminConfig        *controllers.AdminConfigController
+	admin              *controllers.AdminController
+	exportController   *controllers.ExportController
+
+	loggingMiddleware  *middleware.LoggingMiddleware
+	recoveryMiddleware *middleware.RecoveryMiddleware
+	corsMiddleware     *middleware.CORSMiddleware
+	ipLimitMiddleware  *middleware.IPLimitMiddleware
+	signingMiddleware  *middleware.SigningMiddleware
+	gzipMiddleware     *middleware.GzipMiddleware
+	bodyLogMiddleware  *middleware.BodyLogMiddleware // nil unless Sandbox
+
+	chatService *services.ChatService
+	authService *services.AuthService
+	userService *services.UserService
+
+	// What Reload changes, besides motdController.
+	buffer    *models.MessageBuffer
+	tunables  *services.Tunables
+	filter    *services.WordFilter
+	spamGuard *services.SpamGuard
+
+	audit      *slog.Logger
+	auditClose io.Closer
+	httpServer *http.Server
+	irc        *irc.Gateway      // nil unless IRCPort is set
+	tracer     *tracing.Exporter // nil unless OTLPEndpoint is set
+	config     *Config
+	logger     *slog.Logger
+}
+
+type Config struct {
+	Port            string
+	AccessKey       string
+	DataDir         string
+	TokenSecret     string
+	SessionTTL      time.Duration
+	MaxMessages     int
+	MessageTTL      time.Duration
+	CleanupInterval time.Duration
+	// RoomRetention gives rooms a TTL and message cap of their own in
+	// place of MessageTTL and MaxMessages.
+	RoomRetention map[string]models.Retention
+	// TLSCert and TLSKey are PEM files; with both set the server speaks
+	// HTTPS only.
+	TLSCert string
+	TLSKey  string
+	// Scanner is run on every upload: an http(s) URL or a command line
+	// (see services.NewScanner). Empty = uploads are not scanned.
+	Scanner     string
+	ScanTimeout time.Duration
+	MaxUpload   int
+	// UploadQuota is how many bytes one user may have stored; uploads
+	// expire after UploadTTL, independently of MessageTTL.
+	UploadQuota int64
+	UploadTTL   time.Duration
+	// MaxPaste is the longest /api/paste text in bytes; pastes expire
+	// after PasteTTL.
+	MaxPaste int
+	PasteTTL time.Duration
+	// AuditLog is a file for security events (JSON lines); empty = the
+	// main log.
+	AuditLog string
+	// Motd is shown to clients after login; KDFSalt is the salt they
+	// derive the room key from their passphrase with (empty = built-in).
+	Motd    string
+	KDFSalt string
+	// MinClientVersion turns away older TTC clients at login (empty =
+	// every version is served).
+	MinClientVersion string
+	// Tunables are the starting values of what PATCH /api/admin/config
+	// can change; AdminToken guards the admin API (empty = disabled).
+	Tunables    services.TunableValues
+	FilterWords []string
+	AdminToken  string
+	// Spam is the repeat and flood policy every message is checked
+	// against (see services.SpamGuard).
+	Spam services.SpamPolicy
+	// IPRateLimit and IPRateBurst limit the requests from one address, of
+	// any client ID (0 = no limit); MaxPollsPerIP caps its open polls and
+	// streams (0 = no cap). X-Forwarded-For is only believed from TrustedProxies.
+	IPRateLimit    float64
+	IPRateBurst    int
+	MaxPollsPerIP  int
+	TrustedProxies []string
+	// RequireSigned refuses requests that aren't signed (see
+	// middleware.SigningMiddleware); off, signed and unsigned both pass.
+	RequireSigned bool
+	// Auth is the authentication backend (services.AuthKey, AuthLocal or
+	// AuthOIDC); OIDC configures the last.
+	Auth string
+	OIDC services.OIDCConfig
+	// Sandbox is for client development: no authentication, no rate
+	// limits, every body logged and SandboxScript replayed one message
+	// every SandboxInterval. See applySandbox.
+	Sandbox         bool
+	SandboxScript   []services.SandboxLine
+	SandboxInterval time.Duration
+	// Import is an archive (see services.ImportArchive) read into the
+	// store and message buffer before the relay starts; empty = none.
+	Import string
+	// IRCPort is where IRC clients connect (see irc.Gateway), with TLS
+	// when TLSCert is set; empty = no IRC.
+	IRCPort string
+	// OTLPEndpoint is an OpenTelemetry collector (OTLP/HTTP) that gets a
+	// span of every send and poll; empty = no tracing.
+	OTLPEndpoint string
+}
+
+func NewServer(config *Config, logger *slog.Logger) (*Server, error) {
+	buffer := models.NewMessageBuffer(config.MaxMessages, config.MessageTTL)
+	buffer.SetRetention(config.RoomRetention)
+
+	store, err := storage.NewStore(config.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	if config.Import != "" {
+		f, err := os.Open(config.Import)
+		if err != nil {
+			return nil, err
+		}
+		counts, err := services.ImportArchive(f, store, buffer)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("import %s: %w", config.Import, err)
+		}
+		logger.Info("archive imported", "path", config.Import, "accounts", counts.Accounts, "bans", counts.Bans, "drafts", counts.Drafts, "messages", counts.Messages)
+	}
+
+	tunables, err := services.NewTunables(config.Tunables)
+	if err != nil {
+		return nil, err
+	}
+	filter := services.NewWordFilter(config.FilterWords)
+	chatService := services.NewChatService(buffer, tunables, filter)
+	authService := services.NewAuthService(config.AccessKey, []byte(config.TokenSecret), config.SessionTTL)
+	authService.SetRateLimit(config.Tunables.RateLimit, config.Tunables.RateBurst)
+	userService, err := services.NewUserService(store)
+	if err != nil {
+		return nil, err
+	}
+	bans, err := services.NewBanList(store)
+	if err != nil {
+		return nil, err
+	}
+	authService.SetBanList(bans)
+	chatService.SetUsers(userService)
+	drafts, err := services.NewDraftStore(store)
+	if err != nil {
+		return nil, err
+	}
+
+	authService.CleanupOldClients(24 * time.Hour)
+
+	var authenticator services.Authenticator
+	var oidc *services.OIDCAuth
+	if config.Auth == services.AuthOIDC {
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		oidc, err = services.NewOIDCAuth(ctx, config.OIDC, authService, userService)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		authenticator = oidc
+	} else if authenticator, err = services.NewAuthenticator(config.Auth, authService, userService); err != nil {
+		return nil, err
+	}
+
+	var bodyLogMiddleware *middleware.BodyLogMiddleware
+	if config.Sandbox {
+		authService.SetSandbox(true)
+		userService.SetSandbox(true)
+		bodyLogMiddleware = middleware.NewBodyLogMiddleware(8 << 10)
+		services.ReplaySandboxScript(chatService, userService, config.SandboxScript, config.SandboxInterval, logger)
+	}
+
+	auditLog, auditClose, err := logging.OpenAudit(config.AuditLog, logger)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	spamGuard := services.NewSpamGuard(config.Spam, auditLog)
+	chatService.SetSpamGuard(spamGuard)
+	subscriptions, err := services.NewSubscriptions(store, logger)
+	if err != nil {
+		return nil, err
+	}
+	chatService.SetSubscriptions(subscriptions)
+	scanner, err := services.NewScanner(config.Scanner, config.ScanTimeout)
+	if err != nil {
+		return nil, err
+	}
+	uploadService := services.NewUploadService(scanner, services.UploadPolicy{
+		MaxFileSize: config.MaxUpload,
+		UserQuota:   config.UploadQuota,
+		TTL:         config.UploadTTL,
+	}, auditLog)
+
+	traffic := metrics.NewTraffic()
+
+	chatController := controllers.NewSendController(chatService, authService, userService, traffic)
+	pollController := controllers.NewPollController(chatService, authService, tunables)
+	streamController := controllers.NewStreamController(chatService, authService, tunables)
+	statsController := controllers.NewStatsController(chatService, authService, traffic, config.AdminToken, time.Now())
+	loginController := controllers.NewLoginController(authenticator, authService, userService, services.NewLoginGuard(), config.MinClientVersion)
+	registerController := controllers.NewRegisterController(authenticator, authService, userService, config.MinClientVersion)
+	deviceController := controllers.NewDeviceController(oidc, authService, userService, config.MinClientVersion)
+	historyController := controllers.NewHistoryController(chatService, authService)
+	presenceController := controllers.NewPresenceController(authService)
+	whoisController := controllers.NewWhoisController(authService, userService)
+	nickController := controllers.NewNickController(chatService, authService, userService)
+	if oidc != nil {
+		nickController.SetIssuer(oidc.Issuer())
+	}
+	roomController := controllers.NewRoomController(services.NewRoomService(buffer), authService)
+	typingController := controllers.NewTypingController(chatService, authService)
+	reactionController := controllers.NewReactionController(chatService, authService)
+	pinController := controllers.NewPinController(chatService, authService)
+	draftController := controllers.NewDraftController(drafts, authService)
+	keysController := controllers.NewKeysController(authService, userService)
+	uploadController := controllers.NewUploadController(uploadService, authService)
+	fileController := controllers.NewFileController(uploadService, authService)
+	pasteController := controllers.NewPasteController(services.NewPasteStore(services.PastePolicy{
+		MaxSize: config.MaxPaste,
+		TTL:     config.PasteTTL,
+	}), authService)
+	webhookController := controllers.NewWebhookController(chatService, authService, traffic)
+	metricsController := controllers.NewMetricsController(traffic)
+	motdController := controllers.NewMotdController(config.Motd, config.KDFSalt, config.MinClientVersion, authenticator.Method(), tunables, chatService)
+	adminConfig := controllers.NewAdminConfigController(tunables, authService, config.AdminToken, auditLog)
+	admin := controllers.NewAdminController(chatService, authService, userService, bans, subscriptions, config.AdminToken, auditLog)
+	exportController := controllers.NewExportController(store, buffer, config.AdminToken, auditLog)
+
+	loggingMiddleware := middleware.NewLoggingMiddleware(logger)
+	recoveryMiddleware := middleware.NewRecoveryMiddleware(logger)
+	corsMiddleware := middleware.NewCORSMiddleware()
+	ipLimitMiddleware, err := middleware.NewIPLimitMiddleware(config.IPRateLimit, config.IPRateBurst, config.MaxPollsPerIP, config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	var ircGateway *irc.Gateway
+	if config.IRCPort != "" {
+		ircGateway = irc.NewGateway(chatService, authService, authenticator, config.AccessKey, ipLimitMiddleware, logger)
+	}
+
+	tracer, err := tracing.New(config.OTLPEndpoint, "ttc-relay", logger)
+	if err != nil {
+		return nil, err
+	}
+
+	signingMiddleware := middleware.NewSigningMiddleware(authService, config.RequireSigned, int64(max(config.MaxUpload, config.MaxPaste))+64<<10)
+
+	return &Server{
+		chatController:     chatController,
+		pollController:     pollController,
+		streamController:   streamController,
+		statsController:    statsController,
+		loginController:    loginController,
+		registerController: registerController,
+		deviceController:   deviceController,
+		historyController:  historyController,
+		presenceController: presenceController,
+		whoisController:    whoisController,
+		nickController:     nickController,
+		roomController:     roomController,
+		typingController:   typingController,
+		reactionController: reactionController,
+		pinController:      pinController,
+		draftController:    draftController,
+		keysController:     keysController,
+		uploadController:   uploadController,
+		pasteController:    pasteController,
+		webhookController:  webhookController,
+		metricsController:  metricsController,
+		motdController:     motdController,
+		fileController:     fileController,
+		adminConfig:        adminConfig,
+		admin:              admin,
+		exportController:   exportController,
+		loggingMiddleware:  loggingMiddleware,
+		recoveryMiddleware: recoveryMiddleware,
+		corsMiddleware:     corsMiddleware,
+		ipLimitMiddleware:  ipLimitMiddleware,
+		signingMiddleware:  signingMiddleware,
+		gzipMiddleware:     middleware.NewGzipMiddleware(),
+		bodyLogMiddleware:  bodyLogMiddleware,
+		chatService:        chatService,
+		authService:        authService,
+		userService:        userService,
+		buffer:             buffer,
+		tunables:           tunables,
+		filter:             filter,
+		spamGuard:          spamGuard,
+		audit:              auditLog,
+		auditClose:         auditClose,
+		irc:                ircGateway,
+		tracer:             tracer,
+		config:             config,
+		logger:             logger,
+	}, nil
+}
+
+func (s *Server) registerRoutes() {
+	chain := func(handler http.HandlerFunc) http.HandlerFunc {
+		if s.bodyLogMiddleware != nil {
+			handler = s.bodyLogMiddleware.Wrap(handler)
+		}
+		return s.recoveryMiddleware.Wrap(
+			s.loggingMiddleware.Wrap(
+				s.corsMiddleware.Wrap(
+					s.gzipMiddleware.Wrap(handler),
+				),
+			),
+		)
+	}
+	wrap := func(handler http.HandlerFunc) http.HandlerFunc {
+		return chain(s.ipLimitMiddleware.Wrap(handler))
+	}
+	wrapPoll := func(handler http.HandlerFunc) http.HandlerFunc {
+		return chain(s.ipLimitMiddleware.WrapPoll(handler))
+	}
+	// Requests made with a session token, and register and login, are
+	// checked for a signature; the admin API has a token of its own.
+	signed := s.signingMiddleware.Wrap
+	signedAccess := s.signingMiddleware.WrapAccess
+	// Sends and polls are traced, with -otlp-endpoint.
+	traced := s.tracer.Wrap
+
+	http.HandleFunc("/api/send", wrap(traced("send", signed(s.chatController.Handle))))
+	http.HandleFunc("/api/send/batch", wrap(traced("send.batch", signed(s.chatController.HandleBatch))))
+	http.HandleFunc("/api/poll", wrapPoll(traced("poll", signed(s.pollController.Handle))))
+	http.HandleFunc("/api/stream", wrapPoll(traced("stream", signed(s.streamController.Handle))))
+	http.HandleFunc("/api/ws", wrapPoll(traced("ws", signed(s.streamController.HandleWebSocket))))
+	// Scrapers can't sign; the access key or admin token in the header is
+	// the check.
+	http.HandleFunc("/api/stats", wrap(s.statsController.Handle))
+	http.HandleFunc("/api/login", wrap(signedAccess(s.loginController.Handle)))
+	http.HandleFunc("/api/register", wrap(signedAccess(s.registerController.Handle)))
+	http.HandleFunc("/api/auth/device", wrap(signedAccess(s.deviceController.HandleStart)))
+	http.HandleFunc("/api/auth/device/token", wrap(signedAccess(s.deviceController.HandleToken)))
+	http.HandleFunc("/api/history", wrap(signed(s.historyController.Handle)))
+	http.HandleFunc("/api/presence", wrap(signed(s.presenceController.Handle)))
+	http.HandleFunc("/api/whois", wrap(signed(s.whoisController.Handle)))
+	http.HandleFunc("/api/nick", wrap(signed(s.nickController.Handle)))
+	http.HandleFunc("/api/rooms/", wrap(signed(s.roomController.Handle)))
+	http.HandleFunc("/api/typing", wrap(signed(s.typingController.Handle)))
+	http.HandleFunc("/api/messages/", wrap(signed(func(w http.ResponseWriter, r *http.Request) {
+		// /api/messages/<id>/pin, else /api/messages/<id>/react.
+		if strings.HasSuffix(r.URL.Path, "/pin") {
+			s.pinController.Handle(w, r)
+			return
+		}
+		s.reactionController.Handle(w, r)
+	})))
+	http.HandleFunc("/api/pins", wrap(signed(s.pinController.HandleList)))
+	http.HandleFunc("/api/drafts", wrap(signed(s.draftController.Handle)))
+	http.HandleFunc("/api/keys", wrap(signed(s.keysController.Handle)))
+	http.HandleFunc("/api/upload", wrap(signed(s.uploadController.Handle)))
+	http.HandleFunc("/api/files", wrap(signed(s.fileController.Handle)))
+	http.HandleFunc("/api/files/", wrap(signed(s.fileController.Handle)))
+	http.HandleFunc("/api/paste", wrap(signed(s.pasteController.Handle)))
+	http.HandleFunc("/api/motd", wrap(s.motdController.Handle))
+	// A webhook's token is its URL; what posts to it can't sign.
+	http.HandleFunc(controllers.WebhookPath, wrap(s.webhookController.Handle))
+
+	// Protocol version 2: the endpoints that carry messages, in the
+	// explicit format (models.WireMessage). The rest of the API is the
+	// same in both versions.
+	http.HandleFunc("/api/v2/send", wrap(traced("send", signed(s.chatController.Handle))))
+	http.HandleFunc("/api/v2/send/batch", wrap(traced("send.batch", signed(s.chatController.HandleBatch))))
+	http.HandleFunc("/api/v2/poll", wrapPoll(traced("poll", signed(s.pollController.Handle))))
+	http.HandleFunc("/api/v2/stream", wrapPoll(traced("stream", signed(s.streamController.Handle))))
+	http.HandleFunc("/api/v2/ws", wrapPoll(traced("ws", signed(s.streamController.HandleWebSocket))))
+	http.HandleFunc("/api/v2/history", wrap(signed(s.historyController.Handle)))
+
+	http.HandleFunc("/metrics", wrap(s.metricsController.Handle))
+	http.HandleFunc("/api/admin/config", wrap(s.adminConfig.Handle))
+	http.HandleFunc("/api/admin/bans", wrap(s.admin.HandleBans))
+	http.HandleFunc("/api/admin/kick", wrap(s.admin.HandleKick))
+	http.HandleFunc("/api/admin/accounts", wrap(s.admin.HandleAccounts))
+	http.HandleFunc("/api/admin/unmute", wrap(s.admin.HandleUnmute))
+	http.HandleFunc("/api/admin/purge", wrap(s.admin.HandlePurge))
+	http.HandleFunc("/api/admin/announce", wrap(s.admin.HandleAnnounce))
+	http.HandleFunc("/api/admin/pins", wrap(s.admin.HandlePins))
+	http.HandleFunc("/api/admin/observers", wrap(s.admin.HandleObservers))
+	http.HandleFunc("/api/admin/webhooks", wrap(s.admin.HandleWebhooks))
+	http.HandleFunc("/api/admin/subscriptions", wrap(s.admin.HandleSubscriptions))
+	http.HandleFunc("/api/admin/export", wrap(s.exportController.Handle))
+
+	http.HandleFunc("/health", wrap(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}))
+}
+
+func (s *Server) Start() error {
+	s.registerRoutes()
+
+	s.httpServer = &http.Server{
+		Addr:         ":" + s.config.Port,
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 60 * time.Second,
+		IdleTimeout:  120 * time.Second,
+		ErrorLog:     slog.NewLogLogger(s.logger.Handler(), slog.LevelWarn),
+	}
+
+	s.logger.Info("server started", "port", s.config.Port)
+	if s.config.Sandbox {
+		s.logger.Warn("SANDBOX: no authentication, no rate limits, request and response bodies are logged — for client development only, never expose this relay",
+			"script_messages", len(s.config.SandboxScript),
+			"interval", s.config.SandboxInterval)
+	}
+	switch s.config.Auth {
+	case services.AuthOIDC:
+		s.logger.Info("authentication: device code sign-in", "issuer", s.config.OIDC.Issuer, "client_id", s.config.OIDC.ClientID, "username_claim", s.config.OIDC.UsernameClaim)
+	case services.AuthLocal:
+		s.logger.Info("authentication: local accounts — create them with POST /api/admin/accounts", "accounts", s.userService.Count())
+	default:
+		s.logger.Info("access key", "key", s.config.AccessKey)
+	}
+	if s.config.DataDir != "" {
+		s.logger.Info("data dir", "path", s.config.DataDir, "accounts", s.userService.Count())
+	} else {
+		s.logger.Info("data dir: none — accounts are kept in memory only")
+	}
+	s.logger.Info("message buffer", "max_messages", s.config.MaxMessages, "ttl", s.config.MessageTTL)
+	for room, r := range s.config.RoomRetention {
+		s.logger.Info("room retention", "room", room, "ttl", r.TTL, "max_messages", r.MaxMessages)
+	}
+	if s.config.Scanner != "" {
+		s.logger.Info("uploads", "max_bytes", s.config.MaxUpload, "quota_bytes", s.config.UploadQuota, "ttl", s.config.UploadTTL, "scanner", s.config.Scanner)
+	} else {
+		s.logger.Warn("uploads are not scanned — set -scanner to check them", "max_bytes", s.config.MaxUpload, "quota_bytes", s.config.UploadQuota, "ttl", s.config.UploadTTL)
+	}
+	s.logger.Info("pastes", "max_bytes", s.config.MaxPaste, "ttl", s.config.PasteTTL)
+	if s.config.AuditLog != "" {
+		s.logger.Info("audit log", "path", s.config.AuditLog)
+	}
+	s.logger.Info("tunables", "values", s.config.Tunables)
+	if s.config.AdminToken == "" {
+		s.logger.Info("admin API disabled — set -admin-token to enable it")
+	}
+	if s.config.RequireSigned {
+		s.logger.Info("request signing required — unsigned requests are refused")
+	}
+	if s.tracer != nil {
+		s.logger.Info("tracing sends and polls", "otlp_endpoint", s.tracer.Endpoint())
+	}
+	if p := s.config.Spam; p.RepeatLimit > 0 || p.FloodScore > 0 {
+		s.logger.Info("spam guard", "repeats", p.RepeatLimit, "window", p.RepeatWindow, "flood_score", p.FloodScore, "mute", p.MuteFor, "mute_max", p.MaxMute)
+	}
+	s.logger.Info("per-address limits", "rate", s.config.IPRateLimit, "burst", s.config.IPRateBurst, "max_polls", s.config.MaxPollsPerIP, "trusted_proxies", s.config.TrustedProxies)
+
+	if s.config.TLSCert == "" {
+		if err := s.startIRC(nil); err != nil {
+			return err
+		}
+		return s.httpServer.ListenAndServe()
+	}
+
+	cert, err := tls.LoadX509KeyPair(s.config.TLSCert, s.config.TLSKey)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %w", err)
+	}
+	pin, err := spkiPin(cert)
+	if err != nil {
+		return fmt.Errorf("TLS certificate: %w", err)
+	}
+	s.httpServer.TLSConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	// Clients pin this hash with "pin_sha256" in their config.json; it
+	// stays the same across renewals that keep the key.
+	s.logger.Info("https enabled", "cert", s.config.TLSCert, "pin_sha256", pin)
+
+	if err := s.startIRC(s.httpServer.TLSConfig); err != nil {
+		return err
+	}
+	return s.httpServer.ListenAndServeTLS("", "")
+}
+
+// startIRC opens the IRC port, if there is one, and serves it in the
+// background; with tlsConfig over TLS.
+func (s *Server) startIRC(tlsConfig *tls.Config) error {
+	if s.irc == nil {
+		return nil
+	}
+	ln, err := net.Listen("tcp", ":"+s.config.IRCPort)
+	if err != nil {
+		return fmt.Errorf("irc: %w", err)
+	}
+	if tlsConfig != nil {
+		ln = tls.NewListener(ln, tlsConfig)
+	}
+	s.logger.Info("irc gateway started", "port", s.config.IRCPort, "tls", tlsConfig != nil)
+	go func() {
+		if err := s.irc.Serve(ln); err != nil {
+			s.logger.Error("irc gateway stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// spkiPin returns the client-side pin for cert: sha256/ and the Base64
+// SHA-256 of the leaf's public key.
+func spkiPin(cert tls.Certificate) (string, error) {
+	if len(cert.Certificate) == 0 {
+		return "", errors.New("no certificate in chain")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// reloadable are the Config fields Reload applies to a running relay.
+var reloadable = map[string]bool{"Tunables": true, "FilterWords": true, "Spam": true, "Motd": true, "RoomRetention": true, "Import": true}
+
+// Reload applies the settings of next that can change while the relay runs
+// — the tunables, the word filter, the spam guard, the message of the day
+// and room retention — and warns about any other change, which takes a
+// restart. Open polls and streams are kept. Tunables changed through PATCH
+// /api/admin/config are set back to next's.
+func (s *Server) Reload(next *Config) {
+	t := next.Tunables
+	if t.RoomAccents == nil {
+		t.RoomAccents = map[string]string{}
+	}
+	before, after, err := s.tunables.Apply(services.TunablesPatch{
+		RateLimit:   &t.RateLimit,
+		RateBurst:   &t.RateBurst,
+		SlowMode:    &t.SlowMode,
+		PollTimeout: &t.PollTimeout,
+		Filter:      &t.Filter,
+		ReadOnly:    &t.ReadOnly,
+		RoomAccents: t.RoomAccents,
+	})
+	if err != nil {
+		s.logger.Error("config not reloaded", "error", err)
+		return
+	}
+	if after.RateLimit != before.RateLimit || after.RateBurst != before.RateBurst {
+		s.authService.SetRateLimit(after.RateLimit, after.RateBurst)
+	}
+	s.filter.Set(next.FilterWords)
+	s.spamGuard.SetPolicy(next.Spam)
+	s.motdController.SetMotd(next.Motd)
+	s.buffer.SetRetention(next.RoomRetention)
+
+	var restart []string
+	old, cur := reflect.ValueOf(s.config).Elem(), reflect.ValueOf(next).Elem()
+	for i := 0; i < old.NumField(); i++ {
+		name := old.Type().Field(i).Name
+		if !reloadable[name] && !reflect.DeepEqual(old.Field(i).Interface(), cur.Field(i).Interface()) {
+			restart = append(restart, name)
+		}
+	}
+	s.config.Tunables, s.config.FilterWords, s.config.Spam, s.config.Motd = next.Tunables, next.FilterWords, next.Spam, next.Motd
+	s.config.RoomRetention = next.RoomRetention
+
+	s.audit.Info("config reloaded", "before", before, "after", after, "filter_words", s.filter.Len(), "spam", next.Spam)
+	if len(restart) > 0 {
+		s.logger.Warn("config reloaded; these changes take a restart", "settings", restart)
+	} else {
+		s.logger.Info("config reloaded")
+	}
+}
+
+func (s *Server) Shutdown() error {
+	s.logger.Info("initializing server shutdown")
+	defer s.auditClose.Close()
+	if s.irc != nil {
+		s.irc.Close()
+	}
+	defer s.tracer.Close()
+	if s.httpServer != nil {
+		return s.httpServer.Close()
+	}
+	return nil
+}
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		os.Exit(runExport(os.Args[2:]))
+	}
+
+	configPath := flag.String("config", os.Getenv("CONFIG"), "YAML file of settings keyed by flag name; flags given here win over it, and SIGHUP reads it again")
+	port := flag.String("port", "8034", "Port to run the server on")
+	accessKey := flag.String("key", "secure_chat_key_2024", "Access key for clients")
+	auth := flag.String("auth", envOr("AUTH", services.AuthKey), "How users log in: key (the access key and a password, open registration), local (a password, accounts made by the admin) or oidc (device code sign-in at -oidc-issuer)")
+	oidcIssuer := flag.String("oidc-issuer", os.Getenv("OIDC_ISSUER"), "OpenID Connect issuer URL for -auth oidc")
+	oidcClientID := flag.String("oidc-client-id", os.Getenv("OIDC_CLIENT_ID"), "The relay's client ID at -oidc-issuer")
+	oidcClientSecret := flag.String("oidc-client-secret", os.Getenv("OIDC_CLIENT_SECRET"), "The relay's client secret at -oidc-issuer (empty for a public client)")
+	oidcScopes := flag.String("oidc-scopes", "openid profile", "Space-separated scopes asked for at sign-in")
+	oidcUsernameClaim := flag.String("oidc-username-claim", "preferred_username", "ID token claim that becomes the username")
+	tokenSecret := flag.String("token-secret", os.Getenv("TOKEN_SECRET"), "HMAC secret for session tokens (empty = random, tokens reset on restart)")
+	sessionTTL := flag.Duration("session-ttl", 24*time.Hour, "Lifetime of session tokens issued at login")
+	dataDir := flag.String("data-dir", os.Getenv("DATA_DIR"), "Directory for persisted state such as accounts (empty = memory only)")
+	maxMessages := flag.Int("max-msgs", 1000, "Maximum number of messages to store")
+	msgTTL := flag.Duration("ttl", 1*time.Minute, "Time to live for messages")
+	roomRetention := flag.String("room-retention", os.Getenv("ROOM_RETENTION"), "Comma-separated room=ttl or room=ttl/max pairs, e.g. general=24h,ops=5m/100: rooms that keep messages longer, shorter or fewer than -ttl and -max-msgs")
+	logFormat := flag.String("log-format", envOr("LOG_FORMAT", "text"), "Log format: text or json")
+	logLevel := flag.String("log-level", envOr("LOG_LEVEL", "info"), "Log level: debug, info, warn or error")
+	tlsCert := flag.String("tls-cert", os.Getenv("TLS_CERT"), "PEM certificate (chain) for HTTPS (empty = plain HTTP)")
+	tlsKey := flag.String("tls-key", os.Getenv("TLS_KEY"), "PEM private key for -tls-cert")
+	scanner := flag.String("scanner", os.Getenv("SCANNER"), "Virus scanner run on uploads: an http(s) URL or a command such as \"clamscan --no-summary\" (empty = no scanning)")
+	scanTimeout := flag.Duration("scan-timeout", 30*time.Second, "Time limit for scanning one upload")
+	maxUpload := flag.Int("max-upload", 10<<20, "Largest accepted upload in bytes")
+	uploadQuota := flag.Int64("upload-quota", 50<<20, "Bytes of uploads one user may have stored at once")
+	uploadTTL := flag.Duration("upload-ttl", 24*time.Hour, "How long uploads stay downloadable (independent of -ttl)")
+	maxPaste := flag.Int("max-paste", 512<<10, "Longest accepted paste in bytes")
+	pasteTTL := flag.Duration("paste-ttl", 6*time.Hour, "How long pastes can be viewed (independent of -ttl)")
+	motd := flag.String("motd", os.Getenv("MOTD"), "Message of the day shown to clients after login")
+	minClientVersion := flag.String("min-client-version", os.Getenv("MIN_CLIENT_VERSION"), "Oldest client version allowed to log in, e.g. v1.1.0 (empty = any)")
+	kdfSalt := flag.String("kdf-salt", os.Getenv("KDF_SALT"), "Salt clients derive the room key from their passphrase with (empty = their built-in salt)")
+	auditLog := flag.String("audit-log", os.Getenv("AUDIT_LOG"), "File for security events such as scan verdicts (empty = main log)")
+	adminToken := flag.String("admin-token", os.Getenv("ADMIN_TOKEN"), "Bearer token for /api/admin/* (empty = admin API disabled)")
+	rateLimit := flag.Float64("rate-limit", 10, "Messages per second each client may send")
+	rateBurst := flag.Int("rate-burst", 20, "Messages a client may send at once before -rate-limit applies")
+	slowMode := flag.Duration("slow-mode", 0, "Least time between two messages from one user (0 = off)")
+	pollTimeout := flag.Duration("poll-timeout", 30*time.Second, "How long a poll waits for new messages")
+	spamRepeats := flag.Int("spam-repeats", 3, "Times one user may send the same text within -spam-window; the next is refused (0 = off)")
+	spamWindow := flag.Duration("spam-window", time.Minute, "Window -spam-repeats counts repeats in")
+	floodScore := flag.Float64("flood-score", 30, "Flood score that mutes a user: 1 per message, +2 for a repeat, +3 for a filtered word, -1 per second (0 = off)")
+	muteFor := flag.Duration("mute", time.Minute, "First mute for flooding; each further one within a day doubles")
+	maxMute := flag.Duration("mute-max", time.Hour, "Longest mute for flooding")
+	filterWords := flag.String("filter-words", os.Getenv("FILTER_WORDS"), "Comma-separated words that get a message refused")
+	readOnly := flag.Bool("read-only", false, "Start with sending disabled")
+	roomAccents := flag.String("room-accent", os.Getenv("ROOM_ACCENTS"), "Comma-separated room=color pairs, e.g. lobby=cyan,ops=red: the color clients draw each room's header in")
+	ipRateLimit := flag.Float64("ip-rate-limit", 20, "Requests per second one address may make, whatever client IDs it uses (0 = no limit)")
+	ipRateBurst := flag.Int("ip-rate-burst", 60, "Requests one address may make at once before -ip-rate-limit applies")
+	maxPollsPerIP := flag.Int("max-polls-per-ip", 16, "Polls and streams one address may hold open at once (0 = no cap)")
+	requireSigned := flag.Bool("require-signed", false, "Refuse requests that aren't signed with the session's signing key (older clients can't connect)")
+	sandbox := flag.Bool("sandbox", false, "Client development relay: no authentication or rate limits, accounts in memory, every request and response body logged, and a script of messages replayed")
+	sandboxScript := flag.String("sandbox-script", "", "JSON lines of {\"user\",\"room\",\"text\",\"type\",\"color\"} for -sandbox to replay (empty = a built-in script)")
+	sandboxInterval := flag.Duration("sandbox-interval", 5*time.Second, "Time between two -sandbox script messages")
+	trustedProxy := flag.String("trusted-proxy", os.Getenv("TRUSTED_PROXY"), "Comma-separated IPs or CIDRs of reverse proxies whose X-Forwarded-For is believed (empty = none)")
+	ircPort := flag.String("irc-port", os.Getenv("IRC_PORT"), "Port IRC clients connect to, e.g. 6667 (TLS with -tls-cert; empty = no IRC)")
+	otlpEndpoint := flag.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "OpenTelemetry collector to send spans of sends and polls to over OTLP/HTTP, e.g. http://localhost:4318 (empty = no tracing)")
+	importPath := flag.String("import", "", "Archive from GET /api/admin/export or \"server export\" to read into -data-dir (and the message buffer) before starting")
+	flag.Parse()
+
+	// Command line over config file over environment over built-in.
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	if *configPath != "" {
+		if err := fileconfig.ApplyFile(flag.CommandLine, *configPath, explicit); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	logger, err := logging.New(os.Stderr, *logFormat, *logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	// Route the standard log package (used by libraries) through slog too.
+	slog.SetDefault(logger)
+
+	// configFromFlags checks the flags and turns them into a Config; SIGHUP
+	// runs it again after re-reading the config file.
+	configFromFlags := func() (*Config, error) {
+		if (*tlsCert == "") != (*tlsKey == "") {
+			return nil, errors.New("-tls-cert and -tls-key must be set together")
+		}
+		if *minClientVersion != "" && !utils.ValidVersion(*minClientVersion) {
+			return nil, fmt.Errorf("-min-client-version must look like v1.2.3, not %q", *minClientVersion)
+		}
+		if *spamRepeats < 0 || *floodScore < 0 || (*spamRepeats > 0 && *spamWindow <= 0) || (*floodScore > 0 && *muteFor <= 0) {
+			return nil, errors.New("-spam-repeats and -flood-score must not be negative, -spam-window and -mute must be positive")
+		}
+		switch *auth {
+		case services.AuthKey, services.AuthLocal:
+		case services.AuthOIDC:
+			if *oidcIssuer == "" || *oidcClientID == "" {
+				return nil, errors.New("-auth oidc needs -oidc-issuer and -oidc-client-id")
+			}
+		default:
+			return nil, fmt.Errorf("-auth must be %s, %s or %s, not %q", services.AuthKey, services.AuthLocal, services.AuthOIDC, *auth)
+		}
+		accents, err := services.ParseRoomAccents(*roomAccents)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -room-accent: %w", err)
+		}
+		retention, err := services.ParseRoomRetention(*roomRetention)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -room-retention: %w", err)
+		}
+
+		config := &Config{
+			Port:             *port,
+			AccessKey:        *accessKey,
+			DataDir:          *dataDir,
+			TokenSecret:      *tokenSecret,
+			SessionTTL:       *sessionTTL,
+			MaxMessages:      *maxMessages,
+			MessageTTL:       *msgTTL,
+			RoomRetention:    retention,
+			CleanupInterval:  10 * time.Second,
+			TLSCert:          *tlsCert,
+			TLSKey:           *tlsKey,
+			Scanner:          *scanner,
+			ScanTimeout:      *scanTimeout,
+			MaxUpload:        *maxUpload,
+			UploadQuota:      *uploadQuota,
+			UploadTTL:        *uploadTTL,
+			MaxPaste:         *maxPaste,
+			PasteTTL:         *pasteTTL,
+			AuditLog:         *auditLog,
+			Motd:             *motd,
+			KDFSalt:          *kdfSalt,
+			MinClientVersion: *minClientVersion,
+			Tunables: services.TunableValues{
+				RateLimit:   *rateLimit,
+				RateBurst:   *rateBurst,
+				SlowMode:    services.Duration(*slowMode),
+				PollTimeout: services.Duration(*pollTimeout),
+				Filter:      *filterWords != "",
+				ReadOnly:    *readOnly,
+				RoomAccents: accents,
+			},
+			FilterWords:    strings.Split(*filterWords, ","),
+			AdminToken:     *adminToken,
+			IPRateLimit:    *ipRateLimit,
+			IPRateBurst:    *ipRateBurst,
+			MaxPollsPerIP:  *maxPollsPerIP,
+			TrustedProxies: strings.Split(*trustedProxy, ","),
+			RequireSigned:  *requireSigned,
+			Import:         *importPath,
+			IRCPort:        *ircPort,
+			OTLPEndpoint:   *otlpEndpoint,
+			Auth:           *auth,
+			Spam: services.SpamPolicy{
+				RepeatLimit:  *spamRepeats,
+				RepeatWindow: *spamWindow,
+				FloodScore:   *floodScore,
+				MuteFor:      *muteFor,
+				MaxMute:      *maxMute,
+			},
+			OIDC: services.OIDCConfig{
+				Issuer:        *oidcIssuer,
+				ClientID:      *oidcClientID,
+				ClientSecret:  *oidcClientSecret,
+				Scopes:        strings.Fields(*oidcScopes),
+				UsernameClaim: *oidcUsernameClaim,
+			},
+		}
+
+		if *sandbox {
+			script := services.DefaultSandboxScript
+			if *sandboxScript != "" {
+				script, err = services.LoadSandboxScript(*sandboxScript)
+				if err != nil {
+					return nil, fmt.Errorf("invalid -sandbox-script: %w", err)
+				}
+			}
+			if *sandboxInterval <= 0 {
+				return nil, errors.New("-sandbox-interval must be positive")
+			}
+			applySandbox(config, script, *sandboxInterval)
+		}
+		return config, nil
+	}
+
+	config, err := configFromFlags()
+	if err != nil {
+		logger.Error("invalid configuration", "error", err)
+		os.Exit(2)
+	}
+
+	server, err := NewServer(config, logger)
+	if err != nil {
+		logger.Error("error initializing server", "error", err)
+		os.Exit(1)
+	}
+
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		for sig := range sigChan {
+			if sig != syscall.SIGHUP {
+				break
+			}
+			if *configPath == "" {
+				logger.Warn("SIGHUP: nothing to reload without -config")
+				continue
+			}
+			if err := fileconfig.ApplyFile(flag.CommandLine, *configPath, explicit); err != nil {
+				logger.Error("config not reloaded", "error", err)
+				continue
+			}
+			next, err := configFromFlags()
+			if err != nil {
+				logger.Error("config not reloaded", "error", err)
+				continue
+			}
+			server.Reload(next)
+		}
+
+		fmt.Println()
+		logger.Info("received shutdown signal, exiting")
+
+		if err := server.Shutdown(); err != nil {
+			logger.Error("error shutting down server", "error", err)
+		}
+
+		os.Exit(0)
+	}()
+
+	if err := server.Start(); err != nil && err != http.ErrServerClosed {
+		logger.Error("error starting server", "error", err)
+		os.Exit(1)
+	}
+}
+
+// runExport implements "server export": it writes the archive of a
+// -data-dir without starting a relay, so a stopped relay can be moved.
+// Messages are not in it — they live in a running relay's memory only.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	dataDir := fs.String("data-dir", os.Getenv("DATA_DIR"), "Directory of the relay's persisted state")
+	out := fs.String("o", "", "File to write the archive to (empty = standard output)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *dataDir == "" {
+		fmt.Fprintln(os.Stderr, "export: -data-dir is required")
+		return 2
+	}
+	if _, err := os.Stat(*dataDir); err != nil {
+		fmt.Fprintln(os.Stderr, "export:", err)
+		return 1
+	}
+	store, err := storage.NewStore(*dataDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export:", err)
+		return 1
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		// The archive holds password hashes: keep it to the owner.
+		f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "export:", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	counts, err := services.ExportArchive(bw, store, nil)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "export:", err)
+		return 1
+	}
+	fmt.Fprintln(os.Stderr, "exported", counts)
+	return 0
+}
+
+// applySandbox turns config into a -sandbox relay's. NewServer switches
+// authentication off in AuthService and UserService; this picks the access
+// key backend, which honours that, and lifts the rest: per-address rate and
+// poll caps, slow mode, the spam guard and required signing. Accounts are
+// kept in memory, since sandbox accounts have no password.
+func applySandbox(config *Config, script []services.SandboxLine, interval time.Duration) {
+	config.Sandbox = true
+	config.Auth = services.AuthKey
+	config.SandboxScript = script
+	config.SandboxInterval = interval
+	config.DataDir = ""
+	config.IPRateLimit = 0
+	config.MaxPollsPerIP = 0
+	config.Tunables.SlowMode = 0
+	config.Spam = services.SpamPolicy{}
+	config.RequireSigned = false
+}
+
+// envOr returns the environment variable key, or def when it is unset.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
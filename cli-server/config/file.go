@@ -0,0 +1,86 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A server config file is YAML whose keys are the server's flag names:
+//
+//	port: 8443
+//	data-dir: /var/lib/ttc
+//	tls-cert: /etc/ttc/cert.pem
+//	rate-limit: 5
+//	filter-words: [spam, scam]
+//	room-accent: {lobby: cyan, ops: red}
+//
+// A list is read as its comma-separated items and a map as key=value
+// pairs, the forms the flags take.
+
+// ApplyFile sets the flags of fs from the config file at path. Flags named
+// in explicit — those given on the command line — keep their value; every
+// other flag the file doesn't name goes back to its default, so reading
+// the file again after an edit drops settings removed from it.
+func ApplyFile(fs *flag.FlagSet, path string, explicit map[string]bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(settings))
+	for name, v := range settings {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		values[name] = flagValue(v)
+	}
+
+	var errs []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if explicit[f.Name] || f.Name == "config" {
+			return
+		}
+		value, ok := values[f.Name]
+		if !ok {
+			value = f.DefValue
+		}
+		if err := f.Value.Set(value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: invalid value %q: %v", f.Name, value, err))
+		}
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("%s: %s", path, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// flagValue renders a YAML value the way the flag it sets expects it.
+func flagValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = flagValue(item)
+		}
+		return strings.Join(items, ",")
+	case map[string]interface{}:
+		pairs := make([]string, 0, len(v))
+		for key, item := range v {
+			pairs = append(pairs, key+"="+flagValue(item))
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	}
+	return fmt.Sprint(v)
+}
@@ -3,4 +3,11 @@ module secure-chat-backend
 
 go 1.21
 
-require golang.org/x/time v0.5.0
+require (
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	golang.org/x/crypto v0.31.0
+	golang.org/x/time v0.5.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"secure-chat-backend/internal/logging"
+	"secure-chat-backend/internal/services"
+)
+
+// AdminConfigController lets the operator read and change the server's
+// tunables without a restart. It is guarded by the admin token, not by
+// chat sessions; with no admin token configured the admin API is off.
+type AdminConfigController struct {
+	tunables    *services.Tunables
+	authService *services.AuthService
+	adminToken  string
+	audit       *slog.Logger
+}
+
+func NewAdminConfigController(tunables *services.Tunables, authService *services.AuthService, adminToken string, audit *slog.Logger) *AdminConfigController {
+	return &AdminConfigController{
+		tunables:    tunables,
+		authService: authService,
+		adminToken:  adminToken,
+		audit:       audit,
+	}
+}
+
+// Handle answers GET and PATCH /api/admin/config. PATCH takes any subset
+// of the fields GET returns and applies all of them or none.
+func (c *AdminConfigController) Handle(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(w, r, c.adminToken) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		var patch services.TunablesPatch
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&patch); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		before, after, err := c.tunables.Apply(patch)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if after.RateLimit != before.RateLimit || after.RateBurst != before.RateBurst {
+			c.authService.SetRateLimit(after.RateLimit, after.RateBurst)
+		}
+
+		c.audit.Info("config changed",
+			"remote", r.RemoteAddr,
+			"before", before,
+			"after", after,
+		)
+		logging.FromContext(r.Context()).Info("config changed", "after", after)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.tunables.Get())
+}
+
+// adminAuthorized checks for "Authorization: Bearer <admin token>" and
+// writes the error response itself when it is missing or wrong.
+func adminAuthorized(w http.ResponseWriter, r *http.Request, adminToken string) bool {
+	if adminToken == "" {
+		http.Error(w, "Admin API is disabled (start the server with -admin-token)", http.StatusNotFound)
+		return false
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(adminToken)) != 1 {
+		http.Error(w, "Invalid admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
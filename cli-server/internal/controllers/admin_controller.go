@@ -0,0 +1,555 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/services"
+	"secure-chat-backend/internal/utils"
+)
+
+// AdminController moderates the relay: bans, kicks, unmutes, purges, pins
+// and announcements, issues observer and webhook tokens and accounts, and
+// manages subscriptions. Like AdminConfigController it answers only to the
+// admin token, and every action lands in the audit log.
+type AdminController struct {
+	chatService *services.ChatService
+	authService *services.AuthService
+	userService *services.UserService
+	bans        *services.BanList
+	subs        *services.Subscriptions
+	adminToken  string
+	audit       *slog.Logger
+}
+
+// BanRequest names exactly one of Username and ClientID.
+type BanRequest struct {
+	Username string `json:"username"`
+	ClientID string `json:"client_id"`
+	Reason   string `json:"reason"`
+}
+
+// KickRequest names the user whose sessions end.
+type KickRequest struct {
+	Username string `json:"username"`
+}
+
+// AccountRequest creates an account, the way users get one on a relay
+// with -auth local.
+type AccountRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// UnmuteRequest names the user whose flooding mute ends.
+type UnmuteRequest struct {
+	Username string `json:"username"`
+}
+
+// PurgeRequest narrows a purge to a room and/or a user; empty = everything.
+type PurgeRequest struct {
+	Room     string `json:"room"`
+	Username string `json:"username"`
+}
+
+// AnnounceRequest is an announcement to Room, or to every room when empty.
+// Keep is a duration like "24h" for which clients connecting are shown an
+// announcement to every room too; empty = only those connected now.
+type AnnounceRequest struct {
+	Text string `json:"text"`
+	Room string `json:"room"`
+	Keep string `json:"keep"`
+}
+
+// PinRequest names the message to pin.
+type PinRequest struct {
+	MessageID string `json:"message_id"`
+}
+
+// ObserverRequest asks for a read-only token for Room. TTL is a duration
+// like "720h"; empty = the session TTL.
+type ObserverRequest struct {
+	Name string `json:"name"`
+	Room string `json:"room"`
+	TTL  string `json:"ttl"`
+}
+
+// ObserverResponse carries the issued observer token.
+type ObserverResponse struct {
+	Token     string `json:"token"`
+	Username  string `json:"username"`
+	Room      string `json:"room"`
+	ExpiresAt string `json:"expires_at"`
+	// SigningKey signs the observer's requests, like a login's.
+	SigningKey string `json:"signing_key"`
+}
+
+// WebhookRequest asks for a token that posts to Room as Name. TTL is a
+// duration like "8760h"; empty = services.MaxWebhookTTL.
+type WebhookRequest struct {
+	Name string `json:"name"`
+	Room string `json:"room"`
+	TTL  string `json:"ttl"`
+}
+
+// WebhookResponse carries the issued webhook token and the path to post
+// to, which holds it.
+type WebhookResponse struct {
+	Token     string `json:"token"`
+	Path      string `json:"path"`
+	Username  string `json:"username"`
+	Room      string `json:"room"`
+	ExpiresAt string `json:"expires_at"`
+}
+
+// SubscriptionRequest subscribes URL to Room's messages, only those with
+// one of Keywords if any are given.
+type SubscriptionRequest struct {
+	Room     string   `json:"room"`
+	URL      string   `json:"url"`
+	Keywords []string `json:"keywords"`
+}
+
+// AdminResponse answers every moderation action.
+type AdminResponse struct {
+	Status string `json:"status"`
+	Purged int    `json:"purged,omitempty"`
+	ID     string `json:"id,omitempty"`
+	Time   string `json:"time"`
+}
+
+func NewAdminController(chatService *services.ChatService, authService *services.AuthService, userService *services.UserService, bans *services.BanList, subs *services.Subscriptions, adminToken string, audit *slog.Logger) *AdminController {
+	return &AdminController{
+		chatService: chatService,
+		authService: authService,
+		userService: userService,
+		bans:        bans,
+		subs:        subs,
+		adminToken:  adminToken,
+		audit:       audit,
+	}
+}
+
+// HandleBans answers /api/admin/bans: GET lists the bans, POST adds one
+// and DELETE (?username= or ?client_id=) lifts one. A banned user's
+// sessions stop working at once.
+func (c *AdminController) HandleBans(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(w, r, c.adminToken) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.bans.List())
+
+	case http.MethodPost:
+		var req BanRequest
+		if !decodeAdminRequest(w, r, &req) {
+			return
+		}
+		ban, err := c.bans.Add(services.Ban{Username: req.Username, ClientID: req.ClientID, Reason: req.Reason})
+		if errors.Is(err, services.ErrEmptyBan) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Could not save the ban", http.StatusInternalServerError)
+			return
+		}
+		if ban.Username != "" {
+			c.authService.Kick(ban.Username)
+		}
+		c.audit.Info("ban added", "remote", r.RemoteAddr, "username", ban.Username, "client_id", ban.ClientID, "reason", ban.Reason)
+		writeAdminResponse(w, http.StatusCreated, AdminResponse{Status: "banned"})
+
+	case http.MethodDelete:
+		query := r.URL.Query()
+		username, clientID := query.Get("username"), query.Get("client_id")
+		err := c.bans.Remove(username, clientID)
+		switch {
+		case errors.Is(err, services.ErrEmptyBan):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, services.ErrUnknownBan):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, "Could not save the ban list", http.StatusInternalServerError)
+			return
+		}
+		c.audit.Info("ban lifted", "remote", r.RemoteAddr, "username", username, "client_id", clientID)
+		writeAdminResponse(w, http.StatusOK, AdminResponse{Status: "unbanned"})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleKick answers POST /api/admin/kick: the user's sessions end and
+// their clients must log in again.
+func (c *AdminController) HandleKick(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(w, r, c.adminToken) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req KickRequest
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+	if req.Username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+	c.authService.Kick(req.Username)
+	c.audit.Info("user kicked", "remote", r.RemoteAddr, "username", req.Username)
+	writeAdminResponse(w, http.StatusOK, AdminResponse{Status: "kicked"})
+}
+
+// HandleAccounts answers POST /api/admin/accounts by creating an account
+// with a password, whatever -auth says about registering.
+func (c *AdminController) HandleAccounts(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(w, r, c.adminToken) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req AccountRequest
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+	err := c.userService.Register(req.Username, req.Password)
+	switch {
+	case errors.Is(err, services.ErrUserExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, services.ErrInvalidUsername), errors.Is(err, services.ErrWeakPassword):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "Could not create account", http.StatusInternalServerError)
+		return
+	}
+	c.audit.Info("account created", "remote", r.RemoteAddr, "username", req.Username)
+	writeAdminResponse(w, http.StatusCreated, AdminResponse{Status: "created"})
+}
+
+// HandleUnmute answers POST /api/admin/unmute: a user muted for flooding
+// may send again, and their earlier mutes no longer lengthen the next.
+func (c *AdminController) HandleUnmute(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(w, r, c.adminToken) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req UnmuteRequest
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+	if req.Username == "" {
+		http.Error(w, "username is required", http.StatusBadRequest)
+		return
+	}
+	if !c.chatService.Unmute(req.Username) {
+		http.Error(w, "user is not muted", http.StatusNotFound)
+		return
+	}
+	c.audit.Info("user unmuted", "remote", r.RemoteAddr, "username", req.Username)
+	writeAdminResponse(w, http.StatusOK, AdminResponse{Status: "unmuted"})
+}
+
+// HandlePurge answers POST /api/admin/purge: held messages, optionally only
+// those of one room and/or user, disappear from polls and history.
+func (c *AdminController) HandlePurge(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(w, r, c.adminToken) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PurgeRequest
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+	if req.Room != "" && !utils.ValidateRoom(req.Room) {
+		http.Error(w, "Invalid room name", http.StatusBadRequest)
+		return
+	}
+	purged := c.chatService.Purge(req.Room, req.Username)
+	c.audit.Info("messages purged", "remote", r.RemoteAddr, "room", req.Room, "username", req.Username, "purged", purged)
+	writeAdminResponse(w, http.StatusOK, AdminResponse{Status: "purged", Purged: purged})
+}
+
+// HandleAnnounce answers /api/admin/announce. POST posts an announcement,
+// which clients show as a system line from the relay; kept ones are also
+// in /api/motd for clients that connect later. DELETE (?id=) stops showing
+// a kept one to them.
+func (c *AdminController) HandleAnnounce(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(w, r, c.adminToken) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var req AnnounceRequest
+		if !decodeAdminRequest(w, r, &req) {
+			return
+		}
+		if req.Room != "" && !utils.ValidateRoom(req.Room) {
+			http.Error(w, "Invalid room name", http.StatusBadRequest)
+			return
+		}
+		var keep time.Duration
+		if req.Keep != "" {
+			var err error
+			if keep, err = time.ParseDuration(req.Keep); err != nil || keep <= 0 {
+				http.Error(w, "Invalid keep — use a duration like \"24h\"", http.StatusBadRequest)
+				return
+			}
+		}
+		msg, err := c.chatService.Announce(req.Text, req.Room, keep)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.audit.Info("announcement", "remote", r.RemoteAddr, "room", req.Room, "keep", req.Keep, "message_id", msg.ID)
+		writeAdminResponse(w, http.StatusOK, AdminResponse{Status: "announced", ID: msg.ID})
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if !c.chatService.Withdraw(id) {
+			http.Error(w, "No kept announcement with that id", http.StatusNotFound)
+			return
+		}
+		c.audit.Info("announcement withdrawn", "remote", r.RemoteAddr, "message_id", id)
+		writeAdminResponse(w, http.StatusOK, AdminResponse{Status: "withdrawn", ID: id})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandlePins answers /api/admin/pins: POST pins any message to its room
+// and DELETE (?message_id=) unpins one, whoever wrote it.
+func (c *AdminController) HandlePins(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(w, r, c.adminToken) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var req PinRequest
+		if !decodeAdminRequest(w, r, &req) {
+			return
+		}
+		pin, err := c.chatService.Pin(models.AnnouncementUser, req.MessageID, true)
+		if err != nil {
+			writePinError(w, err)
+			return
+		}
+		c.audit.Info("message pinned", "remote", r.RemoteAddr, "message_id", req.MessageID, "username", pin.Message.Username)
+		writeAdminResponse(w, http.StatusOK, AdminResponse{Status: "pinned", ID: req.MessageID})
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("message_id")
+		if err := c.chatService.Unpin(models.AnnouncementUser, id, true); err != nil {
+			writePinError(w, err)
+			return
+		}
+		c.audit.Info("message unpinned", "remote", r.RemoteAddr, "message_id", id)
+		writeAdminResponse(w, http.StatusOK, AdminResponse{Status: "unpinned", ID: id})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleObservers answers POST /api/admin/observers with a read-only token
+// for one room: it polls and pages history there, and is refused everywhere
+// else. Kicking its username revokes it for as long as the relay runs (a
+// restart forgets kicks), banning it for good.
+func (c *AdminController) HandleObservers(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(w, r, c.adminToken) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ObserverRequest
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+	if !utils.ValidateRoom(req.Room) {
+		http.Error(w, "Invalid room name", http.StatusBadRequest)
+		return
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+			http.Error(w, "Invalid ttl — use a duration like \"720h\"", http.StatusBadRequest)
+			return
+		}
+	}
+	token, session, expiresAt, err := c.authService.IssueObserverToken(req.Name, req.Room, ttl)
+	if errors.Is(err, services.ErrBadObserver) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not issue the token", http.StatusInternalServerError)
+		return
+	}
+	c.audit.Info("observer token issued", "remote", r.RemoteAddr, "username", session.Username, "room", session.Room, "expires_at", expiresAt)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ObserverResponse{
+		Token:      token,
+		Username:   session.Username,
+		Room:       session.Room,
+		ExpiresAt:  expiresAt.Format(time.RFC3339),
+		SigningKey: c.authService.EncodedSigningKey(token),
+	})
+}
+
+// HandleWebhooks answers POST /api/admin/webhooks with a token that posts
+// to one room through /api/webhook/{token} (see WebhookController). Kicking
+// its username revokes it for as long as the relay runs (a restart forgets
+// kicks), banning it for good.
+func (c *AdminController) HandleWebhooks(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(w, r, c.adminToken) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req WebhookRequest
+	if !decodeAdminRequest(w, r, &req) {
+		return
+	}
+	if !utils.ValidateRoom(req.Room) {
+		http.Error(w, "Invalid room name", http.StatusBadRequest)
+		return
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl <= 0 {
+			http.Error(w, "Invalid ttl — use a duration like \"8760h\"", http.StatusBadRequest)
+			return
+		}
+	}
+	token, session, expiresAt, err := c.authService.IssueWebhookToken(req.Name, req.Room, ttl)
+	if errors.Is(err, services.ErrBadWebhook) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Could not issue the token", http.StatusInternalServerError)
+		return
+	}
+	c.audit.Info("webhook token issued", "remote", r.RemoteAddr, "username", session.Username, "room", session.Room, "expires_at", expiresAt)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(WebhookResponse{
+		Token:     token,
+		Path:      WebhookPath + token,
+		Username:  session.Username,
+		Room:      session.Room,
+		ExpiresAt: expiresAt.Format(time.RFC3339),
+	})
+}
+
+// HandleSubscriptions answers /api/admin/subscriptions: GET lists the
+// subscriptions, POST adds one — the answer is the only time its secret is
+// shown — and DELETE (?id=) ends one.
+func (c *AdminController) HandleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(w, r, c.adminToken) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.subs.List())
+
+	case http.MethodPost:
+		var req SubscriptionRequest
+		if !decodeAdminRequest(w, r, &req) {
+			return
+		}
+		if !utils.ValidateRoom(req.Room) {
+			http.Error(w, "Invalid room name", http.StatusBadRequest)
+			return
+		}
+		sub, err := c.subs.Add(req.Room, req.URL, req.Keywords)
+		if errors.Is(err, services.ErrBadSubscription) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Could not save the subscription", http.StatusInternalServerError)
+			return
+		}
+		c.audit.Info("subscription added", "remote", r.RemoteAddr, "id", sub.ID, "room", sub.Room, "url", sub.URL)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(sub)
+
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		err := c.subs.Remove(id)
+		if errors.Is(err, services.ErrUnknownSubscription) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Could not save the subscriptions", http.StatusInternalServerError)
+			return
+		}
+		c.audit.Info("subscription removed", "remote", r.RemoteAddr, "id", id)
+		writeAdminResponse(w, http.StatusOK, AdminResponse{Status: "removed", ID: id})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// decodeAdminRequest reads a small JSON body into v, rejecting unknown
+// fields, and writes the error response itself on failure.
+func decodeAdminRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeAdminResponse(w http.ResponseWriter, status int, resp AdminResponse) {
+	resp.Time = time.Now().Format(time.RFC3339)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"secure-chat-backend/internal/logging"
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/services"
+)
+
+// DeviceController logs users in through the relay's OpenID Connect
+// provider with the device code flow, on relays started with -auth oidc:
+// the client starts it, shows the user a code to enter at the provider,
+// and polls until the user approved the sign-in.
+type DeviceController struct {
+	oidc             *services.OIDCAuth // nil unless -auth oidc
+	authService      *services.AuthService
+	userService      *services.UserService
+	minClientVersion string
+}
+
+// DeviceStartRequest is the POST /api/auth/device body.
+type DeviceStartRequest struct {
+	ClientID string `json:"client_id"`
+}
+
+// DeviceStartResponse is the provider's device authorization.
+type DeviceStartResponse struct {
+	services.DeviceAuthorization
+	Issuer string `json:"issuer"`
+}
+
+// DeviceTokenRequest is the POST /api/auth/device/token body. The keys and
+// Protocol are those of a LoginRequest.
+type DeviceTokenRequest struct {
+	ClientID    string `json:"client_id"`
+	DeviceCode  string `json:"device_code"`
+	IdentityKey string `json:"identity_key,omitempty"`
+	DHKey       string `json:"dh_key,omitempty"`
+	DHKeySig    string `json:"dh_key_sig,omitempty"`
+	Protocol    int    `json:"protocol,omitempty"`
+}
+
+func NewDeviceController(oidc *services.OIDCAuth, authService *services.AuthService, userService *services.UserService, minClientVersion string) *DeviceController {
+	return &DeviceController{
+		oidc:             oidc,
+		authService:      authService,
+		userService:      userService,
+		minClientVersion: minClientVersion,
+	}
+}
+
+// admit decodes the body into req and runs the checks every device
+// request shares. It answers and returns false when one fails.
+func (c *DeviceController) admit(w http.ResponseWriter, r *http.Request, req interface{}, clientID func() string) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if c.oidc == nil {
+		http.Error(w, "This relay doesn't sign in through an identity provider", http.StatusNotFound)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return false
+	}
+
+	id := clientID()
+	logging.AddAttrs(r.Context(), slog.String("client_id", id))
+	if err := c.oidc.Admit(services.LoginAttempt{ClientID: id}); err != nil {
+		if errors.Is(err, services.ErrBanned) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return false
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if !c.authService.CheckRateLimit(id) {
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return false
+	}
+	return !clientTooOld(w, r, c.minClientVersion)
+}
+
+// HandleStart answers POST /api/auth/device with a code for the user to
+// enter at the provider's verification page.
+func (c *DeviceController) HandleStart(w http.ResponseWriter, r *http.Request) {
+	var req DeviceStartRequest
+	if !c.admit(w, r, &req, func() string { return req.ClientID }) {
+		return
+	}
+
+	da, err := c.oidc.StartDevice(r.Context())
+	if err != nil {
+		logging.AddAttrs(r.Context(), slog.String("oidc_error", err.Error()))
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeviceStartResponse{DeviceAuthorization: *da, Issuer: c.oidc.Issuer()})
+}
+
+// HandleToken answers POST /api/auth/device/token: 428 while the user
+// hasn't approved the sign-in yet, 429 with Retry-After when polled too
+// often, 403 or 410 when it was denied or the code expired, and a session
+// like a login once the user approved it.
+func (c *DeviceController) HandleToken(w http.ResponseWriter, r *http.Request) {
+	var req DeviceTokenRequest
+	if !c.admit(w, r, &req, func() string { return req.ClientID }) {
+		return
+	}
+	if req.DeviceCode == "" {
+		http.Error(w, "device_code is required", http.StatusBadRequest)
+		return
+	}
+
+	keys := services.PublicKeys{IdentityKey: req.IdentityKey, DHKey: req.DHKey, DHKeySig: req.DHKeySig}
+	if keys != (services.PublicKeys{}) {
+		if err := services.ValidatePublicKeys(keys); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	username, err := c.oidc.PollDevice(r.Context(), req.DeviceCode)
+	switch {
+	case errors.Is(err, services.ErrAuthorizationPending):
+		http.Error(w, err.Error(), http.StatusPreconditionRequired)
+		return
+	case errors.Is(err, services.ErrSlowDown):
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case errors.Is(err, services.ErrAccessDenied):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, services.ErrDeviceCodeExpired):
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	case err != nil:
+		logging.AddAttrs(r.Context(), slog.String("oidc_error", err.Error()))
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	logging.AddAttrs(r.Context(), slog.String("user", username))
+
+	// The ban list may name the user now that we know who it is.
+	if err := c.oidc.Admit(services.LoginAttempt{ClientID: req.ClientID, Username: username}); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if keys != (services.PublicKeys{}) {
+		if err := c.userService.SetKeys(username, keys); err != nil {
+			http.Error(w, "Could not store identity key", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	token, expiresAt, err := c.authService.IssueToken(username, req.ClientID)
+	if err != nil {
+		http.Error(w, "Could not issue session token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LoginResponse{
+		Status:     "ok",
+		Username:   username,
+		Token:      token,
+		ExpiresAt:  expiresAt.Format(time.RFC3339),
+		Time:       time.Now().Format(time.RFC3339),
+		SigningKey: c.authService.EncodedSigningKey(token),
+		Protocol:   models.NegotiateProtocol(req.Protocol),
+	})
+}
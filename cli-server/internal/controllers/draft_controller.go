@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"secure-chat-backend/internal/services"
+)
+
+// DraftController lets a user's clients share the message they were typing,
+// one per room, so it follows them from one device to the next.
+type DraftController struct {
+	drafts      *services.DraftStore
+	authService *services.AuthService
+}
+
+// DraftRequest is the POST /api/drafts body; empty text deletes the draft.
+type DraftRequest struct {
+	Token string `json:"token"`
+	Room  string `json:"room"`
+	Text  string `json:"text"`
+}
+
+// DraftResponse is a room's draft; text is empty when there is none.
+type DraftResponse struct {
+	Room      string     `json:"room"`
+	Text      string     `json:"text"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	Time      string     `json:"time"`
+}
+
+func NewDraftController(drafts *services.DraftStore, authService *services.AuthService) *DraftController {
+	return &DraftController{
+		drafts:      drafts,
+		authService: authService,
+	}
+}
+
+// Handle serves both directions:
+//
+//	POST /api/drafts {"token": ..., "room": ..., "text": ...}  — save the draft
+//	GET  /api/drafts?room=...                                   — load it
+func (c *DraftController) Handle(w http.ResponseWriter, r *http.Request) {
+	var token, room, text string
+	switch r.Method {
+	case http.MethodPost:
+		var req DraftRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 2*services.MaxDraftSize)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		token, room, text = req.Token, req.Room, req.Text
+	case http.MethodGet:
+		room = r.URL.Query().Get("room")
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := c.authService.ValidateSession(sessionToken(r, token))
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	logSession(r, session)
+	room, ok = resolveRoom(room)
+	if !ok {
+		http.Error(w, "Invalid room name", http.StatusBadRequest)
+		return
+	}
+
+	resp := DraftResponse{Room: room}
+	if r.Method == http.MethodPost {
+		draft, err := c.drafts.Put(session.Username, room, text)
+		switch {
+		case errors.Is(err, services.ErrDraftTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			http.Error(w, "Could not save the draft", http.StatusInternalServerError)
+			return
+		}
+		if draft.Text != "" {
+			resp.Text = draft.Text
+			resp.UpdatedAt = &draft.UpdatedAt
+		}
+	} else if draft, ok := c.drafts.Get(session.Username, room); ok {
+		resp.Text = draft.Text
+		resp.UpdatedAt = &draft.UpdatedAt
+	}
+
+	resp.Time = time.Now().Format(time.RFC3339)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MIMEMsgpack is the content type of MessagePack bodies. Send, poll and
+// history speak it besides JSON: a client that puts it in Accept gets its
+// answers in it, and may send a body in it with a matching Content-Type.
+// Field names are the JSON ones.
+const MIMEMsgpack = "application/msgpack"
+
+// wantsMsgpack reports whether r's Accept header asks for MessagePack
+// before JSON.
+func wantsMsgpack(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || params["q"] == "0" {
+			continue
+		}
+		switch mediaType {
+		case MIMEMsgpack, "application/x-msgpack":
+			return true
+		case "application/json":
+			return false
+		}
+	}
+	return false
+}
+
+// decodeBody reads a JSON or, by its Content-Type, MessagePack request
+// body into v.
+func decodeBody(r *http.Request, body io.Reader, v interface{}) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == MIMEMsgpack || mediaType == "application/x-msgpack" {
+		dec := msgpack.NewDecoder(body)
+		dec.SetCustomStructTag("json")
+		return dec.Decode(v)
+	}
+	return json.NewDecoder(body).Decode(v)
+}
+
+// writeEncoded answers with status and v in the encoding r asked for.
+func writeEncoded(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Add("Vary", "Accept")
+	if !wantsMsgpack(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+		return
+	}
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		http.Error(w, "Could not encode the response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", MIMEMsgpack)
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/services"
+	"secure-chat-backend/internal/storage"
+)
+
+// ExportController streams the relay's state as an archive (see
+// services.ExportArchive) for a migration: accounts with their password
+// hashes, bans, drafts and the buffered messages. Like the rest of the
+// admin API it answers only to the admin token.
+type ExportController struct {
+	store      storage.Store
+	buffer     *models.MessageBuffer
+	adminToken string
+	audit      *slog.Logger
+}
+
+func NewExportController(store storage.Store, buffer *models.MessageBuffer, adminToken string, audit *slog.Logger) *ExportController {
+	return &ExportController{
+		store:      store,
+		buffer:     buffer,
+		adminToken: adminToken,
+		audit:      audit,
+	}
+}
+
+// Handle answers GET /api/admin/export with the archive as JSON lines.
+func (c *ExportController) Handle(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(w, r, c.adminToken) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"ttc-%s.jsonl\"", time.Now().UTC().Format("20060102-150405")))
+	counts, err := services.ExportArchive(w, c.store, c.buffer)
+	if err != nil {
+		// The status line went out with the header; without its end
+		// record the archive won't import.
+		c.audit.Error("export failed", "remote", r.RemoteAddr, "error", err)
+		return
+	}
+	c.audit.Info("state exported", "remote", r.RemoteAddr, "accounts", counts.Accounts, "bans", counts.Bans, "drafts", counts.Drafts, "messages", counts.Messages)
+}
@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"secure-chat-backend/internal/middleware"
+	"secure-chat-backend/internal/services"
+)
+
+// FileController hands out uploads that passed the scanner.
+type FileController struct {
+	uploadService *services.UploadService
+	authService   *services.AuthService
+}
+
+// FileError is the body of every failed /api/upload and /api/files
+// request. Code is stable for clients to switch on; Message is for people.
+type FileError struct {
+	Code    string `json:"error"`
+	Message string `json:"message"`
+	Limit   int64  `json:"limit,omitempty"` // bytes, for file_too_large and quota_exceeded
+	Used    int64  `json:"used,omitempty"`  // bytes, for quota_exceeded
+}
+
+func NewFileController(uploadService *services.UploadService, authService *services.AuthService) *FileController {
+	return &FileController{
+		uploadService: uploadService,
+		authService:   authService,
+	}
+}
+
+// Handle answers GET /api/files/{id} and the older
+// GET /api/files?id=... with the file itself.
+func (c *FileController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeFileError(w, http.StatusMethodNotAllowed, FileError{Code: "method_not_allowed", Message: "Method not allowed"})
+		return
+	}
+
+	query := r.URL.Query()
+	session, ok := c.authService.ValidateSession(middleware.BearerToken(r))
+	if !ok {
+		writeFileError(w, http.StatusUnauthorized, FileError{Code: "unauthorized", Message: "Unauthorized"})
+		return
+	}
+	logSession(r, session)
+
+	id := query.Get("id")
+	if rest := strings.TrimPrefix(r.URL.Path, "/api/files/"); rest != r.URL.Path {
+		if rest == "" || strings.Contains(rest, "/") {
+			writeUploadError(w, c.uploadService, session.Username, services.ErrUploadNotFound)
+			return
+		}
+		id = rest
+	}
+	upload, err := c.uploadService.Get(id)
+	if err != nil {
+		writeUploadError(w, c.uploadService, session.Username, err)
+		return
+	}
+
+	// Always a download, never rendered inline by a browser.
+	w.Header().Set("Content-Type", upload.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": upload.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.Itoa(upload.Size))
+	w.Write(upload.Data)
+}
+
+// writeUploadError answers with the status and FileError for an
+// UploadService error.
+func writeUploadError(w http.ResponseWriter, uploads *services.UploadService, username string, err error) {
+	status, fe := uploadErrorResponse(uploads, username, err)
+	writeFileError(w, status, fe)
+}
+
+func uploadErrorResponse(uploads *services.UploadService, username string, err error) (int, FileError) {
+	policy := uploads.Policy()
+	fe := FileError{Message: err.Error()}
+	switch {
+	case errors.Is(err, services.ErrBadUploadName):
+		fe.Code = "bad_name"
+		return http.StatusBadRequest, fe
+	case errors.Is(err, services.ErrUploadTooLarge):
+		fe.Code, fe.Limit = "file_too_large", int64(policy.MaxFileSize)
+		return http.StatusRequestEntityTooLarge, fe
+	case errors.Is(err, services.ErrUploadQuota):
+		fe.Code, fe.Limit, fe.Used = "quota_exceeded", policy.UserQuota, uploads.Usage(username)
+		return http.StatusRequestEntityTooLarge, fe
+	case errors.Is(err, services.ErrUploadRateLimit):
+		fe.Code = "rate_limited"
+		return http.StatusTooManyRequests, fe
+	case errors.Is(err, services.ErrUploadRejected):
+		fe.Code = "infected"
+		return http.StatusUnprocessableEntity, fe
+	case errors.Is(err, services.ErrScanUnavailable):
+		fe.Code = "scan_unavailable"
+		return http.StatusServiceUnavailable, fe
+	case errors.Is(err, services.ErrUploadNotFound):
+		fe.Code = "not_found"
+		return http.StatusNotFound, fe
+	case errors.Is(err, services.ErrUploadExpired):
+		fe.Code = "expired"
+		return http.StatusGone, fe
+	}
+	return http.StatusInternalServerError, FileError{Code: "internal", Message: "Could not store file"}
+}
+
+func writeFileError(w http.ResponseWriter, status int, fe FileError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(fe)
+}
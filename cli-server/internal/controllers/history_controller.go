@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"secure-chat-backend/internal/logging"
+	"secure-chat-backend/internal/middleware"
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/services"
+	"secure-chat-backend/internal/utils"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 500
+)
+
+// HistoryController serves the backlog of a room so a client that just
+// connected does not start from an empty screen.
+type HistoryController struct {
+	chatService *services.ChatService
+	authService *services.AuthService
+}
+
+// HistoryResponse is one page of history, oldest message first. When
+// HasMore is set the next older page is requested with before=<first id>.
+type HistoryResponse struct {
+	Room     string        `json:"room"`
+	Messages []interface{} `json:"messages"`
+	HasMore  bool          `json:"has_more"`
+}
+
+func NewHistoryController(chatService *services.ChatService, authService *services.AuthService) *HistoryController {
+	return &HistoryController{
+		chatService: chatService,
+		authService: authService,
+	}
+}
+
+// Handle answers GET /api/history?room=...&limit=100&before=<id>,
+// and the same under /api/v2.
+func (c *HistoryController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	room, ok := resolveRoom(query.Get("room"))
+	if !ok {
+		http.Error(w, "Invalid room name", http.StatusBadRequest)
+		return
+	}
+
+	session, ok := c.authService.ValidateReader(middleware.BearerToken(r), room)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	logSession(r, session)
+
+	limit := defaultHistoryLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, maxHistoryLimit)
+	}
+
+	protocol := protocolOf(r)
+	view := models.View{Room: room, User: session.Username, Reactions: protocol >= models.ProtocolV2}
+	messages, more := c.chatService.History(view, query.Get("before"), limit)
+
+	page := HistoryResponse{
+		Room:     room,
+		Messages: make([]interface{}, len(messages)),
+		HasMore:  more,
+	}
+	for i, msg := range messages {
+		if protocol >= models.ProtocolV2 {
+			page.Messages[i] = msg.ToWire()
+			continue
+		}
+		entry := msg.ToClientFormat()
+		entry["timestamp"] = msg.Timestamp.Format(time.RFC3339)
+		page.Messages[i] = entry
+	}
+
+	writeEncoded(w, r, http.StatusOK, page)
+}
+
+// resolveRoom maps the room a request names to a buffer room: empty means
+// the lobby, anything else must be a valid room name.
+func resolveRoom(room string) (string, bool) {
+	if room == "" {
+		return models.DefaultRoom, true
+	}
+	return room, utils.ValidateRoom(room)
+}
+
+// protocolOf returns the message format version r was made for: 2 under
+// /api/v2/, 1 for the original paths.
+func protocolOf(r *http.Request) int {
+	if strings.HasPrefix(r.URL.Path, "/api/v2/") {
+		return models.ProtocolV2
+	}
+	return models.ProtocolV1
+}
+
+// clientMessage returns msg as protocol version protocol sends it.
+func clientMessage(msg *models.Message, protocol int) interface{} {
+	if protocol >= models.ProtocolV2 {
+		return msg.ToWire()
+	}
+	return msg.ToClientFormat()
+}
+
+// Poll and stream headers: the sequence number a response reads up to, the
+// reader's next last_seq, and whether messages it never got have expired.
+const (
+	headerSeq = "X-TTC-Seq"
+	headerGap = "X-TTC-Gap"
+)
+
+// readCursor returns the cursor a poll or stream names with last_id and
+// last_seq; ok is false for a malformed last_seq.
+func readCursor(query url.Values, lastID string) (models.Cursor, bool) {
+	cursor := models.Cursor{ID: lastID}
+	if v := query.Get("last_seq"); v != "" {
+		seq, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return cursor, false
+		}
+		cursor.Seq = seq
+	}
+	return cursor, true
+}
+
+// sessionToken returns the session token r was made with: the Bearer token
+// if there is one, else fallback from the body. The query string is never
+// read: a token in a URL ends up in access logs and proxy histories.
+func sessionToken(r *http.Request, fallback string) string {
+	if token := middleware.BearerToken(r); token != "" {
+		return token
+	}
+	return fallback
+}
+
+// logSession tags the request's log lines with the client and user behind
+// it, so its access log line can be matched with others of the same client.
+func logSession(r *http.Request, session *services.Session) {
+	logging.AddAttrs(r.Context(),
+		slog.String("client_id", session.ClientID),
+		slog.String("user", session.Username))
+}
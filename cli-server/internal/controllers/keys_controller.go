@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"secure-chat-backend/internal/middleware"
+	"secure-chat-backend/internal/services"
+)
+
+// KeysController hands out the keys users' clients published at login: the
+// Ed25519 identity key and the X25519 key direct messages are encrypted to.
+// The relay only passes them on — clients pin the first identity key they
+// see per user and warn when it changes, and check that the X25519 key is
+// signed by it, so a swapped key doesn't go unnoticed.
+type KeysController struct {
+	authService *services.AuthService
+	userService *services.UserService
+}
+
+// KeyResponse is the /api/keys?user=... body.
+type KeyResponse struct {
+	services.UserKeys
+	Time string `json:"time"`
+}
+
+// KeyDirectoryResponse is the /api/keys body without user: every user who
+// published keys.
+type KeyDirectoryResponse struct {
+	Users []services.UserKeys `json:"users"`
+	Time  string              `json:"time"`
+}
+
+func NewKeysController(authService *services.AuthService, userService *services.UserService) *KeysController {
+	return &KeysController{
+		authService: authService,
+		userService: userService,
+	}
+}
+
+// Handle answers GET /api/keys?user=..., or lists the directory
+// when user is left out.
+func (c *KeysController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	session, ok := c.authService.ValidateSession(middleware.BearerToken(r))
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	logSession(r, session)
+
+	username := query.Get("user")
+	if username == "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(KeyDirectoryResponse{
+			Users: c.userService.Directory(),
+			Time:  time.Now().Format(time.RFC3339),
+		})
+		return
+	}
+
+	keys, exists := c.userService.Keys(username)
+	if !exists {
+		http.Error(w, services.ErrUnknownUser.Error(), http.StatusNotFound)
+		return
+	}
+	if keys.IdentityKey == "" {
+		http.Error(w, "user has not published an identity key", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(KeyResponse{
+		UserKeys: services.UserKeys{Username: username, PublicKeys: keys},
+		Time:     time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"secure-chat-backend/internal/logging"
+	"secure-chat-backend/internal/middleware"
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/services"
+	"secure-chat-backend/internal/utils"
+)
+
+type LoginController struct {
+	authenticator    services.Authenticator
+	authService      *services.AuthService
+	userService      *services.UserService
+	guard            *services.LoginGuard
+	minClientVersion string
+}
+
+// maxAuthBody caps a login or register body: credentials and three keys.
+const maxAuthBody = 16 << 10
+
+type LoginRequest struct {
+	AccessKey string `json:"access_key"`
+	ClientID  string `json:"client_id"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	// IdentityKey optionally publishes the client's Ed25519 public key,
+	// DHKey and DHKeySig its X25519 key for direct messages.
+	IdentityKey string `json:"identity_key,omitempty"`
+	DHKey       string `json:"dh_key,omitempty"`
+	DHKeySig    string `json:"dh_key_sig,omitempty"`
+	// Protocol is the newest message format version the client speaks;
+	// absent means 1.
+	Protocol int `json:"protocol,omitempty"`
+}
+
+type LoginResponse struct {
+	Status    string `json:"status"`
+	Username  string `json:"username"`
+	Token     string `json:"token"`
+	ExpiresAt string `json:"expires_at"`
+	Time      string `json:"time"`
+	// SigningKey signs the requests made with Token (base64url, see
+	// middleware.SigningMiddleware).
+	SigningKey string `json:"signing_key"`
+	// Protocol is the message format version to use, see
+	// models.NegotiateProtocol.
+	Protocol int `json:"protocol"`
+}
+
+// NewLoginController creates the login endpoint. guard refuses a username
+// from an address after too many failed logins there.
+func NewLoginController(authenticator services.Authenticator, authService *services.AuthService, userService *services.UserService, guard *services.LoginGuard, minClientVersion string) *LoginController {
+	return &LoginController{
+		authenticator:    authenticator,
+		authService:      authService,
+		userService:      userService,
+		guard:            guard,
+		minClientVersion: minClientVersion,
+	}
+}
+
+func (c *LoginController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LoginRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAuthBody)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	logging.AddAttrs(r.Context(),
+		slog.String("client_id", req.ClientID),
+		slog.String("user", req.Username))
+
+	attempt := services.LoginAttempt{AccessKey: req.AccessKey, ClientID: req.ClientID, Username: req.Username, Password: req.Password}
+	if err := c.authenticator.Admit(attempt); err != nil {
+		if errors.Is(err, services.ErrBanned) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !c.authService.CheckRateLimit(req.ClientID) {
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	if clientTooOld(w, r, c.minClientVersion) {
+		return
+	}
+
+	keys := services.PublicKeys{IdentityKey: req.IdentityKey, DHKey: req.DHKey, DHKeySig: req.DHKeySig}
+	if keys != (services.PublicKeys{}) {
+		if err := services.ValidatePublicKeys(keys); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ip := middleware.ClientIP(r)
+	if c.guard.LockedOut(req.Username, ip) {
+		http.Error(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	username, err := c.authenticator.Login(attempt)
+	if err != nil {
+		if !errors.Is(err, services.ErrDeviceLogin) {
+			c.guard.Failed(req.Username, ip)
+		}
+		// An unknown username gets the wrong password's answer, so logins
+		// don't tell which accounts exist.
+		switch {
+		case errors.Is(err, services.ErrDeviceLogin):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		}
+		return
+	}
+	c.guard.Succeeded(req.Username, ip)
+
+	if keys != (services.PublicKeys{}) {
+		if err := c.userService.SetKeys(username, keys); err != nil {
+			http.Error(w, "Could not store identity key", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	token, expiresAt, err := c.authService.IssueToken(username, req.ClientID)
+	if err != nil {
+		http.Error(w, "Could not issue session token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(LoginResponse{
+		Status:     "ok",
+		Username:   username,
+		Token:      token,
+		ExpiresAt:  expiresAt.Format(time.RFC3339),
+		Time:       time.Now().Format(time.RFC3339),
+		SigningKey: c.authService.EncodedSigningKey(token),
+		Protocol:   models.NegotiateProtocol(req.Protocol),
+	})
+}
+
+// clientTooOld answers 426 Upgrade Required, and returns true, when the
+// request comes from a TTC client older than minVersion. Other user agents
+// pass: the check is there to retire old clients, not to lock out scripts.
+func clientTooOld(w http.ResponseWriter, r *http.Request, minVersion string) bool {
+	if minVersion == "" {
+		return false
+	}
+	v, ok := utils.ClientVersion(r.UserAgent())
+	if !ok || utils.CompareVersions(v, minVersion) >= 0 {
+		return false
+	}
+	logging.AddAttrs(r.Context(), slog.String("client_version", v))
+	http.Error(w, "This relay requires client "+minVersion+" or newer.", http.StatusUpgradeRequired)
+	return true
+}
@@ -0,0 +1,26 @@
+package controllers
+
+import (
+	"net/http"
+
+	"secure-chat-backend/internal/metrics"
+)
+
+// MetricsController serves traffic metrics in the Prometheus text format.
+type MetricsController struct {
+	traffic *metrics.Traffic
+}
+
+func NewMetricsController(traffic *metrics.Traffic) *MetricsController {
+	return &MetricsController{traffic: traffic}
+}
+
+// Handle answers GET /metrics.
+func (c *MetricsController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.traffic.WriteTo(w)
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"secure-chat-backend/internal/services"
+)
+
+// MotdController serves the operator's message of the day and kept
+// announcements, the salt clients stretch their room passphrase with, the
+// oldest client version still served, the rooms' accent colors and how
+// users log in. None of it is secret, so no token is needed.
+type MotdController struct {
+	mu               sync.RWMutex
+	motd             string
+	kdfSalt          string
+	minClientVersion string
+	auth             string                // the -auth backend
+	tunables         *services.Tunables    // room accents
+	chatService      *services.ChatService // kept announcements
+}
+
+// MotdResponse is the /api/motd body.
+type MotdResponse struct {
+	Motd string `json:"motd"`
+	// Announcements are the kept announcements to every room, for
+	// clients to show when they connect; see services.ChatService.Announce.
+	Announcements []services.Notice `json:"announcements,omitempty"`
+	KDFSalt       string            `json:"kdf_salt,omitempty"`
+	// MinClientVersion is the oldest client login accepts; older ones
+	// stop at their login screen.
+	MinClientVersion string `json:"min_client_version,omitempty"`
+	// RoomAccents maps rooms to the color clients draw their header in.
+	RoomAccents map[string]string `json:"room_accents,omitempty"`
+	// Auth is how users log in: services.AuthKey, AuthLocal or AuthOIDC,
+	// whose clients sign in at /api/auth/device instead of /api/login.
+	Auth string `json:"auth"`
+	Time string `json:"time"`
+}
+
+func NewMotdController(motd, kdfSalt, minClientVersion, auth string, tunables *services.Tunables, chatService *services.ChatService) *MotdController {
+	return &MotdController{motd: motd, kdfSalt: kdfSalt, minClientVersion: minClientVersion, auth: auth, tunables: tunables, chatService: chatService}
+}
+
+// SetMotd replaces the message of the day.
+func (c *MotdController) SetMotd(motd string) {
+	c.mu.Lock()
+	c.motd = motd
+	c.mu.Unlock()
+}
+
+// Handle answers GET /api/motd.
+func (c *MotdController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c.mu.RLock()
+	motd := c.motd
+	c.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MotdResponse{
+		Motd:             motd,
+		Announcements:    c.chatService.Notices(),
+		KDFSalt:          c.kdfSalt,
+		MinClientVersion: c.minClientVersion,
+		RoomAccents:      c.tunables.Get().RoomAccents,
+		Auth:             c.auth,
+		Time:             time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"secure-chat-backend/internal/logging"
+	"secure-chat-backend/internal/services"
+)
+
+// NickController renames a logged-in user.
+type NickController struct {
+	chatService *services.ChatService
+	authService *services.AuthService
+	userService *services.UserService
+	issuer      string // names come from this identity provider; see SetIssuer
+}
+
+// NickRequest is the POST /api/nick body.
+type NickRequest struct {
+	Token string `json:"token"`
+	Nick  string `json:"nick"`
+}
+
+// NickResponse hands back the session for the new name, like a login.
+type NickResponse struct {
+	Status     string `json:"status"`
+	Username   string `json:"username"`
+	Token      string `json:"token"`
+	ExpiresAt  string `json:"expires_at"`
+	Time       string `json:"time"`
+	SigningKey string `json:"signing_key"`
+}
+
+func NewNickController(chatService *services.ChatService, authService *services.AuthService, userService *services.UserService) *NickController {
+	return &NickController{
+		chatService: chatService,
+		authService: authService,
+		userService: userService,
+	}
+}
+
+// SetIssuer turns renaming off: with -auth oidc a name is the identity
+// provider's, and the next login would only bring the old one back.
+func (c *NickController) SetIssuer(issuer string) {
+	c.issuer = issuer
+}
+
+// Handle answers POST /api/nick: the account, its password and keys move to
+// the new name, every room is told "old is now known as new", and the
+// caller gets a session for the new name. Sessions of the old name end, on
+// the user's other clients too.
+func (c *NickController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req NickRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	session, ok := c.authService.ValidateSession(sessionToken(r, req.Token))
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	logSession(r, session)
+	logging.AddAttrs(r.Context(), slog.String("nick", req.Nick))
+
+	if c.issuer != "" {
+		http.Error(w, "Names on this relay come from "+c.issuer+" and can't be changed here", http.StatusForbidden)
+		return
+	}
+	if req.Nick == session.Username {
+		http.Error(w, "That is already your name", http.StatusBadRequest)
+		return
+	}
+	if err := c.authService.CheckNick(session, req.Nick); err != nil {
+		switch {
+		case errors.Is(err, services.ErrBanned):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		}
+		return
+	}
+
+	if err := c.userService.Rename(session.Username, req.Nick); err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, services.ErrInvalidUsername):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, services.ErrUnknownUser):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, "Could not rename account", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	token, expiresAt, err := c.authService.Rename(session, req.Nick)
+	if err != nil {
+		http.Error(w, "Could not issue session token", http.StatusInternalServerError)
+		return
+	}
+	c.chatService.AnnounceNick(session.Username, req.Nick)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NickResponse{
+		Status:     "ok",
+		Username:   req.Nick,
+		Token:      token,
+		ExpiresAt:  expiresAt.Format(time.RFC3339),
+		Time:       time.Now().Format(time.RFC3339),
+		SigningKey: c.authService.EncodedSigningKey(token),
+	})
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"secure-chat-backend/internal/logging"
+	"secure-chat-backend/internal/services"
+)
+
+// PasteController shares blocks of text by a short ID, so long snippets
+// don't have to go through the room.
+type PasteController struct {
+	pastes      *services.PasteStore
+	authService *services.AuthService
+}
+
+// PasteRequest is the POST /api/paste body.
+type PasteRequest struct {
+	Token  string `json:"token"`
+	Name   string `json:"name"`
+	Syntax string `json:"syntax"`
+	Text   string `json:"text"`
+}
+
+// PasteResponse answers POST /api/paste.
+type PasteResponse struct {
+	ID        string `json:"id"`
+	Size      int    `json:"size"`
+	ExpiresAt string `json:"expires_at"`
+	Time      string `json:"time"`
+}
+
+func NewPasteController(pastes *services.PasteStore, authService *services.AuthService) *PasteController {
+	return &PasteController{
+		pastes:      pastes,
+		authService: authService,
+	}
+}
+
+// Handle serves both directions:
+//
+//	POST /api/paste {"token": ..., "name": ..., "syntax": ..., "text": ...}  — store a paste
+//	GET  /api/paste?id=...                                                  — read it (services.Paste)
+func (c *PasteController) Handle(w http.ResponseWriter, r *http.Request) {
+	var req PasteRequest
+	switch r.Method {
+	case http.MethodPost:
+		body := http.MaxBytesReader(w, r.Body, int64(c.pastes.Policy().MaxSize)+64<<10)
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, services.ErrPasteTooLarge.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	case http.MethodGet:
+		// The token is the Bearer token.
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := c.authService.ValidateSession(sessionToken(r, req.Token))
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	logSession(r, session)
+
+	if r.Method == http.MethodGet {
+		paste, err := c.pastes.Get(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(paste)
+		return
+	}
+
+	paste, err := c.pastes.Put(session.Username, req.Name, req.Syntax, req.Text)
+	switch {
+	case errors.Is(err, services.ErrPasteTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, services.ErrPasteRateLimit):
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	logging.AddAttrs(r.Context(), slog.String("paste_id", paste.ID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(PasteResponse{
+		ID:        paste.ID,
+		Size:      len(paste.Text),
+		ExpiresAt: paste.ExpireAt.Format(time.RFC3339),
+		Time:      time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"secure-chat-backend/internal/logging"
+	"secure-chat-backend/internal/middleware"
+	"secure-chat-backend/internal/services"
+)
+
+// PinController pins messages to their room and lists a room's pins. Users
+// pin and unpin their own messages here; the admin API does any message.
+type PinController struct {
+	chatService *services.ChatService
+	authService *services.AuthService
+}
+
+// PinsResponse is the /api/pins body.
+type PinsResponse struct {
+	Room string          `json:"room"`
+	Pins []*services.Pin `json:"pins"`
+}
+
+func NewPinController(chatService *services.ChatService, authService *services.AuthService) *PinController {
+	return &PinController{
+		chatService: chatService,
+		authService: authService,
+	}
+}
+
+// Handle pins the session user's message and answers with the
+// services.Pin, or unpins it with 204:
+//
+//	POST   /api/messages/<id>/pin
+//	DELETE /api/messages/<id>/pin
+func (c *PinController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/messages/"), "/pin")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	session, ok := c.authService.ValidateSession(middleware.BearerToken(r))
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	logSession(r, session)
+	logging.AddAttrs(r.Context(), slog.String("message_id", id))
+
+	if !c.authService.CheckRateLimit(session.ClientID) {
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := c.chatService.Unpin(session.Username, id, false); err != nil {
+			writePinError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	pin, err := c.chatService.Pin(session.Username, id, false)
+	if err != nil {
+		writePinError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pin)
+}
+
+// HandleList answers GET /api/pins?room=... with the room's
+// pinned messages, in protocol version 2's format whatever the client
+// speaks. Observer tokens for the room are accepted.
+func (c *PinController) HandleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	room, ok := resolveRoom(r.URL.Query().Get("room"))
+	if !ok {
+		http.Error(w, "Invalid room name", http.StatusBadRequest)
+		return
+	}
+	session, ok := c.authService.ValidateReader(middleware.BearerToken(r), room)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	logSession(r, session)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PinsResponse{Room: room, Pins: c.chatService.Pins(room)})
+}
+
+// writePinError answers a refused pin or unpin.
+func writePinError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnknownMessage), errors.Is(err, services.ErrNotPinned):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, services.ErrNotAuthor), errors.Is(err, services.ErrReadOnly):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, services.ErrTooManyPins):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
@@ -1,64 +1,90 @@
-// internal/controllers/poll_controller.go
-package controllers
-
-import (
-	"encoding/json"
-	"net/http"
-	"time"
-
-	"secure-chat-backend/internal/services"
-)
-
-// PollController کنترلر long polling
-type PollController struct {
-	chatService *services.ChatService
-	authService *services.AuthService
-	pollTimeout time.Duration
-}
-
-// NewPollController سازنده
-func NewPollController(chatService *services.ChatService, authService *services.AuthService) *PollController {
-	return &PollController{
-		chatService: chatService,
-		authService: authService,
-		pollTimeout: 30 * time.Second,
-	}
-}
-
-// Handle پردازش درخواست long polling
-func (c *PollController) Handle(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	accessKey := r.URL.Query().Get("access_key")
-	clientID := r.URL.Query().Get("client_id")
-	lastID := r.URL.Query().Get("last_id")
-
-	if !c.authService.ValidateAccess(accessKey, clientID) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	messages, err := c.chatService.WaitForMessages(clientID, lastID, c.pollTimeout)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	if len(messages) == 0 {
-		w.WriteHeader(http.StatusNoContent)
-		return
-	}
-
-	// تبدیل پیام‌ها به فرمت مورد نظر کلاینت
-	response := make([]map[string]interface{}, len(messages))
-	for i, msg := range messages {
-		response[i] = msg.ToClientFormat()
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
-}
+// internal/controllers/poll_controller.go
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"secure-chat-backend/internal/logging"
+	"secure-chat-backend/internal/middleware"
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/services"
+)
+
+// PollController کنترلر long polling
+type PollController struct {
+	chatService *services.ChatService
+	authService *services.AuthService
+	tunables    *services.Tunables // poll timeout
+}
+
+// NewPollController سازنده
+func NewPollController(chatService *services.ChatService, authService *services.AuthService, tunables *services.Tunables) *PollController {
+	return &PollController{
+		chatService: chatService,
+		authService: authService,
+		tunables:    tunables,
+	}
+}
+
+// Handle پردازش درخواست long polling
+func (c *PollController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token := middleware.BearerToken(r)
+	cursor, ok := readCursor(r.URL.Query(), r.URL.Query().Get("last_id"))
+	if !ok {
+		http.Error(w, "Invalid last_seq", http.StatusBadRequest)
+		return
+	}
+
+	room, ok := resolveRoom(r.URL.Query().Get("room"))
+	if !ok {
+		http.Error(w, "Invalid room name", http.StatusBadRequest)
+		return
+	}
+
+	session, ok := c.authService.ValidateReader(token, room)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	logSession(r, session)
+
+	batch, err := c.chatService.WaitForMessages(r.Context(), models.View{Room: room, User: session.Username, Reactions: protocolOf(r) >= models.ProtocolV2}, cursor, time.Duration(c.tunables.Get().PollTimeout))
+	if err != nil && r.Context().Err() != nil {
+		// کلاینت قطع شده — کسی برای دریافت پاسخ نیست
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(headerSeq, strconv.FormatUint(batch.Through, 10))
+	if batch.Gap {
+		w.Header().Set(headerGap, "1")
+	}
+	messages := batch.Messages
+	if len(messages) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// تبدیل پیام‌ها به فرمت مورد نظر کلاینت
+	protocol := protocolOf(r)
+	response := make([]interface{}, len(messages))
+	ids := make([]string, len(messages))
+	for i, msg := range messages {
+		response[i] = clientMessage(msg, protocol)
+		ids[i] = msg.ID
+	}
+	logging.AddAttrs(r.Context(), slog.String("room", room), slog.Any("delivered", ids))
+
+	writeEncoded(w, r, http.StatusOK, response)
+}
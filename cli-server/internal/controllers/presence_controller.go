@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"secure-chat-backend/internal/middleware"
+	"secure-chat-backend/internal/services"
+)
+
+// PresenceController lists who is online right now.
+type PresenceController struct {
+	authService *services.AuthService
+}
+
+// PresenceResponse is the /api/presence body.
+type PresenceResponse struct {
+	Users []services.OnlineUser `json:"users"`
+	Count int                   `json:"count"`
+	Time  string                `json:"time"`
+}
+
+func NewPresenceController(authService *services.AuthService) *PresenceController {
+	return &PresenceController{authService: authService}
+}
+
+// Handle answers GET /api/presence — the request itself counts as
+// activity, so the caller is always part of the list.
+func (c *PresenceController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := c.authService.ValidateSession(middleware.BearerToken(r))
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	logSession(r, session)
+
+	users := c.authService.OnlineUsers()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PresenceResponse{
+		Users: users,
+		Count: len(users),
+		Time:  time.Now().Format(time.RFC3339),
+	})
+}
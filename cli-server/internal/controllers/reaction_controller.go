@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"secure-chat-backend/internal/logging"
+	"secure-chat-backend/internal/services"
+)
+
+// ReactionController adds and takes back reactions to messages under
+// /api/messages/<id>/react.
+type ReactionController struct {
+	chatService *services.ChatService
+	authService *services.AuthService
+}
+
+// ReactRequest is the /api/messages/<id>/react body.
+type ReactRequest struct {
+	Token string `json:"token"`
+	Emoji string `json:"emoji"`
+}
+
+func NewReactionController(chatService *services.ChatService, authService *services.AuthService) *ReactionController {
+	return &ReactionController{
+		chatService: chatService,
+		authService: authService,
+	}
+}
+
+// Handle serves both directions and answers with the message's reactions,
+// a models.Reaction:
+//
+//	POST   /api/messages/<id>/react {"emoji": "👍"}  — add a reaction
+//	DELETE /api/messages/<id>/react {"emoji": "👍"}  — take it back
+func (c *ReactionController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/messages/"), "/react")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req ReactRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	session, ok := c.authService.ValidateSession(sessionToken(r, req.Token))
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	logSession(r, session)
+	logging.AddAttrs(r.Context(), slog.String("message_id", id))
+
+	if !c.authService.CheckRateLimit(session.ClientID) {
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if err := services.ValidateEmoji(req.Emoji); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	reaction, err := c.chatService.React(session.Username, id, req.Emoji, r.Method == http.MethodDelete)
+	switch {
+	case errors.Is(err, services.ErrUnknownMessage):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, services.ErrReadOnly):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.authService.MarkActive(session.Username)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reaction)
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"secure-chat-backend/internal/logging"
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/services"
+)
+
+type RegisterController struct {
+	authenticator    services.Authenticator
+	authService      *services.AuthService
+	userService      *services.UserService
+	minClientVersion string
+}
+
+type RegisterRequest struct {
+	AccessKey string `json:"access_key"`
+	ClientID  string `json:"client_id"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	// IdentityKey optionally publishes the client's Ed25519 public key,
+	// DHKey and DHKeySig its X25519 key for direct messages.
+	IdentityKey string `json:"identity_key,omitempty"`
+	DHKey       string `json:"dh_key,omitempty"`
+	DHKeySig    string `json:"dh_key_sig,omitempty"`
+	// Protocol is the newest message format version the client speaks;
+	// absent means 1.
+	Protocol int `json:"protocol,omitempty"`
+}
+
+type RegisterResponse struct {
+	Status    string `json:"status"`
+	Username  string `json:"username"`
+	Token     string `json:"token"`
+	ExpiresAt string `json:"expires_at"`
+	Time      string `json:"time"`
+	// SigningKey signs the requests made with Token (base64url, see
+	// middleware.SigningMiddleware).
+	SigningKey string `json:"signing_key"`
+	// Protocol is the message format version to use, see
+	// models.NegotiateProtocol.
+	Protocol int `json:"protocol"`
+}
+
+func NewRegisterController(authenticator services.Authenticator, authService *services.AuthService, userService *services.UserService, minClientVersion string) *RegisterController {
+	return &RegisterController{
+		authenticator:    authenticator,
+		authService:      authService,
+		userService:      userService,
+		minClientVersion: minClientVersion,
+	}
+}
+
+func (c *RegisterController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RegisterRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAuthBody)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	logging.AddAttrs(r.Context(),
+		slog.String("client_id", req.ClientID),
+		slog.String("user", req.Username))
+
+	attempt := services.LoginAttempt{AccessKey: req.AccessKey, ClientID: req.ClientID, Username: req.Username, Password: req.Password}
+	if err := c.authenticator.Admit(attempt); err != nil {
+		if errors.Is(err, services.ErrBanned) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !c.authService.CheckRateLimit(req.ClientID) {
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	if clientTooOld(w, r, c.minClientVersion) {
+		return
+	}
+
+	keys := services.PublicKeys{IdentityKey: req.IdentityKey, DHKey: req.DHKey, DHKeySig: req.DHKeySig}
+	if keys != (services.PublicKeys{}) {
+		if err := services.ValidatePublicKeys(keys); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	username, err := c.authenticator.Register(attempt)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUserExists):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, services.ErrInvalidUsername), errors.Is(err, services.ErrWeakPassword):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, services.ErrRegistrationClosed), errors.Is(err, services.ErrDeviceLogin):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(w, "Could not create account", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if keys != (services.PublicKeys{}) {
+		if err := c.userService.SetKeys(username, keys); err != nil {
+			http.Error(w, "Could not store identity key", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	token, expiresAt, err := c.authService.IssueToken(username, req.ClientID)
+	if err != nil {
+		http.Error(w, "Could not issue session token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(RegisterResponse{
+		Status:     "registered",
+		Username:   username,
+		Token:      token,
+		ExpiresAt:  expiresAt.Format(time.RFC3339),
+		Time:       time.Now().Format(time.RFC3339),
+		SigningKey: c.authService.EncodedSigningKey(token),
+		Protocol:   models.NegotiateProtocol(req.Protocol),
+	})
+}
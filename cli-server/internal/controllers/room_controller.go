@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"secure-chat-backend/internal/middleware"
+	"secure-chat-backend/internal/services"
+)
+
+// RoomController serves per-room information under /api/rooms/<room>/.
+type RoomController struct {
+	rooms       *services.RoomService
+	authService *services.AuthService
+}
+
+func NewRoomController(rooms *services.RoomService, authService *services.AuthService) *RoomController {
+	return &RoomController{
+		rooms:       rooms,
+		authService: authService,
+	}
+}
+
+// Handle answers GET /api/rooms/<room>/stats with the room's
+// services.RoomStats. Observer tokens for the room are accepted, so a
+// dashboard can show it.
+func (c *RoomController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/api/rooms/"), "/stats")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	room, ok := resolveRoom(name)
+	if !ok {
+		http.Error(w, "Invalid room name", http.StatusBadRequest)
+		return
+	}
+
+	session, ok := c.authService.ValidateReader(middleware.BearerToken(r), room)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	logSession(r, session)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.rooms.Stats(room))
+}
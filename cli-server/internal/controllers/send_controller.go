@@ -1,85 +1,340 @@
-// internal/controllers/send_controller.go
-package controllers
-
-import (
-	"encoding/json"
-	"net/http"
-	"time"
-
-	"secure-chat-backend/internal/services"
-)
-
-// SendController کنترلر ارسال پیام
-type SendController struct {
-	chatService *services.ChatService
-	authService *services.AuthService
-}
-
-// SendRequest ساختار درخواست با فرمت جدید
-type SendRequest struct {
-	AccessKey string `json:"access_key"`
-	ClientID  string `json:"client_id"`
-	Username  string `json:"username"` // مثلا "script_kiddie"
-	Content   string `json:"content"`  // متن پیام
-	Color     string `json:"color"`    // مثل "[yellow]"
-}
-
-// SendResponse ساختار پاسخ
-type SendResponse struct {
-	Status string `json:"status"`
-	ID     string `json:"id"`
-	Time   string `json:"time"`
-}
-
-// NewSendController سازنده
-func NewSendController(chatService *services.ChatService, authService *services.AuthService) *SendController {
-	return &SendController{
-		chatService: chatService,
-		authService: authService,
-	}
-}
-
-// Handle پردازش درخواست ارسال
-func (c *SendController) Handle(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	var req SendRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// اعتبارسنجی
-	if !c.authService.ValidateAccess(req.AccessKey, req.ClientID) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
-	if !c.authService.CheckRateLimit(req.ClientID) {
-		http.Error(w, "Too many requests", http.StatusTooManyRequests)
-		return
-	}
-
-	// تنظیم رنگ پیش‌فرض اگر خالی بود
-	if req.Color == "" {
-		req.Color = "[white]"
-	}
-
-	// ارسال پیام
-	msg, err := c.chatService.SendMessage(req.Username, req.Content, req.Color, req.ClientID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(SendResponse{
-		Status: "sent",
-		ID:     msg.ID,
-		Time:   time.Now().Format(time.RFC3339),
-	})
-}
+// internal/controllers/send_controller.go
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"secure-chat-backend/internal/logging"
+	"secure-chat-backend/internal/metrics"
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/services"
+)
+
+// SendController کنترلر ارسال پیام
+type SendController struct {
+	chatService *services.ChatService
+	authService *services.AuthService
+	userService *services.UserService
+	traffic     *metrics.Traffic
+}
+
+// SendRequest ساختار درخواست با فرمت جدید
+type SendRequest struct {
+	Token    string          `json:"token"`
+	ClientID string          `json:"client_id"`
+	Username string          `json:"username"` // مثلا "script_kiddie"
+	Content  string          `json:"content"`  // متن پیام
+	Color    string          `json:"color"`    // مثل "[yellow]"
+	Room     string          `json:"room"`     // empty = lobby
+	To       string          `json:"to"`       // direct message recipient, empty = the room
+	Sig      string          `json:"sig"`      // sender's signature over the message, optional
+	Fwd      *models.Forward `json:"fwd"`      // provenance of a forwarded message, optional
+	Type     string          `json:"type"`     // "" or "action" (/me), optional
+	ReplyTo  string          `json:"reply_to"` // ID of the message answered, optional
+	XPost    []CrossPostCopy `json:"xpost"`    // copies for other rooms, optional
+}
+
+// CrossPostCopy is the copy of a cross-posted message for one more room,
+// sealed and signed by the client for that room.
+type CrossPostCopy struct {
+	Room    string `json:"room"`
+	Content string `json:"content"`
+	Sig     string `json:"sig"`
+}
+
+// SendResponse ساختار پاسخ
+type SendResponse struct {
+	Status string `json:"status"`
+	ID     string `json:"id"`
+	Time   string `json:"time"`
+}
+
+// MaxBatchSize is the most messages one /api/send/batch request may carry.
+const MaxBatchSize = 20
+
+// maxBatchBody caps the size of a /api/send/batch body.
+const maxBatchBody = 1 << 20
+
+// SendBatchRequest is the /api/send/batch body. A message without a room
+// goes to the batch's room.
+type SendBatchRequest struct {
+	Token    string        `json:"token"`
+	Room     string        `json:"room"`
+	Messages []SendRequest `json:"messages"`
+}
+
+// SendBatchResponse lists the IDs of the messages sent, in order. Sending
+// stops at the first message that is refused; Failed says which and why,
+// and the messages after it are not sent.
+type SendBatchResponse struct {
+	Status string       `json:"status"` // "sent", or "partial" when Failed is set
+	IDs    []string     `json:"ids"`
+	Failed *sendFailure `json:"failed,omitempty"`
+	Time   string       `json:"time"`
+}
+
+// NewSendController سازنده
+func NewSendController(chatService *services.ChatService, authService *services.AuthService, userService *services.UserService, traffic *metrics.Traffic) *SendController {
+	return &SendController{
+		chatService: chatService,
+		authService: authService,
+		userService: userService,
+		traffic:     traffic,
+	}
+}
+
+// Handle پردازش درخواست ارسال
+func (c *SendController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SendRequest
+	if err := decodeBody(r, r.Body, &req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// اعتبارسنجی
+	session, ok := c.authService.ValidateSession(sessionToken(r, req.Token))
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	logSession(r, session)
+
+	if !c.authService.CheckRateLimit(session.ClientID) {
+		c.traffic.RateLimited.Inc()
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	msg, failure := c.post(session, req)
+	if failure != nil {
+		failure.write(w)
+		return
+	}
+	logging.AddAttrs(r.Context(), slog.String("room", msg.Room), slog.String("message_id", msg.ID))
+
+	writeEncoded(w, r, http.StatusOK, SendResponse{
+		Status: "sent",
+		ID:     msg.ID,
+		Time:   time.Now().Format(time.RFC3339),
+	})
+}
+
+// HandleBatch sends several messages in one request, as a client does when
+// text is pasted line by line. The batch counts as one request against the
+// client's rate limit; slow mode still applies to each message.
+func (c *SendController) HandleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SendBatchRequest
+	if err := decodeBody(r, http.MaxBytesReader(w, r.Body, maxBatchBody), &req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Messages) == 0 || len(req.Messages) > MaxBatchSize {
+		http.Error(w, fmt.Sprintf("A batch carries 1 to %d messages", MaxBatchSize), http.StatusBadRequest)
+		return
+	}
+
+	session, ok := c.authService.ValidateSession(sessionToken(r, req.Token))
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	logSession(r, session)
+
+	if !c.authService.CheckRateLimit(session.ClientID) {
+		c.traffic.RateLimited.Inc()
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	resp := SendBatchResponse{Status: "sent", IDs: make([]string, 0, len(req.Messages))}
+	for _, m := range req.Messages {
+		if m.Room == "" {
+			m.Room = req.Room
+		}
+		msg, failure := c.post(session, m)
+		if failure != nil {
+			resp.Status = "partial"
+			resp.Failed = failure
+			break
+		}
+		resp.IDs = append(resp.IDs, msg.ID)
+	}
+	logging.AddAttrs(r.Context(), slog.Int("batch", len(req.Messages)), slog.Int("sent", len(resp.IDs)))
+
+	resp.Time = time.Now().Format(time.RFC3339)
+	writeEncoded(w, r, http.StatusOK, resp)
+}
+
+// sendFailure is why a message was not sent: the status and error /api/send
+// answers with, and for slow mode the seconds to wait.
+type sendFailure struct {
+	Status     int    `json:"status"`
+	Error      string `json:"error"`
+	RetryAfter int    `json:"retry_after,omitempty"`
+}
+
+func (f *sendFailure) write(w http.ResponseWriter) {
+	if f.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(f.RetryAfter))
+	}
+	http.Error(w, f.Error, f.Status)
+}
+
+func refuse(status int, msg string) *sendFailure {
+	return &sendFailure{Status: status, Error: msg}
+}
+
+// post checks one message from session and sends it.
+func (c *SendController) post(session *services.Session, req SendRequest) (*models.Message, *sendFailure) {
+	// The session decides who is speaking; a mismatching username is an
+	// impersonation attempt, an empty one just means "me".
+	if req.Username == "" {
+		req.Username = session.Username
+	}
+	if req.Username != session.Username {
+		return nil, refuse(http.StatusForbidden, "Username does not match session")
+	}
+
+	room, ok := resolveRoom(req.Room)
+	if !ok {
+		return nil, refuse(http.StatusBadRequest, "Invalid room name")
+	}
+
+	// A direct message reaches only its recipient (and the sender's other
+	// sessions); its content is sealed to the recipient's X25519 key.
+	if req.To != "" {
+		if req.To == session.Username {
+			return nil, refuse(http.StatusBadRequest, "Cannot send a direct message to yourself")
+		}
+		if !c.userService.Exists(req.To) {
+			return nil, refuse(http.StatusNotFound, services.ErrUnknownUser.Error())
+		}
+	}
+
+	if req.Sig != "" {
+		if err := services.ValidateSignature(req.Sig); err != nil {
+			return nil, refuse(http.StatusBadRequest, err.Error())
+		}
+	}
+	if req.Fwd != nil {
+		if err := services.ValidateForward(req.Fwd); err != nil {
+			return nil, refuse(http.StatusBadRequest, err.Error())
+		}
+	}
+	if err := services.ValidateMessageType(req.Type, req.Content); err != nil {
+		return nil, refuse(http.StatusBadRequest, err.Error())
+	}
+	if req.ReplyTo != "" {
+		if err := services.ValidateReplyTo(req.ReplyTo); err != nil {
+			return nil, refuse(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	// تنظیم رنگ پیش‌فرض اگر خالی بود
+	if req.Color == "" {
+		req.Color = "[white]"
+	}
+
+	if len(req.XPost) > 0 {
+		return c.crossPost(session, room, req)
+	}
+
+	// ارسال پیام
+	msg, err := c.chatService.SendMessage(services.OutgoingMessage{
+		Username: req.Username,
+		Content:  req.Content,
+		Color:    req.Color,
+		Room:     room,
+		To:       req.To,
+		Sig:      req.Sig,
+		Fwd:      req.Fwd,
+		Type:     req.Type,
+		ReplyTo:  req.ReplyTo,
+	})
+	if err != nil {
+		return nil, sendRefusal(err)
+	}
+
+	c.traffic.ObserveSend(session.ClientID, len(req.Content))
+	c.authService.MarkSent(session)
+	return msg, nil
+}
+
+// crossPost sends req to room and to the rooms of req.XPost as linked
+// copies, and returns the copy in room.
+func (c *SendController) crossPost(session *services.Session, room string, req SendRequest) (*models.Message, *sendFailure) {
+	if req.To != "" || req.Fwd != nil || req.ReplyTo != "" {
+		return nil, refuse(http.StatusBadRequest, "Direct messages, forwards and replies can't be cross-posted")
+	}
+	copies := []services.CrossPostCopy{{Room: room, Content: req.Content, Sig: req.Sig}}
+	size := len(req.Content)
+	for _, x := range req.XPost {
+		xroom, ok := resolveRoom(x.Room)
+		if !ok || x.Room == "" {
+			return nil, refuse(http.StatusBadRequest, "Invalid room name")
+		}
+		for _, other := range copies {
+			if other.Room == xroom {
+				return nil, refuse(http.StatusBadRequest, services.ErrBadCrossPost.Error())
+			}
+		}
+		if x.Sig != "" {
+			if err := services.ValidateSignature(x.Sig); err != nil {
+				return nil, refuse(http.StatusBadRequest, err.Error())
+			}
+		}
+		if err := services.ValidateMessageType(req.Type, x.Content); err != nil {
+			return nil, refuse(http.StatusBadRequest, err.Error())
+		}
+		copies = append(copies, services.CrossPostCopy{Room: xroom, Content: x.Content, Sig: x.Sig})
+		size += len(x.Content)
+	}
+
+	msgs, err := c.chatService.CrossPost(req.Username, req.Color, req.Type, copies)
+	if err != nil {
+		return nil, sendRefusal(err)
+	}
+
+	c.traffic.ObserveSend(session.ClientID, size)
+	c.authService.MarkSent(session)
+	return msgs[0], nil
+}
+
+// sendRefusal is the answer to a message ChatService refused with err.
+func sendRefusal(err error) *sendFailure {
+	var slow *services.SlowModeError
+	var muted *services.MutedError
+	switch {
+	case errors.Is(err, services.ErrReadOnly), errors.Is(err, services.ErrMessageBlocked), errors.Is(err, services.ErrRepeatedMessage):
+		return refuse(http.StatusForbidden, err.Error())
+	case errors.As(err, &muted):
+		// 403, not 429: clients retry a 429, and a mute is not worth
+		// retrying for.
+		f := refuse(http.StatusForbidden, err.Error())
+		f.RetryAfter = int(math.Ceil(muted.Wait.Seconds()))
+		return f
+	case errors.Is(err, services.ErrBadCrossPost):
+		return refuse(http.StatusBadRequest, err.Error())
+	case errors.As(err, &slow):
+		f := refuse(http.StatusTooManyRequests, err.Error())
+		f.RetryAfter = int(math.Ceil(slow.Wait.Seconds()))
+		return f
+	}
+	return refuse(http.StatusInternalServerError, err.Error())
+}
@@ -1,36 +1,216 @@
-package controllers
-
-import (
-	"encoding/json"
-	"net/http"
-
-	"secure-chat-backend/internal/services"
-)
-
-type StatsController struct {
-	chatService *services.ChatService
-	authService *services.AuthService
-}
-
-func NewStatsController(chatService *services.ChatService, authService *services.AuthService) *StatsController {
-	return &StatsController{
-		chatService: chatService,
-		authService: authService,
-	}
-}
-
-func (c *StatsController) Handle(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	stats := map[string]interface{}{
-		"chat_stats":     c.chatService.GetStats(),
-		"active_clients": c.authService.GetClientCount(),
-		"status":         "running",
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(stats)
-}
+package controllers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"secure-chat-backend/internal/metrics"
+	"secure-chat-backend/internal/services"
+)
+
+// AccessKeyHeader carries the access key on requests that need it but have
+// no session, like GET /api/stats. "Authorization: Bearer <key>" works too,
+// for scrapers that can only set that.
+const AccessKeyHeader = "X-TTC-Access-Key"
+
+// StatsController answers GET /api/stats for whoever has the access key or
+// the admin token: as JSON, or in the Prometheus text format with
+// ?format=prometheus or an Accept header that asks for text/plain.
+// ?detail=full adds, for the admin token only, every client and the
+// requests the rate limit refused.
+type StatsController struct {
+	chatService *services.ChatService
+	authService *services.AuthService
+	traffic     *metrics.Traffic
+	adminToken  string
+	started     time.Time
+}
+
+// ClientStats is one client in a ?detail=full answer.
+type ClientStats struct {
+	ID          string    `json:"id"`
+	Username    string    `json:"username,omitempty"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	Requests    int64     `json:"requests"`
+	Sent        int64     `json:"sent"`
+	RateLimited int64     `json:"rate_limited"`
+}
+
+// statsSnapshot is one reading of everything /api/stats reports; clients
+// is nil unless the full detail was asked for.
+type statsSnapshot struct {
+	chat        map[string]interface{}
+	byUser      map[string]int
+	active      int
+	uptime      time.Duration
+	clients     []ClientStats
+	rateLimited uint64
+}
+
+func NewStatsController(chatService *services.ChatService, authService *services.AuthService, traffic *metrics.Traffic, adminToken string, started time.Time) *StatsController {
+	return &StatsController{
+		chatService: chatService,
+		authService: authService,
+		traffic:     traffic,
+		adminToken:  adminToken,
+		started:     started,
+	}
+}
+
+func (c *StatsController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	admin := c.isAdmin(r)
+	if !admin && !c.authService.CheckAccessKey(accessKey(r)) {
+		http.Error(w, "Unauthorized: send the access key in the "+AccessKeyHeader+" header, or the admin token as a Bearer token", http.StatusUnauthorized)
+		return
+	}
+	full := false
+	switch r.URL.Query().Get("detail") {
+	case "", "basic":
+	case "full":
+		if !admin {
+			http.Error(w, "detail=full needs the admin token", http.StatusForbidden)
+			return
+		}
+		full = true
+	default:
+		http.Error(w, `detail is "basic" or "full"`, http.StatusBadRequest)
+		return
+	}
+
+	snap := statsSnapshot{
+		chat:   c.chatService.GetStats(),
+		byUser: c.chatService.MessagesByUser(),
+		active: c.authService.GetClientCount(),
+		uptime: time.Since(c.started),
+	}
+	if full {
+		for _, client := range c.authService.Clients() {
+			snap.clients = append(snap.clients, ClientStats{
+				ID:          client.ID,
+				Username:    client.Username,
+				FirstSeen:   client.FirstSeen,
+				LastSeen:    client.LastSeen,
+				Requests:    client.MessageCount,
+				Sent:        client.Sent,
+				RateLimited: client.RateLimited,
+			})
+		}
+		snap.rateLimited = c.traffic.RateLimited.Value()
+	}
+
+	if wantsPrometheus(r) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		snap.writePrometheus(w, full)
+		return
+	}
+
+	stats := map[string]interface{}{
+		"chat_stats":       snap.chat,
+		"messages_by_user": snap.byUser,
+		"active_clients":   snap.active,
+		"uptime_seconds":   int64(snap.uptime.Seconds()),
+		"started_at":       c.started.UTC().Format(time.RFC3339),
+		"status":           "running",
+	}
+	if full {
+		stats["clients"] = append([]ClientStats{}, snap.clients...)
+		stats["rate_limited_total"] = snap.rateLimited
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// isAdmin reports whether r carries the admin token as a Bearer token.
+func (c *StatsController) isAdmin(r *http.Request) bool {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && c.adminToken != "" && subtle.ConstantTimeCompare([]byte(given), []byte(c.adminToken)) == 1
+}
+
+// accessKey returns the access key r presents, "" for none.
+func accessKey(r *http.Request) string {
+	if key := r.Header.Get(AccessKeyHeader); key != "" {
+		return key
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// wantsPrometheus reports whether r asks for the Prometheus text format
+// rather than JSON.
+func wantsPrometheus(r *http.Request) bool {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format == "prometheus"
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/plain") && !strings.Contains(accept, "application/json")
+}
+
+// statsGauges are the chat_stats entries written as Prometheus gauges, in
+// order.
+var statsGauges = []struct{ key, name, help string }{
+	{"total_messages", "ttc_buffer_messages", "Messages the relay's buffer holds."},
+	{"buffer_capacity", "ttc_buffer_capacity", "Messages the relay's buffer holds at most."},
+	{"messages_per_minute", "ttc_messages_per_minute", "Messages users sent in the last 60 seconds."},
+	{"waiting_clients", "ttc_waiting_clients", "Clients waiting in a long poll or stream."},
+	{"max_waiters", "ttc_max_waiting_clients", "Clients that may wait at once."},
+	{"muted_users", "ttc_muted_users", "Users muted for flooding."},
+}
+
+// labelEscaper escapes a Prometheus label value.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writePrometheus writes snap in the Prometheus text format; full adds the
+// per-client counters.
+func (snap *statsSnapshot) writePrometheus(w io.Writer, full bool) {
+	gauge := func(name, help string, v interface{}) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, v)
+	}
+	gauge("ttc_uptime_seconds", "Seconds since the relay started.", int64(snap.uptime.Seconds()))
+	gauge("ttc_active_clients", "Clients the relay knows.", snap.active)
+	for _, g := range statsGauges {
+		if v, ok := snap.chat[g.key]; ok {
+			gauge(g.name, g.help, v)
+		}
+	}
+
+	users := make([]string, 0, len(snap.byUser))
+	for u := range snap.byUser {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	fmt.Fprint(w, "# HELP ttc_user_messages_total Messages each user sent since the relay started.\n# TYPE ttc_user_messages_total counter\n")
+	for _, u := range users {
+		fmt.Fprintf(w, "ttc_user_messages_total{username=\"%s\"} %d\n", labelEscaper.Replace(u), snap.byUser[u])
+	}
+	if !full {
+		return
+	}
+
+	fmt.Fprintf(w, "# HELP ttc_rate_limited_total Requests the per-client rate limit refused.\n# TYPE ttc_rate_limited_total counter\nttc_rate_limited_total %d\n", snap.rateLimited)
+	for _, m := range []struct {
+		name, help string
+		value      func(ClientStats) int64
+	}{
+		{"ttc_client_requests_total", "Requests each known client made.", func(cs ClientStats) int64 { return cs.Requests }},
+		{"ttc_client_rate_limited_total", "Requests of each known client the rate limit refused.", func(cs ClientStats) int64 { return cs.RateLimited }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", m.name, m.help, m.name)
+		for _, cs := range snap.clients {
+			fmt.Fprintf(w, "%s{client_id=\"%s\",username=\"%s\"} %d\n", m.name, labelEscaper.Replace(cs.ID), labelEscaper.Replace(cs.Username), m.value(cs))
+		}
+	}
+}
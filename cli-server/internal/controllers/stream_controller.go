@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"secure-chat-backend/internal/middleware"
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/services"
+)
+
+// StreamController pushes new messages as Server-Sent Events over one
+// long-lived response — the push alternative to long polling. It passes
+// through most proxies and has no gap between polls. The same events also
+// go over a WebSocket, for clients and proxies that prefer one.
+type StreamController struct {
+	chatService *services.ChatService
+	authService *services.AuthService
+	tunables    *services.Tunables // keepalive interval = poll timeout
+}
+
+// streamWriteSlack is how far past the next keepalive the write deadline
+// is pushed, so the server's WriteTimeout doesn't end a healthy stream.
+const streamWriteSlack = 30 * time.Second
+
+func NewStreamController(chatService *services.ChatService, authService *services.AuthService, tunables *services.Tunables) *StreamController {
+	return &StreamController{
+		chatService: chatService,
+		authService: authService,
+		tunables:    tunables,
+	}
+}
+
+// Handle answers GET /api/stream?room=...&last_id=<id>, and the
+// same under /api/v2. Every message is a "message" event whose data is the
+// message in poll format and whose id is the message ID, so a reconnecting
+// EventSource resumes through Last-Event-ID. A "gap" event, whose data is
+// the sequence number read up to, says messages after the cursor expired
+// before they could be sent. A comment line is sent whenever a poll timeout passes
+// quietly, to keep proxies from closing the connection. The token is checked
+// again after every wait; once it is no longer valid an "error" event ends
+// the stream.
+func (c *StreamController) Handle(w http.ResponseWriter, r *http.Request) {
+	req, ok := c.accept(w, r)
+	if !ok {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx: pass events through unbuffered
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	c.run(r.Context(), req, sseSink{w: w, rc: rc})
+}
+
+// HandleWebSocket answers GET /api/ws, and the same under /api/v2, with
+// the stream Handle sends over a WebSocket. Each event is a text frame
+// holding {"event": ..., "data": ...}: a "message" with the message in poll
+// format, a "gap" with the sequence number read up to, or an "error" with
+// its reason, after which the relay closes the connection. A ping stands
+// in for the keepalive comment, and a client that stops answering pings
+// is dropped.
+func (c *StreamController) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	req, ok := c.accept(w, r)
+	if !ok {
+		return
+	}
+	ws := upgradeWebSocket(w, r)
+	if ws == nil {
+		return
+	}
+	defer ws.close()
+
+	// The hijacked connection is no longer watched by net/http: the reader
+	// ends the stream when the client closes it or goes quiet.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		for {
+			ws.setReadDeadline(time.Duration(c.tunables.Get().PollTimeout) + streamWriteSlack)
+			op, payload, err := ws.readFrame()
+			if err != nil {
+				return
+			}
+			switch op {
+			case wsPing:
+				ws.send(wsPong, payload)
+			case wsClose:
+				return
+			}
+		}
+	}()
+	c.run(ctx, req, wsSink{ws})
+}
+
+// streamRequest is a stream request that passed accept.
+type streamRequest struct {
+	token    string
+	view     models.View
+	cursor   models.Cursor
+	protocol int
+}
+
+// accept checks a stream request and answers it itself when it fails.
+func (c *StreamController) accept(w http.ResponseWriter, r *http.Request) (streamRequest, bool) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return streamRequest{}, false
+	}
+
+	query := r.URL.Query()
+	room, ok := resolveRoom(query.Get("room"))
+	if !ok {
+		http.Error(w, "Invalid room name", http.StatusBadRequest)
+		return streamRequest{}, false
+	}
+	token := middleware.BearerToken(r)
+	session, ok := c.authService.ValidateReader(token, room)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return streamRequest{}, false
+	}
+	logSession(r, session)
+
+	lastID := query.Get("last_id")
+	if lastID == "" {
+		lastID = r.Header.Get("Last-Event-ID")
+	}
+	cursor, ok := readCursor(query, lastID)
+	if !ok {
+		http.Error(w, "Invalid last_seq", http.StatusBadRequest)
+		return streamRequest{}, false
+	}
+
+	protocol := protocolOf(r)
+	return streamRequest{
+		token:    token,
+		view:     models.View{Room: room, User: session.Username, Reactions: protocol >= models.ProtocolV2},
+		cursor:   cursor,
+		protocol: protocol,
+	}, true
+}
+
+// streamSink is where a stream's events go.
+type streamSink interface {
+	waiting(timeout time.Duration) // a wait of up to timeout begins
+	message(id string, data []byte)
+	gap(through uint64)
+	keepalive()
+	fail(reason string) // the last event
+	flush() error
+}
+
+// run sends req's room to sink until ctx is done, the token is no longer
+// valid or a write fails.
+func (c *StreamController) run(ctx context.Context, req streamRequest, sink streamSink) {
+	cursor := req.cursor
+	for {
+		timeout := time.Duration(c.tunables.Get().PollTimeout)
+		sink.waiting(timeout)
+
+		batch, err := c.chatService.WaitForMessages(ctx, req.view, cursor, timeout)
+		if ctx.Err() != nil {
+			return // client went away
+		}
+		if err != nil {
+			sink.fail(err.Error())
+			sink.flush()
+			return
+		}
+		if _, ok := c.authService.ValidateReader(req.token, req.view.Room); !ok {
+			sink.fail("Unauthorized")
+			sink.flush()
+			return
+		}
+
+		if batch.Gap {
+			sink.gap(batch.Through)
+		}
+		if len(batch.Messages) == 0 && !batch.Gap {
+			sink.keepalive()
+		}
+		cursor.Seq = batch.Through
+		for _, msg := range batch.Messages {
+			data, err := json.Marshal(clientMessage(msg, req.protocol))
+			if err != nil {
+				continue
+			}
+			sink.message(msg.ID, data)
+			cursor.ID = msg.ID
+		}
+		if err := sink.flush(); err != nil {
+			return
+		}
+	}
+}
+
+// sseSink writes Server-Sent Events.
+type sseSink struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (s sseSink) waiting(timeout time.Duration) {
+	s.rc.SetWriteDeadline(time.Now().Add(timeout + streamWriteSlack))
+}
+
+func (s sseSink) message(id string, data []byte) {
+	fmt.Fprintf(s.w, "id: %s\nevent: message\ndata: %s\n\n", id, data)
+}
+
+func (s sseSink) gap(through uint64) { fmt.Fprintf(s.w, "event: gap\ndata: %d\n\n", through) }
+func (s sseSink) keepalive()         { fmt.Fprint(s.w, ": keepalive\n\n") }
+func (s sseSink) fail(reason string) { fmt.Fprintf(s.w, "event: error\ndata: %s\n\n", reason) }
+func (s sseSink) flush() error       { return s.rc.Flush() }
@@ -0,0 +1,84 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"secure-chat-backend/internal/middleware"
+	"secure-chat-backend/internal/services"
+)
+
+// TypingController carries "user is typing…" events between clients.
+type TypingController struct {
+	chatService *services.ChatService
+	authService *services.AuthService
+}
+
+// TypingRequest is the POST /api/typing body.
+type TypingRequest struct {
+	Token string `json:"token"`
+	Room  string `json:"room"`
+}
+
+// TypingResponse lists who else is typing in a room.
+type TypingResponse struct {
+	Room   string   `json:"room"`
+	Typing []string `json:"typing"`
+}
+
+func NewTypingController(chatService *services.ChatService, authService *services.AuthService) *TypingController {
+	return &TypingController{
+		chatService: chatService,
+		authService: authService,
+	}
+}
+
+// Handle serves both directions:
+//
+//	POST /api/typing {"token": ..., "room": ...}  — I am typing
+//	GET  /api/typing?room=...                     — who else is typing
+func (c *TypingController) Handle(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var req TypingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		session, ok := c.authService.ValidateSession(sessionToken(r, req.Token))
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		logSession(r, session)
+		room, ok := resolveRoom(req.Room)
+		if !ok {
+			http.Error(w, "Invalid room name", http.StatusBadRequest)
+			return
+		}
+		c.chatService.SetTyping(room, session.Username)
+		c.authService.MarkActive(session.Username)
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodGet:
+		session, ok := c.authService.ValidateSession(middleware.BearerToken(r))
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		logSession(r, session)
+		room, ok := resolveRoom(r.URL.Query().Get("room"))
+		if !ok {
+			http.Error(w, "Invalid room name", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TypingResponse{
+			Room:   room,
+			Typing: c.chatService.Typing(room, session.Username),
+		})
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
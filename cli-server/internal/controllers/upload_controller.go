@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"secure-chat-backend/internal/logging"
+	"secure-chat-backend/internal/middleware"
+	"secure-chat-backend/internal/services"
+)
+
+// UploadController accepts files for distribution. Every upload is scanned
+// (see services.Scanner) before /api/files hands it out.
+type UploadController struct {
+	uploadService *services.UploadService
+	authService   *services.AuthService
+}
+
+// UploadResponse is the /api/upload body.
+type UploadResponse struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	Size   int    `json:"size"`
+	SHA256 string `json:"sha256"`
+	// ExpiresAt is when the file stops being downloadable.
+	ExpiresAt string `json:"expires_at"`
+	// QuotaUsed and QuotaLimit are the uploader's stored bytes, this file
+	// included, and how many they may store.
+	QuotaUsed  int64  `json:"quota_used"`
+	QuotaLimit int64  `json:"quota_limit"`
+	Time       string `json:"time"`
+}
+
+func NewUploadController(uploadService *services.UploadService, authService *services.AuthService) *UploadController {
+	return &UploadController{
+		uploadService: uploadService,
+		authService:   authService,
+	}
+}
+
+// Handle answers POST /api/upload?name=... with the file as the
+// raw request body.
+func (c *UploadController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeFileError(w, http.StatusMethodNotAllowed, FileError{Code: "method_not_allowed", Message: "Method not allowed"})
+		return
+	}
+
+	query := r.URL.Query()
+	session, ok := c.authService.ValidateSession(middleware.BearerToken(r))
+	if !ok {
+		writeFileError(w, http.StatusUnauthorized, FileError{Code: "unauthorized", Message: "Unauthorized"})
+		return
+	}
+	logSession(r, session)
+
+	if !c.uploadService.Allow(session.Username) {
+		writeUploadError(w, c.uploadService, session.Username, services.ErrUploadRateLimit)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(c.uploadService.MaxSize())))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeUploadError(w, c.uploadService, session.Username, services.ErrUploadTooLarge)
+		return
+	case err != nil:
+		writeFileError(w, http.StatusBadRequest, FileError{Code: "bad_request", Message: "Invalid request body"})
+		return
+	}
+
+	upload, err := c.uploadService.Store(r.Context(), session.Username, query.Get("name"), r.Header.Get("Content-Type"), data)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return // کلاینت قطع شده
+		}
+		writeUploadError(w, c.uploadService, session.Username, err)
+		return
+	}
+	logging.AddAttrs(r.Context(), slog.String("upload_id", upload.ID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(UploadResponse{
+		ID:         upload.ID,
+		Name:       upload.Name,
+		Size:       upload.Size,
+		SHA256:     upload.SHA256,
+		ExpiresAt:  upload.ExpireAt.Format(time.RFC3339),
+		QuotaUsed:  c.uploadService.Usage(session.Username),
+		QuotaLimit: c.uploadService.Policy().UserQuota,
+		Time:       time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"secure-chat-backend/internal/logging"
+	"secure-chat-backend/internal/metrics"
+	"secure-chat-backend/internal/services"
+	"secure-chat-backend/internal/utils"
+)
+
+// WebhookPath is where webhooks post; the token follows it.
+const WebhookPath = "/api/webhook/"
+
+// maxWebhookBody caps the size of a webhook body. Alerting systems send a
+// lot besides the text, all of it ignored.
+const maxWebhookBody = 1 << 20
+
+// WebhookController lets systems that can't speak the client protocol —
+// CI servers, alerting — post into a room: a token issued with
+// POST /api/admin/webhooks is the URL, and the text arrives as a message from
+// the webhook's username (services.WebhookPrefix + its name).
+type WebhookController struct {
+	chatService *services.ChatService
+	authService *services.AuthService
+	traffic     *metrics.Traffic
+}
+
+// WebhookPayload is what a webhook posts. Text is the message; without it,
+// Title and Message (Grafana's fields) are put together.
+type WebhookPayload struct {
+	Text    string `json:"text"`
+	Title   string `json:"title"`
+	Message string `json:"message"`
+}
+
+// text returns the message p carries, "" for none.
+func (p WebhookPayload) text() string {
+	if text := strings.TrimSpace(p.Text); text != "" {
+		return text
+	}
+	var parts []string
+	for _, part := range []string{p.Title, p.Message} {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+func NewWebhookController(chatService *services.ChatService, authService *services.AuthService, traffic *metrics.Traffic) *WebhookController {
+	return &WebhookController{
+		chatService: chatService,
+		authService: authService,
+		traffic:     traffic,
+	}
+}
+
+// Handle answers POST /api/webhook/{token} {"text": ...} with a SendResponse.
+// The message is plain text: the relay doesn't hold the room's key.
+func (c *WebhookController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, ok := c.authService.ValidateWebhook(strings.TrimPrefix(r.URL.Path, WebhookPath))
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	logSession(r, session)
+
+	if !c.authService.CheckRateLimit(session.ClientID) {
+		c.traffic.RateLimited.Inc()
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+
+	var payload WebhookPayload
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookBody)).Decode(&payload); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	text := payload.text()
+	if !utils.ValidateMessage(session.Username, text) {
+		http.Error(w, "A webhook posts a \"text\" of 1 to 10000 bytes", http.StatusBadRequest)
+		return
+	}
+
+	msg, err := c.chatService.SendMessage(services.OutgoingMessage{Username: session.Username, Content: text, Color: "[white]", Room: session.Room})
+	if err != nil {
+		sendRefusal(err).write(w)
+		return
+	}
+	c.traffic.ObserveSend(session.ClientID, len(text))
+	c.authService.MarkSent(session)
+	logging.AddAttrs(r.Context(), slog.String("room", msg.Room), slog.String("message_id", msg.ID))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(SendResponse{
+		Status: "sent",
+		ID:     msg.ID,
+		Time:   time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Just enough of RFC 6455 for the stream: the handshake, unmasked frames
+// from the relay and masked ones from the client. The relay only sends;
+// a client's data frames are read and ignored.
+
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Frame opcodes.
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xA
+)
+
+// maxWSClientFrame caps a frame from the client, which has nothing to say
+// beyond control frames.
+const maxWSClientFrame = 4 << 10
+
+// wsConn is a hijacked connection speaking WebSocket.
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	mu     sync.Mutex // guards w and closed; the stream and the reader both write
+	w      *bufio.Writer
+	closed bool
+}
+
+// upgradeWebSocket answers a WebSocket handshake and takes over the
+// connection. It returns nil when the request isn't one, having answered
+// it with 426.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) *wsConn {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") || key == "" {
+		w.Header().Set("Upgrade", "websocket")
+		http.Error(w, "Expected a WebSocket handshake", http.StatusUpgradeRequired)
+		return nil
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil
+	}
+	// The server's read and write timeouts were meant for the request;
+	// the stream sets its own.
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil
+	}
+	return &wsConn{conn: conn, r: brw.Reader, w: brw.Writer}
+}
+
+// headerHasToken reports whether the comma-separated header name lists
+// token, ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// queue buffers a frame; flush sends what is buffered.
+func (c *wsConn) queue(op byte, payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeFrame(op, payload)
+}
+
+func (c *wsConn) flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.w.Flush()
+}
+
+// send writes a frame at once.
+func (c *wsConn) send(op byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeFrame(op, payload)
+	return c.w.Flush()
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) {
+	if c.closed {
+		return
+	}
+	header := make([]byte, 0, 10)
+	header = append(header, 0x80|op) // FIN: the relay never fragments
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xFFFF:
+		header = append(header, 126, byte(n>>8), byte(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	c.w.Write(header)
+	c.w.Write(payload)
+	if op == wsClose {
+		c.closed = true
+	}
+}
+
+// setWriteDeadline bounds the writes until the next call.
+func (c *wsConn) setWriteDeadline(d time.Duration) {
+	c.conn.SetWriteDeadline(time.Now().Add(d))
+}
+
+// setReadDeadline drops a client that sends nothing, not even a pong, for d.
+func (c *wsConn) setReadDeadline(d time.Duration) {
+	c.conn.SetReadDeadline(time.Now().Add(d))
+}
+
+// readFrame reads a frame from the client and returns its opcode and
+// unmasked payload.
+func (c *wsConn) readFrame() (byte, []byte, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(c.r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	op := head[0] & 0x0F
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if head[1]&0x80 == 0 {
+		return 0, nil, errors.New("websocket: unmasked frame from client")
+	}
+	if n > maxWSClientFrame {
+		return 0, nil, errors.New("websocket: frame too large")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return op, payload, nil
+}
+
+// close sends a normal closure, unless a close frame already went out,
+// and drops the connection.
+func (c *wsConn) close() {
+	c.send(wsClose, []byte{0x03, 0xE8}) // 1000
+	c.conn.Close()
+}
+
+// wsSink writes a stream as WebSocket text frames.
+type wsSink struct{ ws *wsConn }
+
+// wsEvent is one text frame of the stream.
+type wsEvent struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+func (s wsSink) waiting(timeout time.Duration) { s.ws.setWriteDeadline(timeout + streamWriteSlack) }
+func (s wsSink) message(_ string, data []byte) { s.event("message", data) }
+func (s wsSink) gap(through uint64)            { s.event("gap", strconv.AppendUint(nil, through, 10)) }
+func (s wsSink) keepalive()                    { s.ws.queue(wsPing, nil) }
+func (s wsSink) flush() error                  { return s.ws.flush() }
+
+func (s wsSink) fail(reason string) {
+	data, _ := json.Marshal(reason)
+	s.event("error", data)
+}
+
+func (s wsSink) event(name string, data []byte) {
+	frame, err := json.Marshal(wsEvent{Event: name, Data: data})
+	if err != nil {
+		return
+	}
+	s.ws.queue(wsText, frame)
+}
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"secure-chat-backend/internal/middleware"
+	"secure-chat-backend/internal/services"
+)
+
+// WhoisController tells what the relay knows about one user.
+type WhoisController struct {
+	authService *services.AuthService
+	userService *services.UserService
+}
+
+// WhoisResponse is the /api/whois body.
+type WhoisResponse struct {
+	services.UserActivity
+	Time string `json:"time"`
+}
+
+func NewWhoisController(authService *services.AuthService, userService *services.UserService) *WhoisController {
+	return &WhoisController{
+		authService: authService,
+		userService: userService,
+	}
+}
+
+// Handle answers GET /api/whois?user=<name> with the user's
+// services.UserActivity, or 404 if there is no such account.
+func (c *WhoisController) Handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	session, ok := c.authService.ValidateSession(middleware.BearerToken(r))
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	logSession(r, session)
+
+	username := query.Get("user")
+	if username == "" {
+		http.Error(w, "Missing user", http.StatusBadRequest)
+		return
+	}
+	if !c.userService.Exists(username) {
+		http.Error(w, services.ErrUnknownUser.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(WhoisResponse{
+		UserActivity: c.authService.Whois(username),
+		Time:         time.Now().Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,803 @@
+// Package irc lets IRC clients use the relay: every room is a channel
+// (lobby is #lobby), and what is said on either side shows up on the other.
+// IRC users log in with their TTC account, PASS being the password.
+package irc
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/services"
+	"secure-chat-backend/internal/utils"
+)
+
+// ServerName is the gateway's name in what it sends.
+const ServerName = "ttc"
+
+const (
+	// pingInterval is how often a connection is pinged, and its session
+	// checked: a kicked or banned user is disconnected within it.
+	pingInterval = 30 * time.Second
+	// idleTimeout closes a connection the client has sent nothing on.
+	idleTimeout = 3 * pingInterval
+	// registerTimeout is how long a client may take to log in.
+	registerTimeout = 30 * time.Second
+	// maxLine is the longest line a client may send, IRCv3 tags included.
+	maxLine = 8 << 10
+	// maxText is the most bytes of message text in one line sent out;
+	// longer messages are split.
+	maxText = 400
+	// maxChannels is how many channels one connection may be in.
+	maxChannels = 20
+	// maxConnsPerAddress is how many connections one address may hold open
+	// at once.
+	maxConnsPerAddress = 4
+	// maxLoginFailures is how many failed logins one address may have
+	// within loginFailureWindow; after that its logins are refused until
+	// the window has passed.
+	maxLoginFailures   = 5
+	loginFailureWindow = 15 * time.Minute
+)
+
+// encryptedText stands in for a message sealed with the room key, which
+// the relay can't read.
+const encryptedText = "[encrypted message — read it in the TTC client]"
+
+// AddressLimiter limits one client address as the relay's per-address
+// limit does for HTTP: how often it may connect, and how many polls it
+// holds open — every channel joined waits for messages like a poll does.
+type AddressLimiter interface {
+	AllowAddress(ip string) bool
+	AcquirePoll(ip string) bool
+	ReleasePoll(ip string)
+}
+
+// Gateway accepts IRC connections. Create it with NewGateway and run it
+// with Serve.
+type Gateway struct {
+	chat          *services.ChatService
+	auth          *services.AuthService
+	authenticator services.Authenticator
+	accessKey     string
+	limiter       AddressLimiter // nil = no limit
+	logger        *slog.Logger
+
+	mu     sync.Mutex
+	ln     net.Listener
+	conns  map[*conn]struct{}
+	byAddr map[string]int // open connections per address
+
+	failMu   sync.Mutex
+	failures map[string]*loginFailures // by address
+}
+
+// loginFailures counts an address's failed logins since the first one in
+// the current window.
+type loginFailures struct {
+	count int
+	since time.Time
+}
+
+// NewGateway creates a gateway that logs users in with authenticator —
+// presenting accessKey for them where the backend wants one — and relays
+// through chat. Every connection takes a request from limiter's budget for
+// its address.
+func NewGateway(chat *services.ChatService, auth *services.AuthService, authenticator services.Authenticator, accessKey string, limiter AddressLimiter, logger *slog.Logger) *Gateway {
+	return &Gateway{
+		chat:          chat,
+		auth:          auth,
+		authenticator: authenticator,
+		accessKey:     accessKey,
+		limiter:       limiter,
+		logger:        logger,
+		conns:         make(map[*conn]struct{}),
+		byAddr:        make(map[string]int),
+		failures:      make(map[string]*loginFailures),
+	}
+}
+
+// Serve accepts connections on ln until Close.
+func (g *Gateway) Serve(ln net.Listener) error {
+	g.mu.Lock()
+	g.ln = ln
+	g.mu.Unlock()
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		ip := remoteIP(nc)
+		g.mu.Lock()
+		full := g.byAddr[ip] >= maxConnsPerAddress
+		g.mu.Unlock()
+		if full || (g.limiter != nil && !g.limiter.AllowAddress(ip)) {
+			nc.SetWriteDeadline(time.Now().Add(time.Second))
+			fmt.Fprintf(nc, "ERROR :Closing link: Too many connections from your address\r\n")
+			nc.Close()
+			continue
+		}
+		c := newConn(g, nc)
+		g.mu.Lock()
+		g.conns[c] = struct{}{}
+		g.byAddr[ip]++
+		g.mu.Unlock()
+		go func() {
+			defer func() {
+				g.mu.Lock()
+				delete(g.conns, c)
+				if g.byAddr[ip]--; g.byAddr[ip] <= 0 {
+					delete(g.byAddr, ip)
+				}
+				g.mu.Unlock()
+			}()
+			c.serve()
+		}()
+	}
+}
+
+// Close stops accepting connections and closes the open ones.
+func (g *Gateway) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for c := range g.conns {
+		c.close("Server shutting down")
+	}
+	if g.ln == nil {
+		return nil
+	}
+	return g.ln.Close()
+}
+
+// ── Connection ────────────────────────────────────────────────────────────────
+
+type conn struct {
+	g        *Gateway
+	nc       net.Conn
+	ip       string
+	clientID string
+
+	writeMu sync.Mutex
+	w       *bufio.Writer
+
+	// Set once logged in.
+	nick  string
+	token string
+
+	// sendMu is held from sending a message until it is in sent, and by
+	// deliver while it checks sent, so a reader can't echo it in between.
+	sendMu sync.Mutex
+
+	mu        sync.Mutex
+	channels  map[string]context.CancelFunc // room → stops its reader
+	sent      map[string]uint64             // messages sent from here, not echoed: ID → Seq
+	announced map[string]uint64             // announcements to every room shown already: ID → Seq
+	closed    bool
+}
+
+func newConn(g *Gateway, nc net.Conn) *conn {
+	return &conn{
+		g:         g,
+		nc:        nc,
+		ip:        remoteIP(nc),
+		clientID:  "irc_" + randomHex(6),
+		w:         bufio.NewWriter(nc),
+		channels:  make(map[string]context.CancelFunc),
+		sent:      make(map[string]uint64),
+		announced: make(map[string]uint64),
+	}
+}
+
+func (c *conn) serve() {
+	defer func() {
+		if r := recover(); r != nil {
+			c.g.logger.Error("irc: panic", "remote", c.nc.RemoteAddr().String(), "error", r)
+		}
+		c.close("")
+	}()
+
+	scanner := bufio.NewScanner(c.nc)
+	scanner.Buffer(make([]byte, 512), maxLine)
+	if !c.register(scanner) {
+		return
+	}
+	c.g.logger.Info("irc: logged in", "user", c.nick, "client_id", c.clientID, "remote", c.nc.RemoteAddr().String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.keepAlive(ctx)
+
+	for {
+		c.nc.SetReadDeadline(time.Now().Add(idleTimeout))
+		if !scanner.Scan() {
+			return
+		}
+		msg, ok := parse(scanner.Text())
+		if !ok {
+			continue
+		}
+		if msg.command == "QUIT" {
+			c.close("Quit")
+			return
+		}
+		c.handle(msg)
+	}
+}
+
+// register reads the lines up to a complete login — PASS, NICK and USER —
+// and logs the user in. It reports whether that worked; a connection gets
+// one try, so guessing a password means reconnecting, and every failure
+// counts against the address.
+func (c *conn) register(scanner *bufio.Scanner) bool {
+	c.nc.SetReadDeadline(time.Now().Add(registerTimeout))
+	var pass, nick string
+	var user bool
+	for !user || nick == "" {
+		if !scanner.Scan() {
+			return false
+		}
+		msg, ok := parse(scanner.Text())
+		if !ok {
+			continue
+		}
+		switch msg.command {
+		case "CAP":
+			if msg.arg(0) == "LS" {
+				c.send(ServerName, "CAP", "*", "LS", "")
+			}
+		case "PASS":
+			pass = msg.arg(0)
+		case "NICK":
+			nick = msg.arg(0)
+		case "USER":
+			user = true
+		case "PING":
+			c.send(ServerName, "PONG", ServerName, msg.arg(0))
+		case "QUIT":
+			return false
+		}
+	}
+
+	attempt := services.LoginAttempt{AccessKey: c.g.accessKey, ClientID: c.clientID, Username: nick, Password: pass}
+	if pass == "" {
+		c.numeric(nick, "464", "Password required — PASS is your TTC password")
+		return false
+	}
+	if err := c.g.authenticator.Admit(attempt); err != nil {
+		c.numeric(nick, "465", err.Error())
+		return false
+	}
+	if c.g.lockedOut(c.ip) {
+		c.g.logger.Warn("irc: login refused, too many failures", "remote", c.ip)
+		c.numeric(nick, "464", "Too many failed logins from your address — try again later")
+		return false
+	}
+	username, err := c.g.authenticator.Login(attempt)
+	if err != nil {
+		c.g.loginFailed(c.ip)
+		text := "Invalid username or password"
+		if errors.Is(err, services.ErrDeviceLogin) {
+			text = err.Error()
+		}
+		c.numeric(nick, "464", text)
+		return false
+	}
+	token, _, err := c.g.auth.IssueToken(username, c.clientID)
+	if err != nil {
+		c.numeric(nick, "464", "Could not log in")
+		return false
+	}
+	if _, ok := c.g.auth.ValidateSession(token); !ok {
+		c.numeric(nick, "465", "Not allowed on this relay")
+		return false
+	}
+	c.nick, c.token = username, token
+
+	c.numeric(c.nick, "001", fmt.Sprintf("Welcome to the TTC relay, %s", c.nick))
+	c.numeric(c.nick, "002", "Your host is "+ServerName)
+	c.numeric(c.nick, "003", "Rooms are channels: /join #lobby")
+	c.send(ServerName, "004", c.nick, ServerName, "ttc", "o", "o")
+	c.send(ServerName, "005", c.nick, "CHANTYPES=#", "NICKLEN=32", "CHANNELLEN=33", fmt.Sprintf("CHANLIMIT=#:%d", maxChannels), "are supported by this server")
+	c.numeric(c.nick, "422", "No message of the day")
+	return true
+}
+
+// keepAlive pings the client and checks the session until ctx is done.
+// Checking the session also keeps the user listed as online.
+func (c *conn) keepAlive(ctx context.Context) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, ok := c.g.auth.ValidateSession(c.token); !ok {
+				c.close("Session ended — kicked or banned")
+				return
+			}
+			c.send("", "PING", ServerName)
+			c.forget(c.g.chat.Oldest())
+		}
+	}
+}
+
+// forget drops the sent messages and announcements older than seq: they
+// have left the buffer, so no reader will come across them again.
+func (c *conn) forget(seq uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, s := range c.sent {
+		if s < seq {
+			delete(c.sent, id)
+		}
+	}
+	for id, s := range c.announced {
+		if s < seq {
+			delete(c.announced, id)
+		}
+	}
+}
+
+// handle carries out one command of a logged-in client.
+func (c *conn) handle(msg message) {
+	switch msg.command {
+	case "PING":
+		c.send(ServerName, "PONG", ServerName, msg.arg(0))
+	case "PONG", "CAP", "USER", "PASS", "AWAY", "USERHOST", "ISON":
+	case "NICK":
+		c.notice("Names can't change over IRC — use /nick in the TTC client, then log in again")
+	case "JOIN":
+		if msg.arg(0) == "0" {
+			for _, room := range c.rooms() {
+				c.part(room)
+			}
+			return
+		}
+		for _, name := range strings.Split(msg.arg(0), ",") {
+			c.join(name)
+		}
+	case "PART":
+		for _, name := range strings.Split(msg.arg(0), ",") {
+			room, ok := roomOf(name)
+			if !ok || !c.in(room) {
+				c.numeric(c.nick, "442", "You're not on that channel", name)
+				continue
+			}
+			c.part(room)
+		}
+	case "PRIVMSG":
+		c.privmsg(msg.arg(0), msg.arg(1))
+	case "NOTICE":
+		// Never answered, as IRC wants; not relayed either.
+	case "MODE":
+		target := msg.arg(0)
+		if room, ok := roomOf(target); ok {
+			c.send(ServerName, "324", c.nick, channelOf(room), "+")
+		} else if strings.EqualFold(target, c.nick) {
+			c.send(ServerName, "221", c.nick, "+")
+		}
+	case "WHO":
+		c.numeric(c.nick, "315", "End of WHO list", msg.arg(0))
+	case "TOPIC":
+		c.numeric(c.nick, "331", "No topic is set", msg.arg(0))
+	case "NAMES":
+		if room, ok := roomOf(msg.arg(0)); ok {
+			c.names(room)
+		}
+	case "LIST":
+		c.numeric(c.nick, "323", "End of LIST")
+	default:
+		c.numeric(c.nick, "421", "Unknown command", msg.command)
+	}
+}
+
+func (c *conn) join(name string) {
+	room, ok := roomOf(name)
+	if !ok {
+		c.numeric(c.nick, "403", "No such channel — room names are lowercase letters, digits, '_' and '-'", name)
+		return
+	}
+	c.mu.Lock()
+	if _, in := c.channels[room]; in || c.closed {
+		c.mu.Unlock()
+		return
+	}
+	if len(c.channels) >= maxChannels {
+		c.mu.Unlock()
+		c.numeric(c.nick, "405", "You have joined too many channels", name)
+		return
+	}
+	if limiter := c.g.limiter; limiter != nil && !limiter.AcquirePoll(c.ip) {
+		c.mu.Unlock()
+		c.numeric(c.nick, "405", "Too many open polls from your address", name)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.channels[room] = cancel
+	c.mu.Unlock()
+
+	c.send(c.prefix(c.nick), "JOIN", channelOf(room))
+	c.numeric(c.nick, "331", "No topic is set", channelOf(room))
+	c.names(room)
+	go c.relay(ctx, room)
+}
+
+func (c *conn) part(room string) {
+	c.mu.Lock()
+	cancel, ok := c.channels[room]
+	delete(c.channels, room)
+	c.mu.Unlock()
+	if ok {
+		cancel()
+		c.releasePoll()
+		c.send(c.prefix(c.nick), "PART", channelOf(room))
+	}
+}
+
+// releasePoll gives back the poll join took for a channel.
+func (c *conn) releasePoll() {
+	if c.g.limiter != nil {
+		c.g.limiter.ReleasePoll(c.ip)
+	}
+}
+
+func (c *conn) in(room string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.channels[room]
+	return ok
+}
+
+func (c *conn) rooms() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rooms := make([]string, 0, len(c.channels))
+	for room := range c.channels {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// names lists who is online — on the relay, since TTC has no room
+// membership — as the channel's names.
+func (c *conn) names(room string) {
+	var names []string
+	for _, u := range c.g.auth.OnlineUsers() {
+		if !strings.Contains(u.Username, ":") {
+			names = append(names, u.Username)
+		}
+	}
+	for len(names) > 0 {
+		n := min(len(names), 20)
+		c.send(ServerName, "353", c.nick, "=", channelOf(room), strings.Join(names[:n], " "))
+		names = names[n:]
+	}
+	c.numeric(c.nick, "366", "End of NAMES list", channelOf(room))
+}
+
+// privmsg sends text to target, a channel the client is in.
+func (c *conn) privmsg(target, text string) {
+	room, ok := roomOf(target)
+	if !ok {
+		c.numeric(c.nick, "401", "Direct messages are end-to-end encrypted — send them from the TTC client", target)
+		return
+	}
+	if !c.in(room) {
+		c.numeric(c.nick, "404", "Join the channel first", target)
+		return
+	}
+	msgType := ""
+	if action, ok := strings.CutPrefix(text, "\x01ACTION "); ok {
+		text, msgType = strings.TrimSuffix(action, "\x01"), models.TypeAction
+	} else if strings.HasPrefix(text, "\x01") {
+		return // other CTCP requests
+	}
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	if _, ok := c.g.auth.ValidateSession(c.token); !ok {
+		c.close("Session ended — kicked or banned")
+		return
+	}
+	if !c.g.auth.CheckRateLimit(c.clientID) {
+		c.numeric(c.nick, "404", "Too many messages — slow down", target)
+		return
+	}
+
+	c.sendMu.Lock()
+	msg, err := c.g.chat.SendMessage(services.OutgoingMessage{Username: c.nick, Content: text, Color: "[white]", Room: room, Type: msgType})
+	if err != nil {
+		c.sendMu.Unlock()
+		c.numeric(c.nick, "404", err.Error(), target)
+		return
+	}
+	c.mu.Lock()
+	c.sent[msg.ID] = msg.Seq
+	c.mu.Unlock()
+	c.sendMu.Unlock()
+	c.g.auth.MarkSent(&services.Session{Username: c.nick, ClientID: c.clientID})
+}
+
+// relay sends the messages of room to the client until ctx is done.
+func (c *conn) relay(ctx context.Context, room string) {
+	view := models.View{Room: room, User: c.nick}
+	// Start after the newest message: IRC clients expect no backlog.
+	cursor := models.Cursor{Seq: c.g.chat.Newest()}
+	for {
+		batch, err := c.g.chat.WaitForMessages(ctx, view, cursor, pingInterval)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			// The relay is out of waiter slots; try again shortly.
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		cursor = models.Cursor{Seq: batch.Through}
+		if n := len(batch.Messages); n > 0 {
+			cursor.ID = batch.Messages[n-1].ID
+		}
+		for _, m := range batch.Messages {
+			c.deliver(room, m)
+		}
+	}
+}
+
+// deliver shows m, from room's stream, to the client.
+func (c *conn) deliver(room string, m *models.Message) {
+	if m.To != "" {
+		return // sealed to its recipient's key
+	}
+	c.sendMu.Lock()
+	c.mu.Lock()
+	_, own := c.sent[m.ID]
+	delete(c.sent, m.ID)
+	_, shown := c.announced[m.ID]
+	shown = shown && m.System && m.Room == ""
+	if m.System && m.Room == "" {
+		c.announced[m.ID] = m.Seq
+	}
+	c.mu.Unlock()
+	c.sendMu.Unlock()
+	if own || shown {
+		return
+	}
+
+	text := m.Content
+	if sealed(text) {
+		text = encryptedText
+	}
+	switch {
+	case m.System && m.Room == "":
+		// To every room: once, not in each channel.
+		for _, line := range lines(text) {
+			c.send(ServerName, "NOTICE", c.nick, line)
+		}
+	case m.System:
+		for _, line := range lines(text) {
+			c.send(ServerName, "NOTICE", channelOf(room), line)
+		}
+	case m.Type == models.TypeAction:
+		c.send(c.prefix(m.Username), "PRIVMSG", channelOf(room), "\x01ACTION "+strings.Join(lines(text), " ")+"\x01")
+	default:
+		for _, line := range lines(text) {
+			c.send(c.prefix(m.Username), "PRIVMSG", channelOf(room), line)
+		}
+	}
+}
+
+// close ends the connection, with reason in an ERROR line if it is set.
+func (c *conn) close(reason string) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	for room, cancel := range c.channels {
+		cancel()
+		c.releasePoll()
+		delete(c.channels, room)
+	}
+	c.mu.Unlock()
+	if reason != "" {
+		c.send("", "ERROR", "Closing link: "+reason)
+	}
+	c.nc.Close()
+	if c.nick != "" {
+		c.g.logger.Info("irc: disconnected", "user", c.nick, "client_id", c.clientID)
+	}
+}
+
+// ── Login limits ──────────────────────────────────────────────────────────────
+
+// lockedOut reports whether ip has had maxLoginFailures failed logins in
+// the current window.
+func (g *Gateway) lockedOut(ip string) bool {
+	g.failMu.Lock()
+	defer g.failMu.Unlock()
+	f, ok := g.failures[ip]
+	if ok && time.Since(f.since) > loginFailureWindow {
+		delete(g.failures, ip)
+		return false
+	}
+	return ok && f.count >= maxLoginFailures
+}
+
+// loginFailed counts a failed login from ip, and forgets the addresses
+// whose window has passed.
+func (g *Gateway) loginFailed(ip string) {
+	g.failMu.Lock()
+	defer g.failMu.Unlock()
+	now := time.Now()
+	for addr, f := range g.failures {
+		if now.Sub(f.since) > loginFailureWindow {
+			delete(g.failures, addr)
+		}
+	}
+	f, ok := g.failures[ip]
+	if !ok {
+		f = &loginFailures{since: now}
+		g.failures[ip] = f
+	}
+	f.count++
+}
+
+// remoteIP returns the address nc comes from, without the port.
+func remoteIP(nc net.Conn) string {
+	host, _, err := net.SplitHostPort(nc.RemoteAddr().String())
+	if err != nil {
+		return nc.RemoteAddr().String()
+	}
+	return host
+}
+
+// ── Lines ─────────────────────────────────────────────────────────────────────
+
+// message is one line from a client. Tags are dropped.
+type message struct {
+	command string
+	params  []string
+}
+
+func (m message) arg(i int) string {
+	if i < len(m.params) {
+		return m.params[i]
+	}
+	return ""
+}
+
+// parse splits an IRC line into its command and parameters.
+func parse(line string) (message, bool) {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "@") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	line = strings.TrimLeft(line, " ")
+	var msg message
+	for line != "" {
+		if strings.HasPrefix(line, ":") && msg.command != "" {
+			msg.params = append(msg.params, line[1:])
+			break
+		}
+		var word string
+		word, line, _ = strings.Cut(line, " ")
+		line = strings.TrimLeft(line, " ")
+		if msg.command == "" {
+			msg.command = strings.ToUpper(word)
+		} else {
+			msg.params = append(msg.params, word)
+		}
+	}
+	return msg, msg.command != ""
+}
+
+// send writes one line. The last parameter is sent as the trailing one.
+func (c *conn) send(prefix, command string, params ...string) {
+	var b strings.Builder
+	if prefix != "" {
+		b.WriteString(":" + prefix + " ")
+	}
+	b.WriteString(command)
+	for i, p := range params {
+		if i == len(params)-1 {
+			b.WriteString(" :" + p)
+		} else {
+			b.WriteString(" " + p)
+		}
+	}
+	b.WriteString("\r\n")
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.nc.SetWriteDeadline(time.Now().Add(pingInterval))
+	c.w.WriteString(b.String())
+	c.w.Flush()
+}
+
+// numeric sends a numeric reply to nick: the params, then text.
+func (c *conn) numeric(nick, code, text string, params ...string) {
+	if nick == "" {
+		nick = "*"
+	}
+	c.send(ServerName, code, append(append([]string{nick}, params...), text)...)
+}
+
+func (c *conn) notice(text string) {
+	c.send(ServerName, "NOTICE", c.nick, text)
+}
+
+func (c *conn) prefix(nick string) string {
+	return nick + "!" + nick + "@" + ServerName
+}
+
+// roomOf returns the room of an IRC channel name.
+func roomOf(channel string) (string, bool) {
+	room := strings.ToLower(strings.TrimPrefix(channel, "#"))
+	if !strings.HasPrefix(channel, "#") || !utils.ValidateRoom(room) {
+		return "", false
+	}
+	return room, true
+}
+
+func channelOf(room string) string {
+	return "#" + room
+}
+
+// lines splits text into lines IRC can carry: at newlines, and so that none
+// is longer than maxText.
+func lines(text string) []string {
+	var out []string
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r", ""), "\n") {
+		for len(line) > maxText {
+			cut := maxText
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			out = append(out, line[:cut])
+			line = line[cut:]
+		}
+		if line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// sealed reports whether content is an envelope sealed by a TTC client:
+// Base64 starting with its magic byte and version.
+func sealed(content string) bool {
+	if len(content) < 8 || strings.ContainsAny(content, " \n") {
+		return false
+	}
+	head, err := base64.StdEncoding.DecodeString(content[:8])
+	return err == nil && len(head) >= 2 && head[0] == 0xE7 && head[1] == 1
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
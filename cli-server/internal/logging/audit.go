@@ -0,0 +1,22 @@
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"os"
+)
+
+// OpenAudit returns the logger for security-relevant events (upload scan
+// verdicts and the like). With a path they go to that file as JSON lines,
+// created with mode 0600; without one they go to fallback, tagged
+// log=audit so they can be filtered out of the main log.
+func OpenAudit(path string, fallback *slog.Logger) (*slog.Logger, io.Closer, error) {
+	if path == "" {
+		return fallback.With("log", "audit"), io.NopCloser(nil), nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return slog.New(slog.NewJSONHandler(f, nil)), f, nil
+}
@@ -0,0 +1,88 @@
+// Package logging sets up the server's structured logger and carries a
+// per-request logger through the request context.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// New builds a logger writing to w. format is "text" or "json"; level is
+// one of debug, info, warn, error.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("log level %q: want debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case "text", "":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("log format %q: want text or json", format)
+}
+
+type ctxKey struct{}
+
+// requestLog is the per-request state: the logger tagged with the request
+// ID, plus attributes handlers add once they know more (client ID, user…).
+type requestLog struct {
+	logger *slog.Logger
+	mu     sync.Mutex
+	attrs  []any
+}
+
+// WithRequest returns a context whose logger is logger tagged with requestID.
+func WithRequest(ctx context.Context, logger *slog.Logger, requestID string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, &requestLog{logger: logger.With("request_id", requestID)})
+}
+
+// AddAttrs tags the rest of the request's log lines — including the access
+// log line written when it finishes — with attrs.
+func AddAttrs(ctx context.Context, attrs ...slog.Attr) {
+	rl, ok := ctx.Value(ctxKey{}).(*requestLog)
+	if !ok {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	for _, a := range attrs {
+		rl.attrs = append(rl.attrs, a)
+	}
+}
+
+// Attrs returns the attributes handlers added to the request so far.
+func Attrs(ctx context.Context) []slog.Attr {
+	rl, ok := ctx.Value(ctxKey{}).(*requestLog)
+	if !ok {
+		return nil
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	attrs := make([]slog.Attr, 0, len(rl.attrs))
+	for _, a := range rl.attrs {
+		if attr, ok := a.(slog.Attr); ok {
+			attrs = append(attrs, attr)
+		}
+	}
+	return attrs
+}
+
+// FromContext returns the request's logger with every attribute added so
+// far, or slog.Default() outside a request.
+func FromContext(ctx context.Context) *slog.Logger {
+	rl, ok := ctx.Value(ctxKey{}).(*requestLog)
+	if !ok {
+		return slog.Default()
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return rl.logger.With(rl.attrs...)
+}
@@ -0,0 +1,190 @@
+// Package metrics collects traffic statistics for operators and writes them
+// in the Prometheus text format. Only aggregates are kept — no usernames,
+// client IDs or message content ever reach /metrics.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Histogram counts observations into cumulative buckets, Prometheus style.
+// It is safe for concurrent use.
+type Histogram struct {
+	name, help string
+	bounds     []float64 // upper bounds, ascending; +Inf is implicit
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, not cumulative; last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram returns a histogram with the given upper bucket bounds.
+func NewHistogram(name, help string, bounds ...float64) *Histogram {
+	return &Histogram{
+		name:   name,
+		help:   help,
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe records one value.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// WriteTo writes the histogram in the Prometheus text format.
+func (h *Histogram) WriteTo(w io.Writer) (int64, error) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	var n int64
+	write := func(format string, args ...any) error {
+		m, err := fmt.Fprintf(w, format, args...)
+		n += int64(m)
+		return err
+	}
+	if err := write("# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name); err != nil {
+		return n, err
+	}
+	var cumulative uint64
+	for i, c := range counts {
+		cumulative += c
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = formatFloat(h.bounds[i])
+		}
+		if err := write("%s_bucket{le=%q} %d\n", h.name, le, cumulative); err != nil {
+			return n, err
+		}
+	}
+	err := write("%s_sum %s\n%s_count %d\n", h.name, formatFloat(sum), h.name, count)
+	return n, err
+}
+
+// Counter is a monotonically increasing count.
+type Counter struct {
+	name, help string
+
+	mu sync.Mutex
+	n  uint64
+}
+
+func NewCounter(name, help string) *Counter {
+	return &Counter{name: name, help: help}
+}
+
+func (c *Counter) Inc() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.n++
+}
+
+// Value returns the count so far.
+func (c *Counter) Value() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.n
+}
+
+// WriteTo writes the counter in the Prometheus text format.
+func (c *Counter) WriteTo(w io.Writer) (int64, error) {
+	c.mu.Lock()
+	n := c.n
+	c.mu.Unlock()
+	m, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, n)
+	return int64(m), err
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// rateWindow is the period per-client send rates are measured over.
+const rateWindow = time.Minute
+
+// Traffic holds the metrics for /api/send: how big messages are and how
+// fast clients send them, so limits can be tuned on real traffic.
+type Traffic struct {
+	MessageBytes *Histogram // content length of every accepted message
+	ClientRate   *Histogram // per client, messages per rateWindow
+	Sent         *Counter
+	RateLimited  *Counter
+
+	mu          sync.Mutex
+	windowStart time.Time
+	perClient   map[string]int // sends this window; never exported
+}
+
+func NewTraffic() *Traffic {
+	return &Traffic{
+		MessageBytes: NewHistogram("ttc_message_content_bytes",
+			"Size of accepted message content in bytes (ciphertext for encrypted messages).",
+			64, 128, 256, 512, 1024, 2048, 4096, 8192),
+		ClientRate: NewHistogram("ttc_client_messages_per_minute",
+			"Messages each sending client sent per minute, observed when the minute ends; silent clients are not counted.",
+			1, 2, 5, 10, 30, 60, 120, 300, 600),
+		Sent:        NewCounter("ttc_messages_sent_total", "Messages accepted by /api/send."),
+		RateLimited: NewCounter("ttc_messages_rate_limited_total", "Sends refused by the per-client rate limit."),
+		windowStart: time.Now(),
+		perClient:   make(map[string]int),
+	}
+}
+
+// ObserveSend records an accepted message of size bytes from clientID.
+func (t *Traffic) ObserveSend(clientID string, size int) {
+	t.MessageBytes.Observe(float64(size))
+	t.Sent.Inc()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollLocked(time.Now())
+	t.perClient[clientID]++
+}
+
+// rollLocked closes the current window once it is over, feeding every
+// client's count into ClientRate.
+func (t *Traffic) rollLocked(now time.Time) {
+	if now.Sub(t.windowStart) < rateWindow {
+		return
+	}
+	for _, n := range t.perClient {
+		t.ClientRate.Observe(float64(n))
+	}
+	clear(t.perClient)
+	t.windowStart = now
+}
+
+// WriteTo writes every metric in the Prometheus text format.
+func (t *Traffic) WriteTo(w io.Writer) (int64, error) {
+	t.mu.Lock()
+	t.rollLocked(time.Now())
+	t.mu.Unlock()
+
+	var total int64
+	for _, m := range []io.WriterTo{t.MessageBytes, t.ClientRate, t.Sent, t.RateLimited} {
+		n, err := m.WriteTo(w)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
@@ -1,24 +1,25 @@
-package middleware
-
-import "net/http"
-
-type CORSMiddleware struct{}
-
-func NewCORSMiddleware() *CORSMiddleware {
-	return &CORSMiddleware{}
-}
-
-func (m *CORSMiddleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next(w, r)
-	}
-}
+package middleware
+
+import "net/http"
+
+type CORSMiddleware struct{}
+
+func NewCORSMiddleware() *CORSMiddleware {
+	return &CORSMiddleware{}
+}
+
+func (m *CORSMiddleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-TTC-Timestamp, X-TTC-Nonce, X-TTC-Signature, X-TTC-Access-Key, X-Request-ID, traceparent, Last-Event-ID")
+		w.Header().Set("Access-Control-Expose-Headers", "X-TTC-Seq, X-TTC-Gap, Retry-After, X-Request-ID")
+
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// gzipMinSize is the smallest response worth compressing; below it the
+// gzip header and the CPU cost more than they save.
+const gzipMinSize = 1024
+
+// gzipTypes are the content types compressed. Uploads are encrypted and
+// don't shrink, and an event stream must reach the client event by event.
+var gzipTypes = []string{"application/json", "application/msgpack", "text/plain", "text/html", "text/csv"}
+
+// GzipMiddleware compresses responses for clients that accept gzip. Poll
+// and history answers are JSON arrays of messages that shrink to a
+// fraction, which counts on metered mobile connections.
+type GzipMiddleware struct {
+	writers sync.Pool
+}
+
+func NewGzipMiddleware() *GzipMiddleware {
+	return &GzipMiddleware{writers: sync.Pool{New: func() any { return gzip.NewWriter(nil) }}}
+}
+
+func (m *GzipMiddleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w, pool: &m.writers, status: http.StatusOK}
+		next(gw, r)
+		// Not deferred: after a panic what was held back is dropped, and
+		// RecoveryMiddleware can still answer 500.
+		gw.close()
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(coding) == "gzip" {
+			return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// gzipWriter holds back the start of a response until it knows whether to
+// compress it: gzipMinSize bytes of a compressible type, not already
+// encoded. A Flush before that sends what there is as it is.
+type gzipWriter struct {
+	http.ResponseWriter
+	pool *sync.Pool
+
+	status    int
+	headerSet bool
+	decided   bool
+	buf       []byte
+	gz        *gzip.Writer // nil = sent as it is
+}
+
+func (g *gzipWriter) WriteHeader(code int) {
+	if g.decided || code < http.StatusOK {
+		g.ResponseWriter.WriteHeader(code)
+		return
+	}
+	g.status, g.headerSet = code, true
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		g.decide(false)
+	}
+}
+
+func (g *gzipWriter) Write(b []byte) (int, error) {
+	if !g.decided {
+		g.buf = append(g.buf, b...)
+		if len(g.buf) < gzipMinSize {
+			return len(b), nil
+		}
+		if err := g.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if g.gz != nil {
+		return g.gz.Write(b)
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+// decide sends the held-back start, compressed if worth is true and the
+// response allows it.
+func (g *gzipWriter) decide(worth bool) error {
+	g.decided = true
+	h := g.Header()
+	if h.Get("Content-Type") == "" && len(g.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(g.buf))
+	}
+	if worth && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz = g.pool.Get().(*gzip.Writer)
+		g.gz.Reset(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(g.status)
+	buf := g.buf
+	g.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if g.gz != nil {
+		_, err = g.gz.Write(buf)
+	} else {
+		_, err = g.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func compressible(contentType string) bool {
+	for _, t := range gzipTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Flush sends everything written so far; http.ResponseController finds it
+// before Unwrap.
+func (g *gzipWriter) Flush() {
+	if !g.decided {
+		g.decide(false)
+	}
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+// close ends the response: a short one goes out as it is.
+func (g *gzipWriter) close() {
+	if !g.decided {
+		if len(g.buf) == 0 && !g.headerSet {
+			return // nothing written; net/http answers 200
+		}
+		g.decide(false)
+	}
+	if g.gz != nil {
+		g.gz.Close()
+		g.gz.Reset(nil)
+		g.pool.Put(g.gz)
+		g.gz = nil
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so
+// streaming handlers can extend their write deadline.
+func (g *gzipWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
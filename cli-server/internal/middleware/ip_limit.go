@@ -0,0 +1,231 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"secure-chat-backend/internal/logging"
+)
+
+// IPLimitMiddleware limits each client address, however many client IDs it
+// uses: a token bucket over all its requests, and a cap on the polls and
+// streams it holds open at once. Behind a reverse proxy the address is taken
+// from X-Forwarded-For, but only for requests that come from one of the
+// trusted proxies.
+type IPLimitMiddleware struct {
+	limit    rate.Limit
+	burst    int
+	maxPolls int // 0 = no cap
+	trusted  []*net.IPNet
+
+	mu      sync.Mutex
+	clients map[string]*ipClient
+}
+
+type ipClient struct {
+	limiter  *rate.Limiter
+	polls    int
+	lastSeen time.Time
+}
+
+// ipIdle is how long an address may stay quiet before its bucket is dropped.
+const ipIdle = 10 * time.Minute
+
+// NewIPLimitMiddleware allows limit requests per second (0 = no limit) with
+// bursts of burst and at most maxPolls open polls per address (0 = no cap).
+// trusted lists the proxies, as IPs or CIDRs, whose X-Forwarded-For is
+// believed.
+func NewIPLimitMiddleware(limit float64, burst, maxPolls int, trusted []string) (*IPLimitMiddleware, error) {
+	m := &IPLimitMiddleware{
+		limit:    rate.Limit(limit),
+		burst:    burst,
+		maxPolls: maxPolls,
+		clients:  make(map[string]*ipClient),
+	}
+	if limit <= 0 {
+		m.limit = rate.Inf
+	}
+	for _, t := range trusted {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if !strings.Contains(t, "/") {
+			if ip := net.ParseIP(t); ip != nil && ip.To4() != nil {
+				t += "/32"
+			} else {
+				t += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(t)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: not an IP or CIDR", t)
+		}
+		m.trusted = append(m.trusted, network)
+	}
+
+	go m.cleanupLoop()
+
+	return m, nil
+}
+
+// Wrap applies the request rate limit.
+func (m *IPLimitMiddleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := m.clientIP(r)
+		if !m.client(ip).limiter.Allow() {
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip)))
+	}
+}
+
+// AllowAddress takes a request from ip's bucket, for traffic that doesn't
+// come through Wrap (the IRC gateway's connections), and reports whether
+// there was one.
+func (m *IPLimitMiddleware) AllowAddress(ip string) bool {
+	return m.client(ip).limiter.Allow()
+}
+
+// AcquirePoll counts an open poll for ip, as WrapPoll does, for waits that
+// don't come through it (the IRC gateway's channels), and reports whether
+// ip had one left. Release it with ReleasePoll.
+func (m *IPLimitMiddleware) AcquirePoll(ip string) bool {
+	return m.acquirePoll(m.client(ip))
+}
+
+// ReleasePoll ends a poll AcquirePoll counted.
+func (m *IPLimitMiddleware) ReleasePoll(ip string) {
+	m.releasePoll(m.client(ip))
+}
+
+// WrapPoll applies the request rate limit and the cap on open polls, for
+// long polls and streams.
+func (m *IPLimitMiddleware) WrapPoll(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := m.client(m.clientIP(r))
+		if !c.limiter.Allow() {
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		if !m.acquirePoll(c) {
+			http.Error(w, "Too many open polls from your address", http.StatusTooManyRequests)
+			return
+		}
+		defer m.releasePoll(c)
+		next(w, r)
+	}
+}
+
+type clientIPKey struct{}
+
+// ClientIP returns the address r comes from as Wrap worked it out, or, for
+// a request that didn't pass through Wrap, the one it was sent from.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP returns the address r comes from. X-Forwarded-For is walked from
+// the right, skipping trusted proxies, so a client can't choose its address
+// by sending the header itself.
+func (m *IPLimitMiddleware) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !m.isTrusted(host) {
+		return host
+	}
+	proxy := host
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		host = hop
+		if !m.isTrusted(hop) {
+			break
+		}
+	}
+	if host != proxy {
+		logging.AddAttrs(r.Context(), slog.String("client_ip", host))
+	}
+	return host
+}
+
+func (m *IPLimitMiddleware) isTrusted(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range m.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *IPLimitMiddleware) client(ip string) *ipClient {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.clients[ip]
+	if !ok {
+		c = &ipClient{limiter: rate.NewLimiter(m.limit, m.burst)}
+		m.clients[ip] = c
+	}
+	c.lastSeen = time.Now()
+	return c
+}
+
+// acquirePoll counts an open poll for c; cleanupLoop keeps c while it has
+// any.
+func (m *IPLimitMiddleware) acquirePoll(c *ipClient) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.maxPolls > 0 && c.polls >= m.maxPolls {
+		return false
+	}
+	c.polls++
+	return true
+}
+
+func (m *IPLimitMiddleware) releasePoll(c *ipClient) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c.polls--
+	c.lastSeen = time.Now()
+}
+
+// cleanupLoop drops the state of addresses that have gone quiet.
+func (m *IPLimitMiddleware) cleanupLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.mu.Lock()
+		now := time.Now()
+		for ip, c := range m.clients {
+			if c.polls == 0 && now.Sub(c.lastSeen) > ipIdle {
+				delete(m.clients, ip)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
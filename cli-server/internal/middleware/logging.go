@@ -1,40 +1,118 @@
-package middleware
-
-import (
-	"log"
-	"net/http"
-	"time"
-)
-
-type LoggingMiddleware struct{}
-
-func NewLoggingMiddleware() *LoggingMiddleware {
-	return &LoggingMiddleware{}
-}
-
-func (m *LoggingMiddleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-
-		rr := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
-
-		next(rr, r)
-
-		log.Printf("[%s] %s %d %s %v",
-			r.Method,
-			r.URL.Path,
-			rr.statusCode,
-			r.RemoteAddr,
-			time.Since(start))
-	}
-}
-
-type responseRecorder struct {
-	http.ResponseWriter
-	statusCode int
-}
-
-func (rr *responseRecorder) WriteHeader(code int) {
-	rr.statusCode = code
-	rr.ResponseWriter.WriteHeader(code)
-}
+package middleware
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"secure-chat-backend/internal/logging"
+	"secure-chat-backend/internal/utils"
+)
+
+type LoggingMiddleware struct {
+	logger *slog.Logger
+}
+
+func NewLoggingMiddleware(logger *slog.Logger) *LoggingMiddleware {
+	return &LoggingMiddleware{logger: logger}
+}
+
+// Wrap gives every request an ID (echoed in the X-Request-ID header) and a
+// request logger in its context, and writes one access log line when the
+// request finishes. Handlers add client_id, user etc. via logging.AddAttrs,
+// so the access line of a send and of the polls delivering it can be matched.
+// The User-Agent is logged too; clients put their version and commit in it.
+//
+// An X-Request-ID the request brings — from a proxy in front, or a client
+// tracking its own requests — is kept if it looks like an ID, so one ID
+// follows the request through every log. 5xx error texts end with it, for
+// users to quote.
+func (m *LoggingMiddleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get("X-Request-ID")
+		if !validRequestID(requestID) {
+			requestID = utils.GenerateRequestID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		ctx := logging.WithRequest(r.Context(), m.logger, requestID)
+
+		rr := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK, requestID: requestID}
+
+		next(rr, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if r.URL.Path == "/health" {
+			level = slog.LevelDebug
+		}
+		logging.FromContext(ctx).Log(ctx, level, "request",
+			"method", r.Method,
+			"path", logPath(r),
+			"status", rr.statusCode,
+			"remote", r.RemoteAddr,
+			"user_agent", r.UserAgent(),
+			"duration", time.Since(start))
+	}
+}
+
+// logPath is r.URL.Path as logged: a webhook's token is its path, and
+// stays out of the log.
+func logPath(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, "/api/webhook/") {
+		return "/api/webhook/…"
+	}
+	return r.URL.Path
+}
+
+// validRequestID reports whether an X-Request-ID from outside is kept: 1
+// to 64 letters, digits and ._:- — anything else could forge log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', strings.ContainsRune("._:-", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	requestID  string
+	wrote      bool
+}
+
+func (rr *responseRecorder) WriteHeader(code int) {
+	rr.statusCode = code
+	rr.ResponseWriter.WriteHeader(code)
+}
+
+// Write adds the request ID to the text of an http.Error with a 5xx
+// status.
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	first := !rr.wrote
+	rr.wrote = true
+	if first && rr.statusCode >= 500 && bytes.HasSuffix(b, []byte("\n")) &&
+		strings.HasPrefix(rr.Header().Get("Content-Type"), "text/plain") && rr.Header().Get("Content-Encoding") == "" {
+		text := append(b[:len(b)-1:len(b)-1], " (request "+rr.requestID+")\n"...)
+		if _, err := rr.ResponseWriter.Write(text); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	return rr.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, so
+// streaming handlers can flush and extend their write deadline.
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+	return rr.ResponseWriter
+}
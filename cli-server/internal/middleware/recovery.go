@@ -1,26 +1,36 @@
-package middleware
-
-import (
-	"log"
-	"net/http"
-	"runtime/debug"
-)
-
-type RecoveryMiddleware struct{}
-
-func NewRecoveryMiddleware() *RecoveryMiddleware {
-	return &RecoveryMiddleware{}
-}
-
-func (m *RecoveryMiddleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if err := recover(); err != nil {
-				log.Printf("PANIC: %v\n%s", err, debug.Stack())
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
-			}
-		}()
-
-		next(w, r)
-	}
-}
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+type RecoveryMiddleware struct {
+	logger *slog.Logger
+}
+
+func NewRecoveryMiddleware(logger *slog.Logger) *RecoveryMiddleware {
+	return &RecoveryMiddleware{logger: logger}
+}
+
+func (m *RecoveryMiddleware) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				// The logging middleware runs inside this one; its request ID
+				// is already on the response headers.
+				requestID := w.Header().Get("X-Request-ID")
+				m.logger.Error("panic",
+					"request_id", requestID,
+					"method", r.Method,
+					"path", logPath(r),
+					"error", err,
+					"stack", string(debug.Stack()))
+				http.Error(w, "Internal server error (request "+requestID+")", http.StatusInternalServerError)
+			}
+		}()
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testKeys gives each token a key of its own.
+type testKeys struct{}
+
+func (testKeys) SigningKey(token string) []byte { return []byte("session key for " + token) }
+func (testKeys) AccessSigningKey() []byte       { return []byte("access key") }
+
+// newRequest builds a request with token as its Bearer token ("" = none),
+// signed with key unless key is nil.
+func newRequest(method, target, token, body string, key []byte, at time.Time, nonce string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	if key != nil {
+		ts := strconv.FormatInt(at.Unix(), 10)
+		r.Header.Set(HeaderTimestamp, ts)
+		r.Header.Set(HeaderNonce, nonce)
+		r.Header.Set(HeaderSignature, SignRequest(key, method, r.URL.RequestURI(), ts, nonce, []byte(body)))
+	}
+	return r
+}
+
+// serve runs r through wrap and reports the status and the body the
+// handler read ("" when it wasn't reached).
+func serve(wrap func(http.HandlerFunc) http.HandlerFunc, r *http.Request) (int, string) {
+	var seen string
+	w := httptest.NewRecorder()
+	wrap(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		seen = "read:" + string(body)
+	})(w, r)
+	return w.Code, seen
+}
+
+func TestSigningAcceptsSignedRequest(t *testing.T) {
+	m := NewSigningMiddleware(testKeys{}, true, 1<<20)
+	key := testKeys{}.SigningKey("tok")
+	r := newRequest(http.MethodPost, "/api/send?room=dev", "tok", `{"content":"hi"}`, key, time.Now(), "n1")
+	code, seen := serve(m.Wrap, r)
+	if code != http.StatusOK || seen != `read:{"content":"hi"}` {
+		t.Errorf("got %d, handler saw %q", code, seen)
+	}
+}
+
+func TestSigningRefusesBadRequests(t *testing.T) {
+	key := testKeys{}.SigningKey("tok")
+	now := time.Now()
+	tests := map[string]func() *http.Request{
+		"body altered": func() *http.Request {
+			r := newRequest(http.MethodPost, "/api/send", "tok", `{"content":"hi"}`, key, now, "n")
+			r.Body = io.NopCloser(strings.NewReader(`{"content":"bye"}`))
+			return r
+		},
+		"query altered": func() *http.Request {
+			r := newRequest(http.MethodGet, "/api/poll?room=dev", "tok", "", key, now, "n")
+			r.URL.RawQuery = "room=ops"
+			r.RequestURI = r.URL.RequestURI()
+			return r
+		},
+		"another token's key": func() *http.Request {
+			return newRequest(http.MethodGet, "/api/poll", "other", "", key, now, "n")
+		},
+		"stale": func() *http.Request {
+			return newRequest(http.MethodGet, "/api/poll", "tok", "", key, now.Add(-SignatureWindow-time.Minute), "n")
+		},
+		"from the future": func() *http.Request {
+			return newRequest(http.MethodGet, "/api/poll", "tok", "", key, now.Add(SignatureWindow+time.Minute), "n")
+		},
+		"no nonce": func() *http.Request {
+			return newRequest(http.MethodGet, "/api/poll", "tok", "", key, now, "")
+		},
+		"nonce too long": func() *http.Request {
+			return newRequest(http.MethodGet, "/api/poll", "tok", "", key, now, strings.Repeat("n", maxNonceLen+1))
+		},
+		"bad timestamp": func() *http.Request {
+			r := newRequest(http.MethodGet, "/api/poll", "tok", "", key, now, "n")
+			r.Header.Set(HeaderTimestamp, "yesterday")
+			return r
+		},
+	}
+	for name, build := range tests {
+		// Signed requests are checked whether or not signing is required.
+		m := NewSigningMiddleware(testKeys{}, false, 1<<20)
+		if code, seen := serve(m.Wrap, build()); code != http.StatusUnauthorized || seen != "" {
+			t.Errorf("%s: got %d, handler reached: %v", name, code, seen != "")
+		}
+	}
+}
+
+func TestSigningRefusesReplay(t *testing.T) {
+	m := NewSigningMiddleware(testKeys{}, false, 1<<20)
+	key := testKeys{}.SigningKey("tok")
+	now := time.Now()
+	if code, _ := serve(m.Wrap, newRequest(http.MethodGet, "/api/poll", "tok", "", key, now, "once")); code != http.StatusOK {
+		t.Fatalf("first: got %d", code)
+	}
+	if code, _ := serve(m.Wrap, newRequest(http.MethodGet, "/api/poll", "tok", "", key, now, "once")); code != http.StatusUnauthorized {
+		t.Errorf("replay: got %d", code)
+	}
+}
+
+func TestSigningUnsigned(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		required bool
+		token    string
+		want     int
+	}{
+		{"optional", false, "tok", http.StatusOK},
+		{"optional, no bearer", false, "", http.StatusOK},
+		{"required", true, "tok", http.StatusUnauthorized},
+		{"required, no bearer", true, "", http.StatusUnauthorized},
+	} {
+		m := NewSigningMiddleware(testKeys{}, tc.required, 1<<20)
+		if code, _ := serve(m.Wrap, newRequest(http.MethodGet, "/api/poll", tc.token, "", nil, time.Time{}, "")); code != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, code, tc.want)
+		}
+	}
+}
+
+func TestSigningRequiredNeedsBearer(t *testing.T) {
+	// Signed, but with the token somewhere the signature doesn't cover.
+	m := NewSigningMiddleware(testKeys{}, true, 1<<20)
+	r := newRequest(http.MethodPost, "/api/typing", "", `{"token":"tok"}`, testKeys{}.SigningKey(""), time.Now(), "n")
+	if code, _ := serve(m.Wrap, r); code != http.StatusUnauthorized {
+		t.Errorf("got %d", code)
+	}
+}
+
+func TestSigningOptionsPasses(t *testing.T) {
+	m := NewSigningMiddleware(testKeys{}, true, 1<<20)
+	r := newRequest(http.MethodOptions, "/api/login", "", "", nil, time.Time{}, "")
+	if code, _ := serve(m.WrapAccess, r); code != http.StatusOK {
+		t.Errorf("got %d", code)
+	}
+}
+
+func TestSigningBodyTooLarge(t *testing.T) {
+	m := NewSigningMiddleware(testKeys{}, false, 16)
+	key := testKeys{}.SigningKey("tok")
+	r := newRequest(http.MethodPost, "/api/send", "tok", strings.Repeat("x", 17), key, time.Now(), "n")
+	if code, seen := serve(m.Wrap, r); code != http.StatusRequestEntityTooLarge || seen != "" {
+		t.Errorf("got %d, handler reached: %v", code, seen != "")
+	}
+}
+
+func TestSigningAccessKey(t *testing.T) {
+	m := NewSigningMiddleware(testKeys{}, true, 1<<20)
+	body := `{"username":"alice"}`
+	r := newRequest(http.MethodPost, "/api/login", "", body, testKeys{}.AccessSigningKey(), time.Now(), "a1")
+	if code, seen := serve(m.WrapAccess, r); code != http.StatusOK || seen != "read:"+body {
+		t.Errorf("access key: got %d, handler saw %q", code, seen)
+	}
+	r = newRequest(http.MethodPost, "/api/login", "", body, testKeys{}.SigningKey("tok"), time.Now(), "a2")
+	if code, _ := serve(m.WrapAccess, r); code != http.StatusUnauthorized {
+		t.Errorf("session key: got %d", code)
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                   "",
+		"Bearer abc":         "abc",
+		"Bearer  abc ":       "abc",
+		"bearer abc":         "",
+		"Basic YWxpY2U6cHc=": "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/poll", nil)
+		if header != "" {
+			r.Header.Set("Authorization", header)
+		}
+		if got := BearerToken(r); got != want {
+			t.Errorf("%q: got %q, want %q", header, got, want)
+		}
+	}
+}
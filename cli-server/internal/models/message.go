@@ -1,128 +1,558 @@
-package models
-
-import (
-	"encoding/json"
-	"sync"
-	"time"
-)
-
-type Message struct {
-	ID        string    `json:"id"`
-	Username  string    `json:"username"`
-	Content   string    `json:"content"`
-	Color     string    `json:"color"`
-	Timestamp time.Time `json:"timestamp"`
-	ExpireAt  time.Time `json:"-"`
-}
-
-func (m *Message) MarshalJSON() ([]byte, error) {
-	msgMap := map[string]interface{}{
-		m.Username:  m.Content,
-		"color":     m.Color,
-		"id":        m.ID,
-		"timestamp": m.Timestamp.Format(time.RFC3339),
-	}
-	return json.Marshal(msgMap)
-}
-
-func (m *Message) ToClientFormat() map[string]interface{} {
-	return map[string]interface{}{
-		m.Username: m.Content,
-		"color":    m.Color,
-		"id":       m.ID,
-	}
-}
-
-type MessageBuffer struct {
-	mu       sync.RWMutex
-	messages []*Message
-	maxSize  int
-	ttl      time.Duration
-}
-
-func NewMessageBuffer(maxSize int, ttl time.Duration) *MessageBuffer {
-	mb := &MessageBuffer{
-		messages: make([]*Message, 0, maxSize),
-		maxSize:  maxSize,
-		ttl:      ttl,
-	}
-
-	go mb.cleanupLoop()
-
-	return mb
-}
-
-func (mb *MessageBuffer) Add(msg *Message) {
-	mb.mu.Lock()
-	defer mb.mu.Unlock()
-
-	msg.ExpireAt = time.Now().Add(mb.ttl)
-	mb.messages = append(mb.messages, msg)
-
-	if len(mb.messages) > mb.maxSize {
-		mb.messages = mb.messages[1:]
-	}
-}
-
-func (mb *MessageBuffer) GetAfter(afterID string, limit int) []*Message {
-	mb.mu.RLock()
-	defer mb.mu.RUnlock()
-
-	if afterID == "" {
-		return mb.getLastMessages(limit)
-	}
-
-	startIdx := -1
-	for i, msg := range mb.messages {
-		if msg.ID == afterID {
-			startIdx = i + 1
-			break
-		}
-	}
-
-	if startIdx < 0 || startIdx >= len(mb.messages) {
-		return []*Message{}
-	}
-
-	result := make([]*Message, len(mb.messages)-startIdx)
-	copy(result, mb.messages[startIdx:])
-	return result
-}
-
-func (mb *MessageBuffer) getLastMessages(limit int) []*Message {
-	if len(mb.messages) == 0 {
-		return []*Message{}
-	}
-
-	if len(mb.messages) <= limit {
-		result := make([]*Message, len(mb.messages))
-		copy(result, mb.messages)
-		return result
-	}
-
-	result := make([]*Message, limit)
-	copy(result, mb.messages[len(mb.messages)-limit:])
-	return result
-}
-
-func (mb *MessageBuffer) cleanupLoop() {
-	ticker := time.NewTicker(10 * time.Second)
-	for range ticker.C {
-		mb.mu.Lock()
-		now := time.Now()
-		newMessages := make([]*Message, 0, len(mb.messages))
-		for _, msg := range mb.messages {
-			if msg.ExpireAt.After(now) {
-				newMessages = append(newMessages, msg)
-			}
-		}
-		mb.messages = newMessages
-		mb.mu.Unlock()
-	}
-}
-
-func (mb *MessageBuffer) Len() int {
-	mb.mu.RLock()
-	defer mb.mu.RUnlock()
-	return len(mb.messages)
-}
+package models
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// DefaultRoom is where messages go when the client names no room.
+const DefaultRoom = "lobby"
+
+// AnnouncementUser is the sender of relay announcements. It is not a valid
+// username, so no account can post under it.
+const AnnouncementUser = "#relay"
+
+type Message struct {
+	ID string `json:"id"`
+	// Seq numbers every message the relay keeps, one after the other
+	// across all rooms, from 1 at startup; see Cursor.
+	Seq       uint64    `json:"seq"`
+	Room      string    `json:"room"`
+	Username  string    `json:"username"`
+	To        string    `json:"to,omitempty"` // direct message recipient; "" = the whole room
+	Content   string    `json:"content"`
+	Sig       string    `json:"sig,omitempty"` // sender's Ed25519 signature; checked by clients, not here
+	Color     string    `json:"color"`
+	Timestamp time.Time `json:"timestamp"`
+	ExpireAt  time.Time `json:"-"`
+	// System marks an announcement from the operator; with Room "" it
+	// reaches every room.
+	System bool `json:"system,omitempty"`
+	// Fwd is set on a forwarded message: where the sender says it comes
+	// from. Relayed as is, like Sig.
+	Fwd *Forward `json:"fwd,omitempty"`
+	// Type is "" for an ordinary message or TypeAction.
+	Type string `json:"type,omitempty"`
+	// ReplyTo is the ID of the message this one answers, as the sender
+	// says; clients show an excerpt of it above the reply.
+	ReplyTo string `json:"reply_to,omitempty"`
+	// Origin links the copies of a cross-posted message: the ID of the
+	// first copy, the same in every room. AlsoIn lists the other rooms.
+	Origin string   `json:"origin,omitempty"`
+	AlsoIn []string `json:"also_in,omitempty"`
+	// Mentions lists the users the content @mentions, for clients to
+	// notify them without parsing the text; see services.ExtractMentions.
+	Mentions []string `json:"mentions,omitempty"`
+	// Nick is set on the announcement of a nick change (Type TypeNick).
+	Nick *NickChange `json:"nick,omitempty"`
+	// Reaction is set on a reaction event (Type TypeReaction).
+	Reaction *Reaction `json:"reaction,omitempty"`
+	// purged messages keep their place in the buffer, so poll cursors
+	// pointing at them stay valid, but nobody sees them.
+	purged bool
+}
+
+// TypeAction marks an IRC-style action, "/me waves": clients show it as
+// "* alice waves". Protocol version 1 has no field for it, so older clients
+// show the text as an ordinary message.
+const TypeAction = "action"
+
+// TypeNick marks the relay's announcement that a user changed their name;
+// Nick says who became whom. Protocol version 1 shows it as an ordinary
+// announcement.
+const TypeNick = "nick"
+
+// TypeReaction marks a reaction event: Username reacted to a message, see
+// Reaction. Protocol version 1 has no way to show one, so version 1 views
+// leave them out.
+const TypeReaction = "reaction"
+
+// Reaction is what a reaction event says: Emoji was added to, or with
+// Removed taken back from, message MessageID, and Totals is every reaction
+// the message has now, in the order they were first given.
+type Reaction struct {
+	MessageID string          `json:"message_id"`
+	Emoji     string          `json:"emoji"`
+	Removed   bool            `json:"removed,omitempty"`
+	Totals    []ReactionTotal `json:"totals"`
+}
+
+// ReactionTotal is who reacted to a message with one emoji.
+type ReactionTotal struct {
+	Emoji string   `json:"emoji"`
+	Users []string `json:"users"`
+}
+
+// NickChange is a user's old and new name.
+type NickChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Forward is the provenance of a forwarded message.
+type Forward struct {
+	From string    `json:"from"`           // original author
+	Room string    `json:"room,omitempty"` // where it was posted; "" = a direct message
+	At   time.Time `json:"at"`             // when
+}
+
+// Protocol versions of the message format. Version 1 keys a message by its
+// sender — {"alice": "hi", "color": ...} — which a user named like a field
+// ("color", "id") breaks; it stays for clients that ask for nothing newer.
+// Version 2, served under /api/v2, has a field per value, see WireMessage.
+const (
+	ProtocolV1 = 1
+	ProtocolV2 = 2
+	// ProtocolLatest is the newest version this relay speaks.
+	ProtocolLatest = ProtocolV2
+)
+
+// NegotiateProtocol returns the version to use with a client that asked for
+// requested: the newest both sides speak. 0 (a client from before versions)
+// gets ProtocolV1.
+func NegotiateProtocol(requested int) int {
+	switch {
+	case requested < ProtocolV1:
+		return ProtocolV1
+	case requested > ProtocolLatest:
+		return ProtocolLatest
+	}
+	return requested
+}
+
+// WireMessage is a message in protocol version 2.
+type WireMessage struct {
+	ID        string      `json:"id"`
+	Seq       uint64      `json:"seq"`
+	Username  string      `json:"username"`
+	Content   string      `json:"content"`
+	Color     string      `json:"color"`
+	Timestamp time.Time   `json:"timestamp"`
+	To        string      `json:"to,omitempty"`
+	Sig       string      `json:"sig,omitempty"`
+	System    bool        `json:"system,omitempty"`
+	Fwd       *Forward    `json:"fwd,omitempty"`
+	Type      string      `json:"type,omitempty"`
+	ReplyTo   string      `json:"reply_to,omitempty"`
+	Origin    string      `json:"origin,omitempty"`
+	AlsoIn    []string    `json:"also_in,omitempty"`
+	Mentions  []string    `json:"mentions,omitempty"`
+	Nick      *NickChange `json:"nick,omitempty"`
+	Reaction  *Reaction   `json:"reaction,omitempty"`
+}
+
+// ToWire returns m in protocol version 2.
+func (m *Message) ToWire() WireMessage {
+	return WireMessage{
+		ID:        m.ID,
+		Seq:       m.Seq,
+		Username:  m.Username,
+		Content:   m.Content,
+		Color:     m.Color,
+		Timestamp: m.Timestamp,
+		To:        m.To,
+		Sig:       m.Sig,
+		System:    m.System,
+		Fwd:       m.Fwd,
+		Type:      m.Type,
+		ReplyTo:   m.ReplyTo,
+		Origin:    m.Origin,
+		AlsoIn:    m.AlsoIn,
+		Mentions:  m.Mentions,
+		Nick:      m.Nick,
+		Reaction:  m.Reaction,
+	}
+}
+
+func (m *Message) MarshalJSON() ([]byte, error) {
+	msgMap := map[string]interface{}{
+		m.Username:  m.Content,
+		"color":     m.Color,
+		"id":        m.ID,
+		"timestamp": m.Timestamp.Format(time.RFC3339),
+	}
+	return json.Marshal(msgMap)
+}
+
+func (m *Message) ToClientFormat() map[string]interface{} {
+	entry := map[string]interface{}{
+		m.Username: m.Content,
+		"color":    m.Color,
+		"id":       m.ID,
+	}
+	if m.To != "" {
+		entry["to"] = m.To
+	}
+	if m.Sig != "" {
+		entry["sig"] = m.Sig
+	}
+	if m.System {
+		entry["system"] = true
+	}
+	if m.Fwd != nil {
+		entry["fwd"] = m.Fwd
+	}
+	return entry
+}
+
+// View is what one reader may see: the messages of Room, plus direct
+// messages from or to User in any room, and reaction events if Reactions is
+// set.
+type View struct {
+	Room      string
+	User      string
+	Reactions bool // protocol version 2 and later
+}
+
+// Sees reports whether m belongs in v.
+func (v View) Sees(m *Message) bool {
+	if m.purged || (m.Type == TypeReaction && !v.Reactions) {
+		return false
+	}
+	if m.System && m.Room == "" {
+		return true
+	}
+	if m.To != "" {
+		return v.User != "" && (m.To == v.User || m.Username == v.User)
+	}
+	return m.Room == v.Room
+}
+
+// Cursor is how far a reader has read: up to the message ID or, once that
+// message has expired, up to sequence number Seq. With Seq a reader also
+// learns when messages it never got have expired.
+type Cursor struct {
+	ID  string
+	Seq uint64
+}
+
+// Batch is one read from the buffer.
+type Batch struct {
+	Messages []*Message
+	// Through is the sequence number read up to — the reader's next
+	// Cursor.Seq — whether or not the messages were in its view.
+	Through uint64
+	// Gap reports that messages after the cursor expired (or the relay
+	// restarted) before they were read; some may have been in the view.
+	Gap bool
+}
+
+// Retention is how long a room's messages are kept and how many of them,
+// instead of the buffer's TTL and size.
+type Retention struct {
+	TTL         time.Duration // 0 = the buffer's
+	MaxMessages int           // 0 = only the buffer's size
+}
+
+// MessageBuffer keeps the newest maxSize messages in a fixed-size ring.
+// Messages are addressed by position i (0 = oldest held); byID maps a
+// message ID to its sequence number, so a cursor lookup is O(1) and stays
+// valid while the ring wraps.
+//
+// Rooms with a Retention of their own expire out of order. Their messages
+// are hidden like purged ones when their time or their room's count is up
+// and leave the ring when they reach its front; the ring's size still bounds
+// every room together.
+type MessageBuffer struct {
+	mu        sync.RWMutex
+	ring      []*Message
+	head      int    // ring index of the oldest message
+	count     int    // messages held
+	base      uint64 // sequence number of the oldest message
+	byID      map[string]uint64
+	maxSize   int
+	ttl       time.Duration
+	retention map[string]Retention // room → its own retention
+}
+
+func NewMessageBuffer(maxSize int, ttl time.Duration) *MessageBuffer {
+	if maxSize < 1 {
+		maxSize = 1
+	}
+	mb := &MessageBuffer{
+		ring:    make([]*Message, maxSize),
+		base:    1,
+		byID:    make(map[string]uint64, maxSize),
+		maxSize: maxSize,
+		ttl:     ttl,
+	}
+
+	go mb.cleanupLoop()
+
+	return mb
+}
+
+func (mb *MessageBuffer) Add(msg *Message) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	ttl, keep := mb.retentionLocked(msg.Room)
+	msg.ExpireAt = time.Now().Add(ttl)
+	if mb.count == mb.maxSize {
+		mb.dropOldestLocked()
+	}
+	msg.Seq = mb.base + uint64(mb.count)
+	mb.ring[(mb.head+mb.count)%mb.maxSize] = msg
+	mb.byID[msg.ID] = msg.Seq
+	mb.count++
+
+	if keep < mb.maxSize && msg.Type != TypeReaction {
+		// Hide the room's messages beyond its newest keep. Reaction
+		// events don't count.
+		kept := 0
+		for i := mb.count - 1; i >= 0; i-- {
+			if m := mb.at(i); !m.purged && m.Room == msg.Room && m.Type != TypeReaction {
+				if kept++; kept > keep {
+					mb.hideLocked(i)
+				}
+			}
+		}
+	}
+}
+
+// SetRetention gives rooms a retention of their own, replacing the earlier
+// ones. Messages already held keep their expiry.
+func (mb *MessageBuffer) SetRetention(rooms map[string]Retention) {
+	retention := make(map[string]Retention, len(rooms))
+	for room, r := range rooms {
+		retention[room] = r
+	}
+	mb.mu.Lock()
+	mb.retention = retention
+	mb.mu.Unlock()
+}
+
+// RetentionOf returns how long room's messages are kept and how many of
+// them at most.
+func (mb *MessageBuffer) RetentionOf(room string) (time.Duration, int) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+	return mb.retentionLocked(room)
+}
+
+// retentionLocked is RetentionOf. The caller holds mb.mu.
+func (mb *MessageBuffer) retentionLocked(room string) (time.Duration, int) {
+	ttl, keep := mb.ttl, mb.maxSize
+	if r, ok := mb.retention[room]; ok {
+		if r.TTL > 0 {
+			ttl = r.TTL
+		}
+		if r.MaxMessages > 0 && r.MaxMessages < keep {
+			keep = r.MaxMessages
+		}
+	}
+	return ttl, keep
+}
+
+// at returns the i-th oldest message held. The caller holds mb.mu.
+func (mb *MessageBuffer) at(i int) *Message {
+	return mb.ring[(mb.head+i)%mb.maxSize]
+}
+
+// indexOf returns the position of the message with id, or -1 if it is not
+// (or no longer) held. The caller holds mb.mu.
+func (mb *MessageBuffer) indexOf(id string) int {
+	seq, ok := mb.byID[id]
+	if !ok || seq < mb.base {
+		return -1
+	}
+	return int(seq - mb.base)
+}
+
+// dropOldestLocked removes the oldest message. The caller holds mb.mu.
+func (mb *MessageBuffer) dropOldestLocked() {
+	old := mb.ring[mb.head]
+	if seq, ok := mb.byID[old.ID]; ok && seq == mb.base {
+		delete(mb.byID, old.ID)
+	}
+	mb.ring[mb.head] = nil
+	mb.head = (mb.head + 1) % mb.maxSize
+	mb.base++
+	mb.count--
+}
+
+// Read returns up to limit messages in view after c. An empty cursor gets
+// the newest ones. A cursor ID that is no longer held falls back to c.Seq;
+// without one, nothing is returned, as clients from before sequence numbers
+// expect.
+func (mb *MessageBuffer) Read(c Cursor, view View, limit int) Batch {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	last := mb.base + uint64(mb.count) - 1 // newest sequence number given out
+	if c.ID == "" && c.Seq == 0 {
+		result, _ := mb.getLastMessages(view, mb.count, limit)
+		return Batch{Messages: result, Through: last}
+	}
+
+	b := Batch{Messages: []*Message{}, Through: last}
+	start := mb.count
+	if idx := mb.indexOf(c.ID); idx >= 0 {
+		start = idx + 1
+	} else if c.Seq > 0 {
+		switch {
+		case c.Seq > last: // numbered by a relay from before a restart
+			b.Gap, start = true, 0
+		case c.Seq+1 < mb.base:
+			b.Gap, start = true, 0
+		default:
+			start = int(c.Seq + 1 - mb.base)
+		}
+	}
+
+	for i := start; i < mb.count; i++ {
+		msg := mb.at(i)
+		if !view.Sees(msg) {
+			continue
+		}
+		b.Messages = append(b.Messages, msg)
+		if len(b.Messages) == limit {
+			b.Through = msg.Seq
+			break
+		}
+	}
+	return b
+}
+
+// GetBefore returns up to limit messages in view that precede beforeID
+// (or the newest ones when beforeID is empty), oldest first, and whether
+// older messages remain. An unknown beforeID yields nothing.
+func (mb *MessageBuffer) GetBefore(beforeID string, view View, limit int) ([]*Message, bool) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+
+	endIdx := mb.count
+	if beforeID != "" {
+		endIdx = mb.indexOf(beforeID)
+		if endIdx < 0 {
+			return []*Message{}, false
+		}
+	}
+	return mb.getLastMessages(view, endIdx, limit)
+}
+
+// getLastMessages collects the last limit messages in view among the
+// first endIdx messages held. The caller holds mb.mu.
+func (mb *MessageBuffer) getLastMessages(view View, endIdx, limit int) ([]*Message, bool) {
+	result := []*Message{}
+	i := endIdx - 1
+	for ; i >= 0 && len(result) < limit; i-- {
+		if msg := mb.at(i); view.Sees(msg) {
+			result = append(result, msg)
+		}
+	}
+	for l, r := 0, len(result)-1; l < r; l, r = l+1, r-1 {
+		result[l], result[r] = result[r], result[l]
+	}
+
+	more := false
+	for ; i >= 0; i-- {
+		if view.Sees(mb.at(i)) {
+			more = true
+			break
+		}
+	}
+	return result, more
+}
+
+// cleanupLoop drops expired messages. Without room retentions all messages
+// share one TTL, so they expire in the order they were added and only the
+// front of the ring needs checking; with them, expired messages behind the
+// front are hidden until they get there.
+func (mb *MessageBuffer) cleanupLoop() {
+	ticker := time.NewTicker(10 * time.Second)
+	for range ticker.C {
+		mb.mu.Lock()
+		now := time.Now()
+		for mb.count > 0 && !mb.at(0).ExpireAt.After(now) {
+			mb.dropOldestLocked()
+		}
+		if len(mb.retention) > 0 {
+			for i := 0; i < mb.count; i++ {
+				if m := mb.at(i); !m.purged && !m.ExpireAt.After(now) {
+					mb.hideLocked(i)
+				}
+			}
+		}
+		mb.mu.Unlock()
+	}
+}
+
+// hideLocked swaps the i-th oldest message for a content-less placeholder
+// that keeps its ID and place, so cursors stay valid and the placeholder
+// expires as usual. The caller holds mb.mu for writing.
+func (mb *MessageBuffer) hideLocked(i int) {
+	idx := (mb.head + i) % mb.maxSize
+	msg := mb.ring[idx]
+	// A new value rather than a change in place: pollers may still be
+	// encoding the old one.
+	mb.ring[idx] = &Message{ID: msg.ID, Seq: msg.Seq, Room: msg.Room, ExpireAt: msg.ExpireAt, purged: true}
+}
+
+// Purge hides every held message that match accepts (see hideLocked) and
+// returns how many.
+func (mb *MessageBuffer) Purge(match func(*Message) bool) int {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+
+	purged := 0
+	for i := 0; i < mb.count; i++ {
+		if msg := mb.at(i); msg.purged || !match(msg) {
+			continue
+		}
+		mb.hideLocked(i)
+		purged++
+	}
+	return purged
+}
+
+// Each calls fn for every held message nobody purged, oldest first. fn
+// runs under the buffer's read lock and must not call back into it.
+func (mb *MessageBuffer) Each(fn func(*Message)) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+	for i := 0; i < mb.count; i++ {
+		if msg := mb.at(i); !msg.purged {
+			fn(msg)
+		}
+	}
+}
+
+// Find returns the held message with id, or nil if it is not (or no
+// longer) held or was purged.
+func (mb *MessageBuffer) Find(id string) *Message {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+	if i := mb.indexOf(id); i >= 0 && !mb.at(i).purged {
+		return mb.at(i)
+	}
+	return nil
+}
+
+// Cap returns how many messages the buffer holds at most.
+func (mb *MessageBuffer) Cap() int {
+	return mb.maxSize
+}
+
+// Oldest returns the sequence number of the oldest message held: older
+// ones are gone.
+func (mb *MessageBuffer) Oldest() uint64 {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+	return mb.base
+}
+
+// Newest returns the sequence number of the newest message given out, 0
+// before the first: a Cursor with it reads only what comes after.
+func (mb *MessageBuffer) Newest() uint64 {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+	return mb.base + uint64(mb.count) - 1
+}
+
+func (mb *MessageBuffer) Len() int {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+	return mb.count
+}
@@ -0,0 +1,303 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/storage"
+	"secure-chat-backend/internal/utils"
+)
+
+// An archive is a relay's state as JSON lines, to move it to another host
+// or storage backend: an ArchiveHeader, then one ArchiveRecord per account,
+// ban, draft and message, and a last "end" record with the counts, so a
+// cut-off archive is refused rather than half imported. Accounts, bans and
+// drafts come from the storage backend; messages only live in a running
+// relay's buffer, so only GET /api/admin/export has them. Rooms are not
+// stored on their own — they exist through their messages.
+
+// ArchiveFormat and ArchiveVersion identify an archive in its header.
+const (
+	ArchiveFormat  = "ttc-archive"
+	ArchiveVersion = 1
+)
+
+var (
+	ErrNotArchive       = errors.New("not a ttc-archive (version 1) file")
+	ErrArchiveTruncated = errors.New("archive is cut off: no end record, or not the counts it says")
+)
+
+// ArchiveHeader is the first line of an archive.
+type ArchiveHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ArchiveRecord is one line after the header. Kind says which of the other
+// fields is set.
+type ArchiveRecord struct {
+	Kind    string           `json:"kind"` // "account", "ban", "draft", "message" or "end"
+	Account *Account         `json:"account,omitempty"`
+	Ban     *Ban             `json:"ban,omitempty"`
+	Draft   *ArchivedDraft   `json:"draft,omitempty"`
+	Message *ArchivedMessage `json:"message,omitempty"`
+	End     *ArchiveCounts   `json:"end,omitempty"`
+}
+
+// ArchivedDraft is one user's draft for one room.
+type ArchivedDraft struct {
+	Username string `json:"username"`
+	Room     string `json:"room"`
+	Draft
+}
+
+// ArchivedMessage is a message with its room; the relay gives it a new
+// sequence number on import.
+type ArchivedMessage struct {
+	Room string `json:"room"`
+	models.WireMessage
+}
+
+// ArchiveCounts says how many records of each kind an export wrote or an
+// import read.
+type ArchiveCounts struct {
+	Accounts int `json:"accounts"`
+	Bans     int `json:"bans"`
+	Drafts   int `json:"drafts"`
+	Messages int `json:"messages"`
+}
+
+func (c ArchiveCounts) String() string {
+	return fmt.Sprintf("%d accounts, %d bans, %d drafts, %d messages", c.Accounts, c.Bans, c.Drafts, c.Messages)
+}
+
+// archiveState is what an archive holds, as the storage backend keeps it.
+type archiveState struct {
+	accounts []*Account
+	bans     []Ban
+	drafts   map[string]map[string]Draft
+}
+
+func loadArchiveState(store storage.Store) (*archiveState, error) {
+	st := &archiveState{drafts: make(map[string]map[string]Draft)}
+	for key, v := range map[string]interface{}{usersKey: &st.accounts, bansKey: &st.bans, draftsKey: &st.drafts} {
+		if err := store.Load(key, v); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("load %s: %w", key, err)
+		}
+	}
+	if st.drafts == nil {
+		st.drafts = make(map[string]map[string]Draft)
+	}
+	return st, nil
+}
+
+// ExportArchive writes store's accounts, bans and drafts and, unless buffer
+// is nil, its messages nobody purged to w.
+func ExportArchive(w io.Writer, store storage.Store, buffer *models.MessageBuffer) (ArchiveCounts, error) {
+	var counts ArchiveCounts
+	st, err := loadArchiveState(store)
+	if err != nil {
+		return counts, err
+	}
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(ArchiveHeader{Format: ArchiveFormat, Version: ArchiveVersion, CreatedAt: time.Now().UTC()}); err != nil {
+		return counts, err
+	}
+	sort.Slice(st.accounts, func(i, j int) bool { return st.accounts[i].Username < st.accounts[j].Username })
+	for _, a := range st.accounts {
+		if err := enc.Encode(ArchiveRecord{Kind: "account", Account: a}); err != nil {
+			return counts, err
+		}
+		counts.Accounts++
+	}
+	for i := range st.bans {
+		if err := enc.Encode(ArchiveRecord{Kind: "ban", Ban: &st.bans[i]}); err != nil {
+			return counts, err
+		}
+		counts.Bans++
+	}
+	users := make([]string, 0, len(st.drafts))
+	for u := range st.drafts {
+		users = append(users, u)
+	}
+	sort.Strings(users)
+	for _, u := range users {
+		rooms := make([]string, 0, len(st.drafts[u]))
+		for room := range st.drafts[u] {
+			rooms = append(rooms, room)
+		}
+		sort.Strings(rooms)
+		for _, room := range rooms {
+			d := ArchivedDraft{Username: u, Room: room, Draft: st.drafts[u][room]}
+			if err := enc.Encode(ArchiveRecord{Kind: "draft", Draft: &d}); err != nil {
+				return counts, err
+			}
+			counts.Drafts++
+		}
+	}
+
+	if buffer != nil {
+		// Copy first: Each holds the buffer's lock, and w may be a slow client.
+		var msgs []ArchivedMessage
+		buffer.Each(func(m *models.Message) {
+			msgs = append(msgs, ArchivedMessage{Room: m.Room, WireMessage: m.ToWire()})
+		})
+		for i := range msgs {
+			if err := enc.Encode(ArchiveRecord{Kind: "message", Message: &msgs[i]}); err != nil {
+				return counts, err
+			}
+			counts.Messages++
+		}
+	}
+	return counts, enc.Encode(ArchiveRecord{Kind: "end", End: &counts})
+}
+
+// ImportArchive reads an archive from r into store and buffer: accounts,
+// bans and drafts replace those of the same user (or ban target) already
+// there, messages are appended to buffer. The whole archive is checked
+// before anything is written; an error names the offending line. Run it
+// before the services load store.
+func ImportArchive(r io.Reader, store storage.Store, buffer *models.MessageBuffer) (ArchiveCounts, error) {
+	var counts ArchiveCounts
+	st, err := loadArchiveState(store)
+	if err != nil {
+		return counts, err
+	}
+	accounts := make(map[string]*Account, len(st.accounts))
+	for _, a := range st.accounts {
+		accounts[a.Username] = a
+	}
+	var msgs []*models.Message
+	var end *ArchiveCounts
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 4<<20)
+	n := 0
+	for scanner.Scan() {
+		n++
+		if n == 1 {
+			var h ArchiveHeader
+			if json.Unmarshal(scanner.Bytes(), &h) != nil || h.Format != ArchiveFormat || h.Version != ArchiveVersion {
+				return counts, ErrNotArchive
+			}
+			continue
+		}
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if end != nil {
+			return counts, fmt.Errorf("line %d: record after the end record", n)
+		}
+		var rec ArchiveRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return counts, fmt.Errorf("line %d: %w", n, err)
+		}
+		if rec.Kind == "end" && rec.End != nil {
+			end = rec.End
+			continue
+		}
+		if err := st.apply(rec, accounts, &msgs, &counts); err != nil {
+			return counts, fmt.Errorf("line %d: %w", n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return counts, err
+	}
+	if n == 0 {
+		return counts, ErrNotArchive
+	}
+	if end == nil || *end != counts {
+		return counts, ErrArchiveTruncated
+	}
+
+	st.accounts = st.accounts[:0]
+	for _, a := range accounts {
+		st.accounts = append(st.accounts, a)
+	}
+	for key, v := range map[string]interface{}{usersKey: st.accounts, bansKey: st.bans, draftsKey: st.drafts} {
+		if err := store.Save(key, v); err != nil {
+			return counts, fmt.Errorf("save %s: %w", key, err)
+		}
+	}
+	for _, m := range msgs {
+		buffer.Add(m)
+	}
+	return counts, nil
+}
+
+// apply checks rec and merges it into st, accounts or msgs.
+func (st *archiveState) apply(rec ArchiveRecord, accounts map[string]*Account, msgs *[]*models.Message, counts *ArchiveCounts) error {
+	switch {
+	case rec.Kind == "account" && rec.Account != nil:
+		a := rec.Account
+		if !validUsername.MatchString(a.Username) {
+			return ErrInvalidUsername
+		}
+		accounts[a.Username] = a
+		counts.Accounts++
+
+	case rec.Kind == "ban" && rec.Ban != nil:
+		b := *rec.Ban
+		if (b.Username == "") == (b.ClientID == "") {
+			return ErrEmptyBan
+		}
+		kept := st.bans[:0:0]
+		for _, old := range st.bans {
+			if (b.Username != "" && old.Username == b.Username) || (b.ClientID != "" && old.ClientID == b.ClientID) {
+				continue
+			}
+			kept = append(kept, old)
+		}
+		st.bans = append(kept, b)
+		counts.Bans++
+
+	case rec.Kind == "draft" && rec.Draft != nil:
+		d := rec.Draft
+		if !validUsername.MatchString(d.Username) || !utils.ValidateRoom(d.Room) {
+			return fmt.Errorf("draft needs a valid username and room")
+		}
+		if st.drafts[d.Username] == nil {
+			st.drafts[d.Username] = make(map[string]Draft)
+		}
+		st.drafts[d.Username][d.Room] = d.Draft
+		counts.Drafts++
+
+	case rec.Kind == "message" && rec.Message != nil:
+		m := rec.Message
+		if m.ID == "" || m.Username == "" || (m.Room != "" && !utils.ValidateRoom(m.Room)) {
+			return fmt.Errorf("message needs an id, a username and a valid room or none")
+		}
+		*msgs = append(*msgs, &models.Message{
+			ID:        m.ID,
+			Room:      m.Room,
+			Username:  m.Username,
+			To:        m.To,
+			Content:   m.Content,
+			Sig:       m.Sig,
+			Color:     m.Color,
+			Timestamp: m.Timestamp,
+			System:    m.System,
+			Fwd:       m.Fwd,
+			Type:      m.Type,
+			ReplyTo:   m.ReplyTo,
+			Origin:    m.Origin,
+			AlsoIn:    m.AlsoIn,
+			Mentions:  m.Mentions,
+			Nick:      m.Nick,
+			Reaction:  m.Reaction,
+		})
+		counts.Messages++
+
+	default:
+		return fmt.Errorf("unknown record kind %q", rec.Kind)
+	}
+	return nil
+}
@@ -1,98 +1,665 @@
-package services
-
-import (
-	"sync"
-	"time"
-
-	"golang.org/x/time/rate"
-)
-
-type AuthService struct {
-	accessKey    string
-	mu           sync.RWMutex
-	clients      map[string]*ClientInfo
-	rateLimiters map[string]*rate.Limiter
-	rateLimit    rate.Limit
-	rateBurst    int
-}
-
-type ClientInfo struct {
-	ID           string
-	FirstSeen    time.Time
-	LastSeen     time.Time
-	MessageCount int64
-}
-
-func NewAuthService(accessKey string) *AuthService {
-	return &AuthService{
-		accessKey:    accessKey,
-		clients:      make(map[string]*ClientInfo),
-		rateLimiters: make(map[string]*rate.Limiter),
-		rateLimit:    10,
-		rateBurst:    20,
-	}
-}
-
-func (s *AuthService) ValidateAccess(key, clientID string) bool {
-	if key != s.accessKey {
-		return false
-	}
-
-	if clientID == "" {
-		return false
-	}
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	now := time.Now()
-	if client, exists := s.clients[clientID]; exists {
-		client.LastSeen = now
-		client.MessageCount++
-	} else {
-		s.clients[clientID] = &ClientInfo{
-			ID:           clientID,
-			FirstSeen:    now,
-			LastSeen:     now,
-			MessageCount: 1,
-		}
-		s.rateLimiters[clientID] = rate.NewLimiter(s.rateLimit, s.rateBurst)
-	}
-
-	return true
-}
-
-func (s *AuthService) CheckRateLimit(clientID string) bool {
-	s.mu.RLock()
-	limiter, exists := s.rateLimiters[clientID]
-	s.mu.RUnlock()
-
-	if !exists {
-		return true
-	}
-
-	return limiter.Allow()
-}
-
-func (s *AuthService) CleanupOldClients(maxAge time.Duration) {
-	ticker := time.NewTicker(5 * time.Minute)
-	go func() {
-		for range ticker.C {
-			s.mu.Lock()
-			now := time.Now()
-			for id, client := range s.clients {
-				if now.Sub(client.LastSeen) > maxAge {
-					delete(s.clients, id)
-					delete(s.rateLimiters, id)
-				}
-			}
-			s.mu.Unlock()
-		}
-	}()
-}
-
-func (s *AuthService) GetClientCount() int {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return len(s.clients)
-}
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrInvalidToken       = errors.New("invalid session token")
+	ErrTokenExpired       = errors.New("session token expired")
+	ErrBadAccessKey       = errors.New("invalid access key or client id")
+	ErrBadObserver        = errors.New("an observer needs a name (letters, digits, '_', '.', '-') and a room")
+	ErrBadWebhook         = errors.New("a webhook needs a name (letters, digits, '_', '.', '-') and a room")
+	ErrNickTooSoon        = errors.New("nick changed less than a minute ago")
+)
+
+type AuthService struct {
+	accessKey    string
+	tokenSecret  []byte
+	tokenTTL     time.Duration
+	mu           sync.RWMutex
+	clients      map[string]*ClientInfo
+	rateLimiters map[string]*rate.Limiter
+	rateLimit    rate.Limit
+	rateBurst    int
+
+	// presence: username → client_id → last activity. Every send/poll
+	// refreshes it, so an idle long-poll keeps a client online.
+	presence map[string]map[string]time.Time
+	// active: username → last message or typing event, for the users in
+	// presence — unlike a poll, it means someone is at the keyboard.
+	active map[string]time.Time
+
+	// bans shut users and clients out (nil = none); kicked ends the
+	// sessions a user was issued before the kick.
+	bans   *BanList
+	kicked map[string]time.Time
+	// renamed: new username → when the user took it (see CheckNick).
+	renamed map[string]time.Time
+
+	// sandbox turns authentication and rate limits off (SetSandbox).
+	sandbox bool
+}
+
+// PresenceTimeout is how long a client counts as online after its last
+// request. It must outlast the 30s long-poll so a waiting client stays online.
+const PresenceTimeout = 60 * time.Second
+
+// OnlineUser is one entry of the presence list.
+type OnlineUser struct {
+	Username string    `json:"username"`
+	Clients  int       `json:"clients"`
+	LastSeen time.Time `json:"last_seen"`
+	// LastActive is when the user last sent a message or typed; nil if
+	// not since they came online.
+	LastActive *time.Time `json:"last_active,omitempty"`
+}
+
+// Session is the identity carried by a signed session token.
+type Session struct {
+	Username  string `json:"u"`
+	ClientID  string `json:"c"`
+	IssuedAt  int64  `json:"iat,omitempty"` // Unix milliseconds
+	ExpiresAt int64  `json:"exp"`
+	Scope     string `json:"scope,omitempty"` // "" = a logged-in user, ScopeRead = an observer, ScopeWebhook = a webhook
+	Room      string `json:"room,omitempty"`  // the one room an observer may read or a webhook posts to
+}
+
+// ScopeRead marks an observer token: it may poll and page through the
+// history of one room, and nothing else — no sending, typing, presence,
+// keys or files. Dashboards, loggers and bridges use it.
+const ScopeRead = "read"
+
+// ObserverPrefix starts every observer's username. No account can have it,
+// so an observer never reads anyone's direct messages.
+const ObserverPrefix = "observer:"
+
+// MaxObserverTTL caps how long an observer token lasts.
+const MaxObserverTTL = 90 * 24 * time.Hour
+
+// ScopeWebhook marks a webhook token: it posts plain text to one room
+// through /api/webhook/{token}, and nothing else — it can't read, not even
+// its own room. CI servers and alerting post with it.
+const ScopeWebhook = "webhook"
+
+// WebhookPrefix starts every webhook's username, so no webhook posts as a
+// user and everyone can tell its messages from a person's.
+const WebhookPrefix = "hook:"
+
+// MaxWebhookTTL caps how long a webhook token lasts, and is how long it
+// lasts unless asked for less: the systems that post with one are set up
+// once and left alone.
+const MaxWebhookTTL = 365 * 24 * time.Hour
+
+type ClientInfo struct {
+	ID           string
+	Username     string // who last used the client; "" until it logs in
+	FirstSeen    time.Time
+	LastSeen     time.Time
+	MessageCount int64 // requests
+	Sent         int64 // chat messages (MarkSent)
+	RateLimited  int64 // requests CheckRateLimit refused
+}
+
+// UserActivity is what the relay knows about one user, for /api/whois. It
+// is built from ClientInfo, which is in memory only: FirstSeen is the
+// first request since the relay started, and clients idle for longer than
+// CleanupOldClients' maxAge are forgotten.
+type UserActivity struct {
+	Username   string     `json:"username"`
+	Online     bool       `json:"online"`
+	Clients    int        `json:"clients"` // online now
+	FirstSeen  *time.Time `json:"first_seen,omitempty"`
+	LastSeen   *time.Time `json:"last_seen,omitempty"`
+	LastActive *time.Time `json:"last_active,omitempty"` // as in OnlineUser
+	Messages   int64      `json:"messages"`
+}
+
+// NewAuthService creates the auth service. An empty tokenSecret generates a
+// random one, which means session tokens do not survive a server restart.
+func NewAuthService(accessKey string, tokenSecret []byte, tokenTTL time.Duration) *AuthService {
+	if len(tokenSecret) == 0 {
+		tokenSecret = make([]byte, 32)
+		if _, err := rand.Read(tokenSecret); err != nil {
+			panic("auth: cannot generate token secret: " + err.Error())
+		}
+	}
+	return &AuthService{
+		accessKey:    accessKey,
+		tokenSecret:  tokenSecret,
+		tokenTTL:     tokenTTL,
+		clients:      make(map[string]*ClientInfo),
+		rateLimiters: make(map[string]*rate.Limiter),
+		rateLimit:    10,
+		rateBurst:    20,
+		presence:     make(map[string]map[string]time.Time),
+		active:       make(map[string]time.Time),
+		kicked:       make(map[string]time.Time),
+		renamed:      make(map[string]time.Time),
+	}
+}
+
+// SetBanList installs the bans ValidateAccess and ValidateSession enforce.
+func (s *AuthService) SetBanList(bans *BanList) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bans = bans
+}
+
+// SandboxGuest is who a request without a valid session token acts as on a
+// sandbox relay.
+const SandboxGuest = "guest"
+
+// SetSandbox turns authentication off for -sandbox: any access key passes,
+// a request without a valid session token acts as SandboxGuest, and rate
+// limits are not enforced. Call it before serving.
+func (s *AuthService) SetSandbox(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sandbox = on
+}
+
+// CheckAccessKey reports whether key is the relay's access key; on a
+// sandbox relay any key is.
+func (s *AuthService) CheckAccessKey(key string) bool {
+	return s.sandbox || subtle.ConstantTimeCompare([]byte(key), []byte(s.accessKey)) == 1
+}
+
+// ValidateAccess checks the access key presented at login or registration
+// and that neither username nor clientID is banned. It returns
+// ErrBadAccessKey or ErrBanned (with the ban's reason).
+func (s *AuthService) ValidateAccess(key, clientID, username string) error {
+	if s.sandbox {
+		s.touchClient(clientID, "")
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(s.accessKey)) != 1 {
+		return ErrBadAccessKey
+	}
+	return s.AdmitClient(clientID, username)
+}
+
+// AdmitClient is ValidateAccess without the access key, for backends that
+// don't use one: clientID must be set and neither it nor username banned.
+// username may be "" when it isn't known yet.
+func (s *AuthService) AdmitClient(clientID, username string) error {
+	if clientID == "" {
+		return ErrBadAccessKey
+	}
+
+	if err := s.checkBan(username, clientID); err != nil {
+		return err
+	}
+
+	s.touchClient(clientID, "") // no session yet: the password is unchecked
+	return nil
+}
+
+func (s *AuthService) checkBan(username, clientID string) error {
+	s.mu.RLock()
+	bans := s.bans
+	s.mu.RUnlock()
+	if bans == nil {
+		return nil
+	}
+	return bans.Check(username, clientID)
+}
+
+// ValidateSession checks a session token presented on send/poll and records
+// the client's activity like ValidateAccess does for the access key. Tokens
+// of banned users or clients and of kicked sessions are refused, and so are
+// observer and webhook tokens — see ValidateReader and ValidateWebhook.
+func (s *AuthService) ValidateSession(token string) (*Session, bool) {
+	session, ok := s.checkSession(token)
+	if !ok || session.Scope != "" {
+		return nil, false
+	}
+	s.touchPresence(session.Username, session.ClientID)
+	return session, true
+}
+
+// ValidateReader is ValidateSession for reading room's stream (poll and
+// history): it also accepts an observer token issued for room. Observers
+// are not listed as online.
+func (s *AuthService) ValidateReader(token, room string) (*Session, bool) {
+	session, ok := s.checkSession(token)
+	if !ok {
+		return nil, false
+	}
+	switch session.Scope {
+	case "":
+		s.touchPresence(session.Username, session.ClientID)
+	case ScopeRead:
+		if session.Room != room {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+	return session, true
+}
+
+// ValidateWebhook checks a webhook token (see ScopeWebhook). Webhooks are
+// not listed as online.
+func (s *AuthService) ValidateWebhook(token string) (*Session, bool) {
+	session, ok := s.checkSession(token)
+	if !ok || session.Scope != ScopeWebhook {
+		return nil, false
+	}
+	return session, true
+}
+
+// checkSession verifies token and refuses banned and kicked sessions.
+func (s *AuthService) checkSession(token string) (*Session, bool) {
+	session, err := s.ValidateToken(token)
+	if err != nil && s.sandbox {
+		session, err = &Session{Username: SandboxGuest, ClientID: SandboxGuest}, nil
+	}
+	if err != nil {
+		return nil, false
+	}
+	if s.checkBan(session.Username, session.ClientID) != nil {
+		return nil, false
+	}
+	s.mu.RLock()
+	kickedAt, kicked := s.kicked[session.Username]
+	s.mu.RUnlock()
+	if kicked && session.IssuedAt < kickedAt.UnixMilli() {
+		return nil, false
+	}
+	s.touchClient(session.ClientID, session.Username)
+	return session, true
+}
+
+func (s *AuthService) touchPresence(username, clientID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clients, ok := s.presence[username]
+	if !ok {
+		clients = make(map[string]time.Time)
+		s.presence[username] = clients
+	}
+	clients[clientID] = time.Now()
+}
+
+// MarkActive records that username just sent a message or typed.
+func (s *AuthService) MarkActive(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active[username] = time.Now()
+}
+
+// MarkSent is MarkActive for a chat message, which also counts towards
+// the messages of session's client.
+func (s *AuthService) MarkSent(session *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active[session.Username] = time.Now()
+	if client, ok := s.clients[session.ClientID]; ok {
+		client.Sent++
+	}
+}
+
+// Whois sums up the clients username used and its presence.
+func (s *AuthService) Whois(username string) UserActivity {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prunePresenceLocked(time.Now())
+	u := UserActivity{Username: username}
+	for _, client := range s.clients {
+		if client.Username != username {
+			continue
+		}
+		if u.FirstSeen == nil || client.FirstSeen.Before(*u.FirstSeen) {
+			first := client.FirstSeen
+			u.FirstSeen = &first
+		}
+		if u.LastSeen == nil || client.LastSeen.After(*u.LastSeen) {
+			last := client.LastSeen
+			u.LastSeen = &last
+		}
+		u.Messages += client.Sent
+	}
+	if clients, ok := s.presence[username]; ok {
+		u.Online = true
+		u.Clients = len(clients)
+	}
+	if at, ok := s.active[username]; ok {
+		u.LastActive = &at
+	}
+	return u
+}
+
+// OnlineUsers lists users with at least one client active within
+// PresenceTimeout, sorted by username. Stale clients are dropped on the way.
+func (s *AuthService) OnlineUsers() []OnlineUser {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prunePresenceLocked(time.Now())
+	users := make([]OnlineUser, 0, len(s.presence))
+	for username, clients := range s.presence {
+		u := OnlineUser{Username: username, Clients: len(clients)}
+		for _, seen := range clients {
+			if seen.After(u.LastSeen) {
+				u.LastSeen = seen
+			}
+		}
+		if at, ok := s.active[username]; ok {
+			u.LastActive = &at
+		}
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users
+}
+
+func (s *AuthService) prunePresenceLocked(now time.Time) {
+	for username, clients := range s.presence {
+		for id, seen := range clients {
+			if now.Sub(seen) > PresenceTimeout {
+				delete(clients, id)
+			}
+		}
+		if len(clients) == 0 {
+			delete(s.presence, username)
+			delete(s.active, username)
+		}
+	}
+}
+
+func (s *AuthService) touchClient(clientID, username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if client, exists := s.clients[clientID]; exists {
+		client.LastSeen = now
+		client.MessageCount++
+		if username != "" && client.Username != username {
+			// Another account on the same machine starts afresh.
+			client.Username, client.FirstSeen, client.Sent = username, now, 0
+		}
+	} else {
+		s.clients[clientID] = &ClientInfo{
+			ID:           clientID,
+			Username:     username,
+			FirstSeen:    now,
+			LastSeen:     now,
+			MessageCount: 1,
+		}
+		s.rateLimiters[clientID] = rate.NewLimiter(s.rateLimit, s.rateBurst)
+	}
+}
+
+// IssueToken signs a session token for a logged-in user.
+// Format: base64url(payload JSON) "." base64url(HMAC-SHA256(payload)).
+func (s *AuthService) IssueToken(username, clientID string) (string, time.Time, error) {
+	return s.issue(Session{Username: username, ClientID: clientID}, s.tokenTTL)
+}
+
+// IssueObserverToken signs a read-only token for room (see ScopeRead) under
+// the username ObserverPrefix+name, lasting ttl — the session TTL when 0,
+// at most MaxObserverTTL. Kicking or banning that username revokes it.
+func (s *AuthService) IssueObserverToken(name, room string, ttl time.Duration) (string, *Session, time.Time, error) {
+	if !validUsername.MatchString(name) || room == "" {
+		return "", nil, time.Time{}, ErrBadObserver
+	}
+	if ttl <= 0 {
+		ttl = s.tokenTTL
+	}
+	if ttl > MaxObserverTTL {
+		ttl = MaxObserverTTL
+	}
+	session := Session{
+		Username: ObserverPrefix + name,
+		ClientID: "observer_" + name,
+		Scope:    ScopeRead,
+		Room:     room,
+	}
+	token, expiresAt, err := s.issue(session, ttl)
+	return token, &session, expiresAt, err
+}
+
+// IssueWebhookToken signs a token that posts to room (see ScopeWebhook)
+// under the username WebhookPrefix+name, lasting ttl — MaxWebhookTTL when 0
+// or more. Kicking or banning that username revokes it.
+func (s *AuthService) IssueWebhookToken(name, room string, ttl time.Duration) (string, *Session, time.Time, error) {
+	if !validUsername.MatchString(name) || room == "" {
+		return "", nil, time.Time{}, ErrBadWebhook
+	}
+	if ttl <= 0 || ttl > MaxWebhookTTL {
+		ttl = MaxWebhookTTL
+	}
+	session := Session{
+		Username: WebhookPrefix + name,
+		ClientID: "webhook_" + name,
+		Scope:    ScopeWebhook,
+		Room:     room,
+	}
+	token, expiresAt, err := s.issue(session, ttl)
+	return token, &session, expiresAt, err
+}
+
+func (s *AuthService) issue(session Session, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	session.IssuedAt = now.UnixMilli()
+	session.ExpiresAt = expiresAt.Unix()
+	payload, err := json.Marshal(session)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.sign(encoded), expiresAt, nil
+}
+
+// ValidateToken verifies the signature and expiry of a session token.
+func (s *AuthService) ValidateToken(token string) (*Session, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(encoded))) {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var session Session
+	if err := json.Unmarshal(payload, &session); err != nil || session.Username == "" {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() >= session.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	return &session, nil
+}
+
+func (s *AuthService) sign(encoded string) string {
+	mac := hmac.New(sha256.New, s.tokenSecret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SigningKey is the key requests made with token are signed with (see
+// middleware.SigningMiddleware). It is derived from the token, so nothing is
+// stored, yet only the relay and the client that logged in know it — a
+// token read from a proxy log is no use without it.
+func (s *AuthService) SigningKey(token string) []byte {
+	mac := hmac.New(sha256.New, s.tokenSecret)
+	mac.Write([]byte("request-signing\x00" + token))
+	return mac.Sum(nil)
+}
+
+// AccessSigningKey is the key register and login requests are signed with.
+func (s *AuthService) AccessSigningKey() []byte {
+	return AccessSigningKey(s.accessKey)
+}
+
+// AccessSigningKey derives the signing key for requests made before login
+// from the access key; clients derive the same.
+func AccessSigningKey(accessKey string) []byte {
+	mac := hmac.New(sha256.New, []byte(accessKey))
+	mac.Write([]byte("request-signing"))
+	return mac.Sum(nil)
+}
+
+// EncodedSigningKey is SigningKey as sent to the client, base64url.
+func (s *AuthService) EncodedSigningKey(token string) string {
+	return base64.RawURLEncoding.EncodeToString(s.SigningKey(token))
+}
+
+// Kick ends every session username holds now; logging in again works.
+// Kicks are kept in memory only, like the rate limiters.
+func (s *AuthService) Kick(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.kicked[username] = time.Now()
+	delete(s.presence, username)
+	delete(s.active, username)
+}
+
+// NickCooldown is how long a user must keep a name before changing it
+// again, so nick changes can't flood every room with announcements.
+const NickCooldown = time.Minute
+
+// CheckNick reports whether session's user may now be renamed to nick:
+// ErrBanned if nick is banned, ErrNickTooSoon within NickCooldown of the
+// last change. Whether nick is free is for UserService.Rename to say.
+func (s *AuthService) CheckNick(session *Session, nick string) error {
+	if err := s.checkBan(nick, session.ClientID); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if at, ok := s.renamed[session.Username]; ok && time.Since(at) < NickCooldown {
+		return ErrNickTooSoon
+	}
+	return nil
+}
+
+// Rename moves session's user to nick once the account has been renamed:
+// presence and activity follow, every session issued to the old name ends
+// as on Kick, and a token for nick is issued to session's client.
+func (s *AuthService) Rename(session *Session, nick string) (string, time.Time, error) {
+	s.mu.Lock()
+	now := time.Now()
+	old := session.Username
+	if clients, ok := s.presence[old]; ok {
+		s.presence[nick] = clients
+		delete(s.presence, old)
+	}
+	if at, ok := s.active[old]; ok {
+		s.active[nick] = at
+		delete(s.active, old)
+	}
+	for _, client := range s.clients {
+		if client.Username == old {
+			client.Username = nick // keeps its counts for /api/whois
+		}
+	}
+	s.kicked[old] = now
+	s.renamed[nick] = now
+	s.mu.Unlock()
+
+	return s.IssueToken(nick, session.ClientID)
+}
+
+// SetRateLimit changes the per-client send rate, for new and existing
+// clients alike.
+func (s *AuthService) SetRateLimit(limit float64, burst int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimit, s.rateBurst = rate.Limit(limit), burst
+	for _, limiter := range s.rateLimiters {
+		limiter.SetLimit(s.rateLimit)
+		limiter.SetBurst(burst)
+	}
+}
+
+func (s *AuthService) CheckRateLimit(clientID string) bool {
+	if s.sandbox {
+		return true
+	}
+	s.mu.RLock()
+	limiter, exists := s.rateLimiters[clientID]
+	s.mu.RUnlock()
+
+	if !exists {
+		return true
+	}
+
+	if limiter.Allow() {
+		return true
+	}
+	s.mu.Lock()
+	if client, ok := s.clients[clientID]; ok {
+		client.RateLimited++
+	}
+	s.mu.Unlock()
+	return false
+}
+
+func (s *AuthService) CleanupOldClients(maxAge time.Duration) {
+	ticker := time.NewTicker(5 * time.Minute)
+	go func() {
+		for range ticker.C {
+			s.mu.Lock()
+			now := time.Now()
+			for id, client := range s.clients {
+				if now.Sub(client.LastSeen) > maxAge {
+					delete(s.clients, id)
+					delete(s.rateLimiters, id)
+				}
+			}
+			s.prunePresenceLocked(now)
+			// Observer and webhook tokens may outlive sessions: a kick
+			// must outlast the longest of them.
+			keep := max(s.tokenTTL, MaxObserverTTL, MaxWebhookTTL)
+			for username, at := range s.kicked {
+				if now.Sub(at) > keep {
+					delete(s.kicked, username) // every token it ended has expired
+				}
+			}
+			for username, at := range s.renamed {
+				if now.Sub(at) > NickCooldown {
+					delete(s.renamed, username)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}()
+}
+
+func (s *AuthService) GetClientCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.clients)
+}
+
+// Clients returns a copy of every client the relay knows, most recently
+// seen first.
+func (s *AuthService) Clients() []ClientInfo {
+	s.mu.RLock()
+	clients := make([]ClientInfo, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, *client)
+	}
+	s.mu.RUnlock()
+	sort.Slice(clients, func(i, j int) bool { return clients[i].LastSeen.After(clients[j].LastSeen) })
+	return clients
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Authentication backends, as -auth names them.
+const (
+	// AuthKey is the shared access key every client knows, then the
+	// account's password; anyone with the key may register.
+	AuthKey = "key"
+	// AuthLocal is the local account database alone: no access key, and
+	// only an admin creates accounts (POST /api/admin/accounts).
+	AuthLocal = "local"
+	// AuthOIDC signs users in with an OpenID Connect provider through the
+	// device code flow (see OIDCAuth); the provider's username is the
+	// account's.
+	AuthOIDC = "oidc"
+)
+
+var (
+	ErrRegistrationClosed = errors.New("registration is closed on this relay — ask its admin for an account")
+	ErrDeviceLogin        = errors.New("this relay signs in through your organization's identity provider — update the client to log in")
+)
+
+// LoginAttempt is what a client presents to log in or register.
+type LoginAttempt struct {
+	AccessKey string
+	ClientID  string
+	Username  string
+	Password  string
+}
+
+// Authenticator decides who may log in: it is the -auth backend behind
+// POST /api/login and /api/register. Sessions, bans and rate limits stay
+// with AuthService whichever backend is in use.
+type Authenticator interface {
+	// Method is the backend's -auth name; /api/motd tells clients, so
+	// they know how to log in.
+	Method() string
+	// Admit checks what comes before the credentials — the access key and
+	// bans — and returns ErrBadAccessKey or ErrBanned.
+	Admit(attempt LoginAttempt) error
+	// Login checks the credentials of an admitted attempt and returns the
+	// username to issue the session for; ErrUnknownUser when there is no
+	// such account.
+	Login(attempt LoginAttempt) (string, error)
+	// Register creates an account for an admitted attempt and returns its
+	// username, or ErrRegistrationClosed.
+	Register(attempt LoginAttempt) (string, error)
+}
+
+// NewAuthenticator returns the backend method names. OIDC needs more than
+// the services, so it is built with NewOIDCAuth instead.
+func NewAuthenticator(method string, auth *AuthService, users *UserService) (Authenticator, error) {
+	switch method {
+	case AuthKey, "":
+		return &keyAuth{auth: auth, users: users}, nil
+	case AuthLocal:
+		return &localAuth{auth: auth, users: users}, nil
+	}
+	return nil, fmt.Errorf("unknown authentication backend %q (want %s, %s or %s)", method, AuthKey, AuthLocal, AuthOIDC)
+}
+
+// keyAuth is AuthKey.
+type keyAuth struct {
+	auth  *AuthService
+	users *UserService
+}
+
+func (a *keyAuth) Method() string { return AuthKey }
+
+func (a *keyAuth) Admit(attempt LoginAttempt) error {
+	return a.auth.ValidateAccess(attempt.AccessKey, attempt.ClientID, attempt.Username)
+}
+
+func (a *keyAuth) Login(attempt LoginAttempt) (string, error) {
+	return attempt.Username, a.users.Login(attempt.Username, attempt.Password)
+}
+
+func (a *keyAuth) Register(attempt LoginAttempt) (string, error) {
+	return attempt.Username, a.users.Register(attempt.Username, attempt.Password)
+}
+
+// localAuth is AuthLocal.
+type localAuth struct {
+	auth  *AuthService
+	users *UserService
+}
+
+func (a *localAuth) Method() string { return AuthLocal }
+
+func (a *localAuth) Admit(attempt LoginAttempt) error {
+	return a.auth.AdmitClient(attempt.ClientID, attempt.Username)
+}
+
+func (a *localAuth) Login(attempt LoginAttempt) (string, error) {
+	return attempt.Username, a.users.Login(attempt.Username, attempt.Password)
+}
+
+func (a *localAuth) Register(LoginAttempt) (string, error) {
+	return "", ErrRegistrationClosed
+}
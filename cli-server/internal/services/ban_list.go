@@ -0,0 +1,123 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"secure-chat-backend/internal/storage"
+)
+
+const bansKey = "bans"
+
+var (
+	ErrBanned     = errors.New("banned from this relay")
+	ErrEmptyBan   = errors.New("a ban needs a username or a client_id")
+	ErrUnknownBan = errors.New("no such ban")
+)
+
+// Ban shuts one username or one client_id out: its sessions stop working
+// at once and it cannot log in or register again until unbanned.
+type Ban struct {
+	Username  string    `json:"username,omitempty"`
+	ClientID  string    `json:"client_id,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (b Ban) err() error {
+	if b.Reason == "" {
+		return ErrBanned
+	}
+	return fmt.Errorf("%w: %s", ErrBanned, b.Reason)
+}
+
+// BanList holds the bans, persisted in the storage backend like accounts.
+type BanList struct {
+	store storage.Store
+	mu    sync.RWMutex
+	bans  []Ban
+}
+
+func NewBanList(store storage.Store) (*BanList, error) {
+	b := &BanList{store: store}
+	err := store.Load(bansKey, &b.bans)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("load bans: %w", err)
+	}
+	return b, nil
+}
+
+// Add bans ban.Username or ban.ClientID (set exactly one), replacing an
+// earlier ban of the same target.
+func (b *BanList) Add(ban Ban) (Ban, error) {
+	if (ban.Username == "") == (ban.ClientID == "") {
+		return Ban{}, ErrEmptyBan
+	}
+	ban.CreatedAt = time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	previous := b.bans
+	b.bans = append(b.withoutLocked(ban.Username, ban.ClientID), ban)
+	if err := b.store.Save(bansKey, b.bans); err != nil {
+		b.bans = previous
+		return Ban{}, err
+	}
+	return ban, nil
+}
+
+// Remove lifts the ban on username or clientID (set exactly one).
+func (b *BanList) Remove(username, clientID string) error {
+	if (username == "") == (clientID == "") {
+		return ErrEmptyBan
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	kept := b.withoutLocked(username, clientID)
+	if len(kept) == len(b.bans) {
+		return ErrUnknownBan
+	}
+	previous := b.bans
+	b.bans = kept
+	if err := b.store.Save(bansKey, b.bans); err != nil {
+		b.bans = previous
+		return err
+	}
+	return nil
+}
+
+// withoutLocked returns a copy of the bans minus the one on username or
+// clientID. The caller holds b.mu.
+func (b *BanList) withoutLocked(username, clientID string) []Ban {
+	kept := make([]Ban, 0, len(b.bans))
+	for _, ban := range b.bans {
+		if (username != "" && ban.Username == username) || (clientID != "" && ban.ClientID == clientID) {
+			continue
+		}
+		kept = append(kept, ban)
+	}
+	return kept
+}
+
+// Check returns ErrBanned, with the reason, if username or clientID is
+// banned. Empty arguments match nothing.
+func (b *BanList) Check(username, clientID string) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, ban := range b.bans {
+		if (username != "" && ban.Username == username) || (clientID != "" && ban.ClientID == clientID) {
+			return ban.err()
+		}
+	}
+	return nil
+}
+
+// List returns every ban, oldest first.
+func (b *BanList) List() []Ban {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]Ban{}, b.bans...)
+}
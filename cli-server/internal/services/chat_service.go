@@ -1,112 +1,596 @@
-package services
-
-import (
-	"errors"
-	"sync"
-	"time"
-
-	"secure-chat-backend/internal/models"
-	"secure-chat-backend/internal/utils"
-)
-
-type ChatService struct {
-	buffer     *models.MessageBuffer
-	mu         sync.RWMutex
-	waiters    map[string]chan struct{}
-	maxWaiters int
-	msgCounter int64
-}
-
-func NewChatService(buffer *models.MessageBuffer) *ChatService {
-	return &ChatService{
-		buffer:     buffer,
-		waiters:    make(map[string]chan struct{}),
-		maxWaiters: 1000,
-		msgCounter: 0,
-	}
-}
-
-func (s *ChatService) SendMessage(username, content, color, clientID string) (*models.Message, error) {
-	if username == "" || content == "" {
-		return nil, errors.New("username and content cannot be empty")
-	}
-
-	if color != "" && !utils.IsValidColor(color) {
-		color = "[white]"
-	}
-
-	s.msgCounter++
-	msgID := utils.GenerateID()
-
-	msg := &models.Message{
-		ID:        msgID,
-		Username:  username,
-		Content:   content,
-		Color:     color,
-		Timestamp: time.Now(),
-	}
-
-	s.buffer.Add(msg)
-
-	s.notifyWaiters()
-
-	return msg, nil
-}
-
-func (s *ChatService) GetMessages(afterID string) ([]*models.Message, error) {
-	return s.buffer.GetAfter(afterID, 50), nil
-}
-
-func (s *ChatService) WaitForMessages(clientID, afterID string, timeout time.Duration) ([]*models.Message, error) {
-	if messages := s.buffer.GetAfter(afterID, 50); len(messages) > 0 {
-		return messages, nil
-	}
-
-	waiter := make(chan struct{}, 1)
-
-	s.mu.Lock()
-	if len(s.waiters) >= s.maxWaiters {
-		s.mu.Unlock()
-		return nil, errors.New("server is busy")
-	}
-	s.waiters[clientID] = waiter
-	s.mu.Unlock()
-
-	defer func() {
-		s.mu.Lock()
-		delete(s.waiters, clientID)
-		s.mu.Unlock()
-		close(waiter)
-	}()
-
-	select {
-	case <-waiter:
-		return s.buffer.GetAfter(afterID, 50), nil
-	case <-time.After(timeout):
-		return []*models.Message{}, nil
-	}
-}
-
-func (s *ChatService) notifyWaiters() {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	for _, waiter := range s.waiters {
-		select {
-		case waiter <- struct{}{}:
-		default:
-		}
-	}
-}
-
-func (s *ChatService) GetStats() map[string]interface{} {
-	s.mu.RLock()
-	waiterCount := len(s.waiters)
-	s.mu.RUnlock()
-
-	return map[string]interface{}{
-		"total_messages":  s.buffer.Len(),
-		"waiting_clients": waiterCount,
-		"max_waiters":     s.maxWaiters,
-	}
-}
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/utils"
+)
+
+type ChatService struct {
+	buffer     *models.MessageBuffer
+	mu         sync.RWMutex
+	waiters    map[chan struct{}]struct{} // one per waiting poll or stream
+	maxWaiters int
+	msgCounter int64
+
+	typingMu sync.Mutex
+	typing   map[string]map[string]time.Time // room → username → expiry
+
+	reactMu sync.Mutex // serializes reactions, see React
+
+	pinMu sync.Mutex
+	pins  map[string][]*Pin // room → pinned messages, first pinned first
+
+	noticeMu sync.Mutex
+	notices  []Notice // kept announcements, oldest first; see Notices
+
+	tunables   *Tunables
+	filter     *WordFilter
+	slowMu     sync.Mutex
+	lastSendBy map[string]time.Time // username → last accepted message, for slow mode
+
+	users *UserService   // whom a message may mention; nil = nobody
+	spam  *SpamGuard     // repeat and flood checks; nil = none
+	subs  *Subscriptions // where new messages are sent on; nil = nowhere
+
+	sent *sendStats // per-user and per-minute counts for GetStats
+}
+
+var (
+	ErrReadOnly       = errors.New("the relay is read-only right now — try again later")
+	ErrMessageBlocked = errors.New("message blocked by the word filter")
+	ErrBadMessageType = errors.New(`type must be empty or "action", and an action one line`)
+	ErrBadCrossPost   = errors.New("a cross-post goes to 2 to 6 different rooms")
+	ErrBadReplyTo     = errors.New("reply_to must be a message ID")
+)
+
+// MaxCrossPostRooms is how many rooms one cross-post may reach.
+const MaxCrossPostRooms = 6
+
+// CrossPostCopy is one room's copy of a cross-posted message. Content and
+// signature differ per room: clients seal and sign for each room.
+type CrossPostCopy struct {
+	Room    string
+	Content string
+	Sig     string
+}
+
+// ValidateMessageType checks a message's type: none or models.TypeAction,
+// and an action's content must be a single line.
+func ValidateMessageType(msgType, content string) error {
+	switch msgType {
+	case "":
+		return nil
+	case models.TypeAction:
+		if strings.ContainsAny(content, "\r\n") {
+			return ErrBadMessageType
+		}
+		return nil
+	}
+	return ErrBadMessageType
+}
+
+// maxReplyTo is the longest message ID a reply may refer to. The relay's
+// own IDs are about 30 characters; imported ones may come from elsewhere.
+const maxReplyTo = 64
+
+// ValidateReplyTo checks that id could be a message ID. Whether the message
+// exists is not checked: it may have expired while the reply was written,
+// and the replying client still shows it.
+func ValidateReplyTo(id string) error {
+	if id == "" || len(id) > maxReplyTo {
+		return ErrBadReplyTo
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return ErrBadReplyTo
+		}
+	}
+	return nil
+}
+
+// SlowModeError is returned while slow mode makes a user wait before their
+// next message.
+type SlowModeError struct {
+	Wait time.Duration
+}
+
+func (e *SlowModeError) Error() string {
+	return fmt.Sprintf("slow mode — wait %v before sending again", e.Wait.Round(time.Second))
+}
+
+// TypingTTL is how long a typing event keeps a user listed as typing.
+// Clients repeat the event every few seconds while the user keeps typing.
+const TypingTTL = 5 * time.Second
+
+// NewChatService creates the chat service. tunables supplies read-only
+// mode, slow mode and whether filter is applied.
+func NewChatService(buffer *models.MessageBuffer, tunables *Tunables, filter *WordFilter) *ChatService {
+	return &ChatService{
+		buffer:     buffer,
+		waiters:    make(map[chan struct{}]struct{}),
+		maxWaiters: 1000,
+		msgCounter: 0,
+		typing:     make(map[string]map[string]time.Time),
+		tunables:   tunables,
+		filter:     filter,
+		lastSendBy: make(map[string]time.Time),
+		sent:       newSendStats(),
+	}
+}
+
+// SetUsers makes messages list the accounts they @mention, see
+// ExtractMentions.
+func (s *ChatService) SetUsers(users *UserService) {
+	s.users = users
+}
+
+// SetSpamGuard makes every message pass guard's repeat and flood checks.
+func (s *ChatService) SetSpamGuard(guard *SpamGuard) {
+	s.spam = guard
+}
+
+// SetSubscriptions sends messages and announcements on to subs as they are
+// posted.
+func (s *ChatService) SetSubscriptions(subs *Subscriptions) {
+	s.subs = subs
+}
+
+func (s *ChatService) publish(msg *models.Message) {
+	if s.subs != nil {
+		s.subs.Publish(msg)
+	}
+}
+
+// Unmute lifts a flooding mute of username, reporting whether there was one.
+func (s *ChatService) Unmute(username string) bool {
+	return s.spam != nil && s.spam.Unmute(username)
+}
+
+// mentions returns whom content mentions.
+func (s *ChatService) mentions(content string) []string {
+	if s.users == nil {
+		return nil
+	}
+	return ExtractMentions(content, s.users.Exists)
+}
+
+// OutgoingMessage is a message to send, see SendMessage.
+type OutgoingMessage struct {
+	Username string
+	Content  string
+	Color    string
+	Room     string // "" = models.DefaultRoom
+	// To makes it a direct message only Username and To will see.
+	To string
+	// Sig and Fwd are relayed as is for the receiving clients to verify
+	// and show, and so are Type and ReplyTo once ValidateMessageType and
+	// ValidateReplyTo accepted them.
+	Sig     string
+	Fwd     *models.Forward
+	Type    string
+	ReplyTo string
+}
+
+// SendMessage stores out and wakes the pollers. It refuses with
+// ErrReadOnly, ErrMessageBlocked or a *SlowModeError as the tunables say,
+// and with ErrRepeatedMessage or a *MutedError as the spam guard does.
+func (s *ChatService) SendMessage(out OutgoingMessage) (*models.Message, error) {
+	if out.Username == "" || out.Content == "" {
+		return nil, errors.New("username and content cannot be empty")
+	}
+	room := out.Room
+	if room == "" {
+		room = models.DefaultRoom
+	}
+	if err := s.admit(out.Username, out.Content); err != nil {
+		return nil, err
+	}
+
+	color := out.Color
+	if color != "" && !utils.IsValidColor(color) {
+		color = "[white]"
+	}
+
+	s.msgCounter++
+	msgID := utils.GenerateID()
+
+	msg := &models.Message{
+		ID:        msgID,
+		Room:      room,
+		Username:  out.Username,
+		To:        out.To,
+		Content:   out.Content,
+		Sig:       out.Sig,
+		Fwd:       out.Fwd,
+		Type:      out.Type,
+		ReplyTo:   out.ReplyTo,
+		Mentions:  s.mentions(out.Content),
+		Color:     color,
+		Timestamp: time.Now(),
+	}
+
+	s.buffer.Add(msg)
+	s.sent.add(out.Username, msg.Timestamp)
+	s.clearTyping(room, out.Username)
+
+	s.notifyWaiters()
+	s.publish(msg)
+
+	return msg, nil
+}
+
+// CrossPost sends a message to several rooms at once as linked copies: each
+// gets its own ID, all share Origin — the first copy's ID — and each lists
+// the other rooms in AlsoIn. The checks SendMessage makes run once for the
+// whole post, which is sent to every room or to none. Rooms must be
+// resolved and different.
+func (s *ChatService) CrossPost(username, color, msgType string, copies []CrossPostCopy) ([]*models.Message, error) {
+	if len(copies) < 2 || len(copies) > MaxCrossPostRooms {
+		return nil, ErrBadCrossPost
+	}
+	contents := make([]string, len(copies))
+	for i, c := range copies {
+		if c.Content == "" {
+			return nil, errors.New("username and content cannot be empty")
+		}
+		contents[i] = c.Content
+	}
+	if err := s.admit(username, contents...); err != nil {
+		return nil, err
+	}
+	if color != "" && !utils.IsValidColor(color) {
+		color = "[white]"
+	}
+
+	now := time.Now()
+	msgs := make([]*models.Message, len(copies))
+	for i, c := range copies {
+		msgs[i] = &models.Message{
+			ID:        utils.GenerateID(),
+			Room:      c.Room,
+			Username:  username,
+			Content:   c.Content,
+			Sig:       c.Sig,
+			Type:      msgType,
+			Mentions:  s.mentions(c.Content),
+			Color:     color,
+			Timestamp: now,
+		}
+	}
+	for i, msg := range msgs {
+		msg.Origin = msgs[0].ID
+		for j, c := range copies {
+			if j != i {
+				msg.AlsoIn = append(msg.AlsoIn, c.Room)
+			}
+		}
+		s.msgCounter++
+		s.buffer.Add(msg)
+		s.sent.add(username, now)
+		s.clearTyping(msg.Room, username)
+	}
+	s.notifyWaiters()
+	for _, msg := range msgs {
+		s.publish(msg)
+	}
+	return msgs, nil
+}
+
+// admit applies read-only mode, the word filter, the spam guard and slow
+// mode to a message from username with the given contents. A message the
+// filter blocks still counts towards a flooding mute.
+func (s *ChatService) admit(username string, contents ...string) error {
+	settings := s.tunables.Get()
+	if settings.ReadOnly {
+		return ErrReadOnly
+	}
+	blocked := false
+	if settings.Filter {
+		for _, content := range contents {
+			if s.filter.Match(content) {
+				blocked = true
+				break
+			}
+		}
+	}
+	if s.spam != nil {
+		if err := s.spam.Admit(username, contents, blocked); err != nil {
+			return err
+		}
+	}
+	if blocked {
+		return ErrMessageBlocked
+	}
+	if wait := s.slowModeWait(username, time.Duration(settings.SlowMode)); wait > 0 {
+		return &SlowModeError{Wait: wait}
+	}
+	return nil
+}
+
+// slowModeWait returns how long username must still wait under slow mode
+// with the given gap, or 0 — in which case the send is recorded.
+func (s *ChatService) slowModeWait(username string, gap time.Duration) time.Duration {
+	if gap <= 0 {
+		return 0
+	}
+	s.slowMu.Lock()
+	defer s.slowMu.Unlock()
+
+	now := time.Now()
+	if wait := s.lastSendBy[username].Add(gap).Sub(now); wait > 0 {
+		return wait
+	}
+	s.lastSendBy[username] = now
+	if len(s.lastSendBy) > 1000 {
+		for u, t := range s.lastSendBy {
+			if now.Sub(t) > gap {
+				delete(s.lastSendBy, u)
+			}
+		}
+	}
+	return 0
+}
+
+// Purge hides the held messages of room from username; an empty room or
+// username matches any. Direct messages count as sent in the room they
+// were sent from. Their pins go too. Returns how many were purged.
+func (s *ChatService) Purge(room, username string) int {
+	s.unpinWhere(room, username)
+	return s.buffer.Purge(func(m *models.Message) bool {
+		return (room == "" || m.Room == room) && (username == "" || m.Username == username)
+	})
+}
+
+// MaxNotices is how many kept announcements the relay holds at once; a new
+// one drops the oldest.
+const MaxNotices = 10
+
+// ErrRoomNotice refuses to keep an announcement to a single room: notices
+// are public, in /api/motd.
+var ErrRoomNotice = errors.New("only announcements to every room can be kept")
+
+// Notice is an announcement to every room that is also shown to clients
+// connecting until Until, after its message expired or scrolled by.
+type Notice struct {
+	ID    string    `json:"id"`
+	Text  string    `json:"text"`
+	Time  time.Time `json:"time"`
+	Until time.Time `json:"until"`
+}
+
+// Announce posts an announcement from the operator to room, or to every
+// room when room is empty. With keep, an announcement to every room is a
+// Notice for that long too. Read-only mode, the filter and slow mode do not
+// apply.
+func (s *ChatService) Announce(text, room string, keep time.Duration) (*models.Message, error) {
+	if text == "" {
+		return nil, errors.New("announcement text cannot be empty")
+	}
+	if keep > 0 && room != "" {
+		return nil, ErrRoomNotice
+	}
+	msg := &models.Message{
+		ID:        utils.GenerateID(),
+		Room:      room,
+		Username:  models.AnnouncementUser,
+		Content:   text,
+		Color:     "[yellow]",
+		Timestamp: time.Now(),
+		System:    true,
+	}
+	s.buffer.Add(msg)
+	s.notifyWaiters()
+	s.publish(msg)
+	if keep > 0 {
+		s.noticeMu.Lock()
+		s.notices = append(s.notices, Notice{ID: msg.ID, Text: text, Time: msg.Timestamp, Until: msg.Timestamp.Add(keep)})
+		if len(s.notices) > MaxNotices {
+			s.notices = s.notices[len(s.notices)-MaxNotices:]
+		}
+		s.noticeMu.Unlock()
+	}
+	return msg, nil
+}
+
+// Notices returns the kept announcements that haven't run out, oldest
+// first.
+func (s *ChatService) Notices() []Notice {
+	s.noticeMu.Lock()
+	defer s.noticeMu.Unlock()
+	now := time.Now()
+	live := s.notices[:0]
+	for _, n := range s.notices {
+		if now.Before(n.Until) {
+			live = append(live, n)
+		}
+	}
+	s.notices = live
+	return append([]Notice{}, live...)
+}
+
+// Withdraw stops showing the kept announcement with id to clients that
+// connect, reporting whether there was one. Clients that got it keep it.
+func (s *ChatService) Withdraw(id string) bool {
+	s.noticeMu.Lock()
+	defer s.noticeMu.Unlock()
+	for i, n := range s.notices {
+		if n.ID == id {
+			s.notices = append(s.notices[:i:i], s.notices[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// AnnounceNick tells every room, and every subscription, that from is now
+// known as to.
+func (s *ChatService) AnnounceNick(from, to string) *models.Message {
+	msg := &models.Message{
+		ID:        utils.GenerateID(),
+		Username:  models.AnnouncementUser,
+		Content:   fmt.Sprintf("%s is now known as %s", from, to),
+		Color:     "[yellow]",
+		Timestamp: time.Now(),
+		System:    true,
+		Type:      models.TypeNick,
+		Nick:      &models.NickChange{From: from, To: to},
+	}
+	s.buffer.Add(msg)
+	s.notifyWaiters()
+	s.publish(msg)
+	return msg
+}
+
+func (s *ChatService) GetMessages(afterID string, view models.View) ([]*models.Message, error) {
+	return s.buffer.Read(models.Cursor{ID: afterID}, view, 50).Messages, nil
+}
+
+// Newest returns the sequence number of the newest message, for a reader
+// that wants only what comes next (see models.Cursor).
+func (s *ChatService) Newest() uint64 {
+	return s.buffer.Newest()
+}
+
+// Oldest returns the sequence number of the oldest message still kept.
+func (s *ChatService) Oldest() uint64 {
+	return s.buffer.Oldest()
+}
+
+// History returns a page of view's backlog ending just before beforeID, plus
+// whether an older page exists.
+func (s *ChatService) History(view models.View, beforeID string, limit int) ([]*models.Message, bool) {
+	return s.buffer.GetBefore(beforeID, view, limit)
+}
+
+// WaitForMessages long-polls for messages in view after cursor. It returns
+// as soon as there are some or a gap was found, when timeout passes (with no
+// messages), or when ctx is done — then with ctx's error, so a poll whose
+// client disconnected frees its waiter slot right away instead of holding
+// it until the timeout. Every call waits on its own, so the clients sharing
+// a client ID (an observer token's) don't take each other's wake-ups.
+func (s *ChatService) WaitForMessages(ctx context.Context, view models.View, cursor models.Cursor, timeout time.Duration) (models.Batch, error) {
+	if b := s.buffer.Read(cursor, view, 50); len(b.Messages) > 0 || b.Gap {
+		return b, nil
+	}
+
+	waiter := make(chan struct{}, 1)
+
+	s.mu.Lock()
+	if len(s.waiters) >= s.maxWaiters {
+		s.mu.Unlock()
+		return models.Batch{}, errors.New("server is busy")
+	}
+	s.waiters[waiter] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.waiters, waiter)
+		s.mu.Unlock()
+		close(waiter)
+	}()
+
+	// Waiters are woken for every room, so keep waiting until something in
+	// this view shows up or the poll times out.
+	deadline := time.After(timeout)
+	for {
+		select {
+		case <-waiter:
+			if b := s.buffer.Read(cursor, view, 50); len(b.Messages) > 0 || b.Gap {
+				return b, nil
+			}
+		case <-deadline:
+			return s.buffer.Read(cursor, view, 50), nil
+		case <-ctx.Done():
+			return models.Batch{}, ctx.Err()
+		}
+	}
+}
+
+// SetTyping marks username as typing in room for TypingTTL.
+func (s *ChatService) SetTyping(room, username string) {
+	s.typingMu.Lock()
+	defer s.typingMu.Unlock()
+
+	users, ok := s.typing[room]
+	if !ok {
+		users = make(map[string]time.Time)
+		s.typing[room] = users
+	}
+	users[username] = time.Now().Add(TypingTTL)
+}
+
+// Typing returns who is typing in room right now, sorted, leaving out
+// exclude (the asking user). Expired entries are dropped on the way.
+func (s *ChatService) Typing(room, exclude string) []string {
+	s.typingMu.Lock()
+	defer s.typingMu.Unlock()
+
+	now := time.Now()
+	names := []string{}
+	for username, expiry := range s.typing[room] {
+		if now.After(expiry) {
+			delete(s.typing[room], username)
+			continue
+		}
+		if username != exclude {
+			names = append(names, username)
+		}
+	}
+	if len(s.typing[room]) == 0 {
+		delete(s.typing, room)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// clearTyping ends username's typing state as soon as the message is sent.
+func (s *ChatService) clearTyping(room, username string) {
+	s.typingMu.Lock()
+	defer s.typingMu.Unlock()
+	delete(s.typing[room], username)
+}
+
+func (s *ChatService) notifyWaiters() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for waiter := range s.waiters {
+		select {
+		case waiter <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (s *ChatService) GetStats() map[string]interface{} {
+	s.mu.RLock()
+	waiterCount := len(s.waiters)
+	s.mu.RUnlock()
+
+	stats := map[string]interface{}{
+		"total_messages":      s.buffer.Len(),
+		"buffer_capacity":     s.buffer.Cap(),
+		"messages_per_minute": s.sent.lastMinute(time.Now()),
+		"waiting_clients":     waiterCount,
+		"max_waiters":         s.maxWaiters,
+	}
+	if s.spam != nil {
+		stats["muted_users"] = s.spam.Muted()
+	}
+	return stats
+}
+
+// MessagesByUser returns how many messages each username sent since the
+// relay started, whether or not the buffer still holds them.
+func (s *ChatService) MessagesByUser() map[string]int {
+	return s.sent.users()
+}
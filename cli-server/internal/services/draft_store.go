@@ -0,0 +1,119 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"secure-chat-backend/internal/storage"
+)
+
+const draftsKey = "drafts"
+
+const (
+	// MaxDraftSize is the longest draft kept, in bytes as sent — an
+	// encrypted draft is about a third longer than its text.
+	MaxDraftSize = 16 << 10
+	// maxDraftRooms is how many rooms one user keeps drafts for; saving
+	// another drops the oldest.
+	maxDraftRooms = 20
+	// draftTTL is how long an untouched draft is kept.
+	draftTTL = 30 * 24 * time.Hour
+)
+
+var ErrDraftTooLarge = errors.New("draft too large")
+
+// Draft is the text a user had typed but not sent in one room. The relay
+// keeps it as the client sent it — clients encrypt it in rooms that require
+// encryption.
+type Draft struct {
+	Text      string    `json:"text"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// DraftStore keeps one draft per user and room so a user can pick it up on
+// another device, persisted in the storage backend like accounts.
+type DraftStore struct {
+	store  storage.Store
+	mu     sync.RWMutex
+	drafts map[string]map[string]Draft // username → room → draft
+}
+
+func NewDraftStore(store storage.Store) (*DraftStore, error) {
+	d := &DraftStore{store: store, drafts: make(map[string]map[string]Draft)}
+	err := store.Load(draftsKey, &d.drafts)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("load drafts: %w", err)
+	}
+	if d.drafts == nil {
+		d.drafts = make(map[string]map[string]Draft)
+	}
+	return d, nil
+}
+
+// Get returns username's draft for room, if there is one.
+func (d *DraftStore) Get(username, room string) (Draft, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	draft, ok := d.drafts[username][room]
+	if !ok || time.Since(draft.UpdatedAt) > draftTTL {
+		return Draft{}, false
+	}
+	return draft, true
+}
+
+// Put stores username's draft for room; empty text deletes it.
+func (d *DraftStore) Put(username, room, text string) (Draft, error) {
+	if len(text) > MaxDraftSize {
+		return Draft{}, ErrDraftTooLarge
+	}
+	draft := Draft{Text: text, UpdatedAt: time.Now()}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	rooms := make(map[string]Draft, len(d.drafts[username])+1)
+	for r, old := range d.drafts[username] {
+		if time.Since(old.UpdatedAt) <= draftTTL {
+			rooms[r] = old
+		}
+	}
+	if text == "" {
+		delete(rooms, room)
+	} else {
+		rooms[room] = draft
+		trimDrafts(rooms)
+	}
+
+	previous := d.drafts[username]
+	if len(rooms) == 0 {
+		delete(d.drafts, username)
+	} else {
+		d.drafts[username] = rooms
+	}
+	if err := d.store.Save(draftsKey, d.drafts); err != nil {
+		if previous == nil {
+			delete(d.drafts, username)
+		} else {
+			d.drafts[username] = previous
+		}
+		return Draft{}, err
+	}
+	return draft, nil
+}
+
+// trimDrafts drops the oldest drafts beyond maxDraftRooms.
+func trimDrafts(rooms map[string]Draft) {
+	if len(rooms) <= maxDraftRooms {
+		return
+	}
+	names := make([]string, 0, len(rooms))
+	for r := range rooms {
+		names = append(names, r)
+	}
+	sort.Slice(names, func(i, j int) bool { return rooms[names[i]].UpdatedAt.Before(rooms[names[j]].UpdatedAt) })
+	for _, r := range names[:len(rooms)-maxDraftRooms] {
+		delete(rooms, r)
+	}
+}
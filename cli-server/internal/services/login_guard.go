@@ -0,0 +1,81 @@
+package services
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxLoginFailures is how many failed logins a username may have from
+	// one address within LoginFailureWindow; after that its logins from
+	// there are refused until the window is over.
+	MaxLoginFailures   = 5
+	LoginFailureWindow = 15 * time.Minute
+)
+
+// LoginGuard counts failed logins per username and address, so a password
+// can't be guessed at the speed of the request rate limit. It is keyed by
+// both so that someone guessing from one address doesn't lock the user out
+// everywhere else.
+type LoginGuard struct {
+	mu       sync.Mutex
+	failures map[string]*loginFailures // by username and address
+}
+
+// loginFailures counts failed logins since the first one in the current
+// window.
+type loginFailures struct {
+	count int
+	since time.Time
+}
+
+// NewLoginGuard creates a guard with no failures counted.
+func NewLoginGuard() *LoginGuard {
+	return &LoginGuard{failures: make(map[string]*loginFailures)}
+}
+
+// LockedOut reports whether username has had MaxLoginFailures failed logins
+// from ip in the current window.
+func (g *LoginGuard) LockedOut(username, ip string) bool {
+	key := loginKey(username, ip)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f, ok := g.failures[key]
+	if ok && time.Since(f.since) > LoginFailureWindow {
+		delete(g.failures, key)
+		return false
+	}
+	return ok && f.count >= MaxLoginFailures
+}
+
+// Failed counts a failed login for username from ip, and forgets the
+// entries whose window has passed.
+func (g *LoginGuard) Failed(username, ip string) {
+	key := loginKey(username, ip)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	for k, f := range g.failures {
+		if now.Sub(f.since) > LoginFailureWindow {
+			delete(g.failures, k)
+		}
+	}
+	f, ok := g.failures[key]
+	if !ok {
+		f = &loginFailures{since: now}
+		g.failures[key] = f
+	}
+	f.count++
+}
+
+// Succeeded forgets username's failed logins from ip.
+func (g *LoginGuard) Succeeded(username, ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.failures, loginKey(username, ip))
+}
+
+func loginKey(username, ip string) string {
+	return strings.ToLower(username) + "\x00" + ip
+}
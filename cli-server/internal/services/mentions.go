@@ -0,0 +1,54 @@
+package services
+
+import "strings"
+
+// MaxMentions is how many users one message's Mentions lists at most.
+const MaxMentions = 20
+
+// ExtractMentions returns the users content mentions as "@name", in order
+// of first mention and at most MaxMentions. An "@" counts only at the start
+// or after a character no username has, so "bob@example.com" mentions
+// nobody; a name counts only if exists says the account is there. Sentence
+// punctuation after a name ("@bob.") is dropped unless it belongs to it.
+// Sealed content is Base64, which has no "@": the relay can't tell whom an
+// encrypted message mentions.
+func ExtractMentions(content string, exists func(username string) bool) []string {
+	var mentions []string
+	for i := 0; i < len(content) && len(mentions) < MaxMentions; i++ {
+		if content[i] != '@' || (i > 0 && isUsernameByte(content[i-1])) {
+			continue
+		}
+		end := i + 1
+		for end < len(content) && end-i-1 < 32 && isUsernameByte(content[end]) {
+			end++
+		}
+		name := content[i+1 : end]
+		i = end - 1
+		for name != "" && !exists(name) {
+			trimmed := strings.TrimRight(name, ".-")
+			if trimmed == name {
+				name = ""
+				break
+			}
+			name = trimmed
+		}
+		if name != "" && !containsName(mentions, name) {
+			mentions = append(mentions, name)
+		}
+	}
+	return mentions
+}
+
+// isUsernameByte reports whether b may appear in a username.
+func isUsernameByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '_' || b == '.' || b == '-'
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
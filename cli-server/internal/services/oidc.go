@@ -0,0 +1,268 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OIDCConfig configures AuthOIDC.
+type OIDCConfig struct {
+	// Issuer is the provider's issuer URL; its endpoints are discovered
+	// from /.well-known/openid-configuration under it.
+	Issuer string
+	// ClientID and ClientSecret are the relay's registration with the
+	// provider; the secret is empty for a public client.
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// UsernameClaim is the ID token claim that becomes the username;
+	// empty = "preferred_username".
+	UsernameClaim string
+}
+
+// DeviceAuthorization is a started device code flow (RFC 8628): the user
+// opens VerificationURI and types UserCode, while the client polls with
+// DeviceCode.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"` // seconds between polls
+}
+
+var (
+	ErrAuthorizationPending = errors.New("waiting for the sign-in to be approved")
+	ErrSlowDown             = errors.New("polling too fast — wait longer between polls")
+	ErrDeviceCodeExpired    = errors.New("the sign-in code expired — start again")
+	ErrAccessDenied         = errors.New("the sign-in was denied")
+)
+
+// OIDCAuth is AuthOIDC. The relay is the provider's client: it starts the
+// device code flow for the TUI, which has no browser, and redeems the code
+// once the user approved it. The ID token comes straight from the
+// provider's token endpoint over TLS, so its issuer, audience and expiry
+// are checked but not its signature (OpenID Connect Core 3.1.3.7). Its
+// UsernameClaim names the account, which is created on first login.
+type OIDCAuth struct {
+	auth   *AuthService
+	users  *UserService
+	config OIDCConfig
+	client *http.Client
+
+	issuer         string // as the discovery document spells it
+	deviceEndpoint string
+	tokenEndpoint  string
+}
+
+// maxOIDCResponse caps what is read from the provider.
+const maxOIDCResponse = 1 << 20
+
+// NewOIDCAuth discovers the provider's endpoints. It fails when the
+// provider can't be reached or doesn't support the device code flow.
+func NewOIDCAuth(ctx context.Context, config OIDCConfig, auth *AuthService, users *UserService) (*OIDCAuth, error) {
+	if config.Issuer == "" || config.ClientID == "" {
+		return nil, errors.New("oidc needs an issuer and a client id")
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "preferred_username"
+	}
+	a := &OIDCAuth{
+		auth:   auth,
+		users:  users,
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(config.Issuer, "/")+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Issuer                      string `json:"issuer"`
+		DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+		TokenEndpoint               string `json:"token_endpoint"`
+	}
+	if err := a.do(req, &doc); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimRight(doc.Issuer, "/") != strings.TrimRight(config.Issuer, "/") {
+		return nil, fmt.Errorf("oidc discovery: the provider calls itself %q, not %q", doc.Issuer, config.Issuer)
+	}
+	if doc.DeviceAuthorizationEndpoint == "" || doc.TokenEndpoint == "" {
+		return nil, errors.New("oidc discovery: the provider has no device authorization endpoint")
+	}
+	a.issuer, a.deviceEndpoint, a.tokenEndpoint = doc.Issuer, doc.DeviceAuthorizationEndpoint, doc.TokenEndpoint
+	return a, nil
+}
+
+func (a *OIDCAuth) Method() string { return AuthOIDC }
+
+// Issuer is the provider users sign in with.
+func (a *OIDCAuth) Issuer() string { return a.issuer }
+
+func (a *OIDCAuth) Admit(attempt LoginAttempt) error {
+	return a.auth.AdmitClient(attempt.ClientID, attempt.Username)
+}
+
+// Login refuses passwords: users sign in with StartDevice and PollDevice.
+func (a *OIDCAuth) Login(LoginAttempt) (string, error) { return "", ErrDeviceLogin }
+
+// Register refuses too: accounts come from the provider.
+func (a *OIDCAuth) Register(LoginAttempt) (string, error) { return "", ErrDeviceLogin }
+
+// StartDevice asks the provider for a device code and the code the user
+// types at its verification page.
+func (a *OIDCAuth) StartDevice(ctx context.Context) (*DeviceAuthorization, error) {
+	form := url.Values{"scope": {strings.Join(a.config.Scopes, " ")}}
+	var da struct {
+		DeviceAuthorization
+		VerificationURL string `json:"verification_url"` // Google's spelling
+	}
+	if err := a.post(ctx, a.deviceEndpoint, form, &da); err != nil {
+		return nil, err
+	}
+	if da.VerificationURI == "" {
+		da.VerificationURI = da.VerificationURL
+	}
+	if da.DeviceCode == "" || da.UserCode == "" || da.VerificationURI == "" {
+		return nil, errors.New("identity provider: incomplete device authorization")
+	}
+	if da.Interval <= 0 {
+		da.Interval = 5
+	}
+	return &da.DeviceAuthorization, nil
+}
+
+// PollDevice redeems deviceCode and returns the signed-in user's name,
+// creating the account on first login. Until the user has approved it
+// returns ErrAuthorizationPending (or ErrSlowDown); then ErrAccessDenied
+// or ErrDeviceCodeExpired if it won't be.
+func (a *OIDCAuth) PollDevice(ctx context.Context, deviceCode string) (string, error) {
+	form := url.Values{
+		"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+		"device_code": {deviceCode},
+	}
+	var tok struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := a.post(ctx, a.tokenEndpoint, form, &tok); err != nil {
+		return "", err
+	}
+	claims, err := a.idTokenClaims(tok.IDToken)
+	if err != nil {
+		return "", err
+	}
+	username, _ := claims[a.config.UsernameClaim].(string)
+	if !validUsername.MatchString(username) {
+		return "", fmt.Errorf("the identity provider's %s %q is not a username this relay takes (1-32 letters, digits, '_', '-' or '.')", a.config.UsernameClaim, username)
+	}
+	if err := a.users.Ensure(username); err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+// idTokenClaims decodes raw and checks it was issued to the relay by the
+// provider and hasn't expired.
+func (a *OIDCAuth) idTokenClaims(raw string) (map[string]interface{}, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("identity provider: no ID token — is the openid scope requested?")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("identity provider: malformed ID token: %w", err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("identity provider: malformed ID token: %w", err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != a.issuer {
+		return nil, fmt.Errorf("identity provider: ID token from %q", iss)
+	}
+	audience := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		audience = aud == a.config.ClientID
+	case []interface{}:
+		for _, v := range aud {
+			audience = audience || v == a.config.ClientID
+		}
+	}
+	if !audience {
+		return nil, errors.New("identity provider: ID token issued to another client")
+	}
+	if exp, _ := claims["exp"].(float64); time.Unix(int64(exp), 0).Before(time.Now()) {
+		return nil, errors.New("identity provider: ID token expired")
+	}
+	return claims, nil
+}
+
+// post sends form, with the relay's client credentials, to endpoint and
+// decodes the answer into v. OAuth errors come back as the sentinels
+// above where there is one.
+func (a *OIDCAuth) post(ctx context.Context, endpoint string, form url.Values, v interface{}) error {
+	form.Set("client_id", a.config.ClientID)
+	if a.config.ClientSecret != "" {
+		form.Set("client_secret", a.config.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return a.do(req, v)
+}
+
+// do sends req and decodes a 200 answer into v.
+func (a *OIDCAuth) do(req *http.Request, v interface{}) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("identity provider not reachable: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxOIDCResponse))
+	if err != nil {
+		return fmt.Errorf("identity provider: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var oauthErr struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		json.Unmarshal(body, &oauthErr)
+		switch oauthErr.Error {
+		case "authorization_pending":
+			return ErrAuthorizationPending
+		case "slow_down":
+			return ErrSlowDown
+		case "expired_token", "invalid_grant": // the latter for a used or unknown code
+			return ErrDeviceCodeExpired
+		case "access_denied":
+			return ErrAccessDenied
+		case "":
+			return fmt.Errorf("identity provider: HTTP %d", resp.StatusCode)
+		}
+		if oauthErr.Description != "" {
+			return fmt.Errorf("identity provider: %s: %s", oauthErr.Error, oauthErr.Description)
+		}
+		return fmt.Errorf("identity provider: %s", oauthErr.Error)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("identity provider: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"secure-chat-backend/internal/utils"
+)
+
+var (
+	ErrPasteTooLarge  = errors.New("paste is too large")
+	ErrPasteEmpty     = errors.New("paste is empty")
+	ErrPasteRateLimit = errors.New("too many pastes — wait a moment")
+	ErrBadPasteSyntax = errors.New("syntax must be up to 32 letters, digits or _+#.-")
+	ErrPasteNotFound  = errors.New("no such paste, or it has expired")
+)
+
+// pasteStoreBytes caps the text held across all pastes; the oldest are
+// dropped to make room.
+const pasteStoreBytes = 64 << 20
+
+var pasteSyntaxPattern = regexp.MustCompile(`^[a-z0-9_+#.\-]{0,32}$`)
+
+// Paste is a block of text shared by ID instead of posted to a room.
+type Paste struct {
+	ID string `json:"id"`
+	// Name is where the text came from, e.g. a file name; Syntax is a
+	// hint for highlighting such as "go". Both may be empty.
+	Name      string    `json:"name,omitempty"`
+	Syntax    string    `json:"syntax,omitempty"`
+	Text      string    `json:"text"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpireAt  time.Time `json:"expire_at"`
+}
+
+// PastePolicy is how big a paste may be and how long it is kept,
+// independently of the chat buffer and of uploads.
+type PastePolicy struct {
+	MaxSize int           // longest paste in bytes as sent
+	TTL     time.Duration // how long a paste can be read
+}
+
+// PasteStore keeps pastes in memory until their TTL runs out. The relay
+// stores the text as the client sent it — clients encrypt it in rooms that
+// require encryption.
+type PasteStore struct {
+	policy PastePolicy
+
+	mu       sync.Mutex
+	pastes   map[string]*Paste
+	order    []string // paste IDs, oldest first
+	bytes    int
+	limiters map[string]*rate.Limiter
+}
+
+func NewPasteStore(policy PastePolicy) *PasteStore {
+	return &PasteStore{
+		policy:   policy,
+		pastes:   make(map[string]*Paste),
+		limiters: make(map[string]*rate.Limiter),
+	}
+}
+
+// Policy returns the limits the store enforces.
+func (s *PasteStore) Policy() PastePolicy {
+	return s.policy
+}
+
+// Put stores text for author and returns the new paste. A user may paste
+// 5 times at once, then once every 10 seconds.
+func (s *PasteStore) Put(author, name, syntax, text string) (*Paste, error) {
+	syntax = strings.ToLower(strings.TrimSpace(syntax))
+	switch {
+	case strings.TrimSpace(text) == "":
+		return nil, ErrPasteEmpty
+	case len(text) > s.policy.MaxSize:
+		return nil, ErrPasteTooLarge
+	case !pasteSyntaxPattern.MatchString(syntax):
+		return nil, ErrBadPasteSyntax
+	}
+	if name != "" {
+		var ok bool
+		if name, ok = cleanUploadName(name); !ok {
+			name = ""
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	limiter, ok := s.limiters[author]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Every(10*time.Second), 5)
+		s.limiters[author] = limiter
+	}
+	if !limiter.Allow() {
+		return nil, ErrPasteRateLimit
+	}
+
+	now := time.Now()
+	s.dropExpiredLocked(now)
+	for len(s.order) > 0 && s.bytes+len(text) > pasteStoreBytes {
+		s.dropOldestLocked()
+	}
+	paste := &Paste{
+		ID:        utils.GeneratePasteID(),
+		Name:      name,
+		Syntax:    syntax,
+		Text:      text,
+		Author:    author,
+		CreatedAt: now,
+		ExpireAt:  now.Add(s.policy.TTL),
+	}
+	s.pastes[paste.ID] = paste
+	s.order = append(s.order, paste.ID)
+	s.bytes += len(text)
+	return paste, nil
+}
+
+// Get returns the paste with id while it hasn't expired.
+func (s *PasteStore) Get(id string) (*Paste, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropExpiredLocked(time.Now())
+	paste, ok := s.pastes[strings.ToLower(id)]
+	if !ok {
+		return nil, ErrPasteNotFound
+	}
+	return paste, nil
+}
+
+// dropExpiredLocked drops pastes past their TTL. All pastes share one TTL,
+// so they expire in the order they were stored.
+func (s *PasteStore) dropExpiredLocked(now time.Time) {
+	for len(s.order) > 0 && !s.pastes[s.order[0]].ExpireAt.After(now) {
+		s.dropOldestLocked()
+	}
+}
+
+func (s *PasteStore) dropOldestLocked() {
+	paste := s.pastes[s.order[0]]
+	delete(s.pastes, paste.ID)
+	s.order = s.order[1:]
+	s.bytes -= len(paste.Text)
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"secure-chat-backend/internal/models"
+)
+
+var (
+	ErrNotPinnable = errors.New("only messages to a room can be pinned")
+	ErrNotAuthor   = errors.New("only the author or an admin can pin or unpin a message")
+	ErrTooManyPins = errors.New("a room holds at most 25 pinned messages — unpin one first")
+	ErrNotPinned   = errors.New("that message isn't pinned")
+)
+
+// MaxPinsPerRoom is how many messages one room may have pinned.
+const MaxPinsPerRoom = 25
+
+// Pin is a pinned message. The relay keeps a copy, so the message stays
+// pinned after it expired from the buffer, until it is unpinned, purged or
+// the relay restarts.
+type Pin struct {
+	Message  models.WireMessage `json:"message"`
+	PinnedBy string             `json:"pinned_by"`
+	PinnedAt time.Time          `json:"pinned_at"`
+}
+
+// Pin pins the message with id to its room for username. Unless admin, it
+// must be username's own. Pinning a pinned message again changes nothing.
+// It refuses with ErrReadOnly, ErrUnknownMessage, ErrNotPinnable,
+// ErrNotAuthor or ErrTooManyPins.
+func (s *ChatService) Pin(username, id string, admin bool) (*Pin, error) {
+	if !admin && s.tunables.Get().ReadOnly {
+		return nil, ErrReadOnly
+	}
+	target := s.buffer.Find(id)
+	if target == nil {
+		return nil, ErrUnknownMessage
+	}
+	if target.System || target.To != "" || target.Type == models.TypeReaction {
+		return nil, ErrNotPinnable
+	}
+	if !admin && target.Username != username {
+		return nil, ErrNotAuthor
+	}
+
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+	pins := s.pins[target.Room]
+	for _, p := range pins {
+		if p.Message.ID == id {
+			return p, nil
+		}
+	}
+	if len(pins) >= MaxPinsPerRoom {
+		return nil, ErrTooManyPins
+	}
+	if s.pins == nil {
+		s.pins = make(map[string][]*Pin)
+	}
+	pin := &Pin{Message: target.ToWire(), PinnedBy: username, PinnedAt: time.Now()}
+	s.pins[target.Room] = append(pins, pin)
+	return pin, nil
+}
+
+// Unpin unpins the message with id for username. Unless admin, it must be
+// username's own. It refuses with ErrReadOnly, ErrNotPinned or ErrNotAuthor.
+func (s *ChatService) Unpin(username, id string, admin bool) error {
+	if !admin && s.tunables.Get().ReadOnly {
+		return ErrReadOnly
+	}
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+	for room, pins := range s.pins {
+		for i, p := range pins {
+			if p.Message.ID != id {
+				continue
+			}
+			if !admin && p.Message.Username != username {
+				return ErrNotAuthor
+			}
+			s.pins[room] = append(pins[:i:i], pins[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotPinned
+}
+
+// Pins returns the messages pinned in room, the first pinned first.
+func (s *ChatService) Pins(room string) []*Pin {
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+	return append([]*Pin{}, s.pins[room]...)
+}
+
+// unpinWhere unpins every message of room (all rooms when "") by username
+// (everyone when ""), for Purge.
+func (s *ChatService) unpinWhere(room, username string) {
+	s.pinMu.Lock()
+	defer s.pinMu.Unlock()
+	for r, pins := range s.pins {
+		if room != "" && r != room {
+			continue
+		}
+		kept := make([]*Pin, 0, len(pins))
+		for _, p := range pins {
+			if username == "" || p.Message.Username != username {
+				kept = append(kept, p)
+			}
+		}
+		s.pins[r] = kept
+	}
+}
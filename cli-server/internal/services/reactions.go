@@ -0,0 +1,141 @@
+package services
+
+import (
+	"errors"
+	"time"
+	"unicode"
+
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/utils"
+)
+
+var (
+	ErrBadEmoji         = errors.New("emoji must be 1-16 bytes without letters, spaces or control characters")
+	ErrUnknownMessage   = errors.New("no such message — it may have expired")
+	ErrCannotReact      = errors.New("announcements and reactions can't be reacted to")
+	ErrTooManyReactions = errors.New("a message takes at most 20 different reactions")
+)
+
+// MaxReactionKinds is how many different emoji one message may collect.
+const MaxReactionKinds = 20
+
+// maxEmojiLen is the longest reaction in bytes: room for a skin tone, a
+// ZWJ sequence or a flag, not for a sentence.
+const maxEmojiLen = 16
+
+// ValidateEmoji checks that emoji could be an emoji. Which ones exist is
+// the clients' business; the relay keeps out text, so reactions can't be
+// used as a second channel for messages the word filter never sees.
+func ValidateEmoji(emoji string) error {
+	if emoji == "" || len(emoji) > maxEmojiLen {
+		return ErrBadEmoji
+	}
+	for _, r := range emoji {
+		if r == unicode.ReplacementChar || unicode.IsLetter(r) || unicode.IsSpace(r) || unicode.IsControl(r) {
+			return ErrBadEmoji
+		}
+	}
+	return nil
+}
+
+// React adds username's emoji to the message with id, or with remove takes
+// it back, and returns the message's reactions. When they changed, a
+// reaction event (models.TypeReaction) goes out to everyone who sees the
+// message; adding a reaction twice or taking back one never given changes
+// nothing. Direct messages can only be reacted to by their two users. It
+// refuses with ErrReadOnly, ErrUnknownMessage, ErrCannotReact or
+// ErrTooManyReactions.
+func (s *ChatService) React(username, id, emoji string, remove bool) (*models.Reaction, error) {
+	if s.tunables.Get().ReadOnly {
+		return nil, ErrReadOnly
+	}
+	s.reactMu.Lock()
+	defer s.reactMu.Unlock()
+
+	target := s.buffer.Find(id)
+	if target == nil || (target.To != "" && username != target.Username && username != target.To) {
+		return nil, ErrUnknownMessage
+	}
+	if target.System || target.Type == models.TypeReaction {
+		return nil, ErrCannotReact
+	}
+
+	totals, changed := applyReaction(s.reactionTotals(id), username, emoji, remove)
+	if len(totals) > MaxReactionKinds {
+		return nil, ErrTooManyReactions
+	}
+	reaction := &models.Reaction{MessageID: id, Emoji: emoji, Removed: remove, Totals: totals}
+	if !changed {
+		return reaction, nil
+	}
+
+	to := ""
+	if target.To != "" {
+		// The event reaches the same two users as the message.
+		to = target.To
+		if username == target.To {
+			to = target.Username
+		}
+	}
+	s.buffer.Add(&models.Message{
+		ID:        utils.GenerateID(),
+		Room:      target.Room,
+		Username:  username,
+		To:        to,
+		Content:   emoji,
+		Timestamp: time.Now(),
+		Type:      models.TypeReaction,
+		Reaction:  reaction,
+	})
+	s.notifyWaiters()
+	return reaction, nil
+}
+
+// reactionTotals returns the reactions the message with id has: those of
+// the newest reaction event for it. The caller holds s.reactMu.
+func (s *ChatService) reactionTotals(id string) []models.ReactionTotal {
+	var totals []models.ReactionTotal
+	s.buffer.Each(func(m *models.Message) {
+		if m.Type == models.TypeReaction && m.Reaction != nil && m.Reaction.MessageID == id {
+			totals = m.Reaction.Totals
+		}
+	})
+	return totals
+}
+
+// applyReaction returns old with username's emoji added or removed, and
+// whether that changed anything. old is left as it is: events already in
+// the buffer share it.
+func applyReaction(old []models.ReactionTotal, username, emoji string, remove bool) ([]models.ReactionTotal, bool) {
+	totals := make([]models.ReactionTotal, 0, len(old)+1)
+	found, changed := false, false
+	for _, t := range old {
+		users := make([]string, 0, len(t.Users)+1)
+		given := false
+		for _, u := range t.Users {
+			if t.Emoji == emoji && u == username {
+				given = true
+				if remove {
+					changed = true
+					continue
+				}
+			}
+			users = append(users, u)
+		}
+		if t.Emoji == emoji {
+			found = true
+			if !remove && !given {
+				users = append(users, username)
+				changed = true
+			}
+		}
+		if len(users) > 0 {
+			totals = append(totals, models.ReactionTotal{Emoji: t.Emoji, Users: users})
+		}
+	}
+	if !found && !remove {
+		totals = append(totals, models.ReactionTotal{Emoji: emoji, Users: []string{username}})
+		changed = true
+	}
+	return totals, changed
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/utils"
+)
+
+// RoomStatsWindow is the span the message rate and the active members are
+// measured over.
+const RoomStatsWindow = 10 * time.Minute
+
+// roomStatsTTL is how long one aggregation of the buffer is served before
+// the next request walks it again.
+const roomStatsTTL = 5 * time.Second
+
+// topPosters is how many posters RoomStats lists.
+const topPosters = 5
+
+// RoomStats describes one room from the messages the relay still holds.
+// Direct messages and announcements are not counted.
+type RoomStats struct {
+	Room string `json:"room"`
+	// Messages is how many of the room's messages are held.
+	Messages int `json:"messages"`
+	// MessagesPerMinute and ActiveMembers (distinct posters) cover the
+	// last WindowSeconds.
+	MessagesPerMinute float64  `json:"messages_per_minute"`
+	ActiveMembers     int      `json:"active_members"`
+	WindowSeconds     int      `json:"window_seconds"`
+	TopPosters        []Poster `json:"top_posters"`
+	// BufferUsed of BufferSize messages are held across all rooms; the
+	// room's share is Messages of them.
+	BufferUsed int       `json:"buffer_used"`
+	BufferSize int       `json:"buffer_size"`
+	ComputedAt time.Time `json:"computed_at"`
+	// TTLSeconds is how long the room's messages are kept and MaxMessages
+	// how many of them at most (see models.Retention).
+	TTLSeconds  int `json:"ttl_seconds"`
+	MaxMessages int `json:"max_messages"`
+}
+
+// Poster is a user and how many of the room's held messages are theirs.
+type Poster struct {
+	Username string `json:"username"`
+	Messages int    `json:"messages"`
+}
+
+// RoomService aggregates per-room statistics. One pass over the buffer
+// covers every room and is cached for roomStatsTTL, so a dashboard polling
+// many rooms costs one walk, not one per room.
+type RoomService struct {
+	buffer *models.MessageBuffer
+
+	mu       sync.Mutex
+	cached   map[string]*RoomStats
+	cachedAt time.Time
+	used     int
+}
+
+func NewRoomService(buffer *models.MessageBuffer) *RoomService {
+	return &RoomService{buffer: buffer}
+}
+
+// Stats returns room's statistics, at most roomStatsTTL old. A room with no
+// messages held gets zeroes.
+func (s *RoomService) Stats(room string) RoomStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cached == nil || time.Since(s.cachedAt) > roomStatsTTL {
+		s.aggregateLocked()
+	}
+	stats := RoomStats{
+		Room:          room,
+		WindowSeconds: int(RoomStatsWindow.Seconds()),
+		TopPosters:    []Poster{},
+		BufferUsed:    s.used,
+		BufferSize:    s.buffer.Cap(),
+		ComputedAt:    s.cachedAt,
+	}
+	if cached, ok := s.cached[room]; ok {
+		stats = *cached
+	}
+	ttl, keep := s.buffer.RetentionOf(room)
+	stats.TTLSeconds, stats.MaxMessages = int(ttl.Seconds()), keep
+	return stats
+}
+
+// ParseRoomRetention reads retentions given as "general=24h,ops=5m/100": a
+// room's TTL and, after a slash, how many of its messages are kept at most.
+func ParseRoomRetention(s string) (map[string]models.Retention, error) {
+	rooms := make(map[string]models.Retention)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		room, value, ok := strings.Cut(pair, "=")
+		room = strings.TrimSpace(room)
+		if !ok || !utils.ValidateRoom(room) {
+			return nil, fmt.Errorf("room retention %q is not room=ttl or room=ttl/max", pair)
+		}
+		ttl, max, hasMax := strings.Cut(strings.TrimSpace(value), "/")
+		var r models.Retention
+		if ttl != "" {
+			d, err := time.ParseDuration(ttl)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("room retention %q: ttl must be a positive duration like 5m", pair)
+			}
+			r.TTL = d
+		}
+		if hasMax {
+			n, err := strconv.Atoi(max)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("room retention %q: max must be a positive number of messages", pair)
+			}
+			r.MaxMessages = n
+		}
+		rooms[room] = r
+	}
+	return rooms, nil
+}
+
+func (s *RoomService) aggregateLocked() {
+	now := time.Now()
+	since := now.Add(-RoomStatsWindow)
+
+	type tally struct {
+		messages, recent int
+		posters          map[string]int
+		active           map[string]bool
+	}
+	rooms := make(map[string]*tally)
+	used := 0
+	s.buffer.Each(func(m *models.Message) {
+		used++
+		if m.System || m.To != "" || m.Type == models.TypeReaction {
+			return
+		}
+		t := rooms[m.Room]
+		if t == nil {
+			t = &tally{posters: make(map[string]int), active: make(map[string]bool)}
+			rooms[m.Room] = t
+		}
+		t.messages++
+		t.posters[m.Username]++
+		if m.Timestamp.After(since) {
+			t.recent++
+			t.active[m.Username] = true
+		}
+	})
+
+	s.cached = make(map[string]*RoomStats, len(rooms))
+	for room, t := range rooms {
+		posters := make([]Poster, 0, len(t.posters))
+		for name, n := range t.posters {
+			posters = append(posters, Poster{Username: name, Messages: n})
+		}
+		sort.Slice(posters, func(i, j int) bool {
+			if posters[i].Messages != posters[j].Messages {
+				return posters[i].Messages > posters[j].Messages
+			}
+			return posters[i].Username < posters[j].Username
+		})
+		if len(posters) > topPosters {
+			posters = posters[:topPosters]
+		}
+		s.cached[room] = &RoomStats{
+			Room:              room,
+			Messages:          t.messages,
+			MessagesPerMinute: float64(t.recent) / RoomStatsWindow.Minutes(),
+			ActiveMembers:     len(t.active),
+			WindowSeconds:     int(RoomStatsWindow.Seconds()),
+			TopPosters:        posters,
+			BufferUsed:        used,
+			BufferSize:        s.buffer.Cap(),
+			ComputedAt:        now,
+		}
+	}
+	s.cachedAt, s.used = now, used
+}
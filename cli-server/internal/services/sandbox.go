@@ -0,0 +1,110 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/utils"
+)
+
+// SandboxLine is one message of a -sandbox script.
+type SandboxLine struct {
+	User  string `json:"user"`
+	Room  string `json:"room,omitempty"` // "" = the default room
+	Text  string `json:"text"`
+	Type  string `json:"type,omitempty"`  // "" or models.TypeAction
+	Color string `json:"color,omitempty"` // e.g. "[cyan]"
+}
+
+// DefaultSandboxScript is what a sandbox relay replays without
+// -sandbox-script: a bit of everything a client has to show — two rooms,
+// actions, mentions, Unicode and a long line.
+var DefaultSandboxScript = []SandboxLine{
+	{User: "alice", Color: "[cyan]", Text: "morning all"},
+	{User: "bob", Color: "[yellow]", Text: "hey @alice, did the deploy go out?"},
+	{User: "alice", Color: "[cyan]", Text: "stretches and reaches for the coffee", Type: models.TypeAction},
+	{User: "alice", Color: "[cyan]", Text: "@bob yes, 20 minutes ago. nothing on fire so far"},
+	{User: "carol", Color: "[magenta]", Room: "dev", Text: "anyone else seeing the poll time out after 30s? I think that's expected"},
+	{User: "bob", Color: "[yellow]", Room: "dev", Text: "@carol that's the long poll — it answers empty and you poll again"},
+	{User: "carol", Color: "[magenta]", Text: "Grüße aus Köln ☕ — テスト — 🚀"},
+	{User: "bob", Color: "[yellow]", Text: "here is a long one so you can check wrapping: Lorem ipsum dolor sit amet, consectetur adipiscing elit, sed do eiusmod tempor incididunt ut labore et dolore magna aliqua. Ut enim ad minim veniam, quis nostrud exercitation ullamco laboris."},
+	{User: "alice", Color: "[cyan]", Room: "dev", Text: "ships it", Type: models.TypeAction},
+}
+
+// LoadSandboxScript reads a script as JSON lines, one SandboxLine each.
+// Blank lines and lines starting with # are skipped.
+func LoadSandboxScript(path string) ([]SandboxLine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var script []SandboxLine
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var line SandboxLine
+		if err := json.Unmarshal([]byte(text), &line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		if err := line.validate(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		script = append(script, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(script) == 0 {
+		return nil, fmt.Errorf("%s: no messages", path)
+	}
+	return script, nil
+}
+
+func (l SandboxLine) validate() error {
+	if !validUsername.MatchString(l.User) {
+		return ErrInvalidUsername
+	}
+	if l.Text == "" {
+		return fmt.Errorf("empty text")
+	}
+	if l.Room != "" && !utils.ValidateRoom(l.Room) {
+		return fmt.Errorf("invalid room %q", l.Room)
+	}
+	if !utils.IsValidColor(l.Color) {
+		return fmt.Errorf("invalid color %q", l.Color)
+	}
+	return ValidateMessageType(l.Type, l.Text)
+}
+
+// ReplaySandboxScript sends script's messages through chat one every
+// interval, from the start again after the last, for as long as the relay
+// runs. Its users get accounts in users so they can be mentioned.
+func ReplaySandboxScript(chat *ChatService, users *UserService, script []SandboxLine, interval time.Duration, logger *slog.Logger) {
+	for _, line := range script {
+		if err := users.Ensure(line.User); err != nil {
+			logger.Warn("sandbox: cannot create user", "user", line.User, "error", err)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	go func() {
+		for i := 0; ; i = (i + 1) % len(script) {
+			<-ticker.C
+			line := script[i]
+			if _, err := chat.SendMessage(OutgoingMessage{Username: line.User, Content: line.Text, Color: line.Color, Room: line.Room, Type: line.Type}); err != nil {
+				logger.Warn("sandbox: script message refused", "line", i+1, "user", line.User, "error", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Verdict is what a scanner decided about an upload.
+type Verdict string
+
+const (
+	VerdictClean    Verdict = "clean"
+	VerdictInfected Verdict = "infected"
+)
+
+// ScanResult is a scanner's verdict plus its explanation, e.g. the
+// signature name that matched.
+type ScanResult struct {
+	Verdict Verdict
+	Detail  string
+}
+
+// Scanner checks an upload before it is handed out to anyone. An error
+// means no verdict could be had (scanner missing, crashed, timed out).
+type Scanner interface {
+	Scan(ctx context.Context, name string, data []byte) (ScanResult, error)
+}
+
+// NewScanner builds the scanner configured with -scanner: an http(s) URL
+// selects HTTPScanner, anything else is a command line for CommandScanner.
+// An empty spec means no scanning and returns nil.
+func NewScanner(spec string, timeout time.Duration) (Scanner, error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case spec == "":
+		return nil, nil
+	case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+		return &HTTPScanner{URL: spec, Client: &http.Client{Timeout: timeout}}, nil
+	}
+	argv := strings.Fields(spec)
+	path, err := exec.LookPath(argv[0])
+	if err != nil {
+		return nil, fmt.Errorf("scanner: %w", err)
+	}
+	argv[0] = path
+	return &CommandScanner{Argv: argv, Timeout: timeout}, nil
+}
+
+// scanOutputLimit caps how much scanner output is kept for the verdict.
+const scanOutputLimit = 4096
+
+// CommandScanner runs an external program on each upload, clamscan style:
+// the file's path is appended to Argv, exit status 0 means clean, 1 means
+// infected and anything else is an error.
+//
+// The program is run without a shell, with an empty environment except
+// PATH, inside a private temporary directory holding only the upload, with
+// stdin closed, its output capped and a hard timeout.
+type CommandScanner struct {
+	Argv    []string
+	Timeout time.Duration
+}
+
+func (c *CommandScanner) Scan(ctx context.Context, name string, data []byte) (ScanResult, error) {
+	dir, err := os.MkdirTemp("", "ttc-scan-")
+	if err != nil {
+		return ScanResult{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	// The upload's own name never reaches the command line.
+	path := filepath.Join(dir, "upload"+strings.ToLower(filepath.Ext(name)))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return ScanResult{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	var out limitedBuffer
+	cmd := exec.CommandContext(ctx, c.Argv[0], append(c.Argv[1:], path)...)
+	cmd.Dir = dir
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + dir, "TMPDIR=" + dir}
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Run()
+	detail := firstLine(out.String())
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return ScanResult{Verdict: VerdictClean, Detail: detail}, nil
+	case ctx.Err() != nil:
+		return ScanResult{}, fmt.Errorf("scanner timed out after %v", c.Timeout)
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return ScanResult{Verdict: VerdictInfected, Detail: detail}, nil
+	}
+	return ScanResult{}, fmt.Errorf("scanner failed: %v: %s", err, detail)
+}
+
+// HTTPScanner POSTs each upload to URL as application/octet-stream (file
+// name in X-Filename) and expects 200 with {"verdict": "clean"|"infected",
+// "detail": "..."}.
+type HTTPScanner struct {
+	URL    string
+	Client *http.Client
+}
+
+func (h *HTTPScanner) Scan(ctx context.Context, name string, data []byte) (ScanResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(data))
+	if err != nil {
+		return ScanResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Filename", name)
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("scanner: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ScanResult{}, fmt.Errorf("scanner: HTTP %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Verdict Verdict `json:"verdict"`
+		Detail  string  `json:"detail"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, scanOutputLimit)).Decode(&body); err != nil {
+		return ScanResult{}, fmt.Errorf("scanner: bad response: %w", err)
+	}
+	if body.Verdict != VerdictClean && body.Verdict != VerdictInfected {
+		return ScanResult{}, fmt.Errorf("scanner: unknown verdict %q", body.Verdict)
+	}
+	return ScanResult{Verdict: body.Verdict, Detail: firstLine(body.Detail)}, nil
+}
+
+// limitedBuffer keeps the first scanOutputLimit bytes written to it and
+// silently drops the rest, so a chatty scanner can't fill memory.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := scanOutputLimit - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+func firstLine(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s
+}
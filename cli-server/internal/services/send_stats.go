@@ -0,0 +1,59 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// sendStats counts the messages users send for /api/stats: per username
+// since the relay started, and over the last minute. Announcements are not
+// counted.
+type sendStats struct {
+	mu     sync.Mutex
+	byUser map[string]int
+	slots  [60]int   // messages sent in one second; slot = Unix second % 60
+	second [60]int64 // the Unix second each slot counts
+}
+
+func newSendStats() *sendStats {
+	return &sendStats{byUser: make(map[string]int)}
+}
+
+// add counts a message from username sent at now.
+func (s *sendStats) add(username string, now time.Time) {
+	sec := now.Unix()
+	i := sec % 60
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byUser[username]++
+	if s.second[i] != sec {
+		s.second[i], s.slots[i] = sec, 0
+	}
+	s.slots[i]++
+}
+
+// lastMinute returns how many messages were sent in the 60 seconds up to
+// now.
+func (s *sendStats) lastMinute(now time.Time) int {
+	sec := now.Unix()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for i, at := range s.second {
+		if sec-at < 60 {
+			n += s.slots[i]
+		}
+	}
+	return n
+}
+
+// users returns a copy of the per-username counts.
+func (s *sendStats) users() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]int, len(s.byUser))
+	for u, n := range s.byUser {
+		out[u] = n
+	}
+	return out
+}
@@ -0,0 +1,228 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrRepeatedMessage = errors.New("the same message was sent too often — say something else")
+
+// SpamPolicy configures SpamGuard; a zero RepeatLimit or FloodScore turns
+// that check off.
+type SpamPolicy struct {
+	// RepeatLimit is how often one user may send the same text within
+	// RepeatWindow; the next copy is refused.
+	RepeatLimit  int
+	RepeatWindow time.Duration
+	// FloodScore mutes a user whose score reaches it. A message scores 1,
+	// a repeat of a recent one 2 more and one the word filter refused 3
+	// more; the score drops by 1 every second.
+	FloodScore float64
+	// MuteFor is the first mute; each further one within a day doubles,
+	// up to MaxMute.
+	MuteFor time.Duration
+	MaxMute time.Duration
+}
+
+// Flood scores, see SpamPolicy.FloodScore.
+const (
+	scoreMessage = 1
+	scoreRepeat  = 2
+	scoreBlocked = 3
+	// strikeMemory is how long a mute counts towards the next one's length.
+	strikeMemory = 24 * time.Hour
+)
+
+// MutedError is returned for messages from a user muted for flooding.
+type MutedError struct {
+	Wait time.Duration
+}
+
+func (e *MutedError) Error() string {
+	return fmt.Sprintf("muted for flooding — you can send again in %v", e.Wait.Round(time.Second))
+}
+
+// SpamGuard spots users who repeat themselves or flood, per username
+// whatever client they use, and mutes the latter for a while. Encrypted
+// messages differ on every send, so only their rate counts. Mutes are kept
+// in memory and end with a restart. It is safe for concurrent use.
+type SpamGuard struct {
+	audit *slog.Logger
+
+	mu     sync.Mutex
+	policy SpamPolicy
+	users  map[string]*spamRecord
+}
+
+type spamRecord struct {
+	score   float64
+	scored  time.Time
+	recent  []sentText // within RepeatWindow, oldest first
+	muted   time.Time  // until
+	strikes int
+	struck  time.Time
+}
+
+type sentText struct {
+	text string
+	at   time.Time
+}
+
+// maxRecent bounds how many texts one user's record remembers.
+const maxRecent = 50
+
+// NewSpamGuard returns a guard applying policy; mutes are written to audit.
+func NewSpamGuard(policy SpamPolicy, audit *slog.Logger) *SpamGuard {
+	g := &SpamGuard{
+		audit: audit,
+		users: make(map[string]*spamRecord),
+	}
+	g.SetPolicy(policy)
+	return g
+}
+
+// SetPolicy replaces the policy. Scores, mutes and strikes are kept.
+func (g *SpamGuard) SetPolicy(policy SpamPolicy) {
+	if policy.MaxMute < policy.MuteFor {
+		policy.MaxMute = policy.MuteFor
+	}
+	g.mu.Lock()
+	g.policy = policy
+	g.mu.Unlock()
+}
+
+// enabled reports whether any check is on. g.mu must be held.
+func (g *SpamGuard) enabled() bool {
+	return g.policy.RepeatLimit > 0 || g.policy.FloodScore > 0
+}
+
+// Admit scores a message from username — the first of contents stands for
+// a cross-post — blocked saying the word filter refused it. It returns a
+// *MutedError while username is muted, including by this message, and
+// ErrRepeatedMessage for a text sent too often.
+func (g *SpamGuard) Admit(username string, contents []string, blocked bool) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if !g.enabled() || len(contents) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	rec := g.users[username]
+	if rec == nil {
+		rec = &spamRecord{scored: now}
+		g.users[username] = rec
+		g.prune(now)
+	}
+	if wait := rec.muted.Sub(now); wait > 0 {
+		return &MutedError{Wait: wait}
+	}
+
+	rec.score -= now.Sub(rec.scored).Seconds()
+	if rec.score < 0 {
+		rec.score = 0
+	}
+	rec.scored = now
+	rec.score += scoreMessage
+	if blocked {
+		rec.score += scoreBlocked
+	}
+
+	var refuse error
+	if g.policy.RepeatLimit > 0 {
+		text := normalizeText(contents[0])
+		kept := rec.recent[:0]
+		seen := 0
+		for _, t := range rec.recent {
+			if now.Sub(t.at) < g.policy.RepeatWindow {
+				kept = append(kept, t)
+				if t.text == text {
+					seen++
+				}
+			}
+		}
+		rec.recent = kept
+		if seen > 0 {
+			rec.score += scoreRepeat
+		}
+		if seen >= g.policy.RepeatLimit {
+			refuse = ErrRepeatedMessage
+		} else if !blocked {
+			if len(rec.recent) == maxRecent {
+				rec.recent = rec.recent[1:]
+			}
+			rec.recent = append(rec.recent, sentText{text: text, at: now})
+		}
+	}
+
+	if g.policy.FloodScore > 0 && rec.score >= g.policy.FloodScore {
+		if now.Sub(rec.struck) > strikeMemory {
+			rec.strikes = 0
+		}
+		mute := g.policy.MuteFor
+		for i := 0; i < rec.strikes && mute < g.policy.MaxMute; i++ {
+			mute *= 2
+		}
+		if mute > g.policy.MaxMute {
+			mute = g.policy.MaxMute
+		}
+		rec.strikes++
+		rec.struck = now
+		rec.muted = now.Add(mute)
+		rec.score = 0
+		rec.recent = nil
+		g.audit.Warn("user muted for flooding", "username", username, "for", mute.String(), "strike", rec.strikes)
+		return &MutedError{Wait: mute}
+	}
+	return refuse
+}
+
+// Muted returns how many users are muted right now.
+func (g *SpamGuard) Muted() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	n := 0
+	for _, rec := range g.users {
+		if rec.muted.After(now) {
+			n++
+		}
+	}
+	return n
+}
+
+// Unmute lifts username's mute and forgets its strikes, reporting whether
+// it was muted.
+func (g *SpamGuard) Unmute(username string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	rec := g.users[username]
+	if rec == nil {
+		return false
+	}
+	delete(g.users, username)
+	return rec.muted.After(time.Now())
+}
+
+// prune drops records with nothing left to remember once there are many.
+// g.mu must be held.
+func (g *SpamGuard) prune(now time.Time) {
+	if len(g.users) <= 1000 {
+		return
+	}
+	for u, rec := range g.users {
+		idle := now.Sub(rec.scored)
+		if rec.muted.Before(now) && idle.Seconds() >= rec.score && idle >= g.policy.RepeatWindow && now.Sub(rec.struck) > strikeMemory {
+			delete(g.users, u)
+		}
+	}
+}
+
+// normalizeText makes "Buy NOW" and "buy  now " the same message.
+func normalizeText(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
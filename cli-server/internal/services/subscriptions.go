@@ -0,0 +1,288 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/storage"
+)
+
+const subscriptionsKey = "subscriptions"
+
+var (
+	ErrBadSubscription     = errors.New("a subscription needs a room and an http(s) url")
+	ErrUnknownSubscription = errors.New("no such subscription")
+)
+
+const (
+	// subscriptionAttempts is how often a delivery is tried before it is
+	// given up on; the waits between tries double from subscriptionBackoff.
+	subscriptionAttempts = 5
+	subscriptionBackoff  = 2 * time.Second
+	// subscriptionTimeout is how long one try may take.
+	subscriptionTimeout = 10 * time.Second
+	// subscriptionQueue is how many messages wait for one subscription;
+	// more are dropped while its URL is down.
+	subscriptionQueue = 256
+)
+
+// Subscription sends the messages of one room to a URL as they arrive: a
+// bridge or a logging pipeline listens there. With Keywords, only messages
+// containing one of them (any case) are sent.
+type Subscription struct {
+	ID        string    `json:"id"`
+	Room      string    `json:"room"`
+	URL       string    `json:"url"`
+	Keywords  []string  `json:"keywords,omitempty"`
+	Secret    string    `json:"secret,omitempty"` // HMAC key of the X-TTC-Signature header
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// matches reports whether msg goes to sub.
+func (sub *Subscription) matches(msg *models.Message) bool {
+	if msg.Room != sub.Room && msg.Room != "" {
+		return false
+	}
+	if len(sub.Keywords) == 0 {
+		return true
+	}
+	content := strings.ToLower(msg.Content)
+	for _, k := range sub.Keywords {
+		if strings.Contains(content, strings.ToLower(k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SubscriptionEvent is what a subscription's URL is sent for a message.
+type SubscriptionEvent struct {
+	Event        string             `json:"event"` // "message"
+	Subscription string             `json:"subscription"`
+	Room         string             `json:"room"` // "" = an announcement to every room
+	Message      models.WireMessage `json:"message"`
+}
+
+// Subscriptions holds the subscriptions, persisted in the storage backend
+// like bans, and delivers to them. Each has a queue and a goroutine of its
+// own, so a URL that is down holds up only its own messages.
+type Subscriptions struct {
+	store  storage.Store
+	client *http.Client
+	logger *slog.Logger
+
+	mu      sync.RWMutex
+	subs    []Subscription
+	workers map[string]*subscriptionWorker
+}
+
+type subscriptionWorker struct {
+	sub    Subscription
+	queue  chan SubscriptionEvent
+	cancel context.CancelFunc
+}
+
+// NewSubscriptions loads the saved subscriptions and starts delivering to
+// them.
+func NewSubscriptions(store storage.Store, logger *slog.Logger) (*Subscriptions, error) {
+	s := &Subscriptions{
+		store:   store,
+		client:  &http.Client{Timeout: subscriptionTimeout},
+		logger:  logger,
+		workers: make(map[string]*subscriptionWorker),
+	}
+	err := store.Load(subscriptionsKey, &s.subs)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("load subscriptions: %w", err)
+	}
+	for _, sub := range s.subs {
+		s.startLocked(sub)
+	}
+	return s, nil
+}
+
+// Add subscribes rawURL to room's messages and returns the subscription,
+// with the secret its deliveries are signed with.
+func (s *Subscriptions) Add(room, rawURL string, keywords []string) (Subscription, error) {
+	u, err := url.Parse(rawURL)
+	if room == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Subscription{}, ErrBadSubscription
+	}
+	var kept []string
+	for _, k := range keywords {
+		if k = strings.TrimSpace(k); k != "" {
+			kept = append(kept, k)
+		}
+	}
+	sub := Subscription{
+		ID:        "sub_" + randomHex(8),
+		Room:      room,
+		URL:       u.String(),
+		Keywords:  kept,
+		Secret:    randomHex(16),
+		CreatedAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subs := append(append([]Subscription{}, s.subs...), sub)
+	if err := s.store.Save(subscriptionsKey, subs); err != nil {
+		return Subscription{}, err
+	}
+	s.subs = subs
+	s.startLocked(sub)
+	return sub, nil
+}
+
+// Remove ends the subscription with id. Messages still waiting for it are
+// dropped.
+func (s *Subscriptions) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := make([]Subscription, 0, len(s.subs))
+	for _, sub := range s.subs {
+		if sub.ID != id {
+			kept = append(kept, sub)
+		}
+	}
+	if len(kept) == len(s.subs) {
+		return ErrUnknownSubscription
+	}
+	if err := s.store.Save(subscriptionsKey, kept); err != nil {
+		return err
+	}
+	s.subs = kept
+	if w, ok := s.workers[id]; ok {
+		w.cancel()
+		delete(s.workers, id)
+	}
+	return nil
+}
+
+// List returns every subscription, oldest first, without their secrets.
+func (s *Subscriptions) List() []Subscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	subs := append([]Subscription{}, s.subs...)
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+	return subs
+}
+
+// Publish queues msg for the subscriptions it matches. Direct messages and
+// reactions are never sent. It does not wait for the deliveries.
+func (s *Subscriptions) Publish(msg *models.Message) {
+	if msg.To != "" || msg.Type == models.TypeReaction {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, w := range s.workers {
+		if !w.sub.matches(msg) {
+			continue
+		}
+		select {
+		case w.queue <- SubscriptionEvent{Event: "message", Subscription: w.sub.ID, Room: msg.Room, Message: msg.ToWire()}:
+		default:
+			s.logger.Warn("subscription queue full, message dropped", "subscription", w.sub.ID, "message_id", msg.ID)
+		}
+	}
+}
+
+// startLocked starts delivering to sub. The caller holds s.mu.
+func (s *Subscriptions) startLocked(sub Subscription) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &subscriptionWorker{sub: sub, queue: make(chan SubscriptionEvent, subscriptionQueue), cancel: cancel}
+	s.workers[sub.ID] = w
+	go s.run(ctx, w)
+}
+
+// run delivers w's events one after the other, in order, until ctx ends.
+func (s *Subscriptions) run(ctx context.Context, w *subscriptionWorker) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-w.queue:
+			s.deliver(ctx, w.sub, ev)
+		}
+	}
+}
+
+// deliver POSTs ev to sub.URL, trying again with growing waits while the
+// URL is unreachable or answers 5xx, 408 or 429.
+func (s *Subscriptions) deliver(ctx context.Context, sub Subscription, ev SubscriptionEvent) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(sub.Secret))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	wait := subscriptionBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(ctx, sub.URL, body, signature)
+		if err == nil {
+			return
+		}
+		if !retry || attempt == subscriptionAttempts {
+			s.logger.Warn("subscription delivery failed", "subscription", sub.ID, "message_id", ev.Message.ID, "attempts", attempt, "error", err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// post makes one delivery and reports whether a failure is worth another
+// try.
+func (s *Subscriptions) post(ctx context.Context, target string, body []byte, signature string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-TTC-Signature", signature)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return false, fmt.Errorf("HTTP %d", resp.StatusCode)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("services: cannot generate random bytes: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"secure-chat-backend/internal/utils"
+)
+
+// Duration is a time.Duration that reads and writes JSON as "30s", "2m".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// TunableValues are the settings operators may change while the server
+// runs (PATCH /api/admin/config). None of them drops a long poll.
+type TunableValues struct {
+	RateLimit   float64  `json:"rate_limit"`   // messages per second per client
+	RateBurst   int      `json:"rate_burst"`   // messages a client may send at once
+	SlowMode    Duration `json:"slow_mode"`    // least time between one user's messages, 0 = off
+	PollTimeout Duration `json:"poll_timeout"` // how long a poll waits for new messages
+	Filter      bool     `json:"filter"`       // refuse messages the word filter matches
+	ReadOnly    bool     `json:"read_only"`    // refuse every new message
+	// RoomAccents maps a room to its accent color ("cyan"), which clients
+	// draw the room's header in; see /api/motd.
+	RoomAccents map[string]string `json:"room_accents"`
+}
+
+// LogValue shows the durations as "30s" rather than nanoseconds.
+func (v TunableValues) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Float64("rate_limit", v.RateLimit),
+		slog.Int("rate_burst", v.RateBurst),
+		slog.String("slow_mode", time.Duration(v.SlowMode).String()),
+		slog.String("poll_timeout", time.Duration(v.PollTimeout).String()),
+		slog.Bool("filter", v.Filter),
+		slog.Bool("read_only", v.ReadOnly),
+		slog.Any("room_accents", v.RoomAccents),
+	)
+}
+
+// TunablesPatch is a partial update; nil fields are left alone.
+type TunablesPatch struct {
+	RateLimit   *float64  `json:"rate_limit"`
+	RateBurst   *int      `json:"rate_burst"`
+	SlowMode    *Duration `json:"slow_mode"`
+	PollTimeout *Duration `json:"poll_timeout"`
+	Filter      *bool     `json:"filter"`
+	ReadOnly    *bool     `json:"read_only"`
+	// RoomAccents replaces every accent; {} clears them.
+	RoomAccents map[string]string `json:"room_accents"`
+}
+
+// Limits keep runtime changes safe: the poll timeout must stay well inside
+// the HTTP server's 60s write timeout, and a rate of zero would silence
+// everyone for good.
+const (
+	maxPollTimeout = 55 * time.Second
+	maxSlowMode    = 10 * time.Minute
+	maxAccents     = 100
+)
+
+// Tunables holds the current TunableValues. It is safe for concurrent use.
+type Tunables struct {
+	mu sync.RWMutex
+	v  TunableValues
+}
+
+// NewTunables returns Tunables starting at initial.
+func NewTunables(initial TunableValues) (*Tunables, error) {
+	if err := initial.validate(); err != nil {
+		return nil, err
+	}
+	return &Tunables{v: initial}, nil
+}
+
+// Get returns the current values.
+func (t *Tunables) Get() TunableValues {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.v
+}
+
+// Apply validates p against the current values and, if every field is
+// acceptable, applies all of it. It returns the values before and after.
+func (t *Tunables) Apply(p TunablesPatch) (before, after TunableValues, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	before, after = t.v, t.v
+	if p.RateLimit != nil {
+		after.RateLimit = *p.RateLimit
+	}
+	if p.RateBurst != nil {
+		after.RateBurst = *p.RateBurst
+	}
+	if p.SlowMode != nil {
+		after.SlowMode = *p.SlowMode
+	}
+	if p.PollTimeout != nil {
+		after.PollTimeout = *p.PollTimeout
+	}
+	if p.Filter != nil {
+		after.Filter = *p.Filter
+	}
+	if p.ReadOnly != nil {
+		after.ReadOnly = *p.ReadOnly
+	}
+	if p.RoomAccents != nil {
+		after.RoomAccents = make(map[string]string, len(p.RoomAccents))
+		for room, color := range p.RoomAccents {
+			after.RoomAccents[room] = color
+		}
+	}
+
+	if err := after.validate(); err != nil {
+		return before, before, err
+	}
+	t.v = after
+	return before, after, nil
+}
+
+func (v TunableValues) validate() error {
+	switch {
+	case v.RateLimit < 0.1 || v.RateLimit > 1000:
+		return fmt.Errorf("rate_limit must be between 0.1 and 1000 messages per second")
+	case v.RateBurst < 1 || v.RateBurst > 1000:
+		return fmt.Errorf("rate_burst must be between 1 and 1000")
+	case v.SlowMode < 0 || time.Duration(v.SlowMode) > maxSlowMode:
+		return fmt.Errorf("slow_mode must be between 0s and %v", maxSlowMode)
+	case time.Duration(v.PollTimeout) < time.Second || time.Duration(v.PollTimeout) > maxPollTimeout:
+		return fmt.Errorf("poll_timeout must be between 1s and %v", maxPollTimeout)
+	case len(v.RoomAccents) > maxAccents:
+		return fmt.Errorf("room_accents may name at most %d rooms", maxAccents)
+	}
+	for room, color := range v.RoomAccents {
+		if !utils.ValidateRoom(room) {
+			return fmt.Errorf("room_accents: invalid room name %q", room)
+		}
+		if !utils.ValidAccent(color) {
+			return fmt.Errorf("room_accents: %q is not a color for room %q (red, green, yellow, blue, magenta, cyan or white)", color, room)
+		}
+	}
+	return nil
+}
+
+// ParseRoomAccents reads accents given as "lobby=cyan,ops=red". They are
+// checked with the other tunables.
+func ParseRoomAccents(s string) (map[string]string, error) {
+	accents := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		room, color, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("room accent %q is not room=color", pair)
+		}
+		accents[strings.TrimSpace(room)] = strings.ToLower(strings.TrimSpace(color))
+	}
+	return accents, nil
+}
@@ -0,0 +1,286 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"mime"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/time/rate"
+
+	"secure-chat-backend/internal/utils"
+)
+
+var (
+	ErrUploadTooLarge  = errors.New("file is too large")
+	ErrUploadQuota     = errors.New("upload quota exceeded")
+	ErrUploadRateLimit = errors.New("too many uploads — wait a moment")
+	ErrUploadRejected  = errors.New("file rejected by the virus scanner")
+	ErrScanUnavailable = errors.New("virus scanner unavailable — try again later")
+	ErrBadUploadName   = errors.New("file name must be 1-255 printable characters")
+	ErrUploadNotFound  = errors.New("no such file")
+	ErrUploadExpired   = errors.New("file has expired")
+)
+
+// Upload is a file handed to the relay for distribution.
+type Upload struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int       `json:"size"`
+	SHA256      string    `json:"sha256"`
+	Uploader    string    `json:"uploader"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpireAt    time.Time `json:"expire_at"`
+	Data        []byte    `json:"-"`
+}
+
+// UploadPolicy is what each user may keep on the relay. It is separate
+// from the chat buffer's limits: attachments usually need to outlive the
+// messages that announce them.
+type UploadPolicy struct {
+	MaxFileSize int           // largest single upload in bytes
+	UserQuota   int64         // bytes one user may have stored at once
+	TTL         time.Duration // how long an upload stays downloadable
+}
+
+// maxUploadsKept caps how many uploads are held in memory across all
+// users; the oldest is dropped to make room.
+const maxUploadsKept = 200
+
+// maxConcurrentScans bounds how many scans run at once, so a burst of
+// uploads can't start an unbounded number of scanner processes.
+const maxConcurrentScans = 4
+
+// UploadService stores uploaded files in memory and runs every upload
+// through the configured Scanner before anyone can download it. Each
+// verdict is written to the audit log. Files that can't be scanned are
+// refused — the service fails closed.
+//
+// Every user's stored bytes count against UploadPolicy.UserQuota until the
+// upload expires.
+type UploadService struct {
+	scanner Scanner // nil = no scanning
+	policy  UploadPolicy
+	audit   *slog.Logger
+
+	scanSlots chan struct{}
+
+	mu       sync.RWMutex
+	files    map[string]*Upload
+	order    []string         // upload IDs, oldest first
+	usage    map[string]int64 // username → bytes stored or being scanned
+	limiters map[string]*rate.Limiter
+}
+
+func NewUploadService(scanner Scanner, policy UploadPolicy, audit *slog.Logger) *UploadService {
+	s := &UploadService{
+		scanner:   scanner,
+		policy:    policy,
+		audit:     audit,
+		scanSlots: make(chan struct{}, maxConcurrentScans),
+		files:     make(map[string]*Upload),
+		usage:     make(map[string]int64),
+		limiters:  make(map[string]*rate.Limiter),
+	}
+
+	go s.cleanupLoop()
+
+	return s
+}
+
+// Policy returns the limits the service enforces.
+func (s *UploadService) Policy() UploadPolicy {
+	return s.policy
+}
+
+// MaxSize is the largest accepted upload in bytes.
+func (s *UploadService) MaxSize() int {
+	return s.policy.MaxFileSize
+}
+
+// Usage returns how many bytes username currently has stored.
+func (s *UploadService) Usage(username string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.usage[username]
+}
+
+// Allow reports whether username may upload now: 5 at once, then one
+// every 6 seconds.
+func (s *UploadService) Allow(username string) bool {
+	s.mu.Lock()
+	limiter, ok := s.limiters[username]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Every(6*time.Second), 5)
+		s.limiters[username] = limiter
+	}
+	s.mu.Unlock()
+	return limiter.Allow()
+}
+
+// Store scans data and, if it is clean, keeps it for download until the
+// policy's TTL runs out.
+func (s *UploadService) Store(ctx context.Context, uploader, name, contentType string, data []byte) (*Upload, error) {
+	name, ok := cleanUploadName(name)
+	if !ok {
+		return nil, ErrBadUploadName
+	}
+	if len(data) > s.policy.MaxFileSize {
+		return nil, ErrUploadTooLarge
+	}
+	if _, _, err := mime.ParseMediaType(contentType); err != nil {
+		contentType = "application/octet-stream"
+	}
+
+	sum := sha256.Sum256(data)
+	upload := &Upload{
+		ID:          utils.GenerateUploadID(),
+		Name:        name,
+		ContentType: contentType,
+		Size:        len(data),
+		SHA256:      hex.EncodeToString(sum[:]),
+		Uploader:    uploader,
+		CreatedAt:   time.Now(),
+		Data:        data,
+	}
+
+	// Reserve the quota before scanning so concurrent uploads can't
+	// overshoot it together; give it back if the file isn't kept.
+	if !s.reserve(uploader, int64(upload.Size)) {
+		return nil, ErrUploadQuota
+	}
+	if err := s.scan(ctx, upload); err != nil {
+		s.release(uploader, int64(upload.Size))
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.order) >= maxUploadsKept {
+		s.dropOldestLocked()
+	}
+	upload.ExpireAt = time.Now().Add(s.policy.TTL)
+	s.files[upload.ID] = upload
+	s.order = append(s.order, upload.ID)
+	return upload, nil
+}
+
+func (s *UploadService) reserve(username string, size int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.usage[username]+size > s.policy.UserQuota {
+		return false
+	}
+	s.usage[username] += size
+	return true
+}
+
+func (s *UploadService) release(username string, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.releaseLocked(username, size)
+}
+
+func (s *UploadService) releaseLocked(username string, size int64) {
+	if s.usage[username] -= size; s.usage[username] <= 0 {
+		delete(s.usage, username)
+	}
+}
+
+func (s *UploadService) dropOldestLocked() {
+	upload := s.files[s.order[0]]
+	delete(s.files, upload.ID)
+	s.order = s.order[1:]
+	s.releaseLocked(upload.Uploader, int64(upload.Size))
+}
+
+// cleanupLoop drops expired uploads and frees their quota. All uploads
+// share one TTL, so they expire in the order they were stored.
+func (s *UploadService) cleanupLoop() {
+	ticker := time.NewTicker(time.Minute)
+	for range ticker.C {
+		s.mu.Lock()
+		now := time.Now()
+		for len(s.order) > 0 && !s.files[s.order[0]].ExpireAt.After(now) {
+			s.dropOldestLocked()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// scan runs the scanner on upload and records the verdict.
+func (s *UploadService) scan(ctx context.Context, upload *Upload) error {
+	attrs := []any{
+		"upload_id", upload.ID,
+		"user", upload.Uploader,
+		"name", upload.Name,
+		"size", upload.Size,
+		"sha256", upload.SHA256,
+	}
+	if s.scanner == nil {
+		s.audit.Info("upload accepted", append(attrs, "verdict", "unscanned")...)
+		return nil
+	}
+
+	select {
+	case s.scanSlots <- struct{}{}:
+		defer func() { <-s.scanSlots }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	start := time.Now()
+	result, err := s.scanner.Scan(ctx, upload.Name, upload.Data)
+	attrs = append(attrs, "scan_ms", time.Since(start).Milliseconds())
+	switch {
+	case err != nil:
+		s.audit.Warn("upload refused", append(attrs, "verdict", "error", "error", err.Error())...)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return ErrScanUnavailable
+	case result.Verdict == VerdictInfected:
+		s.audit.Warn("upload rejected", append(attrs, "verdict", result.Verdict, "detail", result.Detail)...)
+		return ErrUploadRejected
+	}
+	s.audit.Info("upload accepted", append(attrs, "verdict", result.Verdict, "detail", result.Detail)...)
+	return nil
+}
+
+// Get returns the upload with id. An upload past its TTL that the cleanup
+// loop hasn't dropped yet reports ErrUploadExpired.
+func (s *UploadService) Get(id string) (*Upload, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	upload, ok := s.files[id]
+	if !ok {
+		return nil, ErrUploadNotFound
+	}
+	if !upload.ExpireAt.After(time.Now()) {
+		return nil, ErrUploadExpired
+	}
+	return upload, nil
+}
+
+// cleanUploadName drops any directory part of name and refuses names that
+// are empty, too long or contain control characters.
+func cleanUploadName(name string) (string, bool) {
+	name = strings.TrimSpace(filepath.Base(strings.ReplaceAll(name, "\\", "/")))
+	if name == "" || name == "." || name == "/" || len(name) > 255 {
+		return "", false
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "", false
+		}
+	}
+	return name, true
+}
@@ -0,0 +1,334 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"secure-chat-backend/internal/models"
+	"secure-chat-backend/internal/storage"
+	"secure-chat-backend/internal/utils"
+)
+
+const usersKey = "users"
+
+var (
+	ErrUserExists      = errors.New("username is already registered")
+	ErrUnknownUser     = errors.New("no account with that username")
+	ErrInvalidUsername = errors.New("username must be 1-32 characters: letters, digits, '_', '-' or '.'")
+	ErrWeakPassword    = errors.New("password must be at least 8 characters")
+	ErrBadIdentityKey  = errors.New("identity_key must be a Base64 Ed25519 public key")
+	ErrBadDHKey        = errors.New("dh_key must be a Base64 X25519 public key with dh_key_sig, its signature by identity_key")
+	ErrBadSignature    = errors.New("sig must be a Base64 Ed25519 signature")
+	ErrBadForward      = errors.New("fwd needs a username in from, a valid room or none, and a time in at")
+)
+
+// dhKeyContext prefixes the X25519 key a client signs with its identity
+// key; the client signs exactly these bytes.
+const dhKeyContext = "ttc-dh-key-v1"
+
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9_.\-]{1,32}$`)
+
+type Account struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"password_hash"`
+	CreatedAt    time.Time `json:"created_at"`
+	// IdentityKey is the Base64 Ed25519 public key the user's client last
+	// published. Other clients pin the first one they see (trust on first
+	// use) and warn when it changes.
+	IdentityKey string `json:"identity_key,omitempty"`
+	// DHKey is the Base64 X25519 key other clients encrypt direct messages
+	// to; DHKeySig is IdentityKey's signature over it, so the relay cannot
+	// swap it without also swapping the pinned identity key.
+	DHKey    string `json:"dh_key,omitempty"`
+	DHKeySig string `json:"dh_key_sig,omitempty"`
+}
+
+// PublicKeys are the keys a client publishes at login.
+type PublicKeys struct {
+	IdentityKey string `json:"identity_key"`
+	DHKey       string `json:"dh_key,omitempty"`
+	DHKeySig    string `json:"dh_key_sig,omitempty"`
+}
+
+// UserKeys is one entry of the key directory.
+type UserKeys struct {
+	Username string `json:"username"`
+	PublicKeys
+}
+
+// UserService owns registered accounts. Passwords are stored as bcrypt hashes
+// in the storage backend; the plaintext never leaves Register/Login.
+type UserService struct {
+	store    storage.Store
+	mu       sync.RWMutex
+	accounts map[string]*Account
+
+	// sandbox makes Login take any password (SetSandbox).
+	sandbox bool
+}
+
+func NewUserService(store storage.Store) (*UserService, error) {
+	s := &UserService{
+		store:    store,
+		accounts: make(map[string]*Account),
+	}
+
+	var accounts []*Account
+	err := store.Load(usersKey, &accounts)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, fmt.Errorf("load accounts: %w", err)
+	}
+	for _, a := range accounts {
+		s.accounts[a.Username] = a
+	}
+	return s, nil
+}
+
+func (s *UserService) Register(username, password string) error {
+	if !validUsername.MatchString(username) {
+		return ErrInvalidUsername
+	}
+	if len(password) < 8 {
+		return ErrWeakPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.accounts[username]; exists {
+		return ErrUserExists
+	}
+	s.accounts[username] = &Account{
+		Username:     username,
+		PasswordHash: string(hash),
+		CreatedAt:    time.Now(),
+	}
+	if err := s.saveLocked(); err != nil {
+		delete(s.accounts, username)
+		return err
+	}
+	return nil
+}
+
+// SetSandbox makes Login accept any password and create the accounts it
+// doesn't know, for -sandbox. Call it before serving.
+func (s *UserService) SetSandbox(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sandbox = on
+}
+
+// Ensure creates an account without a password for username unless there
+// is one — the sandbox's accounts, which no password opens.
+func (s *UserService) Ensure(username string) error {
+	if !validUsername.MatchString(username) {
+		return ErrInvalidUsername
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.accounts[username]; exists {
+		return nil
+	}
+	s.accounts[username] = &Account{Username: username, CreatedAt: time.Now()}
+	if err := s.saveLocked(); err != nil {
+		delete(s.accounts, username)
+		return err
+	}
+	return nil
+}
+
+// Rename moves username's account — password, keys and all — to nick.
+func (s *UserService) Rename(username, nick string) error {
+	if !validUsername.MatchString(nick) {
+		return ErrInvalidUsername
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, exists := s.accounts[username]
+	if !exists {
+		return ErrUnknownUser
+	}
+	if _, taken := s.accounts[nick]; taken {
+		return ErrUserExists
+	}
+	delete(s.accounts, username)
+	account.Username = nick
+	s.accounts[nick] = account
+	if err := s.saveLocked(); err != nil {
+		delete(s.accounts, nick)
+		account.Username = username
+		s.accounts[username] = account
+		return err
+	}
+	return nil
+}
+
+func (s *UserService) Login(username, password string) error {
+	if s.sandbox {
+		return s.Ensure(username)
+	}
+
+	s.mu.RLock()
+	account, exists := s.accounts[username]
+	s.mu.RUnlock()
+
+	if !exists {
+		return ErrUnknownUser
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)); err != nil {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// ValidateIdentityKey checks that key is a Base64 Ed25519 public key.
+func ValidateIdentityKey(key string) error {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return ErrBadIdentityKey
+	}
+	return nil
+}
+
+// ValidateSignature checks that sig has the shape of a Base64 Ed25519
+// signature. Whether it is valid is for the receiving clients to check:
+// they hold the pinned key, the relay's copy may be the one that was swapped.
+func ValidateSignature(sig string) error {
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil || len(raw) != ed25519.SignatureSize {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// ValidateForward checks the provenance of a forwarded message for shape:
+// an author that could be a username, a valid room or none, and a time.
+// Whether it is true only the forwarder knows.
+func ValidateForward(fwd *models.Forward) error {
+	if !validUsername.MatchString(fwd.From) || (fwd.Room != "" && !utils.ValidateRoom(fwd.Room)) || fwd.At.IsZero() {
+		return ErrBadForward
+	}
+	return nil
+}
+
+// ValidatePublicKeys checks the identity key and, if one is given, that the
+// X25519 key is signed by it. Clients from before direct messages publish
+// no X25519 key.
+func ValidatePublicKeys(keys PublicKeys) error {
+	if err := ValidateIdentityKey(keys.IdentityKey); err != nil {
+		return err
+	}
+	if keys.DHKey == "" && keys.DHKeySig == "" {
+		return nil
+	}
+	identity, _ := base64.StdEncoding.DecodeString(keys.IdentityKey)
+	dh, err := base64.StdEncoding.DecodeString(keys.DHKey)
+	if err != nil || len(dh) != 32 {
+		return ErrBadDHKey
+	}
+	sig, err := base64.StdEncoding.DecodeString(keys.DHKeySig)
+	if err != nil || !ed25519.Verify(identity, append([]byte(dhKeyContext), dh...), sig) {
+		return ErrBadDHKey
+	}
+	return nil
+}
+
+// SetKeys publishes keys as username's, replacing the previous ones (a
+// reinstalled client comes with new keys). An X25519 key left out is
+// cleared, since the old one was vouched for by the old identity.
+func (s *UserService) SetKeys(username string, keys PublicKeys) error {
+	if err := ValidatePublicKeys(keys); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, exists := s.accounts[username]
+	if !exists {
+		return ErrUnknownUser
+	}
+	previous := account.keys()
+	if previous == keys {
+		return nil
+	}
+	account.setKeys(keys)
+	if err := s.saveLocked(); err != nil {
+		account.setKeys(previous)
+		return err
+	}
+	return nil
+}
+
+// Keys returns username's published keys; IdentityKey is "" if the user
+// never published any. ok is false for unknown users.
+func (s *UserService) Keys(username string) (keys PublicKeys, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	account, exists := s.accounts[username]
+	if !exists {
+		return PublicKeys{}, false
+	}
+	return account.keys(), true
+}
+
+// Directory lists the keys of every user who published some, sorted by
+// username.
+func (s *UserService) Directory() []UserKeys {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	dir := make([]UserKeys, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		if a.IdentityKey != "" {
+			dir = append(dir, UserKeys{Username: a.Username, PublicKeys: a.keys()})
+		}
+	}
+	sort.Slice(dir, func(i, j int) bool { return dir[i].Username < dir[j].Username })
+	return dir
+}
+
+func (a *Account) keys() PublicKeys {
+	return PublicKeys{IdentityKey: a.IdentityKey, DHKey: a.DHKey, DHKeySig: a.DHKeySig}
+}
+
+func (a *Account) setKeys(k PublicKeys) {
+	a.IdentityKey, a.DHKey, a.DHKeySig = k.IdentityKey, k.DHKey, k.DHKeySig
+}
+
+func (s *UserService) Exists(username string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, exists := s.accounts[username]
+	return exists
+}
+
+func (s *UserService) Count() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.accounts)
+}
+
+func (s *UserService) saveLocked() error {
+	accounts := make([]*Account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		accounts = append(accounts, a)
+	}
+	return s.store.Save(usersKey, accounts)
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// WordFilter matches messages containing a banned word, case-insensitively
+// and on word boundaries ("ass" does not match "class"). Encrypted messages
+// are ciphertext to the relay and never match. It is safe for concurrent
+// use.
+type WordFilter struct {
+	mu    sync.RWMutex
+	words map[string]bool
+}
+
+// NewWordFilter builds a filter from words; blank entries are ignored.
+func NewWordFilter(words []string) *WordFilter {
+	f := &WordFilter{}
+	f.Set(words)
+	return f
+}
+
+// Set replaces the filter's words; blank entries are ignored.
+func (f *WordFilter) Set(words []string) {
+	set := make(map[string]bool)
+	for _, w := range words {
+		if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+			set[w] = true
+		}
+	}
+	f.mu.Lock()
+	f.words = set
+	f.mu.Unlock()
+}
+
+// Len returns how many words the filter holds.
+func (f *WordFilter) Len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.words)
+}
+
+// Match reports whether content contains a banned word.
+func (f *WordFilter) Match(content string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if len(f.words) == 0 {
+		return false
+	}
+	fields := strings.FieldsFunc(strings.ToLower(content), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, w := range fields {
+		if f.words[w] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,330 @@
+// Package tracing records spans of the relay's send and poll handlers and
+// exports them to an OpenTelemetry collector over OTLP/HTTP, in its JSON
+// encoding, so a message can be followed from one client's send to the
+// polls that deliver it. A W3C traceparent header makes the span part of
+// the caller's trace. Like package metrics, it writes the wire format
+// itself instead of pulling in the SDK.
+package tracing
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"secure-chat-backend/internal/logging"
+)
+
+const (
+	// batchSize is how many spans one export carries at most; a full
+	// batch is sent without waiting for flushEvery.
+	batchSize  = 256
+	flushEvery = 5 * time.Second
+	// maxQueued is how many spans wait while the collector is slow or
+	// down; more are dropped.
+	maxQueued     = 4096
+	exportTimeout = 10 * time.Second
+)
+
+// Exporter batches finished spans and posts them to a collector. A nil
+// *Exporter traces nothing.
+type Exporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	queue   []span
+	dropped int
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+type span struct {
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   [8]byte // zero for a span that starts its trace
+	name       string
+	start, end time.Time
+	attrs      []slog.Attr
+	failed     bool
+}
+
+// New starts exporting to endpoint, the collector's OTLP/HTTP address such
+// as http://collector:4318; "/v1/traces" is added unless the path ends in
+// it. An empty endpoint returns nil: nothing is traced.
+func New(endpoint, service string, logger *slog.Logger) (*Exporter, error) {
+	if endpoint == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("otlp endpoint %q: want an http(s) URL", endpoint)
+	}
+	if !strings.HasSuffix(u.Path, "/v1/traces") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/traces"
+	}
+	e := &Exporter{
+		endpoint: u.String(),
+		service:  service,
+		client:   &http.Client{Timeout: exportTimeout},
+		logger:   logger,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// Endpoint returns where spans are posted.
+func (e *Exporter) Endpoint() string {
+	return e.endpoint
+}
+
+// Close exports the spans still queued and stops.
+func (e *Exporter) Close() {
+	if e == nil {
+		return
+	}
+	close(e.stop)
+	<-e.done
+}
+
+// Wrap records a server span called name for every request to next. It
+// carries the method, the status code and what the handler added with
+// logging.AddAttrs — client ID, room, message ID — and the request's log
+// lines carry its trace_id.
+func (e *Exporter) Wrap(name string, next http.HandlerFunc) http.HandlerFunc {
+	if e == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		s := span{name: name, start: time.Now()}
+		if traceID, parentID, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			s.traceID, s.parentID = traceID, parentID
+		} else {
+			rand.Read(s.traceID[:])
+		}
+		rand.Read(s.spanID[:])
+		logging.AddAttrs(r.Context(), slog.String("trace_id", hex.EncodeToString(s.traceID[:])))
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		s.end = time.Now()
+		s.failed = rec.status >= 500
+		s.attrs = []slog.Attr{
+			slog.String("http.request.method", r.Method),
+			slog.String("url.path", r.URL.Path),
+			slog.Int("http.response.status_code", rec.status),
+		}
+		for _, a := range logging.Attrs(r.Context()) {
+			if a.Key != "trace_id" {
+				s.attrs = append(s.attrs, a)
+			}
+		}
+		e.enqueue(s)
+	}
+}
+
+// parseTraceparent reads a W3C traceparent header,
+// "00-<trace ID>-<parent span ID>-<flags>".
+func parseTraceparent(header string) (traceID [16]byte, parentID [8]byte, ok bool) {
+	parts := strings.Split(header, "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return traceID, parentID, false
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return traceID, parentID, false
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil {
+		return traceID, parentID, false
+	}
+	return traceID, parentID, traceID != [16]byte{} && parentID != [8]byte{}
+}
+
+func (e *Exporter) enqueue(s span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.queue) >= maxQueued {
+		e.dropped++
+		return
+	}
+	e.queue = append(e.queue, s)
+	if len(e.queue) >= batchSize {
+		select {
+		case e.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (e *Exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(flushEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stop:
+			e.flush()
+			return
+		case <-ticker.C:
+		case <-e.wake:
+		}
+		e.flush()
+	}
+}
+
+// flush exports every queued span, batchSize at a time. Spans a failed
+// export carried are lost; tracing is best effort.
+func (e *Exporter) flush() {
+	e.mu.Lock()
+	queue, dropped := e.queue, e.dropped
+	e.queue, e.dropped = nil, 0
+	e.mu.Unlock()
+
+	if dropped > 0 {
+		e.logger.Warn("trace queue full, spans dropped", "spans", dropped)
+	}
+	for len(queue) > 0 {
+		n := min(len(queue), batchSize)
+		if err := e.export(queue[:n]); err != nil {
+			e.logger.Warn("trace export failed", "endpoint", e.endpoint, "spans", n, "error", err)
+		}
+		queue = queue[n:]
+	}
+}
+
+// OTLP/JSON, the parts of ExportTraceServiceRequest the relay fills in.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope struct {
+			Name string `json:"name"`
+		} `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpSpan struct {
+		TraceID      string     `json:"traceId"`
+		SpanID       string     `json:"spanId"`
+		ParentSpanID string     `json:"parentSpanId,omitempty"`
+		Name         string     `json:"name"`
+		Kind         int        `json:"kind"`
+		Start        string     `json:"startTimeUnixNano"`
+		End          string     `json:"endTimeUnixNano"`
+		Attributes   []otlpAttr `json:"attributes,omitempty"`
+		Status       otlpStatus `json:"status"`
+	}
+	otlpStatus struct {
+		Code int `json:"code,omitempty"`
+	}
+	otlpAttr struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+)
+
+const (
+	spanKindServer  = 2
+	statusCodeError = 2
+)
+
+func (e *Exporter) export(spans []span) error {
+	scope := otlpScopeSpans{}
+	scope.Scope.Name = "secure-chat-backend"
+	for _, s := range spans {
+		out := otlpSpan{
+			TraceID: hex.EncodeToString(s.traceID[:]),
+			SpanID:  hex.EncodeToString(s.spanID[:]),
+			Name:    s.name,
+			Kind:    spanKindServer,
+			Start:   strconv.FormatInt(s.start.UnixNano(), 10),
+			End:     strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != [8]byte{} {
+			out.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for _, a := range s.attrs {
+			out.Attributes = append(out.Attributes, otlpAttribute(a))
+		}
+		if s.failed {
+			out.Status.Code = statusCodeError
+		}
+		scope.Spans = append(scope.Spans, out)
+	}
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttr{otlpAttribute(slog.String("service.name", e.service))}},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}})
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// otlpAttribute converts a as an OTLP AnyValue wants it: 64-bit integers
+// are strings in OTLP/JSON.
+func otlpAttribute(a slog.Attr) otlpAttr {
+	v := a.Value.Resolve()
+	var value map[string]any
+	switch v.Kind() {
+	case slog.KindInt64:
+		value = map[string]any{"intValue": strconv.FormatInt(v.Int64(), 10)}
+	case slog.KindUint64:
+		value = map[string]any{"intValue": strconv.FormatUint(v.Uint64(), 10)}
+	case slog.KindBool:
+		value = map[string]any{"boolValue": v.Bool()}
+	case slog.KindFloat64:
+		value = map[string]any{"doubleValue": v.Float64()}
+	default:
+		value = map[string]any{"stringValue": v.String()}
+	}
+	return otlpAttr{Key: a.Key, Value: value}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.status = code
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
@@ -1,40 +1,47 @@
-package utils
-
-import (
-	"strings"
-)
-
-var validColors = map[string]bool{
-	"[red]":     true,
-	"[green]":   true,
-	"[yellow]":  true,
-	"[blue]":    true,
-	"[magenta]": true,
-	"[cyan]":    true,
-	"[white]":   true,
-	"[black]":   true,
-	"":          true,
-}
-
-func IsValidColor(color string) bool {
-	return validColors[color]
-}
-
-func NormalizeColor(color string) string {
-	if color == "" {
-		return "[white]"
-	}
-
-	if !strings.HasPrefix(color, "[") {
-		color = "[" + color
-	}
-	if !strings.HasSuffix(color, "]") {
-		color = color + "]"
-	}
-
-	if validColors[color] {
-		return color
-	}
-
-	return "[white]"
-}
+package utils
+
+import (
+	"strings"
+)
+
+var validColors = map[string]bool{
+	"[red]":     true,
+	"[green]":   true,
+	"[yellow]":  true,
+	"[blue]":    true,
+	"[magenta]": true,
+	"[cyan]":    true,
+	"[white]":   true,
+	"[black]":   true,
+	"":          true,
+}
+
+func IsValidColor(color string) bool {
+	return validColors[color]
+}
+
+func NormalizeColor(color string) string {
+	if color == "" {
+		return "[white]"
+	}
+
+	if !strings.HasPrefix(color, "[") {
+		color = "[" + color
+	}
+	if !strings.HasSuffix(color, "]") {
+		color = color + "]"
+	}
+
+	if validColors[color] {
+		return color
+	}
+
+	return "[white]"
+}
+
+// ValidAccent reports whether name, e.g. "cyan", can be a room's accent
+// color: any message color except black, which vanishes on the client's
+// black background.
+func ValidAccent(name string) bool {
+	return name != "" && name != "black" && validColors["["+name+"]"]
+}
@@ -1,14 +1,50 @@
-package utils
-
-import (
-	"fmt"
-	"sync/atomic"
-	"time"
-)
-
-var counter uint64
-
-func GenerateID() string {
-	newCounter := atomic.AddUint64(&counter, 1)
-	return fmt.Sprintf("msg_%d_%d", time.Now().UnixNano(), newCounter)
-}
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var counter uint64
+
+func GenerateID() string {
+	newCounter := atomic.AddUint64(&counter, 1)
+	return fmt.Sprintf("msg_%d_%d", time.Now().UnixNano(), newCounter)
+}
+
+// GenerateRequestID returns a short random ID used to tag a request's log
+// lines, e.g. "req_9f86d081e4b1".
+func GenerateRequestID() string {
+	var b [6]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return GenerateID()
+	}
+	return "req_" + hex.EncodeToString(b[:])
+}
+
+// GenerateUploadID returns an unguessable ID for an uploaded file, e.g.
+// "file_3f9a0c2e71d84b5a9e06c1d7". Knowing the ID is enough to download
+// the file, so it must not be predictable like message IDs.
+func GenerateUploadID() string {
+	var b [12]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("utils: cannot generate upload id: " + err.Error())
+	}
+	return "file_" + hex.EncodeToString(b[:])
+}
+
+// GeneratePasteID returns a short random ID for a paste, e.g. "k3vq9xwa",
+// short enough to type after /paste view. Reading a paste needs a session
+// as well as the ID.
+func GeneratePasteID() string {
+	var b [5]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("utils: cannot generate paste id: " + err.Error())
+	}
+	return strings.ToLower(base32.StdEncoding.EncodeToString(b[:]))
+}
@@ -1,16 +1,27 @@
-package utils
-
-import "strings"
-
-func ValidateMessage(sender, content string) bool {
-	if strings.TrimSpace(sender) == "" {
-		return false
-	}
-	if strings.TrimSpace(content) == "" {
-		return false
-	}
-	if len(content) > 10000 {
-		return false
-	}
-	return true
-}
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+var roomPattern = regexp.MustCompile(`^[a-z0-9_\-]{1,32}$`)
+
+func ValidateMessage(sender, content string) bool {
+	if strings.TrimSpace(sender) == "" {
+		return false
+	}
+	if strings.TrimSpace(content) == "" {
+		return false
+	}
+	if len(content) > 10000 {
+		return false
+	}
+	return true
+}
+
+// ValidateRoom reports whether room is an acceptable room name: lowercase
+// letters, digits, '_' and '-', at most 32 characters.
+func ValidateRoom(room string) bool {
+	return roomPattern.MatchString(room)
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+)
+
+// clientAgent is how TTC clients start their User-Agent, e.g.
+// "ttc-client/v1.1.0 (3f2c9ab; 2026-10-01)".
+const clientAgent = "ttc-client/"
+
+// ClientVersion returns the version a TTC client's User-Agent names; ok is
+// false for any other User-Agent, including clients from before they sent
+// one.
+func ClientVersion(userAgent string) (string, bool) {
+	if !strings.HasPrefix(userAgent, clientAgent) {
+		return "", false
+	}
+	v, _, _ := strings.Cut(strings.TrimPrefix(userAgent, clientAgent), " ")
+	if !ValidVersion(v) {
+		return "", false
+	}
+	return v, true
+}
+
+// ValidVersion reports whether v looks like "v1.2.3" or "v1.2.0-rc1"; the
+// "v" and trailing parts are optional.
+func ValidVersion(v string) bool {
+	_, ok := parseVersion(v)
+	return ok
+}
+
+// CompareVersions orders two valid versions: negative if a is older than b,
+// 0 for the same release, positive if a is newer. Missing parts count as 0
+// and a pre-release sorts before its release. Invalid versions compare as 0.
+func CompareVersions(a, b string) int {
+	va, okA := parseVersion(a)
+	vb, okB := parseVersion(b)
+	if !okA || !okB {
+		return 0
+	}
+	for i := range va.parts {
+		if va.parts[i] != vb.parts[i] {
+			if va.parts[i] < vb.parts[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case va.pre == vb.pre:
+		return 0
+	case va.pre == "":
+		return 1
+	case vb.pre == "":
+		return -1
+	}
+	return strings.Compare(va.pre, vb.pre)
+}
+
+type version struct {
+	parts [3]int
+	pre   string
+}
+
+func parseVersion(v string) (version, bool) {
+	var p version
+	v = strings.TrimPrefix(v, "v")
+	v, p.pre, _ = strings.Cut(v, "-")
+	fields := strings.Split(v, ".")
+	if len(fields) > len(p.parts) {
+		return p, false
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return p, false
+		}
+		p.parts[i] = n
+	}
+	return p, true
+}